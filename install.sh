@@ -74,7 +74,17 @@ mkdir -p "$CLAUDE_DIR/scripts"
 # Copy scripts
 cp "$SCRIPT_DIR/scripts/neo4j-context.sh" "$CLAUDE_DIR/scripts/"
 cp "$SCRIPT_DIR/scripts/populate-doc-graph.py" "$CLAUDE_DIR/scripts/"
-cp "$SCRIPT_DIR/scripts/populate-code-graph.go" "$CLAUDE_DIR/scripts/" 2>/dev/null || true
+
+# Build the code indexer from the module, which needs Go
+if command -v go &>/dev/null; then
+    if (cd "$SCRIPT_DIR" && go build -o "$CLAUDE_DIR/scripts/populate-code-graph" ./scripts); then
+        echo "  ✓ Built populate-code-graph"
+    else
+        echo "  ⚠ Could not build populate-code-graph, skipping it. Run 'go build ./scripts' in $SCRIPT_DIR to see why."
+    fi
+else
+    echo "  ⚠ Go not found, skipping populate-code-graph. Install Go 1.25+ and re-run to index code."
+fi
 
 # Make executable
 chmod +x "$CLAUDE_DIR/scripts/neo4j-context.sh"
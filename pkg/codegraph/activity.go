@@ -0,0 +1,135 @@
+package codegraph
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ActivityTag tags the memories recording activity, alongside the action
+const ActivityTag = "activity"
+
+// ActivityImportance is the importance of activity memories, low so ranked
+// recall puts notes first
+const ActivityImportance = 0.1
+
+// HookEvent is the part of the JSON an agent's PostToolUse hook receives
+// on stdin that says which file a tool read or edited, as Claude Code sends
+// it
+type HookEvent struct {
+	SessionID     string    `json:"session_id"`
+	Cwd           string    `json:"cwd"`
+	HookEventName string    `json:"hook_event_name"`
+	ToolName      string    `json:"tool_name"`
+	ToolInput     HookInput `json:"tool_input"`
+}
+
+// HookInput is the input of the tool a HookEvent reports: the file and, for
+// Read, the lines read, or for Edit and MultiEdit, the text written
+type HookInput struct {
+	FilePath  string `json:"file_path"`
+	Offset    int    `json:"offset"`
+	Limit     int    `json:"limit"`
+	NewString string `json:"new_string"`
+	Edits     []struct {
+		NewString string `json:"new_string"`
+	} `json:"edits"`
+}
+
+// Activity is a file read or edited during a session, with the keys of the
+// functions and methods in the lines read or edited
+type Activity struct {
+	Action    string   `json:"action"` // read or edit
+	File      string   `json:"file"`
+	Functions []string `json:"functions,omitempty"`
+}
+
+// HookActivity returns the activity event records in the project at root,
+// given the content of the file after the tool ran. ok is false for tools
+// other than Read, Edit, MultiEdit and Write, and for files outside root.
+// Functions are only listed for a Read of some lines and for edits, and
+// only for Go source files.
+func HookActivity(event HookEvent, root string, src []byte) (a Activity, ok bool) {
+	switch event.ToolName {
+	case "Read":
+		a.Action = "read"
+	case "Edit", "MultiEdit", "Write":
+		a.Action = "edit"
+	default:
+		return Activity{}, false
+	}
+	path := event.ToolInput.FilePath
+	if path == "" {
+		return Activity{}, false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(event.Cwd, path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return Activity{}, false
+	}
+	a.File = filepath.ToSlash(rel)
+
+	var spans [][2]int
+	switch event.ToolName {
+	case "Read":
+		if in := event.ToolInput; in.Offset > 0 || in.Limit > 0 {
+			start := max(in.Offset, 1)
+			end := start + in.Limit - 1
+			if in.Limit <= 0 {
+				end = bytes.Count(src, []byte("\n")) + 1
+			}
+			spans = append(spans, [2]int{start, end})
+		}
+	case "Edit":
+		spans = appendSpan(spans, src, event.ToolInput.NewString)
+	case "MultiEdit":
+		for _, edit := range event.ToolInput.Edits {
+			spans = appendSpan(spans, src, edit.NewString)
+		}
+	}
+	if len(spans) == 0 || !isSourceFile(path) {
+		return a, true
+	}
+	graph, err := Parser{Root: root}.ParseFile(path, src)
+	if err != nil {
+		return a, true
+	}
+	for _, fn := range graph.Functions {
+		if slices.ContainsFunc(spans, func(s [2]int) bool { return fn.LineStart <= s[1] && s[0] <= fn.LineEnd }) {
+			a.Functions = append(a.Functions, FunctionNode{Name: fn.Name, File: a.File, Receiver: fn.Receiver}.Key())
+		}
+	}
+	return a, true
+}
+
+// appendSpan appends the lines text spans where it first appears in src
+func appendSpan(spans [][2]int, src []byte, text string) [][2]int {
+	if text == "" {
+		return spans
+	}
+	i := bytes.Index(src, []byte(text))
+	if i < 0 {
+		return spans
+	}
+	start := bytes.Count(src[:i], []byte("\n")) + 1
+	return append(spans, [2]int{start, start + strings.Count(strings.TrimSuffix(text, "\n"), "\n")})
+}
+
+// Memory returns the memory recording a: tagged activity and its action,
+// with a low importance, about its file and functions
+func (a Activity) Memory() Memory {
+	text := a.Action + " " + a.File
+	about := []string{"File:" + a.File}
+	if len(a.Functions) > 0 {
+		names := make([]string, len(a.Functions))
+		for i, key := range a.Functions {
+			names[i] = key[strings.LastIndex(key, ":")+1:]
+		}
+		text += ": " + strings.Join(names, ", ")
+		about = append(about, a.Functions...)
+	}
+	return Memory{Text: text, Tags: []string{ActivityTag, a.Action}, About: about, Importance: ActivityImportance}
+}
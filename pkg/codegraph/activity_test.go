@@ -0,0 +1,55 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHookActivity(t *testing.T) {
+	src := []byte("package store\n\nfunc Get() {}\n\nfunc (s *Store) Put(key string) {\n\ts.keys = append(s.keys, key)\n}\n\nfunc helper() {}\n")
+	event := func(tool string, input HookInput) HookEvent {
+		return HookEvent{Cwd: "/src/app", HookEventName: "PostToolUse", ToolName: tool, ToolInput: input}
+	}
+	var multi HookInput
+	multi.FilePath = "store/store.go"
+	multi.Edits = append(multi.Edits, struct {
+		NewString string `json:"new_string"`
+	}{"func helper() {}"})
+	tests := []struct {
+		name  string
+		event HookEvent
+		want  Activity
+		ok    bool
+	}{
+		{"whole read", event("Read", HookInput{FilePath: "/src/app/store/store.go"}), Activity{Action: "read", File: "store/store.go"}, true},
+		{"read of some lines", event("Read", HookInput{FilePath: "/src/app/store/store.go", Offset: 2, Limit: 4}),
+			Activity{Action: "read", File: "store/store.go", Functions: []string{"Function:store/store.go:Get", "Function:store/store.go:*Store.Put"}}, true},
+		{"edit", event("Edit", HookInput{FilePath: "/src/app/store/store.go", NewString: "\ts.keys = append(s.keys, key)\n"}),
+			Activity{Action: "edit", File: "store/store.go", Functions: []string{"Function:store/store.go:*Store.Put"}}, true},
+		{"relative multi-edit", event("MultiEdit", multi),
+			Activity{Action: "edit", File: "store/store.go", Functions: []string{"Function:store/store.go:helper"}}, true},
+		{"write", event("Write", HookInput{FilePath: "/src/app/store/store.go"}), Activity{Action: "edit", File: "store/store.go"}, true},
+		{"edit of text no longer there", event("Edit", HookInput{FilePath: "/src/app/README.md", NewString: "gone"}), Activity{Action: "edit", File: "README.md"}, true},
+		{"outside the project", event("Read", HookInput{FilePath: "/etc/hosts"}), Activity{}, false},
+		{"other tool", event("Bash", HookInput{}), Activity{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := HookActivity(tt.event, "/src/app", src)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: HookActivity = %+v, %v, want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestActivityMemory(t *testing.T) {
+	got := Activity{Action: "edit", File: "store/store.go", Functions: []string{"Function:store/store.go:*Store.Put"}}.Memory()
+	want := Memory{
+		Text:       "edit store/store.go: *Store.Put",
+		Tags:       []string{ActivityTag, "edit"},
+		About:      []string{"File:store/store.go", "Function:store/store.go:*Store.Put"},
+		Importance: ActivityImportance,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Memory = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+package codegraph
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// AGEWriter writes into an Apache AGE graph in PostgreSQL. AGE vertices
+// carry a single label, so the project is kept in a property as in the
+// other embedded backends, and AGE has no temporal type, so timestamps are
+// stored as RFC 3339 strings.
+type AGEWriter struct {
+	db    *sql.DB
+	conn  *sql.Conn
+	graph string
+
+	StatementTimeout time.Duration
+}
+
+// OpenAGE connects to PostgreSQL at dsn and creates graph if it is missing
+func OpenAGE(ctx context.Context, dsn, graph string) (*AGEWriter, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// AGE must be loaded per session, so the backend holds one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to PostgreSQL: %w", err)
+	}
+	b := &AGEWriter{db: db, conn: conn, graph: graph}
+
+	setup := []string{
+		`CREATE EXTENSION IF NOT EXISTS age`,
+		`LOAD 'age'`,
+		`SET search_path = ag_catalog, "$user", public`,
+	}
+	for _, stmt := range setup {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			b.Close(ctx)
+			return nil, fmt.Errorf("loading AGE: %w", err)
+		}
+	}
+
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM ag_catalog.ag_graph WHERE name = $1)`, graph).Scan(&exists); err != nil {
+		b.Close(ctx)
+		return nil, err
+	}
+	if !exists {
+		if _, err := conn.ExecContext(ctx, `SELECT create_graph($1)`, graph); err != nil {
+			b.Close(ctx)
+			return nil, fmt.Errorf("creating graph %s: %w", graph, err)
+		}
+	}
+
+	slog.Info("connected to PostgreSQL", "graph", graph)
+	return b, nil
+}
+
+// cypherSQL wraps an openCypher query for AGE's cypher() function, passing
+// params as the agtype map argument
+func (b *AGEWriter) cypherSQL(query, columns string) string {
+	return fmt.Sprintf("SELECT * FROM cypher('%s', $cg$%s$cg$, $1) AS (%s)",
+		strings.ReplaceAll(b.graph, "'", "''"), query, columns)
+}
+
+func (b *AGEWriter) exec(ctx context.Context, tx *sql.Tx, query string, params map[string]any) error {
+	args, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if b.StatementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.StatementTimeout)
+		defer cancel()
+	}
+	_, err = tx.ExecContext(ctx, b.cypherSQL(query, "v agtype"), string(args))
+	return err
+}
+
+func (b *AGEWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	tx, err := b.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := run.StartedAt.Format(time.RFC3339Nano)
+
+	slog.Info("creating graph nodes", "project", project)
+	slog.Info("clearing existing nodes", "project", project)
+	if err := b.exec(ctx, tx, `MATCH (n) WHERE n.project = $project DETACH DELETE n`,
+		map[string]any{"project": project}); err != nil {
+		return fmt.Errorf("clearing nodes: %w", err)
+	}
+
+	nodes := graph.Nodes()
+	rels := graph.Relationships()
+	p := StartProgress("writing", "rows", len(nodes)+len(rels))
+	defer p.Finish()
+
+	slog.Info("creating nodes", "count", len(nodes))
+	labels := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		if _, ok := labels[node.Key]; ok {
+			continue
+		}
+		labels[node.Key] = node.Label
+
+		names := make([]string, 0, len(node.Props))
+		for name := range node.Props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		params := map[string]any{
+			"id":      project + ":" + node.Key,
+			"project": project,
+			"now":     now,
+			"runId":   run.ID,
+		}
+		assignments := []string{"id: $id", "project: $project", "createdAt: $now", "updatedAt: $now", "runId: $runId"}
+		for _, name := range names {
+			params[name] = node.Props[name]
+			assignments = append(assignments, name+": $"+name)
+		}
+
+		query := fmt.Sprintf(`CREATE (n:%s {%s})`, node.Label, strings.Join(assignments, ", "))
+		if err := b.exec(ctx, tx, query, params); err != nil {
+			return fmt.Errorf("creating node %s: %w", node.Key, err)
+		}
+		p.Add(1)
+	}
+
+	// Index the id lookups used to connect relationships
+	for _, label := range NodeLabels {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM ag_catalog.ag_label l JOIN ag_catalog.ag_graph g ON l.graph = g.graphid
+			WHERE g.name = $1 AND l.name = $2)`, b.graph, label).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			index := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q.%q USING gin (properties)`,
+				strings.ToLower(label)+"_properties", b.graph, label)
+			if _, err := tx.ExecContext(ctx, index); err != nil {
+				return fmt.Errorf("indexing %s: %w", label, err)
+			}
+		}
+	}
+
+	slog.Info("creating relationships", "count", len(rels))
+	for _, rel := range rels {
+		query := fmt.Sprintf(`
+			MATCH (a:%s {id: $from}), (b:%s {id: $to})
+			CREATE (a)-[:%s {createdAt: $now, updatedAt: $now, runId: $runId}]->(b)
+		`, labels[rel.From], labels[rel.To], rel.Type)
+		params := map[string]any{
+			"from":  project + ":" + rel.From,
+			"to":    project + ":" + rel.To,
+			"now":   now,
+			"runId": run.ID,
+		}
+		if err := b.exec(ctx, tx, query, params); err != nil {
+			return fmt.Errorf("creating relationship %s: %w", rel.Key(), err)
+		}
+		p.Add(1)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Print summary
+	args, _ := json.Marshal(map[string]any{"project": project})
+	rows, err := b.conn.QueryContext(ctx, b.cypherSQL(`
+		MATCH (n) WHERE n.project = $project
+		RETURN label(n), count(*)
+	`, "label agtype, count agtype"), string(args))
+	if err != nil {
+		return fmt.Errorf("getting summary: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var label, count string
+		if err := rows.Scan(&label, &count); err != nil {
+			return err
+		}
+		slog.Info("graph summary", "label", strings.Trim(label, `"`), "count", count)
+	}
+	return rows.Err()
+}
+
+func (b *AGEWriter) Close(ctx context.Context) error {
+	b.conn.Close()
+	return b.db.Close()
+}
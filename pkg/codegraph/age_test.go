@@ -0,0 +1,50 @@
+package codegraph
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestAGECypherSQL(t *testing.T) {
+	b := &AGEWriter{graph: "o'graph"}
+	got := b.cypherSQL("MATCH (n) RETURN n", "n agtype")
+	want := "SELECT * FROM cypher('o''graph', $cg$MATCH (n) RETURN n$cg$, $1) AS (n agtype)"
+	if got != want {
+		t.Errorf("cypherSQL = %s, want %s", got, want)
+	}
+}
+
+// TestAGEBackend writes to the PostgreSQL database with the AGE extension
+// named by AGE_TEST_DSN
+func TestAGEWriter(t *testing.T) {
+	dsn := os.Getenv("AGE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("AGE_TEST_DSN not set")
+	}
+	ctx := context.Background()
+	backend, err := OpenAGE(ctx, dsn, "codegraph_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	for range 2 {
+		if err := backend.Write(ctx, "App", graph, NewRunInfo()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args, _ := json.Marshal(map[string]any{"project": "App"})
+	var count string
+	if err := backend.conn.QueryRowContext(ctx, backend.cypherSQL(
+		`MATCH (n) WHERE n.project = $project RETURN count(*)`, "count agtype"), string(args)).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(len(graph.Nodes())); count != want {
+		t.Errorf("%s App nodes after writing twice, want %s", count, want)
+	}
+}
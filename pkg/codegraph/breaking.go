@@ -0,0 +1,207 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// APIChange is a change to the exported API of a package that can break
+// the code importing it. Before and After are what the symbol was and has
+// become, as Go declarations without parameter names.
+type APIChange struct {
+	Kind    string `json:"kind"`
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+	Message string `json:"message"`
+}
+
+// The kinds of change BreakingChanges reports
+const (
+	// RemovedSymbol is an exported function, method or type that is gone,
+	// or no longer exported
+	RemovedSymbol = "removed"
+	// ChangedSignature is a function or method taking or returning other
+	// types, or a struct whose exported fields were removed or retyped
+	ChangedSignature = "changed"
+	// NarrowedInterface is an interface that lost or changed methods its
+	// callers may call
+	NarrowedInterface = "narrowed"
+	// ExtendedInterface is an interface with new methods, which types
+	// outside the module implementing it lack
+	ExtendedInterface = "extended"
+)
+
+// apiSymbol is an exported symbol of a package and its declaration as
+// compared: a function's signature, or a type's exported fields or methods
+type apiSymbol struct {
+	kind    string // func, struct or interface
+	members []string
+}
+
+// BreakingChanges compares the exported API of the importable packages of
+// before and after, leaving out main, internal and test code. Symbols are
+// matched by package, receiver and name, so moving one between the files
+// of its package changes nothing, and only types count, not parameter
+// names. Additions are compatible and not reported, except for methods
+// added to an interface. Changes are ordered by package and name.
+func BreakingChanges(before, after *Graph) []APIChange {
+	old, current := exportedAPI(before), exportedAPI(after)
+	var changes []APIChange
+	for id, was := range old {
+		pkg, name, _ := strings.Cut(id, "\x00")
+		change := APIChange{Package: pkg, Name: name, Before: was.declaration(name)}
+		is, ok := current[id]
+		switch {
+		case !ok:
+			change.Kind = RemovedSymbol
+			change.Message = fmt.Sprintf("%s %s was removed or unexported", was.kind, name)
+		case was.kind != is.kind:
+			change.Kind, change.After = ChangedSignature, is.declaration(name)
+			change.Message = fmt.Sprintf("%s is no longer a %s", name, was.kind)
+		case was.kind == "interface":
+			lost := slices.DeleteFunc(slices.Clone(was.members), func(m string) bool { return slices.Contains(is.members, m) })
+			added := slices.DeleteFunc(slices.Clone(is.members), func(m string) bool { return slices.Contains(was.members, m) })
+			change.After = is.declaration(name)
+			if len(lost) > 0 {
+				change.Kind = NarrowedInterface
+				change.Message = fmt.Sprintf("%s no longer has %s", name, strings.Join(lost, ", "))
+			} else if len(added) > 0 {
+				change.Kind = ExtendedInterface
+				change.Message = fmt.Sprintf("%s now also needs %s of its implementations", name, strings.Join(added, ", "))
+			}
+		case was.kind == "struct":
+			lost := slices.DeleteFunc(slices.Clone(was.members), func(f string) bool { return slices.Contains(is.members, f) })
+			if len(lost) > 0 {
+				change.Kind, change.After = ChangedSignature, is.declaration(name)
+				change.Message = fmt.Sprintf("%s no longer has the fields %s", name, strings.Join(lost, ", "))
+			}
+		case !slices.Equal(was.members, is.members):
+			change.Kind, change.After = ChangedSignature, is.declaration(name)
+			change.Message = fmt.Sprintf("%s changed from %s to %s", name, change.Before, change.After)
+		}
+		if change.Kind != "" {
+			changes = append(changes, change)
+		}
+	}
+	slices.SortFunc(changes, func(a, b APIChange) int {
+		return cmp.Or(strings.Compare(a.Package, b.Package), strings.Compare(a.Name, b.Name))
+	})
+	return changes
+}
+
+// declaration writes s as a Go declaration of name
+func (s apiSymbol) declaration(name string) string {
+	switch s.kind {
+	case "func":
+		if typeName, method, ok := strings.Cut(name, "."); ok {
+			return "func (" + typeName + ") " + method + strings.TrimPrefix(s.members[0], "func")
+		}
+		return "func " + name + strings.TrimPrefix(s.members[0], "func")
+	case "struct", "interface":
+		return "type " + name + " " + s.kind + " { " + strings.Join(s.members, "; ") + " }"
+	}
+	return name
+}
+
+// exportedAPI returns the exported symbols of g's importable packages by
+// package path and name, methods named Type.Method
+func exportedAPI(g *Graph) map[string]apiSymbol {
+	api := make(map[string]apiSymbol)
+	packages := make(map[string]string, len(g.Files))
+	for _, file := range g.Files {
+		packages[file.Path] = file.Package
+	}
+	importable := func(file string) bool {
+		dir := filepath.ToSlash(filepath.Dir(file))
+		return packages[file] != "main" && !strings.HasSuffix(file, "_test.go") &&
+			!slices.Contains(strings.Split(dir, "/"), "internal")
+	}
+	id := func(file, name string) string {
+		return filepath.ToSlash(filepath.Dir(file)) + "\x00" + name
+	}
+
+	for _, fn := range g.Functions {
+		receiver, _, _ := strings.Cut(strings.TrimPrefix(fn.Receiver, "*"), "[")
+		if !fn.IsExport || !importable(fn.File) || (receiver != "" && !ast.IsExported(receiver)) {
+			continue
+		}
+		name := fn.Name
+		if receiver != "" {
+			name = receiver + "." + fn.Name
+		}
+		params := strings.TrimPrefix(strings.TrimPrefix(fn.Signature, "func "), "("+fn.Receiver+") ")
+		api[id(fn.File, name)] = apiSymbol{kind: "func", members: []string{apiSignature(strings.TrimPrefix(params, fn.Name))}}
+	}
+	for _, st := range g.Structs {
+		if !st.IsExport || !importable(st.File) {
+			continue
+		}
+		var fields []string
+		for _, field := range st.Fields {
+			name, typ, named := strings.Cut(field, " ")
+			if !named {
+				// An embedded field is named after its type
+				typ = name
+				name = strings.TrimPrefix(name[strings.LastIndex(name, ".")+1:], "*")
+			}
+			if ast.IsExported(name) {
+				fields = append(fields, name+" "+typ)
+			}
+		}
+		api[id(st.File, st.Name)] = apiSymbol{kind: "struct", members: fields}
+	}
+	for _, iface := range g.Interfaces {
+		if !iface.IsExport || !importable(iface.File) {
+			continue
+		}
+		methods := make([]string, 0, len(iface.Methods))
+		for _, method := range iface.Methods {
+			name, params, _ := strings.Cut(method, "(")
+			methods = append(methods, name+strings.TrimPrefix(apiSignature("("+params), "func"))
+		}
+		slices.Sort(methods)
+		api[id(iface.File, iface.Name)] = apiSymbol{kind: "interface", members: methods}
+	}
+	return api
+}
+
+// apiSignature rewrites the parameters and results of a signature, such as
+// "(key string, n int) error", as a function type without parameter
+// names, "func(string, int) error". A signature it cannot parse is kept
+// as it is.
+func apiSignature(params string) string {
+	expr, err := parser.ParseExpr("func" + params)
+	fn, ok := expr.(*ast.FuncType)
+	if err != nil || !ok {
+		return params
+	}
+	typeList := func(fields *ast.FieldList) []string {
+		var list []string
+		if fields == nil {
+			return list
+		}
+		for _, field := range fields.List {
+			for range max(len(field.Names), 1) {
+				list = append(list, types.ExprString(field.Type))
+			}
+		}
+		return list
+	}
+	sig := "func(" + strings.Join(typeList(fn.Params), ", ") + ")"
+	switch results := typeList(fn.Results); len(results) {
+	case 0:
+	case 1:
+		sig += " " + results[0]
+	default:
+		sig += " (" + strings.Join(results, ", ") + ")"
+	}
+	return sig
+}
@@ -0,0 +1,109 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestBreakingChanges(t *testing.T) {
+	before := writeTree(t, map[string]string{
+		"store/store.go": `package store
+
+type Store struct {
+	Name  string
+	Size  int
+	cache map[string]int
+}
+
+func (s *Store) Put(key string, value []byte) error { return nil }
+
+func (s *Store) Close() {}
+
+func Open(path string) *Store { return nil }
+
+func Remove() {}
+
+type Saver interface {
+	Save(key string) error
+	Flush()
+}
+
+type Loader interface {
+	Load(key string) []byte
+}
+`,
+		"store/internal/disk/disk.go": "package disk\n\nfunc Write() {}\n",
+		"main.go":                     "package main\n\nfunc Run() {}\n",
+	})
+	after := writeTree(t, map[string]string{
+		// Moved to another file, parameters renamed and a field reordered:
+		// none of it breaks importers
+		"store/open.go": "package store\n\nfunc Open(file string) *Store { return nil }\n\nfunc Added() {}\n",
+		"store/store.go": `package store
+
+type Store struct {
+	Size  int64
+	Name  string
+}
+
+func (s *Store) Put(key string, value []byte, sync bool) error { return nil }
+
+type Saver interface {
+	Flush()
+}
+
+type Loader interface {
+	Load(key string) []byte
+	Keys() []string
+}
+`,
+		"store/internal/disk/disk.go": "package disk\n\nfunc Write(sync bool) {}\n",
+		"main.go":                     "package main\n",
+	})
+	parse := func(root string) *Graph {
+		graph, err := Parser{Root: root}.Parse(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return graph
+	}
+
+	want := []APIChange{
+		{Kind: ExtendedInterface, Package: "store", Name: "Loader",
+			Before: "type Loader interface { Load(string) []byte }", After: "type Loader interface { Keys() []string; Load(string) []byte }",
+			Message: "Loader now also needs Keys() []string of its implementations"},
+		{Kind: RemovedSymbol, Package: "store", Name: "Remove", Before: "func Remove()", Message: "func Remove was removed or unexported"},
+		{Kind: NarrowedInterface, Package: "store", Name: "Saver",
+			Before: "type Saver interface { Flush(); Save(string) error }", After: "type Saver interface { Flush() }",
+			Message: "Saver no longer has Save(string) error"},
+		{Kind: ChangedSignature, Package: "store", Name: "Store",
+			Before: "type Store struct { Name string; Size int }", After: "type Store struct { Size int64; Name string }",
+			Message: "Store no longer has the fields Size int"},
+		{Kind: RemovedSymbol, Package: "store", Name: "Store.Close", Before: "func (Store) Close()", Message: "func Store.Close was removed or unexported"},
+		{Kind: ChangedSignature, Package: "store", Name: "Store.Put",
+			Before: "func (Store) Put(string, []byte) error", After: "func (Store) Put(string, []byte, bool) error",
+			Message: "Store.Put changed from func (Store) Put(string, []byte) error to func (Store) Put(string, []byte, bool) error"},
+	}
+	if changes := BreakingChanges(parse(before), parse(after)); !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes =\n%+v\nwant\n%+v", changes, want)
+	}
+	if changes := BreakingChanges(parse(after), parse(after)); changes != nil {
+		t.Errorf("changes to itself = %+v", changes)
+	}
+}
+
+func TestAPISignature(t *testing.T) {
+	tests := map[string]string{
+		"(key string, n, m int) error":        "func(string, int, int) error",
+		"(ctx context.Context) (int, error)":  "func(context.Context) (int, error)",
+		"()":                                  "func()",
+		"(fn func(a int) bool, xs ...string)": "func(func(a int) bool, ...string)",
+		"(not go":                             "(not go",
+	}
+	for params, want := range tests {
+		if got := apiSignature(params); got != want {
+			t.Errorf("apiSignature(%q) = %q, want %q", params, got, want)
+		}
+	}
+}
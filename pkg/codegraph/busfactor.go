@@ -0,0 +1,76 @@
+package codegraph
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// PackageOwnership is who wrote a package, summed from the git blame of
+// its files: Owner wrote the largest share of its Lines, Knowledgeable
+// counts the authors who wrote at least KnowledgeableShare of them, and
+// BusFactor is the fewest authors who between them wrote more than half.
+// A bus factor of 1 is code only one person knows.
+type PackageOwnership struct {
+	Package       string  `json:"package"`
+	Lines         int     `json:"lines"`
+	Owner         string  `json:"owner"`
+	OwnerShare    float64 `json:"ownerShare"`
+	Knowledgeable int     `json:"knowledgeable"`
+	BusFactor     int     `json:"busFactor"`
+}
+
+// KnowledgeableShare is the least share of a package's lines an author
+// must have written to count as knowing it
+const KnowledgeableShare = 0.1
+
+// PackageOwnerships returns the ownership of every package of g with
+// blamed files, by path, from the Ownership AddOwnership recorded
+func (g *Graph) PackageOwnerships() []PackageOwnership {
+	lines := make(map[string]map[string]int)
+	for _, file := range g.Files {
+		o, ok := g.Ownership[file.Key()]
+		if !ok {
+			continue
+		}
+		dir := filepath.Dir(file.Path)
+		if lines[dir] == nil {
+			lines[dir] = make(map[string]int)
+		}
+		for email, n := range o.Lines {
+			lines[dir][email] += n
+		}
+	}
+
+	var ownerships []PackageOwnership
+	for dir, authors := range lines {
+		emails := make([]string, 0, len(authors))
+		total := 0
+		for email, n := range authors {
+			emails = append(emails, email)
+			total += n
+		}
+		if total == 0 {
+			continue
+		}
+		slices.SortFunc(emails, func(a, b string) int {
+			return cmp.Or(authors[b]-authors[a], strings.Compare(a, b))
+		})
+		o := PackageOwnership{Package: dir, Lines: total, Owner: emails[0],
+			OwnerShare: float64(authors[emails[0]]) / float64(total)}
+		covered := 0
+		for _, email := range emails {
+			if float64(authors[email]) >= KnowledgeableShare*float64(total) {
+				o.Knowledgeable++
+			}
+			if covered*2 <= total {
+				covered += authors[email]
+				o.BusFactor++
+			}
+		}
+		ownerships = append(ownerships, o)
+	}
+	slices.SortFunc(ownerships, func(a, b PackageOwnership) int { return cmp.Compare(a.Package, b.Package) })
+	return ownerships
+}
@@ -0,0 +1,24 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackageOwnerships(t *testing.T) {
+	graph := &Graph{
+		Files: []FileNode{{Path: "main.go"}, {Path: "store/store.go"}, {Path: "store/cache.go"}, {Path: "untracked.go"}},
+		Ownership: map[string]Ownership{
+			"File:main.go":        {Lines: map[string]int{"ann@example.com": 90, "bob@example.com": 10}},
+			"File:store/store.go": {Lines: map[string]int{"ann@example.com": 30, "bob@example.com": 40}},
+			"File:store/cache.go": {Lines: map[string]int{"cy@example.com": 25, "dee@example.com": 5}},
+		},
+	}
+	want := []PackageOwnership{
+		{Package: ".", Lines: 100, Owner: "ann@example.com", OwnerShare: 0.9, Knowledgeable: 2, BusFactor: 1},
+		{Package: "store", Lines: 100, Owner: "bob@example.com", OwnerShare: 0.4, Knowledgeable: 3, BusFactor: 2},
+	}
+	if got := graph.PackageOwnerships(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ownerships = %+v, want %+v", got, want)
+	}
+}
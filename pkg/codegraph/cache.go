@@ -0,0 +1,206 @@
+package codegraph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// parseCacheVersion is part of every key of a parseCache; change it
+// whenever parsing the same file gives a different fragment than before
+const parseCacheVersion = "1"
+
+// parseCache keeps the fragment parsed from each file in a directory, as
+// JSON named after the hash of the file's path and content, so unchanged
+// files are read back instead of parsed again. What the names of a
+// type-checked file resolve to depends on the other files of its package
+// and those it imports, so type-checked packages are cached whole instead,
+// see packageKeys.
+type parseCache struct {
+	dir          string
+	hits, misses atomic.Int64
+}
+
+// newParseCache returns the cache in dir, or nil if dir is empty
+func newParseCache(dir string) *parseCache {
+	if dir == "" {
+		return nil
+	}
+	return &parseCache{dir: dir}
+}
+
+// key is the hash a file at relPath with content src is cached by
+func (c *parseCache) key(relPath string, src []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", parseCacheVersion, filepath.ToSlash(relPath))
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// file is where the fragment of key is kept, below a directory of the
+// first byte of the hash so none grows too large
+func (c *parseCache) file(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// get returns the fragment cached under key, if any
+func (c *parseCache) get(key string) (*Graph, bool) {
+	var fragment Graph
+	if !c.load(key, &fragment) {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return &fragment, true
+}
+
+// put caches fragment under key
+func (c *parseCache) put(key string, fragment *Graph) {
+	c.store(key, fragment)
+}
+
+// load decodes what is cached under key into v, reporting whether there
+// was anything readable
+func (c *parseCache) load(key string, v any) bool {
+	data, err := os.ReadFile(c.file(key))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// store caches v under key. The file is written whole and renamed into
+// place, so concurrent runs never read half of it. Failing to cache is
+// only a warning, as what was parsed is parsed again the next time.
+func (c *parseCache) store(key string, v any) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = writeFileAtomic(c.file(key), data)
+	}
+	if err != nil {
+		slog.Warn("could not cache parsed file", "dir", c.dir, "err", err)
+	}
+}
+
+// typedPackage is what type-checking a package gives: the fragment of each
+// of its files below the root, by slash-separated path, and the keys of
+// its functions by funcID
+type typedPackage struct {
+	Files map[string]*Graph `json:"files"`
+	Keys  map[string]string `json:"keys"`
+
+	// fresh is set when the package was type-checked in this run, rather
+	// than read from the cache
+	fresh bool
+}
+
+// packageKeys returns the hash each listed package is cached by, by ID:
+// of its ID, the path below root and content of its files, the go.mod and
+// go.sum of its module, the Go version and the hashes of the packages it
+// imports, so a package is checked again whenever anything its types
+// depend on changes. Packages in no list, such as those of the standard
+// library and of modules required by version, count by ID alone, their
+// versions being in go.sum. A package with a file that cannot be read
+// has no hash and is never cached.
+func packageKeys(root string, modules []ModuleNode, lists [][]*packages.Package, filter Filter) map[string]string {
+	byID := make(map[string]*packages.Package)
+	module := make(map[string]string)
+	for i, list := range lists {
+		h := sha256.New()
+		for _, name := range []string{"go.mod", "go.sum"} {
+			data, _ := os.ReadFile(filepath.Join(root, modules[i].Dir, name))
+			fmt.Fprintf(h, "%s\x00%d\x00", name, len(data))
+			h.Write(data)
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		for _, pkg := range list {
+			byID[pkg.ID] = pkg
+			module[pkg.ID] = sum
+		}
+	}
+
+	hashes := make(map[string]string)
+	var hash func(id string) (string, bool)
+	hash = func(id string) (string, bool) {
+		if sum, ok := hashes[id]; ok {
+			return sum, sum != ""
+		}
+		pkg, ok := byID[id]
+		if !ok {
+			// A package compiled again for a test, "p [q.test]", changes
+			// with p
+			if base, _, found := strings.Cut(id, " ["); found {
+				return hash(base)
+			}
+			return id, true
+		}
+		hashes[id] = ""
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00typed\x00%s\x00%d\x00%s\x00%s\x00", parseCacheVersion, runtime.Version(), filter.MaxFileSize, id, module[id])
+		for _, file := range pkg.CompiledGoFiles {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				return "", false
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				rel = filepath.Base(file)
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(src))
+			h.Write(src)
+		}
+		for _, path := range slices.Sorted(maps.Keys(pkg.Imports)) {
+			sum, ok := hash(pkg.Imports[path].ID)
+			if !ok {
+				return "", false
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00", path, sum)
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		hashes[id] = sum
+		return sum, true
+	}
+	for id := range byID {
+		hash(id)
+	}
+	return hashes
+}
+
+// report logs how many files were read from the cache
+func (c *parseCache) report() {
+	if c != nil {
+		slog.Info("parse cache", "dir", c.dir, "reused", c.hits.Load(), "parsed", c.misses.Load())
+	}
+}
+
+// writeFileAtomic writes data to a temporary file beside file and renames
+// it to file, creating the directory if need be
+func writeFileAtomic(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,110 @@
+package codegraph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCache(t *testing.T) {
+	ctx := context.Background()
+	root := writeTree(t, testTree)
+	dir := t.TempDir()
+	uncached, err := parseCodebase(ctx, root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func() (*Graph, *parseCache) {
+		t.Helper()
+		cache := newParseCache(dir)
+		graph, err := parseCodebase(ctx, root, Filter{}, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return graph, cache
+	}
+	graph, cache := parse()
+	if cache.hits.Load() != 0 || cache.misses.Load() != int64(len(graph.Files)) {
+		t.Errorf("first run reused %d and parsed %d files", cache.hits.Load(), cache.misses.Load())
+	}
+	graph, cache = parse()
+	if cache.hits.Load() != int64(len(graph.Files)) || cache.misses.Load() != 0 {
+		t.Errorf("second run reused %d and parsed %d files", cache.hits.Load(), cache.misses.Load())
+	}
+	if !reflect.DeepEqual(graph, uncached) {
+		t.Errorf("cached graph differs:\n%+v\nwant\n%+v", graph, uncached)
+	}
+
+	// A changed file is parsed again, and so is one whose entry is broken
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.ReadFile(filepath.Join(root, "store", "store.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.file(cache.key(filepath.Join("store", "store.go"), src)), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	graph, cache = parse()
+	if cache.misses.Load() != 2 || cache.hits.Load() != int64(len(graph.Files))-2 {
+		t.Errorf("after changes reused %d and parsed %d files", cache.hits.Load(), cache.misses.Load())
+	}
+	if newParseCache("") != nil {
+		t.Error("a cache without a directory")
+	}
+}
+
+func TestParseTypedCache(t *testing.T) {
+	ctx := context.Background()
+	root := writeTree(t, typedTree)
+	dir := t.TempDir()
+	uncached, err := parseTyped(ctx, root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func() (*Graph, *parseCache) {
+		t.Helper()
+		cache := newParseCache(dir)
+		graph, err := parseTyped(ctx, root, Filter{}, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return graph, cache
+	}
+	graph, cache := parse()
+	if cache.hits.Load() != 0 || cache.misses.Load() != 3 {
+		t.Errorf("first run reused %d and parsed %d files", cache.hits.Load(), cache.misses.Load())
+	}
+	graph, cache = parse()
+	if cache.hits.Load() != 3 || cache.misses.Load() != 0 {
+		t.Errorf("second run reused %d and parsed %d files", cache.hits.Load(), cache.misses.Load())
+	}
+	if !reflect.DeepEqual(graph, uncached) {
+		t.Errorf("cached graph differs:\n%+v\nwant\n%+v", graph, uncached)
+	}
+
+	// A changed package is checked again with those importing it, and
+	// their calls resolve to what it now declares
+	store := strings.Replace(typedTree["store/store.go"], "func New()", "// New makes a Store\nfunc New()", 1)
+	if err := os.WriteFile(filepath.Join(root, "store", "store.go"), []byte(store), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	graph, cache = parse()
+	if cache.hits.Load() != 1 || cache.misses.Load() != 2 {
+		t.Errorf("after a change reused %d and parsed %d files", cache.hits.Load(), cache.misses.Load())
+	}
+	var calls []string
+	for _, rel := range graph.Calls() {
+		calls = append(calls, rel.From+" -> "+rel.To)
+	}
+	if !slices.Contains(calls, "Function:main.go:main -> Function:store/store.go:New") {
+		t.Errorf("calls after a change = %q", calls)
+	}
+}
@@ -0,0 +1,138 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CallDepth is how deep the calls from an entry point of the program go:
+// Depth calls along the longest chain of them, Path, which runs from the
+// entry point to the function deepest down by their keys. Kind tells the
+// entry points apart: "main", an "http" handler or an "rpc" method.
+type CallDepth struct {
+	Key   string   `json:"key"`
+	Name  string   `json:"name"`
+	File  string   `json:"file"`
+	Line  int      `json:"line"`
+	Kind  string   `json:"kind"`
+	Depth int      `json:"depth"`
+	Path  []string `json:"path"`
+}
+
+// DeepCallChain is the kind of finding DeepCallFindings reports
+const DeepCallChain = "deep-call-chain"
+
+// CallDepths finds the entry points of g, see entryKind, and follows the
+// calls from each, resolved like Graph.Calls whether or not the calls
+// feature is on, to the longest chain. A call back into the chain, as in
+// recursion, is not followed. Entry points are ordered deepest first, then
+// by key.
+func (g *Graph) CallDepths() []CallDepth {
+	full := *g
+	full.Features = Features{"calls": true}
+	callees := make(map[string][]string)
+	for _, call := range full.Calls() {
+		callees[call.From] = append(callees[call.From], call.To)
+	}
+	for from := range callees {
+		slices.Sort(callees[from])
+	}
+
+	// deepest holds the longest chain found below each function, by key
+	deepest := make(map[string][]string)
+	onChain := make(map[string]bool)
+	var follow func(key string) []string
+	follow = func(key string) []string {
+		if chain, ok := deepest[key]; ok {
+			return chain
+		}
+		onChain[key] = true
+		var longest []string
+		for _, callee := range callees[key] {
+			if onChain[callee] {
+				continue
+			}
+			if chain := follow(callee); len(chain) > len(longest) {
+				longest = chain
+			}
+		}
+		onChain[key] = false
+		deepest[key] = append([]string{key}, longest...)
+		return deepest[key]
+	}
+
+	kinds := g.entryKinds()
+	var depths []CallDepth
+	for _, fn := range g.Functions {
+		kind, ok := kinds[fn.Key()]
+		if !ok {
+			continue
+		}
+		name := fn.Name
+		if fn.Receiver != "" {
+			name = strings.TrimPrefix(fn.Receiver, "*") + "." + fn.Name
+		}
+		path := follow(fn.Key())
+		depths = append(depths, CallDepth{Key: fn.Key(), Name: name, File: fn.File, Line: fn.LineStart,
+			Kind: kind, Depth: len(path) - 1, Path: path})
+	}
+	slices.SortFunc(depths, func(a, b CallDepth) int {
+		return cmp.Or(b.Depth-a.Depth, strings.Compare(a.Key, b.Key))
+	})
+	return depths
+}
+
+// entryKinds returns the kind of each entry point of g by key: the main
+// function of package main, the HTTP handlers, which take an
+// http.ResponseWriter and an *http.Request or are ServeHTTP methods, and
+// the exported methods of gRPC servers, the structs embedding a generated
+// Unimplemented...Server
+func (g *Graph) entryKinds() map[string]string {
+	packages := make(map[string]string, len(g.Files))
+	for _, file := range g.Files {
+		packages[file.Path] = file.Package
+	}
+	servers := make(map[string]bool)
+	for _, st := range g.Structs {
+		for _, field := range st.Fields {
+			embedded := field[strings.LastIndex(field, ".")+1:]
+			if !strings.Contains(field, " ") && strings.HasPrefix(embedded, "Unimplemented") && strings.HasSuffix(embedded, "Server") {
+				servers[filepath.Dir(st.File)+"\x00"+st.Name] = true
+			}
+		}
+	}
+
+	kinds := make(map[string]string)
+	for _, fn := range g.Functions {
+		receiver := strings.TrimPrefix(fn.Receiver, "*")
+		switch {
+		case fn.Name == "main" && fn.Receiver == "" && packages[fn.File] == "main":
+			kinds[fn.Key()] = "main"
+		case fn.Receiver != "" && fn.Name == "ServeHTTP",
+			strings.Contains(fn.Signature, "http.ResponseWriter") && strings.Contains(fn.Signature, "*http.Request"):
+			kinds[fn.Key()] = "http"
+		case fn.IsExport && servers[filepath.Dir(fn.File)+"\x00"+receiver]:
+			kinds[fn.Key()] = "rpc"
+		}
+	}
+	return kinds
+}
+
+// DeepCallFindings flags the entry points of depths whose calls go at
+// least maxDepth deep
+func DeepCallFindings(depths []CallDepth, maxDepth int) []Finding {
+	var findings []Finding
+	for _, d := range depths {
+		if d.Depth < maxDepth {
+			continue
+		}
+		findings = append(findings, Finding{
+			Kind: DeepCallChain, Key: d.Key, Name: d.Name, File: d.File, Line: d.Line,
+			Message: fmt.Sprintf("%s, a %s entry point, calls %d deep, down to %s", d.Name, d.Kind, d.Depth, d.Path[len(d.Path)-1]),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,49 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCallDepths(t *testing.T) {
+	graph := &Graph{
+		Files: []FileNode{
+			{Path: "main.go", Package: "main"},
+			{Path: "api/api.go", Package: "api"},
+		},
+		Structs: []StructNode{{Name: "Greeter", File: "api/api.go", Fields: []string{"pb.UnimplementedGreeterServer"}}},
+		Functions: []FunctionNode{
+			{Name: "main", File: "main.go", Calls: []string{"serve", "setup"}},
+			{Name: "serve", File: "main.go", Calls: []string{"listen"}},
+			{Name: "listen", File: "main.go", Calls: []string{"accept"}},
+			{Name: "accept", File: "main.go", Calls: []string{"listen"}},
+			{Name: "setup", File: "main.go"},
+			{Name: "handle", File: "api/api.go", Signature: "func handle(w http.ResponseWriter, r *http.Request)", Calls: []string{"decode"}},
+			{Name: "decode", File: "api/api.go"},
+			{Name: "SayHello", File: "api/api.go", Receiver: "*Greeter", IsExport: true},
+			{Name: "reset", File: "api/api.go", Receiver: "*Greeter"},
+		},
+	}
+	var got []CallDepth
+	for _, d := range graph.CallDepths() {
+		d.Line = 0
+		got = append(got, d)
+	}
+	want := []CallDepth{
+		{Key: "Function:main.go:main", Name: "main", File: "main.go", Kind: "main", Depth: 3,
+			Path: []string{"Function:main.go:main", "Function:main.go:serve", "Function:main.go:listen", "Function:main.go:accept"}},
+		{Key: "Function:api/api.go:handle", Name: "handle", File: "api/api.go", Kind: "http", Depth: 1,
+			Path: []string{"Function:api/api.go:handle", "Function:api/api.go:decode"}},
+		{Key: "Function:api/api.go:*Greeter.SayHello", Name: "Greeter.SayHello", File: "api/api.go", Kind: "rpc", Depth: 0,
+			Path: []string{"Function:api/api.go:*Greeter.SayHello"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("depths = %+v, want %+v", got, want)
+	}
+
+	findings := DeepCallFindings(got, 2)
+	if len(findings) != 1 || findings[0].Key != "Function:main.go:main" ||
+		findings[0].Message != "main, a main entry point, calls 3 deep, down to Function:main.go:accept" {
+		t.Errorf("findings = %+v", findings)
+	}
+}
@@ -0,0 +1,138 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Changes is what happened in a project since a time, to catch up on it
+// when resuming work: the keys of the code nodes changed since, files
+// first, and the memories and decisions recorded since, newest first
+type Changes struct {
+	Since     time.Time  `json:"since"`
+	Nodes     []string   `json:"nodes"`
+	Memories  []Memory   `json:"memories"`
+	Decisions []Decision `json:"decisions"`
+}
+
+// ChangeQuery selects the changes made after Since. Code changes are read
+// from the git repository at Root, if set, and matched to the functions
+// and methods of Graph, parsed from its working tree. Namespaces, if not
+// nil, keeps to the shared memories and those in the namespaces listed.
+type ChangeQuery struct {
+	Since      time.Time
+	Root       string
+	Graph      *Graph
+	Namespaces []string
+}
+
+// ChangesSince reads what changed in the project after cq.Since: the code
+// from git, the memories from m and the decisions from d, either of which
+// may be nil, without recording an access to the memories
+func ChangesSince(ctx context.Context, m MemoryStore, d DecisionStore, project string, cq ChangeQuery) (Changes, error) {
+	changes := Changes{Since: cq.Since.UTC(), Nodes: []string{}, Memories: []Memory{}, Decisions: []Decision{}}
+	if cq.Root != "" {
+		nodes, err := ChangedNodes(ctx, cq.Root, cq.Since, cq.Graph)
+		if err != nil {
+			return Changes{}, err
+		}
+		changes.Nodes = nodes
+	}
+	if m != nil {
+		memories, err := m.Recall(ctx, project, MemoryQuery{Since: cq.Since, Namespaces: cq.Namespaces, Peek: true})
+		if err != nil {
+			return Changes{}, fmt.Errorf("reading memories: %w", err)
+		}
+		changes.Memories = append(changes.Memories, memories...)
+	}
+	if d != nil {
+		decisions, err := d.Decisions(ctx, project, DecisionQuery{Since: cq.Since})
+		if err != nil {
+			return Changes{}, fmt.Errorf("reading decisions: %w", err)
+		}
+		changes.Decisions = append(changes.Decisions, decisions...)
+	}
+	return changes, nil
+}
+
+// SessionTime returns the time to catch up from after a session: its end,
+// or its start if it has not ended
+func SessionTime(ctx context.Context, s SessionStore, project, id string) (time.Time, error) {
+	session, _, err := s.Replay(ctx, project, id, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cmp.Or(session.EndedAt, session.StartedAt), nil
+}
+
+// GitDiff returns the zero-context diff of the Go files below root in its
+// working tree against the git revision rev
+func GitDiff(ctx context.Context, root, rev string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", "-C", root, "diff", "--no-renames", "--relative", "-U0", rev, "--", "*.go").Output()
+	if err != nil {
+		return "", GitError("diff", err)
+	}
+	return string(out), nil
+}
+
+// ChangedNodes returns the keys of the Go files changed in the commits to
+// root after since or in its working tree, untracked files included, then
+// of the functions and methods of graph whose lines those changes touched.
+// graph, which may be nil, is parsed from the working tree.
+func ChangedNodes(ctx context.Context, root string, since time.Time, graph *Graph) ([]string, error) {
+	commits, err := logChanges(ctx, root, "", since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	diff, err := GitDiff(ctx, root, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	// The working tree is newer than every commit
+	commits = append([][]fileChange{parseDiff(diff)}, commits...)
+	out, err := exec.CommandContext(ctx, "git", "-C", root, "ls-files", "--others", "--exclude-standard", "--", "*.go").Output()
+	if err != nil {
+		return nil, GitError("ls-files", err)
+	}
+	untracked := strings.Fields(string(out))
+
+	functions := make(map[string][]FunctionNode)
+	if graph != nil {
+		for _, fn := range graph.Functions {
+			functions[fn.File] = append(functions[fn.File], fn)
+		}
+	}
+	var files, changed []string
+	add := func(list *[]string, key string) {
+		if !slices.Contains(*list, key) {
+			*list = append(*list, key)
+		}
+	}
+	for _, path := range untracked {
+		add(&files, FileNode{Path: path}.Key())
+		for _, fn := range functions[path] {
+			add(&changed, fn.Key())
+		}
+	}
+	// later holds, per file, the hunks of the changes already seen, oldest
+	// first, as in AddChurn
+	later := make(map[string][][]hunk)
+	for _, commit := range commits {
+		for _, change := range commit {
+			add(&files, FileNode{Path: change.Path}.Key())
+			for _, h := range change.Hunks {
+				for _, key := range slices.Sorted(maps.Keys(hunkFunctions(functions[change.Path], later[change.Path], h))) {
+					add(&changed, key)
+				}
+			}
+			later[change.Path] = append([][]hunk{change.Hunks}, later[change.Path]...)
+		}
+	}
+	return append(nonNil(files), changed...), nil
+}
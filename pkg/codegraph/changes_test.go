@@ -0,0 +1,75 @@
+package codegraph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedNodes(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("GIT_COMMITTER_DATE", "2020-01-01T00:00:00Z")
+	root := gitRepo(t, testTree)
+	write := func(path, src string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(path)), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Put changes in a later commit, run in the working tree, and extra.go
+	// is not tracked yet
+	t.Setenv("GIT_COMMITTER_DATE", "2030-01-01T00:00:00Z")
+	write("store/store.go", strings.Replace(testTree["store/store.go"], "\treturn s.flush()", "\ts.keys = append(s.keys, key)\n\treturn s.flush()", 1))
+	git(t, root, "commit", "-q", "-am", "put twice")
+	write("main.go", strings.Replace(testTree["main.go"], "func run() {}", "func run() { main() }", 1))
+	write("extra.go", "package main\n\nfunc extra() {}\n")
+
+	graph, err := parseCodebase(ctx, root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := ChangedNodes(ctx, root, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"File:extra.go", "File:main.go", "File:store/store.go",
+		"Function:extra.go:extra", "Function:main.go:run", "Function:store/store.go:*Store.Put",
+	}
+	if !slices.Equal(nodes, want) {
+		t.Errorf("changed %q, want %q", nodes, want)
+	}
+
+	nodes, err = ChangedNodes(ctx, root, time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"File:extra.go", "File:main.go"}; !slices.Equal(nodes, want) {
+		t.Errorf("changed since 2031 %q, want %q", nodes, want)
+	}
+
+	if _, err := ChangedNodes(ctx, t.TempDir(), time.Time{}, nil); err == nil {
+		t.Error("read changes outside a repository")
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	since := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	m := &fakeMemoryStore{memories: []Memory{{ID: "mem-1", Text: "flush is slow"}}}
+	changes, err := ChangesSince(context.Background(), m, nil, "App", ChangeQuery{Since: since, Namespaces: []string{"team"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Memories) != 1 || changes.Nodes == nil || changes.Decisions == nil || !changes.Since.Equal(since) {
+		t.Errorf("changes = %+v", changes)
+	}
+	if want := (MemoryQuery{Since: since, Namespaces: []string{"team"}, Peek: true}); !reflect.DeepEqual(m.queries, []MemoryQuery{want}) {
+		t.Errorf("recalled with %+v, want %+v", m.queries, want)
+	}
+}
@@ -0,0 +1,203 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Consolidation is a group of near-duplicate memories and the memory
+// replacing them
+type Consolidation struct {
+	Memory  Memory   `json:"memory"`
+	Sources []Memory `json:"sources"`
+}
+
+// ConsolidateOptions selects which memories are near-duplicates: those in
+// the same namespace about a node in common whose texts are at least
+// Similarity alike, by the cosine of their embeddings with Embedder or
+// else by the words they share
+type ConsolidateOptions struct {
+	Embedder   Embedder
+	Similarity float64
+	// DryRun finds the groups without storing or archiving anything
+	DryRun bool
+}
+
+// ConsolidateMemories merges each group of near-duplicate live memories of
+// the project into one: the most detailed text, with the tags and keys of
+// all of them, linked to them by CONSOLIDATES relationships. The memories
+// merged are archived, so they are kept as its provenance but no longer
+// recalled.
+func ConsolidateMemories(ctx context.Context, m MemoryStore, project string, opts ConsolidateOptions) ([]Consolidation, error) {
+	memories, err := m.Recall(ctx, project, MemoryQuery{Peek: true})
+	if err != nil {
+		return nil, err
+	}
+	groups, err := duplicateGroups(ctx, memories, opts)
+	if err != nil {
+		return nil, err
+	}
+	consolidations := make([]Consolidation, 0, len(groups))
+	for _, group := range groups {
+		c := Consolidation{Memory: mergeMemories(group), Sources: group}
+		sources := c.Memory.Sources
+		if !opts.DryRun {
+			if c.Memory, err = m.Remember(ctx, project, c.Memory); err != nil {
+				return consolidations, fmt.Errorf("consolidating %s: %w", strings.Join(sources, ", "), err)
+			}
+			if _, err := m.ArchiveMemories(ctx, project, sources); err != nil {
+				return consolidations, fmt.Errorf("archiving %s: %w", strings.Join(sources, ", "), err)
+			}
+		}
+		consolidations = append(consolidations, c)
+	}
+	return consolidations, nil
+}
+
+// duplicateGroups returns the groups of two or more memories linked by
+// pairs that share a key and are alike enough, oldest first within each
+func duplicateGroups(ctx context.Context, memories []Memory, opts ConsolidateOptions) ([][]Memory, error) {
+	var vectors [][]float64
+	if opts.Embedder != nil && len(memories) > 0 {
+		texts := make([]string, len(memories))
+		for i, memory := range memories {
+			texts[i] = memory.Text
+		}
+		var err error
+		if vectors, err = opts.Embedder.Embed(ctx, texts); err != nil {
+			return nil, fmt.Errorf("embedding memories: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return nil, fmt.Errorf("embedding memories: got %d vectors for %d texts", len(vectors), len(texts))
+		}
+	}
+	words := make([]map[string]bool, len(memories))
+	for i, memory := range memories {
+		words[i] = wordSet(memory.Text)
+	}
+
+	// Union-find over the pairs alike enough
+	parent := make([]int, len(memories))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range memories {
+		for j := i + 1; j < len(memories); j++ {
+			if memories[i].Namespace != memories[j].Namespace {
+				continue
+			}
+			if !slices.ContainsFunc(memories[i].About, func(key string) bool { return slices.Contains(memories[j].About, key) }) {
+				continue
+			}
+			similarity := jaccard(words[i], words[j])
+			if vectors != nil {
+				similarity = cosine(vectors[i], vectors[j])
+			}
+			if similarity >= opts.Similarity {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	byRoot := make(map[int][]Memory)
+	var roots []int
+	for i, memory := range memories {
+		root := find(i)
+		if byRoot[root] == nil {
+			roots = append(roots, root)
+		}
+		byRoot[root] = append(byRoot[root], memory)
+	}
+	var groups [][]Memory
+	for _, root := range roots {
+		group := byRoot[root]
+		if len(group) < 2 {
+			continue
+		}
+		slices.SortFunc(group, func(a, b Memory) int { return strings.Compare(a.ID, b.ID) })
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// mergeMemories returns the memory consolidating group: the longest text,
+// the newest when as long, with every tag and key in order of first use,
+// the highest importance and confidence, and pinned if any was, in their
+// namespace, written via consolidation
+func mergeMemories(group []Memory) Memory {
+	merged := Memory{Tags: []string{}, About: []string{}, Namespace: group[0].Namespace, Provenance: Provenance{Via: ViaConsolidate}}
+	for _, memory := range group {
+		if len(memory.Text) >= len(merged.Text) {
+			merged.Text = memory.Text
+		}
+		for _, tag := range memory.Tags {
+			if !slices.Contains(merged.Tags, tag) {
+				merged.Tags = append(merged.Tags, tag)
+			}
+		}
+		for _, key := range memory.About {
+			if !slices.Contains(merged.About, key) {
+				merged.About = append(merged.About, key)
+			}
+		}
+		merged.Sources = append(merged.Sources, memory.ID)
+		merged.Importance = max(merged.Importance, memory.Importance)
+		merged.Confidence = max(merged.Confidence, memory.Confidence)
+		merged.Pinned = merged.Pinned || memory.Pinned
+	}
+	return merged
+}
+
+// wordSet returns the lower-cased words of text
+func wordSet(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		words[word] = true
+	}
+	return words
+}
+
+// jaccard returns the share of the words in a or b that are in both
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// cosine returns the cosine similarity of a and b, 0 if either is zero or
+// their lengths differ
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
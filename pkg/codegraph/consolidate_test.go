@@ -0,0 +1,94 @@
+package codegraph
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestConsolidateMemories(t *testing.T) {
+	store := &fakeMemoryStore{memories: []Memory{
+		{ID: "mem-1", Text: "Put holds the lock across fsync", Tags: []string{"perf"}, About: []string{"Function:store.go:*Store.Put"}, Importance: 0.9, Confidence: 0.5},
+		{ID: "mem-2", Text: "main exits 2 on bad flags", About: []string{"File:main.go"}},
+		{ID: "mem-3", Text: "Put holds the lock across fsync, so writes queue", Tags: []string{"locking"}, About: []string{"Function:store.go:*Store.Put", "Package:."}},
+		// Alike, but about another node
+		{ID: "mem-4", Text: "Put holds the lock across fsync", About: []string{"Function:cache.go:*Cache.Put"}},
+		{ID: "mem-5", Text: "put holds the lock across FSYNC!", About: []string{"Package:."}, Confidence: 0.8, Pinned: true},
+		// Alike, but in another namespace
+		{ID: "mem-6", Text: "Put holds the lock across fsync", About: []string{"Function:store.go:*Store.Put"}, Namespace: "user:alice"},
+	}}
+	consolidations, err := ConsolidateMemories(context.Background(), store, "App", ConsolidateOptions{Similarity: 0.6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consolidations) != 1 {
+		t.Fatalf("consolidations = %+v, want one", consolidations)
+	}
+	want := Memory{
+		Text:       "Put holds the lock across fsync, so writes queue",
+		Tags:       []string{"perf", "locking"},
+		About:      []string{"Function:store.go:*Store.Put", "Package:."},
+		Sources:    []string{"mem-1", "mem-3", "mem-5"},
+		Importance: 0.9,
+		Confidence: 0.8,
+		Pinned:     true,
+		Provenance: Provenance{Via: ViaConsolidate},
+	}
+	if got := consolidations[0].Memory; !reflect.DeepEqual(got, want) {
+		t.Errorf("consolidated into %+v, want %+v", got, want)
+	}
+	if len(store.memories) != 7 || !reflect.DeepEqual(store.memories[6], want) {
+		t.Errorf("remembered %+v", store.memories[6:])
+	}
+	var archived []string
+	for _, m := range store.memories {
+		if m.Archived {
+			archived = append(archived, m.ID)
+		}
+	}
+	if !slices.Equal(archived, want.Sources) {
+		t.Errorf("archived %q, want %q", archived, want.Sources)
+	}
+	if q := store.queries[0]; !q.Peek || q.All {
+		t.Errorf("recalled with %+v, want live memories without an access", q)
+	}
+}
+
+func TestConsolidateMemoriesEmbedded(t *testing.T) {
+	store := &fakeMemoryStore{memories: []Memory{
+		{ID: "mem-1", Text: "a", About: []string{"File:main.go"}},
+		{ID: "mem-2", Text: "bb", About: []string{"File:main.go"}},
+		{ID: "mem-3", Text: "c\nc", About: []string{"File:main.go"}},
+	}}
+	// lengthEmbedder embeds bb as (2, 1) and c\nc as (3, 2), closer to each
+	// other than to a's (1, 1)
+	consolidations, err := ConsolidateMemories(context.Background(), store, "App", ConsolidateOptions{
+		Embedder: &lengthEmbedder{}, Similarity: 0.99, DryRun: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consolidations) != 1 || !slices.Equal(consolidations[0].Memory.Sources, []string{"mem-2", "mem-3"}) {
+		t.Errorf("consolidations = %+v, want mem-2 and mem-3", consolidations)
+	}
+	if len(store.memories) != 3 || store.memories[0].Archived {
+		t.Errorf("dry run changed %+v", store.memories)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if got := jaccard(wordSet("Put holds the lock"), wordSet("put HOLDS the lock!")); got != 1 {
+		t.Errorf("jaccard of the same words = %v", got)
+	}
+	if got := jaccard(wordSet("a b"), wordSet("b c")); got != 1.0/3 {
+		t.Errorf("jaccard = %v, want 1/3", got)
+	}
+	if got := cosine([]float64{1, 0}, []float64{0, 2}); got != 0 {
+		t.Errorf("cosine of orthogonal vectors = %v", got)
+	}
+	if got := cosine([]float64{1, 1}, []float64{2, 2}); math.Abs(got-1) > 1e-12 {
+		t.Errorf("cosine of parallel vectors = %v", got)
+	}
+}
@@ -0,0 +1,178 @@
+package codegraph
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ConventionStore is implemented by backends that keep a project's coding
+// conventions and its developers' preferences, linked to the packages they
+// apply to, so an agent writing code in a package can follow them without
+// being told each time
+type ConventionStore interface {
+	// AddConvention stores convention, assigning its ID and CreatedAt, and
+	// links it to each package it applies to; every package must be stored
+	AddConvention(ctx context.Context, project string, convention Convention) (Convention, error)
+	// Conventions lists the conventions matching q, by category and then
+	// oldest first
+	Conventions(ctx context.Context, project string, q ConventionQuery) ([]Convention, error)
+	// RemoveConvention deletes the convention with the given ID, reporting
+	// whether there was one
+	RemoveConvention(ctx context.Context, project, id string) (bool, error)
+}
+
+// Convention is a rule code in a project follows, such as how errors are
+// wrapped, which logging library is used or how things are named, with why
+// and an example. It applies to Packages, by path, and those under them,
+// or to the whole project if there are none. A preference of one developer
+// is kept to their Namespace, such as user:alice, like a memory.
+type Convention struct {
+	ID        string    `json:"id"`
+	Category  string    `json:"category"`
+	Rule      string    `json:"rule"`
+	Rationale string    `json:"rationale,omitempty"`
+	Example   string    `json:"example,omitempty"`
+	Packages  []string  `json:"packages"`
+	Namespace string    `json:"namespace,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ConventionQuery selects conventions: those applying to Package, by its
+// path, and in Category, if set. Namespaces, if not nil, keeps to the
+// shared conventions and those in the namespaces listed.
+type ConventionQuery struct {
+	Package    string
+	Category   string
+	Namespaces []string
+	Limit      int
+}
+
+// ConventionCategories are the categories a convention can be in, the
+// first being the default
+var ConventionCategories = []string{"general", "error-handling", "logging", "naming", "testing", "style", "dependencies", "preference"}
+
+// ErrInvalidConvention is returned for a convention without a rule, in an
+// unknown category, or applying to an invalid package path
+var ErrInvalidConvention = errors.New("invalid convention")
+
+// newConventionID returns an ID that sorts by creation time
+func newConventionID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return "con-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// conventionCategory returns category, or the default category if it is
+// empty
+func conventionCategory(category string) string {
+	if category == "" {
+		return ConventionCategories[0]
+	}
+	return strings.ToLower(category)
+}
+
+// cypherAddConvention checks every package of convention is stored, then
+// creates the Convention node and links it to them
+func cypherAddConvention(ctx context.Context, q Querier, labels LabelMap, project string, convention Convention) (Convention, error) {
+	if strings.TrimSpace(convention.Rule) == "" {
+		return Convention{}, fmt.Errorf("%w: no rule", ErrInvalidConvention)
+	}
+	convention.Category = conventionCategory(convention.Category)
+	if !slices.Contains(ConventionCategories, convention.Category) {
+		return Convention{}, fmt.Errorf("%w: unknown category %q, expected one of %s", ErrInvalidConvention, convention.Category, strings.Join(ConventionCategories, ", "))
+	}
+	packages := []string{}
+	keys := make([]string, 0, len(convention.Packages))
+	for _, pkg := range convention.Packages {
+		pkg = path.Clean(strings.TrimPrefix(pkg, "Package:"))
+		if path.IsAbs(pkg) || pkg == ".." || strings.HasPrefix(pkg, "../") {
+			return Convention{}, fmt.Errorf("%w: package %q is outside the project", ErrInvalidConvention, pkg)
+		}
+		if !slices.Contains(packages, pkg) {
+			packages = append(packages, pkg)
+			keys = append(keys, "Package:"+pkg)
+		}
+	}
+	if err := checkKeys(ctx, q, labels, project, keys, ErrInvalidConvention); err != nil {
+		return Convention{}, err
+	}
+
+	now := time.Now().UTC()
+	convention.ID, convention.CreatedAt, convention.Packages = newConventionID(now), now, packages
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		CREATE (:%s:%s {id: $id, category: $category, rule: $rule, rationale: $rationale, example: $example,
+		        packages: $packages, namespace: $namespace, createdAt: $createdAt})
+	`, project, labels.Label("Convention")), map[string]any{
+		"id": convention.ID, "category": convention.Category, "rule": convention.Rule, "rationale": convention.Rationale,
+		"example": convention.Example, "packages": packages, "namespace": convention.Namespace, "createdAt": now,
+	})
+	if err != nil {
+		return Convention{}, err
+	}
+	links := make([]nodeLink, len(keys))
+	for i, key := range keys {
+		links[i] = nodeLink{convention.ID, key}
+	}
+	return convention, linkNodes(ctx, q, labels, project, "Convention", "APPLIES_TO", links)
+}
+
+// cypherConventions lists the conventions matching cq, by category and
+// then oldest first
+func cypherConventions(ctx context.Context, q Querier, labels LabelMap, project string, cq ConventionQuery) ([]Convention, error) {
+	limit := ""
+	if cq.Limit > 0 {
+		limit = fmt.Sprintf("LIMIT %d", cq.Limit)
+	}
+	if cq.Package != "" {
+		cq.Package = path.Clean(cq.Package)
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (c:%s:%s)
+		WHERE ($package = '' OR size(c.packages) = 0 OR any(p IN c.packages WHERE $package = p OR $package STARTS WITH p + '/'))
+		  AND ($category = '' OR c.category = $category)
+		  AND ($anyNamespace OR coalesce(c.namespace, '') = '' OR c.namespace IN $namespaces)
+		RETURN c.id AS id, c.category AS category, c.rule AS rule, c.rationale AS rationale, c.example AS example,
+		       c.packages AS packages, c.namespace AS namespace, c.createdAt AS createdAt
+		ORDER BY c.category, c.id
+		%s
+	`, project, labels.Label("Convention"), limit), map[string]any{
+		"package": cq.Package, "category": strings.ToLower(cq.Category),
+		"anyNamespace": cq.Namespaces == nil, "namespaces": nonNil(cq.Namespaces),
+	})
+	if err != nil {
+		return nil, err
+	}
+	conventions := make([]Convention, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 8 {
+			continue
+		}
+		rationale, _ := row[3].(string)
+		example, _ := row[4].(string)
+		namespace, _ := row[6].(string)
+		conventions = append(conventions, Convention{
+			ID:        fmt.Sprint(row[0]),
+			Category:  fmt.Sprint(row[1]),
+			Rule:      fmt.Sprint(row[2]),
+			Rationale: rationale,
+			Example:   example,
+			Packages:  stringList(row[5]),
+			Namespace: namespace,
+			CreatedAt: timeValue(row[7]),
+		})
+	}
+	return conventions, nil
+}
+
+// cypherRemoveConvention deletes the convention with the given ID
+func cypherRemoveConvention(ctx context.Context, q Querier, labels LabelMap, project, id string) (bool, error) {
+	n, err := countAndDelete(ctx, q, fmt.Sprintf(`MATCH (c:%s:%s {id: $id})`, project, labels.Label("Convention")), `DETACH DELETE c`, map[string]any{"id": id})
+	return n > 0, err
+}
@@ -0,0 +1,109 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCypherAddConvention(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasSuffix(query, "AS count") {
+			if params["path"] == "store" {
+				return [][]any{{int64(1)}}
+			}
+			return [][]any{{int64(0)}}
+		}
+		return nil
+	}}
+	convention, err := cypherAddConvention(context.Background(), q, LabelMap{}, "App", Convention{
+		Category:  "Error-Handling",
+		Rule:      "wrap errors with %w and the operation that failed",
+		Rationale: "callers match them with errors.Is",
+		Packages:  []string{"Package:store", "./store"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(convention.ID, "con-") || convention.Category != "error-handling" || !reflect.DeepEqual(convention.Packages, []string{"store"}) {
+		t.Errorf("convention = %+v", convention)
+	}
+	var created, linked bool
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "CREATE (:App:Convention {"):
+			created = q.params[i]["category"] == "error-handling" && reflect.DeepEqual(q.params[i]["packages"], []string{"store"})
+		case strings.HasSuffix(query, "MERGE (m)-[:APPLIES_TO]->(n)"):
+			linked = strings.Contains(query, "MATCH (n:App:Package {path: row.path})")
+		}
+	}
+	if !created || !linked {
+		t.Errorf("created %v, linked %v in %q", created, linked, q.queries)
+	}
+
+	// A convention for the whole project links to nothing
+	if convention, err := cypherAddConvention(context.Background(), q, LabelMap{}, "App", Convention{Rule: "log with slog"}); err != nil ||
+		convention.Category != "general" || convention.Packages == nil {
+		t.Errorf("project-wide convention = %+v, %v", convention, err)
+	}
+
+	for _, invalid := range []Convention{
+		{Packages: []string{"store"}},
+		{Rule: "x", Category: "tabs"},
+		{Rule: "x", Packages: []string{"../lib"}},
+	} {
+		if _, err := cypherAddConvention(context.Background(), q, LabelMap{}, "App", invalid); !errors.Is(err, ErrInvalidConvention) {
+			t.Errorf("%+v: err = %v, want ErrInvalidConvention", invalid, err)
+		}
+	}
+	if _, err := cypherAddConvention(context.Background(), q, LabelMap{}, "App", Convention{Rule: "x", Packages: []string{"cmd"}}); !errors.Is(err, ErrNoNode) {
+		t.Errorf("unknown package: err = %v, want ErrNoNode", err)
+	}
+}
+
+func TestCypherConventions(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		return [][]any{
+			{"con-2", "logging", "log with slog", nil, nil, []any{}, nil, created},
+			{"con-1", "naming", "name tests after the function", "", "TestCypherAddConvention", []any{"store"}, "user:alice", created},
+		}
+	}}
+	conventions, err := cypherConventions(context.Background(), q, LabelMap{}, "App", ConventionQuery{Package: "store/./sync", Category: "Naming", Namespaces: []string{"user:alice"}, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Convention{
+		{ID: "con-2", Category: "logging", Rule: "log with slog", Packages: []string{}, CreatedAt: created},
+		{ID: "con-1", Category: "naming", Rule: "name tests after the function", Example: "TestCypherAddConvention", Packages: []string{"store"},
+			Namespace: "user:alice", CreatedAt: created},
+	}
+	if !reflect.DeepEqual(conventions, want) {
+		t.Errorf("conventions = %+v, want %+v", conventions, want)
+	}
+	params := q.params[0]
+	if params["package"] != "store/sync" || params["category"] != "naming" || params["anyNamespace"] != false || !strings.Contains(q.queries[0], "LIMIT 5") {
+		t.Errorf("listed with %v by %q", params, q.queries[0])
+	}
+}
+
+func TestCypherRemoveConvention(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if params["id"] == "con-1" {
+			return [][]any{{int64(1)}}
+		}
+		return [][]any{{int64(0)}}
+	}}
+	if found, err := cypherRemoveConvention(context.Background(), q, LabelMap{}, "App", "con-1"); !found || err != nil {
+		t.Errorf("removing con-1 = %v, %v", found, err)
+	}
+	if !strings.HasSuffix(q.queries[len(q.queries)-1], "DETACH DELETE c") {
+		t.Errorf("removed by %q", q.queries)
+	}
+	if found, err := cypherRemoveConvention(context.Background(), q, LabelMap{}, "App", "con-9"); found || err != nil {
+		t.Errorf("removing con-9 = %v, %v", found, err)
+	}
+}
@@ -0,0 +1,170 @@
+package codegraph
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Coverage is how much of a function the tests of a coverage profile ran:
+// its statements, those run, and the line ranges of those never run, as
+// "12-15", or "12" for one line
+type Coverage struct {
+	Statements int      `json:"statements"`
+	Covered    int      `json:"covered"`
+	Uncovered  []string `json:"uncovered,omitempty"`
+}
+
+// Percent returns the share of the statements run, from 0 to 100 to one
+// decimal place; a function without statements has nothing left to run
+func (c Coverage) Percent() float64 {
+	if c.Statements == 0 {
+		return 100
+	}
+	return math.Round(float64(c.Covered)/float64(c.Statements)*1000) / 10
+}
+
+// coverBlock is one block of a coverage profile: the statements from
+// StartLine to EndLine and how many times they ran
+type coverBlock struct {
+	StartLine, EndLine int
+	Statements, Count  int
+}
+
+// AddCoverage reads a profile written by go test -coverprofile and records
+// the coverage of every function in the files it profiles. Profiles name
+// files by import path, which is matched to the graph's files by its
+// longest suffix that is one of them. A block counted in several test
+// binaries, as with -coverpkg, is run if any of them ran it.
+func AddCoverage(graph *Graph, profile io.Reader) error {
+	blocks, err := readCoverProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	paths := make(map[string]bool, len(graph.Files))
+	for _, file := range graph.Files {
+		paths[file.Path] = true
+	}
+	byFile := make(map[string][]coverBlock)
+	for name, fileBlocks := range blocks {
+		for rest := name; rest != ""; {
+			if paths[rest] {
+				byFile[rest] = append(byFile[rest], fileBlocks...)
+				break
+			}
+			_, rest, _ = strings.Cut(rest, "/")
+		}
+	}
+	if len(blocks) > 0 && len(byFile) == 0 {
+		return errors.New("the coverage profile covers none of the project's files")
+	}
+
+	graph.Coverage = make(map[string]Coverage)
+	for _, fn := range graph.Functions {
+		fileBlocks, ok := byFile[fn.File]
+		if !ok {
+			continue
+		}
+		var c Coverage
+		var uncovered []coverBlock
+		for _, b := range fileBlocks {
+			if b.StartLine < fn.LineStart || b.EndLine > fn.LineEnd {
+				continue
+			}
+			c.Statements += b.Statements
+			if b.Count > 0 {
+				c.Covered += b.Statements
+			} else {
+				uncovered = append(uncovered, b)
+			}
+		}
+		c.Uncovered = lineRanges(uncovered)
+		graph.Coverage[fn.Key()] = c
+	}
+	return nil
+}
+
+// readCoverProfile returns the blocks of a coverage profile by file name,
+// merging those listed more than once
+func readCoverProfile(profile io.Reader) (map[string][]coverBlock, error) {
+	scanner := bufio.NewScanner(profile)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "mode: ") {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("not a coverage profile: the first line is not mode: set, count or atomic")
+	}
+
+	type position struct {
+		file                                 string
+		startLine, startCol, endLine, endCol int
+	}
+	counts := make(map[position]coverBlock)
+	var order []position
+	line := 1
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		// name.go:line.column,line.column statements count
+		i := strings.LastIndex(text, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("coverage profile line %d: no file name", line)
+		}
+		var p position
+		var b coverBlock
+		p.file = text[:i]
+		_, err := fmt.Sscanf(text[i+1:], "%d.%d,%d.%d %d %d", &p.startLine, &p.startCol, &p.endLine, &p.endCol, &b.Statements, &b.Count)
+		if err != nil {
+			return nil, fmt.Errorf("coverage profile line %d: %w", line, err)
+		}
+		b.StartLine, b.EndLine = p.startLine, p.endLine
+		if seen, ok := counts[p]; ok {
+			b.Count = max(b.Count, seen.Count)
+		} else {
+			order = append(order, p)
+		}
+		counts[p] = b
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[string][]coverBlock)
+	for _, p := range order {
+		blocks[p.file] = append(blocks[p.file], counts[p])
+	}
+	return blocks, nil
+}
+
+// lineRanges merges the lines of blocks into ranges, sorted, joining those
+// that overlap or touch
+func lineRanges(blocks []coverBlock) []string {
+	slices.SortFunc(blocks, func(a, b coverBlock) int { return cmp.Compare(a.StartLine, b.StartLine) })
+	var merged [][2]int
+	for _, b := range blocks {
+		if n := len(merged); n > 0 && b.StartLine <= merged[n-1][1]+1 {
+			merged[n-1][1] = max(merged[n-1][1], b.EndLine)
+			continue
+		}
+		merged = append(merged, [2]int{b.StartLine, b.EndLine})
+	}
+	var ranges []string
+	for _, r := range merged {
+		if r[0] == r[1] {
+			ranges = append(ranges, strconv.Itoa(r[0]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,55 @@
+package codegraph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddCoverage(t *testing.T) {
+	graph := &Graph{
+		Files: []FileNode{{Path: "main.go"}, {Path: "store/store.go"}, {Path: "cmd/tool/tool.go"}},
+		Functions: []FunctionNode{
+			{Name: "main", File: "main.go", LineStart: 5, LineEnd: 9},
+			{Name: "New", File: "store/store.go", LineStart: 3, LineEnd: 5},
+			{Name: "Put", Receiver: "*Store", File: "store/store.go", LineStart: 7, LineEnd: 20},
+			{Name: "empty", File: "store/store.go", LineStart: 22, LineEnd: 22},
+			{Name: "run", File: "cmd/tool/tool.go", LineStart: 3, LineEnd: 6},
+		},
+	}
+	// Two test binaries profile store.go, one of them running line 12
+	profile := `mode: set
+example.com/app/main.go:5.13,8.2 3 1
+example.com/app/main.go:8.2,8.10 1 0
+example.com/app/store/store.go:3.20,5.2 1 0
+example.com/app/store/store.go:7.30,9.16 2 1
+example.com/app/store/store.go:9.16,11.3 1 0
+example.com/app/store/store.go:12.2,12.20 1 0
+example.com/app/store/store.go:14.2,16.3 2 0
+example.com/app/store/store.go:18.2,20.2 1 1
+example.com/app/store/store.go:12.2,12.20 1 1
+`
+	if err := AddCoverage(graph, strings.NewReader(profile)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Coverage{
+		"Function:main.go:main":              {Statements: 4, Covered: 3, Uncovered: []string{"8"}},
+		"Function:store/store.go:New":        {Statements: 1, Covered: 0, Uncovered: []string{"3-5"}},
+		"Function:store/store.go:*Store.Put": {Statements: 7, Covered: 4, Uncovered: []string{"9-11", "14-16"}},
+		"Function:store/store.go:empty":      {},
+	}
+	if !reflect.DeepEqual(graph.Coverage, want) {
+		t.Errorf("coverage = %+v, want %+v", graph.Coverage, want)
+	}
+	for key, percent := range map[string]float64{"Function:main.go:main": 75, "Function:store/store.go:*Store.Put": 57.1, "Function:store/store.go:empty": 100} {
+		if got := graph.Coverage[key].Percent(); got != percent {
+			t.Errorf("%s covered %v%%, want %v%%", key, got, percent)
+		}
+	}
+
+	for _, profile := range []string{"", "main.go:5.13,8.2 3 1\n", "mode: set\nmain.go:5.13 3 1\n", "mode: set\nother.com/x/y.go:1.1,2.2 1 1\n"} {
+		if err := AddCoverage(graph, strings.NewReader(profile)); err == nil {
+			t.Errorf("%q: no error", profile)
+		}
+	}
+}
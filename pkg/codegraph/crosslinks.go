@@ -0,0 +1,341 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CrossLinker is implemented by backends that keep several projects in one
+// database and can link nodes across them: a service's files importing a
+// shared library's packages, or a client calling another service's
+// handler. Memories about the nodes linked to can then be recalled from
+// any project linking to them, with RecallLinked.
+type CrossLinker interface {
+	// LinkProjects stores link, assigning its ID and CreatedAt, and
+	// creates its relationship; both nodes must exist. Storing the same
+	// link again keeps the first.
+	LinkProjects(ctx context.Context, link CrossLink) (CrossLink, error)
+	// LinkImports links the files of project importing packages of
+	// library, whose Go module path is module, by IMPORTS links, and
+	// returns them
+	LinkImports(ctx context.Context, project, library, module string) ([]CrossLink, error)
+	// CrossLinks lists the links from or to the project's nodes
+	CrossLinks(ctx context.Context, project string) ([]CrossLink, error)
+	// Unlink deletes the link with the given ID from or to the project,
+	// and its relationship, reporting whether there was one
+	Unlink(ctx context.Context, project, id string) (bool, error)
+}
+
+// CrossLink is a relationship of Type from the node keyed From in
+// FromProject to the node keyed To in ToProject. It is stored as a
+// CrossLink node of FromProject, so the relationship is made again after
+// either project is re-indexed.
+type CrossLink struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	FromProject string    `json:"fromProject"`
+	From        string    `json:"from"`
+	ToProject   string    `json:"toProject"`
+	To          string    `json:"to"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// CrossLinkTypes are the relationship types a cross-project link can have,
+// the first being the default
+var CrossLinkTypes = []string{"DEPENDS_ON", "IMPORTS", "CALLS"}
+
+// ErrInvalidLink is returned for a link with an unknown type, an invalid
+// project name or key, or from a project to itself
+var ErrInvalidLink = errors.New("invalid link")
+
+// crossLinkID returns the ID of a link, the same for the same link
+func crossLinkID(link CrossLink) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{link.Type, link.FromProject, link.From, link.ToProject, link.To}, "\x00")))
+	return "lnk-" + hex.EncodeToString(sum[:6])
+}
+
+// checkCrossLink fills in link's default type and ID, and returns
+// ErrInvalidLink if it cannot be stored
+func checkCrossLink(link CrossLink) (CrossLink, error) {
+	link.Type = strings.ToUpper(cmp.Or(link.Type, CrossLinkTypes[0]))
+	if !slices.Contains(CrossLinkTypes, link.Type) {
+		return CrossLink{}, fmt.Errorf("%w: unknown type %q, expected one of %s", ErrInvalidLink, link.Type, strings.Join(CrossLinkTypes, ", "))
+	}
+	for _, project := range []string{link.FromProject, link.ToProject} {
+		if !IdentPattern.MatchString(project) {
+			return CrossLink{}, fmt.Errorf("%w: invalid project name %q", ErrInvalidLink, project)
+		}
+	}
+	if link.FromProject == link.ToProject {
+		return CrossLink{}, fmt.Errorf("%w: %s links to itself, link projects apart", ErrInvalidLink, link.FromProject)
+	}
+	for _, key := range []string{link.From, link.To} {
+		if _, _, err := ParseKey(key); err != nil {
+			return CrossLink{}, fmt.Errorf("%w: %w", ErrInvalidLink, err)
+		}
+	}
+	link.ID = crossLinkID(link)
+	return link, nil
+}
+
+// cypherLinkProjects checks both nodes of link exist, then stores it
+func cypherLinkProjects(ctx context.Context, q Querier, labels LabelMap, link CrossLink) (CrossLink, error) {
+	link, err := checkCrossLink(link)
+	if err != nil {
+		return CrossLink{}, err
+	}
+	if err := checkKeys(ctx, q, labels, link.FromProject, []string{link.From}, ErrInvalidLink); err != nil {
+		return CrossLink{}, err
+	}
+	if err := checkKeys(ctx, q, labels, link.ToProject, []string{link.To}, ErrInvalidLink); err != nil {
+		return CrossLink{}, err
+	}
+	links, err := storeCrossLinks(ctx, q, labels, []CrossLink{link})
+	if err != nil {
+		return CrossLink{}, err
+	}
+	return links[0], nil
+}
+
+// cypherLinkImports stores an IMPORTS link from each file of project to
+// each package of library it imports by module path
+func cypherLinkImports(ctx context.Context, q Querier, labels LabelMap, project, library, module string) ([]CrossLink, error) {
+	module = strings.TrimSuffix(module, "/")
+	if module == "" {
+		return nil, fmt.Errorf("%w: no module path", ErrInvalidLink)
+	}
+	if _, err := checkCrossLink(CrossLink{FromProject: project, From: "Package:.", ToProject: library, To: "Package:."}); err != nil {
+		return nil, err
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (f:%s:%s) WHERE f.imports IS NOT NULL AND NOT coalesce(f.deleted, false)
+		UNWIND f.imports AS import
+		MATCH (p:%s:%s) WHERE NOT coalesce(p.deleted, false)
+		  AND (import = $module + '/' + p.path OR p.path = '.' AND import = $module)
+		RETURN DISTINCT f.path AS file, p.path AS package
+		ORDER BY file, package
+	`, project, labels.Label("File"), library, labels.Label("Package")), map[string]any{"module": module})
+	if err != nil {
+		return nil, err
+	}
+	links := make([]CrossLink, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		link, err := checkCrossLink(CrossLink{
+			Type: "IMPORTS", FromProject: project, From: "File:" + fmt.Sprint(row[0]),
+			ToProject: library, To: "Package:" + fmt.Sprint(row[1]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return storeCrossLinks(ctx, q, labels, links)
+}
+
+// storeCrossLinks merges a CrossLink node for each link, keeping the time
+// of one already stored, then creates their relationships
+func storeCrossLinks(ctx context.Context, q Querier, labels LabelMap, links []CrossLink) ([]CrossLink, error) {
+	now := time.Now().UTC()
+	for i, link := range links {
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MERGE (l:%s:%s {id: $id})
+			ON CREATE SET l.type = $type, l.fromProject = $fromProject, l.from = $from,
+			              l.toProject = $toProject, l.to = $to, l.createdAt = $now
+			RETURN l.createdAt AS createdAt
+		`, link.FromProject, labels.Label("CrossLink")), map[string]any{
+			"id": link.ID, "type": link.Type, "fromProject": link.FromProject, "from": link.From,
+			"toProject": link.ToProject, "to": link.To, "now": now,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("storing link %s: %w", link.ID, err)
+		}
+		links[i].CreatedAt = now
+		if len(rows) > 0 && len(rows[0]) > 0 {
+			links[i].CreatedAt = timeValue(rows[0][0])
+		}
+	}
+	return links, mergeCrossLinks(ctx, q, labels, links)
+}
+
+// mergeCrossLinks merges the relationship of each link whose nodes exist,
+// with one UNWIND statement per pair of projects, kinds and type
+func mergeCrossLinks(ctx context.Context, q Querier, labels LabelMap, links []CrossLink) error {
+	type group struct{ fromProject, fromKind, toProject, toKind, rel string }
+	rows := make(map[group][]any)
+	for _, link := range links {
+		fromKind, from, err := ParseKey(link.From)
+		if err != nil {
+			continue
+		}
+		toKind, to, err := ParseKey(link.To)
+		if err != nil {
+			continue
+		}
+		g := group{link.FromProject, fromKind, link.ToProject, toKind, link.Type}
+		rows[g] = append(rows[g], map[string]any{"from": from, "to": to})
+	}
+	groups := slices.SortedFunc(maps.Keys(rows), func(a, b group) int {
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	})
+	for _, g := range groups {
+		_, _, err := q.Query(ctx, fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (a:%s:%s %s)
+			MATCH (b:%s:%s %s)
+			MERGE (a)-[:%s]->(b)
+		`, g.fromProject, labels.Label(g.fromKind), keyPattern(g.fromKind, "row.from."),
+			g.toProject, labels.Label(g.toKind), keyPattern(g.toKind, "row.to."), g.rel), map[string]any{"rows": rows[g]})
+		if err != nil {
+			return fmt.Errorf("linking %s:%s nodes to %s:%s nodes: %w", g.fromProject, g.fromKind, g.toProject, g.toKind, err)
+		}
+	}
+	return nil
+}
+
+// cypherCrossLinks lists the links from or to the project's nodes, by ID
+func cypherCrossLinks(ctx context.Context, q Querier, labels LabelMap, project string) ([]CrossLink, error) {
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (l:%s) WHERE l.fromProject = $project OR l.toProject = $project
+		RETURN l.id AS id, l.type AS type, l.fromProject AS fromProject, l.from AS from,
+		       l.toProject AS toProject, l.to AS to, l.createdAt AS createdAt
+		ORDER BY id
+	`, labels.Label("CrossLink")), map[string]any{"project": project})
+	if err != nil {
+		return nil, err
+	}
+	links := make([]CrossLink, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 7 {
+			continue
+		}
+		links = append(links, CrossLink{
+			ID:          fmt.Sprint(row[0]),
+			Type:        fmt.Sprint(row[1]),
+			FromProject: fmt.Sprint(row[2]),
+			From:        fmt.Sprint(row[3]),
+			ToProject:   fmt.Sprint(row[4]),
+			To:          fmt.Sprint(row[5]),
+			CreatedAt:   timeValue(row[6]),
+		})
+	}
+	return links, nil
+}
+
+// cypherUnlink deletes the link id from or to the project and the
+// relationship it made
+func cypherUnlink(ctx context.Context, q Querier, labels LabelMap, project, id string) (bool, error) {
+	links, err := cypherCrossLinks(ctx, q, labels, project)
+	if err != nil {
+		return false, err
+	}
+	i := slices.IndexFunc(links, func(link CrossLink) bool { return link.ID == id })
+	if i < 0 {
+		return false, nil
+	}
+	link := links[i]
+	fromKind, from, err := ParseKey(link.From)
+	if err != nil {
+		return false, err
+	}
+	toKind, to, err := ParseKey(link.To)
+	if err != nil {
+		return false, err
+	}
+	params := map[string]any{"id": id}
+	for prop, value := range from {
+		params["from_"+prop] = value
+	}
+	for prop, value := range to {
+		params["to_"+prop] = value
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		MATCH (a:%s:%s %s)-[r:%s]->(b:%s:%s %s)
+		DELETE r
+	`, link.FromProject, labels.Label(fromKind), keyPattern(fromKind, "$from_"), link.Type,
+		link.ToProject, labels.Label(toKind), keyPattern(toKind, "$to_")), params)
+	if err != nil {
+		return false, err
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		MATCH (l:%s:%s {id: $id})
+		DELETE l
+	`, link.FromProject, labels.Label("CrossLink")), params)
+	return err == nil, err
+}
+
+// relinkCrossLinks creates again the relationships of the links from or
+// to the project, after a write has replaced its nodes
+func relinkCrossLinks(ctx context.Context, q Querier, labels LabelMap, project string) error {
+	links, err := cypherCrossLinks(ctx, q, labels, project)
+	if err != nil {
+		return err
+	}
+	return mergeCrossLinks(ctx, q, labels, links)
+}
+
+// RecallLinked recalls the project's memories matching mq, then adds the
+// memories of the projects it links to that are about the nodes linked
+// to, or what holds or is held by them, with their Project set. With
+// mq.About or mq.File, only the links from that node, or from what holds
+// or is held by it, are followed. Memories recalled through links are
+// read without recording an access, and all of them are then ranked, or
+// matched to mq.Question, and limited together.
+func RecallLinked(ctx context.Context, m MemoryStore, links []CrossLink, project string, mq MemoryQuery) ([]Memory, error) {
+	memories, err := m.Recall(ctx, project, mq)
+	if err != nil {
+		return nil, err
+	}
+	from := mq.About
+	if mq.File != "" {
+		from = "File:" + mq.File
+	}
+	targets := make(map[string][]string)
+	for _, link := range links {
+		if link.FromProject == project && (from == "" || encloses(from, link.From) || encloses(link.From, from)) {
+			targets[link.ToProject] = append(targets[link.ToProject], link.To)
+		}
+	}
+	for _, linked := range slices.Sorted(maps.Keys(targets)) {
+		lq := mq
+		lq.About, lq.File, lq.Limit, lq.Peek, lq.Ranking, lq.Question = "", "", 0, true, nil, ""
+		found, err := m.Recall(ctx, linked, lq)
+		if err != nil {
+			return nil, fmt.Errorf("recalling from %s: %w", linked, err)
+		}
+		for _, memory := range found {
+			if slices.ContainsFunc(memory.About, func(key string) bool {
+				return slices.ContainsFunc(targets[linked], func(target string) bool { return encloses(key, target) || encloses(target, key) })
+			}) {
+				memory.Project = linked
+				memories = append(memories, memory)
+			}
+		}
+	}
+	if mq.Ranking != nil {
+		mq.Ranking.Rank(memories, time.Now().UTC())
+	}
+	if mq.Question != "" {
+		memories = matchQuestion(memories, mq.Question)
+	}
+	if mq.Limit > 0 && len(memories) > mq.Limit {
+		memories = memories[:mq.Limit]
+	}
+	return memories, nil
+}
+
+// encloses reports whether the node keyed outer is the one keyed inner or
+// the file or package holding it
+func encloses(outer, inner string) bool {
+	return slices.Contains(EnclosingKeys(inner), outer)
+}
@@ -0,0 +1,180 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckCrossLink(t *testing.T) {
+	link, err := checkCrossLink(CrossLink{FromProject: "Api", From: "File:cmd/main.go", ToProject: "Lib", To: "Package:log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Type != "DEPENDS_ON" || !strings.HasPrefix(link.ID, "lnk-") {
+		t.Errorf("link = %+v", link)
+	}
+	again, _ := checkCrossLink(CrossLink{Type: "depends_on", FromProject: "Api", From: "File:cmd/main.go", ToProject: "Lib", To: "Package:log"})
+	if again.ID != link.ID {
+		t.Errorf("the same link has IDs %s and %s", link.ID, again.ID)
+	}
+	other, _ := checkCrossLink(CrossLink{Type: "IMPORTS", FromProject: "Api", From: "File:cmd/main.go", ToProject: "Lib", To: "Package:log"})
+	if other.ID == link.ID {
+		t.Errorf("links of different types share ID %s", link.ID)
+	}
+
+	for _, invalid := range []CrossLink{
+		{Type: "OWNS", FromProject: "Api", From: "File:main.go", ToProject: "Lib", To: "Package:log"},
+		{FromProject: "Api", From: "File:main.go", ToProject: "Lib) DETACH DELETE (n", To: "Package:log"},
+		{FromProject: "Api", From: "File:main.go", ToProject: "Api", To: "Package:log"},
+		{FromProject: "Api", From: "main.go", ToProject: "Lib", To: "Package:log"},
+	} {
+		if _, err := checkCrossLink(invalid); !errors.Is(err, ErrInvalidLink) {
+			t.Errorf("%+v: err = %v, want ErrInvalidLink", invalid, err)
+		}
+	}
+}
+
+func TestCypherLinkProjects(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasSuffix(query, "AS count") {
+			if params["path"] == "gone.go" {
+				return [][]any{{int64(0)}}
+			}
+			return [][]any{{int64(1)}}
+		}
+		return nil
+	}}
+	link, err := cypherLinkProjects(context.Background(), q, LabelMap{}, CrossLink{
+		Type: "CALLS", FromProject: "Api", From: "Function:client.go:Charge", ToProject: "Billing", To: "Function:handler.go:*Server.Charge",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.CreatedAt.IsZero() {
+		t.Errorf("link = %+v", link)
+	}
+	var stored, merged bool
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "MERGE (l:Api:CrossLink {id: $id})"):
+			stored = q.params[i]["id"] == link.ID && q.params[i]["toProject"] == "Billing"
+		case strings.HasSuffix(query, "MERGE (a)-[:CALLS]->(b)"):
+			merged = strings.Contains(query, "MATCH (a:Api:Function {file: row.from.file, name: row.from.name, receiver: row.from.receiver})") &&
+				strings.Contains(query, "MATCH (b:Billing:Method {file: row.to.file, name: row.to.name, receiver: row.to.receiver})")
+		}
+	}
+	if !stored || !merged {
+		t.Errorf("stored %v, merged %v in %q", stored, merged, q.queries)
+	}
+
+	_, err = cypherLinkProjects(context.Background(), q, LabelMap{}, CrossLink{FromProject: "Api", From: "File:gone.go", ToProject: "Lib", To: "Package:log"})
+	if !errors.Is(err, ErrNoNode) {
+		t.Errorf("missing node: err = %v, want ErrNoNode", err)
+	}
+}
+
+func TestCypherLinkImports(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.Contains(query, "UNWIND f.imports AS import") {
+			return [][]any{{"cmd/main.go", "."}, {"cmd/main.go", "log"}}
+		}
+		return nil
+	}}
+	links, err := cypherLinkImports(context.Background(), q, LabelMap{}, "Api", "Lib", "example.com/lib/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, link := range links {
+		got = append(got, link.Type+" "+link.From+" "+link.To)
+	}
+	if want := []string{"IMPORTS File:cmd/main.go Package:.", "IMPORTS File:cmd/main.go Package:log"}; !slices.Equal(got, want) {
+		t.Errorf("linked %q, want %q", got, want)
+	}
+	if q.params[0]["module"] != "example.com/lib" || !strings.Contains(q.queries[0], "MATCH (p:Lib:Package)") {
+		t.Errorf("imports read by %q with %v", q.queries[0], q.params[0])
+	}
+	if !strings.HasSuffix(q.queries[len(q.queries)-1], "MERGE (a)-[:IMPORTS]->(b)") || len(q.params[len(q.params)-1]["rows"].([]any)) != 2 {
+		t.Errorf("last query %q", q.queries[len(q.queries)-1])
+	}
+
+	if _, err := cypherLinkImports(context.Background(), q, LabelMap{}, "Api", "Lib", ""); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("no module: err = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestCypherUnlink(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasPrefix(query, "MATCH (l:CrossLink)") {
+			return [][]any{{"lnk-1", "IMPORTS", "Api", "File:cmd/main.go", "Lib", "Package:log", nil}}
+		}
+		return nil
+	}}
+	found, err := cypherUnlink(context.Background(), q, LabelMap{}, "Lib", "lnk-1")
+	if err != nil || !found {
+		t.Fatalf("unlinked %v, %v", found, err)
+	}
+	if len(q.queries) != 3 || !strings.Contains(q.queries[1], "MATCH (a:Api:File {path: $from_path})-[r:IMPORTS]->(b:Lib:Package {path: $to_path})") ||
+		q.params[1]["from_path"] != "cmd/main.go" || !strings.HasPrefix(q.queries[2], "MATCH (l:Api:CrossLink {id: $id})") {
+		t.Errorf("queries %q with %v", q.queries, q.params)
+	}
+	if found, err := cypherUnlink(context.Background(), q, LabelMap{}, "Lib", "lnk-2"); err != nil || found {
+		t.Errorf("unlinked lnk-2: %v, %v", found, err)
+	}
+}
+
+// projectMemoryStore keeps the memories of each project in their own
+// fakeMemoryStore
+type projectMemoryStore struct {
+	*fakeMemoryStore
+	projects map[string]*fakeMemoryStore
+}
+
+func (p projectMemoryStore) Recall(ctx context.Context, project string, q MemoryQuery) ([]Memory, error) {
+	return p.projects[project].Recall(ctx, project, q)
+}
+
+func TestRecallLinked(t *testing.T) {
+	api := &fakeMemoryStore{memories: []Memory{{ID: "mem-a1", Text: "main logs through lib", About: []string{"File:cmd/main.go"}}}}
+	lib := &fakeMemoryStore{memories: []Memory{
+		{ID: "mem-l3", Text: "Printf is not safe for concurrent use", About: []string{"Function:log/log.go:Printf"}},
+		{ID: "mem-l2", Text: "the store is slow", About: []string{"Package:store"}},
+		{ID: "mem-l1", Text: "log writes to stderr", About: []string{"Package:log"}},
+	}}
+	store := projectMemoryStore{projects: map[string]*fakeMemoryStore{"Api": api, "Lib": lib, "Web": {}}}
+	links := []CrossLink{
+		{Type: "IMPORTS", FromProject: "Api", From: "File:cmd/main.go", ToProject: "Lib", To: "Package:log"},
+		{Type: "IMPORTS", FromProject: "Web", From: "File:main.go", ToProject: "Lib", To: "Package:store"},
+	}
+	ids := func(memories []Memory) []string {
+		var ids []string
+		for _, m := range memories {
+			ids = append(ids, m.ID+"@"+m.Project)
+		}
+		return ids
+	}
+
+	memories, err := RecallLinked(context.Background(), store, links, "Api", MemoryQuery{About: "Function:cmd/main.go:run", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mem-a1@", "mem-l3@Lib"}; !slices.Equal(ids(memories), want) {
+		t.Errorf("recalled %q, want %q", ids(memories), want)
+	}
+	if want := (MemoryQuery{Peek: true}); !reflect.DeepEqual(lib.queries[0], want) {
+		t.Errorf("recalled from Lib with %+v, want %+v", lib.queries[0], want)
+	}
+
+	// Links from other nodes are not followed
+	memories, err = RecallLinked(context.Background(), store, links, "Api", MemoryQuery{File: "other.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mem-a1@"}; !slices.Equal(ids(memories), want) {
+		t.Errorf("recalled %q, want %q", ids(memories), want)
+	}
+}
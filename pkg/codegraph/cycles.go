@@ -0,0 +1,192 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CycleStore is implemented by backends that keep the dependency cycles
+// found between a project's packages, linked to the packages in them
+type CycleStore interface {
+	// ReplaceCycles deletes the project's cycles and stores cycles in their
+	// place, linking each to its packages
+	ReplaceCycles(ctx context.Context, project string, cycles []Cycle) error
+}
+
+// Cycle is a group of packages that each depend on the others, directly or
+// through the rest. The packages of an import cycle do so through imports
+// alone, which the compiler rejects. Those of a near cycle only do so once
+// type usage is counted too: a call resolved to another package's method,
+// or a struct implementing another package's interface. The compiler
+// allows that, but the packages can no longer change apart. Packages are
+// sorted, and Path is one loop through them from the first.
+type Cycle struct {
+	Kind     string   `json:"kind"`
+	Packages []string `json:"packages"`
+	Path     []string `json:"path"`
+}
+
+// The kinds of cycle Cycles reports
+const (
+	ImportCycle = "import"
+	NearCycle   = "near"
+)
+
+// Cycles finds the import cycles between the packages of g, and the near
+// cycles type usage adds to them, import cycles first and each kind by its
+// first package. A near cycle holding an import cycle is reported as well
+// when it holds more packages.
+func Cycles(g *Graph) []Cycle {
+	full := *g
+	full.Features = Features{"calls": true, "imports": true, "implements": true}
+
+	imports := make(map[string][]string)
+	for _, dep := range full.PackageDependencies() {
+		from, to := strings.TrimPrefix(dep.From, "Package:"), strings.TrimPrefix(dep.To, "Package:")
+		imports[from] = append(imports[from], to)
+	}
+	uses := make(map[string][]string, len(imports))
+	for pkg, deps := range imports {
+		uses[pkg] = slices.Clone(deps)
+	}
+	for _, rel := range append(full.Calls(), full.Implementations()...) {
+		from, to := keyPackagePath(rel.From), keyPackagePath(rel.To)
+		if from != to && !slices.Contains(uses[from], to) {
+			uses[from] = append(uses[from], to)
+		}
+	}
+
+	var cycles []Cycle
+	found := make(map[string]bool)
+	for _, members := range components(imports) {
+		found[strings.Join(members, "\x00")] = true
+		cycles = append(cycles, Cycle{Kind: ImportCycle, Packages: members, Path: loop(members, imports)})
+	}
+	for _, members := range components(uses) {
+		if !found[strings.Join(members, "\x00")] {
+			cycles = append(cycles, Cycle{Kind: NearCycle, Packages: members, Path: loop(members, uses)})
+		}
+	}
+	return cycles
+}
+
+// keyPackagePath returns the path of the package holding the node with
+// the given key
+func keyPackagePath(key string) string {
+	keys := EnclosingKeys(key)
+	return strings.TrimPrefix(keys[len(keys)-1], "Package:")
+}
+
+// components returns the strongly connected components of more than one
+// package in the dependency graph deps, each sorted, ordered by their first
+// package
+func components(deps map[string][]string) [][]string {
+	var (
+		index    = make(map[string]int)
+		low      = make(map[string]int)
+		onStack  = make(map[string]bool)
+		stack    []string
+		found    [][]string
+		strongly func(pkg string)
+	)
+	// Tarjan's algorithm
+	strongly = func(pkg string) {
+		index[pkg], low[pkg] = len(index), len(index)
+		stack = append(stack, pkg)
+		onStack[pkg] = true
+		for _, dep := range deps[pkg] {
+			if _, visited := index[dep]; !visited {
+				strongly(dep)
+				low[pkg] = min(low[pkg], low[dep])
+			} else if onStack[dep] {
+				low[pkg] = min(low[pkg], index[dep])
+			}
+		}
+		if low[pkg] != index[pkg] {
+			return
+		}
+		i := slices.Index(stack, pkg)
+		members := slices.Clone(stack[i:])
+		stack = stack[:i]
+		for _, member := range members {
+			onStack[member] = false
+		}
+		if len(members) > 1 {
+			slices.Sort(members)
+			found = append(found, members)
+		}
+	}
+	for _, pkg := range slices.Sorted(maps.Keys(deps)) {
+		if _, visited := index[pkg]; !visited {
+			strongly(pkg)
+		}
+	}
+	slices.SortFunc(found, func(a, b []string) int { return cmp.Compare(a[0], b[0]) })
+	return found
+}
+
+// loop returns the shortest path through deps from the first of members
+// back to it, staying among members
+func loop(members []string, deps map[string][]string) []string {
+	start := members[0]
+	previous := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, dep := range slices.Sorted(slices.Values(deps[pkg])) {
+			if !slices.Contains(members, dep) {
+				continue
+			}
+			if dep == start {
+				var path []string
+				for at := pkg; at != start; at = previous[at] {
+					path = append(path, at)
+				}
+				path = append(path, start)
+				slices.Reverse(path)
+				return append(path, start)
+			}
+			if _, seen := previous[dep]; !seen {
+				previous[dep] = pkg
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// cypherReplaceCycles deletes the project's cycles, then creates a Cycle
+// node for each of cycles and links it to its packages
+func cypherReplaceCycles(ctx context.Context, q Querier, labels LabelMap, project string, cycles []Cycle) error {
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (c:%s:%s)
+		DETACH DELETE c
+	`, project, labels.Label("Cycle")), nil)
+	if err != nil || len(cycles) == 0 {
+		return err
+	}
+
+	rows := make([]any, len(cycles))
+	var links []nodeLink
+	for i, cycle := range cycles {
+		id := "cycles:" + cycle.Kind + ":" + strings.Join(cycle.Packages, ",")
+		rows[i] = map[string]any{"id": id, "kind": cycle.Kind, "packages": cycle.Packages, "path": cycle.Path}
+		for _, pkg := range cycle.Packages {
+			links = append(links, nodeLink{id, "Package:" + pkg})
+		}
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		UNWIND $rows AS row
+		CREATE (:%s:%s {id: row.id, kind: row.kind, packages: row.packages, path: row.path, createdAt: $createdAt})
+	`, project, labels.Label("Cycle")), map[string]any{"rows": rows, "createdAt": time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return linkNodes(ctx, q, labels, project, "Cycle", "INCLUDES", links)
+}
@@ -0,0 +1,65 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCycles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a/a.go": "package a\n\nimport \"example.com/app/b\"\n\nfunc A() { b.B() }\n",
+		"b/b.go": "package b\n\nimport \"example.com/app/c\"\n\nfunc B() { c.C() }\n",
+		"c/c.go": "package c\n\nimport \"example.com/app/a\"\n\nfunc C() { a.A() }\n",
+		// d imports e, and e only calls back into d through an interface d
+		// implements
+		"d/d.go": `package d
+
+import "example.com/app/e"
+
+type Widget struct{}
+
+func (w *Widget) Frob() {}
+
+func D() { e.Run(&Widget{}) }
+`,
+		"e/e.go": `package e
+
+type Frobber interface {
+	Frob()
+}
+
+func Run(f Frobber) { f.Frob() }
+`,
+	})
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cycle{
+		{Kind: ImportCycle, Packages: []string{"a", "b", "c"}, Path: []string{"a", "b", "c", "a"}},
+		{Kind: NearCycle, Packages: []string{"d", "e"}, Path: []string{"d", "e", "d"}},
+	}
+	if cycles := Cycles(graph); !reflect.DeepEqual(cycles, want) {
+		t.Errorf("cycles = %+v, want %+v", cycles, want)
+	}
+}
+
+func TestCypherReplaceCycles(t *testing.T) {
+	q := &recordingQuerier{}
+	cycles := []Cycle{{Kind: ImportCycle, Packages: []string{"a", "b"}, Path: []string{"a", "b", "a"}}}
+	if err := cypherReplaceCycles(context.Background(), q, LabelMap{}, "App", cycles); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 3 || !strings.HasSuffix(strings.TrimSpace(q.queries[0]), "DETACH DELETE c") {
+		t.Fatalf("queries = %q", q.queries)
+	}
+	if row := q.params[1]["rows"].([]any)[0].(map[string]any); row["id"] != "cycles:import:a,b" || !reflect.DeepEqual(row["path"], []string{"a", "b", "a"}) {
+		t.Errorf("created %v", row)
+	}
+	want := []any{map[string]any{"id": "cycles:import:a,b", "path": "a"}, map[string]any{"id": "cycles:import:a,b", "path": "b"}}
+	if !strings.Contains(q.queries[2], "MERGE (m)-[:INCLUDES]->(n)") || !reflect.DeepEqual(q.params[2]["rows"], want) {
+		t.Errorf("linked by %q with %v", q.queries[2], q.params[2])
+	}
+}
@@ -0,0 +1,150 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FindingStore is implemented by backends that keep what analyses of a
+// project's code found, linked to the nodes flagged, so the graph can be
+// asked for dead code like for anything else
+type FindingStore interface {
+	// ReplaceFindings deletes the project's findings of the named analysis
+	// and stores findings in their place, linking each to its node
+	ReplaceFindings(ctx context.Context, project, analysis string, findings []Finding) error
+}
+
+// Finding is something an analysis found about the node with Key, such as
+// a function nothing calls
+type Finding struct {
+	Kind    string `json:"kind"`
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+
+	// Severity grades the finding, "warning" or "error", for the analyses
+	// that do
+	Severity string `json:"severity,omitempty"`
+}
+
+// The kinds of finding DeadCode reports
+const (
+	UnusedFunction = "unused-function"
+	UnusedExport   = "unused-export"
+)
+
+// testEntryPrefixes start the names of the functions go test calls
+var testEntryPrefixes = []string{"Test", "Benchmark", "Fuzz", "Example"}
+
+// DeadCode finds the functions of g nothing else in it calls or refers to:
+// unexported ones, and those of package main, are unused, while exported
+// ones are unused inside the module but may be used outside it. Functions
+// are resolved like Graph.Calls, also counting the references of function
+// bodies and of package-level declarations. Methods are left out, as they
+// may satisfy interfaces outside the graph, as are init, main and the
+// functions go test runs. Findings are ordered by file and line.
+func DeadCode(g *Graph) []Finding {
+	packages := make(map[string]string)
+	resolved := withRefs(g)
+	resolved.Features = Features{"calls": true}
+	for _, file := range g.Files {
+		packages[file.Path] = file.Package
+		if len(file.Uses) > 0 {
+			// The package-level declarations of a file call like an
+			// unnamed function of it
+			resolved.Functions = append(resolved.Functions, FunctionNode{File: file.Path, Calls: file.Uses, Resolved: file.Resolved})
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, call := range resolved.Calls() {
+		if call.From != call.To {
+			used[call.To] = true
+		}
+	}
+
+	var findings []Finding
+	for _, fn := range g.Functions {
+		if fn.Receiver != "" || fn.Name == "_" || used[fn.Key()] || isEntryPoint(fn, packages[fn.File]) {
+			continue
+		}
+		finding := Finding{Kind: UnusedFunction, Key: fn.Key(), Name: fn.Name, File: fn.File, Line: fn.LineStart,
+			Message: fmt.Sprintf("%s is not called or referred to", fn.Name)}
+		if fn.IsExport && packages[fn.File] != "main" && !strings.HasSuffix(fn.File, "_test.go") {
+			finding.Kind = UnusedExport
+			finding.Message = fmt.Sprintf("%s is exported but not called or referred to inside the module", fn.Name)
+		}
+		findings = append(findings, finding)
+	}
+	slices.SortFunc(findings, func(a, b Finding) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return findings
+}
+
+// isEntryPoint reports whether fn, in package pkg, is called by the Go
+// runtime or go test rather than by code
+func isEntryPoint(fn FunctionNode, pkg string) bool {
+	switch {
+	case fn.Name == "init":
+		return true
+	case fn.Name == "main":
+		return pkg == "main"
+	case strings.HasSuffix(fn.File, "_test.go"):
+		return slices.ContainsFunc(testEntryPrefixes, func(prefix string) bool { return strings.HasPrefix(fn.Name, prefix) })
+	}
+	return false
+}
+
+// cypherReplaceFindings deletes the project's findings of analysis, then
+// creates a Finding node for each of findings and links it to the node it
+// flags
+func cypherReplaceFindings(ctx context.Context, q Querier, labels LabelMap, project, analysis string, findings []Finding) error {
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (f:%s:%s {analysis: $analysis})
+		DETACH DELETE f
+	`, project, labels.Label("Finding")), map[string]any{"analysis": analysis})
+	if err != nil || len(findings) == 0 {
+		return err
+	}
+
+	rows := make([]any, len(findings))
+	links := make([]nodeLink, len(findings))
+	seen := make(map[string]bool, len(findings))
+	for i, finding := range findings {
+		id := analysis + ":" + finding.Kind + ":" + finding.Key
+		if seen[id] {
+			// Another finding of the same node, such as a second call
+			// discarding an error, is told apart by its line
+			id += ":" + strconv.Itoa(finding.Line)
+		}
+		seen[id] = true
+		row := map[string]any{
+			"id": id, "kind": finding.Kind, "about": []string{finding.Key}, "name": finding.Name,
+			"file": finding.File, "line": finding.Line, "message": finding.Message, "severity": nil,
+		}
+		if finding.Severity != "" {
+			row["severity"] = finding.Severity
+		}
+		rows[i] = row
+		links[i] = nodeLink{id, finding.Key}
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		UNWIND $rows AS row
+		CREATE (:%s:%s {id: row.id, analysis: $analysis, kind: row.kind, about: row.about, name: row.name,
+		        file: row.file, line: row.line, message: row.message, severity: row.severity, createdAt: $createdAt})
+	`, project, labels.Label("Finding")), map[string]any{"rows": rows, "analysis": analysis, "createdAt": time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return linkNodes(ctx, q, labels, project, "Finding", "FLAGS", links)
+}
@@ -0,0 +1,110 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeadCode(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": `package main
+
+import "example.com/app/store"
+
+var commands = map[string]func(){"put": put}
+
+func main() {
+	store.New().Put("k")
+	recurse(1)
+}
+
+func put() { store.Open(helper) }
+
+func helper() {}
+
+func recurse(n int) { recurse(n - 1) }
+
+func orphan() { orphan() }
+
+func Exported() {}
+`,
+		"store/store.go": `package store
+
+type Store struct{}
+
+func New() *Store { return &Store{} }
+
+func Open(fn func()) {}
+
+func Unused() {}
+
+func unused() {}
+
+func (s *Store) Put(key string) {}
+
+func (s *Store) never() {}
+
+func init() {}
+`,
+	})
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Finding{
+		{Kind: UnusedFunction, Key: "Function:main.go:orphan", Name: "orphan", File: "main.go", Line: 18, Message: "orphan is not called or referred to"},
+		{Kind: UnusedFunction, Key: "Function:main.go:Exported", Name: "Exported", File: "main.go", Line: 20, Message: "Exported is not called or referred to"},
+		{Kind: UnusedExport, Key: "Function:store/store.go:Unused", Name: "Unused", File: "store/store.go", Line: 9,
+			Message: "Unused is exported but not called or referred to inside the module"},
+		{Kind: UnusedFunction, Key: "Function:store/store.go:unused", Name: "unused", File: "store/store.go", Line: 11, Message: "unused is not called or referred to"},
+	}
+	if findings := DeadCode(graph); !reflect.DeepEqual(findings, want) {
+		t.Errorf("findings = %+v, want %+v", findings, want)
+	}
+}
+
+func TestCypherReplaceFindings(t *testing.T) {
+	q := &recordingQuerier{}
+	findings := []Finding{
+		{Kind: UnusedFunction, Key: "Function:main.go:orphan", Name: "orphan", File: "main.go", Line: 18, Message: "orphan is not called"},
+		{Kind: GodFunction, Key: "Function:main.go:main", Name: "main", File: "main.go", Line: 3, Message: "main has 200 lines (limit 80)", Severity: SeverityError},
+	}
+	if err := cypherReplaceFindings(context.Background(), q, LabelMap{}, "App", "deadcode", findings); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 3 || !strings.HasSuffix(strings.TrimSpace(q.queries[0]), "DETACH DELETE f") || q.params[0]["analysis"] != "deadcode" {
+		t.Fatalf("queries = %q", q.queries)
+	}
+	rows := q.params[1]["rows"].([]any)
+	if row := rows[0].(map[string]any); row["id"] != "deadcode:unused-function:Function:main.go:orphan" || !reflect.DeepEqual(row["about"], []string{"Function:main.go:orphan"}) {
+		t.Errorf("created %v", row)
+	}
+	if rows[0].(map[string]any)["severity"] != nil || rows[1].(map[string]any)["severity"] != SeverityError {
+		t.Errorf("severities of %v", rows)
+	}
+	if !strings.Contains(q.queries[2], "MATCH (n:App:Function {file: row.file, name: row.name, receiver: row.receiver})") || !strings.Contains(q.queries[2], "MERGE (m)-[:FLAGS]->(n)") {
+		t.Errorf("linked by %q", q.queries[2])
+	}
+
+	// Findings of one node are told apart by their lines
+	q = &recordingQuerier{}
+	twice := []Finding{
+		{Kind: DiscardedError, Key: "Function:main.go:main", Line: 4},
+		{Kind: DiscardedError, Key: "Function:main.go:main", Line: 7},
+	}
+	if err := cypherReplaceFindings(context.Background(), q, LabelMap{}, "App", "errors", twice); err != nil {
+		t.Fatal(err)
+	}
+	if rows := q.params[1]["rows"].([]any); rows[0].(map[string]any)["id"] != "errors:discarded-error:Function:main.go:main" ||
+		rows[1].(map[string]any)["id"] != "errors:discarded-error:Function:main.go:main:7" {
+		t.Errorf("findings of one node created as %v", rows)
+	}
+
+	// No findings clears the analysis
+	q = &recordingQuerier{}
+	if err := cypherReplaceFindings(context.Background(), q, LabelMap{}, "App", "deadcode", nil); err != nil || len(q.queries) != 1 {
+		t.Errorf("replacing with none = %v by %q", err, q.queries)
+	}
+}
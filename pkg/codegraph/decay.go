@@ -0,0 +1,94 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Decay scores how relevant a memory still is: 1 when it was made or last
+// recalled, halving every HalfLife since. Each recall lengthens its
+// half-life, so memories in use fade slower than ones nobody reads, and an
+// expired memory scores 0.
+type Decay struct {
+	HalfLife time.Duration
+}
+
+// Score returns m's relevance at now, between 0 and 1
+func (d Decay) Score(m Memory, now time.Time) float64 {
+	if !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt) {
+		return 0
+	}
+	if d.HalfLife <= 0 {
+		return 1
+	}
+	last := m.CreatedAt
+	if m.AccessedAt.After(last) {
+		last = m.AccessedAt
+	}
+	age := max(now.Sub(last), 0)
+	halfLife := float64(d.HalfLife) * (1 + math.Log2(1+float64(max(m.Accesses, 0))))
+	return math.Exp2(-float64(age) / halfLife)
+}
+
+// MemoryGC selects the memories to collect: those scoring below Threshold
+// by Decay. They are archived, or deleted with Delete; a memory already
+// archived is only collected again to delete it. Pinned memories are never
+// collected, however much they decayed.
+type MemoryGC struct {
+	Decay
+	Threshold float64
+	Delete    bool
+	// DryRun selects the memories without archiving or deleting them
+	DryRun bool
+}
+
+// ScoredMemory is a memory with its relevance score
+type ScoredMemory struct {
+	Memory
+	Score float64 `json:"score"`
+}
+
+// CollectMemories scores every memory of the project at now and archives
+// or deletes those gc selects, returning them lowest score first
+func CollectMemories(ctx context.Context, m MemoryStore, project string, gc MemoryGC, now time.Time) ([]ScoredMemory, error) {
+	memories, err := m.Recall(ctx, project, MemoryQuery{All: true, Peek: true})
+	if err != nil {
+		return nil, err
+	}
+	var collected []ScoredMemory
+	for _, memory := range memories {
+		if memory.Pinned || memory.Archived && !gc.Delete {
+			continue
+		}
+		if score := gc.Score(memory, now); score < gc.Threshold {
+			collected = append(collected, ScoredMemory{memory, score})
+		}
+	}
+	slices.SortFunc(collected, func(a, b ScoredMemory) int {
+		return cmp.Or(cmp.Compare(a.Score, b.Score), strings.Compare(a.ID, b.ID))
+	})
+	if gc.DryRun || len(collected) == 0 {
+		return collected, nil
+	}
+	if !gc.Delete {
+		ids := make([]string, len(collected))
+		for i, s := range collected {
+			ids[i] = s.ID
+		}
+		if _, err := m.ArchiveMemories(ctx, project, ids); err != nil {
+			return nil, fmt.Errorf("archiving memories: %w", err)
+		}
+		return collected, nil
+	}
+	for _, s := range collected {
+		if _, err := m.Forget(ctx, project, s.ID); err != nil {
+			return nil, fmt.Errorf("deleting memory %s: %w", s.ID, err)
+		}
+	}
+	return collected, nil
+}
@@ -0,0 +1,143 @@
+package codegraph
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDecayScore(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	d := Decay{HalfLife: 30 * 24 * time.Hour}
+	tests := []struct {
+		name   string
+		memory Memory
+		want   float64
+	}{
+		{"new", Memory{CreatedAt: now}, 1},
+		{"one half-life old", Memory{CreatedAt: now.AddDate(0, 0, -30)}, 0.5},
+		{"two half-lives old", Memory{CreatedAt: now.AddDate(0, 0, -60)}, 0.25},
+		{"recalled since", Memory{CreatedAt: now.AddDate(0, 0, -60), AccessedAt: now.AddDate(0, 0, -30)}, 0.5},
+		// One access doubles the half-life
+		{"recalled once, long ago", Memory{CreatedAt: now.AddDate(0, 0, -90), AccessedAt: now.AddDate(0, 0, -60), Accesses: 1}, 0.5},
+		{"expired", Memory{CreatedAt: now, ExpiresAt: now}, 0},
+		{"expiring", Memory{CreatedAt: now.AddDate(0, 0, -30), ExpiresAt: now.Add(time.Hour)}, 0.5},
+	}
+	for _, tt := range tests {
+		if got := d.Score(tt.memory, now); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: score = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := (Decay{}).Score(Memory{CreatedAt: now.AddDate(-5, 0, 0)}, now); got != 1 {
+		t.Errorf("no half-life: score = %v, want 1", got)
+	}
+}
+
+// fakeMemoryStore keeps memories in a slice
+type fakeMemoryStore struct {
+	memories []Memory
+	queries  []MemoryQuery
+}
+
+func (f *fakeMemoryStore) Remember(ctx context.Context, project string, memory Memory) (Memory, error) {
+	f.memories = append(f.memories, memory)
+	return memory, nil
+}
+
+func (f *fakeMemoryStore) Recall(ctx context.Context, project string, q MemoryQuery) ([]Memory, error) {
+	f.queries = append(f.queries, q)
+	return slices.Clone(f.memories), nil
+}
+
+func (f *fakeMemoryStore) Forget(ctx context.Context, project, id string) (bool, error) {
+	n := len(f.memories)
+	f.memories = slices.DeleteFunc(f.memories, func(m Memory) bool { return m.ID == id })
+	return len(f.memories) < n, nil
+}
+
+func (f *fakeMemoryStore) PinMemory(ctx context.Context, project, id string, pinned bool) (bool, error) {
+	for i := range f.memories {
+		if f.memories[i].ID == id {
+			f.memories[i].Pinned = pinned
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeMemoryStore) ArchiveMemories(ctx context.Context, project string, ids []string) (int, error) {
+	n := 0
+	for i := range f.memories {
+		if slices.Contains(ids, f.memories[i].ID) && !f.memories[i].Archived {
+			f.memories[i].Archived = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestCollectMemories(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	newStore := func() *fakeMemoryStore {
+		return &fakeMemoryStore{memories: []Memory{
+			{ID: "mem-1", CreatedAt: now.AddDate(0, 0, -120)},
+			{ID: "mem-2", CreatedAt: now.AddDate(0, 0, -60)},
+			{ID: "mem-3", CreatedAt: now.AddDate(0, 0, -1)},
+			{ID: "mem-4", CreatedAt: now, ExpiresAt: now.AddDate(0, 0, -1)},
+			{ID: "mem-5", CreatedAt: now.AddDate(0, 0, -365), Archived: true},
+			{ID: "mem-6", CreatedAt: now.AddDate(0, 0, -365), ExpiresAt: now.AddDate(0, 0, -1), Pinned: true},
+		}}
+	}
+	ids := func(scored []ScoredMemory) []string {
+		var ids []string
+		for _, s := range scored {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+	gc := MemoryGC{Decay: Decay{HalfLife: 30 * 24 * time.Hour}, Threshold: 0.2}
+
+	store := newStore()
+	dry := gc
+	dry.DryRun = true
+	collected, err := CollectMemories(context.Background(), store, "App", dry, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lowest score first: the expired memory, then the oldest
+	if want := []string{"mem-4", "mem-1"}; !slices.Equal(ids(collected), want) {
+		t.Errorf("collected %q, want %q", ids(collected), want)
+	}
+	if store.memories[0].Archived || !store.queries[0].All || !store.queries[0].Peek {
+		t.Errorf("dry run changed %+v, or recalled with %+v", store.memories, store.queries)
+	}
+
+	if _, err := CollectMemories(context.Background(), store, "App", gc, now); err != nil {
+		t.Fatal(err)
+	}
+	var archived []string
+	for _, m := range store.memories {
+		if m.Archived {
+			archived = append(archived, m.ID)
+		}
+	}
+	if want := []string{"mem-1", "mem-4", "mem-5"}; !slices.Equal(archived, want) {
+		t.Errorf("archived %q, want %q", archived, want)
+	}
+
+	// Deleting also removes what was archived before
+	store = newStore()
+	gc.Delete = true
+	collected, err = CollectMemories(context.Background(), store, "App", gc, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mem-4", "mem-5", "mem-1"}; !slices.Equal(ids(collected), want) {
+		t.Errorf("deleted %q, want %q", ids(collected), want)
+	}
+	if len(store.memories) != 3 {
+		t.Errorf("left %+v, want mem-2, mem-3 and the pinned mem-6", store.memories)
+	}
+}
@@ -0,0 +1,238 @@
+package codegraph
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DecisionStore is implemented by backends that keep architectural
+// decisions, linked to the packages and functions they affect so why code
+// is built the way it is can be found next to it
+type DecisionStore interface {
+	// Decide stores decision, assigning its ID and times, and links it to
+	// each node it affects; every key must name a stored node
+	Decide(ctx context.Context, project string, decision Decision) (Decision, error)
+	// Decisions lists the decisions matching q, newest first
+	Decisions(ctx context.Context, project string, q DecisionQuery) ([]Decision, error)
+	// SetDecisionStatus changes the status of a decision, such as to
+	// deprecate it
+	SetDecisionStatus(ctx context.Context, project, id, status string) (Decision, error)
+}
+
+// Decision is an architectural decision record: what was decided and why,
+// the alternatives considered and the nodes, named by key, it affects.
+// GitState records the code it was made at.
+type Decision struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Rationale    string    `json:"rationale,omitempty"`
+	Alternatives []string  `json:"alternatives,omitempty"`
+	Status       string    `json:"status"`
+	Affects      []string  `json:"affects"`
+	Session      string    `json:"session,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	// Redacted is set when redaction changed the title, rationale or an
+	// alternative, see RedactDecision
+	Redacted bool `json:"redacted,omitempty"`
+	GitState
+}
+
+// DecisionQuery selects decisions: those affecting any node keyed in
+// Affects, with Status, made during Session and made or updated after
+// Since, if set, at most Limit of them if positive
+type DecisionQuery struct {
+	Affects []string
+	Status  string
+	Session string
+	Since   time.Time
+	Limit   int
+}
+
+// DecisionStatuses are the statuses a decision can have, the first being
+// the default
+var DecisionStatuses = []string{"accepted", "proposed", "rejected", "deprecated", "superseded"}
+
+var (
+	// ErrInvalidDecision is returned for a decision without a title, with
+	// an unknown status, or with no key or an invalid one
+	ErrInvalidDecision = errors.New("invalid decision")
+	// ErrNoDecision is returned for a decision ID naming no stored decision
+	ErrNoDecision = errors.New("no such decision")
+)
+
+// EnclosingKeys returns key with the keys of the file and package holding
+// the node it names, so decisions about a package are found from any of
+// its functions
+func EnclosingKeys(key string) []string {
+	kind, props, err := ParseKey(key)
+	if err != nil {
+		return []string{key}
+	}
+	keys := []string{key}
+	switch kind {
+	case "File":
+		keys = append(keys, "Package:"+path.Dir(props["path"].(string)))
+	case "Function", "Method", "Struct", "Interface":
+		file := props["file"].(string)
+		keys = append(keys, "File:"+file, "Package:"+path.Dir(file))
+	}
+	return keys
+}
+
+// newDecisionID returns an ID that sorts by creation time
+func newDecisionID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return "dec-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// cypherDecide checks every key of decision names a live node, then creates
+// the Decision node and links it to them and to the commit it was made at
+func cypherDecide(ctx context.Context, q Querier, labels LabelMap, project string, decision Decision) (Decision, error) {
+	if strings.TrimSpace(decision.Title) == "" {
+		return Decision{}, fmt.Errorf("%w: no title", ErrInvalidDecision)
+	}
+	decision.Status = decisionStatus(decision.Status)
+	if !slices.Contains(DecisionStatuses, decision.Status) {
+		return Decision{}, fmt.Errorf("%w: unknown status %q", ErrInvalidDecision, decision.Status)
+	}
+	if len(decision.Affects) == 0 {
+		return Decision{}, fmt.Errorf("%w: affects no node", ErrInvalidDecision)
+	}
+	if err := checkKeys(ctx, q, labels, project, decision.Affects, ErrInvalidDecision); err != nil {
+		return Decision{}, err
+	}
+	if decision.Session != "" {
+		if err := sessionExists(ctx, q, labels, project, decision.Session); err != nil {
+			return Decision{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	decision.ID, decision.CreatedAt, decision.UpdatedAt = newDecisionID(now), now, now
+	decision.Alternatives = slices.DeleteFunc(slices.Clone(decision.Alternatives), func(alt string) bool { return alt == "" })
+	if decision.Alternatives == nil {
+		decision.Alternatives = []string{}
+	}
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		CREATE (:%s:%s {id: $id, title: $title, rationale: $rationale, alternatives: $alternatives,
+		        status: $status, affects: $affects, session: $session, createdAt: $createdAt, updatedAt: $createdAt,
+		        redacted: $redacted, commit: $commit, branch: $branch, pr: $pr})
+	`, project, labels.Label("Decision")), map[string]any{
+		"id": decision.ID, "title": decision.Title, "rationale": decision.Rationale, "alternatives": decision.Alternatives,
+		"status": decision.Status, "affects": decision.Affects, "session": decision.Session, "createdAt": now,
+		"redacted": decision.Redacted, "commit": decision.Commit, "branch": decision.Branch, "pr": decision.PR,
+	})
+	if err != nil {
+		return Decision{}, err
+	}
+	if err := recordInSession(ctx, q, labels, project, decision.Session, "Decision", decision.ID); err != nil {
+		return Decision{}, err
+	}
+	if err := recordAtCommit(ctx, q, labels, project, "Decision", decision.ID, decision.GitState); err != nil {
+		return Decision{}, err
+	}
+	links := make([]nodeLink, len(decision.Affects))
+	for i, key := range decision.Affects {
+		links[i] = nodeLink{decision.ID, key}
+	}
+	return decision, linkNodes(ctx, q, labels, project, "Decision", "AFFECTS", links)
+}
+
+// decisionStatus returns status, or the default status if it is empty
+func decisionStatus(status string) string {
+	if status == "" {
+		return DecisionStatuses[0]
+	}
+	return strings.ToLower(status)
+}
+
+// cypherDecisions lists the decisions matching dq, newest first
+func cypherDecisions(ctx context.Context, q Querier, labels LabelMap, project string, dq DecisionQuery) ([]Decision, error) {
+	return queryDecisions(ctx, q, labels, project, "", dq)
+}
+
+// cypherSetDecisionStatus changes the status of the decision id
+func cypherSetDecisionStatus(ctx context.Context, q Querier, labels LabelMap, project, id, status string) (Decision, error) {
+	status = decisionStatus(status)
+	if !slices.Contains(DecisionStatuses, status) {
+		return Decision{}, fmt.Errorf("%w: unknown status %q", ErrInvalidDecision, status)
+	}
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (d:%s:%s {id: $id})
+		SET d.status = $status, d.updatedAt = $now
+	`, project, labels.Label("Decision")), map[string]any{"id": id, "status": status, "now": time.Now().UTC()})
+	if err != nil {
+		return Decision{}, err
+	}
+	decisions, err := queryDecisions(ctx, q, labels, project, id, DecisionQuery{Limit: 1})
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(decisions) == 0 {
+		return Decision{}, fmt.Errorf("%w %q", ErrNoDecision, id)
+	}
+	return decisions[0], nil
+}
+
+// queryDecisions reads the decision id, or every decision matching dq if
+// id is empty
+func queryDecisions(ctx context.Context, q Querier, labels LabelMap, project, id string, dq DecisionQuery) ([]Decision, error) {
+	limit := ""
+	if dq.Limit > 0 {
+		limit = fmt.Sprintf("LIMIT %d", dq.Limit)
+	}
+	affects := dq.Affects
+	if affects == nil {
+		affects = []string{}
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (d:%s:%s)
+		WHERE ($id = '' OR d.id = $id) AND (size($affects) = 0 OR any(key IN d.affects WHERE key IN $affects))
+		  AND ($status = '' OR d.status = $status) AND ($session = '' OR d.session = $session)
+		  AND ($since IS NULL OR d.createdAt > $since OR d.updatedAt > $since)
+		RETURN d.id AS id, d.title AS title, d.rationale AS rationale, d.alternatives AS alternatives,
+		       d.status AS status, d.affects AS affects, d.session AS session, d.createdAt AS createdAt,
+		       d.updatedAt AS updatedAt, d.commit AS commit, d.branch AS branch, d.pr AS pr,
+		       coalesce(d.redacted, false) AS redacted
+		ORDER BY d.id DESC
+		%s
+	`, project, labels.Label("Decision"), limit), map[string]any{
+		"id": id, "affects": affects, "status": strings.ToLower(dq.Status), "session": dq.Session,
+		"since": optionalTime(dq.Since),
+	})
+	if err != nil {
+		return nil, err
+	}
+	decisions := make([]Decision, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 13 {
+			continue
+		}
+		rationale, _ := row[2].(string)
+		session, _ := row[6].(string)
+		redacted, _ := row[12].(bool)
+		decisions = append(decisions, Decision{
+			ID:           fmt.Sprint(row[0]),
+			Title:        fmt.Sprint(row[1]),
+			Rationale:    rationale,
+			Alternatives: stringList(row[3]),
+			Status:       fmt.Sprint(row[4]),
+			Affects:      stringList(row[5]),
+			Session:      session,
+			CreatedAt:    timeValue(row[7]),
+			UpdatedAt:    timeValue(row[8]),
+			Redacted:     redacted,
+			GitState:     gitState(row[9:12]),
+		})
+	}
+	return decisions, nil
+}
@@ -0,0 +1,140 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnclosingKeys(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"Function:store/store.go:*Store.Put", []string{"Function:store/store.go:*Store.Put", "File:store/store.go", "Package:store"}},
+		{"Struct:main.go:Config", []string{"Struct:main.go:Config", "File:main.go", "Package:."}},
+		{"File:store/store.go", []string{"File:store/store.go", "Package:store"}},
+		{"Package:store", []string{"Package:store"}},
+		{"bad", []string{"bad"}},
+	}
+	for _, tt := range tests {
+		if got := EnclosingKeys(tt.key); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("EnclosingKeys(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCypherDecide(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasSuffix(query, "AS count") {
+			if params["path"] == "store" || params["id"] == "ses-1" {
+				return [][]any{{int64(1)}}
+			}
+			return [][]any{{int64(0)}}
+		}
+		return nil
+	}}
+	decision, err := cypherDecide(context.Background(), q, LabelMap{}, "App", Decision{
+		Title:        "Store writes through a single goroutine",
+		Rationale:    "the driver is not safe for concurrent use",
+		Alternatives: []string{"a mutex", ""},
+		Affects:      []string{"Package:store"},
+		Session:      "ses-1",
+		GitState:     GitState{Commit: "abc123", Branch: "main", PR: 42},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(decision.ID, "dec-") || decision.Status != "accepted" || !reflect.DeepEqual(decision.Alternatives, []string{"a mutex"}) {
+		t.Errorf("decision = %+v", decision)
+	}
+	var created, linked, recorded, committed bool
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "CREATE (:App:Decision {"):
+			created = q.params[i]["status"] == "accepted" && reflect.DeepEqual(q.params[i]["affects"], []string{"Package:store"}) &&
+				q.params[i]["commit"] == "abc123"
+		case strings.HasSuffix(query, "MERGE (m)-[:AFFECTS]->(n)"):
+			linked = strings.Contains(query, "MATCH (n:App:Package {path: row.path})")
+		case strings.HasSuffix(query, "MERGE (s)-[:RECORDED]->(n)"):
+			recorded = q.params[i]["id"] == decision.ID
+		case strings.Contains(query, "MERGE (n)-[r:RECORDED_AT]->(c)"):
+			committed = q.params[i]["id"] == decision.ID && q.params[i]["pr"] == 42
+		}
+	}
+	if !created || !linked || !recorded || !committed {
+		t.Errorf("created %v, linked %v, recorded %v, committed %v in %q", created, linked, recorded, committed, q.queries)
+	}
+
+	for _, invalid := range []Decision{
+		{Affects: []string{"Package:store"}},
+		{Title: "x"},
+		{Title: "x", Affects: []string{"Package:store"}, Status: "maybe"},
+	} {
+		if _, err := cypherDecide(context.Background(), q, LabelMap{}, "App", invalid); !errors.Is(err, ErrInvalidDecision) {
+			t.Errorf("%+v: err = %v, want ErrInvalidDecision", invalid, err)
+		}
+	}
+	_, err = cypherDecide(context.Background(), q, LabelMap{}, "App", Decision{Title: "x", Affects: []string{"Package:gone"}})
+	if !errors.Is(err, ErrNoNode) {
+		t.Errorf("err = %v, want ErrNoNode", err)
+	}
+}
+
+func TestCypherDecisions(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		return [][]any{
+			{"dec-1", "Use SQLite for tests", nil, []any{"an in-memory map"}, "deprecated", []any{"Package:store"}, nil, created, created.Format(time.RFC3339Nano),
+				"abc123", "main", int64(42), false},
+		}
+	}}
+	decisions, err := cypherDecisions(context.Background(), q, LabelMap{}, "App", DecisionQuery{Affects: EnclosingKeys("File:store/store.go"), Status: "Deprecated", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Decision{{
+		ID: "dec-1", Title: "Use SQLite for tests", Alternatives: []string{"an in-memory map"}, Status: "deprecated",
+		Affects: []string{"Package:store"}, CreatedAt: created, UpdatedAt: created,
+		GitState: GitState{Commit: "abc123", Branch: "main", PR: 42},
+	}}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Errorf("decisions = %+v, want %+v", decisions, want)
+	}
+	params := q.params[0]
+	if !reflect.DeepEqual(params["affects"], []string{"File:store/store.go", "Package:store"}) || params["status"] != "deprecated" || !strings.HasSuffix(q.queries[0], "LIMIT 3") {
+		t.Errorf("query %q with %v", q.queries[0], params)
+	}
+	if params["since"] != nil {
+		t.Errorf("since = %v without Since", params["since"])
+	}
+	since := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if _, err := cypherDecisions(context.Background(), q, LabelMap{}, "App", DecisionQuery{Since: since}); err != nil || q.params[1]["since"] != since {
+		t.Errorf("since = %v, %v", q.params[1]["since"], err)
+	}
+}
+
+func TestCypherSetDecisionStatus(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasPrefix(query, "MATCH (d:App:Decision)") && params["id"] == "dec-1" {
+			return [][]any{{"dec-1", "x", "", nil, "superseded", []any{"Package:store"}, nil, nil, nil, nil, nil, nil, nil}}
+		}
+		return nil
+	}}
+	decision, err := cypherSetDecisionStatus(context.Background(), q, LabelMap{}, "App", "dec-1", "superseded")
+	if err != nil || decision.Status != "superseded" {
+		t.Errorf("decision = %+v, %v", decision, err)
+	}
+	if q.params[0]["status"] != "superseded" {
+		t.Errorf("set with %v", q.params[0])
+	}
+	if _, err := cypherSetDecisionStatus(context.Background(), q, LabelMap{}, "App", "dec-9", "rejected"); !errors.Is(err, ErrNoDecision) {
+		t.Errorf("unknown decision: err = %v, want ErrNoDecision", err)
+	}
+	if _, err := cypherSetDecisionStatus(context.Background(), q, LabelMap{}, "App", "dec-1", "done"); !errors.Is(err, ErrInvalidDecision) {
+		t.Errorf("unknown status: err = %v, want ErrInvalidDecision", err)
+	}
+}
@@ -0,0 +1,147 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Duplicate is a pair of functions whose bodies are copies of each other,
+// or nearly: Similarity estimates the share of the token shingles of their
+// normalized bodies they have in common, from 0 to 1
+type Duplicate struct {
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Similarity float64 `json:"similarity"`
+}
+
+// DuplicateSimilarity is the least similarity at which the duplicates
+// feature links two functions
+const DuplicateSimilarity = 0.8
+
+const (
+	// fingerprintSize is the number of MinHash values kept per function,
+	// compared fingerprintBands at a time to find candidate pairs
+	fingerprintSize  = 32
+	fingerprintBands = 8
+	// shingleTokens is the number of consecutive tokens hashed together
+	shingleTokens = 5
+	// minFingerprintTokens leaves out bodies too short for a match to
+	// mean they were copied, such as getters
+	minFingerprintTokens = 30
+)
+
+// fingerprint returns the MinHash signature of the token shingles of a
+// function body, or nil if it is too short. Tokens are the kinds of the
+// body's syntax nodes, its operators and literal kinds, and the names it
+// uses, except that its parameters and local variables all read the same,
+// so a copy with them renamed still matches.
+func fingerprint(body *ast.BlockStmt) []uint32 {
+	var tokens []string
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case nil:
+		case *ast.Ident:
+			if n.Obj != nil && n.Obj.Kind == ast.Var {
+				tokens = append(tokens, "var")
+			} else {
+				tokens = append(tokens, n.Name)
+			}
+		case *ast.BasicLit:
+			tokens = append(tokens, n.Kind.String())
+		case *ast.BinaryExpr:
+			tokens = append(tokens, n.Op.String())
+		case *ast.UnaryExpr:
+			tokens = append(tokens, n.Op.String())
+		case *ast.AssignStmt:
+			tokens = append(tokens, n.Tok.String())
+		case *ast.IncDecStmt:
+			tokens = append(tokens, n.Tok.String())
+		case *ast.BranchStmt:
+			tokens = append(tokens, n.Tok.String())
+		default:
+			tokens = append(tokens, fmt.Sprintf("%T", n))
+		}
+		return true
+	})
+	if len(tokens) < minFingerprintTokens {
+		return nil
+	}
+
+	signature := make([]uint32, fingerprintSize)
+	for i := range signature {
+		signature[i] = math.MaxUint32
+	}
+	for i := 0; i+shingleTokens <= len(tokens); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+shingleTokens], " ")))
+		shingle := h.Sum64()
+		for j := range signature {
+			// A different hash function per value, mixing in its index
+			x := shingle ^ uint64(j+1)*0x9e3779b97f4a7c15
+			x ^= x >> 33
+			x *= 0xff51afd7ed558ccd
+			x ^= x >> 33
+			signature[j] = min(signature[j], uint32(x>>32))
+		}
+	}
+	return signature
+}
+
+// Duplicates returns the pairs of functions whose fingerprints are at
+// least threshold similar, the most similar first. Candidates are the
+// functions sharing a band of their fingerprints, so pairs far below the
+// threshold are never compared and a pair just above it may be missed.
+func (g *Graph) Duplicates(threshold float64) []Duplicate {
+	type band struct {
+		index  int
+		values [fingerprintSize / fingerprintBands]uint32
+	}
+	buckets := make(map[band][]int)
+	for i, fn := range g.Functions {
+		if len(fn.Fingerprint) != fingerprintSize {
+			continue
+		}
+		for b := range fingerprintBands {
+			key := band{index: b}
+			copy(key.values[:], fn.Fingerprint[b*len(key.values):])
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	var duplicates []Duplicate
+	compared := make(map[[2]int]bool)
+	for _, bucket := range buckets {
+		for x, i := range bucket {
+			for _, j := range bucket[x+1:] {
+				if compared[[2]int{i, j}] {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+				same := 0
+				for k, v := range g.Functions[i].Fingerprint {
+					if g.Functions[j].Fingerprint[k] == v {
+						same++
+					}
+				}
+				similarity := math.Round(float64(same)/fingerprintSize*100) / 100
+				if similarity < threshold {
+					continue
+				}
+				from, to := g.Functions[i].Key(), g.Functions[j].Key()
+				if to < from {
+					from, to = to, from
+				}
+				duplicates = append(duplicates, Duplicate{From: from, To: to, Similarity: similarity})
+			}
+		}
+	}
+	slices.SortFunc(duplicates, func(a, b Duplicate) int {
+		return cmp.Or(cmp.Compare(b.Similarity, a.Similarity), strings.Compare(a.From, b.From), strings.Compare(a.To, b.To))
+	})
+	return duplicates
+}
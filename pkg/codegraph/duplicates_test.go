@@ -0,0 +1,58 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	// sum is copied to another package with its variables renamed, and
+	// product differs from it in the operator and the function it calls
+	body := func(total, item, op, call string) string {
+		return `(items []int, limit int) (int, error) {
+	` + total + ` := 0
+	for _, ` + item + ` := range items {
+		if ` + item + ` < 0 {
+			return 0, errors.New("negative item")
+		}
+		if ` + total + ` > limit {
+			break
+		}
+		` + total + ` = ` + total + ` ` + op + ` ` + item + `
+	}
+	` + call + `(` + total + `)
+	return ` + total + `, nil
+}
+`
+	}
+	root := writeTree(t, map[string]string{
+		"a/a.go": "package a\n\nimport \"errors\"\n\nfunc sum" + body("total", "item", "+", "record") +
+			"\nfunc product" + body("total", "item", "*", "report") +
+			"\nfunc short() int { return 1 }\n\nfunc record(int) {}\n\nfunc report(int) {}\n",
+		"b/b.go": "package b\n\nimport \"errors\"\n\nfunc Sum" + body("acc", "n", "+", "record") + "\nfunc record(int) {}\n",
+	})
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range graph.Functions {
+		if short := fn.Name == "short" || fn.Name == "record" || fn.Name == "report"; short != (fn.Fingerprint == nil) {
+			t.Errorf("%s fingerprint = %v", fn.Name, fn.Fingerprint)
+		}
+	}
+
+	want := []Duplicate{{From: "Function:a/a.go:sum", To: "Function:b/b.go:Sum", Similarity: 1}}
+	if got := graph.Duplicates(DuplicateSimilarity); !reflect.DeepEqual(got, want) {
+		t.Errorf("duplicates = %+v, want %+v", got, want)
+	}
+	var similar int
+	for _, dup := range graph.Duplicates(0) {
+		if dup.Similarity < 1 {
+			similar++
+		}
+	}
+	if similar == 0 {
+		t.Error("product shares no band with sum at threshold 0")
+	}
+}
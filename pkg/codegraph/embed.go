@@ -0,0 +1,346 @@
+package codegraph
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Embedder turns texts into vectors with a hosted or local model, so code
+// can be looked up by what it does rather than by its exact name
+type Embedder interface {
+	// Embed returns one vector per text, in order
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// VectorSearcher is implemented by backends that index the embeddings they
+// write and can search them
+type VectorSearcher interface {
+	// SimilarNodes returns the at most limit embedded nodes whose
+	// embeddings are closest to vector, closest first
+	SimilarNodes(ctx context.Context, project string, vector []float64, limit int) ([]SimilarNode, error)
+	// Search returns the nodes closest to q.Vector with their package,
+	// signature and callers, best first
+	Search(ctx context.Context, project string, q SearchQuery) ([]SearchResult, error)
+}
+
+// EmbeddingProperty is the node property embeddings are written to
+const EmbeddingProperty = "embedding"
+
+// SimilarNode is a node found by SimilarNodes, with its cosine similarity
+// to the vector searched for
+type SimilarNode struct {
+	Key   string  `json:"key"`
+	Kind  string  `json:"kind"`
+	Name  string  `json:"name"`
+	File  string  `json:"file"`
+	Score float64 `json:"score"`
+}
+
+// embeddingTexts returns the keys of the functions, methods and structs of
+// the graph and the texts embedded for them: the signature or fields, with
+// the doc comment
+func (g *Graph) embeddingTexts() (keys, texts []string) {
+	for _, fn := range g.Functions {
+		keys = append(keys, fn.Key())
+		texts = append(texts, strings.TrimSpace(fn.Doc+"\n"+fn.Signature))
+	}
+	for _, st := range g.Structs {
+		keys = append(keys, st.Key())
+		texts = append(texts, strings.TrimSpace(st.Doc+"\n"+st.definition()))
+	}
+	return keys, texts
+}
+
+// definition returns the struct's declaration, one field per line
+func (s StructNode) definition() string {
+	def := "type " + s.Name + " struct {\n"
+	for _, field := range s.Fields {
+		def += "\t" + field + "\n"
+	}
+	return def + "}"
+}
+
+// embeddingDimensions returns the length of the graph's embeddings, or 0
+// if it has none
+func (g *Graph) embeddingDimensions() int {
+	for _, vector := range g.Embeddings {
+		return len(vector)
+	}
+	return 0
+}
+
+// Embed embeds the functions, methods and structs of graph, batchSize texts
+// per call, and records the vectors in graph.Embeddings
+func Embed(ctx context.Context, graph *Graph, e Embedder, batchSize int) error {
+	keys, texts := graph.embeddingTexts()
+	if batchSize <= 0 {
+		batchSize = len(texts)
+	}
+	graph.Embeddings = make(map[string][]float64, len(keys))
+	p := StartProgress("embedding", "symbols", len(texts))
+	defer p.Finish()
+	dims := 0
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+		vectors, err := e.Embed(ctx, texts[start:end])
+		if err != nil {
+			return fmt.Errorf("embedding %s: %w", keys[start], err)
+		}
+		if len(vectors) != end-start {
+			return fmt.Errorf("embedding %s: got %d vectors for %d texts", keys[start], len(vectors), end-start)
+		}
+		for i, vector := range vectors {
+			if dims == 0 {
+				dims = len(vector)
+			}
+			if len(vector) == 0 || len(vector) != dims {
+				return fmt.Errorf("embedding %s: got %d dimensions, want %d", keys[start+i], len(vector), dims)
+			}
+			graph.Embeddings[keys[start+i]] = vector
+		}
+		p.Add(end - start)
+	}
+	return nil
+}
+
+// OpenAIEmbedder embeds with the OpenAI embeddings API, or a server
+// compatible with it
+type OpenAIEmbedder struct {
+	BaseURL string // default https://api.openai.com/v1
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	url := strings.TrimSuffix(cmp.Or(e.BaseURL, "https://api.openai.com/v1"), "/") + "/embeddings"
+	header := http.Header{}
+	if e.APIKey != "" {
+		header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+	if err := postJSON(ctx, e.Client, url, header, map[string]any{"model": e.Model, "input": texts}, &resp); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float64, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// OllamaEmbedder embeds with a model served by Ollama
+type OllamaEmbedder struct {
+	BaseURL string // default http://localhost:11434
+	Model   string
+	Client  *http.Client
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var resp struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	url := strings.TrimSuffix(cmp.Or(e.BaseURL, "http://localhost:11434"), "/") + "/api/embed"
+	if err := postJSON(ctx, e.Client, url, nil, map[string]any{"model": e.Model, "input": texts}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Embeddings, nil
+}
+
+// postJSON posts req as JSON to url and decodes the JSON answer into resp
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	r.Header.Set("Content-Type", "application/json")
+	res, err := cmp.Or(client, http.DefaultClient).Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", url, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// ProcessEmbedder is an Embedder run as a subprocess, for local models and
+// providers without a built-in client. For each batch it writes a line of
+// JSON to the process's stdin:
+//
+//	{"texts": ["func Parse(src []byte) (*Graph, error)", ...]}
+//
+// and reads one line back from its stdout, with a vector per text or an
+// error:
+//
+//	{"embeddings": [[0.12, -0.03, ...], ...]}
+//	{"error": "model not loaded"}
+//
+// The process's stderr is passed through.
+type ProcessEmbedder struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+// StartEmbedder starts command with sh -c in dir, keeping it running to
+// embed batch after batch until Close. Cancelling ctx kills it.
+func StartEmbedder(ctx context.Context, command, dir string) (*ProcessEmbedder, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting embedder %q: %w", command, err)
+	}
+	return &ProcessEmbedder{command: command, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (e *ProcessEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	data, err := json.Marshal(map[string]any{"texts": texts})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.stdin.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("embedder %q: %w", e.command, err)
+	}
+	line, err := e.stdout.ReadBytes('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("embedder %q exited without answering", e.command)
+		}
+		return nil, fmt.Errorf("embedder %q: %w", e.command, err)
+	}
+	var resp struct {
+		Embeddings [][]float64 `json:"embeddings"`
+		Error      string      `json:"error"`
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("embedder %q answered %q: %w", e.command, strings.TrimSpace(string(line)), err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("embedder %q: %s", e.command, resp.Error)
+	}
+	return resp.Embeddings, nil
+}
+
+// Close closes the process's stdin, which it should take as the end of the
+// run, and waits for it to exit
+func (e *ProcessEmbedder) Close() error {
+	e.stdin.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("embedder %q: %w", e.command, err)
+	}
+	return nil
+}
+
+// VectorIndexName is the name of the vector index over a project's
+// embeddings
+func VectorIndexName(project string) string {
+	return project + "_embedding"
+}
+
+// cypherVectorIndex creates the Neo4j vector index over the embeddings of
+// the project's nodes, recreating it if it was made for vectors of another
+// length, such as before a change of model
+func cypherVectorIndex(ctx context.Context, q Querier, project string, dims int) error {
+	name := VectorIndexName(project)
+	_, rows, err := q.Query(ctx, `
+		SHOW VECTOR INDEXES YIELD name, options WHERE name = $name
+		RETURN options.indexConfig['vector.dimensions'] AS dimensions
+	`, map[string]any{"name": name})
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		if intValue(rows[0][0]) == dims {
+			return nil
+		}
+		if _, _, err := q.Query(ctx, fmt.Sprintf("DROP INDEX `%s`", name), nil); err != nil {
+			return err
+		}
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(
+		"CREATE VECTOR INDEX `%s` IF NOT EXISTS\nFOR (n:%s) ON n.%s\n"+
+			"OPTIONS {indexConfig: {`vector.dimensions`: %d, `vector.similarity_function`: 'cosine'}}",
+		name, project, EmbeddingProperty, dims), nil)
+	return err
+}
+
+// cypherSimilarNodes queries the project's vector index for the live nodes
+// closest to vector. It asks the index for twice limit, as nodes marked
+// deleted keep their embeddings.
+func cypherSimilarNodes(ctx context.Context, q Querier, labels LabelMap, project string, vector []float64, limit int) ([]SimilarNode, error) {
+	_, rows, err := q.Query(ctx, `
+		CALL db.index.vector.queryNodes($index, $k, $vector) YIELD node, score
+		WHERE NOT coalesce(node.deleted, false)
+		RETURN labels(node) AS labels, node.name AS name, node.file AS file, node.receiver AS receiver, score
+		ORDER BY score DESC
+		LIMIT $limit
+	`, map[string]any{"index": VectorIndexName(project), "k": limit * 2, "vector": vector, "limit": limit})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]SimilarNode, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 5 {
+			continue
+		}
+		kind := ""
+		for _, label := range stringList(row[0]) {
+			if kind = labels.Kind(label); kind != "" {
+				break
+			}
+		}
+		name, _ := row[1].(string)
+		file, _ := row[2].(string)
+		receiver, _ := row[3].(string)
+		score, _ := row[4].(float64)
+		node := SimilarNode{Kind: kind, Name: name, File: file, Score: score}
+		switch kind {
+		case "Function", "Method":
+			node.Key = FunctionNode{Name: name, File: file, Receiver: receiver}.Key()
+		case "Struct":
+			node.Key = StructNode{Name: name, File: file}.Key()
+		default:
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
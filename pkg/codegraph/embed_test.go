@@ -0,0 +1,224 @@
+package codegraph
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lengthEmbedder embeds each text as its length and number of lines,
+// recording the batches it was given
+type lengthEmbedder struct {
+	batches [][]string
+}
+
+func (e *lengthEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	e.batches = append(e.batches, texts)
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float64{float64(len(text)), float64(strings.Count(text, "\n") + 1)}
+	}
+	return vectors, nil
+}
+
+func TestEmbed(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	e := &lengthEmbedder{}
+	if err := Embed(context.Background(), graph, e, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Five functions and methods and one struct, two at a time
+	if len(e.batches) != 3 || len(graph.Embeddings) != 6 {
+		t.Fatalf("embedded %q into %v", e.batches, graph.Embeddings)
+	}
+	if e.batches[0][0] != "func main()" {
+		t.Errorf("main embedded as %q", e.batches[0][0])
+	}
+	if want := "type Store struct {\n\tkeys []string\n}"; e.batches[2][1] != want {
+		t.Errorf("Store embedded as %q, want %q", e.batches[2][1], want)
+	}
+	if got := graph.Embeddings["Function:store/store.go:*Store.Put"]; len(got) != 2 {
+		t.Errorf("Put embedding = %v", got)
+	}
+	if graph.embeddingDimensions() != 2 {
+		t.Errorf("dimensions = %d, want 2", graph.embeddingDimensions())
+	}
+}
+
+// fixedEmbedder answers every batch with the same vectors
+type fixedEmbedder [][]float64
+
+func (e fixedEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	return e, nil
+}
+
+func TestEmbedRejects(t *testing.T) {
+	for _, e := range []fixedEmbedder{
+		{{1, 2}},      // fewer vectors than texts
+		{{1, 2}, {1}}, // differing lengths
+		{{}, {}},      // empty vectors
+	} {
+		if err := Embed(context.Background(), parseTestTree(t, Filter{}), e, 2); err == nil {
+			t.Errorf("%v: no error", e)
+		}
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	var req struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		// Answered out of order, as the API allows
+		w.Write([]byte(`{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}]}`))
+	}))
+	defer srv.Close()
+
+	e := &OpenAIEmbedder{BaseURL: srv.URL + "/v1/", APIKey: "sk-test", Model: "text-embedding-3-small"}
+	vectors, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{1, 0}, {0, 1}}; !reflect.DeepEqual(vectors, want) {
+		t.Errorf("vectors = %v, want %v", vectors, want)
+	}
+	if req.Model != "text-embedding-3-small" || !reflect.DeepEqual(req.Input, []string{"a", "b"}) {
+		t.Errorf("request = %+v", req)
+	}
+
+	e.APIKey = "wrong"
+	if _, err := e.Embed(context.Background(), []string{"a"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("err = %v, want the 401", err)
+	}
+}
+
+func TestOllamaEmbedder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"model": "nomic-embed-text", "embeddings": [[0.5, 0.25]]}`))
+	}))
+	defer srv.Close()
+
+	vectors, err := (&OllamaEmbedder{BaseURL: srv.URL, Model: "nomic-embed-text"}).Embed(context.Background(), []string{"a"})
+	if err != nil || !reflect.DeepEqual(vectors, [][]float64{{0.5, 0.25}}) {
+		t.Errorf("vectors = %v, %v", vectors, err)
+	}
+}
+
+func TestProcessEmbedder(t *testing.T) {
+	// Answers every batch of two with two fixed vectors
+	e, err := StartEmbedder(context.Background(), `while read -r line; do echo '{"embeddings": [[1, 0], [0, 1]]}'; done`, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil || !reflect.DeepEqual(vectors, [][]float64{{1, 0}, {0, 1}}) {
+		t.Errorf("vectors = %v, %v", vectors, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	failing, err := StartEmbedder(context.Background(), `read -r line; echo '{"error": "model not loaded"}'`, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := failing.Embed(context.Background(), []string{"a"}); err == nil || !strings.Contains(err.Error(), "model not loaded") {
+		t.Errorf("err = %v, want the embedder's error", err)
+	}
+	failing.Close()
+}
+
+func TestBuildStatementsEmbeddings(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Embeddings = map[string][]float64{
+		"Function:main.go:run":               {1, 0},
+		"Function:store/store.go:*Store.Put": {0, 1},
+		"Struct:store/store.go:Store":        {1, 1},
+	}
+	stmts := BuildStatements("App", graph, RunInfo{ID: "run-1", StartedAt: time.Now()}, StatementOptions{Files: []string{"main.go"}})
+	var written []Statement
+	for _, stmt := range stmts {
+		if stmt.Desc == "writing embeddings" {
+			written = append(written, stmt)
+		}
+	}
+	if len(written) != 1 || len(written[0].Rows) != 1 {
+		t.Fatalf("embedding statements = %+v, want run's only", written)
+	}
+	if q := written[0].Query; !strings.Contains(q, "MATCH (n:App:Function {file: row.file, name: row.name, receiver: row.receiver})") ||
+		!strings.Contains(q, "SET n.embedding = row.embedding") {
+		t.Errorf("query does not match on the keys and set the embedding:\n%s", q)
+	}
+	if row := written[0].Rows[0]; row["name"] != "run" || !reflect.DeepEqual(row["embedding"], []float64{1, 0}) {
+		t.Errorf("row = %v", row)
+	}
+}
+
+func TestCypherVectorIndex(t *testing.T) {
+	// The index exists for 768 dimensions
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasPrefix(query, "SHOW VECTOR INDEXES") {
+			return [][]any{{int64(768)}}
+		}
+		return nil
+	}}
+	if err := cypherVectorIndex(context.Background(), q, "App", 768); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 1 || q.params[0]["name"] != "App_embedding" {
+		t.Errorf("same dimensions: queries = %q", q.queries)
+	}
+
+	q.queries, q.params = nil, nil
+	if err := cypherVectorIndex(context.Background(), q, "App", 1536); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"DROP INDEX `App_embedding`",
+		"CREATE VECTOR INDEX `App_embedding` IF NOT EXISTS\nFOR (n:App) ON n.embedding\n" +
+			"OPTIONS {indexConfig: {`vector.dimensions`: 1536, `vector.similarity_function`: 'cosine'}}",
+	}
+	if len(q.queries) != 3 || !reflect.DeepEqual(q.queries[1:], want) {
+		t.Errorf("new dimensions: queries = %q, want %q", q.queries, want)
+	}
+}
+
+func TestCypherSimilarNodes(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		return [][]any{
+			{[]any{"App", "CG_Method"}, "Put", "store/store.go", "*Store", 0.93},
+			{[]any{"App", "CG_Struct"}, "Store", "store/store.go", nil, 0.9},
+			{[]any{"App", "CG_Function"}, "run", "main.go", "", 0.71},
+		}
+	}}
+	nodes, err := cypherSimilarNodes(context.Background(), q, LabelMap{Prefix: "CG_"}, "App", []float64{1, 0}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SimilarNode{
+		{Key: "Function:store/store.go:*Store.Put", Kind: "Method", Name: "Put", File: "store/store.go", Score: 0.93},
+		{Key: "Struct:store/store.go:Store", Kind: "Struct", Name: "Store", File: "store/store.go", Score: 0.9},
+		{Key: "Function:main.go:run", Kind: "Function", Name: "run", File: "main.go", Score: 0.71},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes = %+v, want %+v", nodes, want)
+	}
+	if params := q.params[0]; params["index"] != "App_embedding" || params["k"] != 6 || params["limit"] != 3 {
+		t.Errorf("queried with %v", params)
+	}
+}
@@ -0,0 +1,260 @@
+package codegraph
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Enricher attaches custom properties, such as the owning team or the
+// feature flags a function reads, to the nodes of each parsed file before
+// they are written
+type Enricher interface {
+	// Enrich is called once per file with the file's nodes: the file, its
+	// declarations, and its package if this is the package's first file. It
+	// returns the properties to add to each node, by node key.
+	Enrich(ctx context.Context, file FileNode, nodes []GraphNode) (map[string]map[string]any, error)
+}
+
+// reservedProperties are written by the writers, --blame, --churn or
+// --coverprofile, and cannot be set by an enricher
+var reservedProperties = []string{
+	"id", "project", "createdAt", "updatedAt", "runId", "commit", "deleted", "deletedAt",
+	"lastAuthor", "lastModified", "topContributors", "churn", "churnLines",
+	"fanIn", "fanOut", "afferent", "efferent", "instability", "abstractness", "distance",
+	"coverage", "statements", "coveredStatements", "uncoveredLines", "complexity",
+}
+
+// Enrich runs each enricher over every file of the graph in turn and
+// records the properties they return in graph.Properties. Later enrichers
+// see the properties earlier ones set and may replace them, but not the
+// parsed properties.
+func Enrich(ctx context.Context, graph *Graph, enrichers ...Enricher) error {
+	if len(enrichers) == 0 {
+		return nil
+	}
+	if graph.Properties == nil {
+		graph.Properties = make(map[string]map[string]any)
+	}
+	for _, enricher := range enrichers {
+		byFile := graph.nodesByFile()
+		p := StartProgress("enriching", "files", len(graph.Files))
+		for _, file := range graph.Files {
+			if err := ctx.Err(); err != nil {
+				p.Finish()
+				return err
+			}
+			nodes := byFile[file.Path]
+			props, err := enricher.Enrich(ctx, file, nodes)
+			if err != nil {
+				p.Finish()
+				return fmt.Errorf("enriching %s: %w", file.Path, err)
+			}
+			if err := graph.setProperties(nodes, props); err != nil {
+				p.Finish()
+				return fmt.Errorf("enriching %s: %w", file.Path, err)
+			}
+			p.Add(1)
+		}
+		p.Finish()
+	}
+	return nil
+}
+
+// nodesByFile groups the nodes by the file declaring them. A package goes
+// with the first of its files.
+func (g *Graph) nodesByFile() map[string][]GraphNode {
+	packageFile := make(map[string]string)
+	for _, file := range g.Files {
+		if _, ok := packageFile[filepath.Dir(file.Path)]; !ok {
+			packageFile[filepath.Dir(file.Path)] = file.Path
+		}
+	}
+	byFile := make(map[string][]GraphNode)
+	for _, node := range g.Nodes() {
+		file := nodeFile(node)
+		if node.Label == "Package" {
+			file = packageFile[file]
+		}
+		byFile[file] = append(byFile[file], node)
+	}
+	return byFile
+}
+
+// nodeFile returns the file a node is declared in, or the path of a file or
+// package node
+func nodeFile(node GraphNode) string {
+	if node.Label == "Package" || node.Label == "File" {
+		return PropString(node.Props, "path")
+	}
+	return PropString(node.Props, "file")
+}
+
+// setProperties records the properties an enricher returned for nodes,
+// checking each names one of them and can be stored as a property
+func (g *Graph) setProperties(nodes []GraphNode, props map[string]map[string]any) error {
+	for key, values := range props {
+		i := slices.IndexFunc(nodes, func(n GraphNode) bool { return n.Key == key })
+		if i < 0 {
+			return fmt.Errorf("no node %q in this file", key)
+		}
+		for name, value := range values {
+			_, parsed := nodes[i].Props[name]
+			_, custom := g.Properties[key][name]
+			if slices.Contains(reservedProperties, name) || parsed && !custom {
+				return fmt.Errorf("%s: property %q is reserved", key, name)
+			}
+			v, err := propertyValue(value)
+			if err != nil {
+				return fmt.Errorf("%s: property %q: %w", key, name, err)
+			}
+			if g.Properties[key] == nil {
+				g.Properties[key] = make(map[string]any)
+			}
+			g.Properties[key][name] = v
+		}
+	}
+	return nil
+}
+
+// propertyValue checks value is a string, number, boolean or list of them,
+// which every backend can store. Whole JSON numbers become integers and
+// lists of strings []string.
+func propertyValue(value any) (any, error) {
+	switch v := value.(type) {
+	case string, bool, int, int64, []string:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	case []any:
+		strs := make([]string, 0, len(v))
+		items := make([]any, len(v))
+		for i, item := range v {
+			if _, ok := item.([]any); ok {
+				return nil, errors.New("lists cannot be nested")
+			}
+			converted, err := propertyValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
+			if s, ok := converted.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		if len(strs) == len(v) {
+			return strs, nil
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%v is a %T, not a string, number, boolean or list of them", value, value)
+}
+
+// ProcessEnricher is an Enricher run as a subprocess, so enrichers can be
+// written in any language and added without rebuilding. For each file it
+// writes a line of JSON to the process's stdin:
+//
+//	{"file": {"path": "cmd/main.go", ...}, "nodes": [{"key": "...", "label": "Function", "properties": {...}}]}
+//
+// and reads one line back from its stdout, with the properties to add by
+// node key, or an error:
+//
+//	{"properties": {"Function:cmd/main.go:run": {"team": "platform"}}}
+//	{"error": "cannot read CODEOWNERS"}
+//
+// The process's stderr is passed through.
+type ProcessEnricher struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+// enrichRequest is the line written to an enricher process for each file
+type enrichRequest struct {
+	File  FileNode     `json:"file"`
+	Nodes []enrichNode `json:"nodes"`
+}
+
+type enrichNode struct {
+	Key        string         `json:"key"`
+	Label      string         `json:"label"`
+	Properties map[string]any `json:"properties"`
+}
+
+// enrichResponse is the line an enricher process answers each file with
+type enrichResponse struct {
+	Properties map[string]map[string]any `json:"properties"`
+	Error      string                    `json:"error"`
+}
+
+// StartEnricher starts command with sh -c in dir, keeping it running to
+// enrich file after file until Close. Cancelling ctx kills it.
+func StartEnricher(ctx context.Context, command, dir string) (*ProcessEnricher, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting enricher %q: %w", command, err)
+	}
+	return &ProcessEnricher{command: command, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (e *ProcessEnricher) Enrich(ctx context.Context, file FileNode, nodes []GraphNode) (map[string]map[string]any, error) {
+	req := enrichRequest{File: file, Nodes: make([]enrichNode, len(nodes))}
+	for i, node := range nodes {
+		req.Nodes[i] = enrichNode{Key: node.Key, Label: node.Label, Properties: node.Props}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.stdin.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("enricher %q: %w", e.command, err)
+	}
+	line, err := e.stdout.ReadBytes('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("enricher %q exited without answering", e.command)
+		}
+		return nil, fmt.Errorf("enricher %q: %w", e.command, err)
+	}
+	var resp enrichResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("enricher %q answered %q: %w", e.command, strings.TrimSpace(string(line)), err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("enricher %q: %s", e.command, resp.Error)
+	}
+	return resp.Properties, nil
+}
+
+// Close closes the process's stdin, which it should take as the end of the
+// run, and waits for it to exit
+func (e *ProcessEnricher) Close() error {
+	e.stdin.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("enricher %q: %w", e.command, err)
+	}
+	return nil
+}
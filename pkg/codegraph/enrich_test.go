@@ -0,0 +1,178 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enricherFunc adapts a function to Enricher
+type enricherFunc func(file FileNode, nodes []GraphNode) (map[string]map[string]any, error)
+
+func (f enricherFunc) Enrich(ctx context.Context, file FileNode, nodes []GraphNode) (map[string]map[string]any, error) {
+	return f(file, nodes)
+}
+
+func TestEnrich(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	seen := make(map[string][]string)
+	team := enricherFunc(func(file FileNode, nodes []GraphNode) (map[string]map[string]any, error) {
+		props := make(map[string]map[string]any)
+		for _, node := range nodes {
+			seen[file.Path] = append(seen[file.Path], node.Key)
+			props[node.Key] = map[string]any{"team": "core"}
+		}
+		if file.Path == "store/store.go" {
+			props["File:store/store.go"] = map[string]any{"team": "storage", "flags": []any{"cache", "wal"}, "reviewers": 2.0}
+		}
+		return props, nil
+	})
+	// A second enricher sees the first one's properties and may replace them
+	override := enricherFunc(func(file FileNode, nodes []GraphNode) (map[string]map[string]any, error) {
+		for _, node := range nodes {
+			if node.Key == "Function:main.go:main" && node.Props["team"] == "core" {
+				return map[string]map[string]any{node.Key: {"team": "cli"}}, nil
+			}
+		}
+		return nil, nil
+	})
+	if err := Enrich(context.Background(), graph, team, override); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Contains(seen["main.go"], "Package:.") || slices.Contains(seen["store/store.go"], "Package:.") {
+		t.Errorf("package . not sent with main.go only: %v", seen)
+	}
+	if !slices.Contains(seen["store/store.go"], "Struct:store/store.go:Store") {
+		t.Errorf("store.go's struct not sent with it: %v", seen["store/store.go"])
+	}
+
+	props := make(map[string]map[string]any)
+	for _, node := range graph.Nodes() {
+		props[node.Key] = node.Props
+	}
+	for key, want := range map[string]map[string]any{
+		"File:store/store.go":   {"team": "storage", "flags": []string{"cache", "wal"}, "reviewers": int64(2)},
+		"Function:main.go:main": {"team": "cli"},
+		"Function:main.go:run":  {"team": "core"},
+	} {
+		for name, value := range want {
+			if got := props[key][name]; !reflect.DeepEqual(got, value) {
+				t.Errorf("%s %s = %#v, want %#v", key, name, got, value)
+			}
+		}
+	}
+	if props["File:main.go"]["path"] != "main.go" {
+		t.Errorf("parsed properties lost: %v", props["File:main.go"])
+	}
+}
+
+func TestEnrichRejects(t *testing.T) {
+	tests := []struct {
+		key   string
+		props map[string]any
+		want  string
+	}{
+		{"File:main.go", map[string]any{"path": "other.go"}, `property "path" is reserved`},
+		{"File:main.go", map[string]any{"runId": "x"}, `property "runId" is reserved`},
+		{"File:main.go", map[string]any{"owners": map[string]any{"a": 1}}, "not a string, number, boolean"},
+		{"File:main.go", map[string]any{"matrix": []any{[]any{1}}}, "lists cannot be nested"},
+		{"File:store/store.go", map[string]any{"team": "core"}, `no node "File:store/store.go" in this file`},
+	}
+	for _, tt := range tests {
+		graph := parseTestTree(t, Filter{})
+		e := enricherFunc(func(file FileNode, nodes []GraphNode) (map[string]map[string]any, error) {
+			if file.Path != "main.go" {
+				return nil, nil
+			}
+			return map[string]map[string]any{tt.key: tt.props}, nil
+		})
+		err := Enrich(context.Background(), graph, e)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %v: err = %v, want %q", tt.key, tt.props, err, tt.want)
+		}
+	}
+}
+
+func TestProcessEnricher(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	// Answers every file by tagging its File node, read from the request
+	script := `while read -r line; do
+		path=$(printf '%s' "$line" | sed 's/^{"file":{"path":"\([^"]*\)".*/\1/')
+		printf '{"properties": {"File:%s": {"team": "platform"}}}\n' "$path"
+	done`
+	e, err := StartEnricher(context.Background(), script, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Enrich(context.Background(), graph, e); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"File:main.go", "File:store/store.go"} {
+		if got := graph.Properties[key]["team"]; got != "platform" {
+			t.Errorf("%s team = %v, want platform", key, got)
+		}
+	}
+
+	failing, err := StartEnricher(context.Background(), `read -r line; echo '{"error": "no CODEOWNERS"}'`, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Enrich(context.Background(), parseTestTree(t, Filter{}), failing)
+	if err == nil || !strings.Contains(err.Error(), "no CODEOWNERS") {
+		t.Errorf("err = %v, want the enricher's error", err)
+	}
+	failing.Close()
+
+	exited, err := StartEnricher(context.Background(), "exit 0", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Enrich(context.Background(), parseTestTree(t, Filter{}), exited)
+	if err == nil {
+		t.Error("no error from an enricher that exited")
+	}
+	exited.Close()
+}
+
+func TestBuildStatementsProperties(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Properties = map[string]map[string]any{
+		"Package:.":                          {"team": "core"},
+		"File:main.go":                       {"team": "cli"},
+		"Function:main.go:run":               {"flags": []string{"beta"}},
+		"Struct:store/store.go:Store":        {"team": "storage"},
+		"Function:store/store.go:New":        {"team": "storage"},
+		"Function:store/store.go:*Store.Put": {"team": "storage"},
+	}
+
+	stmts := BuildStatements("App", graph, RunInfo{ID: "run-1", StartedAt: time.Now()}, StatementOptions{Files: []string{"main.go"}})
+	queries := make(map[string]string)
+	rows := make(map[string][]map[string]any)
+	for _, stmt := range stmts {
+		if stmt.Desc != "annotating custom properties" {
+			continue
+		}
+		for _, label := range NodeLabels {
+			if strings.Contains(stmt.Query, ":App:"+label+" ") {
+				queries[label] = stmt.Query
+				rows[label] = stmt.Rows
+			}
+		}
+	}
+	if len(rows["Package"]) != 1 || len(rows["File"]) != 1 || len(rows["Function"]) != 1 || len(rows["Struct"]) != 0 {
+		t.Fatalf("rows = %v, want main.go's package, file and run only", rows)
+	}
+	if q := queries["Function"]; !strings.Contains(q, "{file: row.file, name: row.name, receiver: row.receiver}") || !strings.Contains(q, "SET n += row.props") {
+		t.Errorf("function query does not match on its keys and set the properties:\n%s", q)
+	}
+	if got := rows["Function"][0]; got["name"] != "run" || !reflect.DeepEqual(got["props"], map[string]any{"flags": []string{"beta"}}) {
+		t.Errorf("function row = %v", got)
+	}
+}
@@ -0,0 +1,83 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"slices"
+	"strings"
+)
+
+// Discard is a call throwing results away, made on Line: all of them when
+// Blanks is nil, or else those at the indexes in Blanks, assigned to the
+// blank identifier. Call is written like the callees of FunctionNode.Calls.
+type Discard struct {
+	Call   string `json:"call"`
+	Line   int    `json:"line"`
+	Blanks []int  `json:"blanks,omitempty"`
+}
+
+// DiscardedError is the kind of finding DiscardedErrors reports
+const DiscardedError = "discarded-error"
+
+// DiscardedErrors finds the calls in g's functions throwing away an error
+// the function called returns, by calling it as a statement or assigning
+// the error to the blank identifier. Callees are resolved like
+// Graph.Calls, and must all return an error where it is thrown away, so
+// calls into other modules and the standard library, whose signatures the
+// graph does not hold, are not checked. Findings are ordered by file and
+// line.
+func DiscardedErrors(g *Graph) []Finding {
+	resolve := g.callResolver()
+	var findings []Finding
+	for _, fn := range g.Functions {
+		name := fn.Name
+		if fn.Receiver != "" {
+			name = strings.TrimPrefix(fn.Receiver, "*") + "." + fn.Name
+		}
+		for _, d := range fn.Discards {
+			targets := resolve(fn, d.Call)
+			if len(targets) == 0 {
+				continue
+			}
+			discarded := true
+			for _, target := range targets {
+				errs := errorResults(target)
+				discarded = discarded && len(errs) > 0 &&
+					(d.Blanks == nil || slices.ContainsFunc(errs, func(i int) bool { return slices.Contains(d.Blanks, i) }))
+			}
+			if !discarded {
+				continue
+			}
+			finding := Finding{Kind: DiscardedError, Key: fn.Key(), Name: name, File: fn.File, Line: d.Line,
+				Message: fmt.Sprintf("%s ignores the error %s returns", name, d.Call)}
+			if d.Blanks != nil {
+				finding.Message = fmt.Sprintf("%s assigns the error %s returns to _", name, d.Call)
+			}
+			findings = append(findings, finding)
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), a.Line-b.Line)
+	})
+	return findings
+}
+
+// errorResults returns the indexes of the results of fn of type error
+func errorResults(fn FunctionNode) []int {
+	ft := signatureType(fn)
+	if ft == nil || ft.Results == nil {
+		return nil
+	}
+	var indexes []int
+	i := 0
+	for _, field := range ft.Results.List {
+		for range max(len(field.Names), 1) {
+			if id, ok := field.Type.(*ast.Ident); ok && id.Name == "error" {
+				indexes = append(indexes, i)
+			}
+			i++
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,60 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiscardedErrors(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": `package main
+
+import "example.com/app/store"
+
+func main() {
+	store.Open("db")
+	s, _ := store.Open("db")
+	_, err := store.Open("db")
+	_ = err
+	s.Close()
+	defer s.Close()
+	s.Len()
+	_ = s.Close()
+	println(s)
+}
+`,
+		"store/store.go": `package store
+
+type Store struct{}
+
+func Open(name string) (*Store, error) { return &Store{}, nil }
+
+func (s *Store) Close() error { return nil }
+
+func (s *Store) Len() int { return 0 }
+`,
+	})
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, finding := range DiscardedErrors(graph) {
+		if finding.Kind != DiscardedError || finding.Key != "Function:main.go:main" {
+			t.Errorf("finding = %+v", finding)
+		}
+		got = append(got, fmt.Sprintf("%d %s", finding.Line, finding.Message))
+	}
+	want := []string{
+		"6 main ignores the error store.Open returns",
+		"7 main assigns the error store.Open returns to _",
+		"10 main ignores the error s.Close returns",
+		"13 main assigns the error s.Close returns to _",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+}
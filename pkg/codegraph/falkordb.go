@@ -0,0 +1,403 @@
+package codegraph
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FalkorDBWriter writes to FalkorDB (the successor to RedisGraph), which is
+// queried with GRAPH.QUERY commands over the Redis protocol rather than
+// Bolt. It runs the same batched statements as the Neo4j backend; FalkorDB
+// has no explicit transactions, so each batch is committed on its own, and
+// timestamps are stored as RFC 3339 strings.
+type FalkorDBWriter struct {
+	mu         sync.Mutex // serialises commands on conn
+	conn       net.Conn
+	r          *bufio.Reader
+	graph      string
+	Statements StatementOptions
+	// Redact rewrites the text of memories, decisions and summaries before
+	// they are stored
+	Redact []RedactRule
+
+	BatchSize        int
+	StatementTimeout time.Duration
+	stats            WriteStats
+}
+
+// OpenFalkorDB connects to FalkorDB at addr, authenticating if password is
+// set, and writes to graph
+func OpenFalkorDB(ctx context.Context, addr, graph, password string) (*FalkorDBWriter, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to FalkorDB: %w", err)
+	}
+	b := &FalkorDBWriter{conn: conn, r: bufio.NewReader(conn), graph: graph}
+
+	if password != "" {
+		if _, err := b.do(ctx, "AUTH", password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	if _, err := b.do(ctx, "PING"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot connect to FalkorDB: %w", err)
+	}
+	slog.Info("connected to FalkorDB", "addr", addr, "graph", graph)
+	return b, nil
+}
+
+// do sends one command and reads its reply. The context's deadline, or its
+// cancellation, interrupts the round trip.
+func (b *FalkorDBWriter) do(ctx context.Context, args ...string) (any, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	b.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { b.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(b.conn, buf.String()); err != nil {
+		return nil, err
+	}
+	reply, err := readRESP(b.r)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+// query runs a Cypher query against the graph, passing params in the
+// CYPHER name=value prefix FalkorDB uses for parameters
+func (b *FalkorDBWriter) query(ctx context.Context, query string, params map[string]any) (any, error) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var prefix strings.Builder
+	if len(keys) > 0 {
+		prefix.WriteString("CYPHER")
+		for _, key := range keys {
+			fmt.Fprintf(&prefix, " %s=%s", key, CypherLiteral(falkorValue(params[key])))
+		}
+		prefix.WriteString(" ")
+	}
+
+	args := []string{"GRAPH.QUERY", b.graph, prefix.String() + TrimQuery(query)}
+	if b.StatementTimeout > 0 {
+		args = append(args, "TIMEOUT", strconv.FormatInt(b.StatementTimeout.Milliseconds(), 10))
+	}
+	return b.do(ctx, args...)
+}
+
+// falkorValue replaces the times in a parameter with RFC3339 strings, as
+// FalkorDB has no datetime type
+func falkorValue(value any) any {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = falkorValue(item)
+		}
+		return items
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, item := range v {
+			m[key] = falkorValue(item)
+		}
+		return m
+	default:
+		return value
+	}
+}
+
+// readRESP reads one RESP2 reply, returning error replies as errors
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("malformed reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+func (b *FalkorDBWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	return b.write(ctx, project, graph, run, b.Statements)
+}
+
+func (b *FalkorDBWriter) WriteFiles(ctx context.Context, project string, graph *Graph, files []string, run RunInfo) error {
+	opts := b.Statements
+	opts.Files = files
+	return b.write(ctx, project, graph, run, opts)
+}
+
+func (b *FalkorDBWriter) write(ctx context.Context, project string, graph *Graph, run RunInfo, opts StatementOptions) error {
+	slog.Info("creating graph nodes", "project", project)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := StartProgress("writing", "rows", StatementRows(graph, opts))
+	defer p.Finish()
+
+	phase := ""
+	for stmt := range StreamStatements(ctx, project, graph, run, opts) {
+		if stmt.Phase != phase {
+			phase = stmt.Phase
+			slog.Info("writing", "phase", phase)
+		}
+		for _, batch := range stmt.Batches(b.BatchSize) {
+			batchCtx, span := tracer.Start(ctx, "write batch", trace.WithAttributes(
+				attribute.String("codegraph.statement", stmt.Desc),
+				attribute.Int("codegraph.rows", len(batch.Rows))))
+			_, err := b.query(batchCtx, batch.Query, batch.Params)
+			endSpan(span, err)
+			if err != nil {
+				return fmt.Errorf("%s: %w", stmt.Desc, err)
+			}
+			b.stats.Statements++
+			b.stats.addBatch(len(batch.Rows))
+			p.Add(len(batch.Rows))
+		}
+	}
+	if err := cypherRelink(ctx, b, opts.Labels, project); err != nil {
+		return fmt.Errorf("relinking memories, decisions, sessions and links: %w", err)
+	}
+
+	// Print summary. The reply is a header, the result rows and statistics.
+	reply, err := b.query(ctx, fmt.Sprintf(`
+		MATCH (n:%s) WHERE NOT coalesce(n.deleted, false)
+		RETURN labels(n) AS labels, count(*) AS count
+	`, project), nil)
+	if err != nil {
+		return fmt.Errorf("getting summary: %w", err)
+	}
+
+	if parts, ok := reply.([]any); ok && len(parts) == 3 {
+		rows, _ := parts[1].([]any)
+		for _, row := range rows {
+			if cols, ok := row.([]any); ok && len(cols) == 2 {
+				slog.Info("graph summary", "labels", cols[0], "count", cols[1])
+			}
+		}
+	}
+	return nil
+}
+
+// Query runs a read or write query. FalkorDB replies with a header of column
+// names, the rows and statistics; a query returning nothing has no header.
+func (b *FalkorDBWriter) Query(ctx context.Context, query string, params map[string]any) (columns []string, rows [][]any, err error) {
+	ctx, span := startQuery(ctx, "falkordb", query)
+	defer func() { endSpan(span, err) }()
+
+	reply, err := b.query(ctx, query, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	parts, _ := reply.([]any)
+	if len(parts) < 3 {
+		return nil, nil, nil
+	}
+	header, _ := parts[0].([]any)
+	for _, column := range header {
+		// Compact replies pair each name with a column type
+		if pair, ok := column.([]any); ok && len(pair) == 2 {
+			column = pair[1]
+		}
+		name, _ := column.(string)
+		columns = append(columns, name)
+	}
+	records, _ := parts[1].([]any)
+	for _, record := range records {
+		values, _ := record.([]any)
+		rows = append(rows, values)
+	}
+	return columns, rows, nil
+}
+
+func (b *FalkorDBWriter) Projects(ctx context.Context) ([]string, error) {
+	return labelProjects(ctx, b, b.Statements.Labels)
+}
+
+func (b *FalkorDBWriter) DeleteProject(ctx context.Context, project string) (int, error) {
+	return cypherDeleteProject(ctx, b, project)
+}
+
+func (b *FalkorDBWriter) Snapshots(ctx context.Context, project string) ([]Snapshot, error) {
+	return cypherSnapshots(ctx, b, project)
+}
+
+func (b *FalkorDBWriter) Prune(ctx context.Context, project string, before time.Time) (int, error) {
+	return cypherPrune(ctx, b, project, before)
+}
+
+func (b *FalkorDBWriter) Remember(ctx context.Context, project string, memory Memory) (Memory, error) {
+	return cypherRemember(ctx, b, b.Statements.Labels, project, RedactMemory(b.Redact, memory))
+}
+
+func (b *FalkorDBWriter) Recall(ctx context.Context, project string, q MemoryQuery) ([]Memory, error) {
+	return cypherRecall(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *FalkorDBWriter) Forget(ctx context.Context, project, id string) (bool, error) {
+	return cypherForget(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *FalkorDBWriter) PinMemory(ctx context.Context, project, id string, pinned bool) (bool, error) {
+	return cypherPinMemory(ctx, b, b.Statements.Labels, project, id, pinned)
+}
+
+func (b *FalkorDBWriter) ArchiveMemories(ctx context.Context, project string, ids []string) (int, error) {
+	return cypherArchiveMemories(ctx, b, b.Statements.Labels, project, ids)
+}
+
+func (b *FalkorDBWriter) ImportMemories(ctx context.Context, project string, memories []Memory) (int, error) {
+	return cypherImportMemories(ctx, b, b.Statements.Labels, project, redactMemories(b.Redact, memories))
+}
+
+func (b *FalkorDBWriter) ImportDecisions(ctx context.Context, project string, decisions []Decision) (int, error) {
+	return cypherImportDecisions(ctx, b, b.Statements.Labels, project, redactDecisions(b.Redact, decisions))
+}
+
+func (b *FalkorDBWriter) Decide(ctx context.Context, project string, decision Decision) (Decision, error) {
+	return cypherDecide(ctx, b, b.Statements.Labels, project, RedactDecision(b.Redact, decision))
+}
+
+func (b *FalkorDBWriter) Decisions(ctx context.Context, project string, q DecisionQuery) ([]Decision, error) {
+	return cypherDecisions(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *FalkorDBWriter) SetDecisionStatus(ctx context.Context, project, id, status string) (Decision, error) {
+	return cypherSetDecisionStatus(ctx, b, b.Statements.Labels, project, id, status)
+}
+
+func (b *FalkorDBWriter) StartSession(ctx context.Context, project string, session Session) (Session, error) {
+	return cypherStartSession(ctx, b, b.Statements.Labels, project, session)
+}
+
+func (b *FalkorDBWriter) EndSession(ctx context.Context, project, id string) (Session, error) {
+	return cypherEndSession(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *FalkorDBWriter) TouchFiles(ctx context.Context, project, id string, paths []string) error {
+	return cypherTouchFiles(ctx, b, b.Statements.Labels, project, id, paths)
+}
+
+func (b *FalkorDBWriter) Sessions(ctx context.Context, project string, limit int) ([]Session, error) {
+	return cypherSessions(ctx, b, b.Statements.Labels, project, limit)
+}
+
+func (b *FalkorDBWriter) Replay(ctx context.Context, project, id string, namespaces []string) (Session, []Memory, error) {
+	return cypherReplay(ctx, b, b.Statements.Labels, project, id, namespaces)
+}
+
+func (b *FalkorDBWriter) Summarize(ctx context.Context, project string, summary Summary) (Summary, error) {
+	return cypherSummarize(ctx, b, b.Statements.Labels, project, RedactSummary(b.Redact, summary))
+}
+
+func (b *FalkorDBWriter) Summaries(ctx context.Context, project string, q SummaryQuery) ([]Summary, error) {
+	return cypherSummaries(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *FalkorDBWriter) AddConvention(ctx context.Context, project string, convention Convention) (Convention, error) {
+	return cypherAddConvention(ctx, b, b.Statements.Labels, project, convention)
+}
+
+func (b *FalkorDBWriter) Conventions(ctx context.Context, project string, q ConventionQuery) ([]Convention, error) {
+	return cypherConventions(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *FalkorDBWriter) RemoveConvention(ctx context.Context, project, id string) (bool, error) {
+	return cypherRemoveConvention(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *FalkorDBWriter) ReplaceFindings(ctx context.Context, project, analysis string, findings []Finding) error {
+	return cypherReplaceFindings(ctx, b, b.Statements.Labels, project, analysis, findings)
+}
+
+func (b *FalkorDBWriter) ReplaceCycles(ctx context.Context, project string, cycles []Cycle) error {
+	return cypherReplaceCycles(ctx, b, b.Statements.Labels, project, cycles)
+}
+
+func (b *FalkorDBWriter) LinkProjects(ctx context.Context, link CrossLink) (CrossLink, error) {
+	return cypherLinkProjects(ctx, b, b.Statements.Labels, link)
+}
+
+func (b *FalkorDBWriter) LinkImports(ctx context.Context, project, library, module string) ([]CrossLink, error) {
+	return cypherLinkImports(ctx, b, b.Statements.Labels, project, library, module)
+}
+
+func (b *FalkorDBWriter) CrossLinks(ctx context.Context, project string) ([]CrossLink, error) {
+	return cypherCrossLinks(ctx, b, b.Statements.Labels, project)
+}
+
+func (b *FalkorDBWriter) Unlink(ctx context.Context, project, id string) (bool, error) {
+	return cypherUnlink(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *FalkorDBWriter) Stats() WriteStats {
+	return b.stats
+}
+
+func (b *FalkorDBWriter) Close(ctx context.Context) error {
+	return b.conn.Close()
+}
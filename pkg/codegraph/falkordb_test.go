@@ -0,0 +1,217 @@
+package codegraph
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRESP(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  any
+		err   bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{"-ERR unknown command\r\n", nil, true},
+		{":42\r\n", int64(42), false},
+		{"$5\r\nhe\r\no\r\n", "he\r\no", false},
+		{"$-1\r\n", nil, false},
+		{"*2\r\n$1\r\na\r\n*1\r\n:1\r\n", []any{"a", []any{int64(1)}}, false},
+		{"?\r\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := readRESP(bufio.NewReader(strings.NewReader(tt.reply)))
+		if (err != nil) != tt.err {
+			t.Errorf("readRESP(%q) error = %v, want error %v", tt.reply, err, tt.err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("readRESP(%q) = %#v, want %#v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+// fakeFalkor serves the Redis protocol on a local port, answering PING and
+// every GRAPH.QUERY with a fixed reply, and records the commands it gets
+type fakeFalkor struct {
+	addr     string
+	commands chan []string
+}
+
+// startFakeFalkor starts a fake whose queries all return an empty result
+func startFakeFalkor(t *testing.T) *fakeFalkor {
+	t.Helper()
+	return startFakeFalkorReply(t, "*3\r\n*0\r\n*0\r\n*0\r\n")
+}
+
+func startFakeFalkorReply(t *testing.T, queryReply string) *fakeFalkor {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeFalkor{addr: l.Addr().String(), commands: make(chan []string, 1000)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			request, err := readRESP(r)
+			if err != nil {
+				return
+			}
+			var args []string
+			for _, arg := range request.([]any) {
+				args = append(args, arg.(string))
+			}
+			f.commands <- args
+			reply := queryReply
+			if args[0] != "GRAPH.QUERY" {
+				reply = "+OK\r\n"
+			}
+			if _, err := io.WriteString(conn, reply); err != nil {
+				return
+			}
+		}
+	}()
+	return f
+}
+
+func TestFalkorDBWriter(t *testing.T) {
+	ctx := context.Background()
+	fake := startFakeFalkor(t)
+	backend, err := OpenFalkorDB(ctx, fake.addr, "code", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+	backend.BatchSize = 2
+	backend.StatementTimeout = 1500 * time.Millisecond
+
+	graph := parseTestTree(t, Filter{})
+	run := RunInfo{ID: "run-1", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := backend.Write(ctx, "App", graph, run); err != nil {
+		t.Fatal(err)
+	}
+	close(fake.commands)
+
+	var queries []string
+	for args := range fake.commands {
+		switch args[0] {
+		case "AUTH":
+			if args[1] != "secret" {
+				t.Errorf("AUTH %q, want secret", args[1])
+			}
+		case "GRAPH.QUERY":
+			if args[1] != "code" || args[len(args)-2] != "TIMEOUT" || args[len(args)-1] != "1500" {
+				t.Errorf("GRAPH.QUERY args = %q", args)
+			}
+			queries = append(queries, args[2])
+		}
+	}
+
+	want := "CYPHER commit=null now='2024-01-02T03:04:05Z' rows=[{`name`: 'main', `path`: '.'}, {`name`: 'store', `path`: 'store'}] runId='run-1' UNWIND $rows AS row\n" +
+		"CREATE (p:App:Package {path: row.path, name: row.name, createdAt: $now, updatedAt: $now, runId: $runId, commit: $commit})"
+	if !slices.Contains(queries, want) {
+		t.Errorf("packages not created by\n%s\nin\n%s", want, strings.Join(queries, "\n\n"))
+	}
+	// Besides the statements, the write reads the memories' keys and
+	// mentions, decisions, summaries, sessions, conventions, findings,
+	// cycles and links to relink and the summary
+	if stats := backend.Stats(); stats.Statements != len(queries)-10 || stats.BatchRows == 0 {
+		t.Errorf("stats = %+v after %d write queries", stats, len(queries)-10)
+	}
+	if !slices.ContainsFunc(queries, func(q string) bool { return strings.HasPrefix(q, "MATCH (n:App:Memory)\nUNWIND n.about AS item") }) {
+		t.Errorf("memories not relinked by\n%s", strings.Join(queries, "\n\n"))
+	}
+}
+
+func TestFalkorValue(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{at, "'2024-01-02T03:04:05Z'"},
+		{"text", "'text'"},
+		{[]any{map[string]any{"at": at, "n": 1}}, "[{`at`: '2024-01-02T03:04:05Z', `n`: 1}]"},
+	}
+	for _, tt := range tests {
+		if got := CypherLiteral(falkorValue(tt.value)); got != tt.want {
+			t.Errorf("falkorValue(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFalkorDBQuery(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		reply   string
+		columns []string
+		rows    string
+	}{
+		{"empty", "*1\r\n*0\r\n", nil, "[]"},
+		{"plain header", "*3\r\n*2\r\n$4\r\nname\r\n$5\r\ncalls\r\n*1\r\n*2\r\n$5\r\nParse\r\n:2\r\n*0\r\n",
+			[]string{"name", "calls"}, "[[Parse 2]]"},
+		{"compact header", "*3\r\n*1\r\n*2\r\n:1\r\n$4\r\nname\r\n*2\r\n*1\r\n$1\r\na\r\n*1\r\n$1\r\nb\r\n*0\r\n",
+			[]string{"name"}, "[[a] [b]]"},
+	}
+	for _, tt := range tests {
+		fake := startFakeFalkorReply(t, tt.reply)
+		backend, err := OpenFalkorDB(ctx, fake.addr, "code", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, rows, err := backend.Query(ctx, "MATCH (f:Function {name: $name}) RETURN f.name", map[string]any{"name": "Parse"})
+		backend.Close(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(columns, tt.columns) || fmt.Sprint(rows) != tt.rows {
+			t.Errorf("%s: Query = %q, %v, want %q, %s", tt.name, columns, rows, tt.columns, tt.rows)
+		}
+		close(fake.commands)
+		for args := range fake.commands {
+			if args[0] == "GRAPH.QUERY" && args[2] != "CYPHER name='Parse' MATCH (f:Function {name: $name}) RETURN f.name" {
+				t.Errorf("%s: sent %q", tt.name, args[2])
+			}
+		}
+	}
+}
+
+func TestFalkorDBProjects(t *testing.T) {
+	ctx := context.Background()
+	fake := startFakeFalkorReply(t, "*3\r\n*1\r\n$5\r\nlabel\r\n*2\r\n*1\r\n$3\r\nApp\r\n*1\r\n$3\r\nWeb\r\n*0\r\n")
+	backend, err := OpenFalkorDB(ctx, fake.addr, "code", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Statements.Labels = LabelMap{Prefix: "CG_"}
+	projects, err := backend.Projects(ctx)
+	backend.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"App", "Web"}; !slices.Equal(projects, want) {
+		t.Errorf("Projects() = %v, want %v", projects, want)
+	}
+	close(fake.commands)
+	for args := range fake.commands {
+		if args[0] == "GRAPH.QUERY" && (!strings.Contains(args[2], "MATCH (p:CG_Package)") || !strings.Contains(args[2], "package='CG_Package'")) {
+			t.Errorf("projects listed by\n%s", args[2])
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package codegraph
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// featureDefaults lists the extractors --features can switch on and off,
+// and whether each runs when it is not mentioned
+var featureDefaults = map[string]bool{
+	"calls":      true,
+	"imports":    true,
+	"implements": true,
+	"tests":      false,
+	"duplicates": false,
+}
+
+// Features holds the extractors switched by --features, e.g. "tests,-calls"
+type Features map[string]bool
+
+// Enabled reports whether the named extractor runs
+func (f Features) Enabled(name string) bool {
+	if on, ok := f[name]; ok {
+		return on
+	}
+	return featureDefaults[name]
+}
+
+func (f *Features) String() string {
+	var items []string
+	for name, on := range *f {
+		if !on {
+			name = "-" + name
+		}
+		items = append(items, name)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Set switches the comma-separated extractors on, or off when prefixed
+// with a minus; it may be called repeatedly
+func (f *Features) Set(list string) error {
+	if *f == nil {
+		*f = make(Features)
+	}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, off := strings.CutPrefix(item, "-")
+		name = strings.TrimPrefix(name, "+")
+		if _, ok := featureDefaults[name]; !ok {
+			known := slices.Sorted(maps.Keys(featureDefaults))
+			return fmt.Errorf("unknown feature %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		(*f)[name] = !off
+	}
+	return nil
+}
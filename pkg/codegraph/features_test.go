@@ -0,0 +1,29 @@
+package codegraph
+
+import (
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	var f Features
+	if !f.Enabled("calls") || f.Enabled("tests") {
+		t.Error("unset features do not take their defaults")
+	}
+	if err := f.Set("tests, -calls"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("+imports,,-implements"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"calls": false, "imports": true, "implements": false, "tests": true} {
+		if got := f.Enabled(name); got != want {
+			t.Errorf("Enabled(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if got := f.String(); got != "-calls,-implements,imports,tests" {
+		t.Errorf("String() = %q", got)
+	}
+	if err := f.Set("colour"); err == nil {
+		t.Error("unknown feature accepted")
+	}
+}
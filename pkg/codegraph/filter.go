@@ -0,0 +1,217 @@
+package codegraph
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Filter decides which files under the root are part of the graph.
+// Paths are slash-separated and relative to the root. Patterns are globs in
+// which ** matches any number of directories; a pattern without a slash
+// matches a file or directory name at any depth unless it starts with one,
+// and a directory match covers everything below it.
+type Filter struct {
+	Include        []string
+	Exclude        []string
+	Tests          bool // include _test.go files
+	FollowSymlinks bool // walk symlinks, see Walk
+
+	// MaxFileSize leaves out files of more bytes and MaxFiles the files
+	// after the first so many, with a warning; zero for no limit
+	MaxFileSize int64
+	MaxFiles    int
+}
+
+// Validate reports the first malformed pattern
+func (f Filter) Validate() error {
+	for _, pattern := range append(slices.Clone(f.Include), f.Exclude...) {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SkipDir reports whether nothing below the directory rel can be included
+func (f Filter) SkipDir(rel string) bool {
+	if rel == "." {
+		return false
+	}
+	return isSkippedDir(path.Base(rel)) || matchesAny(f.Exclude, rel)
+}
+
+// Includes reports whether the file rel is part of the graph
+func (f Filter) Includes(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if !(isSourceFile(rel) || f.Tests && strings.HasSuffix(rel, "_test.go")) || slices.ContainsFunc(strings.Split(path.Dir(rel), "/"), func(dir string) bool {
+		return dir != "." && isSkippedDir(dir)
+	}) {
+		return false
+	}
+	if matchesAny(f.Exclude, rel) {
+		return false
+	}
+	return len(f.Include) == 0 || matchesAny(f.Include, rel)
+}
+
+// Walk calls fn for dir, root or a directory below it, and each file and
+// directory below dir in lexical order, with its path relative to root,
+// leaving out the directories below dir that f skips. A symlink is skipped
+// unless FollowSymlinks is set; then info describes what it leads to, and
+// it is walked unless it leads inside root, where its target is walked by
+// its own path, or to a file or directory walked already, so nothing is
+// walked twice and links cannot loop. Dangling links are skipped.
+func (f Filter) Walk(root, dir string, fn func(path, rel string, info fs.FileInfo) error) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	w := walker{filter: f, root: root, realRoot: realRoot, seen: make(map[string]bool), fn: fn}
+	return w.walk(dir, info, true)
+}
+
+// walker is the state of a Filter.Walk: what it walked, by the path the
+// links to it resolve to
+type walker struct {
+	filter         Filter
+	root, realRoot string
+	seen           map[string]bool
+	fn             func(path, rel string, info fs.FileInfo) error
+}
+
+// walk walks path, described by info, and what is below it
+func (w *walker) walk(path string, info fs.FileInfo, top bool) error {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !top && w.filter.SkipDir(filepath.ToSlash(rel)) {
+		return nil
+	}
+	if w.filter.FollowSymlinks {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if w.seen[real] {
+			return nil
+		}
+		w.seen[real] = true
+	}
+	if err := w.fn(path, rel, info); err != nil || !info.IsDir() {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		var info fs.FileInfo
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if !w.filter.FollowSymlinks {
+				continue
+			}
+			target, err := filepath.EvalSymlinks(child)
+			if err != nil {
+				continue
+			}
+			if inside, err := filepath.Rel(w.realRoot, target); err == nil && inside != ".." && !strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+				continue
+			}
+			if info, err = os.Stat(child); err != nil {
+				return err
+			}
+		} else if info, err = entry.Info(); err != nil {
+			return err
+		}
+		if err := w.walk(child, info, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limiter keeps the files within the limits of a filter, warning of each
+// file too large and, by report, of how many more there were than it keeps
+type limiter struct {
+	filter     Filter
+	kept, over int
+}
+
+// keep reports whether the file rel of size bytes is kept
+func (l *limiter) keep(rel string, size int64) bool {
+	if l.filter.MaxFileSize > 0 && size > l.filter.MaxFileSize {
+		slog.Warn("skipping file over the size limit", "file", rel, "size", size, "limit", l.filter.MaxFileSize)
+		return false
+	}
+	if l.filter.MaxFiles > 0 && l.kept >= l.filter.MaxFiles {
+		l.over++
+		return false
+	}
+	l.kept++
+	return true
+}
+
+// report warns of the files left out over the limit of files
+func (l *limiter) report() {
+	if l.over > 0 {
+		slog.Warn("skipping files over the file limit", "skipped", l.over, "limit", l.filter.MaxFiles)
+	}
+}
+
+// matchesAny reports whether rel or one of its parent directories matches
+// any of the patterns
+func matchesAny(patterns []string, rel string) bool {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if anchored, ok := strings.CutPrefix(pattern, "/"); ok {
+			pattern = anchored
+		} else if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		for i := 1; i <= len(parts); i++ {
+			if matchSegments(strings.Split(pattern, "/"), parts[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where a
+// "**" segment matches zero or more path segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,118 @@
+package codegraph
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFilterIncludes(t *testing.T) {
+	tests := []struct {
+		filter Filter
+		path   string
+		want   bool
+	}{
+		{Filter{}, "main.go", true},
+		{Filter{}, "cmd/tool/main.go", true},
+		{Filter{}, filepath.Join("cmd", "tool", "main.go"), true},
+		{Filter{}, "README.md", false},
+		{Filter{}, "main_test.go", false},
+		{Filter{Tests: true}, "main_test.go", true},
+		{Filter{}, "vendor/dep/dep.go", false},
+		{Filter{}, "web/node_modules/x.go", false},
+		{Filter{}, ".git/hooks/x.go", false},
+		{Filter{Exclude: []string{"gen"}}, "api/gen/types.go", false},
+		{Filter{Exclude: []string{"/gen"}}, "api/gen/types.go", true},
+		{Filter{Exclude: []string{"/gen"}}, "gen/types.go", false},
+		{Filter{Exclude: []string{"*.pb.go"}}, "api/types.pb.go", false},
+		{Filter{Exclude: []string{"*.pb.go"}}, "api/types.go", true},
+		{Filter{Include: []string{"internal/**"}}, "internal/a/b.go", true},
+		{Filter{Include: []string{"internal/**"}}, "cmd/main.go", false},
+		{Filter{Include: []string{"**/api/*.go"}}, "svc/api/types.go", true},
+		{Filter{Include: []string{"internal"}, Exclude: []string{"internal/legacy"}}, "internal/legacy/old.go", false},
+		{Filter{Include: []string{"internal"}, Exclude: []string{"internal/legacy"}}, "internal/new.go", true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Includes(tt.path); got != tt.want {
+			t.Errorf("%+v.Includes(%q) = %v, want %v", tt.filter, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFilterSkipDir(t *testing.T) {
+	filter := Filter{Exclude: []string{"testdata", "/build"}}
+	for path, want := range map[string]bool{
+		".":               false,
+		"pkg":             false,
+		"vendor":          true,
+		".cache":          true,
+		"pkg/testdata":    true,
+		"build":           true,
+		"pkg/build":       false,
+		"pkg/build/stuff": false,
+	} {
+		if got := filter.SkipDir(path); got != want {
+			t.Errorf("SkipDir(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	if err := (Filter{Include: []string{"a/**/*.go"}}).Validate(); err != nil {
+		t.Errorf("valid pattern rejected: %v", err)
+	}
+	if err := (Filter{Exclude: []string{"a/[b"}}).Validate(); err == nil {
+		t.Error("malformed pattern accepted")
+	}
+}
+
+func TestFilterWalk(t *testing.T) {
+	root := writeTree(t, map[string]string{"main.go": "package main\n", "store/store.go": "package store\n"})
+	outside := writeTree(t, map[string]string{"lib/lib.go": "package lib\n", "util.go": "package util\n"})
+	for link, target := range map[string]string{
+		filepath.Join(root, "loop"):           root,
+		filepath.Join(root, "alias"):          filepath.Join(root, "store"),
+		filepath.Join(root, "vendored"):       outside,
+		filepath.Join(root, "util.go"):        filepath.Join(outside, "util.go"),
+		filepath.Join(root, "dangling.go"):    filepath.Join(root, "missing.go"),
+		filepath.Join(outside, "lib", "back"): outside,
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	linked := filepath.Join(t.TempDir(), "linked")
+	if err := os.Symlink(root, linked); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(filter Filter, root string) []string {
+		var files []string
+		if err := filter.Walk(root, root, func(path, rel string, info fs.FileInfo) error {
+			if path != filepath.Join(root, rel) {
+				t.Errorf("%s is not below %s at %s", path, root, rel)
+			}
+			if !info.IsDir() {
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	if got, want := walk(Filter{}, root), []string{"main.go", "store/store.go"}; !slices.Equal(got, want) {
+		t.Errorf("skipping symlinks walked %q, want %q", got, want)
+	}
+	// What links inside the root, back up a followed one or to a file
+	// walked already lead to is walked once
+	want := []string{"main.go", "store/store.go", "util.go", "vendored/lib/lib.go"}
+	if got := walk(Filter{FollowSymlinks: true}, root); !slices.Equal(got, want) {
+		t.Errorf("following symlinks walked %q, want %q", got, want)
+	}
+	if got := walk(Filter{FollowSymlinks: true}, linked); !slices.Equal(got, want) {
+		t.Errorf("through a linked root walked %q, want %q", got, want)
+	}
+}
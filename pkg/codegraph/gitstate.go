@@ -0,0 +1,119 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// GitState is the state of the code a memory or decision was recorded at:
+// the commit checked out, its branch and the number of the branch's pull
+// request, each empty if unknown
+type GitState struct {
+	Commit string `json:"commit,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	PR     int    `json:"pr,omitempty"`
+}
+
+// ResolveGitState returns the commit and branch checked out in dir, empty
+// if dir is not in a git repository
+func ResolveGitState(ctx context.Context, dir string) GitState {
+	return GitState{Commit: ResolveCommit(ctx, dir, ""), Branch: CurrentBranch(ctx, dir)}
+}
+
+// githubRemote matches the owner and name of a GitHub repository in an
+// HTTPS or SSH remote URL
+var githubRemote = regexp.MustCompile(`github\.com[:/]([^/]+/[^/]+?)(?:\.git)?/?$`)
+
+// GitHubRepo returns the owner/name of the GitHub repository dir's origin
+// remote points to, or "" if it is not on GitHub
+func GitHubRepo(ctx context.Context, dir string) string {
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	m := githubRemote.FindStringSubmatch(strings.TrimSpace(string(out)))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// GitHub looks up pull requests with the GitHub REST API
+type GitHub struct {
+	BaseURL string // default https://api.github.com
+	Repo    string // owner/name
+	Token   string
+	Client  *http.Client
+}
+
+// PullRequest returns the number of the open pull request from branch, or
+// 0 if there is none
+func (g *GitHub) PullRequest(ctx context.Context, branch string) (int, error) {
+	owner, _, ok := strings.Cut(g.Repo, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid GitHub repository %q, want owner/name", g.Repo)
+	}
+	u := fmt.Sprintf("%s/repos/%s/pulls?state=open&head=%s", strings.TrimSuffix(cmp.Or(g.BaseURL, "https://api.github.com"), "/"),
+		g.Repo, url.QueryEscape(owner+":"+branch))
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	r.Header.Set("Accept", "application/vnd.github+json")
+	if g.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+	res, err := cmp.Or(g.Client, http.DefaultClient).Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return 0, fmt.Errorf("%s: %s: %s", u, res.Status, strings.TrimSpace(string(msg)))
+	}
+	var pulls []struct {
+		Number int `json:"number"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&pulls); err != nil {
+		return 0, err
+	}
+	if len(pulls) == 0 {
+		return 0, nil
+	}
+	return pulls[0].Number, nil
+}
+
+// gitState reads the commit, branch and pull request columns of a memory
+// or decision
+func gitState(row []any) GitState {
+	commit, _ := row[0].(string)
+	branch, _ := row[1].(string)
+	return GitState{Commit: commit, Branch: branch, PR: intValue(row[2])}
+}
+
+// recordAtCommit links the node of label with the given ID to the Commit
+// node of state's commit by a RECORDED_AT relationship holding the branch
+// and pull request, if the commit is known
+func recordAtCommit(ctx context.Context, q Querier, labels LabelMap, project, label, id string, state GitState) error {
+	if state.Commit == "" {
+		return nil
+	}
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (n:%[1]s:%[2]s {id: $id})
+		MERGE (c:%[1]s:%[3]s {sha: $commit})
+		MERGE (n)-[r:RECORDED_AT]->(c)
+		SET r.branch = $branch, r.pr = $pr
+	`, project, labels.Label(label), labels.Label("Commit")), map[string]any{
+		"id": id, "commit": state.Commit, "branch": state.Branch, "pr": state.PR,
+	})
+	return err
+}
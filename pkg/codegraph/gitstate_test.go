@@ -0,0 +1,85 @@
+package codegraph
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveGitState(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{"main.go": "package main\n"})
+	git(t, root, "checkout", "-q", "-b", "feature/notes")
+	state := ResolveGitState(ctx, root)
+	if state.Commit != ResolveCommit(ctx, root, "") || state.Branch != "feature/notes" || state.PR != 0 {
+		t.Errorf("state = %+v", state)
+	}
+	if state := ResolveGitState(ctx, t.TempDir()); state != (GitState{}) {
+		t.Errorf("state outside a repository = %+v", state)
+	}
+}
+
+func TestGitHubRepo(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{"main.go": "package main\n"})
+	if got := GitHubRepo(ctx, root); got != "" {
+		t.Errorf("without a remote: %q", got)
+	}
+	git(t, root, "remote", "add", "origin", "/src/app.git")
+	for remote, want := range map[string]string{
+		"https://github.com/acme/app.git": "acme/app",
+		"git@github.com:acme/app":         "acme/app",
+		"https://gitlab.com/acme/app.git": "",
+	} {
+		git(t, root, "remote", "set-url", "origin", remote)
+		if got := GitHubRepo(ctx, root); got != want {
+			t.Errorf("GitHubRepo with origin %s = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestGitHubPullRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/app/pulls" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("head") == "acme:feature/notes" {
+			w.Write([]byte(`[{"number": 42}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+	g := &GitHub{BaseURL: srv.URL, Repo: "acme/app", Token: "secret"}
+	if pr, err := g.PullRequest(context.Background(), "feature/notes"); pr != 42 || err != nil {
+		t.Errorf("PullRequest = %d, %v, want 42", pr, err)
+	}
+	if pr, err := g.PullRequest(context.Background(), "main"); pr != 0 || err != nil {
+		t.Errorf("PullRequest without one = %d, %v, want 0", pr, err)
+	}
+	g.Token = ""
+	if _, err := g.PullRequest(context.Background(), "main"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("err = %v, want the 404", err)
+	}
+	if _, err := (&GitHub{Repo: "app"}).PullRequest(context.Background(), "main"); err == nil {
+		t.Error("a repository without an owner succeeded")
+	}
+}
+
+func TestRecordAtCommit(t *testing.T) {
+	q := &recordingQuerier{}
+	if err := recordAtCommit(context.Background(), q, LabelMap{}, "App", "Memory", "mem-1", GitState{Branch: "main"}); err != nil || len(q.queries) != 0 {
+		t.Errorf("without a commit: %v, queries %q", err, q.queries)
+	}
+	state := GitState{Commit: "abc123", Branch: "main", PR: 42}
+	if err := recordAtCommit(context.Background(), q, LabelMap{Prefix: "CG_"}, "App", "Memory", "mem-1", state); err != nil {
+		t.Fatal(err)
+	}
+	want := "MATCH (n:App:CG_Memory {id: $id})\nMERGE (c:App:CG_Commit {sha: $commit})\nMERGE (n)-[r:RECORDED_AT]->(c)\nSET r.branch = $branch, r.pr = $pr"
+	if q.queries[0] != want || q.params[0]["commit"] != "abc123" || q.params[0]["pr"] != 42 {
+		t.Errorf("recorded with %q and %v", q.queries[0], q.params[0])
+	}
+}
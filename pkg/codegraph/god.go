@@ -0,0 +1,166 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"slices"
+	"strings"
+)
+
+// GodLimits are the sizes past which GodFindings flags a struct or a
+// function as doing too much. A limit of 0 is not checked.
+type GodLimits struct {
+	// Fields and Dependencies limit a struct's fields and the distinct
+	// types its fields use
+	Fields       int
+	Dependencies int
+	// Lines, Complexity and Params limit a function's length, cyclomatic
+	// complexity and parameters
+	Lines      int
+	Complexity int
+	Params     int
+}
+
+// The kinds of finding GodFindings reports
+const (
+	GodStruct   = "god-struct"
+	GodFunction = "god-function"
+)
+
+// The severities of a finding: a warning past a limit, an error past twice
+// the limit
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// GodFindings flags the structs and functions of g exceeding limits, with
+// every limit exceeded in the message and the severity of the worst one.
+// Findings are ordered by file and line, structs, which have no line,
+// first.
+func GodFindings(g *Graph, limits GodLimits) []Finding {
+	var findings []Finding
+	for _, st := range g.Structs {
+		dependencies := len(fieldTypes(st))
+		finding := Finding{Kind: GodStruct, Key: st.Key(), Name: st.Name, File: st.File}
+		finding.Severity, finding.Message = exceeded(st.Name, []godMeasure{
+			{"fields", len(st.Fields), limits.Fields},
+			{"dependencies", dependencies, limits.Dependencies},
+		})
+		if finding.Severity != "" {
+			findings = append(findings, finding)
+		}
+	}
+	for _, fn := range g.Functions {
+		name := fn.Name
+		if fn.Receiver != "" {
+			name = strings.TrimPrefix(fn.Receiver, "*") + "." + fn.Name
+		}
+		finding := Finding{Kind: GodFunction, Key: fn.Key(), Name: name, File: fn.File, Line: fn.LineStart}
+		finding.Severity, finding.Message = exceeded(name, []godMeasure{
+			{"lines", fn.LineEnd - fn.LineStart + 1, limits.Lines},
+			{"complexity", fn.Complexity, limits.Complexity},
+			{"parameters", paramCount(fn), limits.Params},
+		})
+		if finding.Severity != "" {
+			findings = append(findings, finding)
+		}
+	}
+	slices.SortFunc(findings, func(a, b Finding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), a.Line-b.Line, strings.Compare(a.Name, b.Name))
+	})
+	return findings
+}
+
+// godMeasure is a size of a struct or function and its limit
+type godMeasure struct {
+	name         string
+	value, limit int
+}
+
+// exceeded returns the severity of the worst of measures past its limit
+// and a message listing those past theirs, or nothing if none is
+func exceeded(name string, measures []godMeasure) (severity, message string) {
+	var over []string
+	for _, m := range measures {
+		if m.limit <= 0 || m.value <= m.limit {
+			continue
+		}
+		over = append(over, fmt.Sprintf("%d %s (limit %d)", m.value, m.name, m.limit))
+		if m.value > 2*m.limit {
+			severity = SeverityError
+		} else if severity == "" {
+			severity = SeverityWarning
+		}
+	}
+	if severity == "" {
+		return "", ""
+	}
+	return severity, fmt.Sprintf("%s has %s", name, strings.Join(over, ", "))
+}
+
+// fieldTypes returns the distinct named types the fields of st use, such
+// as "Config" or "sql.DB", leaving out the predeclared ones
+func fieldTypes(st StructNode) []string {
+	var names []string
+	for _, field := range st.Fields {
+		typ := field
+		if _, t, named := strings.Cut(field, " "); named {
+			typ = t
+		}
+		expr, err := parser.ParseExpr(typ)
+		if err != nil {
+			continue
+		}
+		var inspect func(n ast.Node) bool
+		inspect = func(n ast.Node) bool {
+			name := ""
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				name = types.ExprString(n)
+			case *ast.Ident:
+				if types.Universe.Lookup(n.Name) == nil {
+					name = n.Name
+				}
+			case *ast.Field:
+				// The names of a func type's parameters are not types
+				ast.Inspect(n.Type, inspect)
+				return false
+			}
+			if name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+			return name == ""
+		}
+		ast.Inspect(expr, inspect)
+	}
+	return names
+}
+
+// paramCount returns the number of parameters fn takes, receiver aside
+func paramCount(fn FunctionNode) int {
+	ft := signatureType(fn)
+	if ft == nil || ft.Params == nil {
+		return 0
+	}
+	n := 0
+	for _, field := range ft.Params.List {
+		n += max(len(field.Names), 1)
+	}
+	return n
+}
+
+// signatureType parses the signature of fn, receiver aside, or returns nil
+// if it cannot
+func signatureType(fn FunctionNode) *ast.FuncType {
+	params := strings.TrimPrefix(strings.TrimPrefix(fn.Signature, "func "), "("+fn.Receiver+") ")
+	expr, err := parser.ParseExpr("func" + strings.TrimPrefix(params, fn.Name))
+	if err != nil {
+		return nil
+	}
+	ft, _ := expr.(*ast.FuncType)
+	return ft
+}
@@ -0,0 +1,65 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGodFindings(t *testing.T) {
+	src := `package p
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+type Server struct {
+	db      *sql.DB
+	client  *http.Client
+	handler func(w http.ResponseWriter, r *http.Request)
+	routes  map[string]Route
+	name    string
+}
+
+type Route struct{ path string }
+
+func (s *Server) handle(a, b, c string, d int) {
+	if a == "" {
+		return
+	}
+	if b == "" {
+		return
+	}
+	println(c, d)
+}
+
+func small() {}
+`
+	graph, err := Parser{}.ParseFile("p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fieldTypes(graph.Structs[0]); !reflect.DeepEqual(got, []string{"sql.DB", "http.Client", "http.ResponseWriter", "http.Request", "Route"}) {
+		t.Errorf("field types = %q", got)
+	}
+
+	// Past twice a limit is an error, past it only a warning
+	findings := GodFindings(graph, GodLimits{Fields: 4, Dependencies: 2, Lines: 8, Complexity: 5, Params: 1})
+	want := []Finding{
+		{Kind: GodStruct, Key: "Struct:p.go:Server", Name: "Server", File: "p.go", Severity: SeverityError,
+			Message: "Server has 5 fields (limit 4), 5 dependencies (limit 2)"},
+		{Kind: GodFunction, Key: "Function:p.go:*Server.handle", Name: "Server.handle", File: "p.go", Line: 18, Severity: SeverityError,
+			Message: "Server.handle has 9 lines (limit 8), 4 parameters (limit 1)"},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("findings = %+v, want %+v", findings, want)
+	}
+
+	findings = GodFindings(graph, GodLimits{Lines: 8})
+	if len(findings) != 1 || findings[0].Severity != SeverityWarning {
+		t.Errorf("lines past the limit: findings = %+v", findings)
+	}
+	if findings := GodFindings(graph, GodLimits{}); len(findings) != 0 {
+		t.Errorf("no limits: findings = %+v", findings)
+	}
+}
@@ -0,0 +1,713 @@
+// Package codegraph parses Go source into a graph of packages, files,
+// functions, methods, structs and interfaces, and writes it to NornicDB or
+// Neo4j, FalkorDB, Apache AGE, Kùzu, SQLite or memory.
+//
+// A Parser produces a Graph, and a Writer stores it as a project, stamped
+// with the run that wrote it:
+//
+//	graph, err := codegraph.Parser{Root: "."}.Parse(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	w, err := codegraph.OpenSQLite(ctx, "codegraph.db")
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close(ctx)
+//	return w.Write(ctx, "MyProject", graph, codegraph.NewRunInfo())
+//
+// Every file parsed, batch written and query run is an OpenTelemetry span
+// of the global tracer provider, a child of the span in the context passed.
+package codegraph
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunInfo identifies a single population run. Every node and relationship
+// written by the run is stamped with its ID and timestamp, and nodes with the
+// git commit that was indexed when there is one.
+type RunInfo struct {
+	ID        string
+	StartedAt time.Time
+	Commit    string
+}
+
+// RunMetrics records where a run spent its time and how its writes were
+// batched, for tuning large deployments
+type RunMetrics struct {
+	ParseTime     time.Duration
+	WriteTime     time.Duration
+	Files         int
+	Nodes         int
+	Relationships int
+	WriteStats
+}
+
+// WriteStats counts the round trips a backend made while writing
+type WriteStats struct {
+	Statements int
+	Retries    int
+	Batches    int
+	BatchRows  int
+	MaxBatch   int
+	Shrinks    int
+}
+
+// addBatch records a statement that wrote rows graph elements
+func (s *WriteStats) addBatch(rows int) {
+	if rows == 0 {
+		return
+	}
+	s.Batches++
+	s.BatchRows += rows
+	if rows > s.MaxBatch {
+		s.MaxBatch = rows
+	}
+}
+
+// FileNode represents a source file in the graph
+type FileNode struct {
+	Path     string   `json:"path"`
+	Package  string   `json:"package"`
+	Language string   `json:"language"`
+	Imports  []string `json:"imports"`
+
+	// ImportNames holds the names the file gives the imports it names, by
+	// import path: st for import st "example.com/app/store", or . or _
+	ImportNames map[string]string `json:"importNames,omitempty"`
+
+	// Uses lists the functions the package-level variables and constants
+	// declared in the file call or refer to, written like Calls
+	Uses []string `json:"uses,omitempty"`
+
+	// Resolved is set for Uses like FunctionNode.Resolved
+	Resolved map[string]string `json:"resolved,omitempty"`
+}
+
+// FunctionNode represents a function/method in the graph
+type FunctionNode struct {
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	Signature string `json:"signature"`
+	Receiver  string `json:"receiver"` // empty for functions, type name for methods
+	IsExport  bool   `json:"isExport"`
+	LineStart int    `json:"lineStart"`
+	LineEnd   int    `json:"lineEnd"`
+
+	// Complexity is the cyclomatic complexity of the body, 0 without one
+	Complexity int `json:"complexity,omitempty"`
+
+	// Stub is set for a body that does nothing of its own, see isStub
+	Stub bool `json:"stub,omitempty"`
+
+	// Fingerprint is the MinHash signature of the body that Duplicates
+	// compares, nil for bodies too short to tell copies apart
+	Fingerprint []uint32 `json:"fingerprint,omitempty"`
+
+	// Doc is the doc comment, embedded with the signature but not written
+	Doc string `json:"doc,omitempty"`
+
+	// Calls lists callee expressions as written in the body, e.g. "helper",
+	// "strings.TrimSpace" or "conn.flush", except that calls through the
+	// receiver use its type ("Server.flush"); see Graph.Calls
+	Calls []string `json:"calls"`
+
+	// Refs lists the functions the body refers to without calling them,
+	// such as a handler passed as an argument, written like Calls
+	Refs []string `json:"refs,omitempty"`
+
+	// Discards lists the calls in the body throwing results away, which
+	// DiscardedErrors checks for errors
+	Discards []Discard `json:"discards,omitempty"`
+
+	// Resolved maps the Calls and Refs the type checker resolved to the
+	// keys of the project functions they name, or to "" for what is not
+	// one, such as a function of another module or a func variable. It is
+	// nil for a function that was not type-checked.
+	Resolved map[string]string `json:"resolved,omitempty"`
+}
+
+// StructNode represents a struct definition
+type StructNode struct {
+	Name     string   `json:"name"`
+	File     string   `json:"file"`
+	Fields   []string `json:"fields"`
+	IsExport bool     `json:"isExport"`
+
+	// Doc is the doc comment, embedded with the fields but not written
+	Doc string `json:"doc,omitempty"`
+}
+
+// InterfaceNode represents an interface definition
+type InterfaceNode struct {
+	Name     string   `json:"name"`
+	File     string   `json:"file"`
+	Methods  []string `json:"methods"`
+	IsExport bool     `json:"isExport"`
+}
+
+// PackageNode represents a Go package
+type PackageNode struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// Module and ImportPath are the path of the module holding the package
+	// and its import path within it, empty outside a module. Packages of
+	// the same name in different modules differ by them.
+	Module     string `json:"module,omitempty"`
+	ImportPath string `json:"importPath,omitempty"`
+}
+
+// Relationship represents a directed edge between two nodes, identified by
+// their keys
+type Relationship struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// GraphExport is the document written by export --format json
+type GraphExport struct {
+	Project     string    `json:"project"`
+	RunID       string    `json:"runId"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	*Graph
+	Relationships []Relationship `json:"relationships"`
+}
+
+// Graph holds all parsed code elements
+type Graph struct {
+	Files      []FileNode      `json:"files"`
+	Functions  []FunctionNode  `json:"functions"`
+	Structs    []StructNode    `json:"structs"`
+	Interfaces []InterfaceNode `json:"interfaces"`
+	Packages   []PackageNode   `json:"packages"`
+
+	// Modules are the Go modules of the go.mod files in or above the
+	// directory parsed, see ModuleNode; none without one
+	Modules []ModuleNode `json:"modules,omitempty"`
+
+	// Set by AddOwnership: ownership by File and Function node key, and the
+	// authors it refers to
+	Ownership map[string]Ownership `json:"ownership,omitempty"`
+	Authors   []AuthorNode         `json:"authors,omitempty"`
+
+	// Set by AddChurn: change counts by File and Function node key
+	Churn map[string]Churn `json:"churn,omitempty"`
+
+	// Set by AddCoverage: test coverage by Function node key, for the
+	// functions of the files the profile covers
+	Coverage map[string]Coverage `json:"coverage,omitempty"`
+
+	// Set by AddVulnerabilities: the vulnerabilities govulncheck found,
+	// empty rather than nil if it found none
+	Vulnerabilities []Vulnerability `json:"vulnerabilities,omitempty"`
+
+	// Set by Enrich: custom properties by node key, written with the
+	// parsed ones
+	Properties map[string]map[string]any `json:"properties,omitempty"`
+
+	// Set by Embed: embeddings by Function and Struct node key
+	Embeddings map[string][]float64 `json:"embeddings,omitempty"`
+
+	// Features switches off the relationships derived from the parsed
+	// symbols
+	Features Features `json:"features,omitempty"`
+
+	// Failed lists the files left out because they could not be parsed
+	Failed []ParseFailure `json:"failed,omitempty"`
+}
+
+// ParseFailure is a file that could not be parsed, and why
+type ParseFailure struct {
+	File string `json:"file"`
+	Err  string `json:"err"`
+}
+
+// Key returns the stable identity of the package node
+func (p PackageNode) Key() string {
+	return "Package:" + p.Path
+}
+
+// Key returns the stable identity of the file node
+func (f FileNode) Key() string {
+	return "File:" + f.Path
+}
+
+// Key returns the stable identity of the function node. Methods are
+// qualified by their receiver type.
+func (fn FunctionNode) Key() string {
+	name := fn.Name
+	if fn.Receiver != "" {
+		name = fn.Receiver + "." + name
+	}
+	return "Function:" + fn.File + ":" + name
+}
+
+// Key returns the stable identity of the struct node
+func (s StructNode) Key() string {
+	return "Struct:" + s.File + ":" + s.Name
+}
+
+// Key returns the stable identity of the interface node
+func (i InterfaceNode) Key() string {
+	return "Interface:" + i.File + ":" + i.Name
+}
+
+// RemoveFile drops a file and the symbols declared in it, or its failure to
+// parse, and its package once no file belongs to it
+func (g *Graph) RemoveFile(path string) {
+	if len(g.Properties) > 0 || len(g.Embeddings) > 0 {
+		for _, node := range g.Nodes() {
+			if node.Label != "Package" && nodeFile(node) == path {
+				delete(g.Properties, node.Key)
+				delete(g.Embeddings, node.Key)
+			}
+		}
+	}
+	g.Files = slices.DeleteFunc(g.Files, func(f FileNode) bool { return f.Path == path })
+	g.Failed = slices.DeleteFunc(g.Failed, func(f ParseFailure) bool { return f.File == path })
+	g.Functions = slices.DeleteFunc(g.Functions, func(fn FunctionNode) bool { return fn.File == path })
+	g.Structs = slices.DeleteFunc(g.Structs, func(st StructNode) bool { return st.File == path })
+	g.Interfaces = slices.DeleteFunc(g.Interfaces, func(iface InterfaceNode) bool { return iface.File == path })
+	g.Packages = slices.DeleteFunc(g.Packages, func(pkg PackageNode) bool {
+		return !slices.ContainsFunc(g.Files, func(f FileNode) bool { return filepath.Dir(f.Path) == pkg.Path })
+	})
+}
+
+// AddFragment merges a graph parsed from a single file
+func (g *Graph) AddFragment(fragment *Graph) {
+	for _, pkg := range fragment.Packages {
+		if !slices.ContainsFunc(g.Packages, func(p PackageNode) bool { return p.Path == pkg.Path }) {
+			g.scopePackage(&pkg)
+			g.Packages = append(g.Packages, pkg)
+		}
+	}
+	g.Files = append(g.Files, fragment.Files...)
+	g.Functions = append(g.Functions, fragment.Functions...)
+	g.Structs = append(g.Structs, fragment.Structs...)
+	g.Interfaces = append(g.Interfaces, fragment.Interfaces...)
+	for key, props := range fragment.Properties {
+		if g.Properties == nil {
+			g.Properties = make(map[string]map[string]any)
+		}
+		g.Properties[key] = props
+	}
+	for key, vector := range fragment.Embeddings {
+		if g.Embeddings == nil {
+			g.Embeddings = make(map[string][]float64)
+		}
+		g.Embeddings[key] = vector
+	}
+	g.Sort()
+}
+
+// Sort puts the packages, modules and files in order of path and the
+// symbols in order of file and then position or name, so a graph of the
+// same code is written, exported and printed the same way whatever order
+// its files were walked, parsed or added in
+func (g *Graph) Sort() {
+	slices.SortFunc(g.Packages, func(a, b PackageNode) int { return strings.Compare(a.Path, b.Path) })
+	slices.SortFunc(g.Modules, func(a, b ModuleNode) int { return strings.Compare(a.Dir, b.Dir) })
+	slices.SortFunc(g.Files, func(a, b FileNode) int { return strings.Compare(a.Path, b.Path) })
+	slices.SortFunc(g.Functions, func(a, b FunctionNode) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.LineStart, b.LineStart), strings.Compare(a.Key(), b.Key()))
+	})
+	slices.SortFunc(g.Structs, func(a, b StructNode) int { return strings.Compare(a.Key(), b.Key()) })
+	slices.SortFunc(g.Interfaces, func(a, b InterfaceNode) int { return strings.Compare(a.Key(), b.Key()) })
+	slices.SortFunc(g.Failed, func(a, b ParseFailure) int { return strings.Compare(a.File, b.File) })
+}
+
+// Relationships derives the edges written alongside the nodes: file
+// membership in packages, containment of symbols, and imports of packages
+// that live in the same codebase.
+func (g *Graph) Relationships() []Relationship {
+	var rels []Relationship
+
+	for _, file := range g.Files {
+		pkg := PackageNode{Path: filepath.Dir(file.Path)}
+		rels = append(rels, Relationship{Type: "BELONGS_TO", From: file.Key(), To: pkg.Key()})
+	}
+	for _, fn := range g.Functions {
+		rels = append(rels, Relationship{Type: "CONTAINS", From: FileNode{Path: fn.File}.Key(), To: fn.Key()})
+	}
+	for _, st := range g.Structs {
+		rels = append(rels, Relationship{Type: "CONTAINS", From: FileNode{Path: st.File}.Key(), To: st.Key()})
+	}
+	for _, iface := range g.Interfaces {
+		rels = append(rels, Relationship{Type: "CONTAINS", From: FileNode{Path: iface.File}.Key(), To: iface.Key()})
+	}
+
+	for _, file := range g.Files {
+		if !g.Features.Enabled("imports") {
+			break
+		}
+		for _, imp := range file.Imports {
+			if dir := g.projectPackage(imp); dir != "" {
+				rels = append(rels, Relationship{Type: "IMPORTS", From: file.Key(), To: PackageNode{Path: dir}.Key()})
+			}
+		}
+	}
+
+	rels = append(rels, g.Calls()...)
+	rels = append(rels, g.Implementations()...)
+
+	// Several imports or call expressions can resolve to the same edge
+	seen := make(map[string]bool, len(rels))
+	unique := rels[:0]
+	for _, rel := range rels {
+		if !seen[rel.Key()] {
+			seen[rel.Key()] = true
+			unique = append(unique, rel)
+		}
+	}
+	return unique
+}
+
+// Calls resolves the callee expressions recorded on each function to CALLS
+// edges between function nodes. Those the type checker resolved, see
+// FunctionNode.Resolved, link to what it found; the others are resolved
+// by name through the import table of the caller's file. A bare
+// identifier matches a function in any file of the caller's package, or
+// of a package it imports with a dot; pkg.Name matches a function in the
+// project package imported as pkg, under an alias or its package clause's
+// name, and nothing for a package of another module; Type.Name matches
+// that method in the caller's package, and any other selector matches
+// methods of that name in the caller's package, or the only such method
+// in the project.
+func (g *Graph) Calls() []Relationship {
+	if !g.Features.Enabled("calls") {
+		return nil
+	}
+	resolve := g.callResolver()
+	var rels []Relationship
+	for _, fn := range g.Functions {
+		for _, call := range fn.Calls {
+			for _, target := range resolve(fn, call) {
+				rels = append(rels, Relationship{Type: "CALLS", From: fn.Key(), To: target.Key()})
+			}
+		}
+	}
+	return rels
+}
+
+// callResolver returns a function resolving a callee expression recorded
+// in fn to the functions of g it may call, as Graph.Calls does
+func (g *Graph) callResolver() func(fn FunctionNode, call string) []FunctionNode {
+	tables := g.importTables()
+	keys := make(map[string]FunctionNode, len(g.Functions))
+	funcs := make(map[string]FunctionNode)
+	methods := make(map[string][]FunctionNode)
+	methodsByName := make(map[string][]FunctionNode)
+	for _, fn := range g.Functions {
+		keys[fn.Key()] = fn
+		dir := filepath.Dir(fn.File)
+		if fn.Receiver == "" {
+			funcs[dir+"\x00"+fn.Name] = fn
+		} else {
+			methods[dir+"\x00"+fn.Name] = append(methods[dir+"\x00"+fn.Name], fn)
+			methodsByName[fn.Name] = append(methodsByName[fn.Name], fn)
+		}
+	}
+
+	return func(fn FunctionNode, call string) []FunctionNode {
+		if key, ok := fn.Resolved[call]; ok {
+			if target, ok := keys[key]; ok {
+				return []FunctionNode{target}
+			}
+			if key == "" {
+				return nil
+			}
+			// A function the graph left out, or keyed differently, falls
+			// back on its name
+		}
+		dir := filepath.Dir(fn.File)
+		var targets []FunctionNode
+
+		table := tables[fn.File]
+		i := strings.LastIndex(call, ".")
+		if i < 0 {
+			for _, pkgPath := range append([]string{dir}, table.dot...) {
+				if target, ok := funcs[pkgPath+"\x00"+call]; ok {
+					targets = append(targets, target)
+					break
+				}
+			}
+		} else {
+			qualifier, name := call[:i], call[i+1:]
+			if pkgPath, ok := table.project[qualifier]; ok {
+				if target, ok := funcs[pkgPath+"\x00"+name]; ok {
+					targets = append(targets, target)
+				}
+			} else if table.external[qualifier] {
+				// A function of another module
+			} else if local := methods[dir+"\x00"+name]; len(local) > 0 {
+				for _, m := range local {
+					if strings.TrimPrefix(m.Receiver, "*") == qualifier {
+						targets = append(targets, m)
+					}
+				}
+				if len(targets) == 0 {
+					targets = local
+				}
+			} else if all := methodsByName[name]; len(all) == 1 {
+				targets = all
+			}
+		}
+		return targets
+	}
+}
+
+// GraphNode is a kind-agnostic view of a node, carrying the same properties
+// that are written to the database
+type GraphNode struct {
+	Key   string
+	Label string
+	Props map[string]any
+}
+
+// NodeLabels lists every label Nodes produces
+var NodeLabels = []string{"Package", "File", "Function", "Method", "Struct", "Interface"}
+
+// Nodes flattens all parsed elements into graph nodes, in write order
+func (g *Graph) Nodes() []GraphNode {
+	var nodes []GraphNode
+	for _, pkg := range g.Packages {
+		nodes = append(nodes, GraphNode{Key: pkg.Key(), Label: "Package", Props: map[string]any{
+			"name": pkg.Name,
+			"path": pkg.Path,
+		}})
+	}
+	for _, file := range g.Files {
+		nodes = append(nodes, GraphNode{Key: file.Key(), Label: "File", Props: map[string]any{
+			"path":     file.Path,
+			"package":  file.Package,
+			"language": file.Language,
+			"imports":  file.Imports,
+		}})
+	}
+	for _, fn := range g.Functions {
+		label := "Function"
+		if fn.Receiver != "" {
+			label = "Method"
+		}
+		nodes = append(nodes, GraphNode{Key: fn.Key(), Label: label, Props: map[string]any{
+			"name":      fn.Name,
+			"file":      fn.File,
+			"signature": fn.Signature,
+			"receiver":  fn.Receiver,
+			"isExport":  fn.IsExport,
+			"lineStart": fn.LineStart,
+			"lineEnd":   fn.LineEnd,
+		}})
+	}
+	for _, st := range g.Structs {
+		nodes = append(nodes, GraphNode{Key: st.Key(), Label: "Struct", Props: map[string]any{
+			"name":     st.Name,
+			"file":     st.File,
+			"fields":   st.Fields,
+			"isExport": st.IsExport,
+		}})
+	}
+	for _, iface := range g.Interfaces {
+		nodes = append(nodes, GraphNode{Key: iface.Key(), Label: "Interface", Props: map[string]any{
+			"name":     iface.Name,
+			"file":     iface.File,
+			"methods":  iface.Methods,
+			"isExport": iface.IsExport,
+		}})
+	}
+	for _, node := range nodes {
+		for name, value := range g.Properties[node.Key] {
+			node.Props[name] = value
+		}
+	}
+	return nodes
+}
+
+// PackageDependencies lifts file IMPORTS edges to package-to-package edges,
+// deduplicated and sorted, leaving out imports within the same package
+func (g *Graph) PackageDependencies() []Relationship {
+	fileToPkg := make(map[string]string)
+	for _, file := range g.Files {
+		fileToPkg[file.Key()] = PackageNode{Path: filepath.Dir(file.Path)}.Key()
+	}
+
+	seen := make(map[string]bool)
+	var deps []Relationship
+	for _, rel := range g.Relationships() {
+		if rel.Type != "IMPORTS" {
+			continue
+		}
+		dep := Relationship{Type: "IMPORTS", From: fileToPkg[rel.From], To: rel.To}
+		if dep.From == dep.To || seen[dep.Key()] {
+			continue
+		}
+		seen[dep.Key()] = true
+		deps = append(deps, dep)
+	}
+
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].From != deps[j].From {
+			return deps[i].From < deps[j].From
+		}
+		return deps[i].To < deps[j].To
+	})
+	return deps
+}
+
+// Coupling is how many nodes depend on a node and how many it depends on:
+// for a function or method the distinct functions calling it (fan-in) and
+// that it calls (fan-out), for a package the packages importing it
+// (afferent) and that it imports (efferent)
+type Coupling struct {
+	In  int `json:"in"`
+	Out int `json:"out"`
+}
+
+// Instability is Out / (In + Out): 0 for a package others depend on that
+// depends on nothing, and so is hard to change, up to 1 for one that
+// depends on others and nothing depends on, and so breaks when they change
+func (c Coupling) Instability() float64 {
+	if c.In+c.Out == 0 {
+		return 0
+	}
+	return float64(c.Out) / float64(c.In+c.Out)
+}
+
+// Coupling counts the CALLS edges into and out of every function and
+// method, and the package IMPORTS edges into and out of every package, by
+// node key. Calls of a function to itself are not counted.
+func (g *Graph) Coupling() map[string]Coupling {
+	coupling := make(map[string]Coupling, len(g.Functions)+len(g.Packages))
+	for _, fn := range g.Functions {
+		coupling[fn.Key()] = Coupling{}
+	}
+	for _, pkg := range g.Packages {
+		coupling[pkg.Key()] = Coupling{}
+	}
+	seen := make(map[string]bool)
+	for _, rel := range append(g.Calls(), g.PackageDependencies()...) {
+		if rel.From == rel.To || seen[rel.Key()] {
+			continue
+		}
+		seen[rel.Key()] = true
+		from, to := coupling[rel.From], coupling[rel.To]
+		from.Out++
+		to.In++
+		coupling[rel.From], coupling[rel.To] = from, to
+	}
+	return coupling
+}
+
+// MethodsOf returns the methods declared on a type, matched by receiver name
+// within the package directory
+func (g *Graph) MethodsOf(pkgPath, typeName string) []FunctionNode {
+	var methods []FunctionNode
+	for _, fn := range g.Functions {
+		if fn.Receiver != "" && strings.TrimPrefix(fn.Receiver, "*") == typeName && filepath.Dir(fn.File) == pkgPath {
+			methods = append(methods, fn)
+		}
+	}
+	return methods
+}
+
+// Implementations returns IMPLEMENTS edges from structs to the interfaces
+// whose method names are all declared on the struct. Matching is by name
+// only; there is no type information to compare signatures.
+func (g *Graph) Implementations() []Relationship {
+	if !g.Features.Enabled("implements") {
+		return nil
+	}
+	var rels []Relationship
+	for _, st := range g.Structs {
+		methods := make(map[string]bool)
+		for _, fn := range g.MethodsOf(filepath.Dir(st.File), st.Name) {
+			methods[fn.Name] = true
+		}
+		if len(methods) == 0 {
+			continue
+		}
+
+		for _, iface := range g.Interfaces {
+			names := interfaceMethodNames(iface)
+			if len(names) == 0 {
+				continue
+			}
+			implements := true
+			for _, name := range names {
+				if !methods[name] {
+					implements = false
+					break
+				}
+			}
+			if implements {
+				rels = append(rels, Relationship{Type: "IMPLEMENTS", From: st.Key(), To: iface.Key()})
+			}
+		}
+	}
+	return rels
+}
+
+// interfaceMethodNames strips the signatures recorded by extractInterface
+// down to method names
+func interfaceMethodNames(iface InterfaceNode) []string {
+	names := make([]string, 0, len(iface.Methods))
+	for _, method := range iface.Methods {
+		if i := strings.Index(method, "("); i > 0 {
+			names = append(names, method[:i])
+		}
+	}
+	return names
+}
+
+// Key returns the identity of the relationship
+func (r Relationship) Key() string {
+	return r.From + " -[" + r.Type + "]-> " + r.To
+}
+
+// NewRunInfo creates a run ID that sorts by start time and stays unique
+// across concurrent runs
+func NewRunInfo() RunInfo {
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return RunInfo{
+		ID:        now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix),
+		StartedAt: now,
+	}
+}
+
+// PerSecond is the rate of n over d, 0 if d is not positive
+func PerSecond(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// PropString returns the string property key of a node's properties
+func PropString(props map[string]any, key string) string {
+	value, _ := props[key].(string)
+	return value
+}
+
+// PropStrings returns the string list property key of a node's properties
+func PropStrings(props map[string]any, key string) []string {
+	values, _ := props[key].([]any)
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
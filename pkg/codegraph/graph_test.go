@@ -0,0 +1,184 @@
+package codegraph
+
+import (
+	"go/token"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRunInfo(t *testing.T) {
+	a, b := NewRunInfo(), NewRunInfo()
+	if a.ID == b.ID {
+		t.Errorf("two runs share the ID %s", a.ID)
+	}
+	if stamp := a.StartedAt.Format("20060102T150405Z"); !strings.HasPrefix(a.ID, stamp+"-") {
+		t.Errorf("run ID %s does not start with its start time %s", a.ID, stamp)
+	}
+	if a.StartedAt.Location() != time.UTC {
+		t.Errorf("run started at %v, want UTC", a.StartedAt)
+	}
+}
+
+func TestPerSecond(t *testing.T) {
+	tests := []struct {
+		n    int
+		d    time.Duration
+		want float64
+	}{
+		{100, 2 * time.Second, 50},
+		{100, 500 * time.Millisecond, 200},
+		{100, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := PerSecond(tt.n, tt.d); got != tt.want {
+			t.Errorf("PerSecond(%d, %v) = %v, want %v", tt.n, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	var stats WriteStats
+	for _, rows := range []int{3, 0, 5, 1} {
+		stats.addBatch(rows)
+	}
+	if want := (WriteStats{Batches: 3, BatchRows: 9, MaxBatch: 5}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestRelationships(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	rels := make(map[string]bool)
+	for _, rel := range graph.Relationships() {
+		rels[rel.Key()] = true
+	}
+
+	for _, want := range []Relationship{
+		{Type: "BELONGS_TO", From: "File:main.go", To: "Package:."},
+		{Type: "BELONGS_TO", From: "File:store/store.go", To: "Package:store"},
+		{Type: "CONTAINS", From: "File:store/store.go", To: "Struct:store/store.go:Store"},
+		{Type: "IMPORTS", From: "File:main.go", To: "Package:store"},
+		{Type: "CALLS", From: "Function:main.go:main", To: "Function:main.go:run"},
+		{Type: "CALLS", From: "Function:main.go:main", To: "Function:store/store.go:New"},
+		{Type: "CALLS", From: "Function:main.go:main", To: "Function:store/store.go:*Store.Put"},
+		{Type: "CALLS", From: "Function:store/store.go:*Store.Put", To: "Function:store/store.go:*Store.flush"},
+		{Type: "IMPLEMENTS", From: "Struct:store/store.go:Store", To: "Interface:store/store.go:Putter"},
+	} {
+		if !rels[want.Key()] {
+			t.Errorf("missing %s", want.Key())
+		}
+	}
+}
+
+func TestRelationshipsFeatures(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Features = Features{"calls": false, "implements": false}
+	for _, rel := range graph.Relationships() {
+		if rel.Type == "CALLS" || rel.Type == "IMPLEMENTS" {
+			t.Errorf("%s written with the feature switched off", rel.Key())
+		}
+	}
+
+	graph.Features = Features{"imports": false}
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		if stmt.Desc == "linking imports" && len(stmt.Rows) > 0 {
+			t.Errorf("%d imports linked with the feature switched off", len(stmt.Rows))
+		}
+	}
+}
+
+func TestImplementations(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	want := []Relationship{{Type: "IMPLEMENTS", From: "Struct:store/store.go:Store", To: "Interface:store/store.go:Putter"}}
+	if got := graph.Implementations(); !slices.Equal(got, want) {
+		t.Errorf("implementations = %+v, want %+v", got, want)
+	}
+	want = []Relationship{{Type: "IMPORTS", From: "Package:.", To: "Package:store"}}
+	if got := graph.PackageDependencies(); !slices.Equal(got, want) {
+		t.Errorf("package dependencies = %+v, want %+v", got, want)
+	}
+}
+
+func TestCoupling(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	want := map[string]Coupling{
+		"Function:main.go:main":                {Out: 3},
+		"Function:main.go:run":                 {In: 1},
+		"Function:store/store.go:New":          {In: 1},
+		"Function:store/store.go:*Store.Put":   {In: 1, Out: 1},
+		"Function:store/store.go:*Store.flush": {In: 1},
+		"Package:.":                            {Out: 1},
+		"Package:store":                        {In: 1},
+	}
+	if got := graph.Coupling(); !maps.Equal(got, want) {
+		t.Errorf("coupling = %+v, want %+v", got, want)
+	}
+	for c, want := range map[Coupling]float64{{}: 0, {Out: 1}: 1, {In: 1}: 0, {In: 1, Out: 3}: 0.75} {
+		if got := c.Instability(); got != want {
+			t.Errorf("%+v instability = %v, want %v", c, got, want)
+		}
+	}
+}
+
+func TestRemoveFileAddFragment(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.RemoveFile("store/store.go")
+	if len(graph.Files) != 1 || len(graph.Structs) != 0 || len(graph.Interfaces) != 0 {
+		t.Errorf("after removing store.go: %d files, %d structs, %d interfaces", len(graph.Files), len(graph.Structs), len(graph.Interfaces))
+	}
+	for _, fn := range graph.Functions {
+		if fn.File == "store/store.go" {
+			t.Errorf("function %s survived its file", fn.Key())
+		}
+	}
+	if len(graph.Packages) != 1 || graph.Packages[0].Path != "." {
+		t.Errorf("packages = %+v, want only .", graph.Packages)
+	}
+
+	root := writeTree(t, testTree)
+	fragment, err := parseFile(token.NewFileSet(), root, filepath.Join(root, "store", "store.go"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.AddFragment(fragment)
+	if len(graph.Packages) != 2 || len(graph.Structs) != 1 || len(graph.Functions) != 5 {
+		t.Errorf("after re-adding store.go: %d packages, %d structs, %d functions", len(graph.Packages), len(graph.Structs), len(graph.Functions))
+	}
+}
+
+func TestSort(t *testing.T) {
+	want := parseTestTree(t, Filter{})
+	want.Sort()
+	if !slices.IsSortedFunc(want.Functions, func(a, b FunctionNode) int { return strings.Compare(a.File, b.File) }) {
+		t.Errorf("functions not in order of file: %+v", want.Functions)
+	}
+
+	// The same graph built in the opposite order sorts back to it
+	graph := parseTestTree(t, Filter{})
+	slices.Reverse(graph.Packages)
+	slices.Reverse(graph.Files)
+	slices.Reverse(graph.Functions)
+	slices.Reverse(graph.Structs)
+	slices.Reverse(graph.Interfaces)
+	graph.Sort()
+	if !reflect.DeepEqual(graph, want) {
+		t.Errorf("sorted graph = %+v\nwant %+v", graph, want)
+	}
+
+	// Re-adding a file puts it back where it was
+	graph.RemoveFile("main.go")
+	root := writeTree(t, testTree)
+	fragment, err := parseFile(token.NewFileSet(), root, filepath.Join(root, "main.go"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.AddFragment(fragment)
+	if !slices.Equal(graph.Relationships(), want.Relationships()) {
+		t.Errorf("relationships after re-adding main.go = %v\nwant %v", graph.Relationships(), want.Relationships())
+	}
+}
@@ -0,0 +1,365 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResolveCommit returns the commit rev (HEAD if empty) names in dir's
+// repository, or "" if dir is not in a git repository
+func ResolveCommit(ctx context.Context, dir, rev string) string {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// CurrentBranch returns the branch checked out in dir, or "" if HEAD is
+// detached or dir is not in a git repository
+func CurrentBranch(ctx context.Context, dir string) string {
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "symbolic-ref", "--short", "--quiet", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// GitError includes git's own message in the error for a failed command
+func GitError(command string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("git %s: %s", command, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// Ownership summarises git blame for a file or function
+type Ownership struct {
+	LastAuthor      string         `json:"lastAuthor"`
+	LastModified    time.Time      `json:"lastModified"`
+	TopContributors []string       `json:"topContributors"`
+	Lines           map[string]int `json:"lines"` // lines per author email
+}
+
+// AuthorNode is a commit author, identified by email
+type AuthorNode struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// ownershipRow adds the ownership properties of one node to its identity
+func ownershipRow(o Ownership, row map[string]any) map[string]any {
+	emails := make([]string, 0, len(o.Lines))
+	for email := range o.Lines {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	authors := make([]any, len(emails))
+	for i, email := range emails {
+		authors[i] = map[string]any{"email": email, "lines": o.Lines[email]}
+	}
+	row["lastAuthor"] = o.LastAuthor
+	row["lastModified"] = o.LastModified
+	row["topContributors"] = o.TopContributors
+	row["authors"] = authors
+	return row
+}
+
+// topContributorCount is how many authors Ownership.TopContributors lists
+const topContributorCount = 3
+
+// blameLine is the author of one line as reported by git blame
+type blameLine struct {
+	Name  string
+	Email string
+	Time  time.Time
+}
+
+// AddOwnership blames every file at rev (the working tree if empty) and
+// records who wrote each file and function. Files git does not track are
+// skipped.
+func AddOwnership(ctx context.Context, graph *Graph, root, rev string) error {
+	graph.Ownership = make(map[string]Ownership)
+	authors := make(map[string]string)
+
+	for _, file := range graph.Files {
+		lines, err := blameFile(ctx, root, rev, file.Path)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		for _, line := range lines {
+			authors[line.Email] = line.Name
+		}
+		graph.Ownership[file.Key()] = summarizeBlame(lines)
+
+		for _, fn := range graph.Functions {
+			if fn.File != file.Path || fn.LineStart < 1 || fn.LineEnd > len(lines) {
+				continue
+			}
+			graph.Ownership[fn.Key()] = summarizeBlame(lines[fn.LineStart-1 : fn.LineEnd])
+		}
+	}
+
+	graph.Authors = graph.Authors[:0]
+	for email, name := range authors {
+		graph.Authors = append(graph.Authors, AuthorNode{Email: email, Name: name})
+	}
+	sort.Slice(graph.Authors, func(i, j int) bool { return graph.Authors[i].Email < graph.Authors[j].Email })
+	return nil
+}
+
+// blameFile returns the author of every line of path, relative to root
+func blameFile(ctx context.Context, root, rev, path string) ([]blameLine, error) {
+	args := []string{"-C", root, "blame", "--line-porcelain"}
+	if rev != "" {
+		args = append(args, rev)
+	}
+	out, err := exec.CommandContext(ctx, "git", append(args, "--", filepath.ToSlash(path))...).Output()
+	if err != nil {
+		return nil, GitError("blame", err)
+	}
+
+	// Every line is a header block of "key value" lines followed by the
+	// content prefixed with a tab
+	var lines []blameLine
+	var current blameLine
+	for _, text := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(text, "\t"):
+			lines = append(lines, current)
+		case strings.HasPrefix(text, "author "):
+			current.Name = strings.TrimPrefix(text, "author ")
+		case strings.HasPrefix(text, "author-mail "):
+			current.Email = strings.Trim(strings.TrimPrefix(text, "author-mail "), "<>")
+		case strings.HasPrefix(text, "author-time "):
+			seconds, _ := strconv.ParseInt(strings.TrimPrefix(text, "author-time "), 10, 64)
+			current.Time = time.Unix(seconds, 0).UTC()
+		}
+	}
+	return lines, nil
+}
+
+// summarizeBlame finds the most recent author and the authors with the most
+// lines in a range of blamed lines
+func summarizeBlame(lines []blameLine) Ownership {
+	o := Ownership{Lines: make(map[string]int)}
+	for _, line := range lines {
+		o.Lines[line.Email]++
+		if line.Time.After(o.LastModified) {
+			o.LastModified = line.Time
+			o.LastAuthor = line.Email
+		}
+	}
+
+	for email := range o.Lines {
+		o.TopContributors = append(o.TopContributors, email)
+	}
+	sort.Slice(o.TopContributors, func(i, j int) bool {
+		a, b := o.TopContributors[i], o.TopContributors[j]
+		if o.Lines[a] != o.Lines[b] {
+			return o.Lines[a] > o.Lines[b]
+		}
+		return a < b
+	})
+	if len(o.TopContributors) > topContributorCount {
+		o.TopContributors = o.TopContributors[:topContributorCount]
+	}
+	return o
+}
+
+// Churn counts how often a file or function changed in the --churn window
+type Churn struct {
+	Commits int `json:"commits"`
+	Lines   int `json:"lines"` // lines added plus lines removed
+}
+
+// hunk is one -U0 diff hunk: Old lines starting at OldStart in the parent
+// became New lines starting at NewStart
+type hunk struct {
+	OldStart, Old int
+	NewStart, New int
+}
+
+// hunkPattern matches a unified diff hunk header
+var hunkPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// fileChange is one file's hunks in one commit
+type fileChange struct {
+	Path  string
+	Hunks []hunk
+}
+
+// AddChurn counts the commits since the git date since (e.g. "90 days ago")
+// that touched each file and function, up to rev (HEAD if empty). Line
+// numbers in older commits are carried forward through the later diffs so
+// they line up with the functions as they are now.
+func AddChurn(ctx context.Context, graph *Graph, root, rev, since string) error {
+	commits, err := logChanges(ctx, root, rev, since)
+	if err != nil {
+		return err
+	}
+
+	functions := make(map[string][]FunctionNode)
+	for _, fn := range graph.Functions {
+		functions[fn.File] = append(functions[fn.File], fn)
+	}
+
+	graph.Churn = make(map[string]Churn)
+	add := func(key string, lines int) {
+		c := graph.Churn[key]
+		c.Commits++
+		c.Lines += lines
+		graph.Churn[key] = c
+	}
+
+	// later holds, per file, the hunks of the commits already seen, oldest
+	// first, which map a line from an older commit to the current tree
+	later := make(map[string][][]hunk)
+	for _, commit := range commits {
+		for _, change := range commit {
+			lines := 0
+			touched := make(map[string]int)
+			for _, h := range change.Hunks {
+				lines += h.Old + h.New
+				// A function is credited with the added lines that land in it,
+				// or with the whole deletion if that is all the hunk did
+				for key, n := range hunkFunctions(functions[change.Path], later[change.Path], h) {
+					if h.New == 0 {
+						n = h.Old
+					}
+					touched[key] += n
+				}
+			}
+			add(FileNode{Path: change.Path}.Key(), lines)
+			for key, lines := range touched {
+				add(key, lines)
+			}
+			later[change.Path] = append([][]hunk{change.Hunks}, later[change.Path]...)
+		}
+	}
+	return nil
+}
+
+// hunkFunctions counts, by key, the lines h added that land in each of
+// functions once carried through the later hunks to the current tree. A
+// deletion counts the line it happened at.
+func hunkFunctions(functions []FunctionNode, later [][]hunk, h hunk) map[string]int {
+	first := max(h.NewStart, 1)
+	last := max(h.NewStart+h.New-1, first)
+	hit := make(map[string]int)
+	for line := first; line <= last; line++ {
+		current := carryLine(later, line)
+		for _, fn := range functions {
+			if fn.LineStart <= current && fn.LineEnd >= current {
+				hit[fn.Key()]++
+			}
+		}
+	}
+	return hit
+}
+
+// carryLine maps a line through each set of hunks in turn, returning -1 if a
+// later commit deleted it
+func carryLine(diffs [][]hunk, line int) int {
+	for _, hunks := range diffs {
+		next := line
+		for _, h := range hunks {
+			if h.Old == 0 {
+				if line > h.OldStart {
+					next += h.New
+				}
+				continue
+			}
+			if line >= h.OldStart+h.Old {
+				next += h.New - h.Old
+				continue
+			}
+			if line >= h.OldStart {
+				if h.New == 0 {
+					return -1
+				}
+				next = h.NewStart + min(line-h.OldStart, h.New-1)
+				break
+			}
+		}
+		line = next
+	}
+	return line
+}
+
+// logChanges returns the Go file hunks of every non-merge commit since the
+// git date since, newest first
+func logChanges(ctx context.Context, root, rev, since string) ([][]fileChange, error) {
+	args := []string{"-C", root, "log", "--no-merges", "--no-renames", "--relative", "-p", "-U0", "--format=%x00", "--since=" + since}
+	if rev != "" {
+		args = append(args, rev)
+	}
+	out, err := exec.CommandContext(ctx, "git", append(args, "--", "*.go")...).Output()
+	if err != nil {
+		return nil, GitError("log", err)
+	}
+
+	var commits [][]fileChange
+	for _, text := range strings.Split(string(out), "\x00")[1:] {
+		commits = append(commits, parseDiff(text))
+	}
+	return commits, nil
+}
+
+// parseDiff returns the hunks of each file a zero-context diff changed,
+// leaving out deleted files
+func parseDiff(text string) []fileChange {
+	var changes []fileChange
+	header := false
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			header = true
+		case header && strings.HasPrefix(line, "+++ "):
+			header = false
+			if path, ok := strings.CutPrefix(line, "+++ b/"); ok {
+				changes = append(changes, fileChange{Path: path})
+			} else {
+				// Deleted in this diff, so not in the current tree
+				changes = append(changes, fileChange{})
+			}
+		case !header && len(changes) > 0 && strings.HasPrefix(line, "@@ "):
+			m := hunkPattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			h := hunk{OldStart: atoi(m[1]), Old: 1, NewStart: atoi(m[3]), New: 1}
+			if m[2] != "" {
+				h.Old = atoi(m[2])
+			}
+			if m[4] != "" {
+				h.New = atoi(m[4])
+			}
+			last := &changes[len(changes)-1]
+			last.Hunks = append(last.Hunks, h)
+		}
+	}
+	return slices.DeleteFunc(changes, func(change fileChange) bool { return change.Path == "" })
+}
+
+// atoi parses a number already matched by a pattern
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,226 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitRepo writes files into a new git repository and commits them
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := writeTree(t, files)
+	git(t, root, "init", "-q")
+	git(t, root, "add", "-A")
+	git(t, root, "commit", "-q", "-m", "initial")
+	return root
+}
+
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{"main.go": "package main\n"})
+
+	head := ResolveCommit(ctx, root, "")
+	if len(head) != 40 {
+		t.Fatalf("ResolveCommit(HEAD) = %q, want a commit hash", head)
+	}
+	if got := ResolveCommit(ctx, root, "HEAD"); got != head {
+		t.Errorf("ResolveCommit(HEAD) = %q, want %q", got, head)
+	}
+	if got := ResolveCommit(ctx, root, "no-such-branch"); got != "" {
+		t.Errorf("ResolveCommit(no-such-branch) = %q, want empty", got)
+	}
+	if got := ResolveCommit(ctx, t.TempDir(), ""); got != "" {
+		t.Errorf("ResolveCommit outside a repository = %q, want empty", got)
+	}
+}
+
+func TestCurrentBranch(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{"main.go": "package main\n"})
+	git(t, root, "checkout", "-q", "-b", "feature/sessions")
+	if got := CurrentBranch(ctx, root); got != "feature/sessions" {
+		t.Errorf("CurrentBranch = %q, want feature/sessions", got)
+	}
+	git(t, root, "checkout", "-q", "--detach")
+	if got := CurrentBranch(ctx, root); got != "" {
+		t.Errorf("CurrentBranch with a detached HEAD = %q, want empty", got)
+	}
+	if got := CurrentBranch(ctx, t.TempDir()); got != "" {
+		t.Errorf("CurrentBranch outside a repository = %q, want empty", got)
+	}
+}
+
+func TestSummarizeBlame(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	ann := func(d int) blameLine { return blameLine{Name: "Ann", Email: "ann@example.com", Time: day(d)} }
+	bob := func(d int) blameLine { return blameLine{Name: "Bob", Email: "bob@example.com", Time: day(d)} }
+	cy := blameLine{Name: "Cy", Email: "cy@example.com", Time: day(1)}
+	dee := blameLine{Name: "Dee", Email: "dee@example.com", Time: day(1)}
+
+	tests := []struct {
+		lines []blameLine
+		last  string
+		top   []string
+	}{
+		{nil, "", nil},
+		{[]blameLine{ann(1), bob(3), ann(2)}, "bob@example.com", []string{"ann@example.com", "bob@example.com"}},
+		{[]blameLine{dee, cy, bob(1), ann(2)}, "ann@example.com", []string{"ann@example.com", "bob@example.com", "cy@example.com"}},
+	}
+	for _, tt := range tests {
+		o := summarizeBlame(tt.lines)
+		if o.LastAuthor != tt.last || !slices.Equal(o.TopContributors, tt.top) {
+			t.Errorf("summarizeBlame(%v) = last %q, top %q; want %q, %q", tt.lines, o.LastAuthor, o.TopContributors, tt.last, tt.top)
+		}
+	}
+}
+
+func TestAddOwnership(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, testTree)
+	main := testTree["main.go"] + "\nfunc extra() {}\n"
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "commit", "-q", "-am", "extra", "--author", "Ann <ann@example.com>", "--date", "2030-01-01T00:00:00Z")
+	if err := os.WriteFile(filepath.Join(root, "untracked.go"), []byte("package main\n\nfunc untracked() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := parseCodebase(context.Background(), root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddOwnership(ctx, graph, root, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []AuthorNode{{"ann@example.com", "Ann"}, {"test@example.com", "test"}}; !slices.Equal(graph.Authors, want) {
+		t.Errorf("authors = %v, want %v", graph.Authors, want)
+	}
+
+	tests := []struct {
+		key   string
+		last  string
+		lines map[string]int
+	}{
+		{"Function:main.go:extra", "ann@example.com", map[string]int{"ann@example.com": 1}},
+		{"Function:main.go:run", "test@example.com", map[string]int{"test@example.com": 1}},
+		{"File:main.go", "ann@example.com", map[string]int{"test@example.com": 11, "ann@example.com": 2}},
+	}
+	for _, tt := range tests {
+		o, ok := graph.Ownership[tt.key]
+		if !ok {
+			t.Errorf("no ownership for %s", tt.key)
+			continue
+		}
+		if o.LastAuthor != tt.last || fmt.Sprint(o.Lines) != fmt.Sprint(tt.lines) {
+			t.Errorf("%s: last %q, lines %v; want %q, %v", tt.key, o.LastAuthor, o.Lines, tt.last, tt.lines)
+		}
+	}
+	if _, ok := graph.Ownership["File:untracked.go"]; ok {
+		t.Error("untracked file has an owner")
+	}
+
+	// Blaming the first commit ignores the later one
+	if err := AddOwnership(ctx, graph, root, "HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	if o := graph.Ownership["File:main.go"]; o.LastAuthor != "test@example.com" {
+		t.Errorf("HEAD~1 main.go last author = %q", o.LastAuthor)
+	}
+}
+
+func TestCarryLine(t *testing.T) {
+	insert := []hunk{{OldStart: 2, Old: 0, NewStart: 3, New: 2}}
+	remove := []hunk{{OldStart: 3, Old: 2, NewStart: 2, New: 0}}
+	replace := []hunk{{OldStart: 4, Old: 1, NewStart: 4, New: 3}}
+	tests := []struct {
+		diffs [][]hunk
+		line  int
+		want  int
+	}{
+		{nil, 5, 5},
+		{[][]hunk{insert}, 2, 2},
+		{[][]hunk{insert}, 3, 5},
+		{[][]hunk{remove}, 1, 1},
+		{[][]hunk{remove}, 3, -1},
+		{[][]hunk{remove}, 5, 3},
+		{[][]hunk{replace}, 4, 4},
+		{[][]hunk{replace}, 6, 8},
+		{[][]hunk{insert, remove}, 3, 3},
+		{[][]hunk{insert, replace}, 2, 2},
+	}
+	for _, tt := range tests {
+		if got := carryLine(tt.diffs, tt.line); got != tt.want {
+			t.Errorf("carryLine(%v, %d) = %d, want %d", tt.diffs, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestAddChurn(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, testTree)
+	store := filepath.Join(root, "store", "store.go")
+	src := testTree["store/store.go"]
+
+	// Change Put, then push everything down a line so the older change has
+	// to be carried forward to land in Put
+	src = strings.Replace(src, "\treturn s.flush()", "\ts.keys = append(s.keys, key)\n\treturn s.flush()", 1)
+	if err := os.WriteFile(store, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "commit", "-q", "-am", "put twice")
+	if err := os.WriteFile(store, []byte("// Package store keeps keys\n"+src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "commit", "-q", "-am", "doc")
+
+	graph, err := parseCodebase(context.Background(), root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddChurn(ctx, graph, root, "", "1970-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want Churn
+	}{
+		{"File:store/store.go", Churn{Commits: 3, Lines: 19 + 1 + 1}},
+		{"File:main.go", Churn{Commits: 1, Lines: 11}},
+		{"Function:store/store.go:*Store.Put", Churn{Commits: 2, Lines: 4 + 1}},
+		{"Function:store/store.go:*Store.flush", Churn{Commits: 1, Lines: 1}},
+		{"Function:store/store.go:New", Churn{Commits: 1, Lines: 1}},
+	}
+	for _, tt := range tests {
+		if got := graph.Churn[tt.key]; got != tt.want {
+			t.Errorf("churn of %s = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+
+	if err := AddChurn(ctx, graph, root, "HEAD~2", "1970-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	if got := graph.Churn["File:store/store.go"]; got.Commits != 1 {
+		t.Errorf("churn of store.go at HEAD~2 = %+v, want 1 commit", got)
+	}
+	if err := AddChurn(ctx, graph, root, "no-such-rev", "1970-01-01"); err == nil {
+		t.Error("counted churn at a missing revision")
+	}
+}
@@ -0,0 +1,109 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Hotspot is a function or file worth refactoring first: one that changes
+// often, is complex and is called from many places, so it is where bugs
+// are likeliest and cost the most. Score is Churn × Complexity × (1 +
+// FanIn). For a file, Complexity sums that of its functions and FanIn
+// counts the functions of other files calling into it.
+type Hotspot struct {
+	Key        string `json:"key"`
+	Name       string `json:"name"`
+	File       string `json:"file"`
+	Line       int    `json:"line,omitempty"`
+	Churn      int    `json:"churn"`
+	Complexity int    `json:"complexity"`
+	FanIn      int    `json:"fanIn"`
+	Score      int    `json:"score"`
+}
+
+// The kinds of finding HotspotFindings reports
+const (
+	FunctionHotspot = "hotspot-function"
+	FileHotspot     = "hotspot-file"
+)
+
+// Hotspots ranks the functions and files of g by their score, highest
+// first, counting churn from g.Churn and calls whether or not the calls
+// feature is on. Those that did not change in the churn window score
+// nothing and are left out, as are all of them if AddChurn was not run.
+func (g *Graph) Hotspots() []Hotspot {
+	full := *g
+	full.Features = Features{"calls": true}
+	coupling := full.Coupling()
+
+	var hotspots []Hotspot
+	fileOf := make(map[string]string, len(g.Functions))
+	files := make(map[string]*Hotspot, len(g.Files))
+	for _, file := range g.Files {
+		files[file.Path] = &Hotspot{Key: file.Key(), Name: file.Path, File: file.Path, Churn: g.Churn[file.Key()].Commits}
+	}
+	for _, fn := range g.Functions {
+		name := fn.Name
+		if fn.Receiver != "" {
+			name = strings.TrimPrefix(fn.Receiver, "*") + "." + fn.Name
+		}
+		fileOf[fn.Key()] = fn.File
+		h := Hotspot{Key: fn.Key(), Name: name, File: fn.File, Line: fn.LineStart,
+			Churn: g.Churn[fn.Key()].Commits, Complexity: fn.Complexity, FanIn: coupling[fn.Key()].In}
+		h.Score = h.Churn * h.Complexity * (1 + h.FanIn)
+		if h.Score > 0 {
+			hotspots = append(hotspots, h)
+		}
+		if file, ok := files[fn.File]; ok {
+			file.Complexity += fn.Complexity
+		}
+	}
+
+	callers := make(map[string]map[string]bool)
+	for _, call := range full.Calls() {
+		from, to := fileOf[call.From], fileOf[call.To]
+		if from == to {
+			continue
+		}
+		if callers[to] == nil {
+			callers[to] = make(map[string]bool)
+		}
+		callers[to][call.From] = true
+	}
+	for _, file := range g.Files {
+		h := files[file.Path]
+		h.FanIn = len(callers[file.Path])
+		h.Score = h.Churn * h.Complexity * (1 + h.FanIn)
+		if h.Score > 0 {
+			hotspots = append(hotspots, *h)
+		}
+	}
+
+	slices.SortFunc(hotspots, func(a, b Hotspot) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Key, b.Key))
+	})
+	return hotspots
+}
+
+// HotspotFindings turns the first top of hotspots, or all of them if top
+// is 0, into findings flagging their functions and files
+func HotspotFindings(hotspots []Hotspot, top int) []Finding {
+	if top > 0 && len(hotspots) > top {
+		hotspots = hotspots[:top]
+	}
+	findings := make([]Finding, 0, len(hotspots))
+	for _, h := range hotspots {
+		kind := FunctionHotspot
+		if h.Key == (FileNode{Path: h.File}).Key() {
+			kind = FileHotspot
+		}
+		findings = append(findings, Finding{
+			Kind: kind, Key: h.Key, Name: h.Name, File: h.File, Line: h.Line,
+			Message: fmt.Sprintf("%s scores %d: changed in %d commits, complexity %d, called from %d functions",
+				h.Name, h.Score, h.Churn, h.Complexity, h.FanIn),
+		})
+	}
+	return findings
+}
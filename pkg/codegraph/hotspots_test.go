@@ -0,0 +1,56 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHotspots(t *testing.T) {
+	graph := &Graph{
+		Files: []FileNode{{Path: "main.go"}, {Path: "store/store.go"}},
+		Functions: []FunctionNode{
+			{Name: "main", File: "main.go", LineStart: 3, Complexity: 2, Calls: []string{"store.Put", "store.Get"}},
+			{Name: "Put", File: "store/store.go", LineStart: 3, Complexity: 4, Calls: []string{"Get"}},
+			{Name: "Get", File: "store/store.go", LineStart: 9, Complexity: 1},
+			{Name: "stable", File: "store/store.go", LineStart: 12, Complexity: 9},
+		},
+		Packages: []PackageNode{{Name: "main", Path: "."}, {Name: "store", Path: "store"}},
+		Churn: map[string]Churn{
+			"File:main.go":                {Commits: 1},
+			"File:store/store.go":         {Commits: 5},
+			"Function:main.go:main":       {Commits: 1},
+			"Function:store/store.go:Put": {Commits: 4},
+			"Function:store/store.go:Get": {Commits: 2},
+		},
+		Features: Features{"calls": false},
+	}
+	graph.Files[0].Imports = []string{"example.com/app/store"}
+	want := []Hotspot{
+		{Key: "File:store/store.go", Name: "store/store.go", File: "store/store.go", Churn: 5, Complexity: 14, FanIn: 1, Score: 140},
+		{Key: "Function:store/store.go:Put", Name: "Put", File: "store/store.go", Line: 3, Churn: 4, Complexity: 4, FanIn: 1, Score: 32},
+		{Key: "Function:store/store.go:Get", Name: "Get", File: "store/store.go", Line: 9, Churn: 2, Complexity: 1, FanIn: 2, Score: 6},
+		{Key: "File:main.go", Name: "main.go", File: "main.go", Churn: 1, Complexity: 2, Score: 2},
+		{Key: "Function:main.go:main", Name: "main", File: "main.go", Line: 3, Churn: 1, Complexity: 2, Score: 2},
+	}
+	hotspots := graph.Hotspots()
+	if !reflect.DeepEqual(hotspots, want) {
+		t.Errorf("hotspots = %+v, want %+v", hotspots, want)
+	}
+
+	findings := HotspotFindings(hotspots, 2)
+	if len(findings) != 2 || findings[0].Kind != FileHotspot || findings[1].Kind != FunctionHotspot || findings[1].Line != 3 {
+		t.Errorf("findings = %+v", findings)
+	}
+	if want := "Put scores 32: changed in 4 commits, complexity 4, called from 1 functions"; findings[1].Message != want {
+		t.Errorf("message = %q, want %q", findings[1].Message, want)
+	}
+	if findings := HotspotFindings(hotspots, 0); len(findings) != len(hotspots) {
+		t.Errorf("all findings = %+v", findings)
+	}
+
+	// Without churn nothing is hot
+	graph.Churn = nil
+	if hotspots := graph.Hotspots(); hotspots != nil {
+		t.Errorf("hotspots without churn = %+v", hotspots)
+	}
+}
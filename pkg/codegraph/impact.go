@@ -0,0 +1,211 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Impact is the blast radius of a change to a function, struct, interface
+// or file: the symbols depending on what changed through calls, type usage
+// or implementations, up to some depth, the files holding them and the
+// tests reaching what changed
+type Impact struct {
+	Target  string     `json:"target"`
+	Changed []string   `json:"changed"` // keys of the symbols Target names
+	Symbols []Affected `json:"symbols"`
+	Files   []string   `json:"files"`
+	Tests   []Affected `json:"tests"`
+}
+
+// Affected is a symbol a change reaches, Depth hops from what changed, the
+// last hop a relationship of type Via from it
+type Affected struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Line  int    `json:"line,omitempty"`
+	Depth int    `json:"depth"`
+	Via   string `json:"via"`
+}
+
+// ImpactOf walks what depends on target up to depth hops: the callers and
+// referrers of functions, the functions, structs and interfaces whose
+// signatures or fields use a type, and the structs implementing an
+// interface. target is a node key, a file path relative to the project
+// root, a Type.Method, or the name of a function, struct or interface.
+// Symbols leaves out those of _test.go files; Tests holds the functions go
+// test runs among them. Both are ordered by depth and key.
+func ImpactOf(g *Graph, target string, depth int) (Impact, error) {
+	if depth < 1 {
+		return Impact{}, fmt.Errorf("impact depth must be at least 1, got %d", depth)
+	}
+	changed := impactTargets(g, target)
+	if len(changed) == 0 {
+		return Impact{}, fmt.Errorf("no function, struct, interface or file matches %q", target)
+	}
+
+	full := withRefs(g)
+	full.Features = Features{"calls": true, "implements": true}
+	dependents := make(map[string][]Relationship)
+	for _, rel := range slices.Concat(full.Calls(), full.Implementations(), g.TypeUses()) {
+		if rel.From != rel.To {
+			dependents[rel.To] = append(dependents[rel.To], rel)
+		}
+	}
+
+	symbols := make(map[string]Affected)
+	packages := make(map[string]string, len(g.Files))
+	for _, file := range g.Files {
+		packages[file.Path] = file.Package
+	}
+	tests := make(map[string]bool)
+	for _, fn := range g.Functions {
+		name := fn.Name
+		if fn.Receiver != "" {
+			name = strings.TrimPrefix(fn.Receiver, "*") + "." + fn.Name
+		}
+		symbols[fn.Key()] = Affected{Key: fn.Key(), Name: name, File: fn.File, Line: fn.LineStart}
+		tests[fn.Key()] = strings.HasSuffix(fn.File, "_test.go") && isEntryPoint(fn, packages[fn.File])
+	}
+	for _, st := range g.Structs {
+		symbols[st.Key()] = Affected{Key: st.Key(), Name: st.Name, File: st.File}
+	}
+	for _, iface := range g.Interfaces {
+		symbols[iface.Key()] = Affected{Key: iface.Key(), Name: iface.Name, File: iface.File}
+	}
+
+	impact := Impact{Target: target, Changed: changed}
+	visited := make(map[string]bool)
+	for _, key := range changed {
+		visited[key] = true
+	}
+	files := make(map[string]bool)
+	frontier := changed
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, key := range frontier {
+			for _, rel := range dependents[key] {
+				if visited[rel.From] {
+					continue
+				}
+				visited[rel.From] = true
+				next = append(next, rel.From)
+
+				affected := symbols[rel.From]
+				affected.Depth, affected.Via = d, rel.Type
+				files[affected.File] = true
+				switch {
+				case tests[rel.From]:
+					impact.Tests = append(impact.Tests, affected)
+				case !strings.HasSuffix(affected.File, "_test.go"):
+					impact.Symbols = append(impact.Symbols, affected)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	byDepth := func(a, b Affected) int { return cmp.Or(a.Depth-b.Depth, strings.Compare(a.Key, b.Key)) }
+	slices.SortFunc(impact.Symbols, byDepth)
+	slices.SortFunc(impact.Tests, byDepth)
+	impact.Files = slices.Sorted(maps.Keys(files))
+	return impact, nil
+}
+
+// impactTargets returns the keys of the symbols target names, sorted
+func impactTargets(g *Graph, target string) []string {
+	path := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(target, "File:")))
+	typeName, method, isMethod := strings.Cut(target, ".")
+	var keys []string
+	add := func(key, file, name, receiver string) {
+		switch {
+		case key == target, file == path:
+		case receiver != "":
+			if !isMethod || strings.TrimPrefix(receiver, "*") != typeName || name != method {
+				return
+			}
+		case name != target:
+			return
+		}
+		keys = append(keys, key)
+	}
+	for _, fn := range g.Functions {
+		add(fn.Key(), fn.File, fn.Name, fn.Receiver)
+	}
+	for _, st := range g.Structs {
+		add(st.Key(), st.File, st.Name, "")
+	}
+	for _, iface := range g.Interfaces {
+		add(iface.Key(), iface.File, iface.Name, "")
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// withRefs returns a copy of g whose functions call what they refer to as
+// well, so that Calls resolves both
+func withRefs(g *Graph) *Graph {
+	resolved := *g
+	resolved.Functions = make([]FunctionNode, len(g.Functions))
+	for i, fn := range g.Functions {
+		fn.Calls = append(slices.Clip(fn.Calls), fn.Refs...)
+		resolved.Functions[i] = fn
+	}
+	return &resolved
+}
+
+// TypeUses returns a USES_TYPE relationship from each function, struct and
+// interface to the project's structs and interfaces its signature, fields
+// or method signatures name, resolved like Graph.Calls. Bodies are not
+// looked into, and these relationships are not written.
+func (g *Graph) TypeUses() []Relationship {
+	types := make(map[string]string)
+	for _, st := range g.Structs {
+		types[filepath.Dir(st.File)+"\x00"+st.Name] = st.Key()
+	}
+	for _, iface := range g.Interfaces {
+		types[filepath.Dir(iface.File)+"\x00"+iface.Name] = iface.Key()
+	}
+	tables := g.importTables()
+
+	var rels []Relationship
+	uses := func(from, file string, texts []string) {
+		seen := make(map[string]bool)
+		for _, text := range texts {
+			for _, name := range mentionedName.FindAllString(text, -1) {
+				dirs := append([]string{filepath.Dir(file)}, tables[file].dot...)
+				if qualifier, typeName, ok := strings.Cut(name, "."); ok {
+					dir, ok := tables[file].project[qualifier]
+					if !ok {
+						continue
+					}
+					dirs, name = []string{dir}, typeName
+				}
+				var to string
+				for _, dir := range dirs {
+					if to = types[dir+"\x00"+name]; to != "" {
+						break
+					}
+				}
+				if to != "" && to != from && !seen[to] {
+					seen[to] = true
+					rels = append(rels, Relationship{Type: "USES_TYPE", From: from, To: to})
+				}
+			}
+		}
+	}
+	for _, fn := range g.Functions {
+		uses(fn.Key(), fn.File, []string{fn.Signature})
+	}
+	for _, st := range g.Structs {
+		uses(st.Key(), st.File, st.Fields)
+	}
+	for _, iface := range g.Interfaces {
+		uses(iface.Key(), iface.File, iface.Methods)
+	}
+	return rels
+}
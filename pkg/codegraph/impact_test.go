@@ -0,0 +1,146 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestImpactOf(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"store/store.go": `package store
+
+type Saver interface {
+	Save(key string) error
+}
+
+type Store struct{}
+
+func (s *Store) Save(key string) error { return nil }
+
+func Open() *Store { return &Store{} }
+`,
+		"app/app.go": `package app
+
+import "example.com/app/store"
+
+type App struct {
+	saver store.Saver
+}
+
+func Run() { handle(persist) }
+
+func handle(fn func()) { fn() }
+
+func persist() { store.Open().Save("k") }
+`,
+		"app/app_test.go": `package app
+
+import "testing"
+
+func TestRun(t *testing.T) { Run() }
+
+func helper() { persist() }
+
+func TestHelper(t *testing.T) { helper() }
+`,
+	})
+	graph, err := Parser{Root: root, Filter: Filter{Tests: true}}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impact, err := ImpactOf(graph, "Store", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Struct:store/store.go:Store"}; !reflect.DeepEqual(impact.Changed, want) {
+		t.Errorf("changed = %v, want %v", impact.Changed, want)
+	}
+	want := []Affected{
+		{Key: "Function:store/store.go:*Store.Save", Name: "Store.Save", File: "store/store.go", Line: 9, Depth: 1, Via: "USES_TYPE"},
+		{Key: "Function:store/store.go:Open", Name: "Open", File: "store/store.go", Line: 11, Depth: 1, Via: "USES_TYPE"},
+		{Key: "Function:app/app.go:persist", Name: "persist", File: "app/app.go", Line: 13, Depth: 2, Via: "CALLS"},
+	}
+	if !reflect.DeepEqual(impact.Symbols, want) {
+		t.Errorf("symbols = %+v, want %+v", impact.Symbols, want)
+	}
+	if want := []string{"app/app.go", "store/store.go"}; !reflect.DeepEqual(impact.Files, want) || impact.Tests != nil {
+		t.Errorf("files = %v and tests %+v, want %v and none", impact.Files, impact.Tests, want)
+	}
+
+	// Through a function passed as a handler, and a test helper, to tests
+	impact, err = ImpactOf(graph, "persist", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, affected := range impact.Symbols {
+		keys = append(keys, affected.Key)
+	}
+	if want := []string{"Function:app/app.go:Run"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("symbols = %v, want %v", keys, want)
+	}
+	keys = nil
+	for _, affected := range impact.Tests {
+		keys = append(keys, affected.Key)
+	}
+	if want := []string{"Function:app/app_test.go:TestHelper", "Function:app/app_test.go:TestRun"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("tests = %v, want %v", keys, want)
+	}
+	if want := []string{"app/app.go", "app/app_test.go"}; !reflect.DeepEqual(impact.Files, want) {
+		t.Errorf("files = %v, want %v", impact.Files, want)
+	}
+
+	// Implementers and users of an interface, named by key
+	impact, err = ImpactOf(graph, "Interface:store/store.go:Saver", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = nil
+	for _, affected := range impact.Symbols {
+		keys = append(keys, affected.Key+" "+affected.Via)
+	}
+	if want := []string{"Struct:app/app.go:App USES_TYPE", "Struct:store/store.go:Store IMPLEMENTS"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("symbols = %v, want %v", keys, want)
+	}
+
+	// A file stands for the symbols in it, and a method is named by type
+	if impact, err = ImpactOf(graph, "store/store.go", 1); err != nil || len(impact.Changed) != 4 {
+		t.Errorf("file changes %v, %v", impact.Changed, err)
+	}
+	if impact, err = ImpactOf(graph, "Store.Save", 1); err != nil || !reflect.DeepEqual(impact.Changed, []string{"Function:store/store.go:*Store.Save"}) {
+		t.Errorf("method changes %v, %v", impact.Changed, err)
+	}
+
+	if _, err := ImpactOf(graph, "missing", 1); err == nil {
+		t.Error("an unknown target has no error")
+	}
+	if _, err := ImpactOf(graph, "Store", 0); err == nil {
+		t.Error("depth 0 has no error")
+	}
+}
+
+func TestTypeUses(t *testing.T) {
+	graph := &Graph{
+		Files: []FileNode{{Path: "a/a.go", Imports: []string{"example.com/m/b"}}, {Path: "b/b.go"}},
+		Functions: []FunctionNode{
+			{Name: "New", File: "a/a.go", Signature: "New(cfg b.Config) *Client"},
+			{Name: "close", File: "a/a.go", Signature: "(c *Client) close() error", Receiver: "*Client"},
+		},
+		Structs: []StructNode{
+			{Name: "Client", File: "a/a.go", Fields: []string{"cfg b.Config", "next *Client", "strings.Builder"}},
+			{Name: "Config", File: "b/b.go"},
+		},
+		Packages: []PackageNode{{Name: "a", Path: "a"}, {Name: "b", Path: "b"}},
+	}
+	want := []Relationship{
+		{Type: "USES_TYPE", From: "Function:a/a.go:New", To: "Struct:b/b.go:Config"},
+		{Type: "USES_TYPE", From: "Function:a/a.go:New", To: "Struct:a/a.go:Client"},
+		{Type: "USES_TYPE", From: "Function:a/a.go:*Client.close", To: "Struct:a/a.go:Client"},
+		{Type: "USES_TYPE", From: "Struct:a/a.go:Client", To: "Struct:b/b.go:Config"},
+	}
+	if rels := graph.TypeUses(); !reflect.DeepEqual(rels, want) {
+		t.Errorf("type uses = %v, want %v", rels, want)
+	}
+}
@@ -0,0 +1,160 @@
+package codegraph
+
+import (
+	"cmp"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The kinds of import ImportKind tells apart
+const (
+	StdlibImport     = "stdlib"
+	InternalImport   = "internal"
+	ThirdPartyImport = "third-party"
+)
+
+// ImportBreakdown counts the distinct packages the files of a package
+// import by kind. ThirdPartyRatio is the share of them that are third
+// party, from 0 to 1, and ThirdParty lists those.
+type ImportBreakdown struct {
+	Package         string   `json:"package"`
+	Stdlib          int      `json:"stdlib"`
+	Internal        int      `json:"internal"`
+	ThirdParty      []string `json:"thirdParty"`
+	ThirdPartyRatio float64  `json:"thirdPartyRatio"`
+}
+
+// ImportKind classifies an import path of g's files: internal if it names
+// one of g's packages, matched like IMPORTS relationships, stdlib if its
+// first element has no dot, as only the standard library's may, and
+// third-party otherwise
+func (g *Graph) ImportKind(path string) string {
+	if g.projectPackage(path) != "" {
+		return InternalImport
+	}
+	first, _, _ := strings.Cut(path, "/")
+	if !strings.Contains(first, ".") {
+		return StdlibImport
+	}
+	return ThirdPartyImport
+}
+
+// ImportBreakdowns returns the breakdown of the imports of every package
+// of g, by path, whether or not the imports feature is on
+func (g *Graph) ImportBreakdowns() []ImportBreakdown {
+	imports := make(map[string][]string)
+	for _, file := range g.Files {
+		dir := filepath.Dir(file.Path)
+		for _, imp := range file.Imports {
+			if !slices.Contains(imports[dir], imp) {
+				imports[dir] = append(imports[dir], imp)
+			}
+		}
+	}
+
+	breakdowns := make([]ImportBreakdown, 0, len(g.Packages))
+	for _, pkg := range g.Packages {
+		b := ImportBreakdown{Package: pkg.Path, ThirdParty: []string{}}
+		for _, imp := range imports[pkg.Path] {
+			switch g.ImportKind(imp) {
+			case StdlibImport:
+				b.Stdlib++
+			case InternalImport:
+				b.Internal++
+			default:
+				b.ThirdParty = append(b.ThirdParty, imp)
+			}
+		}
+		slices.Sort(b.ThirdParty)
+		if n := len(imports[pkg.Path]); n > 0 {
+			b.ThirdPartyRatio = float64(len(b.ThirdParty)) / float64(n)
+		}
+		breakdowns = append(breakdowns, b)
+	}
+	slices.SortFunc(breakdowns, func(a, b ImportBreakdown) int { return cmp.Compare(a.Package, b.Package) })
+	return breakdowns
+}
+
+// projectPackage returns the path of the package of g an import path
+// names, or "" if it names none: the package of that import path, or for
+// a package outside the modules of g, the one whose path the import path
+// ends with, the longest if several do
+func (g *Graph) projectPackage(imp string) string {
+	var found string
+	for _, pkg := range g.Packages {
+		dir := filepath.ToSlash(pkg.Path)
+		match := dir != "." && (imp == dir || strings.HasSuffix(imp, "/"+dir))
+		if pkg.ImportPath != "" {
+			match = imp == pkg.ImportPath
+		}
+		if match && (found == "" || len(pkg.Path) > len(found)) {
+			found = pkg.Path
+		}
+	}
+	return found
+}
+
+// importTable is what the qualifiers of a file's names refer to: the
+// project package imported under each name, the names of the packages of
+// other modules, and the project packages imported with a dot, whose
+// names the file uses unqualified
+type importTable struct {
+	project  map[string]string
+	external map[string]bool
+	dot      []string
+}
+
+// importTables returns the import table of each file of g by path. An
+// import is named as the file names it, or else after the package clause
+// of a project package, or the import path of another.
+func (g *Graph) importTables() map[string]importTable {
+	names := make(map[string]string, len(g.Packages))
+	for _, pkg := range g.Packages {
+		names[pkg.Path] = pkg.Name
+	}
+	dirs := make(map[string]string)
+	tables := make(map[string]importTable, len(g.Files))
+	for _, file := range g.Files {
+		table := importTable{project: make(map[string]string), external: make(map[string]bool)}
+		for _, imp := range file.Imports {
+			dir, ok := dirs[imp]
+			if !ok {
+				dir = g.projectPackage(imp)
+				dirs[imp] = dir
+			}
+			name := file.ImportNames[imp]
+			switch {
+			case name == "_":
+			case name == "." && dir != "":
+				table.dot = append(table.dot, dir)
+			case name == ".":
+			case dir != "":
+				table.project[cmp.Or(name, names[dir], importName(imp))] = dir
+			default:
+				table.external[cmp.Or(name, importName(imp))] = true
+			}
+		}
+		tables[file.Path] = table
+	}
+	return tables
+}
+
+// majorVersion matches the last element of a module path naming its major
+// version, such as v2
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// importName guesses the name of the package at an import path from the
+// path alone, as its package clause usually has it: the last element
+// before a major version, without a gopkg.in version and after its last
+// hyphen, as in gopkg.in/yaml.v3 or github.com/google/go-cmp
+func importName(imp string) string {
+	name := path.Base(imp)
+	if majorVersion.MatchString(name) && path.Dir(imp) != "." {
+		name = path.Base(path.Dir(imp))
+	}
+	name, _, _ = strings.Cut(name, ".")
+	return name[strings.LastIndex(name, "-")+1:]
+}
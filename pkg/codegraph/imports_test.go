@@ -0,0 +1,112 @@
+package codegraph
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestImportBreakdowns(t *testing.T) {
+	graph := &Graph{
+		Packages: []PackageNode{{Name: "main", Path: "."}, {Name: "store", Path: "store"}},
+		Files: []FileNode{
+			{Path: "main.go", Imports: []string{"fmt", "example.com/app/store", "github.com/spf13/cobra"}},
+			{Path: "flags.go", Imports: []string{"fmt", "github.com/spf13/pflag"}},
+			{Path: "store/store.go", Imports: []string{"database/sql"}},
+		},
+	}
+	for path, want := range map[string]string{
+		"fmt":                   StdlibImport,
+		"database/sql":          StdlibImport,
+		"example.com/app/store": InternalImport,
+		"golang.org/x/net/html": ThirdPartyImport,
+	} {
+		if kind := graph.ImportKind(path); kind != want {
+			t.Errorf("%s kind = %s, want %s", path, kind, want)
+		}
+	}
+
+	want := []ImportBreakdown{
+		{Package: ".", Stdlib: 1, Internal: 1, ThirdParty: []string{"github.com/spf13/cobra", "github.com/spf13/pflag"}, ThirdPartyRatio: 0.5},
+		{Package: "store", Stdlib: 1, ThirdParty: []string{}},
+	}
+	if got := graph.ImportBreakdowns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("breakdowns = %+v, want %+v", got, want)
+	}
+}
+
+func TestProjectPackage(t *testing.T) {
+	graph := &Graph{Packages: []PackageNode{{Path: "."}, {Path: "store"}, {Path: "pkg/store"}}}
+	for imp, want := range map[string]string{
+		"example.com/app/store":     "store",
+		"example.com/app/pkg/store": "pkg/store",
+		"example.com/app/kvstore":   "",
+		"example.com/app":           "",
+	} {
+		if got := graph.projectPackage(imp); got != want {
+			t.Errorf("without a module, %s names %q, want %q", imp, got, want)
+		}
+	}
+
+	graph.setModules([]ModuleNode{{Path: "example.com/app", Dir: ".", ImportPath: "example.com/app"}})
+	for imp, want := range map[string]string{
+		"example.com/app":           ".",
+		"example.com/app/store":     "store",
+		"example.com/lib/pkg/store": "",
+	} {
+		if got := graph.projectPackage(imp); got != want {
+			t.Errorf("in the module, %s names %q, want %q", imp, got, want)
+		}
+	}
+}
+
+func TestImportName(t *testing.T) {
+	for imp, want := range map[string]string{
+		"fmt":                          "fmt",
+		"github.com/google/go-cmp/cmp": "cmp",
+		"github.com/google/go-cmp":     "cmp",
+		"gopkg.in/yaml.v3":             "yaml",
+		"github.com/jackc/pgx/v5":      "pgx",
+	} {
+		if got := importName(imp); got != want {
+			t.Errorf("importName(%s) = %s, want %s", imp, got, want)
+		}
+	}
+}
+
+func TestImportTableCalls(t *testing.T) {
+	graph := &Graph{
+		Packages: []PackageNode{{Name: "main", Path: "."}, {Name: "kv", Path: "store"}, {Name: "util", Path: "util"}},
+		Files: []FileNode{
+			{Path: "main.go", Imports: []string{"example.com/app/store", "example.com/app/util", "os"},
+				ImportNames: map[string]string{"example.com/app/util": "."}},
+			{Path: "cmd.go"},
+			{Path: "store/store.go"},
+			{Path: "util/util.go"},
+		},
+		Functions: []FunctionNode{
+			{Name: "main", File: "main.go", Calls: []string{"kv.Open", "helper", "Must", "os.Exit"}},
+			{Name: "helper", File: "cmd.go"},
+			{Name: "Open", File: "store/store.go", Signature: "func Open() *Store"},
+			{Name: "Exit", Receiver: "*Store", File: "store/store.go"},
+			{Name: "Must", File: "util/util.go"},
+		},
+		Structs: []StructNode{{Name: "Store", File: "store/store.go"}},
+	}
+	graph.setModules([]ModuleNode{{Path: "example.com/app", Dir: ".", ImportPath: "example.com/app"}})
+	var calls []string
+	for _, rel := range graph.Calls() {
+		calls = append(calls, rel.To)
+	}
+	// kv is the package clause of store, helper is in another file of the
+	// package, Must is dot-imported, and os.Exit is not the project's Exit
+	want := []string{"Function:store/store.go:Open", "Function:cmd.go:helper", "Function:util/util.go:Must"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	graph.Functions[0].Signature = "func main(s *kv.Store)"
+	if rels := graph.TypeUses(); !slices.Contains(rels, Relationship{Type: "USES_TYPE", From: "Function:main.go:main", To: "Struct:store/store.go:Store"}) {
+		t.Errorf("type uses = %+v, want main to use store.Store", rels)
+	}
+}
@@ -0,0 +1,80 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The kinds of finding InterfaceFindings reports
+const (
+	BloatedInterface     = "bloated-interface"
+	SingleImplementation = "single-implementation"
+)
+
+// InterfaceFindings flags the interfaces of g worth segregating: those of
+// at least minMethods methods most of whose implementations stub some of
+// them (see isStub), so only need part of the interface, and those with a
+// single implementation in g, which may not need to be interfaces. Both
+// are matched by method name, like Graph.Implementations, whether or not
+// the implements feature is on. Findings are ordered by file and name.
+func InterfaceFindings(g *Graph, minMethods int) []Finding {
+	full := *g
+	full.Features = Features{"implements": true}
+	structs := make(map[string]StructNode, len(g.Structs))
+	for _, st := range g.Structs {
+		structs[st.Key()] = st
+	}
+	implementations := make(map[string][]StructNode)
+	for _, rel := range full.Implementations() {
+		implementations[rel.To] = append(implementations[rel.To], structs[rel.From])
+	}
+
+	var findings []Finding
+	for _, iface := range g.Interfaces {
+		impls := implementations[iface.Key()]
+		finding := Finding{Key: iface.Key(), Name: iface.Name, File: iface.File}
+		names := interfaceMethodNames(iface)
+		switch {
+		case len(impls) == 1:
+			finding.Kind = SingleImplementation
+			finding.Message = fmt.Sprintf("%s has a single implementation, %s, which could be used directly", iface.Name, impls[0].Name)
+		case len(impls) > 1 && len(names) >= minMethods:
+			stubbed := make(map[string]int)
+			stubbing := 0
+			for _, st := range impls {
+				stubs := 0
+				for _, method := range full.MethodsOf(filepath.Dir(st.File), st.Name) {
+					if method.Stub && slices.Contains(names, method.Name) {
+						stubbed[method.Name]++
+						stubs++
+					}
+				}
+				if stubs > 0 {
+					stubbing++
+				}
+			}
+			if stubbing*2 <= len(impls) {
+				continue
+			}
+			methods := slices.Collect(maps.Keys(stubbed))
+			slices.SortFunc(methods, func(a, b string) int { return cmp.Or(stubbed[b]-stubbed[a], strings.Compare(a, b)) })
+			for i, name := range methods {
+				methods[i] = fmt.Sprintf("%s (%d)", name, stubbed[name])
+			}
+			finding.Kind = BloatedInterface
+			finding.Message = fmt.Sprintf("%s has %d methods, and %d of its %d implementations stub some of them: %s",
+				iface.Name, len(names), stubbing, len(impls), strings.Join(methods, ", "))
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	slices.SortFunc(findings, func(a, b Finding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), strings.Compare(a.Name, b.Name))
+	})
+	return findings
+}
@@ -0,0 +1,59 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterfaceFindings(t *testing.T) {
+	graph := &Graph{
+		Interfaces: []InterfaceNode{
+			{Name: "Store", File: "store/store.go", Methods: []string{"Get(key string) string", "Put(key, value string)", "Delete(key string)", "Close() error"}},
+			{Name: "Cache", File: "cache/cache.go", Methods: []string{"Get(key string) string", "Put(key, value string)"}},
+			{Name: "Closer", File: "store/store.go", Methods: []string{"Close() error"}},
+		},
+		Structs: []StructNode{{Name: "Mem", File: "store/mem.go"}, {Name: "Disk", File: "store/disk.go"}, {Name: "Log", File: "store/log.go"}},
+	}
+	for _, st := range []struct {
+		name, file string
+		stubs      []string
+	}{
+		{"Mem", "store/mem.go", []string{"Delete", "Close"}},
+		{"Disk", "store/disk.go", []string{"Close"}},
+		{"Log", "store/log.go", nil},
+	} {
+		for _, method := range []string{"Get", "Put", "Delete", "Close"} {
+			stub := false
+			for _, s := range st.stubs {
+				stub = stub || s == method
+			}
+			graph.Functions = append(graph.Functions, FunctionNode{Name: method, Receiver: "*" + st.name, File: st.file, Stub: stub})
+		}
+	}
+
+	var got []string
+	for _, finding := range InterfaceFindings(graph, 4) {
+		got = append(got, finding.Kind+" "+finding.Key+": "+finding.Message)
+	}
+	want := []string{
+		"bloated-interface Interface:store/store.go:Store: Store has 4 methods, and 2 of its 3 implementations stub some of them: Close (2), Delete (1)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+
+	// A single implementation, and too few methods to be bloated
+	graph.Structs = graph.Structs[:1]
+	got = nil
+	for _, finding := range InterfaceFindings(graph, 4) {
+		got = append(got, finding.Kind+" "+finding.Name+": "+finding.Message)
+	}
+	want = []string{
+		"single-implementation Cache: Cache has a single implementation, Mem, which could be used directly",
+		"single-implementation Closer: Closer has a single implementation, Mem, which could be used directly",
+		"single-implementation Store: Store has a single implementation, Mem, which could be used directly",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+}
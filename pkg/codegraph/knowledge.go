@@ -0,0 +1,252 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// KnowledgeVersion is the version of the Knowledge format written by
+// ExportKnowledge; ImportKnowledge reads it and older ones
+const KnowledgeVersion = 1
+
+// Knowledge is what a project has learned about its code: every memory,
+// archived and expired ones included, and every decision. They name the
+// nodes they are about by key, which stays the same across databases and
+// re-indexes, so they can be imported into a database indexed afresh.
+type Knowledge struct {
+	Version    int        `json:"version"`
+	Project    string     `json:"project"`
+	ExportedAt time.Time  `json:"exportedAt"`
+	Memories   []Memory   `json:"memories"`
+	Decisions  []Decision `json:"decisions"`
+}
+
+// KnowledgeImporter is implemented by backends that can store exported
+// memories and decisions as they were, keeping their IDs and times
+type KnowledgeImporter interface {
+	// ImportMemories stores memories, skipping those whose ID is already
+	// stored, and links them to the nodes they are about that exist;
+	// it returns how many it stored
+	ImportMemories(ctx context.Context, project string, memories []Memory) (int, error)
+	// ImportDecisions stores decisions like ImportMemories
+	ImportDecisions(ctx context.Context, project string, decisions []Decision) (int, error)
+}
+
+// KnowledgeImport counts the memories and decisions ImportKnowledge
+// stored, and those it skipped as already stored
+type KnowledgeImport struct {
+	Memories  int `json:"memories"`
+	Decisions int `json:"decisions"`
+	Skipped   int `json:"skipped"`
+}
+
+// ExportKnowledge reads the project's memories from m and decisions from
+// d, either of which may be nil, without recording an access
+func ExportKnowledge(ctx context.Context, m MemoryStore, d DecisionStore, project string) (Knowledge, error) {
+	k := Knowledge{Version: KnowledgeVersion, Project: project, ExportedAt: time.Now().UTC(), Memories: []Memory{}, Decisions: []Decision{}}
+	if m != nil {
+		memories, err := m.Recall(ctx, project, MemoryQuery{All: true, Peek: true})
+		if err != nil {
+			return Knowledge{}, fmt.Errorf("reading memories: %w", err)
+		}
+		k.Memories = append(k.Memories, memories...)
+	}
+	if d != nil {
+		decisions, err := d.Decisions(ctx, project, DecisionQuery{})
+		if err != nil {
+			return Knowledge{}, fmt.Errorf("reading decisions: %w", err)
+		}
+		k.Decisions = append(k.Decisions, decisions...)
+	}
+	return k, nil
+}
+
+// ImportKnowledge stores k's memories and decisions in the project, which
+// need not be the one they were exported from. Those already stored are
+// skipped, so importing the same knowledge twice stores it once.
+func ImportKnowledge(ctx context.Context, imp KnowledgeImporter, project string, k Knowledge) (KnowledgeImport, error) {
+	if k.Version < 1 || k.Version > KnowledgeVersion {
+		return KnowledgeImport{}, fmt.Errorf("unsupported knowledge version %d, want 1 to %d", k.Version, KnowledgeVersion)
+	}
+	var result KnowledgeImport
+	var err error
+	if result.Memories, err = imp.ImportMemories(ctx, project, k.Memories); err != nil {
+		return result, fmt.Errorf("importing memories: %w", err)
+	}
+	if result.Decisions, err = imp.ImportDecisions(ctx, project, k.Decisions); err != nil {
+		return result, fmt.Errorf("importing decisions: %w", err)
+	}
+	result.Skipped = len(k.Memories) + len(k.Decisions) - result.Memories - result.Decisions
+	return result, nil
+}
+
+// storedIDs returns which of ids name a stored node with label
+func storedIDs(ctx context.Context, q Querier, labels LabelMap, project, label string, ids []string) (map[string]bool, error) {
+	stored := make(map[string]bool)
+	if len(ids) == 0 {
+		return stored, nil
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (n:%s:%s) WHERE n.id IN $ids
+		RETURN n.id AS id
+	`, project, labels.Label(label)), map[string]any{"ids": ids})
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if len(row) > 0 {
+			stored[fmt.Sprint(row[0])] = true
+		}
+	}
+	return stored, nil
+}
+
+// nonNil returns list, or an empty list if it is nil, as a parameter
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// optionalTime returns t in UTC as a parameter, or nil if it is zero
+func optionalTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// cypherImportMemories creates the memories not already stored with their
+// IDs, times and mentions, then links them to the sessions, commits,
+// memories and nodes they name that exist. Their keys are not checked, so a memory
+// about a node not indexed yet is kept and linked when it is.
+func cypherImportMemories(ctx context.Context, q Querier, labels LabelMap, project string, memories []Memory) (int, error) {
+	ids := make([]string, 0, len(memories))
+	for _, memory := range memories {
+		if memory.ID == "" || strings.TrimSpace(memory.Text) == "" || len(memory.About) == 0 {
+			return 0, fmt.Errorf("%w %q: no ID, text or key", ErrInvalidMemory, memory.ID)
+		}
+		if err := checkNamespace(memory.Namespace); err != nil {
+			return 0, err
+		}
+		ids = append(ids, memory.ID)
+	}
+	stored, err := storedIDs(ctx, q, labels, project, "Memory", ids)
+	if err != nil {
+		return 0, err
+	}
+	var imported []Memory
+	for _, memory := range memories {
+		if stored[memory.ID] {
+			continue
+		}
+		stored[memory.ID] = true
+		_, _, err := q.Query(ctx, fmt.Sprintf(`
+			CREATE (:%s:%s {id: $id, text: $text, tags: $tags, about: $about, session: $session, createdAt: $createdAt,
+			        expiresAt: $expiresAt, accessedAt: $accessedAt, accesses: $accesses, archived: $archived, sources: $sources,
+			        importance: $importance, confidence: $confidence, pinned: $pinned, namespace: $namespace, mentions: $mentions,
+			        commit: $commit, branch: $branch, pr: $pr, via: $via, agent: $agent, model: $model, user: $user})
+		`, project, labels.Label("Memory")), map[string]any{
+			"id": memory.ID, "text": memory.Text, "tags": nonNil(memory.Tags), "about": memory.About, "session": memory.Session,
+			"createdAt": optionalTime(memory.CreatedAt), "expiresAt": optionalTime(memory.ExpiresAt), "accessedAt": optionalTime(memory.AccessedAt),
+			"accesses": memory.Accesses, "archived": memory.Archived, "sources": nonNil(memory.Sources),
+			"importance": cmp.Or(memory.Importance, DefaultImportance), "confidence": cmp.Or(memory.Confidence, DefaultConfidence),
+			"pinned": memory.Pinned, "namespace": memory.Namespace, "mentions": nonNil(memory.Mentions),
+			"commit": memory.Commit, "branch": memory.Branch, "pr": memory.PR,
+			"via": memory.Via, "agent": memory.Agent, "model": memory.Model, "user": memory.User,
+		})
+		if err != nil {
+			return len(imported), fmt.Errorf("importing memory %s: %w", memory.ID, err)
+		}
+		imported = append(imported, memory)
+	}
+
+	var links []nodeLink
+	for _, memory := range imported {
+		if err := recordInSession(ctx, q, labels, project, memory.Session, "Memory", memory.ID); err != nil {
+			return len(imported), err
+		}
+		if err := recordAtCommit(ctx, q, labels, project, "Memory", memory.ID, memory.GitState); err != nil {
+			return len(imported), err
+		}
+		// Linked once all are created, as a memory may come before the
+		// ones it consolidates
+		if len(memory.Sources) > 0 {
+			_, _, err := q.Query(ctx, fmt.Sprintf(`
+				MATCH (m:%[1]s:%[2]s {id: $id})
+				MATCH (s:%[1]s:%[2]s) WHERE s.id IN $sources
+				MERGE (m)-[:CONSOLIDATES]->(s)
+			`, project, labels.Label("Memory")), map[string]any{"id": memory.ID, "sources": memory.Sources})
+			if err != nil {
+				return len(imported), err
+			}
+		}
+		if err := linkMentions(ctx, q, labels, project, memory.ID, memory.Mentions); err != nil {
+			return len(imported), err
+		}
+		for _, key := range memory.About {
+			links = append(links, nodeLink{memory.ID, key})
+		}
+	}
+	return len(imported), linkNodes(ctx, q, labels, project, "Memory", "ABOUT", links)
+}
+
+// cypherImportDecisions creates the decisions not already stored with
+// their IDs and times, then links them like cypherImportMemories
+func cypherImportDecisions(ctx context.Context, q Querier, labels LabelMap, project string, decisions []Decision) (int, error) {
+	ids := make([]string, 0, len(decisions))
+	for _, decision := range decisions {
+		if decision.ID == "" || strings.TrimSpace(decision.Title) == "" || len(decision.Affects) == 0 {
+			return 0, fmt.Errorf("%w %q: no ID, title or key", ErrInvalidDecision, decision.ID)
+		}
+		if status := decisionStatus(decision.Status); !slices.Contains(DecisionStatuses, status) {
+			return 0, fmt.Errorf("%w %q: unknown status %q", ErrInvalidDecision, decision.ID, decision.Status)
+		}
+		ids = append(ids, decision.ID)
+	}
+	stored, err := storedIDs(ctx, q, labels, project, "Decision", ids)
+	if err != nil {
+		return 0, err
+	}
+	var imported []Decision
+	for _, decision := range decisions {
+		if stored[decision.ID] {
+			continue
+		}
+		stored[decision.ID] = true
+		_, _, err := q.Query(ctx, fmt.Sprintf(`
+			CREATE (:%s:%s {id: $id, title: $title, rationale: $rationale, alternatives: $alternatives,
+			        status: $status, affects: $affects, session: $session, createdAt: $createdAt, updatedAt: $updatedAt,
+			        redacted: $redacted, commit: $commit, branch: $branch, pr: $pr})
+		`, project, labels.Label("Decision")), map[string]any{
+			"id": decision.ID, "title": decision.Title, "rationale": decision.Rationale,
+			"alternatives": nonNil(decision.Alternatives), "status": decisionStatus(decision.Status),
+			"affects": decision.Affects, "session": decision.Session, "createdAt": optionalTime(decision.CreatedAt),
+			"updatedAt": optionalTime(cmp.Or(decision.UpdatedAt, decision.CreatedAt)), "redacted": decision.Redacted, "commit": decision.Commit,
+			"branch": decision.Branch, "pr": decision.PR,
+		})
+		if err != nil {
+			return len(imported), fmt.Errorf("importing decision %s: %w", decision.ID, err)
+		}
+		imported = append(imported, decision)
+	}
+
+	var links []nodeLink
+	for _, decision := range imported {
+		if err := recordInSession(ctx, q, labels, project, decision.Session, "Decision", decision.ID); err != nil {
+			return len(imported), err
+		}
+		if err := recordAtCommit(ctx, q, labels, project, "Decision", decision.ID, decision.GitState); err != nil {
+			return len(imported), err
+		}
+		for _, key := range decision.Affects {
+			links = append(links, nodeLink{decision.ID, key})
+		}
+	}
+	return len(imported), linkNodes(ctx, q, labels, project, "Decision", "AFFECTS", links)
+}
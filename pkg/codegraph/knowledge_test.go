@@ -0,0 +1,155 @@
+package codegraph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKnowledgeImporter keeps imported memories and decisions by ID
+type fakeKnowledgeImporter struct {
+	memories  map[string]Memory
+	decisions map[string]Decision
+}
+
+func (f *fakeKnowledgeImporter) ImportMemories(ctx context.Context, project string, memories []Memory) (int, error) {
+	n := 0
+	for _, m := range memories {
+		if _, ok := f.memories[m.ID]; !ok {
+			f.memories[m.ID] = m
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeKnowledgeImporter) ImportDecisions(ctx context.Context, project string, decisions []Decision) (int, error) {
+	n := 0
+	for _, d := range decisions {
+		if _, ok := f.decisions[d.ID]; !ok {
+			f.decisions[d.ID] = d
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestExportImportKnowledge(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	store := &fakeMemoryStore{memories: []Memory{
+		{ID: "mem-2", Text: "Put holds the lock", About: []string{"Function:store.go:*Store.Put"}, CreatedAt: created, Sources: []string{"mem-1"}},
+		{ID: "mem-1", Text: "Put locks", About: []string{"Function:store.go:*Store.Put"}, CreatedAt: created, Archived: true},
+	}}
+	k, err := ExportKnowledge(context.Background(), store, nil, "App")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Version != KnowledgeVersion || k.Project != "App" || len(k.Memories) != 2 || k.Decisions == nil {
+		t.Errorf("exported %+v", k)
+	}
+	if q := store.queries[0]; !q.All || !q.Peek {
+		t.Errorf("read memories with %+v, want all of them without an access", q)
+	}
+
+	// Through JSON, as the export command writes it
+	data, err := json.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read Knowledge
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	imp := &fakeKnowledgeImporter{memories: map[string]Memory{"mem-1": {}}, decisions: map[string]Decision{}}
+	result, err := ImportKnowledge(context.Background(), imp, "Other", read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (KnowledgeImport{Memories: 1, Skipped: 1}); result != want {
+		t.Errorf("imported %+v, want %+v", result, want)
+	}
+	if got := imp.memories["mem-2"]; !reflect.DeepEqual(got, k.Memories[0]) {
+		t.Errorf("imported %+v, want %+v", got, k.Memories[0])
+	}
+
+	read.Version = KnowledgeVersion + 1
+	if _, err := ImportKnowledge(context.Background(), imp, "Other", read); err == nil {
+		t.Error("imported a newer version")
+	}
+}
+
+func TestCypherImportMemories(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasSuffix(query, "RETURN n.id AS id") {
+			return [][]any{{"mem-1"}}
+		}
+		return nil
+	}}
+	memories := []Memory{
+		{ID: "mem-3", Text: "Put holds the lock", About: []string{"Function:store.go:*Store.Put"}, CreatedAt: created, Sources: []string{"mem-2"},
+			Accesses: 4, GitState: GitState{Commit: "abc123"}},
+		{ID: "mem-2", Text: "Put locks", Tags: []string{"perf"}, About: []string{"File:gone.go"}, CreatedAt: created, Archived: true, Importance: 0.9},
+		{ID: "mem-1", Text: "already imported", About: []string{"File:main.go"}},
+	}
+	n, err := cypherImportMemories(context.Background(), q, LabelMap{}, "App", memories)
+	if err != nil || n != 2 {
+		t.Fatalf("imported %d, %v, want 2", n, err)
+	}
+	var created3 map[string]any
+	var kinds []string
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "CREATE"):
+			kinds = append(kinds, "create "+q.params[i]["id"].(string))
+			if q.params[i]["id"] == "mem-3" {
+				created3 = q.params[i]
+			}
+		case strings.Contains(query, "CONSOLIDATES"):
+			kinds = append(kinds, "consolidates")
+		case strings.Contains(query, "RECORDED_AT"):
+			kinds = append(kinds, "commit")
+		case strings.Contains(query, ":ABOUT"):
+			kinds = append(kinds, "about")
+		}
+	}
+	// Both are created before mem-3 is linked to mem-2, and mem-2's key is
+	// kept though no node has it
+	if want := []string{"create mem-3", "create mem-2", "commit", "consolidates", "about", "about"}; !slices.Equal(kinds, want) {
+		t.Errorf("queries %q, want %q", kinds, want)
+	}
+	if created3["createdAt"] != created || created3["accessedAt"] != nil || created3["accesses"] != 4 ||
+		created3["importance"] != DefaultImportance || !reflect.DeepEqual(created3["tags"], []string{}) {
+		t.Errorf("created mem-3 with %v", created3)
+	}
+
+	if _, err := cypherImportMemories(context.Background(), q, LabelMap{}, "App", []Memory{{Text: "no ID", About: []string{"File:main.go"}}}); !errors.Is(err, ErrInvalidMemory) {
+		t.Errorf("err = %v, want ErrInvalidMemory", err)
+	}
+}
+
+func TestCypherImportDecisions(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	q := &recordingQuerier{}
+	n, err := cypherImportDecisions(context.Background(), q, LabelMap{}, "App", []Decision{
+		{ID: "dec-1", Title: "Use FalkorDB", Status: "Deprecated", Affects: []string{"Package:store"}, CreatedAt: created},
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("imported %d, %v, want 1", n, err)
+	}
+	params := q.params[1]
+	if !strings.HasPrefix(q.queries[1], "CREATE (:App:Decision {") || params["status"] != "deprecated" ||
+		params["createdAt"] != created || params["updatedAt"] != created {
+		t.Errorf("created with %q and %v", q.queries[1], params)
+	}
+	if _, err := cypherImportDecisions(context.Background(), q, LabelMap{}, "App", []Decision{
+		{ID: "dec-2", Title: "x", Status: "maybe", Affects: []string{"Package:store"}},
+	}); !errors.Is(err, ErrInvalidDecision) {
+		t.Errorf("err = %v, want ErrInvalidDecision", err)
+	}
+}
@@ -0,0 +1,263 @@
+//go:build kuzu
+
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/kuzudb/go-kuzu"
+)
+
+// kuzuSchema declares one node table per label and relationship tables for
+// every label pair an edge type connects. Node ids are the project name
+// joined with the node key, so several projects can share a database.
+var kuzuSchema = []string{
+	`CREATE NODE TABLE IF NOT EXISTS Package(id STRING, project STRING, name STRING, path STRING,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING, PRIMARY KEY (id))`,
+	`CREATE NODE TABLE IF NOT EXISTS File(id STRING, project STRING, path STRING, package STRING,
+		language STRING, imports STRING[], createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING, PRIMARY KEY (id))`,
+	`CREATE NODE TABLE IF NOT EXISTS Function(id STRING, project STRING, name STRING, file STRING,
+		signature STRING, receiver STRING, isExport BOOLEAN, lineStart INT64, lineEnd INT64,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING, PRIMARY KEY (id))`,
+	`CREATE NODE TABLE IF NOT EXISTS Method(id STRING, project STRING, name STRING, file STRING,
+		signature STRING, receiver STRING, isExport BOOLEAN, lineStart INT64, lineEnd INT64,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING, PRIMARY KEY (id))`,
+	`CREATE NODE TABLE IF NOT EXISTS Struct(id STRING, project STRING, name STRING, file STRING,
+		fields STRING[], isExport BOOLEAN, createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING, PRIMARY KEY (id))`,
+	`CREATE NODE TABLE IF NOT EXISTS Interface(id STRING, project STRING, name STRING, file STRING,
+		methods STRING[], isExport BOOLEAN, createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING, PRIMARY KEY (id))`,
+	`CREATE REL TABLE IF NOT EXISTS BELONGS_TO(FROM File TO Package,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING)`,
+	`CREATE REL TABLE IF NOT EXISTS IMPORTS(FROM File TO Package,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING)`,
+	`CREATE REL TABLE IF NOT EXISTS CONTAINS(FROM File TO Function, FROM File TO Method,
+		FROM File TO Struct, FROM File TO Interface,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING)`,
+	`CREATE REL TABLE IF NOT EXISTS CALLS(FROM Function TO Function, FROM Function TO Method,
+		FROM Method TO Function, FROM Method TO Method,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING)`,
+	`CREATE REL TABLE IF NOT EXISTS IMPLEMENTS(FROM Struct TO Interface,
+		createdAt TIMESTAMP, updatedAt TIMESTAMP, runId STRING)`,
+}
+
+// KuzuWriter writes into an embedded Kùzu database directory
+type KuzuWriter struct {
+	db   *kuzu.Database
+	conn *kuzu.Connection
+}
+
+// OpenKuzu opens or creates the database directory at path and its schema
+func OpenKuzu(path string) (*KuzuWriter, error) {
+	db, err := kuzu.OpenDatabase(path, kuzu.DefaultSystemConfig())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := kuzu.OpenConnection(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	b := &KuzuWriter{db: db, conn: conn}
+
+	for _, ddl := range kuzuSchema {
+		if err := b.exec(ddl, nil); err != nil {
+			b.Close(context.Background())
+			return nil, fmt.Errorf("creating schema: %w", err)
+		}
+	}
+	slog.Info("opened Kùzu database", "path", path)
+	return b, nil
+}
+
+// exec runs a statement, discarding its result
+func (b *KuzuWriter) exec(query string, params map[string]any) error {
+	result, err := b.run(query, params)
+	if err != nil {
+		return err
+	}
+	result.Close()
+	return nil
+}
+
+// run runs a statement, preparing it when it takes parameters
+func (b *KuzuWriter) run(query string, params map[string]any) (*kuzu.QueryResult, error) {
+	if params == nil {
+		return b.conn.Query(query)
+	}
+	stmt, err := b.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return b.conn.Execute(stmt, params)
+}
+
+func (b *KuzuWriter) Query(ctx context.Context, query string, params map[string]any) (columns []string, rows [][]any, err error) {
+	ctx, span := startQuery(ctx, "kuzu", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := b.run(query, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer result.Close()
+
+	for result.HasNext() {
+		tuple, err := result.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		values, err := tuple.GetAsSlice()
+		tuple.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, values)
+	}
+	return result.GetColumnNames(), rows, nil
+}
+
+func (b *KuzuWriter) Projects(ctx context.Context) ([]string, error) {
+	_, rows, err := b.Query(ctx, `MATCH (p:Package) RETURN DISTINCT p.project ORDER BY p.project`, nil)
+	if err != nil {
+		return nil, err
+	}
+	projects := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if project, ok := row[0].(string); ok {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
+
+func (b *KuzuWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	if err := b.exec("BEGIN TRANSACTION", nil); err != nil {
+		return err
+	}
+	if err := b.write(project, graph, run); err != nil {
+		b.exec("ROLLBACK", nil)
+		return err
+	}
+	if err := b.exec("COMMIT", nil); err != nil {
+		return err
+	}
+
+	// Print summary
+	result, err := b.conn.Query(fmt.Sprintf(`
+		MATCH (n) WHERE n.project = %s
+		RETURN label(n) AS label, count(*) AS count ORDER BY label
+	`, CypherLiteral(project)))
+	if err != nil {
+		return fmt.Errorf("getting summary: %w", err)
+	}
+	defer result.Close()
+
+	for result.HasNext() {
+		tuple, err := result.Next()
+		if err != nil {
+			return err
+		}
+		values, err := tuple.GetAsSlice()
+		if err != nil {
+			return err
+		}
+		slog.Info("graph summary", "label", values[0], "count", values[1])
+	}
+	return nil
+}
+
+func (b *KuzuWriter) write(project string, graph *Graph, run RunInfo) error {
+	slog.Info("creating graph nodes", "project", project)
+	slog.Info("clearing existing nodes", "project", project)
+	for _, table := range NodeLabels {
+		query := fmt.Sprintf(`MATCH (n:%s) WHERE n.project = $project DETACH DELETE n`, table)
+		if err := b.exec(query, map[string]any{"project": project}); err != nil {
+			return fmt.Errorf("clearing %s nodes: %w", table, err)
+		}
+	}
+
+	nodes := graph.Nodes()
+	rels := graph.Relationships()
+	p := StartProgress("writing", "rows", len(nodes)+len(rels))
+	defer p.Finish()
+
+	if len(graph.Properties) > 0 {
+		slog.Warn("Kùzu tables have a fixed schema, not writing the properties enrichers added")
+	}
+
+	slog.Info("creating nodes", "count", len(nodes))
+	labels := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		// Keys are unique per node except for repeated declarations such as init
+		if _, ok := labels[node.Key]; ok {
+			continue
+		}
+		labels[node.Key] = node.Label
+
+		names := make([]string, 0, len(node.Props))
+		for name := range node.Props {
+			if _, custom := graph.Properties[node.Key][name]; !custom {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		params := map[string]any{
+			"id":      project + ":" + node.Key,
+			"project": project,
+			"now":     run.StartedAt,
+			"runId":   run.ID,
+		}
+		assignments := []string{"id: $id", "project: $project", "createdAt: $now", "updatedAt: $now", "runId: $runId"}
+		for _, name := range names {
+			value := node.Props[name]
+			if n, ok := value.(int); ok {
+				value = int64(n)
+			}
+			// An empty list has no element type to bind it by
+			if list, ok := value.([]string); ok && len(list) == 0 {
+				assignments = append(assignments, name+": CAST([] AS STRING[])")
+				continue
+			}
+			params[name] = value
+			assignments = append(assignments, name+": $"+name)
+		}
+
+		query := fmt.Sprintf(`CREATE (n:%s {%s})`, node.Label, strings.Join(assignments, ", "))
+		if err := b.exec(query, params); err != nil {
+			return fmt.Errorf("creating node %s: %w", node.Key, err)
+		}
+		p.Add(1)
+	}
+
+	slog.Info("creating relationships", "count", len(rels))
+	for _, rel := range rels {
+		query := fmt.Sprintf(`
+			MATCH (a:%s {id: $from}), (b:%s {id: $to})
+			CREATE (a)-[:%s {createdAt: $now, updatedAt: $now, runId: $runId}]->(b)
+		`, labels[rel.From], labels[rel.To], rel.Type)
+		params := map[string]any{
+			"from":  project + ":" + rel.From,
+			"to":    project + ":" + rel.To,
+			"now":   run.StartedAt,
+			"runId": run.ID,
+		}
+		if err := b.exec(query, params); err != nil {
+			return fmt.Errorf("creating relationship %s: %w", rel.Key(), err)
+		}
+		p.Add(1)
+	}
+
+	return nil
+}
+
+func (b *KuzuWriter) Close(ctx context.Context) error {
+	b.conn.Close()
+	b.db.Close()
+	return nil
+}
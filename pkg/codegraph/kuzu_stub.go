@@ -0,0 +1,37 @@
+//go:build !kuzu
+
+package codegraph
+
+import (
+	"context"
+	"errors"
+)
+
+// errNoKuzu is returned by every KuzuWriter of a build without the kuzu tag.
+// go-kuzu needs cgo and the Kùzu C library, which the other backends do not.
+var errNoKuzu = errors.New("kuzu support not compiled in, build with -tags kuzu")
+
+// KuzuWriter writes into an embedded Kùzu database directory. This build
+// leaves it out; rebuild with -tags kuzu to use it.
+type KuzuWriter struct{}
+
+// OpenKuzu fails, as Kùzu is not compiled in
+func OpenKuzu(path string) (*KuzuWriter, error) {
+	return nil, errNoKuzu
+}
+
+func (b *KuzuWriter) Query(ctx context.Context, query string, params map[string]any) ([]string, [][]any, error) {
+	return nil, nil, errNoKuzu
+}
+
+func (b *KuzuWriter) Projects(ctx context.Context) ([]string, error) {
+	return nil, errNoKuzu
+}
+
+func (b *KuzuWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	return errNoKuzu
+}
+
+func (b *KuzuWriter) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+//go:build kuzu
+
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestKuzuWriter(t *testing.T) {
+	ctx := context.Background()
+	backend, err := OpenKuzu(filepath.Join(t.TempDir(), "graph.kuzu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	for _, project := range []string{"App", "App", "Other"} {
+		if err := backend.Write(ctx, project, graph, NewRunInfo()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(query string) int64 {
+		t.Helper()
+		result, err := backend.conn.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer result.Close()
+		tuple, err := result.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := tuple.GetAsSlice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values[0].(int64)
+	}
+	if n := count(`MATCH (n) WHERE n.project = 'App' RETURN count(*)`); n != int64(len(graph.Nodes())) {
+		t.Errorf("%d App nodes after writing twice, want %d", n, len(graph.Nodes()))
+	}
+	if n := count(`MATCH (a)-[r]->(b) WHERE a.project = 'App' RETURN count(*)`); n != int64(len(graph.Relationships())) {
+		t.Errorf("%d App relationships after writing twice, want %d", n, len(graph.Relationships()))
+	}
+	if n := count(`MATCH (a:Function)-[:CALLS]->(b:Method {name: 'Put'}) WHERE a.project = 'Other' RETURN count(*)`); n != 1 {
+		t.Errorf("%d calls of Put in Other, want 1", n)
+	}
+}
+
+func TestKuzuQuery(t *testing.T) {
+	ctx := context.Background()
+	backend, err := OpenKuzu(filepath.Join(t.TempDir(), "graph.kuzu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+	if err := backend.Write(ctx, "App", parseTestTree(t, Filter{}), NewRunInfo()); err != nil {
+		t.Fatal(err)
+	}
+
+	columns, rows, err := backend.Query(ctx,
+		`MATCH (f:Function) WHERE f.project = $project RETURN f.name AS name ORDER BY name`,
+		map[string]any{"project": "App"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(columns, []string{"name"}) {
+		t.Errorf("columns = %q, want [name]", columns)
+	}
+	if fmt.Sprint(rows) != "[[New] [main] [run]]" {
+		t.Errorf("rows = %v, want [[New] [main] [run]]", rows)
+	}
+	if _, _, err := backend.Query(ctx, "MATCH (", nil); err == nil {
+		t.Error("invalid query accepted")
+	}
+}
+
+func TestKuzuProjects(t *testing.T) {
+	ctx := context.Background()
+	backend, err := OpenKuzu(filepath.Join(t.TempDir(), "graph.kuzu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	for _, project := range []string{"Web", "App"} {
+		if err := backend.Write(ctx, project, graph, NewRunInfo()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	projects, err := backend.Projects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"App", "Web"}; !slices.Equal(projects, want) {
+		t.Errorf("Projects() = %v, want %v", projects, want)
+	}
+}
+
+func TestTraceQuery(t *testing.T) {
+	ctx, spans := traceSpans(t)
+	backend, err := OpenKuzu(filepath.Join(t.TempDir(), "graph.kuzu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	backend.Query(ctx, "MATCH (f:Function)\n\tRETURN count(*)", nil)
+	backend.Query(ctx, "MATCH (", nil)
+
+	queries := spans()
+	if len(queries) != 2 {
+		t.Fatalf("%d spans, want 2", len(queries))
+	}
+	for i, want := range []codes.Code{codes.Unset, codes.Error} {
+		span := queries[i]
+		if span.Name() != "query" || spanAttr(span, "db.system.name").AsString() != "kuzu" || span.Status().Code != want {
+			t.Errorf("span %d = %s %v, status %v", i, span.Name(), span.Attributes(), span.Status())
+		}
+	}
+	if got := spanAttr(queries[0], "db.query.text").AsString(); got != "MATCH (f:Function)\nRETURN count(*)" {
+		t.Errorf("query text = %q", got)
+	}
+}
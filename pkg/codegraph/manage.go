@@ -0,0 +1,147 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Manager is implemented by backends that can delete and list a project's
+// stored data outside of a write
+type Manager interface {
+	// DeleteProject removes every node and relationship of project and
+	// returns how many nodes it removed
+	DeleteProject(ctx context.Context, project string) (int, error)
+	// Snapshots lists the runs whose writes are still stored, newest first
+	Snapshots(ctx context.Context, project string) ([]Snapshot, error)
+	// Prune removes data of project that stopped being current before
+	// before, returning how many nodes and relationships it removed
+	Prune(ctx context.Context, project string, before time.Time) (int, error)
+}
+
+// Snapshot is a run whose writes are still stored: the nodes it was the
+// last to write, and with soft deletes those of them marked deleted since
+type Snapshot struct {
+	RunID   string    `json:"runId"`
+	Commit  string    `json:"commit,omitempty"`
+	Written time.Time `json:"written"`
+	Nodes   int       `json:"nodes"`
+	Deleted int       `json:"deleted"`
+}
+
+// cypherDeleteProject removes every node labelled project, Run and Author
+// nodes included
+func cypherDeleteProject(ctx context.Context, q Querier, project string) (int, error) {
+	return countAndDelete(ctx, q, fmt.Sprintf(`MATCH (n:%s)`, project), `DETACH DELETE n`, nil)
+}
+
+// cypherSnapshots groups a project's nodes by the run that last wrote them
+func cypherSnapshots(ctx context.Context, q Querier, project string) ([]Snapshot, error) {
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (n:%s) WHERE n.runId IS NOT NULL AND NOT n:Run
+		RETURN n.runId AS runId, max(n.commit) AS commit, max(n.updatedAt) AS written,
+		       sum(CASE WHEN coalesce(n.deleted, false) THEN 0 ELSE 1 END) AS nodes,
+		       sum(CASE WHEN coalesce(n.deleted, false) THEN 1 ELSE 0 END) AS deleted
+		ORDER BY runId DESC
+	`, project), nil)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 5 {
+			continue
+		}
+		commit, _ := row[1].(string)
+		snapshots = append(snapshots, Snapshot{
+			RunID:   fmt.Sprint(row[0]),
+			Commit:  commit,
+			Written: timeValue(row[2]),
+			Nodes:   intValue(row[3]),
+			Deleted: intValue(row[4]),
+		})
+	}
+	return snapshots, nil
+}
+
+// cypherPrune removes the nodes and relationships soft deletes marked
+// deleted before before, and the Run nodes --record-run stored before then
+func cypherPrune(ctx context.Context, q Querier, project string, before time.Time) (int, error) {
+	params := map[string]any{"before": before.UTC()}
+	steps := []struct{ match, remove string }{
+		{fmt.Sprintf(`MATCH (:%s)-[r]->(:%s) WHERE coalesce(r.deleted, false) AND r.deletedAt < $before`, project, project), `DELETE r`},
+		{fmt.Sprintf(`MATCH (n:%s) WHERE coalesce(n.deleted, false) AND n.deletedAt < $before`, project), `DETACH DELETE n`},
+		{fmt.Sprintf(`MATCH (n:%s:Run) WHERE n.startedAt < $before`, project), `DELETE n`},
+	}
+	removed := 0
+	for _, step := range steps {
+		n, err := countAndDelete(ctx, q, step.match, step.remove, params)
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// countAndDelete counts what match finds, then removes it if there is
+// anything, as not every Cypher dialect returns rows after a delete
+func countAndDelete(ctx context.Context, q Querier, match, remove string, params map[string]any) (int, error) {
+	_, rows, err := q.Query(ctx, match+"\nRETURN count(*) AS count", params)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return 0, nil
+	}
+	n := intValue(rows[0][0])
+	if n == 0 {
+		return 0, nil
+	}
+	if _, _, err := q.Query(ctx, match+"\n"+remove, params); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// intValue reads a count returned by any backend
+func intValue(value any) int {
+	switch v := value.(type) {
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// floatValue reads a number returned by any backend
+func floatValue(value any) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	}
+	return 0
+}
+
+// timeValue reads a timestamp returned as a datetime, or as the RFC3339
+// string FalkorDB and SQLite store
+func timeValue(value any) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		t, _ := time.Parse(time.RFC3339Nano, v)
+		return t
+	case []byte:
+		t, _ := time.Parse(time.RFC3339Nano, string(v))
+		return t
+	}
+	return time.Time{}
+}
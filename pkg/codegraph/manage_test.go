@@ -0,0 +1,92 @@
+package codegraph
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeQuerier answers count queries from counts, by the start of the query,
+// and records every query it is sent
+type fakeQuerier struct {
+	counts  map[string]int64
+	queries []string
+	params  []map[string]any
+}
+
+func (q *fakeQuerier) Query(ctx context.Context, query string, params map[string]any) ([]string, [][]any, error) {
+	q.queries = append(q.queries, query)
+	q.params = append(q.params, params)
+	if !strings.HasSuffix(query, "RETURN count(*) AS count") {
+		return nil, nil, nil
+	}
+	for prefix, n := range q.counts {
+		if strings.HasPrefix(query, prefix) {
+			return []string{"count"}, [][]any{{n}}, nil
+		}
+	}
+	return []string{"count"}, [][]any{{int64(0)}}, nil
+}
+
+func TestCypherPrune(t *testing.T) {
+	q := &fakeQuerier{counts: map[string]int64{
+		"MATCH (:App)-[r]->(:App)": 4,
+		"MATCH (n:App:Run)":        2,
+	}}
+	before := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	removed, err := cypherPrune(context.Background(), q, "App", before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 6 {
+		t.Errorf("removed = %d, want 6", removed)
+	}
+
+	// Three counts, and deletes only for the steps that found something
+	var deletes []string
+	for i, query := range q.queries {
+		if q.params[i]["before"] != before {
+			t.Errorf("%q: before = %v", query, q.params[i]["before"])
+		}
+		if !strings.Contains(query, "RETURN") {
+			deletes = append(deletes, query[strings.LastIndex(query, "\n")+1:])
+		}
+	}
+	if len(q.queries) != 5 || strings.Join(deletes, ", ") != "DELETE r, DELETE n" {
+		t.Errorf("queries = %q", q.queries)
+	}
+}
+
+func TestCypherSnapshots(t *testing.T) {
+	written := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	q := querierFunc(func(query string) [][]any {
+		return [][]any{
+			{"20260304T050607Z-b", "abc123", written.Format(time.RFC3339Nano), int64(10), int64(2)},
+			{"20260301T000000Z-a", nil, written, int64(0), int64(3)},
+		}
+	})
+	snapshots, err := cypherSnapshots(context.Background(), q, "App")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Snapshot{
+		{RunID: "20260304T050607Z-b", Commit: "abc123", Written: written, Nodes: 10, Deleted: 2},
+		{RunID: "20260301T000000Z-a", Written: written, Deleted: 3},
+	}
+	if len(snapshots) != len(want) {
+		t.Fatalf("snapshots = %+v, want %+v", snapshots, want)
+	}
+	for i := range want {
+		if snapshots[i] != want[i] {
+			t.Errorf("snapshot %d = %+v, want %+v", i, snapshots[i], want[i])
+		}
+	}
+}
+
+// querierFunc answers every query with the rows of a function
+type querierFunc func(query string) [][]any
+
+func (f querierFunc) Query(ctx context.Context, query string, params map[string]any) ([]string, [][]any, error) {
+	return nil, f(query), nil
+}
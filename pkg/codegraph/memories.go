@@ -0,0 +1,565 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MemoryStore is implemented by backends that keep memories: free-form
+// notes about code, such as an agent's observations, attached to the nodes
+// they are about and kept across the writes that replace those nodes
+type MemoryStore interface {
+	// Remember stores memory, assigning its ID and CreatedAt, and links it
+	// to each node it is about; every key must name a stored node
+	Remember(ctx context.Context, project string, memory Memory) (Memory, error)
+	// Recall lists the memories matching q, pinned ones first and then
+	// newest first or by q.Ranking, and records they were accessed unless
+	// q.Peek is set
+	Recall(ctx context.Context, project string, q MemoryQuery) ([]Memory, error)
+	// Forget deletes a memory, reporting whether it existed
+	Forget(ctx context.Context, project, id string) (bool, error)
+	// PinMemory pins or unpins a memory, reporting whether it exists
+	PinMemory(ctx context.Context, project, id string, pinned bool) (bool, error)
+	// ArchiveMemories hides memories from Recall without deleting them,
+	// returning how many it archived
+	ArchiveMemories(ctx context.Context, project string, ids []string) (int, error)
+}
+
+// Memory is a note about one or more nodes, named by key, made during the
+// session with ID Session if set. It is no longer recalled after ExpiresAt,
+// if set, or once archived. AccessedAt and Accesses record when and how
+// often it was recalled. A memory consolidating near-duplicates lists their
+// IDs in Sources. Importance and Confidence, from 0 to 1, weigh it when
+// recall ranks memories, and a pinned memory is always recalled first.
+// GitState records the code it was made at. Namespace, if set, keeps it to
+// a team or a user, such as team:payments or user:alice; memories without
+// one are shared by everyone working on the project. Mentions are the keys
+// of the nodes its text names and the IDs of the memories, decisions and
+// summaries it cites, found when it is remembered. Project is only set on
+// memories RecallLinked found in another project.
+type Memory struct {
+	ID         string    `json:"id"`
+	Text       string    `json:"text"`
+	Tags       []string  `json:"tags,omitempty"`
+	About      []string  `json:"about"`
+	Session    string    `json:"session,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt,omitzero"`
+	AccessedAt time.Time `json:"accessedAt,omitzero"`
+	Accesses   int       `json:"accesses,omitempty"`
+	Archived   bool      `json:"archived,omitempty"`
+	Sources    []string  `json:"sources,omitempty"`
+	Importance float64   `json:"importance,omitempty"`
+	Confidence float64   `json:"confidence,omitempty"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Mentions   []string  `json:"mentions,omitempty"`
+	Project    string    `json:"project,omitempty"`
+	GitState
+	Provenance
+}
+
+// Importance and confidence of memories stored without them, or before
+// they were recorded
+const (
+	DefaultImportance = 0.5
+	DefaultConfidence = 1.0
+)
+
+// MemoryQuery selects memories: the one with ID ID, those about the node
+// keyed About, about the file File or anything declared in it, made
+// during Session, made after Since and written with every field set in
+// Provenance, if set, tagged with every tag in Tags and none in
+// ExcludeTags, at most Limit of them if positive. Namespaces, if not nil,
+// keeps to the shared memories and those in the namespaces listed.
+// Expired and archived memories are only selected with All, and Peek
+// lists memories without recording an access. Ranking, if set, orders
+// the memories by its score instead of newest first. Question, if set,
+// keeps only the memories sharing words with it, most relevant first.
+type MemoryQuery struct {
+	ID          string
+	About       string
+	File        string
+	Tags        []string
+	ExcludeTags []string
+	Namespaces  []string
+	Session     string
+	Since       time.Time
+	Provenance  Provenance
+	Question    string
+	Limit       int
+	All         bool
+	Peek        bool
+	Ranking     *Ranking
+}
+
+var (
+	// ErrInvalidMemory is returned for a memory without text, with no key
+	// or an invalid one, or in an invalid namespace
+	ErrInvalidMemory = errors.New("invalid memory")
+	// ErrNoNode is returned for a key naming no stored node
+	ErrNoNode = errors.New("no such node")
+	// ErrNoMemory is returned for a memory ID naming no live memory
+	ErrNoMemory = errors.New("no such memory")
+)
+
+// ParseKey splits a node key into its kind and the identity properties of
+// NodeKeys. A Function key with a receiver is a Method.
+func ParseKey(key string) (string, map[string]any, error) {
+	kind, rest, _ := strings.Cut(key, ":")
+	props := make(map[string]any)
+	switch kind {
+	case "Package", "File":
+		props["path"] = rest
+	case "Function", "Struct", "Interface":
+		i := strings.LastIndex(rest, ":")
+		if i < 0 {
+			return "", nil, fmt.Errorf("invalid key %q", key)
+		}
+		props["file"], props["name"] = rest[:i], rest[i+1:]
+		if kind == "Function" {
+			props["receiver"] = ""
+			if receiver, name, ok := strings.Cut(rest[i+1:], "."); ok {
+				kind, props["receiver"], props["name"] = "Method", receiver, name
+			}
+		}
+	default:
+		return "", nil, fmt.Errorf("invalid key %q", key)
+	}
+	return kind, props, nil
+}
+
+// checkNamespace returns ErrInvalidMemory for a namespace that cannot be
+// told apart in a list of them: * or one with a comma or spaces around it
+func checkNamespace(namespace string) error {
+	if namespace == "*" || strings.Contains(namespace, ",") || strings.TrimSpace(namespace) != namespace {
+		return fmt.Errorf("%w: invalid namespace %q", ErrInvalidMemory, namespace)
+	}
+	return nil
+}
+
+// newMemoryID returns an ID that sorts by creation time
+func newMemoryID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return "mem-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// nodeLink is a relationship from the memory, decision or session with ID
+// id to the node keyed key
+type nodeLink struct {
+	id, key string
+}
+
+// cypherRemember checks every key of memory names a live node and finds
+// the nodes its text mentions, then creates the Memory node and links it
+// to them, to the memories it consolidates and to the commit it was made
+// at. The keys of a consolidating memory come from its sources
+// and are not checked again, as the nodes of some may since have gone.
+func cypherRemember(ctx context.Context, q Querier, labels LabelMap, project string, memory Memory) (Memory, error) {
+	if strings.TrimSpace(memory.Text) == "" {
+		return Memory{}, fmt.Errorf("%w: no text", ErrInvalidMemory)
+	}
+	if len(memory.About) == 0 {
+		return Memory{}, fmt.Errorf("%w: not about any node", ErrInvalidMemory)
+	}
+	if memory.Importance < 0 || memory.Importance > 1 || memory.Confidence < 0 || memory.Confidence > 1 {
+		return Memory{}, fmt.Errorf("%w: importance and confidence must be from 0 to 1", ErrInvalidMemory)
+	}
+	if err := checkNamespace(memory.Namespace); err != nil {
+		return Memory{}, err
+	}
+	if len(memory.Sources) == 0 {
+		if err := checkKeys(ctx, q, labels, project, memory.About, ErrInvalidMemory); err != nil {
+			return Memory{}, err
+		}
+	}
+	if memory.Session != "" {
+		if err := sessionExists(ctx, q, labels, project, memory.Session); err != nil {
+			return Memory{}, err
+		}
+	}
+
+	mentions, err := cypherMentions(ctx, q, labels, project, memory.Text)
+	if err != nil {
+		return Memory{}, fmt.Errorf("finding mentions: %w", err)
+	}
+	memory.Mentions = slices.DeleteFunc(mentions, func(mention string) bool {
+		return slices.Contains(memory.About, mention) || slices.Contains(memory.Sources, mention)
+	})
+
+	now := time.Now().UTC()
+	memory.ID, memory.CreatedAt = newMemoryID(now), now
+	memory.AccessedAt, memory.Accesses, memory.Archived = time.Time{}, 0, false
+	if memory.Importance == 0 {
+		memory.Importance = DefaultImportance
+	}
+	if memory.Confidence == 0 {
+		memory.Confidence = DefaultConfidence
+	}
+	var expiresAt any
+	if !memory.ExpiresAt.IsZero() {
+		memory.ExpiresAt = memory.ExpiresAt.UTC()
+		expiresAt = memory.ExpiresAt
+	}
+	memory.Tags = slices.DeleteFunc(slices.Clone(memory.Tags), func(tag string) bool { return tag == "" })
+	if memory.Tags == nil {
+		memory.Tags = []string{}
+	}
+	sources := memory.Sources
+	if sources == nil {
+		sources = []string{}
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		CREATE (:%s:%s {id: $id, text: $text, tags: $tags, about: $about, session: $session, createdAt: $createdAt,
+		        expiresAt: $expiresAt, accesses: 0, sources: $sources, importance: $importance, confidence: $confidence,
+		        pinned: $pinned, namespace: $namespace, mentions: $mentions, commit: $commit, branch: $branch, pr: $pr,
+		        via: $via, agent: $agent, model: $model, user: $user})
+	`, project, labels.Label("Memory")), map[string]any{
+		"id": memory.ID, "text": memory.Text, "tags": memory.Tags, "about": memory.About, "session": memory.Session, "createdAt": now,
+		"expiresAt": expiresAt, "sources": sources, "importance": memory.Importance, "confidence": memory.Confidence,
+		"pinned": memory.Pinned, "namespace": memory.Namespace, "mentions": memory.Mentions, "commit": memory.Commit, "branch": memory.Branch, "pr": memory.PR,
+		"via": memory.Via, "agent": memory.Agent, "model": memory.Model, "user": memory.User,
+	})
+	if err != nil {
+		return Memory{}, err
+	}
+	if len(memory.Sources) > 0 {
+		_, _, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (m:%[1]s:%[2]s {id: $id})
+			MATCH (s:%[1]s:%[2]s) WHERE s.id IN $sources
+			MERGE (m)-[:CONSOLIDATES]->(s)
+		`, project, labels.Label("Memory")), map[string]any{"id": memory.ID, "sources": memory.Sources})
+		if err != nil {
+			return Memory{}, err
+		}
+	}
+	if err := recordInSession(ctx, q, labels, project, memory.Session, "Memory", memory.ID); err != nil {
+		return Memory{}, err
+	}
+	if err := recordAtCommit(ctx, q, labels, project, "Memory", memory.ID, memory.GitState); err != nil {
+		return Memory{}, err
+	}
+	links := make([]nodeLink, len(memory.About))
+	for i, key := range memory.About {
+		links[i] = nodeLink{memory.ID, key}
+	}
+	if err := linkNodes(ctx, q, labels, project, "Memory", "ABOUT", links); err != nil {
+		return Memory{}, err
+	}
+	return memory, linkMentions(ctx, q, labels, project, memory.ID, memory.Mentions)
+}
+
+// checkKeys checks every key is valid, or returns invalid, and names a
+// live node, or returns ErrNoNode
+func checkKeys(ctx context.Context, q Querier, labels LabelMap, project string, keys []string, invalid error) error {
+	for _, key := range keys {
+		kind, props, err := ParseKey(key)
+		if err != nil {
+			return fmt.Errorf("%w: %w", invalid, err)
+		}
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (n:%s:%s %s) WHERE NOT coalesce(n.deleted, false)
+			RETURN count(n) AS count
+		`, project, labels.Label(kind), keyPattern(kind, "$")), props)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 || len(rows[0]) == 0 || intValue(rows[0][0]) == 0 {
+			return fmt.Errorf("%w %q", ErrNoNode, key)
+		}
+	}
+	return nil
+}
+
+// recordInSession links the session, if any, to the node of label with
+// the given ID that was recorded during it
+func recordInSession(ctx context.Context, q Querier, labels LabelMap, project, session, label, id string) error {
+	if session == "" {
+		return nil
+	}
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (s:%s:%s {id: $session})
+		MATCH (n:%s:%s {id: $id})
+		MERGE (s)-[:RECORDED]->(n)
+	`, project, labels.Label("Session"), project, labels.Label(label)), map[string]any{"id": id, "session": session})
+	return err
+}
+
+// cypherRecall lists the memories matching mq, pinned ones first and then
+// newest first or by mq.Ranking, then records the access unless mq.Peek is
+// set. Ranked memories are all read, ranked, then limited.
+func cypherRecall(ctx context.Context, q Querier, labels LabelMap, project string, mq MemoryQuery) ([]Memory, error) {
+	limit := ""
+	if mq.Limit > 0 && mq.Ranking == nil && mq.Question == "" {
+		limit = fmt.Sprintf("LIMIT %d", mq.Limit)
+	}
+	now := time.Now().UTC()
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (m:%s:%s)
+		WHERE ($id = '' OR m.id = $id) AND ($about = '' OR $about IN m.about)
+		  AND all(tag IN $tags WHERE tag IN m.tags) AND none(tag IN $excludeTags WHERE tag IN m.tags)
+		  AND ($anyNamespace OR coalesce(m.namespace, '') = '' OR m.namespace IN $namespaces)
+		  AND ($file = '' OR any(key IN m.about WHERE key = 'File:' + $file OR key STARTS WITH 'Function:' + $file + ':'
+		       OR key STARTS WITH 'Struct:' + $file + ':' OR key STARTS WITH 'Interface:' + $file + ':'))
+		  AND ($session = '' OR m.session = $session) AND ($since IS NULL OR m.createdAt > $since)
+		  AND ($via = '' OR m.via = $via) AND ($agent = '' OR m.agent = $agent)
+		  AND ($model = '' OR m.model = $model) AND ($user = '' OR m.user = $user)
+		  AND ($all OR NOT coalesce(m.archived, false) AND (m.expiresAt IS NULL OR m.expiresAt > $now))
+		RETURN m.id AS id, m.text AS text, m.tags AS tags, m.about AS about, m.session AS session, m.createdAt AS createdAt,
+		       m.expiresAt AS expiresAt, m.accessedAt AS accessedAt, m.accesses AS accesses, m.archived AS archived,
+		       m.sources AS sources, m.importance AS importance, m.confidence AS confidence, m.pinned AS pinned,
+		       m.commit AS commit, m.branch AS branch, m.pr AS pr, m.namespace AS namespace, m.mentions AS mentions,
+		       m.via AS via, m.agent AS agent, m.model AS model, m.user AS user
+		ORDER BY coalesce(m.pinned, false) DESC, m.id DESC
+		%s
+	`, project, labels.Label("Memory"), limit), map[string]any{
+		"id": mq.ID, "about": mq.About, "file": mq.File, "tags": nonNil(mq.Tags), "excludeTags": nonNil(mq.ExcludeTags),
+		"anyNamespace": mq.Namespaces == nil, "namespaces": nonNil(mq.Namespaces), "session": mq.Session, "since": optionalTime(mq.Since),
+		"via": mq.Provenance.Via, "agent": mq.Provenance.Agent, "model": mq.Provenance.Model, "user": mq.Provenance.User,
+		"all": mq.All, "now": now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	memories := make([]Memory, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 23 {
+			continue
+		}
+		session, _ := row[4].(string)
+		archived, _ := row[9].(bool)
+		pinned, _ := row[13].(bool)
+		namespace, _ := row[17].(string)
+		memories = append(memories, Memory{
+			ID:         fmt.Sprint(row[0]),
+			Text:       fmt.Sprint(row[1]),
+			Tags:       stringList(row[2]),
+			About:      stringList(row[3]),
+			Session:    session,
+			CreatedAt:  timeValue(row[5]),
+			ExpiresAt:  timeValue(row[6]),
+			AccessedAt: timeValue(row[7]),
+			Accesses:   intValue(row[8]),
+			Archived:   archived,
+			Sources:    stringList(row[10]),
+			Importance: cmp.Or(floatValue(row[11]), DefaultImportance),
+			Confidence: cmp.Or(floatValue(row[12]), DefaultConfidence),
+			Pinned:     pinned,
+			GitState:   gitState(row[14:17]),
+			Namespace:  namespace,
+			Mentions:   stringList(row[18]),
+			Provenance: provenance(row[19:23]),
+		})
+	}
+	if mq.Ranking != nil {
+		mq.Ranking.Rank(memories, now)
+	}
+	if mq.Question != "" {
+		memories = matchQuestion(memories, mq.Question)
+	}
+	if mq.Limit > 0 && len(memories) > mq.Limit {
+		memories = memories[:mq.Limit]
+	}
+	if mq.Peek || len(memories) == 0 {
+		return memories, nil
+	}
+	ids := make([]string, len(memories))
+	for i := range memories {
+		ids[i] = memories[i].ID
+		memories[i].AccessedAt = now
+		memories[i].Accesses++
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		MATCH (m:%s:%s) WHERE m.id IN $ids
+		SET m.accessedAt = $now, m.accesses = coalesce(m.accesses, 0) + 1
+	`, project, labels.Label("Memory")), map[string]any{"ids": ids, "now": now})
+	if err != nil {
+		return nil, fmt.Errorf("recording access: %w", err)
+	}
+	return memories, nil
+}
+
+// CorrectMemory replaces the live memory id with one saying text instead,
+// about the same nodes and with the same tags, importance and confidence.
+// The memory corrected is archived and listed in the correction's
+// Sources, so it is kept as its provenance but no longer recalled. The
+// correction is recorded as written at state, by whoever by says.
+func CorrectMemory(ctx context.Context, m MemoryStore, project, id, text string, state GitState, by Provenance) (Memory, error) {
+	memories, err := m.Recall(ctx, project, MemoryQuery{ID: id, Peek: true})
+	if err != nil {
+		return Memory{}, err
+	}
+	i := slices.IndexFunc(memories, func(memory Memory) bool { return memory.ID == id })
+	if i < 0 {
+		return Memory{}, fmt.Errorf("%w %q", ErrNoMemory, id)
+	}
+	old := memories[i]
+	correction, err := m.Remember(ctx, project, Memory{
+		Text:       text,
+		Tags:       old.Tags,
+		About:      old.About,
+		ExpiresAt:  old.ExpiresAt,
+		Sources:    []string{id},
+		Importance: old.Importance,
+		Confidence: old.Confidence,
+		Pinned:     old.Pinned,
+		Namespace:  old.Namespace,
+		GitState:   state,
+		Provenance: by,
+	})
+	if err != nil {
+		return Memory{}, fmt.Errorf("correcting %s: %w", id, err)
+	}
+	if _, err := m.ArchiveMemories(ctx, project, []string{id}); err != nil {
+		return correction, fmt.Errorf("archiving %s: %w", id, err)
+	}
+	return correction, nil
+}
+
+// cypherForget deletes the memory with the given ID
+func cypherForget(ctx context.Context, q Querier, labels LabelMap, project, id string) (bool, error) {
+	n, err := countAndDelete(ctx, q, fmt.Sprintf(`MATCH (m:%s:%s {id: $id})`, project, labels.Label("Memory")), `DETACH DELETE m`, map[string]any{"id": id})
+	return n > 0, err
+}
+
+// cypherPinMemory sets whether the memory with the given ID is pinned
+func cypherPinMemory(ctx context.Context, q Querier, labels LabelMap, project, id string, pinned bool) (bool, error) {
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (m:%s:%s {id: $id})
+		SET m.pinned = $pinned
+		RETURN count(m) AS count
+	`, project, labels.Label("Memory")), map[string]any{"id": id, "pinned": pinned})
+	if err != nil || len(rows) == 0 || len(rows[0]) == 0 {
+		return false, err
+	}
+	return intValue(rows[0][0]) > 0, nil
+}
+
+// cypherArchiveMemories marks the memories with the given IDs archived
+func cypherArchiveMemories(ctx context.Context, q Querier, labels LabelMap, project string, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (m:%s:%s) WHERE m.id IN $ids AND NOT coalesce(m.archived, false)
+		SET m.archived = true, m.archivedAt = $now
+		RETURN count(m) AS count
+	`, project, labels.Label("Memory")), map[string]any{"ids": ids, "now": time.Now().UTC()})
+	if err != nil || len(rows) == 0 || len(rows[0]) == 0 {
+		return 0, err
+	}
+	return intValue(rows[0][0]), nil
+}
+
+// relinks are the lists of keys stored on memories, decisions, summaries,
+// sessions, conventions, findings and cycles, with the relationship
+// linking them to the nodes named, and the prefix turning a list item into a key. The
+// IDs among a memory's mentions are not keys and are skipped, as the nodes
+// they name are not replaced by writes.
+var relinks = []struct{ label, list, rel, prefix string }{
+	{"Memory", "about", "ABOUT", ""},
+	{"Decision", "affects", "AFFECTS", ""},
+	{"Summary", "about", "ABOUT", ""},
+	{"Session", "touched", "TOUCHED", "File:"},
+	{"Convention", "packages", "APPLIES_TO", "Package:"},
+	{"Finding", "about", "FLAGS", ""},
+	{"Cycle", "packages", "INCLUDES", "Package:"},
+	{"Memory", "mentions", "MENTIONS", ""},
+}
+
+// cypherRelink links the project's memories, decisions, summaries,
+// sessions, conventions, findings and cycles again to the nodes they are
+// about, mention, affect, touched, apply to, flag and include, and its
+// nodes to and from other projects' by their links, after a write has
+// replaced those nodes. Keys naming nodes that no longer exist are kept,
+// unlinked.
+func cypherRelink(ctx context.Context, q Querier, labels LabelMap, project string) error {
+	for _, r := range relinks {
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (n:%s:%s)
+			UNWIND n.%s AS item
+			RETURN n.id AS id, item
+		`, project, labels.Label(r.label), r.list), nil)
+		if err != nil {
+			return err
+		}
+		links := make([]nodeLink, 0, len(rows))
+		for _, row := range rows {
+			if len(row) == 2 {
+				links = append(links, nodeLink{fmt.Sprint(row[0]), r.prefix + fmt.Sprint(row[1])})
+			}
+		}
+		if err := linkNodes(ctx, q, labels, project, r.label, r.rel, links); err != nil {
+			return err
+		}
+	}
+	return relinkCrossLinks(ctx, q, labels, project)
+}
+
+// linkNodes merges a rel relationship from the from node with each link's
+// ID to the node with its key, with one UNWIND statement per kind of node
+// linked to
+func linkNodes(ctx context.Context, q Querier, labels LabelMap, project, from, rel string, links []nodeLink) error {
+	rows := make(map[string][]any)
+	for _, link := range links {
+		kind, props, err := ParseKey(link.key)
+		if err != nil {
+			continue
+		}
+		props["id"] = link.id
+		rows[kind] = append(rows[kind], props)
+	}
+	for _, kind := range NodeLabels {
+		if len(rows[kind]) == 0 {
+			continue
+		}
+		_, _, err := q.Query(ctx, fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (m:%s:%s {id: row.id})
+			MATCH (n:%s:%s %s)
+			MERGE (m)-[:%s]->(n)
+		`, project, labels.Label(from), project, labels.Label(kind), keyPattern(kind, "row."), rel), map[string]any{"rows": rows[kind]})
+		if err != nil {
+			return fmt.Errorf("linking %s nodes to %s nodes: %w", from, kind, err)
+		}
+	}
+	return nil
+}
+
+// keyPattern is the property map matching a node of kind on its NodeKeys,
+// read from parameters or row fields named after them
+func keyPattern(kind, prefix string) string {
+	fields := make([]string, len(NodeKeys[kind]))
+	for i, prop := range NodeKeys[kind] {
+		fields[i] = fmt.Sprintf("%s: %s%s", prop, prefix, prop)
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// stringList reads a list of strings returned by any backend
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []any:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			switch s := item.(type) {
+			case string:
+				strs = append(strs, s)
+			case []byte:
+				strs = append(strs, string(s))
+			}
+		}
+		return strs
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingQuerier answers queries with a function of the query and its
+// parameters, and records both
+type recordingQuerier struct {
+	answer  func(query string, params map[string]any) [][]any
+	queries []string
+	params  []map[string]any
+}
+
+func (q *recordingQuerier) Query(ctx context.Context, query string, params map[string]any) ([]string, [][]any, error) {
+	q.queries = append(q.queries, TrimQuery(query))
+	q.params = append(q.params, params)
+	if q.answer == nil {
+		return nil, nil, nil
+	}
+	return nil, q.answer(TrimQuery(query), params), nil
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		kind  string
+		props map[string]any
+	}{
+		{"Package:internal/store", "Package", map[string]any{"path": "internal/store"}},
+		{"File:main.go", "File", map[string]any{"path": "main.go"}},
+		{"Function:main.go:run", "Function", map[string]any{"file": "main.go", "name": "run", "receiver": ""}},
+		{"Function:store/store.go:*Store.Put", "Method", map[string]any{"file": "store/store.go", "name": "Put", "receiver": "*Store"}},
+		{"Struct:store/store.go:Store", "Struct", map[string]any{"file": "store/store.go", "name": "Store"}},
+	}
+	for _, tt := range tests {
+		kind, props, err := ParseKey(tt.key)
+		if err != nil || kind != tt.kind || !reflect.DeepEqual(props, tt.props) {
+			t.Errorf("ParseKey(%q) = %s, %v, %v, want %s, %v", tt.key, kind, props, err, tt.kind, tt.props)
+		}
+	}
+	for _, key := range []string{"", "Function:main.go", "Memory:x"} {
+		if _, _, err := ParseKey(key); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", key)
+		}
+	}
+}
+
+func TestCypherRemember(t *testing.T) {
+	// Only main.go's run function exists
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasSuffix(query, "RETURN count(n) AS count") {
+			if params["name"] == "run" || params["path"] == "main.go" {
+				return [][]any{{int64(1)}}
+			}
+			return [][]any{{int64(0)}}
+		}
+		return nil
+	}}
+	labels := LabelMap{Prefix: "CG_"}
+	memory, err := cypherRemember(context.Background(), q, labels, "App", Memory{
+		Text:  "run retries forever when the store is down",
+		Tags:  []string{"bug", ""},
+		About: []string{"Function:main.go:run", "File:main.go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(memory.ID, "mem-") || memory.CreatedAt.IsZero() || !reflect.DeepEqual(memory.Tags, []string{"bug"}) ||
+		memory.Importance != DefaultImportance || memory.Confidence != DefaultConfidence {
+		t.Errorf("memory = %+v", memory)
+	}
+
+	var created, links []string
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "CREATE"):
+			created = append(created, query)
+			if q.params[i]["id"] != memory.ID || !reflect.DeepEqual(q.params[i]["about"], memory.About) {
+				t.Errorf("created with %v", q.params[i])
+			}
+		case strings.HasPrefix(query, "UNWIND"):
+			links = append(links, query)
+		}
+	}
+	if len(created) != 1 || !strings.HasPrefix(created[0], "CREATE (:App:CG_Memory {") {
+		t.Errorf("created = %q", created)
+	}
+	want := []string{
+		"UNWIND $rows AS row\nMATCH (m:App:CG_Memory {id: row.id})\nMATCH (n:App:CG_File {path: row.path})\nMERGE (m)-[:ABOUT]->(n)",
+		"UNWIND $rows AS row\nMATCH (m:App:CG_Memory {id: row.id})\nMATCH (n:App:CG_Function {file: row.file, name: row.name, receiver: row.receiver})\nMERGE (m)-[:ABOUT]->(n)",
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("links = %q, want %q", links, want)
+	}
+
+	_, err = cypherRemember(context.Background(), q, labels, "App", Memory{Text: "gone", About: []string{"Function:old.go:start"}})
+	if !errors.Is(err, ErrNoNode) {
+		t.Errorf("err = %v, want ErrNoNode", err)
+	}
+	for _, invalid := range []Memory{{Text: " ", About: []string{"File:main.go"}}, {Text: "x"}, {Text: "x", About: []string{"main.go"}},
+		{Text: "x", About: []string{"File:main.go"}, Importance: 2}, {Text: "x", About: []string{"File:main.go"}, Confidence: -1},
+		{Text: "x", About: []string{"File:main.go"}, Namespace: "*"}, {Text: "x", About: []string{"File:main.go"}, Namespace: "a,b"}} {
+		if _, err := cypherRemember(context.Background(), q, labels, "App", invalid); !errors.Is(err, ErrInvalidMemory) {
+			t.Errorf("%+v: err = %v, want ErrInvalidMemory", invalid, err)
+		}
+	}
+}
+
+func TestCypherRememberSources(t *testing.T) {
+	q := &recordingQuerier{}
+	// The keys of a consolidated memory are not checked, so one whose node
+	// has gone is kept
+	memory, err := cypherRemember(context.Background(), q, LabelMap{}, "App", Memory{
+		Text: "Put holds the lock", About: []string{"Function:old.go:put"}, Sources: []string{"mem-1", "mem-2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put is looked up as a mention before the memory is created
+	i := slices.IndexFunc(q.queries, func(query string) bool { return strings.HasPrefix(query, "CREATE") })
+	if i < 0 || i+1 >= len(q.queries) ||
+		q.queries[i+1] != "MATCH (m:App:Memory {id: $id})\nMATCH (s:App:Memory) WHERE s.id IN $sources\nMERGE (m)-[:CONSOLIDATES]->(s)" {
+		t.Fatalf("queries = %q", q.queries)
+	}
+	if !reflect.DeepEqual(q.params[i]["sources"], memory.Sources) || !reflect.DeepEqual(q.params[i+1]["sources"], []string{"mem-1", "mem-2"}) {
+		t.Errorf("created with %v, linked with %v", q.params[i], q.params[i+1])
+	}
+}
+
+func TestCypherRecall(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	expires := created.AddDate(1, 0, 0)
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if !strings.HasPrefix(query, "MATCH (m:App:Memory)\nWHERE ($id") {
+			return nil
+		}
+		return [][]any{
+			{"mem-2", "flaky under load", []any{"test"}, []any{"File:main.go"}, "ses-1", created.Format(time.RFC3339Nano), expires, nil, int64(2), nil, nil, nil, nil, nil,
+				"abc123", "main", int64(42), nil, []any{"Struct:store.go:Store"}, "mcp", "claude-code", nil, "alice"},
+			{"mem-1", "owned by storage", []any{}, []any{"Package:store"}, nil, created, nil, created, nil, false, []any{"mem-0"}, 0.9, 0.6, true, nil, nil, nil,
+				"team:storage", nil, nil, nil, nil, nil},
+		}
+	}}
+	memories, err := cypherRecall(context.Background(), q, LabelMap{}, "App", MemoryQuery{About: "File:main.go", Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memories) != 2 || memories[0].AccessedAt.IsZero() || !memories[0].AccessedAt.Equal(memories[1].AccessedAt) {
+		t.Fatalf("memories = %+v, want both accessed now", memories)
+	}
+	for i := range memories {
+		memories[i].AccessedAt = time.Time{}
+	}
+	want := []Memory{
+		{ID: "mem-2", Text: "flaky under load", Tags: []string{"test"}, About: []string{"File:main.go"}, Session: "ses-1", CreatedAt: created, ExpiresAt: expires, Accesses: 3,
+			Importance: DefaultImportance, Confidence: DefaultConfidence, Mentions: []string{"Struct:store.go:Store"}, GitState: GitState{Commit: "abc123", Branch: "main", PR: 42},
+			Provenance: Provenance{Via: "mcp", Agent: "claude-code", User: "alice"}},
+		{ID: "mem-1", Text: "owned by storage", Tags: []string{}, About: []string{"Package:store"}, CreatedAt: created, Accesses: 1, Sources: []string{"mem-0"},
+			Importance: 0.9, Confidence: 0.6, Pinned: true, Namespace: "team:storage"},
+	}
+	if !reflect.DeepEqual(memories, want) {
+		t.Errorf("memories = %+v, want %+v", memories, want)
+	}
+	if params := q.params[0]; params["about"] != "File:main.go" || !reflect.DeepEqual(params["tags"], []string{}) || params["all"] != false ||
+		params["anyNamespace"] != true || params["via"] != "" || !strings.HasSuffix(q.queries[0], "LIMIT 5") {
+		t.Errorf("query %q with %v", q.queries[0], params)
+	}
+	if len(q.queries) != 2 || !strings.HasSuffix(q.queries[1], "SET m.accessedAt = $now, m.accesses = coalesce(m.accesses, 0) + 1") ||
+		!reflect.DeepEqual(q.params[1]["ids"], []string{"mem-2", "mem-1"}) {
+		t.Errorf("access recorded by %q with %v", q.queries[1:], q.params[1:])
+	}
+
+	q.queries, q.params = nil, nil
+	if _, err := cypherRecall(context.Background(), q, LabelMap{}, "App", MemoryQuery{All: true, Peek: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.queries) != 1 || q.params[0]["all"] != true {
+		t.Errorf("peeking queried %q with %v", q.queries, q.params)
+	}
+
+	// Tags and namespaces are passed as lists, an empty list of namespaces
+	// keeping to shared memories
+	q.queries, q.params = nil, nil
+	if _, err := cypherRecall(context.Background(), q, LabelMap{}, "App", MemoryQuery{
+		Tags: []string{"perf", "db"}, ExcludeTags: []string{"activity"}, Namespaces: []string{}, Peek: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if params := q.params[0]; !reflect.DeepEqual(params["tags"], []string{"perf", "db"}) || !reflect.DeepEqual(params["excludeTags"], []string{"activity"}) ||
+		params["anyNamespace"] != false || !reflect.DeepEqual(params["namespaces"], []string{}) {
+		t.Errorf("queried with %v", params)
+	}
+
+	// Ranked, the pinned memory comes first, and the limit applies after
+	// ranking
+	q.queries, q.params = nil, nil
+	memories, err = cypherRecall(context.Background(), q, LabelMap{}, "App", MemoryQuery{Limit: 1, Ranking: &DefaultRanking})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memories) != 1 || memories[0].ID != "mem-1" || strings.Contains(q.queries[0], "LIMIT") ||
+		!reflect.DeepEqual(q.params[1]["ids"], []string{"mem-1"}) {
+		t.Errorf("ranked %+v by %q with %v", memories, q.queries, q.params)
+	}
+
+	// A question keeps the memories sharing its words, also limited after
+	q.queries, q.params = nil, nil
+	memories, err = cypherRecall(context.Background(), q, LabelMap{}, "App", MemoryQuery{File: "main.go", Question: "who owns storage?", Limit: 5, Peek: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memories) != 1 || memories[0].ID != "mem-1" || strings.Contains(q.queries[0], "LIMIT") || q.params[0]["file"] != "main.go" {
+		t.Errorf("matched %+v by %q with %v", memories, q.queries, q.params)
+	}
+}
+
+func TestCorrectMemory(t *testing.T) {
+	store := &fakeMemoryStore{memories: []Memory{
+		{ID: "mem-1", Text: "Put holds the lock across fsync", Tags: []string{"perf"}, About: []string{"Function:store.go:*Store.Put"}, Session: "ses-1", Importance: 0.9, Confidence: 0.5,
+			Namespace: "team:storage"},
+		{ID: "mem-2", Text: "main exits 2 on bad flags", About: []string{"File:main.go"}},
+	}}
+	state := GitState{Commit: "abc123", Branch: "main"}
+	by := Provenance{Via: ViaMCP, Agent: "claude-code"}
+	correction, err := CorrectMemory(context.Background(), store, "App", "mem-1", "Put releases the lock before fsync", state, by)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Memory{
+		Text: "Put releases the lock before fsync", Tags: []string{"perf"}, About: []string{"Function:store.go:*Store.Put"},
+		Sources: []string{"mem-1"}, Importance: 0.9, Confidence: 0.5, Namespace: "team:storage", GitState: state, Provenance: by,
+	}
+	if !reflect.DeepEqual(correction, want) {
+		t.Errorf("correction = %+v, want %+v", correction, want)
+	}
+	if len(store.memories) != 3 || !store.memories[0].Archived || store.memories[1].Archived {
+		t.Errorf("memories = %+v, want mem-1 archived", store.memories)
+	}
+	if q := store.queries[0]; q.ID != "mem-1" || !q.Peek || q.All {
+		t.Errorf("recalled with %+v", q)
+	}
+	if _, err := CorrectMemory(context.Background(), store, "App", "mem-9", "x", state, by); !errors.Is(err, ErrNoMemory) {
+		t.Errorf("correcting mem-9: err = %v, want ErrNoMemory", err)
+	}
+}
+
+func TestCypherPinMemory(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if params["id"] == "mem-1" {
+			return [][]any{{int64(1)}}
+		}
+		return [][]any{{int64(0)}}
+	}}
+	if found, err := cypherPinMemory(context.Background(), q, LabelMap{}, "App", "mem-1", true); !found || err != nil {
+		t.Errorf("pinning mem-1: %v, %v", found, err)
+	}
+	if q.queries[0] != "MATCH (m:App:Memory {id: $id})\nSET m.pinned = $pinned\nRETURN count(m) AS count" || q.params[0]["pinned"] != true {
+		t.Errorf("pinned with %q and %v", q.queries[0], q.params[0])
+	}
+	if found, err := cypherPinMemory(context.Background(), q, LabelMap{}, "App", "mem-9", false); found || err != nil {
+		t.Errorf("unpinning mem-9: %v, %v", found, err)
+	}
+}
+
+func TestCypherArchiveMemories(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		return [][]any{{int64(len(params["ids"].([]string)))}}
+	}}
+	n, err := cypherArchiveMemories(context.Background(), q, LabelMap{}, "App", []string{"mem-1", "mem-2"})
+	if err != nil || n != 2 {
+		t.Errorf("archived %d, %v, want 2", n, err)
+	}
+	if !strings.Contains(q.queries[0], "SET m.archived = true") {
+		t.Errorf("archived with %q", q.queries[0])
+	}
+	if n, err := cypherArchiveMemories(context.Background(), q, LabelMap{}, "App", nil); n != 0 || err != nil || len(q.queries) != 1 {
+		t.Errorf("archiving nothing: %d, %v after %q", n, err, q.queries)
+	}
+}
+
+func TestCypherRelink(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		switch {
+		case strings.HasPrefix(query, "MATCH (n:App:Memory)"):
+			return [][]any{{"mem-1", "Function:store/store.go:*Store.Put"}, {"mem-1", "Package:store"}, {"mem-2", "bad"}}
+		case strings.HasPrefix(query, "MATCH (n:App:Session)"):
+			return [][]any{{"ses-1", "main.go"}}
+		}
+		return nil
+	}}
+	if err := cypherRelink(context.Background(), q, LabelMap{}, "App"); err != nil {
+		t.Fatal(err)
+	}
+	// Memories read and linked to packages and methods, decisions and
+	// summaries read, sessions read and linked to files, conventions,
+	// findings and cycles read, memories read again and linked to what
+	// they mention, links read
+	if len(q.queries) != 14 {
+		t.Fatalf("queries = %q", q.queries)
+	}
+	if !strings.Contains(q.queries[1], "MATCH (n:App:Package {path: row.path})") {
+		t.Errorf("packages linked by %q", q.queries[1])
+	}
+	want := []any{map[string]any{"id": "mem-1", "file": "store/store.go", "name": "Put", "receiver": "*Store"}}
+	if !strings.Contains(q.queries[2], "MATCH (n:App:Method") || !reflect.DeepEqual(q.params[2]["rows"], want) {
+		t.Errorf("methods linked by %q with %v", q.queries[2], q.params[2])
+	}
+	want = []any{map[string]any{"id": "ses-1", "path": "main.go"}}
+	if !strings.Contains(q.queries[6], "MATCH (m:App:Session {id: row.id})\nMATCH (n:App:File {path: row.path})\nMERGE (m)-[:TOUCHED]->(n)") ||
+		!reflect.DeepEqual(q.params[6]["rows"], want) {
+		t.Errorf("touched files linked by %q with %v", q.queries[6], q.params[6])
+	}
+}
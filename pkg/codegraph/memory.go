@@ -0,0 +1,194 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// MemoryWriter holds the graph in-process with adjacency indexes, so a
+// few structural queries can be answered without any database
+type MemoryWriter struct {
+	nodes map[string]GraphNode
+	order []string
+	out   map[string][]Relationship
+	in    map[string][]Relationship
+}
+
+func (b *MemoryWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	b.nodes = make(map[string]GraphNode)
+	b.out = make(map[string][]Relationship)
+	b.in = make(map[string][]Relationship)
+	b.order = nil
+
+	counts := make(map[string]int)
+	for _, node := range graph.Nodes() {
+		if _, ok := b.nodes[node.Key]; !ok {
+			b.order = append(b.order, node.Key)
+			counts[node.Label]++
+		}
+		b.nodes[node.Key] = node
+	}
+	for _, rel := range graph.Relationships() {
+		b.out[rel.From] = append(b.out[rel.From], rel)
+		b.in[rel.To] = append(b.in[rel.To], rel)
+	}
+
+	labels := make([]string, 0, len(counts))
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		slog.Info("graph summary", "label", label, "count", counts[label])
+	}
+	return nil
+}
+
+func (b *MemoryWriter) Close(ctx context.Context) error {
+	return nil
+}
+
+// find resolves a user-supplied symbol to node keys. It accepts a node key,
+// a file or package path, a type or function name, or Type.Method.
+func (b *MemoryWriter) find(symbol string) []string {
+	if _, ok := b.nodes[symbol]; ok {
+		return []string{symbol}
+	}
+
+	var keys []string
+	for _, key := range b.order {
+		node := b.nodes[key]
+		name := PropString(node.Props, "name")
+		if receiver := PropString(node.Props, "receiver"); receiver != "" {
+			if strings.TrimPrefix(receiver, "*")+"."+name == symbol {
+				keys = append(keys, key)
+			}
+			continue
+		}
+		if name == symbol || (name == "" && PropString(node.Props, "path") == symbol) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// QueryResult is one node reached by a query, with the traversal depth at
+// which it was found
+type QueryResult struct {
+	Key   string
+	Depth int
+}
+
+// Traverse runs one of the built-in queries (callers, callees, implementers
+// or impact) for the nodes matching symbol
+func (b *MemoryWriter) Traverse(kind, symbol string, depth int) ([]QueryResult, error) {
+	seeds := b.find(symbol)
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("no node matches %q", symbol)
+	}
+
+	var results []QueryResult
+	collect := func(rels []Relationship, relType string, outgoing bool) {
+		for _, rel := range rels {
+			if rel.Type != relType {
+				continue
+			}
+			if outgoing {
+				results = append(results, QueryResult{Key: rel.To, Depth: 1})
+			} else {
+				results = append(results, QueryResult{Key: rel.From, Depth: 1})
+			}
+		}
+	}
+
+	switch kind {
+	case "callers":
+		for _, seed := range seeds {
+			collect(b.in[seed], "CALLS", false)
+		}
+	case "callees":
+		for _, seed := range seeds {
+			collect(b.out[seed], "CALLS", true)
+		}
+	case "implementers":
+		// Structs implementing an interface, or interfaces implemented by a struct
+		for _, seed := range seeds {
+			collect(b.in[seed], "IMPLEMENTS", false)
+			collect(b.out[seed], "IMPLEMENTS", true)
+		}
+	case "impact":
+		results = b.impact(seeds, depth)
+	default:
+		return nil, fmt.Errorf("unknown query %q (want callers, callees, implementers or impact)", kind)
+	}
+
+	return dedupeResults(results), nil
+}
+
+// impact walks reverse dependencies (callers and implementers) from the
+// seeds up to depth, then adds the files containing every affected symbol.
+// A file seed stands for the symbols it contains.
+func (b *MemoryWriter) impact(seeds []string, depth int) []QueryResult {
+	visited := make(map[string]bool)
+	var frontier []string
+	for _, seed := range seeds {
+		visited[seed] = true
+		frontier = append(frontier, seed)
+		for _, rel := range b.out[seed] {
+			if rel.Type == "CONTAINS" && !visited[rel.To] {
+				visited[rel.To] = true
+				frontier = append(frontier, rel.To)
+			}
+		}
+	}
+
+	var results []QueryResult
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, key := range frontier {
+			for _, rel := range b.in[key] {
+				if (rel.Type == "CALLS" || rel.Type == "IMPLEMENTS") && !visited[rel.From] {
+					visited[rel.From] = true
+					next = append(next, rel.From)
+					results = append(results, QueryResult{Key: rel.From, Depth: d})
+				}
+			}
+		}
+		frontier = next
+	}
+
+	affected := results
+	for _, result := range affected {
+		for _, rel := range b.in[result.Key] {
+			if rel.Type == "CONTAINS" {
+				results = append(results, QueryResult{Key: rel.From, Depth: result.Depth})
+			}
+		}
+	}
+	return results
+}
+
+// dedupeResults keeps the shallowest occurrence of each node, ordered by
+// depth and key
+func dedupeResults(results []QueryResult) []QueryResult {
+	best := make(map[string]int)
+	for _, result := range results {
+		if d, ok := best[result.Key]; !ok || result.Depth < d {
+			best[result.Key] = result.Depth
+		}
+	}
+	deduped := make([]QueryResult, 0, len(best))
+	for key, depth := range best {
+		deduped = append(deduped, QueryResult{Key: key, Depth: depth})
+	}
+	sort.Slice(deduped, func(i, j int) bool {
+		if deduped[i].Depth != deduped[j].Depth {
+			return deduped[i].Depth < deduped[j].Depth
+		}
+		return deduped[i].Key < deduped[j].Key
+	})
+	return deduped
+}
@@ -0,0 +1,60 @@
+package codegraph
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMemoryWriterTraverse(t *testing.T) {
+	b := &MemoryWriter{}
+	if err := b.Write(context.Background(), "App", parseTestTree(t, Filter{}), NewRunInfo()); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(results []QueryResult) []string {
+		var keys []string
+		for _, r := range results {
+			keys = append(keys, r.Key)
+		}
+		return keys
+	}
+
+	tests := []struct {
+		kind, symbol string
+		depth        int
+		want         []string
+	}{
+		{"callers", "Store.Put", 1, []string{"Function:main.go:main"}},
+		{"callees", "Store.Put", 1, []string{"Function:store/store.go:*Store.flush"}},
+		{"implementers", "Putter", 1, []string{"Struct:store/store.go:Store"}},
+		{"implementers", "Store", 1, []string{"Interface:store/store.go:Putter"}},
+		{"impact", "Store.flush", 2, []string{
+			"File:store/store.go",
+			"Function:store/store.go:*Store.Put",
+			"File:main.go",
+			"Function:main.go:main",
+		}},
+		{"impact", "Store.flush", 1, []string{
+			"File:store/store.go",
+			"Function:store/store.go:*Store.Put",
+		}},
+	}
+	for _, tt := range tests {
+		results, err := b.Traverse(tt.kind, tt.symbol, tt.depth)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.kind, tt.symbol, err)
+			continue
+		}
+		if got := keys(results); !slices.Equal(got, tt.want) {
+			t.Errorf("%s %s = %q, want %q", tt.kind, tt.symbol, got, tt.want)
+		}
+	}
+
+	if _, err := b.Traverse("callers", "Missing", 1); err == nil {
+		t.Error("unknown symbol accepted")
+	}
+	if _, err := b.Traverse("siblings", "Store.Put", 1); err == nil {
+		t.Error("unknown query accepted")
+	}
+}
@@ -0,0 +1,226 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// mentionedPath matches a Go file path, or a package path with at
+	// least one slash
+	mentionedPath = regexp.MustCompile(`(?:[\w.-]+/)*[\w-]+\.go\b|[\w.-]+(?:/[\w.-]+)+`)
+	// mentionedName matches an identifier, or a type and one of its
+	// methods, as in Store.Put
+	mentionedName = regexp.MustCompile(`\b[A-Za-z_]\w*(?:\.[A-Za-z_]\w*)?\b`)
+	// mentionedID matches the ID of a memory, decision or summary
+	mentionedID = regexp.MustCompile(`\b(?:mem|dec|sum)-\d{8}T\d{6}Z-[0-9a-f]{8}\b`)
+)
+
+// mentionLabels are the labels of the nodes whose IDs start with each
+// prefix
+var mentionLabels = map[string]string{"mem": "Memory", "dec": "Decision", "sum": "Summary"}
+
+// mentionCandidates are the strings of a text that may name nodes, in
+// order of first use
+type mentionCandidates struct {
+	files, packages, names, ids []string
+}
+
+// findMentions returns the file and package paths, code-like names and
+// IDs in text. Plain lowercase words are only taken for names when
+// followed by parentheses or quoted in backticks, so prose does not match
+// every function named like a word.
+func findMentions(text string) mentionCandidates {
+	var m mentionCandidates
+	add := func(list *[]string, s string) {
+		if !slices.Contains(*list, s) {
+			*list = append(*list, s)
+		}
+	}
+	for _, path := range mentionedPath.FindAllString(text, -1) {
+		path = strings.TrimRight(path, ".")
+		switch {
+		case strings.HasSuffix(path, ".go"):
+			add(&m.files, strings.TrimPrefix(path, "./"))
+		case strings.Contains(path, "/"):
+			add(&m.packages, strings.TrimPrefix(path, "./"))
+		}
+	}
+	for _, loc := range mentionedName.FindAllStringIndex(text, -1) {
+		name := text[loc[0]:loc[1]]
+		before, after := byte(' '), byte(' ')
+		if loc[0] > 0 {
+			before = text[loc[0]-1]
+		}
+		if loc[1] < len(text) {
+			after = text[loc[1]]
+		}
+		if before == '/' || after == '/' || before == '-' || after == '-' || strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if strings.ContainsAny(name, "._ABCDEFGHIJKLMNOPQRSTUVWXYZ") || after == '(' || before == '`' && after == '`' {
+			add(&m.names, name)
+		}
+	}
+	for _, id := range mentionedID.FindAllString(text, -1) {
+		add(&m.ids, id)
+	}
+	return m
+}
+
+// mentionLabel returns the label of the node with the ID mention, or ""
+// if mention is a key
+func mentionLabel(mention string) string {
+	if mentionedID.FindString(mention) != mention {
+		return ""
+	}
+	return mentionLabels[mention[:3]]
+}
+
+// cypherMentions returns the keys of the live files, packages and symbols
+// text names, and the IDs of the memories, decisions and summaries it
+// cites, in that order. A name or path matching several nodes, such as
+// Put when two types have one, is left out as ambiguous.
+func cypherMentions(ctx context.Context, q Querier, labels LabelMap, project, text string) ([]string, error) {
+	m := findMentions(text)
+	matches := make(map[string][]string)
+	match := func(candidate, key string) {
+		if !slices.Contains(matches[candidate], key) {
+			matches[candidate] = append(matches[candidate], key)
+		}
+	}
+	if len(m.files) > 0 {
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (f:%s:%s) WHERE NOT coalesce(f.deleted, false)
+			  AND any(p IN $paths WHERE f.path = p OR f.path ENDS WITH '/' + p)
+			RETURN f.path AS path
+		`, project, labels.Label("File")), map[string]any{"paths": m.files})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if len(row) == 0 {
+				continue
+			}
+			path := fmt.Sprint(row[0])
+			for _, file := range m.files {
+				if path == file || strings.HasSuffix(path, "/"+file) {
+					match("file "+file, "File:"+path)
+				}
+			}
+		}
+	}
+	if len(m.packages) > 0 {
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (p:%s:%s) WHERE NOT coalesce(p.deleted, false) AND p.path IN $paths
+			RETURN p.path AS path
+		`, project, labels.Label("Package")), map[string]any{"paths": m.packages})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if len(row) > 0 {
+				match("package "+fmt.Sprint(row[0]), "Package:"+fmt.Sprint(row[0]))
+			}
+		}
+	}
+	if len(m.names) > 0 {
+		for _, kind := range []string{"Function", "Method", "Struct", "Interface"} {
+			where, receiver := "n.name IN $names", "''"
+			if kind == "Method" {
+				where, receiver = "(n.name IN $names OR replace(n.receiver, '*', '') + '.' + n.name IN $names)", "n.receiver"
+			}
+			_, rows, err := q.Query(ctx, fmt.Sprintf(`
+				MATCH (n:%s:%s) WHERE NOT coalesce(n.deleted, false) AND %s
+				RETURN n.file AS file, n.name AS name, %s AS receiver
+			`, project, labels.Label(kind), where, receiver), map[string]any{"names": m.names})
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				if len(row) < 3 {
+					continue
+				}
+				file, name, receiver := fmt.Sprint(row[0]), fmt.Sprint(row[1]), ""
+				if r, ok := row[2].(string); ok {
+					receiver = r
+				}
+				var key string
+				switch kind {
+				case "Function":
+					key = FunctionNode{File: file, Name: name}.Key()
+				case "Method":
+					key = FunctionNode{File: file, Name: name, Receiver: receiver}.Key()
+				default:
+					key = kind + ":" + file + ":" + name
+				}
+				for _, candidate := range m.names {
+					if candidate == name || receiver != "" && candidate == strings.TrimPrefix(receiver, "*")+"."+name {
+						match("name "+candidate, key)
+					}
+				}
+			}
+		}
+	}
+	ids := make(map[string][]string)
+	for _, id := range m.ids {
+		ids[mentionLabel(id)] = append(ids[mentionLabel(id)], id)
+	}
+	for _, label := range slices.Sorted(maps.Keys(ids)) {
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (n:%s:%s) WHERE n.id IN $ids
+			RETURN n.id AS id
+		`, project, labels.Label(label)), map[string]any{"ids": ids[label]})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if len(row) > 0 {
+				match("id "+fmt.Sprint(row[0]), fmt.Sprint(row[0]))
+			}
+		}
+	}
+
+	found := []string{}
+	for _, candidates := range []struct {
+		prefix string
+		list   []string
+	}{{"file ", m.files}, {"package ", m.packages}, {"name ", m.names}, {"id ", m.ids}} {
+		for _, candidate := range candidates.list {
+			if keys := matches[candidates.prefix+candidate]; len(keys) == 1 && !slices.Contains(found, keys[0]) {
+				found = append(found, keys[0])
+			}
+		}
+	}
+	return found, nil
+}
+
+// linkMentions merges a MENTIONS relationship from the memory with the
+// given ID to each node it mentions: code nodes by key, and memories,
+// decisions and summaries by ID
+func linkMentions(ctx context.Context, q Querier, labels LabelMap, project, id string, mentions []string) error {
+	var links []nodeLink
+	ids := make(map[string][]string)
+	for _, mention := range mentions {
+		if label := mentionLabel(mention); label != "" {
+			ids[label] = append(ids[label], mention)
+		} else {
+			links = append(links, nodeLink{id, mention})
+		}
+	}
+	for _, label := range slices.Sorted(maps.Keys(ids)) {
+		_, _, err := q.Query(ctx, fmt.Sprintf(`
+			MATCH (m:%s:%s {id: $id})
+			MATCH (n:%s:%s) WHERE n.id IN $ids
+			MERGE (m)-[:MENTIONS]->(n)
+		`, project, labels.Label("Memory"), project, labels.Label(label)), map[string]any{"id": id, "ids": ids[label]})
+		if err != nil {
+			return fmt.Errorf("linking mentions of %s: %w", label, err)
+		}
+	}
+	return linkNodes(ctx, q, labels, project, "Memory", "MENTIONS", links)
+}
@@ -0,0 +1,105 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindMentions(t *testing.T) {
+	got := findMentions("Store.Put in pkg/store/store.go calls `flush` and retry(), unlike Put(); see dec-20260304T050607Z-0a1b2c3d and pkg/cache.")
+	want := mentionCandidates{
+		files:    []string{"pkg/store/store.go"},
+		packages: []string{"pkg/cache"},
+		names:    []string{"Store.Put", "flush", "retry", "Put"},
+		ids:      []string{"dec-20260304T050607Z-0a1b2c3d"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findMentions = %+v, want %+v", got, want)
+	}
+	if got := findMentions("the lock is held across the flush"); !reflect.DeepEqual(got, mentionCandidates{}) {
+		t.Errorf("prose mentions %+v", got)
+	}
+}
+
+func TestCypherMentions(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		switch {
+		case strings.HasPrefix(query, "MATCH (f:App:File)"):
+			return [][]any{{"pkg/store/store.go"}}
+		case strings.HasPrefix(query, "MATCH (p:App:Package)"):
+			return [][]any{{"pkg/cache"}}
+		case strings.HasPrefix(query, "MATCH (n:App:Function)"):
+			return [][]any{{"pkg/store/store.go", "retry", ""}}
+		case strings.HasPrefix(query, "MATCH (n:App:Method)"):
+			return [][]any{{"pkg/store/store.go", "Put", "*Store"}, {"pkg/cache/cache.go", "Put", "*Cache"}}
+		case strings.HasPrefix(query, "MATCH (n:App:Decision)"):
+			return [][]any{{"dec-20260304T050607Z-0a1b2c3d"}}
+		}
+		return nil
+	}}
+	mentions, err := cypherMentions(context.Background(), q, LabelMap{}, "App",
+		"Store.Put in store.go calls `flush` and retry(), unlike Put(); see dec-20260304T050607Z-0a1b2c3d and pkg/cache.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put names both methods, so is left out
+	want := []string{
+		"File:pkg/store/store.go", "Package:pkg/cache", "Function:pkg/store/store.go:*Store.Put",
+		"Function:pkg/store/store.go:retry", "dec-20260304T050607Z-0a1b2c3d",
+	}
+	if !reflect.DeepEqual(mentions, want) {
+		t.Errorf("mentions = %q, want %q", mentions, want)
+	}
+	if len(q.queries) != 7 || !reflect.DeepEqual(q.params[0]["paths"], []string{"store.go"}) {
+		t.Errorf("queries %q with %v", q.queries, q.params)
+	}
+
+	q.queries, q.params = nil, nil
+	if mentions, err := cypherMentions(context.Background(), q, LabelMap{}, "App", "the lock is held"); err != nil || len(mentions) != 0 || len(q.queries) != 0 {
+		t.Errorf("prose: mentions %q, %v after %q", mentions, err, q.queries)
+	}
+}
+
+func TestCypherRememberMentions(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		switch {
+		case strings.HasSuffix(query, "AS count"):
+			return [][]any{{int64(1)}}
+		case strings.HasPrefix(query, "MATCH (n:App:Method)"):
+			return [][]any{{"store.go", "Put", "*Store"}}
+		case strings.HasPrefix(query, "MATCH (n:App:Struct)"):
+			return [][]any{{"store.go", "Store", ""}}
+		case strings.HasPrefix(query, "MATCH (n:App:Memory) WHERE n.id IN $ids"):
+			return [][]any{{"mem-20260304T050607Z-0a1b2c3d"}}
+		}
+		return nil
+	}}
+	memory, err := cypherRemember(context.Background(), q, LabelMap{}, "App", Memory{
+		Text:  "Store.Put holds the Store lock, as mem-20260304T050607Z-0a1b2c3d found",
+		About: []string{"Function:store.go:*Store.Put"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The memory is about Store.Put already, so does not mention it
+	want := []string{"Struct:store.go:Store", "mem-20260304T050607Z-0a1b2c3d"}
+	if !reflect.DeepEqual(memory.Mentions, want) {
+		t.Errorf("mentions = %q, want %q", memory.Mentions, want)
+	}
+	var created, structs, memories bool
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "CREATE"):
+			created = reflect.DeepEqual(q.params[i]["mentions"], want)
+		case strings.HasSuffix(query, "MATCH (n:App:Struct {file: row.file, name: row.name})\nMERGE (m)-[:MENTIONS]->(n)"):
+			structs = true
+		case strings.HasSuffix(query, "MATCH (n:App:Memory) WHERE n.id IN $ids\nMERGE (m)-[:MENTIONS]->(n)"):
+			memories = reflect.DeepEqual(q.params[i]["ids"], want[1:])
+		}
+	}
+	if !created || !structs || !memories {
+		t.Errorf("created %v, linked structs %v and memories %v in %q", created, structs, memories, q.queries)
+	}
+}
@@ -0,0 +1,134 @@
+package codegraph
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ModuleNode is a Go module of the project, from a go.mod: its module
+// path, the directory of the go.mod relative to the root parsed, and the
+// Go version it declares. A go.mod at or above the root is the module of
+// Dir ".", whose ImportPath is that of the root, below the module's own
+// Path if the root is a subdirectory of it; otherwise ImportPath is Path.
+type ModuleNode struct {
+	Path       string `json:"path"`
+	Dir        string `json:"dir"`
+	ImportPath string `json:"importPath"`
+	GoVersion  string `json:"goVersion,omitempty"`
+}
+
+// Key returns the stable identity of the module node
+func (m ModuleNode) Key() string {
+	return "Module:" + m.Path
+}
+
+// readGoMod returns the module path and Go version a go.mod declares
+func readGoMod(file string) (module, goVersion string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "module "); ok {
+			module = strings.Trim(strings.TrimSpace(value), `"`)
+		} else if value, ok := strings.CutPrefix(line, "go "); ok {
+			goVersion = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if module == "" {
+		return "", "", fmt.Errorf("%s has no module line", file)
+	}
+	return module, goVersion, nil
+}
+
+// findModules returns the modules of the go.mod files below root in the
+// directories filter walks, in walk order, after the module of a go.mod
+// above root if root has none of its own
+func findModules(root string, filter Filter) ([]ModuleNode, error) {
+	var modules []ModuleNode
+	err := filter.Walk(root, root, func(file, relPath string, info fs.FileInfo) error {
+		if info.IsDir() || info.Name() != "go.mod" {
+			return nil
+		}
+		module, goVersion, err := readGoMod(file)
+		if err != nil {
+			return err
+		}
+		modules = append(modules, ModuleNode{Path: module, Dir: filepath.Dir(relPath), ImportPath: module, GoVersion: goVersion})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) > 0 && modules[0].Dir == "." {
+		return modules, nil
+	}
+
+	file, below, err := findGoMod(root)
+	if err != nil {
+		// Only the modules below root, if any
+		return modules, nil
+	}
+	module, goVersion, err := readGoMod(file)
+	if err != nil {
+		return nil, err
+	}
+	above := ModuleNode{Path: module, Dir: ".", ImportPath: path.Join(append([]string{module}, below...)...), GoVersion: goVersion}
+	return append([]ModuleNode{above}, modules...), nil
+}
+
+// packageModule returns the module of g holding the package at dir, the
+// one whose directory is the nearest above it
+func (g *Graph) packageModule(dir string) (ModuleNode, bool) {
+	var found ModuleNode
+	depth := -1
+	for _, m := range g.Modules {
+		rel, err := filepath.Rel(m.Dir, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if d := moduleDepth(m.Dir); d > depth {
+			found, depth = m, d
+		}
+	}
+	return found, depth >= 0
+}
+
+// moduleDepth is how many directories below the root dir is
+func moduleDepth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(dir), "/") + 1
+}
+
+// setModules records modules as g's and scopes its packages by them,
+// setting the module and import path of each package in one
+func (g *Graph) setModules(modules []ModuleNode) {
+	g.Modules = modules
+	for i := range g.Packages {
+		g.scopePackage(&g.Packages[i])
+	}
+}
+
+// scopePackage sets the module and import path of pkg from the module of
+// g holding it, if any
+func (g *Graph) scopePackage(pkg *PackageNode) {
+	m, ok := g.packageModule(pkg.Path)
+	if !ok {
+		return
+	}
+	rel, _ := filepath.Rel(m.Dir, pkg.Path)
+	pkg.Module, pkg.ImportPath = m.Path, path.Join(m.ImportPath, filepath.ToSlash(rel))
+}
@@ -0,0 +1,91 @@
+package codegraph
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// monorepoTree has two modules with a store package each, one importing
+// the other's
+var monorepoTree = map[string]string{
+	"libs/go.mod":         "module example.com/lib\n\ngo 1.21\n",
+	"libs/store/store.go": "package store\n\nfunc Open() {}\n",
+	"api/go.mod":          "module example.com/api\n\ngo 1.22\n\nrequire example.com/lib v0.0.0\n\nreplace example.com/lib => ../libs\n",
+	"api/store/store.go":  "package store\n\nfunc Open() {}\n",
+	"api/main.go": `package main
+
+import (
+	"example.com/lib/store"
+	local "example.com/api/store"
+)
+
+func main() {
+	store.Open()
+	local.Open()
+}
+`,
+	"tools/gen.go": "package tools\n",
+}
+
+func TestFindModules(t *testing.T) {
+	root := writeTree(t, monorepoTree)
+	modules, err := findModules(root, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ModuleNode{
+		{Path: "example.com/api", Dir: "api", ImportPath: "example.com/api", GoVersion: "1.22"},
+		{Path: "example.com/lib", Dir: "libs", ImportPath: "example.com/lib", GoVersion: "1.21"},
+	}
+	if !reflect.DeepEqual(modules, want) {
+		t.Errorf("modules = %+v, want %+v", modules, want)
+	}
+
+	// Below a module, root is in it
+	modules, err = findModules(filepath.Join(root, "libs", "store"), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []ModuleNode{{Path: "example.com/lib", Dir: ".", ImportPath: "example.com/lib/store", GoVersion: "1.21"}}
+	if !reflect.DeepEqual(modules, want) {
+		t.Errorf("modules above root = %+v, want %+v", modules, want)
+	}
+}
+
+func TestParseModules(t *testing.T) {
+	root := writeTree(t, monorepoTree)
+	for _, syntaxOnly := range []bool{false, true} {
+		graph, err := Parser{Root: root, SyntaxOnly: syntaxOnly}.Parse(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packages []PackageNode
+		for _, pkg := range graph.Packages {
+			if pkg.Name == "store" || pkg.Name == "tools" {
+				packages = append(packages, pkg)
+			}
+		}
+		slices.SortFunc(packages, func(a, b PackageNode) int { return strings.Compare(a.Path, b.Path) })
+		want := []PackageNode{
+			{Name: "store", Path: "api/store", Module: "example.com/api", ImportPath: "example.com/api/store"},
+			{Name: "store", Path: "libs/store", Module: "example.com/lib", ImportPath: "example.com/lib/store"},
+			{Name: "tools", Path: "tools"},
+		}
+		if !reflect.DeepEqual(packages, want) {
+			t.Errorf("syntax-only %v: packages = %+v, want %+v", syntaxOnly, packages, want)
+		}
+
+		var calls []string
+		for _, rel := range graph.Calls() {
+			calls = append(calls, rel.To)
+		}
+		slices.Sort(calls)
+		if want := []string{"Function:api/store/store.go:Open", "Function:libs/store/store.go:Open"}; !slices.Equal(calls, want) {
+			t.Errorf("syntax-only %v: calls = %q, want %q", syntaxOnly, calls, want)
+		}
+	}
+}
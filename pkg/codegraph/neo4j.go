@@ -0,0 +1,522 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Neo4jWriter writes over Bolt to NornicDB or Neo4j
+type Neo4jWriter struct {
+	Driver     neo4j.DriverWithContext
+	Statements StatementOptions
+	Options    WriteOptions
+	// Redact rewrites the text of memories, decisions and summaries before
+	// they are stored
+	Redact []RedactRule
+	stats  WriteStats
+}
+
+func (b *Neo4jWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	if err := createGraph(ctx, b.Driver, project, graph, run, b.Statements, b.Options, &b.stats); err != nil {
+		return err
+	}
+	return b.relink(ctx, project, graph)
+}
+
+func (b *Neo4jWriter) WriteFiles(ctx context.Context, project string, graph *Graph, files []string, run RunInfo) error {
+	opts := b.Statements
+	opts.Files = files
+	if err := createGraph(ctx, b.Driver, project, graph, run, opts, b.Options, &b.stats); err != nil {
+		return err
+	}
+	return b.relink(ctx, project, graph)
+}
+
+// relink links memories, decisions and sessions again to the nodes a write
+// recreated, and indexes the embeddings it wrote
+func (b *Neo4jWriter) relink(ctx context.Context, project string, graph *Graph) error {
+	if err := cypherRelink(ctx, b, b.Statements.Labels, project); err != nil {
+		return fmt.Errorf("relinking memories, decisions, sessions and links: %w", err)
+	}
+	if dims := graph.embeddingDimensions(); dims > 0 {
+		if err := cypherVectorIndex(ctx, b, project, dims); err != nil {
+			return fmt.Errorf("creating vector index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b *Neo4jWriter) Stats() WriteStats {
+	return b.stats
+}
+
+func (b *Neo4jWriter) Validate(ctx context.Context, project string, graph *Graph) ([]string, error) {
+	session := b.Driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	check := graphCheck{Counts: make(map[string]int)}
+	result, err := session.Run(ctx, fmt.Sprintf(`
+		MATCH (n:%s) WHERE NOT coalesce(n.deleted, false)
+		UNWIND labels(n) AS label
+		RETURN label, count(*) AS count
+	`, project), nil)
+	if err != nil {
+		return nil, fmt.Errorf("counting nodes: %w", err)
+	}
+	for result.Next(ctx) {
+		record := result.Record().AsMap()
+		count, _ := record["count"].(int64)
+		if kind := b.Statements.Labels.Kind(PropString(record, "label")); kind != "" {
+			check.Counts[kind] = int(count)
+		}
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("counting nodes: %w", err)
+	}
+
+	orphans := []struct {
+		query string
+		count *int
+	}{
+		{`MATCH (fn:%[1]s) WHERE (fn:Function OR fn:Method) AND NOT coalesce(fn.deleted, false)
+		  OPTIONAL MATCH (fn)<-[r:CONTAINS]-(f:%[1]s:File) WHERE NOT coalesce(r.deleted, false)
+		  WITH fn, count(f) AS files WHERE files = 0
+		  RETURN count(fn) AS count`, &check.OrphanFunctions},
+		{`MATCH (f:%[1]s:File) WHERE NOT coalesce(f.deleted, false)
+		  OPTIONAL MATCH (f)-[r:BELONGS_TO]->(p:%[1]s:Package) WHERE NOT coalesce(r.deleted, false)
+		  WITH f, count(p) AS packages WHERE packages = 0
+		  RETURN count(f) AS count`, &check.OrphanFiles},
+	}
+	for _, orphan := range orphans {
+		result, err := session.Run(ctx, b.Statements.Labels.Rewrite(fmt.Sprintf(orphan.query, project)), nil)
+		if err != nil {
+			return nil, fmt.Errorf("finding orphans: %w", err)
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("finding orphans: %w", err)
+		}
+		count, _ := record.AsMap()["count"].(int64)
+		*orphan.count = int(count)
+	}
+
+	// Merged nodes collapse symbols that share a key
+	return check.violations(countLabels(graph.Nodes(), b.Statements.SoftDelete)), nil
+}
+
+func (b *Neo4jWriter) Query(ctx context.Context, query string, params map[string]any) (columns []string, rows [][]any, err error) {
+	ctx, span := startQuery(ctx, "neo4j", query)
+	defer func() { endSpan(span, err) }()
+
+	session := b.Driver.NewSession(ctx, neo4j.SessionConfig{})
+	defer session.Close(ctx)
+
+	if b.Options.StatementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Options.StatementTimeout)
+		defer cancel()
+	}
+	result, err := session.Run(ctx, query, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns, err = result.Keys()
+	if err != nil {
+		return nil, nil, err
+	}
+	for result.Next(ctx) {
+		rows = append(rows, result.Record().Values)
+	}
+	return columns, rows, result.Err()
+}
+
+func (b *Neo4jWriter) Projects(ctx context.Context) ([]string, error) {
+	return labelProjects(ctx, b, b.Statements.Labels)
+}
+
+func (b *Neo4jWriter) DeleteProject(ctx context.Context, project string) (int, error) {
+	return cypherDeleteProject(ctx, b, project)
+}
+
+func (b *Neo4jWriter) Snapshots(ctx context.Context, project string) ([]Snapshot, error) {
+	return cypherSnapshots(ctx, b, project)
+}
+
+func (b *Neo4jWriter) Prune(ctx context.Context, project string, before time.Time) (int, error) {
+	return cypherPrune(ctx, b, project, before)
+}
+
+func (b *Neo4jWriter) Remember(ctx context.Context, project string, memory Memory) (Memory, error) {
+	return cypherRemember(ctx, b, b.Statements.Labels, project, RedactMemory(b.Redact, memory))
+}
+
+func (b *Neo4jWriter) Recall(ctx context.Context, project string, q MemoryQuery) ([]Memory, error) {
+	return cypherRecall(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *Neo4jWriter) Forget(ctx context.Context, project, id string) (bool, error) {
+	return cypherForget(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *Neo4jWriter) PinMemory(ctx context.Context, project, id string, pinned bool) (bool, error) {
+	return cypherPinMemory(ctx, b, b.Statements.Labels, project, id, pinned)
+}
+
+func (b *Neo4jWriter) ArchiveMemories(ctx context.Context, project string, ids []string) (int, error) {
+	return cypherArchiveMemories(ctx, b, b.Statements.Labels, project, ids)
+}
+
+func (b *Neo4jWriter) ImportMemories(ctx context.Context, project string, memories []Memory) (int, error) {
+	return cypherImportMemories(ctx, b, b.Statements.Labels, project, redactMemories(b.Redact, memories))
+}
+
+func (b *Neo4jWriter) ImportDecisions(ctx context.Context, project string, decisions []Decision) (int, error) {
+	return cypherImportDecisions(ctx, b, b.Statements.Labels, project, redactDecisions(b.Redact, decisions))
+}
+
+func (b *Neo4jWriter) Decide(ctx context.Context, project string, decision Decision) (Decision, error) {
+	return cypherDecide(ctx, b, b.Statements.Labels, project, RedactDecision(b.Redact, decision))
+}
+
+func (b *Neo4jWriter) Decisions(ctx context.Context, project string, q DecisionQuery) ([]Decision, error) {
+	return cypherDecisions(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *Neo4jWriter) SetDecisionStatus(ctx context.Context, project, id, status string) (Decision, error) {
+	return cypherSetDecisionStatus(ctx, b, b.Statements.Labels, project, id, status)
+}
+
+func (b *Neo4jWriter) SimilarNodes(ctx context.Context, project string, vector []float64, limit int) ([]SimilarNode, error) {
+	return cypherSimilarNodes(ctx, b, b.Statements.Labels, project, vector, limit)
+}
+
+func (b *Neo4jWriter) Search(ctx context.Context, project string, q SearchQuery) ([]SearchResult, error) {
+	return cypherSearch(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *Neo4jWriter) StartSession(ctx context.Context, project string, session Session) (Session, error) {
+	return cypherStartSession(ctx, b, b.Statements.Labels, project, session)
+}
+
+func (b *Neo4jWriter) EndSession(ctx context.Context, project, id string) (Session, error) {
+	return cypherEndSession(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *Neo4jWriter) TouchFiles(ctx context.Context, project, id string, paths []string) error {
+	return cypherTouchFiles(ctx, b, b.Statements.Labels, project, id, paths)
+}
+
+func (b *Neo4jWriter) Sessions(ctx context.Context, project string, limit int) ([]Session, error) {
+	return cypherSessions(ctx, b, b.Statements.Labels, project, limit)
+}
+
+func (b *Neo4jWriter) Replay(ctx context.Context, project, id string, namespaces []string) (Session, []Memory, error) {
+	return cypherReplay(ctx, b, b.Statements.Labels, project, id, namespaces)
+}
+
+func (b *Neo4jWriter) Summarize(ctx context.Context, project string, summary Summary) (Summary, error) {
+	return cypherSummarize(ctx, b, b.Statements.Labels, project, RedactSummary(b.Redact, summary))
+}
+
+func (b *Neo4jWriter) Summaries(ctx context.Context, project string, q SummaryQuery) ([]Summary, error) {
+	return cypherSummaries(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *Neo4jWriter) AddConvention(ctx context.Context, project string, convention Convention) (Convention, error) {
+	return cypherAddConvention(ctx, b, b.Statements.Labels, project, convention)
+}
+
+func (b *Neo4jWriter) Conventions(ctx context.Context, project string, q ConventionQuery) ([]Convention, error) {
+	return cypherConventions(ctx, b, b.Statements.Labels, project, q)
+}
+
+func (b *Neo4jWriter) RemoveConvention(ctx context.Context, project, id string) (bool, error) {
+	return cypherRemoveConvention(ctx, b, b.Statements.Labels, project, id)
+}
+
+func (b *Neo4jWriter) ReplaceFindings(ctx context.Context, project, analysis string, findings []Finding) error {
+	return cypherReplaceFindings(ctx, b, b.Statements.Labels, project, analysis, findings)
+}
+
+func (b *Neo4jWriter) ReplaceCycles(ctx context.Context, project string, cycles []Cycle) error {
+	return cypherReplaceCycles(ctx, b, b.Statements.Labels, project, cycles)
+}
+
+func (b *Neo4jWriter) LinkProjects(ctx context.Context, link CrossLink) (CrossLink, error) {
+	return cypherLinkProjects(ctx, b, b.Statements.Labels, link)
+}
+
+func (b *Neo4jWriter) LinkImports(ctx context.Context, project, library, module string) ([]CrossLink, error) {
+	return cypherLinkImports(ctx, b, b.Statements.Labels, project, library, module)
+}
+
+func (b *Neo4jWriter) CrossLinks(ctx context.Context, project string) ([]CrossLink, error) {
+	return cypherCrossLinks(ctx, b, b.Statements.Labels, project)
+}
+
+func (b *Neo4jWriter) Unlink(ctx context.Context, project, id string) (bool, error) {
+	return cypherUnlink(ctx, b, b.Statements.Labels, project, id)
+}
+
+// RecordRun stores the run's metrics as a Run node, which survives later
+// runs' clears so throughput can be compared over time
+func (b *Neo4jWriter) RecordRun(ctx context.Context, project string, run RunInfo, m RunMetrics) error {
+	session := b.Driver.NewSession(ctx, neo4j.SessionConfig{})
+	defer session.Close(ctx)
+
+	query := fmt.Sprintf(`
+		CREATE (r:%s:Run {
+			runId: $runId,
+			startedAt: $startedAt,
+			commit: $commit,
+			parseMs: $parseMs,
+			writeMs: $writeMs,
+			files: $files,
+			nodes: $nodes,
+			relationships: $relationships,
+			nodesPerSec: $nodesPerSec,
+			relationshipsPerSec: $relationshipsPerSec,
+			statements: $statements,
+			retries: $retries,
+			batches: $batches,
+			maxBatch: $maxBatch
+		})
+	`, project)
+	return runStatement(ctx, session, query, map[string]any{
+		"runId":               run.ID,
+		"startedAt":           run.StartedAt,
+		"commit":              run.Commit,
+		"parseMs":             m.ParseTime.Milliseconds(),
+		"writeMs":             m.WriteTime.Milliseconds(),
+		"files":               m.Files,
+		"nodes":               m.Nodes,
+		"relationships":       m.Relationships,
+		"nodesPerSec":         PerSecond(m.Nodes, m.WriteTime),
+		"relationshipsPerSec": PerSecond(m.Relationships, m.WriteTime),
+		"statements":          m.Statements,
+		"retries":             m.Retries,
+		"batches":             m.Batches,
+		"maxBatch":            m.MaxBatch,
+	}, b.Options.StatementTimeout, nil)
+}
+
+func (b *Neo4jWriter) Close(ctx context.Context) error {
+	return b.Driver.Close(ctx)
+}
+
+func createGraph(ctx context.Context, driver neo4j.DriverWithContext, project string, graph *Graph, run RunInfo, stmtOpts StatementOptions, opts WriteOptions, stats *WriteStats) error {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{})
+	defer session.Close(ctx)
+
+	slog.Info("creating graph nodes", "project", project)
+
+	// The statements are built as they are written, and the rest dropped
+	// on an error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := StartProgress("writing", "rows", StatementRows(graph, stmtOpts))
+	defer p.Finish()
+
+	w := &batchWriter{session: session, opts: opts, stats: stats, size: max(opts.BatchSize, 1), progress: p}
+	phase := ""
+	for stmt := range StreamStatements(ctx, project, graph, run, stmtOpts) {
+		if stmt.Phase != phase {
+			phase = stmt.Phase
+			slog.Info("writing", "phase", phase)
+		}
+		if err := w.write(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt.Desc, err)
+		}
+	}
+
+	// Print summary
+	result, err := session.Run(ctx, fmt.Sprintf(`
+		MATCH (n:%s) WHERE NOT coalesce(n.deleted, false)
+		RETURN labels(n) as labels, count(*) as count
+	`, project), nil)
+	if err != nil {
+		return fmt.Errorf("getting summary: %w", err)
+	}
+
+	for result.Next(ctx) {
+		record := result.Record()
+		labels, _ := record.Get("labels")
+		count, _ := record.Get("count")
+		slog.Info("graph summary", "labels", labels, "count", count)
+	}
+
+	return nil
+}
+
+// WriteOptions controls how the Neo4j backend batches and commits writes
+type WriteOptions struct {
+	BatchSize        int
+	FlushInterval    time.Duration
+	Adaptive         bool
+	StatementTimeout time.Duration
+}
+
+// maxBatchRetries bounds how often a batch is replayed after a transient error
+const maxBatchRetries = 3
+
+// batchWriter executes statements in UNWIND batches on one session
+type batchWriter struct {
+	session  neo4j.SessionWithContext
+	opts     WriteOptions
+	stats    *WriteStats
+	size     int
+	progress Progress
+}
+
+// write runs stmt over its rows in batches. Consecutive batches share a
+// transaction until the flush interval has passed, so a zero interval
+// commits every batch. A failed transaction is rolled back and replayed from
+// its first batch: with smaller batches if the server ran out of memory and
+// adaptive batching is on, otherwise at the same size if the error is
+// transient.
+func (w *batchWriter) write(ctx context.Context, stmt Statement) error {
+	if stmt.Rows == nil {
+		ctx, span := tracer.Start(ctx, "write statement", trace.WithAttributes(attribute.String("codegraph.statement", stmt.Desc)))
+		err := runStatement(ctx, w.session, stmt.Query, stmt.Params, w.opts.StatementTimeout, w.stats)
+		endSpan(span, err)
+		return err
+	}
+
+	// The server-side timeout covers the whole transaction, so it only
+	// matches the statement timeout when every batch commits on its own
+	var configurers []func(*neo4j.TransactionConfig)
+	if w.opts.StatementTimeout > 0 && w.opts.FlushInterval == 0 {
+		configurers = append(configurers, neo4j.WithTxTimeout(w.opts.StatementTimeout))
+	}
+
+	var tx neo4j.ExplicitTransaction
+	var flushAt time.Time
+	committed, next, retries := 0, 0, 0
+	for next < len(stmt.Rows) {
+		n := min(w.size, len(stmt.Rows)-next)
+		err := func() error {
+			if tx == nil {
+				var err error
+				if tx, err = w.session.BeginTransaction(ctx, configurers...); err != nil {
+					return err
+				}
+				flushAt = time.Now().Add(w.opts.FlushInterval)
+			}
+			batchCtx, span := tracer.Start(ctx, "write batch", trace.WithAttributes(
+				attribute.String("codegraph.statement", stmt.Desc),
+				attribute.Int("codegraph.rows", n),
+				attribute.Int("codegraph.retries", retries)))
+			err := w.run(batchCtx, tx, stmt.Query, stmt.batchParams(stmt.Rows[next:next+n]))
+			endSpan(span, err)
+			if err != nil {
+				return err
+			}
+			w.stats.addBatch(n)
+			next += n
+			if next < len(stmt.Rows) && time.Now().Before(flushAt) {
+				return nil
+			}
+			err = tx.Commit(ctx)
+			tx = nil
+			if err != nil {
+				return err
+			}
+			w.progress.Add(next - committed)
+			committed, retries = next, 0
+			return nil
+		}()
+		if err == nil {
+			continue
+		}
+
+		if tx != nil {
+			tx.Rollback(ctx)
+			tx = nil
+		}
+		failed := fmt.Errorf("batch starting at row %d: %w", committed, err)
+		next = committed
+		switch {
+		case ctx.Err() != nil:
+			return failed
+		case w.opts.Adaptive && isMemoryPressure(err) && w.size > 1:
+			w.size /= 2
+			w.stats.Shrinks++
+			slog.Warn("server is low on memory, retrying with smaller batches", "batchSize", w.size)
+		case neo4j.IsRetryable(err) && retries < maxBatchRetries:
+			retries++
+			w.stats.Retries++
+			select {
+			case <-time.After(time.Duration(retries) * 200 * time.Millisecond):
+			case <-ctx.Done():
+				return failed
+			}
+		default:
+			return failed
+		}
+	}
+	return nil
+}
+
+// run executes one batch inside tx, bounded by the statement timeout
+func (w *batchWriter) run(ctx context.Context, tx neo4j.ExplicitTransaction, query string, params map[string]any) error {
+	if w.opts.StatementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.StatementTimeout)
+		defer cancel()
+	}
+	w.stats.Statements++
+	result, err := tx.Run(ctx, query, params)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume(ctx)
+	return err
+}
+
+// isMemoryPressure reports whether the server rejected a transaction because
+// it would exceed its memory limits
+func isMemoryPressure(err error) bool {
+	var neoErr *neo4j.Neo4jError
+	if !errors.As(err, &neoErr) {
+		return false
+	}
+	return strings.Contains(neoErr.Code, "MemoryLimit") || strings.Contains(neoErr.Code, "OutOfMemory")
+}
+
+// runStatement runs a write statement in a managed transaction, which the
+// driver retries on transient errors such as deadlocks or leader changes. A
+// non-zero timeout bounds the statement on the client and is sent to the
+// server as the transaction timeout. stats, if not nil, counts the attempts.
+func runStatement(ctx context.Context, session neo4j.SessionWithContext, query string, params map[string]any, timeout time.Duration, stats *WriteStats) error {
+	var configurers []func(*neo4j.TransactionConfig)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		configurers = append(configurers, neo4j.WithTxTimeout(timeout))
+	}
+	attempts := 0
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		attempts++
+		result, err := tx.Run(ctx, query, params)
+		if err != nil {
+			return nil, err
+		}
+		return result.Consume(ctx)
+	}, configurers...)
+	if stats != nil {
+		stats.Statements++
+		if attempts > 1 {
+			stats.Retries += attempts - 1
+		}
+	}
+	return err
+}
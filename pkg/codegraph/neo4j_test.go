@@ -0,0 +1,152 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// fakeSession hands out fakeTransactions, recording the rows each one
+// committed, and fails the runs that fail says should
+type fakeSession struct {
+	neo4j.SessionWithContext
+	fail      func(run int, rows int) error
+	runs      int
+	txs       int
+	committed [][]any
+}
+
+func (s *fakeSession) BeginTransaction(ctx context.Context, configurers ...func(*neo4j.TransactionConfig)) (neo4j.ExplicitTransaction, error) {
+	s.txs++
+	return &fakeTransaction{session: s}, nil
+}
+
+type fakeTransaction struct {
+	neo4j.ExplicitTransaction
+	session *fakeSession
+	rows    []any
+}
+
+func (tx *fakeTransaction) Run(ctx context.Context, query string, params map[string]any) (neo4j.ResultWithContext, error) {
+	rows := params["rows"].([]any)
+	tx.session.runs++
+	if tx.session.fail != nil {
+		if err := tx.session.fail(tx.session.runs, len(rows)); err != nil {
+			return nil, err
+		}
+	}
+	tx.rows = append(tx.rows, rows...)
+	return fakeResult{}, nil
+}
+
+func (tx *fakeTransaction) Commit(ctx context.Context) error {
+	tx.session.committed = append(tx.session.committed, tx.rows)
+	return nil
+}
+
+func (tx *fakeTransaction) Rollback(ctx context.Context) error {
+	return nil
+}
+
+type fakeResult struct {
+	neo4j.ResultWithContext
+}
+
+func (fakeResult) Consume(ctx context.Context) (neo4j.ResultSummary, error) {
+	return nil, nil
+}
+
+func TestBatchWriter(t *testing.T) {
+	rows := make([]map[string]any, 5)
+	for i := range rows {
+		rows[i] = map[string]any{"n": i}
+	}
+	stmt := Statement{Query: "UNWIND $rows AS row CREATE (:N {n: row.n})", Rows: rows}
+	deadlock := &neo4j.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected"}
+	outOfMemory := &neo4j.Neo4jError{Code: "Neo.TransientError.General.MemoryPoolOutOfMemoryError"}
+
+	tests := []struct {
+		name    string
+		opts    WriteOptions
+		fail    func(run, rows int) error
+		commits []int // rows committed by each transaction
+		retries int
+		shrinks int
+		err     bool
+	}{
+		{name: "batch per transaction", opts: WriteOptions{BatchSize: 2}, commits: []int{2, 2, 1}},
+		{name: "flush interval", opts: WriteOptions{BatchSize: 2, FlushInterval: time.Hour}, commits: []int{5}},
+		{
+			name:    "transient error",
+			opts:    WriteOptions{BatchSize: 2},
+			fail:    func(run, rows int) error { return map[bool]error{true: deadlock}[run == 2] },
+			commits: []int{2, 2, 1},
+			retries: 1,
+		},
+		{
+			name:    "memory pressure",
+			opts:    WriteOptions{BatchSize: 4, Adaptive: true},
+			fail:    func(run, rows int) error { return map[bool]error{true: outOfMemory}[rows > 2] },
+			commits: []int{2, 2, 1},
+			shrinks: 1,
+		},
+		{
+			name:    "memory pressure without adaptive batching",
+			opts:    WriteOptions{BatchSize: 4},
+			fail:    func(run, rows int) error { return map[bool]error{true: outOfMemory}[rows > 2] },
+			retries: maxBatchRetries,
+			err:     true,
+		},
+		{
+			name:    "persistent transient error",
+			opts:    WriteOptions{BatchSize: 2},
+			fail:    func(run, rows int) error { return deadlock },
+			retries: maxBatchRetries,
+			err:     true,
+		},
+		{
+			name: "client error",
+			opts: WriteOptions{BatchSize: 2},
+			fail: func(run, rows int) error { return &neo4j.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError"} },
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		session := &fakeSession{fail: tt.fail}
+		var stats WriteStats
+		w := &batchWriter{session: session, opts: tt.opts, stats: &stats, size: tt.opts.BatchSize, progress: StartProgress("writing", "rows", 0)}
+		err := w.write(context.Background(), stmt)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+		}
+		var commits []int
+		for _, rows := range session.committed {
+			commits = append(commits, len(rows))
+		}
+		if !slices.Equal(commits, tt.commits) {
+			t.Errorf("%s: committed %v rows per transaction, want %v", tt.name, commits, tt.commits)
+		}
+		if stats.Retries != tt.retries || stats.Shrinks != tt.shrinks {
+			t.Errorf("%s: %d retries and %d shrinks, want %d and %d", tt.name, stats.Retries, stats.Shrinks, tt.retries, tt.shrinks)
+		}
+	}
+}
+
+func TestIsMemoryPressure(t *testing.T) {
+	for err, want := range map[error]bool{
+		&neo4j.Neo4jError{Code: "Neo.TransientError.General.MemoryPoolOutOfMemoryError"}:                true,
+		&neo4j.Neo4jError{Code: "Neo.TransientError.General.TransactionMemoryLimit"}:                    true,
+		&neo4j.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected"}:                      false,
+		fmt.Errorf("batch: %w", &neo4j.Neo4jError{Code: "Neo.TransientError.General.OutOfMemoryError"}): true,
+		errors.New("out of memory"): false,
+	} {
+		if got := isMemoryPressure(err); got != want {
+			t.Errorf("isMemoryPressure(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,831 @@
+package codegraph
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Parser parses the Go files under Root that Filter includes into a Graph
+type Parser struct {
+	Root   string
+	Filter Filter
+
+	// SyntaxOnly parses each file on its own instead of loading and
+	// type-checking its package, which is faster and needs neither a
+	// go.mod nor the dependencies, but resolves calls by name only
+	SyntaxOnly bool
+
+	// CacheDir, if set, keeps what is parsed of each file, to be reused
+	// while the file is unchanged, and when type-checked while its
+	// package and those it imports are
+	CacheDir string
+}
+
+// Parse parses the working tree, type-checking it unless SyntaxOnly is set
+func (p Parser) Parse(ctx context.Context) (*Graph, error) {
+	parse := parseTyped
+	if p.SyntaxOnly {
+		parse = parseCodebase
+	}
+	cache := newParseCache(p.CacheDir)
+	graph, err := parse(ctx, p.Root, p.Filter, cache)
+	if err != nil {
+		return nil, err
+	}
+	cache.report()
+	if err := p.scope(graph); err != nil {
+		return nil, err
+	}
+	graph.Sort()
+	return graph, nil
+}
+
+// scope sets the modules of a graph parsed from Root, see ModuleNode
+func (p Parser) scope(graph *Graph) error {
+	modules, err := findModules(p.Root, p.Filter)
+	if err != nil {
+		return err
+	}
+	graph.setModules(modules)
+	return nil
+}
+
+// ParseRevision parses the tree at a git revision instead of the working
+// tree, reading the files from git without checking them out. The files
+// are not type-checked.
+func (p Parser) ParseRevision(ctx context.Context, rev string) (*Graph, error) {
+	cache := newParseCache(p.CacheDir)
+	graph, err := parseRevision(ctx, p.Root, rev, p.Filter, cache)
+	if err != nil {
+		return nil, err
+	}
+	cache.report()
+	// The modules are those of the working tree
+	if err := p.scope(graph); err != nil {
+		return nil, err
+	}
+	graph.Sort()
+	return graph, nil
+}
+
+// ParseFile parses one file under Root into a graph holding the file, its
+// package and its declarations, for merging with Graph.AddFragment. src is
+// the file's content, or nil to read it from path. The file is not
+// type-checked. A file over the MaxFileSize of Filter is an error.
+func (p Parser) ParseFile(path string, src []byte) (*Graph, error) {
+	if p.Filter.MaxFileSize > 0 {
+		size := int64(len(src))
+		if src == nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			size = info.Size()
+		}
+		if size > p.Filter.MaxFileSize {
+			return nil, fmt.Errorf("%s is %d bytes, over the limit of %d", path, size, p.Filter.MaxFileSize)
+		}
+	}
+	return parseFile(token.NewFileSet(), p.Root, path, src)
+}
+
+// parseRevision parses the Go files under root as they were at rev, reading
+// them from the git object store so nothing has to be checked out
+func parseRevision(ctx context.Context, root, rev string, filter Filter, cache *parseCache) (*Graph, error) {
+	// ls-tree lists the subtree of the working directory, relative to it
+	out, err := exec.CommandContext(ctx, "git", "-C", root, "ls-tree", "-r", "--name-only", "-z", rev).Output()
+	if err != nil {
+		return nil, GitError("ls-tree", err)
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if filter.Includes(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "-C", root, "cat-file", "--batch")
+	var stdin strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&stdin, "%s:./%s\n", rev, path)
+	}
+	cmd.Stdin = strings.NewReader(stdin.String())
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// On an early return git may be blocked writing objects no one reads,
+	// so it is killed before it is waited for
+	defer func() {
+		if cmd.ProcessState == nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+
+	// Read the objects in order, then parse them in parallel
+	files := make([]string, 0, len(paths))
+	sources := make([][]byte, 0, len(paths))
+	limit := limiter{filter: filter}
+	r := bufio.NewReader(stdout)
+	for _, path := range paths {
+		// Each object is "<sha> <type> <size>\n<content>\n"
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("reading %s: %s", path, strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if !limit.keep(path, int64(size)) {
+			if _, err := r.Discard(size + 1); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", path, err)
+			}
+			continue
+		}
+		src := make([]byte, size+1)
+		if _, err := io.ReadFull(r, src); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		files = append(files, filepath.Join(root, filepath.FromSlash(path)))
+		sources = append(sources, src[:size])
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("git cat-file: %w", err)
+	}
+	limit.report()
+	return parseMerged(ctx, root, files, sources, cache), nil
+}
+
+// isSkippedDir reports whether a directory is left out of the graph: hidden
+// directories and common non-source directories
+func isSkippedDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules"
+}
+
+// parseCodebase parses the files under root that filter includes. Walking is
+// sequential; parsing runs in parallel and is merged in walk order, so the
+// result does not depend on scheduling.
+func parseCodebase(ctx context.Context, root string, filter Filter, cache *parseCache) (*Graph, error) {
+	paths, err := sourceFiles(root, filter)
+	if err != nil {
+		return nil, err
+	}
+	return parseMerged(ctx, root, paths, nil, cache), nil
+}
+
+// sourceFiles walks root for the files filter includes, in walk order,
+// within its limits
+func sourceFiles(root string, filter Filter) ([]string, error) {
+	var paths []string
+	limit := limiter{filter: filter}
+	err := filter.Walk(root, root, func(path, relPath string, info fs.FileInfo) error {
+		if !info.IsDir() && filter.Includes(relPath) && limit.keep(relPath, info.Size()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	limit.report()
+	return paths, err
+}
+
+// parseWindow is how many files parseFiles parses ahead of the first one
+// it has not passed on, per worker
+const parseWindow = 4
+
+// parseFiles parses the files on GOMAXPROCS workers and passes their
+// fragments to add in the order of paths, nil for files that failed to
+// parse, each once it and the files before it are parsed. No more than
+// parseWindow files a worker are parsed ahead, so however many files
+// there are, few fragments are held at once. sources holds each file's
+// content, or is nil to read the files from disk. Files cache has are
+// read from it, and those parsed are added to it. The files that failed
+// are returned in the order of paths.
+func parseFiles(ctx context.Context, root string, paths []string, sources [][]byte, cache *parseCache, add func(i int, fragment *Graph)) []ParseFailure {
+	type parsed struct {
+		i        int
+		fragment *Graph
+		err      error
+	}
+	fset := token.NewFileSet()
+	workers := min(runtime.GOMAXPROCS(0), len(paths))
+
+	p := StartProgress("parsing", "files", len(paths))
+	next := make(chan int)
+	done := make(chan parsed, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				var src []byte
+				if sources != nil {
+					src = sources[i]
+				}
+				_, span := tracer.Start(ctx, "parse file", trace.WithAttributes(attribute.String("code.file.path", paths[i])))
+				fragment, err := parseCached(fset, root, paths[i], src, cache)
+				endSpan(span, err)
+				p.Add(1)
+				done <- parsed{i, fragment, err}
+			}
+		}()
+	}
+
+	// Failures are reported in path order so runs are reproducible
+	var failed []ParseFailure
+	pending := make(map[int]parsed)
+	sent, added := 0, 0
+	for added < len(paths) {
+		var send chan int
+		if sent < len(paths) && sent-added < workers*parseWindow {
+			send = next
+		}
+		select {
+		case send <- sent:
+			sent++
+		case result := <-done:
+			pending[result.i] = result
+			for result, ok := pending[added]; ok; result, ok = pending[added] {
+				delete(pending, added)
+				if result.err != nil {
+					slog.Warn("failed to parse", "file", paths[added], "err", result.err)
+					failed = append(failed, parseFailure(root, paths[added], result.err))
+				}
+				add(added, result.fragment)
+				added++
+			}
+		}
+	}
+	close(next)
+	wg.Wait()
+	p.Finish()
+	return failed
+}
+
+// parseFailure describes the failure to parse the file at path below root
+func parseFailure(root, path string, err error) ParseFailure {
+	if rel, relErr := filepath.Rel(root, path); relErr == nil {
+		path = rel
+	}
+	return ParseFailure{File: filepath.ToSlash(path), Err: err.Error()}
+}
+
+// parseMerged parses the files like parseFiles and merges their fragments
+// into one graph as they come, so no more than a few files' syntax trees
+// are held besides it. The content of each file in sources is let go once
+// it is merged. The merged graph itself is held whole, so memory still
+// grows with the number of symbols, see BenchmarkParseMemory.
+func parseMerged(ctx context.Context, root string, paths []string, sources [][]byte, cache *parseCache) *Graph {
+	m := newMerger()
+	m.graph.Failed = parseFiles(ctx, root, paths, sources, cache, func(i int, fragment *Graph) {
+		if sources != nil {
+			sources[i] = nil
+		}
+		m.add(fragment)
+	})
+	return m.graph
+}
+
+// merger combines per-file fragments into one graph, keeping the first
+// occurrence of each package
+type merger struct {
+	graph    *Graph
+	packages map[string]bool
+}
+
+func newMerger() *merger {
+	return &merger{graph: &Graph{}, packages: make(map[string]bool)}
+}
+
+// add merges fragment, if not nil, into the graph
+func (m *merger) add(fragment *Graph) {
+	if fragment == nil {
+		return
+	}
+	for _, pkg := range fragment.Packages {
+		if !m.packages[pkg.Path] {
+			m.packages[pkg.Path] = true
+			m.graph.Packages = append(m.graph.Packages, pkg)
+		}
+	}
+	m.graph.Files = append(m.graph.Files, fragment.Files...)
+	m.graph.Functions = append(m.graph.Functions, fragment.Functions...)
+	m.graph.Structs = append(m.graph.Structs, fragment.Structs...)
+	m.graph.Interfaces = append(m.graph.Interfaces, fragment.Interfaces...)
+}
+
+// mergeFragments combines per-file fragments into one graph, keeping the
+// first occurrence of each package
+func mergeFragments(fragments []*Graph) *Graph {
+	m := newMerger()
+	for _, fragment := range fragments {
+		m.add(fragment)
+	}
+	return m.graph
+}
+
+// isSourceFile reports whether path is a Go file the graph includes
+func isSourceFile(path string) bool {
+	return strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go")
+}
+
+// parseFile parses one source file into a graph holding the file, its
+// package and its declarations. src is the file's content, or nil to read
+// it from path.
+func parseFile(fset *token.FileSet, root, path string, src []byte) (*Graph, error) {
+	var source any
+	if src != nil {
+		source = src
+	}
+	file, err := parser.ParseFile(fset, path, source, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	relPath, _ := filepath.Rel(root, path)
+	return fileGraph(fset, relPath, file, nil), nil
+}
+
+// parseCached is parseFile reading the fragment from cache, if not nil,
+// while the file is unchanged
+func parseCached(fset *token.FileSet, root, path string, src []byte, cache *parseCache) (*Graph, error) {
+	if cache == nil {
+		return parseFile(fset, root, path, src)
+	}
+	if src == nil {
+		var err error
+		if src, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	relPath, _ := filepath.Rel(root, path)
+	key := cache.key(relPath, src)
+	if fragment, ok := cache.get(key); ok {
+		return fragment, nil
+	}
+	fragment, err := parseFile(fset, root, path, src)
+	if err == nil {
+		cache.put(key, fragment)
+	}
+	return fragment, err
+}
+
+// fileGraph holds a parsed file, its package and its declarations. resolve,
+// nil for a file that was not type-checked, resolves the names their calls
+// and references are written by, see resolveNames.
+func fileGraph(fset *token.FileSet, relPath string, file *ast.File, resolve resolver) *Graph {
+	graph := &Graph{
+		Files: []FileNode{{
+			Path:        relPath,
+			Package:     file.Name.Name,
+			Language:    "go",
+			Imports:     extractImports(file),
+			ImportNames: extractImportNames(file),
+		}},
+		Packages: []PackageNode{{
+			Name: file.Name.Name,
+			Path: filepath.Dir(relPath),
+		}},
+	}
+
+	// Extract declarations
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			fn := extractFunction(d, relPath, fset, resolve)
+			graph.Functions = append(graph.Functions, fn)
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.ValueSpec:
+					for _, value := range s.Values {
+						uses := appendNew(extractCalls(value, "", ""), extractRefs(value, "", ""))
+						graph.Files[0].Uses = appendNew(graph.Files[0].Uses, uses)
+						if resolve != nil {
+							graph.Files[0].Resolved = mergeResolved(graph.Files[0].Resolved, resolve(value, "", "", uses))
+						}
+					}
+				case *ast.TypeSpec:
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						st := extractStruct(s, t, relPath)
+						// A lone type's comment sits on the declaration
+						doc := s.Doc
+						if doc == nil && len(d.Specs) == 1 {
+							doc = d.Doc
+						}
+						st.Doc = strings.TrimSpace(doc.Text())
+						graph.Structs = append(graph.Structs, st)
+					case *ast.InterfaceType:
+						iface := extractInterface(s, t, relPath)
+						graph.Interfaces = append(graph.Interfaces, iface)
+					}
+				}
+			}
+		}
+	}
+
+	return graph
+}
+
+func extractImports(file *ast.File) []string {
+	var imports []string
+	for _, imp := range file.Imports {
+		path := strings.Trim(imp.Path.Value, `"`)
+		imports = append(imports, path)
+	}
+	return imports
+}
+
+// extractImportNames returns the names a file gives the imports it names,
+// by import path
+func extractImportNames(file *ast.File) map[string]string {
+	var names map[string]string
+	for _, imp := range file.Imports {
+		if imp.Name == nil {
+			continue
+		}
+		if names == nil {
+			names = make(map[string]string)
+		}
+		names[strings.Trim(imp.Path.Value, `"`)] = imp.Name.Name
+	}
+	return names
+}
+
+func extractFunction(fn *ast.FuncDecl, file string, fset *token.FileSet, resolve resolver) FunctionNode {
+	node := FunctionNode{
+		Name:      fn.Name.Name,
+		File:      file,
+		IsExport:  ast.IsExported(fn.Name.Name),
+		LineStart: fset.Position(fn.Pos()).Line,
+		LineEnd:   fset.Position(fn.End()).Line,
+		Doc:       strings.TrimSpace(fn.Doc.Text()),
+	}
+
+	// Build signature
+	var sig strings.Builder
+	sig.WriteString("func ")
+
+	// Check for receiver (method)
+	recvName := ""
+	if fn.Recv != nil && len(fn.Recv.List) > 0 {
+		recv := fn.Recv.List[0]
+		recvType := exprToString(recv.Type)
+		node.Receiver = recvType
+		sig.WriteString("(" + recvType + ") ")
+		if len(recv.Names) > 0 {
+			recvName = recv.Names[0].Name
+		}
+	}
+
+	sig.WriteString(fn.Name.Name)
+	sig.WriteString(formatParams(fn.Type.Params))
+
+	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+		sig.WriteString(" ")
+		sig.WriteString(formatParams(fn.Type.Results))
+	}
+
+	node.Signature = sig.String()
+	if fn.Body != nil {
+		node.Calls = extractCalls(fn.Body, recvName, strings.TrimPrefix(node.Receiver, "*"))
+		node.Refs = extractRefs(fn.Body, recvName, strings.TrimPrefix(node.Receiver, "*"))
+		node.Complexity = complexity(fn.Body)
+		node.Fingerprint = fingerprint(fn.Body)
+		node.Stub = isStub(fn.Body)
+		node.Discards = extractDiscards(fn.Body, fset, recvName, strings.TrimPrefix(node.Receiver, "*"))
+		if resolve != nil {
+			node.Resolved = resolve(fn.Body, recvName, strings.TrimPrefix(node.Receiver, "*"), appendNew(slices.Clip(node.Calls), node.Refs))
+		}
+	}
+	return node
+}
+
+// extractCalls collects the distinct callee expressions in a function body
+// or expression, qualifying calls through the receiver variable with the
+// receiver type
+func extractCalls(body ast.Node, recvName, recvType string) []string {
+	var calls []string
+	seen := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if callee := calleeName(call, recvName, recvType); callee != "" && !seen[callee] {
+			seen[callee] = true
+			calls = append(calls, callee)
+		}
+		return true
+	})
+	return calls
+}
+
+// calleeName writes the function a call expression calls like the callees
+// of extractCalls, or returns "" for one it does not name, such as a call
+// of a function literal
+func calleeName(call *ast.CallExpr, recvName, recvType string) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		qualifier := exprToString(fun.X)
+		if recvName != "" && qualifier == recvName {
+			qualifier = recvType
+		}
+		return qualifier + "." + fun.Sel.Name
+	}
+	return ""
+}
+
+// extractDiscards collects the calls in a function body whose results are
+// thrown away, all of them by a call made as a statement of its own or
+// some of them by assigning them to the blank identifier. Deferred calls
+// and those of go statements are left out.
+func extractDiscards(body ast.Node, fset *token.FileSet, recvName, recvType string) []Discard {
+	var discards []Discard
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch stmt := n.(type) {
+		case *ast.ExprStmt:
+			if call, ok := stmt.X.(*ast.CallExpr); ok {
+				if callee := calleeName(call, recvName, recvType); callee != "" {
+					discards = append(discards, Discard{Call: callee, Line: fset.Position(call.Pos()).Line})
+				}
+			}
+		case *ast.AssignStmt:
+			if len(stmt.Rhs) != 1 {
+				break
+			}
+			call, ok := stmt.Rhs[0].(*ast.CallExpr)
+			if !ok {
+				break
+			}
+			callee := calleeName(call, recvName, recvType)
+			var blanks []int
+			for i, lhs := range stmt.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Name == "_" {
+					blanks = append(blanks, i)
+				}
+			}
+			if callee != "" && len(blanks) > 0 {
+				discards = append(discards, Discard{Call: callee, Line: fset.Position(call.Pos()).Line, Blanks: blanks})
+			}
+		}
+		return true
+	})
+	return discards
+}
+
+// complexity returns the cyclomatic complexity of a function body: one,
+// plus one for each branch it may take, at an if, a loop, a case other
+// than the default, or a && or ||. The bodies of function literals count
+// towards the function holding them.
+func complexity(body *ast.BlockStmt) int {
+	n := 1
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			n++
+		case *ast.CaseClause:
+			if node.List != nil {
+				n++
+			}
+		case *ast.CommClause:
+			if node.Comm != nil {
+				n++
+			}
+		case *ast.BinaryExpr:
+			if node.Op == token.LAND || node.Op == token.LOR {
+				n++
+			}
+		}
+		return true
+	})
+	return n
+}
+
+// isStub reports whether a function body does nothing of its own: it is
+// empty, only returns zero values or a new error, or only panics, like
+// the methods a type declares just to satisfy an interface
+func isStub(body *ast.BlockStmt) bool {
+	if len(body.List) != 1 {
+		return len(body.List) == 0
+	}
+	switch stmt := body.List[0].(type) {
+	case *ast.ReturnStmt:
+		for _, result := range stmt.Results {
+			if !isZeroValue(result) {
+				return false
+			}
+		}
+		return true
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		return ok && exprToString(call.Fun) == "panic"
+	}
+	return false
+}
+
+// isZeroValue reports whether expr is a zero value literal, or a new error
+func isZeroValue(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name == "nil" || e.Name == "false"
+	case *ast.BasicLit:
+		return e.Value == "0" || e.Value == `""` || e.Value == "``"
+	case *ast.CompositeLit:
+		return len(e.Elts) == 0
+	case *ast.CallExpr:
+		fun := exprToString(e.Fun)
+		return fun == "errors.New" || fun == "fmt.Errorf"
+	}
+	return false
+}
+
+// extractRefs collects the distinct names a function body or expression
+// refers to without calling them, written like the callees of
+// extractCalls. Names the parser resolved to local variables, parameters
+// or types are left out, as are fields selected from them and predeclared
+// names, leaving those that may be functions, such as a handler passed as an argument.
+func extractRefs(body ast.Node, recvName, recvType string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	add := func(ref string) {
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			// The callee is called, not referred to, but what it is
+			// selected from and its arguments may refer to functions
+			switch fun := n.Fun.(type) {
+			case *ast.Ident:
+			case *ast.SelectorExpr:
+				ast.Inspect(fun.X, visit)
+			default:
+				ast.Inspect(fun, visit)
+			}
+			for _, arg := range n.Args {
+				ast.Inspect(arg, visit)
+			}
+			return false
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok && (recvName != "" && x.Name == recvName || x.Obj == nil) {
+				qualifier := x.Name
+				if qualifier == recvName {
+					qualifier = recvType
+				}
+				add(qualifier + "." + n.Sel.Name)
+				return false
+			}
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.CompositeLit:
+			// The type of a literal is not a value
+			for _, elt := range n.Elts {
+				ast.Inspect(elt, visit)
+			}
+			return false
+		case *ast.KeyValueExpr:
+			// The keys of a struct literal are fields
+			if _, ok := n.Key.(*ast.Ident); ok {
+				ast.Inspect(n.Value, visit)
+				return false
+			}
+		case *ast.Ident:
+			if n.Obj == nil && types.Universe.Lookup(n.Name) == nil || n.Obj != nil && n.Obj.Kind == ast.Fun {
+				add(n.Name)
+			}
+		}
+		return true
+	}
+	ast.Inspect(body, visit)
+	return refs
+}
+
+// appendNew appends the items not already in list
+func appendNew(list, items []string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func extractStruct(spec *ast.TypeSpec, st *ast.StructType, file string) StructNode {
+	node := StructNode{
+		Name:     spec.Name.Name,
+		File:     file,
+		IsExport: ast.IsExported(spec.Name.Name),
+	}
+
+	for _, field := range st.Fields.List {
+		fieldType := exprToString(field.Type)
+		for _, name := range field.Names {
+			node.Fields = append(node.Fields, name.Name+" "+fieldType)
+		}
+		if len(field.Names) == 0 {
+			// Embedded field
+			node.Fields = append(node.Fields, fieldType)
+		}
+	}
+
+	return node
+}
+
+func extractInterface(spec *ast.TypeSpec, iface *ast.InterfaceType, file string) InterfaceNode {
+	node := InterfaceNode{
+		Name:     spec.Name.Name,
+		File:     file,
+		IsExport: ast.IsExported(spec.Name.Name),
+	}
+
+	for _, method := range iface.Methods.List {
+		for _, name := range method.Names {
+			if fn, ok := method.Type.(*ast.FuncType); ok {
+				sig := name.Name + formatParams(fn.Params)
+				if fn.Results != nil {
+					sig += " " + formatParams(fn.Results)
+				}
+				node.Methods = append(node.Methods, sig)
+			}
+		}
+	}
+
+	return node
+}
+
+func exprToString(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.StarExpr:
+		return "*" + exprToString(e.X)
+	case *ast.SelectorExpr:
+		return exprToString(e.X) + "." + e.Sel.Name
+	case *ast.ArrayType:
+		return "[]" + exprToString(e.Elt)
+	case *ast.MapType:
+		return "map[" + exprToString(e.Key) + "]" + exprToString(e.Value)
+	case *ast.InterfaceType:
+		return "interface{}"
+	case *ast.FuncType:
+		return "func" + formatParams(e.Params)
+	default:
+		return "..."
+	}
+}
+
+func formatParams(fields *ast.FieldList) string {
+	if fields == nil {
+		return "()"
+	}
+
+	var parts []string
+	for _, field := range fields.List {
+		fieldType := exprToString(field.Type)
+		if len(field.Names) > 0 {
+			for _, name := range field.Names {
+				parts = append(parts, name.Name+" "+fieldType)
+			}
+		} else {
+			parts = append(parts, fieldType)
+		}
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
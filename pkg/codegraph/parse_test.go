@@ -0,0 +1,576 @@
+package codegraph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTree is a small codebase with calls within and across packages, a
+// method called through its receiver and an interface it implements
+var testTree = map[string]string{
+	"main.go": `package main
+
+import "example.com/app/store"
+
+func main() {
+	s := store.New()
+	s.Put("k")
+	run()
+}
+
+func run() {}
+`,
+	"store/store.go": `package store
+
+// Putter stores keys
+type Putter interface {
+	Put(key string) error
+}
+
+type Store struct {
+	keys []string
+}
+
+func New() *Store { return &Store{} }
+
+func (s *Store) Put(key string) error {
+	s.keys = append(s.keys, key)
+	return s.flush()
+}
+
+func (s *Store) flush() error { return nil }
+`,
+	"store/store_test.go": `package store
+
+func TestPut() {}
+`,
+	"vendor/dep/dep.go": `package dep
+
+func Vendored() {}
+`,
+}
+
+func writeTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, src := range files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+func parseTestTree(t *testing.T, filter Filter) *Graph {
+	t.Helper()
+	graph, err := Parser{Root: writeTree(t, testTree), Filter: filter}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+func TestParse(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+
+	var files []string
+	for _, f := range graph.Files {
+		files = append(files, f.Path)
+	}
+	if want := []string{"main.go", "store/store.go"}; !slices.Equal(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+
+	var funcs []string
+	for _, fn := range graph.Functions {
+		funcs = append(funcs, fn.Key())
+	}
+	want := []string{
+		"Function:main.go:main",
+		"Function:main.go:run",
+		"Function:store/store.go:New",
+		"Function:store/store.go:*Store.Put",
+		"Function:store/store.go:*Store.flush",
+	}
+	if !slices.Equal(funcs, want) {
+		t.Errorf("functions = %q, want %q", funcs, want)
+	}
+
+	if len(graph.Structs) != 1 || graph.Structs[0].Name != "Store" || graph.Structs[0].IsExport != true {
+		t.Errorf("structs = %+v, want the exported Store", graph.Structs)
+	}
+	if len(graph.Interfaces) != 1 || graph.Interfaces[0].Name != "Putter" {
+		t.Errorf("interfaces = %+v, want Putter", graph.Interfaces)
+	}
+}
+
+func TestExtractCalls(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	calls := make(map[string][]string)
+	for _, fn := range graph.Functions {
+		calls[fn.Key()] = fn.Calls
+	}
+	for key, want := range map[string][]string{
+		"Function:main.go:main":                {"store.New", "s.Put", "run"},
+		"Function:store/store.go:*Store.Put":   {"append", "Store.flush"},
+		"Function:store/store.go:*Store.flush": nil,
+	} {
+		if !slices.Equal(calls[key], want) {
+			t.Errorf("%s calls %q, want %q", key, calls[key], want)
+		}
+	}
+}
+
+func TestExtractRefs(t *testing.T) {
+	src := `package server
+
+import "net/http"
+
+var routes = map[string]http.HandlerFunc{"/": handleRoot, "/health": health()}
+
+type Server struct{ mux *http.ServeMux }
+
+func (s *Server) routes(cfg Config) {
+	handler := s.handle
+	s.mux.HandleFunc("/x", wrap(handler, cfg.Timeout, logRequest))
+}
+`
+	root := t.TempDir()
+	fragment, err := Parser{Root: root}.ParseFile(filepath.Join(root, "server.go"), []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"health", "handleRoot"}; !slices.Equal(fragment.Files[0].Uses, want) {
+		t.Errorf("uses = %q, want %q", fragment.Files[0].Uses, want)
+	}
+	// What is selected from the receiver may be a method, the local
+	// variable and the field of the parameter are not referred to
+	if want := []string{"Server.handle", "Server.mux", "logRequest"}; !slices.Equal(fragment.Functions[0].Refs, want) {
+		t.Errorf("refs = %q, want %q", fragment.Functions[0].Refs, want)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	root := writeTree(t, testTree)
+	graph, err := parseCodebase(context.Background(), root, Filter{Exclude: []string{"store"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Files) != 1 || graph.Files[0].Path != "main.go" {
+		t.Errorf("files = %+v, want only main.go", graph.Files)
+	}
+}
+
+func TestParseTests(t *testing.T) {
+	graph, err := parseCodebase(context.Background(), writeTree(t, testTree), Filter{Tests: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(graph.Functions, func(fn FunctionNode) bool { return fn.Name == "TestPut" }) {
+		t.Error("TestPut not parsed with Filter.Tests")
+	}
+	if !slices.ContainsFunc(graph.Files, func(f FileNode) bool { return f.Path == filepath.Join("store", "store_test.go") }) {
+		t.Errorf("files = %+v, want store_test.go included", graph.Files)
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	root := t.TempDir()
+	paths := []string{filepath.Join(root, "a.go"), filepath.Join(root, "broken.go"), filepath.Join(root, "b.go")}
+	sources := [][]byte{[]byte("package a\n\nfunc A() {}\n"), []byte("package"), []byte("package a\n\nfunc B() {}\n")}
+
+	fragments := make([]*Graph, len(paths))
+	failed := parseFiles(context.Background(), root, paths, sources, nil, func(i int, fragment *Graph) {
+		fragments[i] = fragment
+	})
+	if len(fragments) != 3 || fragments[1] != nil {
+		t.Fatalf("fragments = %v, want the broken file's nil", fragments)
+	}
+	if len(failed) != 1 || failed[0].File != "broken.go" || failed[0].Err == "" {
+		t.Errorf("failed = %+v, want broken.go", failed)
+	}
+	if fragments[0].Functions[0].Name != "A" || fragments[2].Functions[0].Name != "B" {
+		t.Errorf("fragments out of order: %s, %s", fragments[0].Functions[0].Name, fragments[2].Functions[0].Name)
+	}
+
+	graph := mergeFragments(fragments)
+	if len(graph.Packages) != 1 || len(graph.Files) != 2 || len(graph.Functions) != 2 {
+		t.Errorf("merged %d packages, %d files, %d functions; want 1, 2, 2", len(graph.Packages), len(graph.Files), len(graph.Functions))
+	}
+}
+
+func TestParseDeterministic(t *testing.T) {
+	files := make(map[string]string)
+	for i := range 40 {
+		files[fmt.Sprintf("pkg%d/file%d.go", i%5, i)] = fmt.Sprintf("package pkg%d\n\nfunc F%d() {}\n", i%5, i)
+	}
+	root := writeTree(t, files)
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	sequential, err := parseCodebase(context.Background(), root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GOMAXPROCS(8)
+	parallel, err := parseCodebase(context.Background(), root, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := json.Marshal(sequential)
+	got, _ := json.Marshal(parallel)
+	if !bytes.Equal(got, want) {
+		t.Error("parallel parse differs from sequential parse")
+	}
+	if len(parallel.Functions) != 40 || len(parallel.Packages) != 5 {
+		t.Errorf("%d functions in %d packages, want 40 in 5", len(parallel.Functions), len(parallel.Packages))
+	}
+}
+
+func TestParseRevision(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, testTree)
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := parseRevision(ctx, root, "HEAD", Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := parseTestTree(t, Filter{})
+	if len(graph.Files) != len(committed.Files) || len(graph.Functions) != len(committed.Functions) {
+		t.Errorf("HEAD has %d files and %d functions, want %d and %d as committed",
+			len(graph.Files), len(graph.Functions), len(committed.Files), len(committed.Functions))
+	}
+	if _, err := parseRevision(ctx, root, "no-such-rev", Filter{}, nil); err == nil {
+		t.Error("parsed a missing revision")
+	}
+
+	// A submodule named like a Go file is missing from the object store,
+	// and git is still writing the files after it when the read fails
+	big := "package big\n\n// " + strings.Repeat("x", 1<<17) + "\n"
+	for i := range 4 {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("big%d.go", i)), []byte(big), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, root, "add", ".")
+	git(t, root, "update-index", "--add", "--cacheinfo", "160000,"+strings.Repeat("1", 40)+",a.go")
+	git(t, root, "commit", "-q", "-m", "submodule")
+	if _, err := parseRevision(ctx, root, "HEAD", Filter{}, nil); err == nil || !strings.Contains(err.Error(), "a.go") {
+		t.Errorf("parsing a missing object: %v", err)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"go.mod":       "module example.com/app\n",
+		"a.go":         "package app\n\nfunc A() { B() }\n",
+		"b.go":         "package app\n\nfunc B() {}\n",
+		"generated.go": "package app\n\n// " + strings.Repeat("x", 1<<12) + "\nfunc Generated() {}\n",
+	}
+	root := gitRepo(t, files)
+	parsed := func(graph *Graph) []string {
+		var paths []string
+		for _, file := range graph.Files {
+			paths = append(paths, file.Path)
+		}
+		return paths
+	}
+
+	for _, syntaxOnly := range []bool{false, true} {
+		graph, err := Parser{Root: root, Filter: Filter{MaxFileSize: 1 << 10}, SyntaxOnly: syntaxOnly}.Parse(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := parsed(graph), []string{"a.go", "b.go"}; !slices.Equal(got, want) {
+			t.Errorf("syntax-only %v: under the size limit parsed %q, want %q", syntaxOnly, got, want)
+		}
+		if calls := graph.Calls(); len(calls) != 1 || calls[0].To != "Function:b.go:B" {
+			t.Errorf("syntax-only %v: calls = %+v", syntaxOnly, calls)
+		}
+	}
+	graph, err := Parser{Root: root, Filter: Filter{MaxFiles: 2}, SyntaxOnly: true}.Parse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := parsed(graph), []string{"a.go", "b.go"}; !slices.Equal(got, want) {
+		t.Errorf("under the file limit parsed %q, want %q", got, want)
+	}
+
+	graph, err = parseRevision(ctx, root, "HEAD", Filter{MaxFileSize: 1 << 10, MaxFiles: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := parsed(graph), []string{"a.go"}; !slices.Equal(got, want) {
+		t.Errorf("at HEAD under both limits parsed %q, want %q", got, want)
+	}
+
+	parser := Parser{Root: root, Filter: Filter{MaxFileSize: 1 << 10}}
+	if _, err := parser.ParseFile(filepath.Join(root, "generated.go"), nil); err == nil {
+		t.Error("parsed a file over the size limit")
+	}
+	if _, err := parser.ParseFile(filepath.Join(root, "generated.go"), []byte("package app\n")); err != nil {
+		t.Errorf("parsing a file brought under the size limit: %v", err)
+	}
+}
+
+func TestParseFileAndFragments(t *testing.T) {
+	root := writeTree(t, testTree)
+	parser := Parser{Root: root}
+	graph, err := parser.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(root, "main.go")
+	fragment, err := parser.ParseFile(path, []byte("package main\n\nfunc main() {}\n\nfunc other() {}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.RemoveFile("main.go")
+	graph.AddFragment(fragment)
+
+	var funcs []string
+	for _, fn := range graph.Functions {
+		if fn.File == "main.go" {
+			funcs = append(funcs, fn.Name)
+		}
+	}
+	if want := []string{"main", "other"}; !slices.Equal(funcs, want) {
+		t.Errorf("main.go functions = %q, want %q", funcs, want)
+	}
+
+	graph.RemoveFile("store/store.go")
+	if slices.ContainsFunc(graph.Packages, func(p PackageNode) bool { return p.Path == "store" }) {
+		t.Error("package store kept after its only file was removed")
+	}
+}
+
+func TestParseDocs(t *testing.T) {
+	root := t.TempDir()
+	src := `package store
+
+// Put stores key.
+// It fails once the store is closed.
+func Put(key string) error { return nil }
+
+func bare() {}
+
+// Store holds keys
+type Store struct{}
+
+type (
+	// Pair is two keys
+	Pair struct{}
+	Other struct{}
+)
+`
+	fragment, err := Parser{Root: root}.ParseFile(filepath.Join(root, "store.go"), []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]string)
+	for _, fn := range fragment.Functions {
+		docs[fn.Key()] = fn.Doc
+	}
+	for _, st := range fragment.Structs {
+		docs[st.Key()] = st.Doc
+	}
+	want := map[string]string{
+		"Function:store.go:Put":  "Put stores key.\nIt fails once the store is closed.",
+		"Function:store.go:bare": "",
+		"Struct:store.go:Store":  "Store holds keys",
+		"Struct:store.go:Pair":   "Pair is two keys",
+		"Struct:store.go:Other":  "",
+	}
+	for key, doc := range want {
+		if docs[key] != doc {
+			t.Errorf("%s doc = %q, want %q", key, docs[key], doc)
+		}
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	src := `package p
+
+func straight() { println() }
+
+func branches(xs []int, ok bool) int {
+	n := 0
+	for _, x := range xs {
+		if x > 0 && ok || x < -10 {
+			n++
+		}
+	}
+	switch n {
+	case 0, 1:
+		return 0
+	default:
+	}
+	select {
+	case <-make(chan int):
+	default:
+	}
+	f := func() {
+		if ok {
+		}
+	}
+	f()
+	return n
+}
+`
+	graph, err := Parser{}.ParseFile("p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Functions) != 2 {
+		t.Fatalf("functions = %+v", graph.Functions)
+	}
+	want := map[string]int{"straight": 1, "branches": 8}
+	for _, fn := range graph.Functions {
+		if fn.Complexity != want[fn.Name] {
+			t.Errorf("%s complexity = %d, want %d", fn.Name, fn.Complexity, want[fn.Name])
+		}
+	}
+}
+
+func TestStub(t *testing.T) {
+	src := `package p
+
+import "errors"
+
+func empty()                  {}
+func zero() (int, string, error) { return 0, "", nil }
+func unsupported() error       { return errors.New("not supported") }
+func missing() []int           { panic("unimplemented") }
+func literal() (T, bool)       { return T{}, false }
+func real() int                { return 1 }
+func work() error {
+	println()
+	return nil
+}
+`
+	graph, err := Parser{}.ParseFile("p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"empty": true, "zero": true, "unsupported": true, "missing": true, "literal": true}
+	for _, fn := range graph.Functions {
+		if fn.Stub != want[fn.Name] {
+			t.Errorf("%s stub = %v, want %v", fn.Name, fn.Stub, want[fn.Name])
+		}
+	}
+}
+
+// generatedTree is a module of n files, ten to a package, each declaring a
+// struct with a method and functions calling each other and the package
+// before
+func generatedTree(n int) map[string]string {
+	files := map[string]string{"go.mod": "module example.com/gen\n\ngo 1.22\n"}
+	for i := range n {
+		pkg := fmt.Sprintf("p%d", i/10)
+		var src strings.Builder
+		fmt.Fprintf(&src, "package %s\n\n", pkg)
+		if i >= 10 {
+			fmt.Fprintf(&src, "import prev \"example.com/gen/p%d\"\n\n", i/10-1)
+		}
+		fmt.Fprintf(&src, "type T%d struct{ n int }\n\nfunc (t *T%d) Get() int { return t.n }\n\n", i, i)
+		for f := range 5 {
+			fmt.Fprintf(&src, "func F%d_%d(x int) int {\n\tif x > 0 {\n\t\treturn F%d_%d(x - 1)\n\t}\n", i, f, i, (f+1)%5)
+			if i >= 10 {
+				fmt.Fprintf(&src, "\tprev.F%d_0(x)\n", (i/10-1)*10)
+			}
+			fmt.Fprintf(&src, "\treturn (&T%d{}).Get()\n}\n\n", i)
+		}
+		files[fmt.Sprintf("%s/f%d.go", pkg, i)] = src.String()
+	}
+	return files
+}
+
+// heapAlloc returns the bytes of live heap objects after a collection
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// peakHeap samples the live heap until the returned function is called,
+// which returns the most it saw
+func peakHeap() func() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	done := make(chan uint64)
+	stop := make(chan struct{})
+	go func() {
+		var peak uint64
+		for {
+			metrics.Read(sample)
+			peak = max(peak, sample[0].Value.Uint64())
+			select {
+			case <-stop:
+				done <- peak
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	return func() uint64 {
+		close(stop)
+		return <-done
+	}
+}
+
+// BenchmarkParseMemory reports, for trees of growing size and per symbol,
+// how far the heap grows while parsing, what the parsed graph keeps of it
+// and how far the heap grows past that while the graph's statements are
+// streamed. None falls as the tree grows: type-checking holds every
+// package's syntax and types at once, the graph is held whole, and each
+// statement carries the rows of every node of a label. Memory is bounded
+// by the size of the repository, not by a constant.
+func BenchmarkParseMemory(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		root := writeTree(b, generatedTree(n))
+		for _, syntaxOnly := range []bool{true, false} {
+			b.Run(fmt.Sprintf("files=%d/syntax-only=%v", n, syntaxOnly), func(b *testing.B) {
+				var parseBytes, graphBytes, streamBytes, symbols float64
+				for b.Loop() {
+					before := heapAlloc()
+					peak := peakHeap()
+					graph, err := Parser{Root: root, SyntaxOnly: syntaxOnly}.Parse(context.Background())
+					if err != nil {
+						b.Fatal(err)
+					}
+					parsePeak := peak()
+					parsed := heapAlloc()
+					var streamPeak uint64
+					for range StreamStatements(context.Background(), "App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+						streamPeak = max(streamPeak, heapAlloc())
+					}
+					symbols = float64(len(graph.Functions) + len(graph.Structs) + len(graph.Interfaces))
+					parseBytes = float64(parsePeak - min(parsePeak, before))
+					graphBytes = float64(parsed - before)
+					streamBytes = float64(streamPeak - min(streamPeak, parsed))
+					runtime.KeepAlive(graph)
+				}
+				b.ReportMetric(parseBytes/symbols, "parse-B/symbol")
+				b.ReportMetric(graphBytes/symbols, "graph-B/symbol")
+				b.ReportMetric(streamBytes/symbols, "stream-B/symbol")
+			})
+		}
+	}
+}
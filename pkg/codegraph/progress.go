@@ -0,0 +1,21 @@
+package codegraph
+
+// Progress is told how far a long step, such as parsing files or writing
+// rows, has got
+type Progress interface {
+	// Add records n more units done; it must be safe for concurrent use
+	Add(n int)
+	// Finish is called once when the step ends
+	Finish()
+}
+
+// StartProgress is called when a step of total units begins. By default
+// progress is not reported; programs replace it to draw a bar or log.
+var StartProgress = func(name, unit string, total int) Progress {
+	return noProgress{}
+}
+
+type noProgress struct{}
+
+func (noProgress) Add(int) {}
+func (noProgress) Finish() {}
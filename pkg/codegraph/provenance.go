@@ -0,0 +1,112 @@
+package codegraph
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Provenance is who or what wrote a memory: the way it came in, the client
+// or agent that sent it, the model behind that agent and the user it ran
+// for, each empty if unknown. Recall can keep to a provenance, and a
+// Ranking can trust some less, so memories from a noisy hook or a weaker
+// model can be down-weighted or purged.
+type Provenance struct {
+	Via   string `json:"via,omitempty"`
+	Agent string `json:"agent,omitempty"`
+	Model string `json:"model,omitempty"`
+	User  string `json:"user,omitempty"`
+}
+
+// The ways a memory comes in, for Provenance.Via
+const (
+	ViaCLI         = "cli"
+	ViaHook        = "hook"
+	ViaMCP         = "mcp"
+	ViaHTTP        = "http"
+	ViaConsolidate = "consolidate"
+)
+
+// ErrInvalidProvenance is returned for a provenance filter or trust weight
+// that does not parse
+var ErrInvalidProvenance = errors.New("invalid provenance")
+
+// pairs returns the FIELD=VALUE pairs of the fields set, such as via=hook
+func (p Provenance) pairs() []string {
+	var pairs []string
+	for _, field := range []struct{ name, value string }{{"via", p.Via}, {"agent", p.Agent}, {"model", p.Model}, {"user", p.User}} {
+		if field.value != "" {
+			pairs = append(pairs, field.name+"="+field.value)
+		}
+	}
+	return pairs
+}
+
+// set sets the field named by a FIELD=VALUE pair
+func (p *Provenance) set(pair string) error {
+	field, value, _ := strings.Cut(pair, "=")
+	if value == "" {
+		return fmt.Errorf("%w: %q is not FIELD=VALUE", ErrInvalidProvenance, pair)
+	}
+	switch field {
+	case "via":
+		p.Via = value
+	case "agent":
+		p.Agent = value
+	case "model":
+		p.Model = value
+	case "user":
+		p.User = value
+	default:
+		return fmt.Errorf("%w: unknown field %q, expected via, agent, model or user", ErrInvalidProvenance, field)
+	}
+	return nil
+}
+
+// ParseProvenance parses FIELD=VALUE pairs, such as via=hook or
+// model=claude-haiku, into a provenance to recall or purge memories by
+func ParseProvenance(pairs []string) (Provenance, error) {
+	var p Provenance
+	for _, pair := range pairs {
+		if err := p.set(pair); err != nil {
+			return Provenance{}, err
+		}
+	}
+	return p, nil
+}
+
+// ParseTrust parses FIELD=VALUE:WEIGHT entries, such as via=hook:0.5, into
+// the Trust of a Ranking, nil for none. Weights are from 0 to 1.
+func ParseTrust(entries []string) (map[string]float64, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	trust := make(map[string]float64, len(entries))
+	for _, entry := range entries {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: trust %q is not FIELD=VALUE:WEIGHT", ErrInvalidProvenance, entry)
+		}
+		weight, err := strconv.ParseFloat(entry[i+1:], 64)
+		if err != nil || weight < 0 || weight > 1 {
+			return nil, fmt.Errorf("%w: trust %q needs a weight from 0 to 1", ErrInvalidProvenance, entry)
+		}
+		var p Provenance
+		if err := p.set(entry[:i]); err != nil {
+			return nil, err
+		}
+		trust[p.pairs()[0]] = weight
+	}
+	return trust, nil
+}
+
+// provenance reads a Provenance from the via, agent, model and user
+// columns of a row
+func provenance(columns []any) Provenance {
+	via, _ := columns[0].(string)
+	agent, _ := columns[1].(string)
+	model, _ := columns[2].(string)
+	user, _ := columns[3].(string)
+	return Provenance{Via: via, Agent: agent, Model: model, User: user}
+}
@@ -0,0 +1,70 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseProvenance(t *testing.T) {
+	p, err := ParseProvenance([]string{"via=hook", "model=claude-haiku"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Provenance{Via: "hook", Model: "claude-haiku"}); p != want {
+		t.Errorf("provenance = %+v, want %+v", p, want)
+	}
+	for _, invalid := range []string{"hook", "via=", "host=db1"} {
+		if _, err := ParseProvenance([]string{invalid}); !errors.Is(err, ErrInvalidProvenance) {
+			t.Errorf("ParseProvenance(%q) err = %v, want ErrInvalidProvenance", invalid, err)
+		}
+	}
+}
+
+func TestParseTrust(t *testing.T) {
+	trust, err := ParseTrust([]string{"via=hook:0.5", "agent=bot:1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"via=hook": 0.5, "agent=bot:1": 0}; !reflect.DeepEqual(trust, want) {
+		t.Errorf("trust = %v, want %v", trust, want)
+	}
+	if trust, err := ParseTrust(nil); trust != nil || err != nil {
+		t.Errorf("no trust = %v, %v", trust, err)
+	}
+	for _, invalid := range []string{"via=hook", "via=hook:2", "via=hook:x", "host=db1:0.5"} {
+		if _, err := ParseTrust([]string{invalid}); !errors.Is(err, ErrInvalidProvenance) {
+			t.Errorf("ParseTrust(%q) err = %v, want ErrInvalidProvenance", invalid, err)
+		}
+	}
+}
+
+func TestRankingTrust(t *testing.T) {
+	now := time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)
+	ranking := DefaultRanking
+	ranking.Trust = map[string]float64{"via=hook": 0.5, "model=small": 0.5}
+	memories := []Memory{
+		{ID: "mem-3", CreatedAt: now, Provenance: Provenance{Via: ViaHook, Model: "small"}},
+		{ID: "mem-2", CreatedAt: now, Provenance: Provenance{Via: ViaHook}},
+		{ID: "mem-1", CreatedAt: now, Provenance: Provenance{Via: ViaCLI, Model: "large"}},
+	}
+	if got, want := ranking.Score(memories[0], now), DefaultRanking.Score(memories[0], now)/4; got != want {
+		t.Errorf("score = %v, want %v", got, want)
+	}
+	ranking.Rank(memories, now)
+	if memories[0].ID != "mem-1" || memories[2].ID != "mem-3" {
+		t.Errorf("ranked %+v", memories)
+	}
+}
+
+func TestCypherRecallProvenance(t *testing.T) {
+	q := &recordingQuerier{}
+	if _, err := cypherRecall(context.Background(), q, LabelMap{}, "App", MemoryQuery{Provenance: Provenance{Via: ViaHook, Agent: "claude-code"}}); err != nil {
+		t.Fatal(err)
+	}
+	if params := q.params[0]; params["via"] != "hook" || params["agent"] != "claude-code" || params["model"] != "" || params["user"] != "" {
+		t.Errorf("recalled with %v", params)
+	}
+}
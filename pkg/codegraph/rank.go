@@ -0,0 +1,113 @@
+package codegraph
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ranking orders recalled memories by how relevant, important and
+// trustworthy they are: a memory scores its relevance by Decay times its
+// importance to the power Importance and its confidence to the power
+// Confidence, so a weight of 0 ignores that field and a higher one makes
+// it count more. Pinned memories come first whatever their score. Trust
+// weighs memories by provenance: the score is multiplied by the weight of
+// each FIELD=VALUE pair of the memory's provenance listed, such as
+// via=hook, so memories from less trusted sources rank lower.
+type Ranking struct {
+	Decay
+	Importance float64
+	Confidence float64
+	Trust      map[string]float64
+}
+
+// DefaultRanking weighs relevance, halving over 90 days, importance and
+// confidence alike
+var DefaultRanking = Ranking{Decay: Decay{HalfLife: 90 * 24 * time.Hour}, Importance: 1, Confidence: 1}
+
+// Score returns m's rank score at now, between 0 and 1. Memories without
+// an importance or confidence score as if they had the defaults.
+func (r Ranking) Score(m Memory, now time.Time) float64 {
+	importance := cmp.Or(m.Importance, DefaultImportance)
+	confidence := cmp.Or(m.Confidence, DefaultConfidence)
+	score := r.Decay.Score(m, now) * math.Pow(importance, r.Importance) * math.Pow(confidence, r.Confidence)
+	for _, pair := range m.Provenance.pairs() {
+		if weight, ok := r.Trust[pair]; ok {
+			score *= weight
+		}
+	}
+	return score
+}
+
+// Rank sorts memories pinned first, then highest score at now first, then
+// newest first
+func (r Ranking) Rank(memories []Memory, now time.Time) {
+	scores := make(map[string]float64, len(memories))
+	for _, m := range memories {
+		scores[m.ID] = r.Score(m, now)
+	}
+	slices.SortStableFunc(memories, func(a, b Memory) int {
+		if a.Pinned != b.Pinned {
+			if a.Pinned {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(scores[b.ID], scores[a.ID]), strings.Compare(b.ID, a.ID))
+	})
+}
+
+// questionStopWords are the words of a question too common to match on
+var questionStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "can": true, "do": true, "does": true, "for": true,
+	"how": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true, "the": true,
+	"this": true, "to": true, "what": true, "when": true, "where": true, "which": true, "who": true, "why": true,
+}
+
+// relevance returns the share of question's words that m's text, tags or
+// keys contain, a word matching another starting with the same four or
+// more letters so locks matches locking
+func relevance(m Memory, question map[string]bool) float64 {
+	words := wordSet(strings.Join(append(append([]string{m.Text}, m.Tags...), m.About...), " "))
+	matched := 0
+	for q := range question {
+		if words[q] {
+			matched++
+			continue
+		}
+		for word := range words {
+			if len(q) >= 4 && len(word) >= 4 && q[:4] == word[:4] {
+				matched++
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(question))
+}
+
+// matchQuestion returns the memories relevant to question, most relevant
+// first and otherwise in the order given. A question of only stop words
+// matches every memory.
+func matchQuestion(memories []Memory, question string) []Memory {
+	words := wordSet(question)
+	for word := range words {
+		if questionStopWords[word] {
+			delete(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return memories
+	}
+	scores := make(map[string]float64, len(memories))
+	matched := make([]Memory, 0, len(memories))
+	for _, m := range memories {
+		if score := relevance(m, words); score > 0 {
+			scores[m.ID] = score
+			matched = append(matched, m)
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b Memory) int { return cmp.Compare(scores[b.ID], scores[a.ID]) })
+	return matched
+}
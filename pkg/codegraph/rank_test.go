@@ -0,0 +1,80 @@
+package codegraph
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRankingScore(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	r := Ranking{Decay: Decay{HalfLife: 30 * 24 * time.Hour}, Importance: 1, Confidence: 2}
+	tests := []struct {
+		name   string
+		memory Memory
+		want   float64
+	}{
+		{"defaults", Memory{CreatedAt: now}, DefaultImportance},
+		{"important", Memory{CreatedAt: now, Importance: 1}, 1},
+		{"doubtful", Memory{CreatedAt: now, Importance: 1, Confidence: 0.5}, 0.25},
+		{"old", Memory{CreatedAt: now.AddDate(0, 0, -30), Importance: 1}, 0.5},
+		{"expired", Memory{CreatedAt: now, ExpiresAt: now, Importance: 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := r.Score(tt.memory, now); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: score = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// Without weights only relevance counts
+	if got := (Ranking{}).Score(Memory{CreatedAt: now, Importance: 0.1, Confidence: 0.1}, now); got != 1 {
+		t.Errorf("unweighted score = %v, want 1", got)
+	}
+}
+
+func TestRankingRank(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	memories := []Memory{
+		{ID: "mem-1", CreatedAt: now, Importance: 0.2},
+		{ID: "mem-2", CreatedAt: now, Importance: 0.9},
+		{ID: "mem-3", CreatedAt: now.AddDate(-1, 0, 0), Importance: 0.1, Pinned: true},
+		{ID: "mem-4", CreatedAt: now, Importance: 0.2},
+		{ID: "mem-5", CreatedAt: now, Importance: 0.9, Confidence: 0.1},
+	}
+	DefaultRanking.Rank(memories, now)
+	var ids []string
+	for _, m := range memories {
+		ids = append(ids, m.ID)
+	}
+	// Pinned first, then by score, the newest first on a tie
+	if want := []string{"mem-3", "mem-2", "mem-4", "mem-1", "mem-5"}; !slices.Equal(ids, want) {
+		t.Errorf("ranked %q, want %q", ids, want)
+	}
+}
+
+func TestMatchQuestion(t *testing.T) {
+	memories := []Memory{
+		{ID: "mem-4", Text: "main exits 2 on bad flags", About: []string{"File:main.go"}},
+		{ID: "mem-3", Text: "holds the lock across fsync", About: []string{"Function:store.go:*Store.Put"}},
+		{ID: "mem-2", Text: "flaky under load", Tags: []string{"locking"}, About: []string{"Package:store"}},
+		{ID: "mem-1", Text: "Put is slow", About: []string{"Function:store.go:*Store.Put"}},
+	}
+	ids := func(memories []Memory) []string {
+		var ids []string
+		for _, m := range memories {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+	// Put matches by key, and locks the text's lock and the tag locking;
+	// mem-2 and mem-1 each match one word, so keep their order
+	if got, want := ids(matchQuestion(memories, "Why does Put take locks?")), []string{"mem-3", "mem-2", "mem-1"}; !slices.Equal(got, want) {
+		t.Errorf("matched %q, want %q", got, want)
+	}
+	if got := matchQuestion(memories, "what is it?"); len(got) != len(memories) {
+		t.Errorf("a question of stop words matched %q", ids(got))
+	}
+	if got := matchQuestion(memories, "retries"); got == nil || len(got) != 0 {
+		t.Errorf("matched %q, want none", ids(got))
+	}
+}
@@ -0,0 +1,124 @@
+package codegraph
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RedactRule replaces every match of Pattern in a memory's text with
+// [REDACTED:Name] before the memory is stored
+type RedactRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultRedactRules catch the secrets and personal data most often pasted
+// into notes: cloud and service API keys, tokens, private keys, assigned
+// passwords and email addresses. Internal hostnames differ by company, so
+// are left to rules of one's own.
+var DefaultRedactRules = []RedactRule{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{"aws-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_\w{22,})\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"api-key", regexp.MustCompile(`\bsk-(?:proj-|ant-)?[A-Za-z0-9_-]{20,}`)},
+	{"bearer-token", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`)},
+	{"secret", regexp.MustCompile(`(?i)\b(?:password|passwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret)\b\s*[:=]\s*["']?[^\s"']{8,}`)},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+}
+
+// RedactedTag tags the memories whose text a rule changed
+const RedactedTag = "redacted"
+
+// ParseRedactRule parses a rule written NAME=REGEX
+func ParseRedactRule(s string) (RedactRule, error) {
+	name, pattern, ok := strings.Cut(s, "=")
+	if !ok || !validRuleName.MatchString(name) || pattern == "" {
+		return RedactRule{}, fmt.Errorf("redaction rule %q is not NAME=REGEX", s)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return RedactRule{}, fmt.Errorf("redaction rule %s: %w", name, err)
+	}
+	return RedactRule{Name: name, Pattern: re}, nil
+}
+
+// validRuleName matches the names a rule can have, which end up in
+// memory text
+var validRuleName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Redact returns text with the matches of each rule replaced in turn, and
+// the names of the rules that matched
+func Redact(rules []RedactRule, text string) (string, []string) {
+	var matched []string
+	for _, rule := range rules {
+		if !rule.Pattern.MatchString(text) {
+			continue
+		}
+		text = rule.Pattern.ReplaceAllLiteralString(text, "[REDACTED:"+rule.Name+"]")
+		matched = append(matched, rule.Name)
+	}
+	return text, matched
+}
+
+// RedactMemory returns memory with its text redacted by rules and, if that
+// changed it, tagged RedactedTag
+func RedactMemory(rules []RedactRule, memory Memory) Memory {
+	text, matched := Redact(rules, memory.Text)
+	if len(matched) == 0 {
+		return memory
+	}
+	memory.Text = text
+	if !slices.Contains(memory.Tags, RedactedTag) {
+		memory.Tags = append(slices.Clone(memory.Tags), RedactedTag)
+	}
+	return memory
+}
+
+// RedactDecision returns decision with its title, rationale and
+// alternatives redacted by rules, marked Redacted if that changed any
+func RedactDecision(rules []RedactRule, decision Decision) Decision {
+	redact := func(text string) string {
+		text, matched := Redact(rules, text)
+		decision.Redacted = decision.Redacted || len(matched) > 0
+		return text
+	}
+	decision.Title, decision.Rationale = redact(decision.Title), redact(decision.Rationale)
+	if decision.Alternatives != nil {
+		alternatives := make([]string, len(decision.Alternatives))
+		for i, alt := range decision.Alternatives {
+			alternatives[i] = redact(alt)
+		}
+		decision.Alternatives = alternatives
+	}
+	return decision
+}
+
+// redactDecisions returns decisions, each redacted by rules
+func redactDecisions(rules []RedactRule, decisions []Decision) []Decision {
+	redacted := make([]Decision, len(decisions))
+	for i, decision := range decisions {
+		redacted[i] = RedactDecision(rules, decision)
+	}
+	return redacted
+}
+
+// RedactSummary returns summary with its text redacted by rules, marked
+// Redacted if that changed it
+func RedactSummary(rules []RedactRule, summary Summary) Summary {
+	text, matched := Redact(rules, summary.Text)
+	summary.Text = text
+	summary.Redacted = summary.Redacted || len(matched) > 0
+	return summary
+}
+
+// redactMemories returns memories, each redacted by rules
+func redactMemories(rules []RedactRule, memories []Memory) []Memory {
+	redacted := make([]Memory, len(memories))
+	for i, memory := range memories {
+		redacted[i] = RedactMemory(rules, memory)
+	}
+	return redacted
+}
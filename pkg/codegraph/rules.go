@@ -0,0 +1,73 @@
+package codegraph
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ImportRule is a layering rule over the packages a project's files
+// import, written in one of two forms:
+//
+//	pkg/domain must not import pkg/http
+//	only pkg/store may import database/sql
+//
+// The first forbids the files of From from importing To; the second, with
+// Only set, forbids every file outside From. Either package may be a path
+// relative to the project root or an import path, and takes in the
+// packages below it.
+type ImportRule struct {
+	Text string
+	From string
+	To   string
+	Only bool
+}
+
+// RuleViolation is the kind of finding CheckRules reports
+const RuleViolation = "rule-violation"
+
+// ParseImportRule parses a rule written like those of ImportRule
+func ParseImportRule(s string) (ImportRule, error) {
+	words := strings.Fields(s)
+	rule := ImportRule{Text: strings.Join(words, " ")}
+	switch {
+	case len(words) == 5 && words[1] == "must" && words[2] == "not" && words[3] == "import":
+		rule.From, rule.To = words[0], words[4]
+	case len(words) == 5 && words[0] == "only" && words[2] == "may" && words[3] == "import":
+		rule.From, rule.To, rule.Only = words[1], words[4], true
+	default:
+		return ImportRule{}, fmt.Errorf(`rule %q is not "PACKAGE must not import PACKAGE" or "only PACKAGE may import PACKAGE"`, s)
+	}
+	return rule, nil
+}
+
+// CheckRules returns a finding for every import of a file in g that breaks
+// one of rules, in the order of the files and then the rules
+func CheckRules(g *Graph, rules []ImportRule) []Finding {
+	var findings []Finding
+	for _, file := range g.Files {
+		for _, rule := range rules {
+			if inPackage(filepath.ToSlash(file.Path), strings.Trim(rule.From, "/")) == rule.Only {
+				continue
+			}
+			for _, imp := range file.Imports {
+				if !importsPackage(imp, rule.To) {
+					continue
+				}
+				findings = append(findings, Finding{
+					Kind: RuleViolation, Key: file.Key(), Name: file.Path, File: file.Path,
+					Message: fmt.Sprintf("%s imports %s, breaking the rule %q", file.Path, imp, rule.Text),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// importsPackage reports whether an import path is pattern or below it. A
+// pattern relative to the project root matches after the module path.
+func importsPackage(path, pattern string) bool {
+	pattern = strings.Trim(pattern, "/")
+	return path == pattern || strings.HasPrefix(path, pattern+"/") ||
+		strings.HasSuffix(path, "/"+pattern) || strings.Contains(path, "/"+pattern+"/")
+}
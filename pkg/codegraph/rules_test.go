@@ -0,0 +1,55 @@
+package codegraph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseImportRule(t *testing.T) {
+	for s, want := range map[string]ImportRule{
+		"pkg/domain must not import pkg/http":       {Text: "pkg/domain must not import pkg/http", From: "pkg/domain", To: "pkg/http"},
+		"  only pkg/store  may import database/sql": {Text: "only pkg/store may import database/sql", From: "pkg/store", To: "database/sql", Only: true},
+	} {
+		if rule, err := ParseImportRule(s); err != nil || rule != want {
+			t.Errorf("%q = %+v, %v, want %+v", s, rule, err, want)
+		}
+	}
+	for _, s := range []string{"", "pkg/domain must import pkg/http", "only pkg/store may import", "pkg/a must not import pkg/b pkg/c"} {
+		if _, err := ParseImportRule(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	graph := &Graph{Files: []FileNode{
+		{Path: "pkg/domain/user.go", Imports: []string{"example.com/app/pkg/http/router", "strings"}},
+		{Path: "pkg/domain/order.go", Imports: []string{"example.com/app/pkg/httpclient"}},
+		{Path: "pkg/store/sql.go", Imports: []string{"database/sql"}},
+		{Path: "pkg/store/cache/cache.go", Imports: []string{"database/sql"}},
+		{Path: "cmd/app/main.go", Imports: []string{"database/sql", "example.com/app/pkg/http"}},
+	}}
+	var rules []ImportRule
+	for _, s := range []string{"pkg/domain must not import pkg/http", "only pkg/store may import database/sql"} {
+		rule, err := ParseImportRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	var got []string
+	for _, finding := range CheckRules(graph, rules) {
+		if finding.Kind != RuleViolation || finding.Key != (FileNode{Path: finding.File}).Key() {
+			t.Errorf("finding = %+v", finding)
+		}
+		got = append(got, finding.Message)
+	}
+	want := []string{
+		`pkg/domain/user.go imports example.com/app/pkg/http/router, breaking the rule "pkg/domain must not import pkg/http"`,
+		`cmd/app/main.go imports database/sql, breaking the rule "only pkg/store may import database/sql"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+package codegraph
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SearchQuery is a semantic search: the embedded nodes closest to Vector,
+// only those in Package or below it if set, at most Limit of them, each
+// with up to Callers of its callers
+type SearchQuery struct {
+	Vector  []float64
+	Package string
+	Limit   int
+	Callers int
+}
+
+// SearchResult is a node found by a semantic search, with the graph around
+// it. Score is its Similarity raised a little by how many callers it has,
+// so among equally close matches the code the project relies on ranks
+// first.
+type SearchResult struct {
+	Key         string   `json:"key"`
+	Kind        string   `json:"kind"`
+	Name        string   `json:"name"`
+	File        string   `json:"file"`
+	Package     string   `json:"package"`
+	Signature   string   `json:"signature,omitempty"`
+	LineStart   int      `json:"lineStart,omitempty"`
+	LineEnd     int      `json:"lineEnd,omitempty"`
+	Similarity  float64  `json:"similarity"`
+	Score       float64  `json:"score"`
+	CallerCount int      `json:"callerCount"`
+	Callers     []string `json:"callers"`
+	Snippet     string   `json:"snippet,omitempty"`
+}
+
+// searchCallerWeight is how much each doubling of a node's callers adds to
+// its score, small enough that similarity still dominates
+const searchCallerWeight = 0.02
+
+// searchCandidates is how many nodes the vector index is asked for per
+// result, to have some left after filtering by package and re-ranking
+const searchCandidates = 4
+
+// inPackage reports whether file is in package dir or below it
+func inPackage(file, dir string) bool {
+	fileDir := path.Dir(file)
+	return dir == "" || fileDir == dir || dir != "." && strings.HasPrefix(fileDir, dir+"/")
+}
+
+// cypherSearch finds the nodes closest to sq.Vector, then reads their
+// signatures, lines, fields and callers to rank and annotate them
+func cypherSearch(ctx context.Context, q Querier, labels LabelMap, project string, sq SearchQuery) ([]SearchResult, error) {
+	sq.Limit = cmp.Or(sq.Limit, 10)
+	nodes, err := cypherSimilarNodes(ctx, q, labels, project, sq.Vector, sq.Limit*searchCandidates)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(nodes))
+	rows := make([]any, 0, len(nodes))
+	for _, node := range nodes {
+		if !inPackage(node.File, sq.Package) {
+			continue
+		}
+		_, props, err := ParseKey(node.Key)
+		if err != nil {
+			continue
+		}
+		receiver, _ := props["receiver"].(string)
+		results = append(results, SearchResult{
+			Key: node.Key, Kind: node.Kind, Name: node.Name, File: node.File,
+			Package: path.Dir(node.File), Similarity: node.Score, Callers: []string{},
+		})
+		rows = append(rows, map[string]any{"key": node.Key, "file": node.File, "name": node.Name, "receiver": receiver})
+	}
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	_, contexts, err := q.Query(ctx, fmt.Sprintf(`
+		UNWIND $rows AS row
+		MATCH (n:%[1]s {file: row.file, name: row.name})
+		WHERE (n:%[2]s OR n:%[3]s OR n:%[4]s) AND coalesce(n.receiver, '') = row.receiver
+		OPTIONAL MATCH (c:%[1]s)-[:CALLS]->(n)
+		WHERE NOT coalesce(c.deleted, false)
+		WITH row, n, collect(DISTINCT c) AS callers
+		RETURN row.key AS key, n.signature AS signature, n.lineStart AS lineStart, n.lineEnd AS lineEnd,
+		       n.fields AS fields, size(callers) AS callerCount,
+		       [c IN callers[..$callers] | {file: c.file, name: c.name, receiver: c.receiver}] AS callers
+	`, project, labels.Label("Function"), labels.Label("Method"), labels.Label("Struct")),
+		map[string]any{"rows": rows, "callers": sq.Callers})
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]int, len(results))
+	for i, result := range results {
+		byKey[result.Key] = i
+	}
+	for _, row := range contexts {
+		if len(row) < 7 {
+			continue
+		}
+		i, ok := byKey[fmt.Sprint(row[0])]
+		if !ok {
+			continue
+		}
+		r := &results[i]
+		r.Signature, _ = row[1].(string)
+		r.LineStart, r.LineEnd = intValue(row[2]), intValue(row[3])
+		if r.Kind == "Struct" {
+			r.Snippet = StructNode{Name: r.Name, Fields: stringList(row[4])}.definition()
+		}
+		r.CallerCount = intValue(row[5])
+		callers, _ := row[6].([]any)
+		for _, caller := range callers {
+			props, _ := caller.(map[string]any)
+			name, _ := props["name"].(string)
+			file, _ := props["file"].(string)
+			receiver, _ := props["receiver"].(string)
+			r.Callers = append(r.Callers, FunctionNode{Name: name, File: file, Receiver: receiver}.Key())
+		}
+		slices.Sort(r.Callers)
+	}
+
+	for i := range results {
+		results[i].Score = results[i].Similarity + searchCallerWeight*math.Log2(1+float64(results[i].CallerCount))
+	}
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Key, b.Key))
+	})
+	return results[:min(len(results), sq.Limit)], nil
+}
+
+// AddSnippets sets the Snippet of each result with lines but no snippet yet
+// to its source, read from the tree at root, cut to maxLines lines. Results
+// whose file cannot be read, such as one deleted since it was indexed, are
+// left without.
+func AddSnippets(root string, results []SearchResult, maxLines int) {
+	files := make(map[string][]string)
+	for i := range results {
+		r := &results[i]
+		if r.Snippet != "" || r.LineStart <= 0 || maxLines <= 0 {
+			continue
+		}
+		lines, ok := files[r.File]
+		if !ok {
+			lines = readLines(filepath.Join(root, filepath.FromSlash(r.File)))
+			files[r.File] = lines
+		}
+		if r.LineStart > len(lines) {
+			continue
+		}
+		end := min(max(r.LineEnd, r.LineStart), len(lines), r.LineStart+maxLines-1)
+		snippet := strings.Join(lines[r.LineStart-1:end], "\n")
+		if end < r.LineEnd {
+			snippet += "\n\t..."
+		}
+		r.Snippet = snippet
+	}
+}
+
+// readLines returns the lines of the file at name, or nil if it cannot be
+// read
+func readLines(name string) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
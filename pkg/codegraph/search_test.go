@@ -0,0 +1,98 @@
+package codegraph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCypherSearch(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasPrefix(query, "CALL db.index.vector.queryNodes") {
+			return [][]any{
+				{[]any{"App", "Function"}, "run", "main.go", "", 0.9},
+				{[]any{"App", "Method"}, "Put", "store/store.go", "*Store", 0.89},
+				{[]any{"App", "Struct"}, "Store", "store/store.go", nil, 0.8},
+				{[]any{"App", "Function"}, "helper", "store/internal/helper.go", "", 0.7},
+			}
+		}
+		return [][]any{
+			{"Function:main.go:run", "func run() error", int64(10), int64(20), nil, int64(0), []any{}},
+			{"Function:store/store.go:*Store.Put", "func (s *Store) Put(key string)", int64(5), int64(9), nil, int64(7), []any{
+				map[string]any{"file": "main.go", "name": "run", "receiver": ""},
+				map[string]any{"file": "main.go", "name": "main", "receiver": nil},
+			}},
+			{"Struct:store/store.go:Store", nil, nil, nil, []any{"keys []string"}, int64(0), []any{}},
+		}
+	}}
+	results, err := cypherSearch(context.Background(), q, LabelMap{}, "App", SearchQuery{Vector: []float64{1, 0}, Limit: 2, Callers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put's seven callers lift it above run
+	want := []SearchResult{
+		{
+			Key: "Function:store/store.go:*Store.Put", Kind: "Method", Name: "Put", File: "store/store.go", Package: "store",
+			Signature: "func (s *Store) Put(key string)", LineStart: 5, LineEnd: 9, Similarity: 0.89, Score: 0.89 + 0.02*3,
+			CallerCount: 7, Callers: []string{"Function:main.go:main", "Function:main.go:run"},
+		},
+		{
+			Key: "Function:main.go:run", Kind: "Function", Name: "run", File: "main.go", Package: ".",
+			Signature: "func run() error", LineStart: 10, LineEnd: 20, Similarity: 0.9, Score: 0.9, Callers: []string{},
+		},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v\nwant %+v", results, want)
+	}
+	if params := q.params[0]; params["limit"] != 8 {
+		t.Errorf("asked the index for %v, want 8 candidates", params)
+	}
+	if params := q.params[1]; params["callers"] != 2 || len(params["rows"].([]any)) != 4 {
+		t.Errorf("read context with %v", params)
+	}
+
+	q.queries, q.params = nil, nil
+	results, err = cypherSearch(context.Background(), q, LabelMap{}, "App", SearchQuery{Vector: []float64{1, 0}, Package: "store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	if want := []string{"Function:store/store.go:*Store.Put", "Struct:store/store.go:Store", "Function:store/internal/helper.go:helper"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("in store: %q, want %q", keys, want)
+	}
+	if want := "type Store struct {\n\tkeys []string\n}"; results[1].Snippet != want {
+		t.Errorf("Store snippet = %q, want %q", results[1].Snippet, want)
+	}
+}
+
+func TestAddSnippets(t *testing.T) {
+	root := t.TempDir()
+	src := "package main\n\nfunc run() error {\n\tstep1()\n\tstep2()\n\treturn nil\n}\n"
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results := []SearchResult{
+		{File: "main.go", LineStart: 3, LineEnd: 7},
+		{File: "main.go", LineStart: 3, LineEnd: 4},
+		{File: "gone.go", LineStart: 1, LineEnd: 2},
+		{File: "main.go", Snippet: "type Store struct {\n}"},
+	}
+	AddSnippets(root, results, 3)
+	want := []string{
+		"func run() error {\n\tstep1()\n\tstep2()\n\t...",
+		"func run() error {\n\tstep1()",
+		"",
+		"type Store struct {\n}",
+	}
+	for i, r := range results {
+		if r.Snippet != want[i] {
+			t.Errorf("snippet %d = %q, want %q", i, r.Snippet, want[i])
+		}
+	}
+}
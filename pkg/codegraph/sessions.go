@@ -0,0 +1,217 @@
+package codegraph
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// SessionStore is implemented by backends that record work sessions, such
+// as a Claude Code session: the memories made and the files touched
+// between a session's start and end, so past work can be replayed
+type SessionStore interface {
+	// StartSession stores a new session, assigning its ID and StartedAt,
+	// or returns the one stored with the same AgentSession if it has one
+	StartSession(ctx context.Context, project string, session Session) (Session, error)
+	// EndSession records the end of a session, if it had not ended yet
+	EndSession(ctx context.Context, project, id string) (Session, error)
+	// TouchFiles records files, by path, as touched during a session
+	TouchFiles(ctx context.Context, project, id string, paths []string) error
+	// Sessions lists the sessions, newest first, at most limit if positive
+	Sessions(ctx context.Context, project string, limit int) ([]Session, error)
+	// Replay returns a session with the memories made during it, oldest
+	// first, of namespaces like MemoryQuery.Namespaces
+	Replay(ctx context.Context, project, id string, namespaces []string) (Session, []Memory, error)
+}
+
+// Session is a period of work on a project: where and on which branch it
+// happened, the files it touched and how many memories it recorded
+type Session struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt,omitzero"`
+	Dir       string    `json:"dir,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Touched   []string  `json:"touched,omitempty"`
+	Memories  int       `json:"memories"`
+
+	// AgentSession is the ID the agent knows the session by, such as the
+	// session_id of a Claude Code hook event, if it was started for one
+	AgentSession string `json:"agentSession,omitempty"`
+}
+
+// ErrNoSession is returned for a session ID naming no stored session
+var ErrNoSession = errors.New("no such session")
+
+// newSessionID returns an ID that sorts by start time
+func newSessionID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return "ses-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// cypherStartSession creates the Session node, unless one has the same
+// AgentSession already
+func cypherStartSession(ctx context.Context, q Querier, labels LabelMap, project string, session Session) (Session, error) {
+	now := time.Now().UTC()
+	session = Session{ID: newSessionID(now), StartedAt: now, Dir: session.Dir, Branch: session.Branch, AgentSession: session.AgentSession}
+	if session.AgentSession != "" {
+		_, rows, err := q.Query(ctx, fmt.Sprintf(`
+			MERGE (s:%s:%s {agentSession: $agentSession})
+			ON CREATE SET s.id = $id, s.startedAt = $startedAt, s.dir = $dir, s.branch = $branch, s.touched = []
+			RETURN s.id AS id
+		`, project, labels.Label("Session")), map[string]any{
+			"agentSession": session.AgentSession, "id": session.ID, "startedAt": now, "dir": session.Dir, "branch": session.Branch,
+		})
+		if err != nil {
+			return Session{}, err
+		}
+		if len(rows) == 0 || len(rows[0]) == 0 {
+			return Session{}, fmt.Errorf("%w for agent session %q", ErrNoSession, session.AgentSession)
+		}
+		return readSession(ctx, q, labels, project, fmt.Sprint(rows[0][0]))
+	}
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		CREATE (:%s:%s {id: $id, startedAt: $startedAt, dir: $dir, branch: $branch, touched: []})
+	`, project, labels.Label("Session")), map[string]any{
+		"id": session.ID, "startedAt": now, "dir": session.Dir, "branch": session.Branch,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// cypherEndSession sets the session's endedAt, keeping an earlier end
+func cypherEndSession(ctx context.Context, q Querier, labels LabelMap, project, id string) (Session, error) {
+	_, _, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (s:%s:%s {id: $id})
+		SET s.endedAt = coalesce(s.endedAt, $now)
+	`, project, labels.Label("Session")), map[string]any{"id": id, "now": time.Now().UTC()})
+	if err != nil {
+		return Session{}, err
+	}
+	return readSession(ctx, q, labels, project, id)
+}
+
+// cypherTouchFiles adds paths to the session's touched files and links it
+// to their File nodes. Files not indexed yet are linked by the next write.
+func cypherTouchFiles(ctx context.Context, q Querier, labels LabelMap, project, id string, paths []string) error {
+	session, err := readSession(ctx, q, labels, project, id)
+	if err != nil {
+		return err
+	}
+	touched := slices.Clone(session.Touched)
+	if touched == nil {
+		touched = []string{}
+	}
+	var links []nodeLink
+	for _, path := range paths {
+		path = filepath.ToSlash(filepath.Clean(path))
+		if !slices.Contains(touched, path) {
+			touched = append(touched, path)
+		}
+		links = append(links, nodeLink{id, "File:" + path})
+	}
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		MATCH (s:%s:%s {id: $id})
+		SET s.touched = $touched
+	`, project, labels.Label("Session")), map[string]any{"id": id, "touched": touched})
+	if err != nil {
+		return err
+	}
+	return linkNodes(ctx, q, labels, project, "Session", "TOUCHED", links)
+}
+
+// cypherSessions lists the sessions with the number of memories each
+// recorded, newest first
+func cypherSessions(ctx context.Context, q Querier, labels LabelMap, project string, limit int) ([]Session, error) {
+	return querySessions(ctx, q, labels, project, "", limit)
+}
+
+// cypherReplay returns the session and its memories in namespaces, oldest
+// first
+func cypherReplay(ctx context.Context, q Querier, labels LabelMap, project, id string, namespaces []string) (Session, []Memory, error) {
+	session, err := readSession(ctx, q, labels, project, id)
+	if err != nil {
+		return Session{}, nil, err
+	}
+	memories, err := cypherRecall(ctx, q, labels, project, MemoryQuery{Session: id, Namespaces: namespaces, All: true, Peek: true})
+	if err != nil {
+		return Session{}, nil, err
+	}
+	slices.Reverse(memories)
+	return session, memories, nil
+}
+
+// sessionExists returns ErrNoSession if there is no session id
+func sessionExists(ctx context.Context, q Querier, labels LabelMap, project, id string) error {
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (s:%s:%s {id: $id})
+		RETURN count(s) AS count
+	`, project, labels.Label("Session")), map[string]any{"id": id})
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 || intValue(rows[0][0]) == 0 {
+		return fmt.Errorf("%w %q", ErrNoSession, id)
+	}
+	return nil
+}
+
+// readSession reads the session id, or returns ErrNoSession
+func readSession(ctx context.Context, q Querier, labels LabelMap, project, id string) (Session, error) {
+	sessions, err := querySessions(ctx, q, labels, project, id, 1)
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, fmt.Errorf("%w %q", ErrNoSession, id)
+	}
+	return sessions[0], nil
+}
+
+// querySessions reads the session id, or every session if id is empty
+func querySessions(ctx context.Context, q Querier, labels LabelMap, project, id string, limit int) ([]Session, error) {
+	limitClause := ""
+	if limit > 0 {
+		limitClause = fmt.Sprintf("LIMIT %d", limit)
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (s:%s:%s) WHERE $id = '' OR s.id = $id
+		OPTIONAL MATCH (s)-[:RECORDED]->(m:%s:%s)
+		RETURN s.id AS id, s.startedAt AS startedAt, s.endedAt AS endedAt, s.dir AS dir,
+		       s.branch AS branch, s.touched AS touched, count(m) AS memories, s.agentSession AS agentSession
+		ORDER BY id DESC
+		%s
+	`, project, labels.Label("Session"), project, labels.Label("Memory"), limitClause), map[string]any{"id": id})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 8 {
+			continue
+		}
+		dir, _ := row[3].(string)
+		branch, _ := row[4].(string)
+		agentSession, _ := row[7].(string)
+		sessions = append(sessions, Session{
+			ID:        fmt.Sprint(row[0]),
+			StartedAt: timeValue(row[1]),
+			EndedAt:   timeValue(row[2]),
+			Dir:       dir,
+			Branch:    branch,
+			Touched:   stringList(row[5]),
+			Memories:  intValue(row[6]),
+
+			AgentSession: agentSession,
+		})
+	}
+	return sessions, nil
+}
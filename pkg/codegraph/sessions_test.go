@@ -0,0 +1,196 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionRow is ses-1 as querySessions reads it, having touched main.go
+var sessionRow = []any{"ses-1", "2026-03-04T05:06:07Z", nil, "/src/app", "main", []any{"main.go"}, int64(2), nil}
+
+func TestCypherSessions(t *testing.T) {
+	ended := time.Date(2026, 3, 4, 6, 0, 0, 0, time.UTC)
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		return [][]any{
+			{"ses-2", ended, ended, nil, nil, nil, int64(0), "4f1c"},
+			sessionRow,
+		}
+	}}
+	sessions, err := cypherSessions(context.Background(), q, LabelMap{}, "App", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Session{
+		{ID: "ses-2", StartedAt: ended, EndedAt: ended, AgentSession: "4f1c"},
+		{ID: "ses-1", StartedAt: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC), Dir: "/src/app", Branch: "main", Touched: []string{"main.go"}, Memories: 2},
+	}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("sessions = %+v, want %+v", sessions, want)
+	}
+	if !strings.HasSuffix(q.queries[0], "LIMIT 10") || q.params[0]["id"] != "" {
+		t.Errorf("query %q with %v", q.queries[0], q.params[0])
+	}
+}
+
+func TestCypherStartSession(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		switch {
+		case strings.Contains(query, "MERGE (s:App:Session {agentSession: $agentSession})"):
+			return [][]any{{"ses-1"}}
+		case strings.HasPrefix(query, "MATCH (s:App:Session) WHERE") && params["id"] == "ses-1":
+			return [][]any{sessionRow}
+		}
+		return nil
+	}}
+	// A session of the agent's is started once, then found again
+	session, err := cypherStartSession(context.Background(), q, LabelMap{}, "App", Session{AgentSession: "4f1c", Dir: "/src/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "ses-1" || len(q.queries) != 2 || q.params[0]["agentSession"] != "4f1c" || q.params[0]["dir"] != "/src/app" {
+		t.Errorf("session = %+v after %q with %v", session, q.queries, q.params)
+	}
+
+	session, err = cypherStartSession(context.Background(), q, LabelMap{}, "App", Session{Branch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(session.ID, "ses-") || session.ID == "ses-1" || !strings.HasPrefix(q.queries[2], "CREATE (:App:Session") {
+		t.Errorf("session = %+v after %q", session, q.queries[2])
+	}
+}
+
+func TestCypherTouchFiles(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasPrefix(query, "MATCH (s:App:Session) WHERE") && params["id"] == "ses-1" {
+			return [][]any{sessionRow}
+		}
+		return nil
+	}}
+	if err := cypherTouchFiles(context.Background(), q, LabelMap{}, "App", "ses-1", []string{"main.go", "./store/store.go"}); err != nil {
+		t.Fatal(err)
+	}
+	var touched any
+	var links []any
+	for i, query := range q.queries {
+		if strings.HasSuffix(query, "SET s.touched = $touched") {
+			touched = q.params[i]["touched"]
+		}
+		if strings.HasSuffix(query, "MERGE (m)-[:TOUCHED]->(n)") {
+			links = q.params[i]["rows"].([]any)
+		}
+	}
+	if want := []string{"main.go", "store/store.go"}; !reflect.DeepEqual(touched, want) {
+		t.Errorf("touched = %v, want %v", touched, want)
+	}
+	if len(links) != 2 {
+		t.Errorf("linked %v, want both files", links)
+	}
+
+	err := cypherTouchFiles(context.Background(), q, LabelMap{}, "App", "ses-9", []string{"main.go"})
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("unknown session: err = %v, want ErrNoSession", err)
+	}
+}
+
+func TestCypherReplay(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		switch {
+		case strings.HasPrefix(query, "MATCH (s:App:Session)"):
+			return [][]any{sessionRow}
+		case strings.HasPrefix(query, "MATCH (m:App:Memory)") && params["session"] == "ses-1":
+			return [][]any{
+				{"mem-2", "second", nil, []any{"File:main.go"}, "ses-1", nil, nil, nil, nil, true, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+				{"mem-1", "first", nil, []any{"File:main.go"}, "ses-1", nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil},
+			}
+		}
+		return nil
+	}}
+	session, memories, err := cypherReplay(context.Background(), q, LabelMap{}, "App", "ses-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "ses-1" || len(memories) != 2 || memories[0].ID != "mem-1" || memories[1].ID != "mem-2" {
+		t.Errorf("replay = %+v, %+v, want ses-1 with mem-1 then mem-2", session, memories)
+	}
+	// Archived memories are replayed too, and replaying is no access
+	if !memories[1].Archived || q.params[1]["all"] != true || len(q.queries) != 2 {
+		t.Errorf("replay queried %q with %v", q.queries, q.params)
+	}
+}
+
+func TestCypherReplayNamespaces(t *testing.T) {
+	// The querier keeps memories like the recall query's namespace clause
+	row := func(id, namespace string) []any {
+		row := make([]any, 23)
+		row[0], row[1], row[3], row[4], row[17] = id, id, []any{"File:main.go"}, "ses-1", namespace
+		return row
+	}
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasPrefix(query, "MATCH (s:App:Session)") {
+			return [][]any{sessionRow}
+		}
+		var rows [][]any
+		for _, r := range [][]any{row("mem-3", "user:bob"), row("mem-2", "user:ann"), row("mem-1", "")} {
+			if params["anyNamespace"] == true || r[17] == "" || slices.Contains(params["namespaces"].([]string), r[17].(string)) {
+				rows = append(rows, r)
+			}
+		}
+		return rows
+	}}
+	for _, tt := range []struct {
+		namespaces []string
+		want       []string
+	}{
+		{[]string{}, []string{"mem-1"}},
+		{[]string{"user:ann"}, []string{"mem-1", "mem-2"}},
+		{nil, []string{"mem-1", "mem-2", "mem-3"}},
+	} {
+		_, memories, err := cypherReplay(context.Background(), q, LabelMap{}, "App", "ses-1", tt.namespaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range memories {
+			got = append(got, m.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("replay in %q = %v, want %v", tt.namespaces, got, tt.want)
+		}
+	}
+}
+
+func TestCypherRememberSession(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		if strings.HasSuffix(query, "AS count") {
+			if params["id"] == "ses-9" {
+				return [][]any{{int64(0)}}
+			}
+			return [][]any{{int64(1)}}
+		}
+		return nil
+	}}
+	memory, err := cypherRemember(context.Background(), q, LabelMap{}, "App", Memory{Text: "x", About: []string{"File:main.go"}, Session: "ses-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := false
+	for i, query := range q.queries {
+		if strings.HasSuffix(query, "MERGE (s)-[:RECORDED]->(n)") {
+			recorded = q.params[i]["session"] == "ses-1" && q.params[i]["id"] == memory.ID
+		}
+	}
+	if !recorded {
+		t.Errorf("memory not recorded by its session in %q", q.queries)
+	}
+
+	_, err = cypherRemember(context.Background(), q, LabelMap{}, "App", Memory{Text: "x", About: []string{"File:main.go"}, Session: "ses-9"})
+	if !errors.Is(err, ErrNoSession) {
+		t.Errorf("unknown session: err = %v, want ErrNoSession", err)
+	}
+}
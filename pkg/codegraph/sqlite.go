@@ -0,0 +1,247 @@
+package codegraph
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema stores the graph as generic nodes and edges keyed by node
+// key, with the same properties the Neo4j backend writes kept as JSON
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS nodes (
+	project    TEXT NOT NULL,
+	id         TEXT NOT NULL,
+	label      TEXT NOT NULL,
+	properties TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	run_id     TEXT NOT NULL,
+	PRIMARY KEY (project, id)
+);
+CREATE INDEX IF NOT EXISTS nodes_label ON nodes (project, label);
+
+CREATE TABLE IF NOT EXISTS edges (
+	project    TEXT NOT NULL,
+	type       TEXT NOT NULL,
+	source     TEXT NOT NULL,
+	target     TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	run_id     TEXT NOT NULL,
+	PRIMARY KEY (project, type, source, target)
+);
+CREATE INDEX IF NOT EXISTS edges_target ON edges (project, target);
+`
+
+// SQLiteWriter writes into a local SQLite file
+type SQLiteWriter struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates the database file at path and its schema
+func OpenSQLite(ctx context.Context, path string) (*SQLiteWriter, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	slog.Info("opened SQLite database", "path", path)
+	return &SQLiteWriter{db: db}, nil
+}
+
+func (b *SQLiteWriter) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	slog.Info("creating graph nodes", "project", project)
+	slog.Info("clearing existing nodes", "project", project)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM edges WHERE project = ?`, project); err != nil {
+		return fmt.Errorf("clearing edges: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM nodes WHERE project = ?`, project); err != nil {
+		return fmt.Errorf("clearing nodes: %w", err)
+	}
+
+	now := run.StartedAt.Format(time.RFC3339Nano)
+
+	nodes := graph.Nodes()
+	rels := graph.Relationships()
+	p := StartProgress("writing", "rows", len(nodes)+len(rels))
+	defer p.Finish()
+
+	slog.Info("creating nodes", "count", len(nodes))
+	insertNode, err := tx.PrepareContext(ctx, `
+		INSERT OR REPLACE INTO nodes (project, id, label, properties, created_at, updated_at, run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertNode.Close()
+	for _, node := range nodes {
+		props, err := json.Marshal(node.Props)
+		if err != nil {
+			return err
+		}
+		if _, err := insertNode.ExecContext(ctx, project, node.Key, node.Label, string(props), now, now, run.ID); err != nil {
+			return fmt.Errorf("creating node %s: %w", node.Key, err)
+		}
+		p.Add(1)
+	}
+
+	slog.Info("creating relationships", "count", len(rels))
+	insertEdge, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO edges (project, type, source, target, created_at, updated_at, run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertEdge.Close()
+	for _, rel := range rels {
+		if _, err := insertEdge.ExecContext(ctx, project, rel.Type, rel.From, rel.To, now, now, run.ID); err != nil {
+			return fmt.Errorf("creating relationship %s: %w", rel.Key(), err)
+		}
+		p.Add(1)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Print summary
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT label, count(*) FROM nodes WHERE project = ? GROUP BY label ORDER BY label`, project)
+	if err != nil {
+		return fmt.Errorf("getting summary: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var label string
+		var count int
+		if err := rows.Scan(&label, &count); err != nil {
+			return err
+		}
+		slog.Info("graph summary", "label", label, "count", count)
+	}
+	return rows.Err()
+}
+
+func (b *SQLiteWriter) Validate(ctx context.Context, project string, graph *Graph) ([]string, error) {
+	check := graphCheck{Counts: make(map[string]int)}
+	rows, err := b.db.QueryContext(ctx, `SELECT label, count(*) FROM nodes WHERE project = ? GROUP BY label`, project)
+	if err != nil {
+		return nil, fmt.Errorf("counting nodes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label string
+		var count int
+		if err := rows.Scan(&label, &count); err != nil {
+			return nil, err
+		}
+		check.Counts[label] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("counting nodes: %w", err)
+	}
+
+	err = b.db.QueryRowContext(ctx, `
+		SELECT count(*) FROM nodes n
+		WHERE n.project = ? AND n.label IN ('Function', 'Method') AND NOT EXISTS (
+			SELECT 1 FROM edges e JOIN nodes f ON f.project = e.project AND f.id = e.source
+			WHERE e.project = n.project AND e.type = 'CONTAINS' AND e.target = n.id AND f.label = 'File')`,
+		project).Scan(&check.OrphanFunctions)
+	if err != nil {
+		return nil, fmt.Errorf("finding orphan functions: %w", err)
+	}
+	err = b.db.QueryRowContext(ctx, `
+		SELECT count(*) FROM nodes n
+		WHERE n.project = ? AND n.label = 'File' AND NOT EXISTS (
+			SELECT 1 FROM edges e JOIN nodes p ON p.project = e.project AND p.id = e.target
+			WHERE e.project = n.project AND e.type = 'BELONGS_TO' AND e.source = n.id AND p.label = 'Package')`,
+		project).Scan(&check.OrphanFiles)
+	if err != nil {
+		return nil, fmt.Errorf("finding orphan files: %w", err)
+	}
+
+	return check.violations(countLabels(graph.Nodes(), true)), nil
+}
+
+func (b *SQLiteWriter) Projects(ctx context.Context) ([]string, error) {
+	rows, err := b.db.QueryContext(ctx, `SELECT DISTINCT project FROM nodes ORDER BY project`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var projects []string
+	for rows.Next() {
+		var project string
+		if err := rows.Scan(&project); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+func (b *SQLiteWriter) DeleteProject(ctx context.Context, project string) (int, error) {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM edges WHERE project = ?`, project); err != nil {
+		return 0, fmt.Errorf("deleting edges: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM nodes WHERE project = ?`, project)
+	if err != nil {
+		return 0, fmt.Errorf("deleting nodes: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), tx.Commit()
+}
+
+// Snapshots lists the one run a project's nodes come from, as every write
+// replaces the project whole
+func (b *SQLiteWriter) Snapshots(ctx context.Context, project string) ([]Snapshot, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT run_id, max(updated_at), count(*) FROM nodes WHERE project = ?
+		GROUP BY run_id ORDER BY run_id DESC`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var snapshots []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		var written string
+		if err := rows.Scan(&s.RunID, &written, &s.Nodes); err != nil {
+			return nil, err
+		}
+		s.Written = timeValue(written)
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// Prune has nothing to remove: SQLite keeps no deleted nodes or run
+// records, only the project as last written
+func (b *SQLiteWriter) Prune(ctx context.Context, project string, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (b *SQLiteWriter) Close(ctx context.Context) error {
+	return b.db.Close()
+}
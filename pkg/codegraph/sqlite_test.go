@@ -0,0 +1,163 @@
+package codegraph
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSQLiteWriter(t *testing.T) {
+	ctx := context.Background()
+	backend, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "graph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	for _, project := range []string{"App", "App", "Other"} {
+		if err := backend.Write(ctx, project, graph, NewRunInfo()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(query string, args ...any) int {
+		t.Helper()
+		var n int
+		if err := backend.db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(`SELECT count(*) FROM nodes WHERE project = 'App'`); n != len(graph.Nodes()) {
+		t.Errorf("%d App nodes after writing twice, want %d", n, len(graph.Nodes()))
+	}
+	if n := count(`SELECT count(*) FROM edges WHERE project = 'App'`); n != len(graph.Relationships()) {
+		t.Errorf("%d App edges after writing twice, want %d", n, len(graph.Relationships()))
+	}
+	if n := count(`SELECT count(*) FROM nodes WHERE project = 'Other'`); n != len(graph.Nodes()) {
+		t.Errorf("%d Other nodes, want %d", n, len(graph.Nodes()))
+	}
+
+	var props string
+	if err := backend.db.QueryRowContext(ctx, `SELECT properties FROM nodes WHERE project = 'App' AND id = ?`,
+		"Function:store/store.go:*Store.Put").Scan(&props); err != nil {
+		t.Fatal(err)
+	}
+	var fn map[string]any
+	if err := json.Unmarshal([]byte(props), &fn); err != nil {
+		t.Fatal(err)
+	}
+	if fn["receiver"] != "*Store" || fn["isExport"] != true {
+		t.Errorf("Store.Put properties = %v", fn)
+	}
+}
+
+func TestSQLiteValidate(t *testing.T) {
+	ctx := context.Background()
+	backend, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "graph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	if err := backend.Write(ctx, "App", graph, NewRunInfo()); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := backend.Validate(ctx, "App", graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) > 0 {
+		t.Errorf("violations after a clean write: %q", violations)
+	}
+
+	if _, err := backend.db.ExecContext(ctx, `DELETE FROM edges WHERE type = 'BELONGS_TO' AND source = 'File:main.go'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.db.ExecContext(ctx, `DELETE FROM nodes WHERE id = 'Struct:store/store.go:Store'`); err != nil {
+		t.Fatal(err)
+	}
+	violations, err = backend.Validate(ctx, "App", graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Struct nodes: parsed 1, stored 0", "1 files do not belong to a Package"}
+	if !slices.Equal(violations, want) {
+		t.Errorf("violations = %q, want %q", violations, want)
+	}
+}
+
+func TestSQLiteProjects(t *testing.T) {
+	ctx := context.Background()
+	w, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "graph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	for _, project := range []string{"Web", "App"} {
+		if err := w.Write(ctx, project, graph, NewRunInfo()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	projects, err := w.Projects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"App", "Web"}; !slices.Equal(projects, want) {
+		t.Errorf("Projects() = %v, want %v", projects, want)
+	}
+}
+
+func TestSQLiteManage(t *testing.T) {
+	ctx := context.Background()
+	backend, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "graph.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close(ctx)
+
+	graph := parseTestTree(t, Filter{})
+	run := NewRunInfo()
+	for _, project := range []string{"App", "Other"} {
+		if err := backend.Write(ctx, project, graph, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := backend.Snapshots(ctx, "App")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].RunID != run.ID || snapshots[0].Nodes != len(graph.Nodes()) ||
+		!snapshots[0].Written.Equal(run.StartedAt) {
+		t.Errorf("snapshots = %+v, want run %s with %d nodes", snapshots, run.ID, len(graph.Nodes()))
+	}
+
+	removed, err := backend.DeleteProject(ctx, "App")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != len(graph.Nodes()) {
+		t.Errorf("removed %d nodes, want %d", removed, len(graph.Nodes()))
+	}
+	projects, err := backend.Projects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(projects, []string{"Other"}) {
+		t.Errorf("projects after wiping App = %q, want Other", projects)
+	}
+	var edges int
+	if err := backend.db.QueryRowContext(ctx, `SELECT count(*) FROM edges WHERE project = 'App'`).Scan(&edges); err != nil {
+		t.Fatal(err)
+	}
+	if edges != 0 {
+		t.Errorf("%d App edges left", edges)
+	}
+}
@@ -0,0 +1,82 @@
+package codegraph
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+)
+
+// Stability holds a package's coupling and Robert C. Martin's metrics of
+// it. Instability is efferent / (afferent + efferent), as for Coupling.
+// Abstractness is the share of its types that are interfaces, of those
+// the graph holds. Distance is how far it is from the main sequence,
+// |abstractness + instability - 1|: 0 for a package as abstract as it is
+// depended upon, up to 1 for one concrete and depended upon (the zone of
+// pain) or abstract and unused (the zone of uselessness).
+type Stability struct {
+	Package      string  `json:"package"`
+	Afferent     int     `json:"afferent"`
+	Efferent     int     `json:"efferent"`
+	Instability  float64 `json:"instability"`
+	Abstractness float64 `json:"abstractness"`
+	Distance     float64 `json:"distance"`
+}
+
+// ZoneOfPain is the kind of finding PainfulPackages reports
+const ZoneOfPain = "zone-of-pain"
+
+// Stability returns the metrics of every package of g, by path, counting
+// package imports whether or not the imports feature is on
+func (g *Graph) Stability() []Stability {
+	full := *g
+	full.Features = Features{"imports": true, "calls": false}
+	coupling := full.Coupling()
+
+	types := make(map[string][2]int) // structs, interfaces
+	for _, st := range g.Structs {
+		t := types[filepath.Dir(st.File)]
+		t[0]++
+		types[filepath.Dir(st.File)] = t
+	}
+	for _, iface := range g.Interfaces {
+		t := types[filepath.Dir(iface.File)]
+		t[1]++
+		types[filepath.Dir(iface.File)] = t
+	}
+
+	stability := make([]Stability, 0, len(g.Packages))
+	for _, pkg := range g.Packages {
+		c := coupling[pkg.Key()]
+		s := Stability{Package: pkg.Path, Afferent: c.In, Efferent: c.Out, Instability: c.Instability()}
+		if t := types[pkg.Path]; t[0]+t[1] > 0 {
+			s.Abstractness = float64(t[1]) / float64(t[0]+t[1])
+		}
+		s.Distance = math.Abs(s.Abstractness + s.Instability - 1)
+		stability = append(stability, s)
+	}
+	slices.SortFunc(stability, func(a, b Stability) int { return cmp.Compare(a.Package, b.Package) })
+	return stability
+}
+
+// PainfulPackages flags the packages at least distance from the main
+// sequence on its concrete, stable side: others import them, yet they are
+// mostly structs and import little themselves, so they are hard to change
+// and every change ripples out to their importers
+func PainfulPackages(stability []Stability, distance float64) []Finding {
+	var findings []Finding
+	for _, s := range stability {
+		if s.Afferent == 0 || s.Abstractness+s.Instability >= 1 || s.Distance < distance {
+			continue
+		}
+		findings = append(findings, Finding{
+			Kind: ZoneOfPain,
+			Key:  PackageNode{Path: s.Package}.Key(),
+			Name: s.Package,
+			Message: fmt.Sprintf("%s is concrete (abstractness %.2f) and stable (instability %.2f), %.2f from the main sequence, with %d packages importing it",
+				s.Package, s.Abstractness, s.Instability, s.Distance, s.Afferent),
+		})
+	}
+	return findings
+}
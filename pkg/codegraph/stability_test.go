@@ -0,0 +1,45 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestStability(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": "package main\n\nimport (\n\t\"example.com/app/api\"\n\t\"example.com/app/model\"\n)\n\nfunc main() { api.Serve(model.User{}) }\n",
+		"api/api.go": `package api
+
+import "example.com/app/model"
+
+type Handler interface {
+	Serve(model.User)
+}
+
+func Serve(u model.User) {}
+`,
+		"model/model.go": "package model\n\ntype User struct{}\n\ntype Group struct{}\n",
+	})
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stability := graph.Stability()
+	want := []Stability{
+		{Package: ".", Efferent: 2, Instability: 1},
+		{Package: "api", Afferent: 1, Efferent: 1, Instability: 0.5, Abstractness: 1, Distance: 0.5},
+		{Package: "model", Afferent: 2, Distance: 1},
+	}
+	if !reflect.DeepEqual(stability, want) {
+		t.Errorf("stability = %+v, want %+v", stability, want)
+	}
+
+	findings := PainfulPackages(stability, 0.7)
+	if len(findings) != 1 || findings[0].Key != "Package:model" || findings[0].Kind != ZoneOfPain {
+		t.Errorf("findings = %+v, want model in the zone of pain", findings)
+	}
+	if findings := PainfulPackages(stability, 0.3); len(findings) != 1 {
+		t.Errorf("findings = %+v, want api left out as abstract", findings)
+	}
+}
@@ -0,0 +1,1202 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stampRelationship sets run metadata on a merged relationship bound to `r`.
+// createdAt is only set the first time the edge is seen.
+const stampRelationship = `ON CREATE SET r.createdAt = $now
+			SET r.updatedAt = $now, r.runId = $runId`
+
+// codeRelationshipTypes are the relationship types this tool writes
+const codeRelationshipTypes = "BELONGS_TO|CONTAINS|IMPORTS|CALLS|IMPLEMENTS"
+
+// StatementOptions changes how BuildStatements writes the graph
+type StatementOptions struct {
+	Labels LabelMap
+
+	// SoftDelete merges nodes on their identity instead of clearing and
+	// recreating the project, and marks symbols that no longer exist as
+	// deleted so anything attached to them stays resolvable
+	SoftDelete bool
+
+	// Files, if set, limits the write to these files: their nodes are
+	// replaced and only relationships touching them are written
+	Files []string
+}
+
+// inScope reports whether a node in file is written
+func (o StatementOptions) inScope(file string) bool {
+	return o.Files == nil || slices.Contains(o.Files, file)
+}
+
+// NodeKeys are the properties identifying a node of each kind within its
+// project, which writes match and merge on; indexes on them keep large
+// writes fast
+var NodeKeys = map[string][]string{
+	"Package":   {"path"},
+	"File":      {"path"},
+	"Function":  {"file", "name", "receiver"},
+	"Method":    {"file", "name", "receiver"},
+	"Struct":    {"file", "name"},
+	"Interface": {"file", "name"},
+}
+
+// nodeClause writes the node bound to v from the UNWIND row: identity and
+// props are the row keys copied onto it. With soft deletes the node is merged
+// on its identity properties and revived if it had been marked deleted.
+func nodeClause(v, labels string, identity, props []string, softDelete bool) string {
+	if !softDelete {
+		var fields []string
+		for _, prop := range slices.Concat(identity, props) {
+			fields = append(fields, fmt.Sprintf("%s: row.%s", prop, prop))
+		}
+		fields = append(fields, "createdAt: $now", "updatedAt: $now", "runId: $runId", "commit: $commit")
+		return fmt.Sprintf("CREATE (%s:%s {%s})", v, labels, strings.Join(fields, ", "))
+	}
+
+	var keys, sets []string
+	for _, prop := range identity {
+		keys = append(keys, fmt.Sprintf("%s: row.%s", prop, prop))
+	}
+	for _, prop := range props {
+		sets = append(sets, fmt.Sprintf("%s.%s = row.%s", v, prop, prop))
+	}
+	sets = append(sets, v+".updatedAt = $now", v+".runId = $runId", v+".commit = $commit", v+".deleted = false", v+".deletedAt = null")
+	return fmt.Sprintf("MERGE (%s:%s {%s})\n\t\tON CREATE SET %s.createdAt = $now\n\t\tSET %s",
+		v, labels, strings.Join(keys, ", "), v, strings.Join(sets, ", "))
+}
+
+// Statement is a parameterised Cypher statement produced for a graph.
+// Statements are grouped by Phase for progress output. A statement with Rows
+// UNWINDs them from $rows and is split into batches when executed; one with
+// nil Rows runs once with Params.
+type Statement struct {
+	Phase  string
+	Query  string
+	Params map[string]any
+	Rows   []map[string]any
+	Desc   string
+}
+
+// batchParams returns the statement's parameters with rows bound to $rows
+func (s Statement) batchParams(rows []map[string]any) map[string]any {
+	params := make(map[string]any, len(s.Params)+1)
+	for key, value := range s.Params {
+		params[key] = value
+	}
+	list := make([]any, len(rows))
+	for i, row := range rows {
+		list[i] = row
+	}
+	params["rows"] = list
+	return params
+}
+
+// Batches splits the statement into one statement per size rows
+func (s Statement) Batches(size int) []Statement {
+	if s.Rows == nil {
+		return []Statement{s}
+	}
+	var out []Statement
+	for start := 0; start < len(s.Rows); start += size {
+		rows := s.Rows[start:min(start+size, len(s.Rows))]
+		batch := s
+		batch.Params = s.batchParams(rows)
+		batch.Rows = rows
+		out = append(out, batch)
+	}
+	return out
+}
+
+// BuildStatements produces every write statement needed to replace the
+// project's code graph, in execution order
+func BuildStatements(project string, graph *Graph, run RunInfo, opts StatementOptions) []Statement {
+	var stmts []Statement
+	buildStatements(project, graph, run, opts, func(stmt Statement) { stmts = append(stmts, stmt) })
+	return stmts
+}
+
+// statementBuffer is how many statements StreamStatements builds ahead of
+// the one being written
+const statementBuffer = 2
+
+// StreamStatements sends the statements of BuildStatements on the channel
+// it returns, building each once the writer has taken all but
+// statementBuffer of those before it, so the rows of every statement are
+// never held at once. Those of one statement are still those of every
+// node or relationship of its kind. The channel is closed after the last
+// statement; once ctx is done, the rest are dropped.
+func StreamStatements(ctx context.Context, project string, graph *Graph, run RunInfo, opts StatementOptions) <-chan Statement {
+	stmts := make(chan Statement, statementBuffer)
+	go func() {
+		defer close(stmts)
+		buildStatements(project, graph, run, opts, func(stmt Statement) {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case stmts <- stmt:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return stmts
+}
+
+// StatementRows estimates the rows the statements of BuildStatements
+// write, for progress, from the nodes in scope and the relationships they
+// declare, without building the statements. Each callee expression counts
+// as a call whether it resolves or not, and derived rows such as ownership
+// and embeddings are left out, so it is approximate.
+func StatementRows(graph *Graph, opts StatementOptions) int {
+	n := len(graph.Modules)
+	for _, pkg := range graph.Packages {
+		if opts.Files == nil || slices.ContainsFunc(opts.Files, func(file string) bool { return filepath.Dir(file) == pkg.Path }) {
+			n++
+		}
+	}
+	for _, file := range graph.Files {
+		if opts.inScope(file.Path) {
+			// The node and its BELONGS_TO relationship
+			n += 2 + len(file.Imports)
+		}
+	}
+	for _, fn := range graph.Functions {
+		if opts.inScope(fn.File) {
+			n += 2 + len(fn.Calls)
+		}
+	}
+	for _, st := range graph.Structs {
+		if opts.inScope(st.File) {
+			n += 2
+		}
+	}
+	for _, iface := range graph.Interfaces {
+		if opts.inScope(iface.File) {
+			n += 2
+		}
+	}
+	return n
+}
+
+// buildStatements passes the statements of BuildStatements to yield in turn
+func buildStatements(project string, graph *Graph, run RunInfo, opts StatementOptions, yield func(Statement)) {
+	emit := func(stmts ...Statement) {
+		for _, stmt := range stmts {
+			stmt.Query = opts.Labels.Rewrite(stmt.Query)
+			yield(stmt)
+		}
+	}
+	stamp := map[string]any{"now": run.StartedAt, "runId": run.ID, "commit": nil}
+	if run.Commit != "" {
+		stamp["commit"] = run.Commit
+	}
+	soft := opts.SoftDelete
+	relStamp := stampRelationship
+	if soft {
+		relStamp += ", r.deleted = false, r.deletedAt = null"
+	}
+	incremental := opts.Files != nil
+	scope := stamp
+	if incremental {
+		modules := make([]string, 0, len(graph.Modules))
+		for _, m := range graph.Modules {
+			modules = append(modules, m.Path)
+		}
+		scope = map[string]any{"now": run.StartedAt, "runId": run.ID, "paths": opts.Files,
+			"packages": removedPackages(graph, opts.Files), "modules": modules}
+	}
+
+	// Clear existing project nodes, unless they are tombstoned at the end
+	if !soft && incremental {
+		emit(Statement{
+			Phase: fmt.Sprintf("Clearing %d changed files", len(opts.Files)),
+			Query: fmt.Sprintf(`
+			MATCH (f:%s:File) WHERE f.path IN $paths
+			OPTIONAL MATCH (f)-[:CONTAINS]->(s)
+			DETACH DELETE s, f
+		`, project),
+			Params: scope,
+			Desc:   "clearing files",
+		}, Statement{
+			Phase: fmt.Sprintf("Clearing %d changed files", len(opts.Files)),
+			Query: fmt.Sprintf(`
+			MATCH (p:%s:Package) WHERE p.path IN $packages
+			DETACH DELETE p
+		`, project),
+			Params: scope,
+			Desc:   "clearing packages",
+		}, Statement{
+			Phase: fmt.Sprintf("Clearing %d changed files", len(opts.Files)),
+			Query: fmt.Sprintf(`
+			MATCH (m:%s:Module) WHERE NOT m.path IN $modules
+			DETACH DELETE m
+		`, project),
+			Params: scope,
+			Desc:   "clearing modules",
+		})
+	} else if !soft {
+		emit(Statement{
+			Phase: fmt.Sprintf("Clearing existing %s:Code nodes", project),
+			Query: fmt.Sprintf(`
+			MATCH (n:%s) WHERE n:File OR n:Package OR n:Module OR n:Function OR n:Method OR n:Struct OR n:Interface
+			DETACH DELETE n
+		`, project),
+			Desc: "clearing nodes",
+		})
+	}
+
+	// Create Package nodes. An incremental write keeps the other files'
+	// packages, so they are merged rather than created.
+	packages := make([]map[string]any, 0, len(graph.Packages))
+	for _, pkg := range graph.Packages {
+		if incremental && !slices.ContainsFunc(opts.Files, func(file string) bool { return filepath.Dir(file) == pkg.Path }) {
+			continue
+		}
+		packages = append(packages, map[string]any{"name": pkg.Name, "path": pkg.Path})
+	}
+	emit(Statement{
+		Phase: fmt.Sprintf("Creating %d Package nodes", len(graph.Packages)),
+		Query: fmt.Sprintf(`
+		UNWIND $rows AS row
+		%s
+	`, nodeClause("p", project+":Package", NodeKeys["Package"], []string{"name"}, soft || incremental)),
+		Params: stamp,
+		Rows:   packages,
+		Desc:   "creating packages",
+	})
+
+	// Create a Module node per go.mod and link the packages to theirs,
+	// recording their import paths, which tell apart packages of the same
+	// name in different modules. Modules outlive any one file, so they are
+	// always merged; a full write has cleared them first, and an incremental
+	// one the modules whose go.mod is gone.
+	if len(graph.Modules) > 0 {
+		phase := fmt.Sprintf("Creating %d Module nodes", len(graph.Modules))
+		moduleReset := ""
+		if soft {
+			moduleReset = ", m.deleted = false, m.deletedAt = null"
+		}
+		modules := make([]map[string]any, 0, len(graph.Modules))
+		for _, m := range graph.Modules {
+			modules = append(modules, map[string]any{"path": m.Path, "dir": m.Dir, "importPath": m.ImportPath, "goVersion": m.GoVersion})
+		}
+		members := make([]map[string]any, 0, len(graph.Packages))
+		for _, pkg := range graph.Packages {
+			if pkg.Module == "" || incremental && !slices.ContainsFunc(opts.Files, func(file string) bool { return filepath.Dir(file) == pkg.Path }) {
+				continue
+			}
+			members = append(members, map[string]any{"path": pkg.Path, "module": pkg.Module, "importPath": pkg.ImportPath})
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MERGE (m:%s:Module {path: row.path})
+			SET m.dir = row.dir, m.importPath = row.importPath, m.goVersion = row.goVersion, m.updatedAt = $now, m.runId = $runId%s
+		`, project, moduleReset),
+			Params: stamp,
+			Rows:   modules,
+			Desc:   "creating modules",
+		}, Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (p:%s:Package {path: row.path})
+			SET p.module = row.module, p.importPath = row.importPath
+			WITH p, row
+			MATCH (m:%s:Module {path: row.module})
+			MERGE (p)-[r:BELONGS_TO]->(m)
+			%s
+		`, project, project, relStamp),
+			Params: stamp,
+			Rows:   members,
+			Desc:   "linking packages to modules",
+		})
+	}
+
+	// Create File nodes with BELONGS_TO package relationship
+	files := make([]map[string]any, 0, len(graph.Files))
+	for _, file := range graph.Files {
+		if !opts.inScope(file.Path) {
+			continue
+		}
+		files = append(files, map[string]any{
+			"path":     file.Path,
+			"package":  file.Package,
+			"language": file.Language,
+			"imports":  file.Imports,
+			"pkgPath":  filepath.Dir(file.Path),
+		})
+	}
+	emit(Statement{
+		Phase: fmt.Sprintf("Creating %d File nodes", len(graph.Files)),
+		Query: fmt.Sprintf(`
+		UNWIND $rows AS row
+		%s
+		WITH f, row
+		MATCH (p:%s:Package {path: row.pkgPath})
+		MERGE (f)-[r:BELONGS_TO]->(p)
+		%s
+	`, nodeClause("f", project+":File", NodeKeys["File"], []string{"package", "language", "imports"}, soft),
+			project, relStamp),
+		Params: stamp,
+		Rows:   files,
+		Desc:   "creating files",
+	})
+
+	// Create Function and Method nodes. Labels cannot be parameterised, so
+	// each label gets its own statement.
+	phase := fmt.Sprintf("Creating %d Function nodes", len(graph.Functions))
+	functionRows := map[string][]map[string]any{
+		"Function": make([]map[string]any, 0, len(graph.Functions)),
+		"Method":   make([]map[string]any, 0, len(graph.Functions)),
+	}
+	for _, fn := range graph.Functions {
+		if !opts.inScope(fn.File) {
+			continue
+		}
+		label := "Function"
+		if fn.Receiver != "" {
+			label = "Method"
+		}
+		functionRows[label] = append(functionRows[label], map[string]any{
+			"name":       fn.Name,
+			"file":       fn.File,
+			"signature":  fn.Signature,
+			"receiver":   fn.Receiver,
+			"isExport":   fn.IsExport,
+			"lineStart":  fn.LineStart,
+			"lineEnd":    fn.LineEnd,
+			"complexity": fn.Complexity,
+		})
+	}
+	for _, label := range []string{"Function", "Method"} {
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			%s
+			WITH fn, row
+			MATCH (f:%s:File {path: row.file})
+			MERGE (f)-[r:CONTAINS]->(fn)
+			%s
+		`, nodeClause("fn", project+":"+label, NodeKeys[label],
+				[]string{"signature", "isExport", "lineStart", "lineEnd", "complexity"}, soft),
+				project, relStamp),
+			Params: stamp,
+			Rows:   functionRows[label],
+			Desc:   "creating " + strings.ToLower(label) + "s",
+		})
+	}
+
+	// Create Struct nodes
+	structRows := make([]map[string]any, 0, len(graph.Structs))
+	for _, st := range graph.Structs {
+		if !opts.inScope(st.File) {
+			continue
+		}
+		structRows = append(structRows, map[string]any{
+			"name":     st.Name,
+			"file":     st.File,
+			"fields":   st.Fields,
+			"isExport": st.IsExport,
+		})
+	}
+	emit(Statement{
+		Phase: fmt.Sprintf("Creating %d Struct nodes", len(graph.Structs)),
+		Query: fmt.Sprintf(`
+		UNWIND $rows AS row
+		%s
+		WITH s, row
+		MATCH (f:%s:File {path: row.file})
+		MERGE (f)-[r:CONTAINS]->(s)
+		%s
+	`, nodeClause("s", project+":Struct", NodeKeys["Struct"], []string{"fields", "isExport"}, soft),
+			project, relStamp),
+		Params: stamp,
+		Rows:   structRows,
+		Desc:   "creating structs",
+	})
+
+	// Create Interface nodes
+	interfaceRows := make([]map[string]any, 0, len(graph.Interfaces))
+	for _, iface := range graph.Interfaces {
+		if !opts.inScope(iface.File) {
+			continue
+		}
+		interfaceRows = append(interfaceRows, map[string]any{
+			"name":     iface.Name,
+			"file":     iface.File,
+			"methods":  iface.Methods,
+			"isExport": iface.IsExport,
+		})
+	}
+	emit(Statement{
+		Phase: fmt.Sprintf("Creating %d Interface nodes", len(graph.Interfaces)),
+		Query: fmt.Sprintf(`
+		UNWIND $rows AS row
+		%s
+		WITH i, row
+		MATCH (f:%s:File {path: row.file})
+		MERGE (f)-[r:CONTAINS]->(i)
+		%s
+	`, nodeClause("i", project+":Interface", NodeKeys["Interface"], []string{"methods", "isExport"}, soft),
+			project, relStamp),
+		Params: stamp,
+		Rows:   interfaceRows,
+		Desc:   "creating interfaces",
+	})
+
+	// Create IMPORTS relationships between files and the packages of the
+	// project they import, marked as internal imports. External imports
+	// are skipped, and counted on Package nodes instead.
+	imports := make([]map[string]any, 0)
+	for _, file := range graph.Files {
+		if !opts.inScope(file.Path) || !graph.Features.Enabled("imports") {
+			continue
+		}
+		for _, imp := range file.Imports {
+			if dir := graph.projectPackage(imp); dir != "" {
+				imports = append(imports, map[string]any{"filePath": file.Path, "packagePath": dir})
+			}
+		}
+	}
+	emit(Statement{
+		Phase: "Creating IMPORTS relationships",
+		Query: fmt.Sprintf(`
+		UNWIND $rows AS row
+		MATCH (f:%s:File {path: row.filePath})
+		MATCH (p:%s:Package {path: row.packagePath})
+		MERGE (f)-[r:IMPORTS]->(p)
+		%s, r.kind = 'internal'
+	`, project, project, relStamp),
+		Params: stamp,
+		Rows:   imports,
+		Desc:   "linking imports",
+	})
+
+	// Create CALLS relationships between functions, a statement for each
+	// pair of labels so that both ends are matched through their indexes
+	functions := make(map[string]FunctionNode)
+	for _, fn := range graph.Functions {
+		functions[fn.Key()] = fn
+	}
+	functionLabel := func(fn FunctionNode) string {
+		if fn.Receiver != "" {
+			return "Method"
+		}
+		return "Function"
+	}
+	calls := graph.Calls()
+	callRows := make(map[[2]string][]map[string]any)
+	for _, from := range []string{"Function", "Method"} {
+		for _, to := range []string{"Function", "Method"} {
+			callRows[[2]string{from, to}] = []map[string]any{}
+		}
+	}
+	for _, rel := range calls {
+		from, to := functions[rel.From], functions[rel.To]
+		if !opts.inScope(from.File) && !opts.inScope(to.File) {
+			continue
+		}
+		labels := [2]string{functionLabel(from), functionLabel(to)}
+		callRows[labels] = append(callRows[labels], map[string]any{
+			"fromFile":     from.File,
+			"fromName":     from.Name,
+			"fromReceiver": from.Receiver,
+			"toFile":       to.File,
+			"toName":       to.Name,
+			"toReceiver":   to.Receiver,
+		})
+	}
+	for _, from := range []string{"Function", "Method"} {
+		for _, to := range []string{"Function", "Method"} {
+			emit(Statement{
+				Phase: fmt.Sprintf("Creating %d CALLS relationships", len(calls)),
+				Query: fmt.Sprintf(`
+				UNWIND $rows AS row
+				MATCH (a:%s:%s {file: row.fromFile, name: row.fromName, receiver: row.fromReceiver})
+				MATCH (b:%s:%s {file: row.toFile, name: row.toName, receiver: row.toReceiver})
+				MERGE (a)-[r:CALLS]->(b)
+				%s
+			`, project, from, project, to, relStamp),
+				Params: stamp,
+				Rows:   callRows[[2]string{from, to}],
+				Desc:   "linking calls",
+			})
+		}
+	}
+
+	// Create IMPLEMENTS relationships between structs and interfaces
+	structs := make(map[string]StructNode)
+	for _, st := range graph.Structs {
+		structs[st.Key()] = st
+	}
+	interfaces := make(map[string]InterfaceNode)
+	for _, iface := range graph.Interfaces {
+		interfaces[iface.Key()] = iface
+	}
+	impls := graph.Implementations()
+	implRows := make([]map[string]any, 0, len(impls))
+	for _, rel := range impls {
+		st, iface := structs[rel.From], interfaces[rel.To]
+		if !opts.inScope(st.File) && !opts.inScope(iface.File) {
+			continue
+		}
+		implRows = append(implRows, map[string]any{
+			"structFile": st.File,
+			"structName": st.Name,
+			"ifaceFile":  iface.File,
+			"ifaceName":  iface.Name,
+		})
+	}
+	emit(Statement{
+		Phase: fmt.Sprintf("Creating %d IMPLEMENTS relationships", len(impls)),
+		Query: fmt.Sprintf(`
+		UNWIND $rows AS row
+		MATCH (s:%s:Struct {file: row.structFile, name: row.structName})
+		MATCH (i:%s:Interface {file: row.ifaceFile, name: row.ifaceName})
+		MERGE (s)-[r:IMPLEMENTS]->(i)
+		%s
+	`, project, project, relStamp),
+		Params: stamp,
+		Rows:   implRows,
+		Desc:   "linking implementations",
+	})
+
+	// Link the functions whose bodies are copies of each other, from the
+	// one whose key sorts first, with how similar they are
+	if graph.Features.Enabled("duplicates") {
+		duplicates := graph.Duplicates(DuplicateSimilarity)
+		duplicateRows := make([]map[string]any, 0, len(duplicates))
+		for _, dup := range duplicates {
+			from, to := functions[dup.From], functions[dup.To]
+			if !opts.inScope(from.File) && !opts.inScope(to.File) {
+				continue
+			}
+			duplicateRows = append(duplicateRows, map[string]any{
+				"fromFile":     from.File,
+				"fromName":     from.Name,
+				"fromReceiver": from.Receiver,
+				"toFile":       to.File,
+				"toName":       to.Name,
+				"toReceiver":   to.Receiver,
+				"similarity":   dup.Similarity,
+			})
+		}
+		emit(Statement{
+			Phase: fmt.Sprintf("Creating %d DUPLICATES relationships", len(duplicates)),
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (a:%s {file: row.fromFile, name: row.fromName, receiver: row.fromReceiver})
+			MATCH (b:%s {file: row.toFile, name: row.toName, receiver: row.toReceiver})
+			MERGE (a)-[r:DUPLICATES]->(b)
+			%s
+			SET r.similarity = row.similarity
+		`, project, project, relStamp),
+			Params: stamp,
+			Rows:   duplicateRows,
+			Desc:   "linking duplicates",
+		})
+	}
+
+	// Link the tests to the functions they reach, with how many calls deep,
+	// when test files are parsed
+	if graph.Features.Enabled("tests") {
+		links := graph.TestLinks()
+		testRows := make([]map[string]any, 0, len(links))
+		for _, link := range links {
+			test, target := functions[link.Test], functions[link.Target]
+			if !opts.inScope(test.File) && !opts.inScope(target.File) {
+				continue
+			}
+			testRows = append(testRows, map[string]any{
+				"testFile":       test.File,
+				"testName":       test.Name,
+				"testReceiver":   test.Receiver,
+				"targetFile":     target.File,
+				"targetName":     target.Name,
+				"targetReceiver": target.Receiver,
+				"depth":          link.Depth,
+			})
+		}
+		emit(Statement{
+			Phase: fmt.Sprintf("Creating %d TESTS relationships", len(links)),
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (t:%s {file: row.testFile, name: row.testName, receiver: row.testReceiver})
+			MATCH (fn:%s {file: row.targetFile, name: row.targetName, receiver: row.targetReceiver})
+			MERGE (t)-[r:TESTS]->(fn)
+			%s
+			SET r.depth = row.depth
+		`, project, project, relStamp),
+			Params: stamp,
+			Rows:   testRows,
+			Desc:   "linking tests",
+		})
+	}
+
+	// Annotate files and functions with their owners from --blame and link
+	// them to Author nodes. Authors are shared across files, so they are
+	// always merged.
+	if len(graph.Ownership) > 0 {
+		phase = fmt.Sprintf("Creating %d Author nodes", len(graph.Authors))
+		authors := make([]map[string]any, 0, len(graph.Authors))
+		for _, author := range graph.Authors {
+			authors = append(authors, map[string]any{"email": author.Email, "name": author.Name})
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MERGE (a:%s:Author {email: row.email})
+			SET a.name = row.name, a.updatedAt = $now, a.runId = $runId
+		`, project),
+			Params: stamp,
+			Rows:   authors,
+			Desc:   "creating authors",
+		})
+
+		phase = "Annotating ownership"
+		fileOwners := make([]map[string]any, 0, len(graph.Files))
+		for _, file := range graph.Files {
+			owner, ok := graph.Ownership[file.Key()]
+			if !ok || !opts.inScope(file.Path) {
+				continue
+			}
+			fileOwners = append(fileOwners, ownershipRow(owner, map[string]any{"path": file.Path}))
+		}
+		functionOwners := make([]map[string]any, 0, len(graph.Functions))
+		for _, fn := range graph.Functions {
+			owner, ok := graph.Ownership[fn.Key()]
+			if !ok || !opts.inScope(fn.File) {
+				continue
+			}
+			functionOwners = append(functionOwners, ownershipRow(owner, map[string]any{
+				"file":     fn.File,
+				"name":     fn.Name,
+				"receiver": fn.Receiver,
+			}))
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s:File {path: row.path})
+			SET n.lastAuthor = row.lastAuthor, n.lastModified = row.lastModified, n.topContributors = row.topContributors
+			WITH n, row
+			UNWIND row.authors AS owner
+			MATCH (a:%s:Author {email: owner.email})
+			MERGE (a)-[r:AUTHORED]->(n)
+			%s
+			SET r.lines = owner.lines
+		`, project, project, relStamp),
+			Params: stamp,
+			Rows:   fileOwners,
+			Desc:   "annotating file ownership",
+		}, Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s {file: row.file, name: row.name, receiver: row.receiver})
+			WHERE n:Function OR n:Method
+			SET n.lastAuthor = row.lastAuthor, n.lastModified = row.lastModified, n.topContributors = row.topContributors
+			WITH n, row
+			UNWIND row.authors AS owner
+			MATCH (a:%s:Author {email: owner.email})
+			MERGE (a)-[r:AUTHORED]->(n)
+			%s
+			SET r.lines = owner.lines
+		`, project, project, relStamp),
+			Params: stamp,
+			Rows:   functionOwners,
+			Desc:   "annotating function ownership",
+		})
+
+		// A package's ownership sums that of all its files, so an
+		// incremental write sets every package's
+		packageOwners := make([]map[string]any, 0, len(graph.Packages))
+		for _, o := range graph.PackageOwnerships() {
+			packageOwners = append(packageOwners, map[string]any{
+				"path":          o.Package,
+				"owner":         o.Owner,
+				"ownerShare":    o.OwnerShare,
+				"knowledgeable": o.Knowledgeable,
+				"busFactor":     o.BusFactor,
+			})
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s:Package {path: row.path})
+			SET n.owner = row.owner, n.ownerShare = row.ownerShare,
+			    n.knowledgeableAuthors = row.knowledgeable, n.busFactor = row.busFactor
+		`, project),
+			Rows: packageOwners,
+			Desc: "annotating package ownership",
+		})
+	}
+
+	// Record how often each file and function changed in the --churn
+	// window. Nodes that did not change get zero so hotspot queries can
+	// rank every node.
+	if graph.Churn != nil {
+		phase = "Annotating churn"
+		fileChurn := make([]map[string]any, 0, len(graph.Files))
+		for _, file := range graph.Files {
+			if !opts.inScope(file.Path) {
+				continue
+			}
+			c := graph.Churn[file.Key()]
+			fileChurn = append(fileChurn, map[string]any{"path": file.Path, "commits": c.Commits, "lines": c.Lines})
+		}
+		functionChurn := make([]map[string]any, 0, len(graph.Functions))
+		for _, fn := range graph.Functions {
+			if !opts.inScope(fn.File) {
+				continue
+			}
+			c := graph.Churn[fn.Key()]
+			functionChurn = append(functionChurn, map[string]any{
+				"file":     fn.File,
+				"name":     fn.Name,
+				"receiver": fn.Receiver,
+				"commits":  c.Commits,
+				"lines":    c.Lines,
+			})
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s:File {path: row.path})
+			SET n.churn = row.commits, n.churnLines = row.lines
+		`, project),
+			Rows: fileChurn,
+			Desc: "annotating file churn",
+		}, Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s {file: row.file, name: row.name, receiver: row.receiver})
+			WHERE n:Function OR n:Method
+			SET n.churn = row.commits, n.churnLines = row.lines
+		`, project),
+			Rows: functionChurn,
+			Desc: "annotating function churn",
+		})
+	}
+
+	// Record the test coverage of each function from --coverprofile.
+	// Functions in files the profile does not cover have theirs removed,
+	// rather than keeping what an older profile said.
+	if graph.Coverage != nil {
+		phase = "Annotating coverage"
+		functionCoverage := make([]map[string]any, 0, len(graph.Functions))
+		for _, fn := range graph.Functions {
+			if !opts.inScope(fn.File) {
+				continue
+			}
+			row := map[string]any{"file": fn.File, "name": fn.Name, "receiver": fn.Receiver,
+				"coverage": nil, "statements": nil, "covered": nil, "uncovered": nil}
+			if c, ok := graph.Coverage[fn.Key()]; ok {
+				row["coverage"], row["statements"], row["covered"] = c.Percent(), c.Statements, c.Covered
+				row["uncovered"] = append([]string{}, c.Uncovered...)
+			}
+			functionCoverage = append(functionCoverage, row)
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s {file: row.file, name: row.name, receiver: row.receiver})
+			WHERE n:Function OR n:Method
+			SET n.coverage = row.coverage, n.statements = row.statements,
+			    n.coveredStatements = row.covered, n.uncoveredLines = row.uncovered
+		`, project),
+			Rows: functionCoverage,
+			Desc: "annotating function coverage",
+		})
+	}
+
+	// Replace the project's vulnerabilities with those govulncheck found,
+	// each linked to the Dependency node of its module, and the functions
+	// whose calls reach it to the vulnerability. They are for the whole
+	// project, so an incremental write sets them all.
+	if graph.Vulnerabilities != nil {
+		phase = "Linking vulnerabilities"
+		vulnRows := make([]map[string]any, 0, len(graph.Vulnerabilities))
+		reachRows := make([]map[string]any, 0)
+		for _, v := range graph.Vulnerabilities {
+			vulnRows = append(vulnRows, map[string]any{
+				"id":           v.ID,
+				"aliases":      append([]string{}, v.Aliases...),
+				"summary":      v.Summary,
+				"url":          v.URL,
+				"level":        v.Level,
+				"module":       v.Module,
+				"version":      v.Version,
+				"fixedVersion": v.FixedVersion,
+			})
+			for _, p := range v.Paths {
+				fn, ok := functions[p.From]
+				if !ok {
+					continue
+				}
+				reachRows = append(reachRows, map[string]any{
+					"id":       v.ID,
+					"file":     fn.File,
+					"name":     fn.Name,
+					"receiver": fn.Receiver,
+					"path":     p.Calls,
+				})
+			}
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			MATCH (v:%s:Vulnerability)
+			DETACH DELETE v
+		`, project),
+			Desc: "clearing vulnerabilities",
+		}, Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MERGE (d:%s:Dependency {module: row.module})
+			SET d.version = row.version, d.updatedAt = $now
+			CREATE (v:%s:Vulnerability {id: row.id, aliases: row.aliases, summary: row.summary, url: row.url,
+			        level: row.level, fixedVersion: row.fixedVersion, createdAt: $now, runId: $runId})
+			CREATE (v)-[:AFFECTS]->(d)
+		`, project, project),
+			Params: stamp,
+			Rows:   vulnRows,
+			Desc:   "creating vulnerabilities",
+		}, Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (v:%s:Vulnerability {id: row.id})
+			MATCH (fn:%s {file: row.file, name: row.name, receiver: row.receiver})
+			WHERE fn:Function OR fn:Method
+			MERGE (fn)-[r:REACHES]->(v)
+			SET r.path = row.path
+		`, project, project),
+			Rows: reachRows,
+			Desc: "linking vulnerable calls",
+		})
+	}
+
+	// Record how many functions call each function and method and how many
+	// it calls, and how many packages import each package and how many it
+	// imports, with the package's stability metrics. A change to one file
+	// changes the counts of nodes in others, so an incremental write sets
+	// them all.
+	coupling := graph.Coupling()
+	if graph.Features.Enabled("calls") {
+		functionCoupling := make([]map[string]any, 0, len(graph.Functions))
+		for _, fn := range graph.Functions {
+			c := coupling[fn.Key()]
+			functionCoupling = append(functionCoupling, map[string]any{
+				"file":     fn.File,
+				"name":     fn.Name,
+				"receiver": fn.Receiver,
+				"fanIn":    c.In,
+				"fanOut":   c.Out,
+			})
+		}
+		emit(Statement{
+			Phase: "Annotating coupling",
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s {file: row.file, name: row.name, receiver: row.receiver})
+			WHERE n:Function OR n:Method
+			SET n.fanIn = row.fanIn, n.fanOut = row.fanOut
+		`, project),
+			Rows: functionCoupling,
+			Desc: "annotating function coupling",
+		})
+
+		// Record how deep the calls from each entry point go, and along
+		// which path
+		depths := graph.CallDepths()
+		depthRows := make([]map[string]any, 0, len(depths))
+		for _, d := range depths {
+			fn := functions[d.Key]
+			depthRows = append(depthRows, map[string]any{
+				"file":     fn.File,
+				"name":     fn.Name,
+				"receiver": fn.Receiver,
+				"kind":     d.Kind,
+				"depth":    d.Depth,
+				"path":     d.Path,
+			})
+		}
+		emit(Statement{
+			Phase: "Annotating coupling",
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s {file: row.file, name: row.name, receiver: row.receiver})
+			WHERE n:Function OR n:Method
+			SET n.entryPoint = row.kind, n.callDepth = row.depth, n.deepestPath = row.path
+		`, project),
+			Rows: depthRows,
+			Desc: "annotating call depth",
+		})
+	}
+	if graph.Features.Enabled("imports") {
+		packageCoupling := make([]map[string]any, 0, len(graph.Packages))
+		for _, pkg := range graph.Stability() {
+			packageCoupling = append(packageCoupling, map[string]any{
+				"path":         pkg.Package,
+				"afferent":     pkg.Afferent,
+				"efferent":     pkg.Efferent,
+				"instability":  pkg.Instability,
+				"abstractness": pkg.Abstractness,
+				"distance":     pkg.Distance,
+			})
+		}
+		emit(Statement{
+			Phase: "Annotating coupling",
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s:Package {path: row.path})
+			SET n.afferent = row.afferent, n.efferent = row.efferent, n.instability = row.instability,
+			    n.abstractness = row.abstractness, n.distance = row.distance
+		`, project),
+			Rows: packageCoupling,
+			Desc: "annotating package coupling",
+		})
+
+		breakdownRows := make([]map[string]any, 0, len(graph.Packages))
+		for _, b := range graph.ImportBreakdowns() {
+			breakdownRows = append(breakdownRows, map[string]any{
+				"path":            b.Package,
+				"stdlib":          b.Stdlib,
+				"internal":        b.Internal,
+				"thirdParty":      b.ThirdParty,
+				"thirdPartyRatio": b.ThirdPartyRatio,
+			})
+		}
+		emit(Statement{
+			Phase: "Annotating coupling",
+			Query: fmt.Sprintf(`
+			UNWIND $rows AS row
+			MATCH (n:%s:Package {path: row.path})
+			SET n.stdlibImports = row.stdlib, n.internalImports = row.internal,
+			    n.thirdPartyImports = size(row.thirdParty), n.thirdPartyPackages = row.thirdParty,
+			    n.thirdPartyRatio = row.thirdPartyRatio
+		`, project),
+			Rows: breakdownRows,
+			Desc: "annotating import breakdown",
+		})
+	}
+
+	// Set the properties enrichers attached, matching each node on its keys
+	if len(graph.Properties) > 0 {
+		phase = "Annotating custom properties"
+		rows := make(map[string][]map[string]any)
+		for _, node := range graph.Nodes() {
+			props, ok := graph.Properties[node.Key]
+			if !ok {
+				continue
+			}
+			if node.Label == "Package" {
+				if incremental && !slices.ContainsFunc(opts.Files, func(file string) bool { return filepath.Dir(file) == nodeFile(node) }) {
+					continue
+				}
+			} else if !opts.inScope(nodeFile(node)) {
+				continue
+			}
+			row := map[string]any{"props": props}
+			for _, key := range NodeKeys[node.Label] {
+				row[key] = node.Props[key]
+			}
+			rows[node.Label] = append(rows[node.Label], row)
+		}
+		for _, label := range NodeLabels {
+			if len(rows[label]) == 0 {
+				continue
+			}
+			var match []string
+			for _, key := range NodeKeys[label] {
+				match = append(match, fmt.Sprintf("%s: row.%s", key, key))
+			}
+			emit(Statement{
+				Phase: phase,
+				Query: fmt.Sprintf(`
+				UNWIND $rows AS row
+				MATCH (n:%s:%s {%s})
+				SET n += row.props
+			`, project, label, strings.Join(match, ", ")),
+				Rows: rows[label],
+				Desc: "annotating custom properties",
+			})
+		}
+	}
+
+	// Set the embeddings Embed computed, for the vector index
+	if len(graph.Embeddings) > 0 {
+		phase = "Writing embeddings"
+		rows := make(map[string][]map[string]any)
+		for _, node := range graph.Nodes() {
+			vector, ok := graph.Embeddings[node.Key]
+			if !ok || !opts.inScope(nodeFile(node)) {
+				continue
+			}
+			row := map[string]any{"embedding": vector}
+			for _, key := range NodeKeys[node.Label] {
+				row[key] = node.Props[key]
+			}
+			rows[node.Label] = append(rows[node.Label], row)
+		}
+		for _, label := range NodeLabels {
+			if len(rows[label]) == 0 {
+				continue
+			}
+			var match []string
+			for _, key := range NodeKeys[label] {
+				match = append(match, fmt.Sprintf("%s: row.%s", key, key))
+			}
+			emit(Statement{
+				Phase: phase,
+				Query: fmt.Sprintf(`
+				UNWIND $rows AS row
+				MATCH (n:%s:%s {%s})
+				SET n.%s = row.embedding
+			`, project, label, strings.Join(match, ", "), EmbeddingProperty),
+				Rows: rows[label],
+				Desc: "writing embeddings",
+			})
+		}
+	}
+
+	// Tombstone whatever this run did not write. An incremental write only
+	// answers for the changed files, the packages left without a file, the
+	// modules and the relationships touching them.
+	if soft {
+		nodeScope, relScope := "", ""
+		if incremental {
+			nodeScope = "AND (n.path IN $paths OR n.file IN $paths OR n:Package AND n.path IN $packages OR n:Module)"
+			relScope = "AND (a.path IN $paths OR a.file IN $paths OR b.file IN $paths OR a:Package AND a.path IN $packages)"
+		}
+		phase = "Marking removed symbols deleted"
+		relTypes := codeRelationshipTypes
+		if len(graph.Ownership) > 0 {
+			relTypes += "|AUTHORED"
+		}
+		if graph.Features.Enabled("duplicates") {
+			relTypes += "|DUPLICATES"
+		}
+		if graph.Features.Enabled("tests") {
+			relTypes += "|TESTS"
+		}
+		emit(Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			MATCH (n:%s)
+			WHERE (n:File OR n:Package OR n:Module OR n:Function OR n:Method OR n:Struct OR n:Interface)
+			  AND n.runId <> $runId AND NOT coalesce(n.deleted, false) %s
+			SET n.deleted = true, n.deletedAt = $now
+		`, project, nodeScope),
+			Params: scope,
+			Desc:   "tombstoning nodes",
+		}, Statement{
+			Phase: phase,
+			Query: fmt.Sprintf(`
+			MATCH (a:%s)-[r:%s]->(b:%s)
+			WHERE r.runId <> $runId AND NOT coalesce(r.deleted, false) %s
+			SET r.deleted = true, r.deletedAt = $now
+		`, project, relTypes, project, relScope),
+			Params: scope,
+			Desc:   "tombstoning relationships",
+		})
+	}
+}
+
+// removedPackages returns the directories of files that no file of graph
+// is left in, the packages an incremental write of files removes
+func removedPackages(graph *Graph, files []string) []string {
+	removed := []string{}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if slices.Contains(removed, dir) || slices.ContainsFunc(graph.Packages, func(pkg PackageNode) bool { return pkg.Path == dir }) {
+			continue
+		}
+		removed = append(removed, dir)
+	}
+	return removed
+}
+
+// TrimQuery strips the indentation and blank lines of a query literal
+func TrimQuery(query string) string {
+	var lines []string
+	for _, line := range strings.Split(query, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CypherLiteral renders a parameter value as a Cypher literal
+func CypherLiteral(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+		return "'" + r.Replace(v) + "'"
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return "datetime('" + v.Format(time.RFC3339Nano) + "')"
+	case []string:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = CypherLiteral(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []float64:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = CypherLiteral(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = CypherLiteral(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = "`" + key + "`: " + CypherLiteral(v[key])
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return CypherLiteral(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,559 @@
+package codegraph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCypherLiteral(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{nil, "null"},
+		{"it's\n", `'it\'s\n'`},
+		{true, "true"},
+		{42, "42"},
+		{int64(-7), "-7"},
+		{1.5, "1.5"},
+		{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "datetime('2024-01-02T03:04:05Z')"},
+		{[]string{"a", "b"}, "['a', 'b']"},
+		{[]float64{0.5, -1}, "[0.5, -1]"},
+		{[]any{"a", 1}, "['a', 1]"},
+		{map[string]any{"b": 1, "a": "x"}, "{`a`: 'x', `b`: 1}"},
+	}
+	for _, tt := range tests {
+		if got := CypherLiteral(tt.value); got != tt.want {
+			t.Errorf("CypherLiteral(%#v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBuildStatements(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	run := RunInfo{ID: "run-1", StartedAt: time.Now()}
+	stmts := BuildStatements("App", graph, run, StatementOptions{})
+	if len(stmts) == 0 {
+		t.Fatal("no statements")
+	}
+	if !strings.Contains(stmts[0].Query, "DETACH DELETE") {
+		t.Errorf("first statement %q does not clear the project", stmts[0].Desc)
+	}
+
+	rows := make(map[string]int)
+	for _, stmt := range stmts {
+		if !strings.Contains(stmt.Query, ":App") {
+			t.Errorf("%s: query does not use the project label:\n%s", stmt.Desc, stmt.Query)
+		}
+		if stmt.Params != nil && stmt.Params["runId"] != "run-1" {
+			t.Errorf("%s: runId = %v, want run-1", stmt.Desc, stmt.Params["runId"])
+		}
+		if stmt.Desc == "linking calls" && !strings.Contains(stmt.Query, "(a:App:Function {") && !strings.Contains(stmt.Query, "(a:App:Method {") {
+			t.Errorf("calls are matched without a function label:\n%s", stmt.Query)
+		}
+		rows[stmt.Desc] += len(stmt.Rows)
+	}
+	for desc, want := range map[string]int{
+		"creating packages":   2,
+		"creating files":      2,
+		"creating functions":  3,
+		"creating methods":    2,
+		"creating structs":    1,
+		"creating interfaces": 1,
+		"linking calls":       4,
+	} {
+		if rows[desc] != want {
+			t.Errorf("%s: %d rows, want %d", desc, rows[desc], want)
+		}
+	}
+}
+
+func TestNodeClause(t *testing.T) {
+	tests := []struct {
+		soft bool
+		want string
+	}{
+		{false, "CREATE (s:App:Struct {file: row.file, name: row.name, fields: row.fields, " +
+			"createdAt: $now, updatedAt: $now, runId: $runId, commit: $commit})"},
+		{true, "MERGE (s:App:Struct {file: row.file, name: row.name})\n\t\tON CREATE SET s.createdAt = $now\n\t\t" +
+			"SET s.fields = row.fields, s.updatedAt = $now, s.runId = $runId, s.commit = $commit, s.deleted = false, s.deletedAt = null"},
+	}
+	for _, tt := range tests {
+		if got := nodeClause("s", "App:Struct", []string{"file", "name"}, []string{"fields"}, tt.soft); got != tt.want {
+			t.Errorf("nodeClause(soft %v) =\n%s\nwant\n%s", tt.soft, got, tt.want)
+		}
+	}
+}
+
+func TestBuildStatementsSoftDelete(t *testing.T) {
+	run := RunInfo{ID: "run-1", StartedAt: time.Now()}
+	stmts := BuildStatements("App", parseTestTree(t, Filter{}), run, StatementOptions{SoftDelete: true})
+
+	tests := []struct {
+		desc     string
+		contains []string
+	}{
+		{"creating packages", []string{"MERGE (p:App:Package {path: row.path})", "p.deleted = false"}},
+		{"creating files", []string{"MERGE (f:App:File {path: row.path})", "r.deleted = false, r.deletedAt = null"}},
+		{"linking calls", []string{"MERGE (a)-[r:CALLS]->(b)", "r.deleted = false"}},
+		{"tombstoning nodes", []string{"n.runId <> $runId", "SET n.deleted = true, n.deletedAt = $now"}},
+		{"tombstoning relationships", []string{"[r:BELONGS_TO|CONTAINS|IMPORTS|CALLS|IMPLEMENTS]", "SET r.deleted = true, r.deletedAt = $now"}},
+	}
+	for _, tt := range tests {
+		i := slices.IndexFunc(stmts, func(stmt Statement) bool { return stmt.Desc == tt.desc })
+		if i < 0 {
+			t.Errorf("no %s statement", tt.desc)
+			continue
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(stmts[i].Query, want) {
+				t.Errorf("%s: query does not contain %q:\n%s", tt.desc, want, stmts[i].Query)
+			}
+		}
+	}
+
+	for _, stmt := range stmts {
+		if strings.Contains(stmt.Query, "DETACH DELETE") || strings.Contains(stmt.Query, "CREATE (") {
+			t.Errorf("%s: soft delete still clears or creates:\n%s", stmt.Desc, stmt.Query)
+		}
+	}
+	if last := stmts[len(stmts)-1]; last.Desc != "tombstoning relationships" || last.Params["runId"] != "run-1" {
+		t.Errorf("last statement = %s with %v, want the relationship tombstones for run-1", last.Desc, last.Params)
+	}
+}
+
+func TestStatementBatches(t *testing.T) {
+	stmt := Statement{Query: "UNWIND $rows AS row", Params: map[string]any{"runId": "run-1"}, Rows: make([]map[string]any, 5)}
+	batches := stmt.Batches(2)
+	if len(batches) != 3 {
+		t.Fatalf("%d batches, want 3", len(batches))
+	}
+	if n := len(batches[2].Rows); n != 1 {
+		t.Errorf("last batch has %d rows, want 1", n)
+	}
+	for _, batch := range batches {
+		if rows, _ := batch.Params["rows"].([]any); len(rows) != len(batch.Rows) || batch.Params["runId"] != "run-1" {
+			t.Errorf("batch params = %v", batch.Params)
+		}
+	}
+	if _, ok := stmt.Params["rows"]; ok {
+		t.Error("batching bound rows in the statement's own params")
+	}
+
+	single := Statement{Query: "MATCH (n) DETACH DELETE n"}
+	if batches := single.Batches(2); len(batches) != 1 {
+		t.Errorf("statement without rows split into %d batches", len(batches))
+	}
+}
+
+func TestBuildStatementsLabels(t *testing.T) {
+	labels := LabelMap{Prefix: "CG_", Rename: map[string]string{"Struct": "Class"}}
+	for _, stmt := range BuildStatements("App", parseTestTree(t, Filter{}), RunInfo{ID: "run-1"}, StatementOptions{Labels: labels}) {
+		if strings.Contains(stmt.Query, ":Struct") || strings.Contains(stmt.Query, ":File") {
+			t.Errorf("%s: label not mapped:\n%s", stmt.Desc, stmt.Query)
+		}
+	}
+}
+
+func TestBuildStatementsIncremental(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	run := RunInfo{ID: "run-1", StartedAt: time.Now()}
+	stmts := BuildStatements("App", graph, run, StatementOptions{Files: []string{"main.go"}})
+
+	if stmts[0].Desc != "clearing files" || !slices.Equal(stmts[0].Params["paths"].([]string), []string{"main.go"}) {
+		t.Errorf("first statement = %s with %v, want the changed files cleared", stmts[0].Desc, stmts[0].Params)
+	}
+	rows := make(map[string]int)
+	for _, stmt := range stmts {
+		if strings.Contains(stmt.Query, "MATCH (n:App) WHERE n:File") {
+			t.Errorf("%s: incremental write clears the project:\n%s", stmt.Desc, stmt.Query)
+		}
+		rows[stmt.Desc] += len(stmt.Rows)
+	}
+	for desc, want := range map[string]int{
+		"creating packages":       1,
+		"creating files":          1,
+		"creating functions":      2,
+		"creating methods":        0,
+		"creating structs":        0,
+		"linking imports":         1,
+		"linking calls":           3,
+		"linking implementations": 0,
+	} {
+		if rows[desc] != want {
+			t.Errorf("%s: %d rows, want %d", desc, rows[desc], want)
+		}
+	}
+
+	soft := BuildStatements("App", graph, run, StatementOptions{Files: []string{"main.go"}, SoftDelete: true})
+	last := soft[len(soft)-1]
+	if !strings.Contains(last.Query, "a.file IN $paths") || last.Params["paths"] == nil {
+		t.Errorf("relationship tombstones are not limited to the changed files:\n%s", last.Query)
+	}
+}
+
+func TestBuildStatementsRemovedPackage(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	stored := countLabels(graph.Nodes(), true)
+	graph.RemoveFile("store/store.go")
+	run := RunInfo{ID: "run-2", StartedAt: time.Now()}
+
+	var packages []string
+	for _, stmt := range BuildStatements("App", graph, run, StatementOptions{Files: []string{"store/store.go"}}) {
+		if stmt.Desc == "clearing packages" {
+			packages = stmt.Params["packages"].([]string)
+			if !strings.Contains(stmt.Query, "p.path IN $packages") {
+				t.Errorf("packages are not cleared by path:\n%s", stmt.Query)
+			}
+		}
+	}
+	if !slices.Equal(packages, []string{"store"}) {
+		t.Fatalf("cleared packages = %v, want the package left without files", packages)
+	}
+	// What validation then finds stored matches the graph
+	stored["Package"] -= len(packages)
+	if got := countLabels(graph.Nodes(), true)["Package"]; stored["Package"] != got {
+		t.Errorf("%d packages stored, %d parsed", stored["Package"], got)
+	}
+
+	soft := BuildStatements("App", graph, run, StatementOptions{Files: []string{"store/store.go"}, SoftDelete: true})
+	for _, stmt := range soft {
+		if stmt.Desc == "tombstoning nodes" && (!strings.Contains(stmt.Query, "n:Package AND n.path IN $packages") || !slices.Equal(stmt.Params["packages"].([]string), []string{"store"})) {
+			t.Errorf("the package left without files is not tombstoned:\n%s", stmt.Query)
+		}
+	}
+
+	kept := BuildStatements("App", parseTestTree(t, Filter{}), run, StatementOptions{Files: []string{"store/store.go"}})
+	if packages := kept[0].Params["packages"].([]string); len(packages) != 0 {
+		t.Errorf("cleared packages = %v, want none while their files remain", packages)
+	}
+}
+
+func TestBuildStatementsRemovedModule(t *testing.T) {
+	root := writeTree(t, monorepoTree)
+	if err := os.Remove(filepath.Join(root, "libs", "go.mod")); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cleared bool
+	var modules []map[string]any
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-2", StartedAt: time.Now()}, StatementOptions{}) {
+		switch stmt.Desc {
+		case "clearing nodes":
+			cleared = strings.Contains(stmt.Query, "n:Module")
+		case "creating modules":
+			modules = stmt.Rows
+		}
+	}
+	if !cleared {
+		t.Error("a full write does not clear the Module nodes")
+	}
+	if len(modules) != 1 || modules[0]["path"] != "example.com/api" {
+		t.Errorf("modules written = %v, want only example.com/api", modules)
+	}
+}
+
+func TestBuildStatementsOwnership(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Authors = []AuthorNode{{Email: "ann@example.com", Name: "Ann"}}
+	owner := Ownership{LastAuthor: "ann@example.com", TopContributors: []string{"ann@example.com"}, Lines: map[string]int{"ann@example.com": 3}}
+	graph.Ownership = map[string]Ownership{"File:main.go": owner, "Function:main.go:main": owner}
+
+	rows := make(map[string][]map[string]any)
+	var relationships string
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{SoftDelete: true}) {
+		rows[stmt.Desc] = append(rows[stmt.Desc], stmt.Rows...)
+		if stmt.Desc == "tombstoning relationships" {
+			relationships = stmt.Query
+		}
+	}
+	if n := len(rows["creating authors"]); n != 1 {
+		t.Errorf("%d author rows, want 1", n)
+	}
+	if files := rows["annotating file ownership"]; len(files) != 1 || files[0]["path"] != "main.go" {
+		t.Errorf("file ownership rows = %v", files)
+	}
+	functions := rows["annotating function ownership"]
+	if len(functions) != 1 || functions[0]["name"] != "main" {
+		t.Fatalf("function ownership rows = %v", functions)
+	}
+	if authors := fmt.Sprint(functions[0]["authors"]); authors != "[map[email:ann@example.com lines:3]]" {
+		t.Errorf("function authors = %s", authors)
+	}
+	if packages := rows["annotating package ownership"]; len(packages) != 1 || packages[0]["path"] != "." || packages[0]["busFactor"] != 1 {
+		t.Errorf("package ownership rows = %v", packages)
+	}
+	if !strings.Contains(relationships, "|AUTHORED]") {
+		t.Errorf("AUTHORED relationships are not tombstoned:\n%s", relationships)
+	}
+}
+
+func TestBuildStatementsChurn(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Churn = map[string]Churn{"Function:main.go:main": {Commits: 2, Lines: 7}}
+
+	rows := make(map[string][]map[string]any)
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{Files: []string{"main.go"}}) {
+		rows[stmt.Desc] = append(rows[stmt.Desc], stmt.Rows...)
+	}
+	if files := rows["annotating file churn"]; len(files) != 1 || files[0]["commits"] != 0 {
+		t.Errorf("file churn rows = %v, want main.go unchanged", files)
+	}
+	functions := rows["annotating function churn"]
+	if len(functions) != 2 {
+		t.Fatalf("function churn rows = %v, want main.go's two functions", functions)
+	}
+	for _, row := range functions {
+		if want := map[string]int{"main": 2, "run": 0}[row["name"].(string)]; row["commits"] != want {
+			t.Errorf("%s churn = %v, want %d", row["name"], row["commits"], want)
+		}
+	}
+}
+
+func TestBuildStatementsCoverage(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Coverage = map[string]Coverage{"Function:main.go:main": {Statements: 4, Covered: 1, Uncovered: []string{"5-7"}}}
+
+	var functions []map[string]any
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{Files: []string{"main.go"}}) {
+		if stmt.Desc == "annotating function coverage" {
+			functions = append(functions, stmt.Rows...)
+		}
+	}
+	if len(functions) != 2 {
+		t.Fatalf("function coverage rows = %v, want main.go's two functions", functions)
+	}
+	for _, row := range functions {
+		switch row["name"] {
+		case "main":
+			if row["coverage"] != 25.0 || row["statements"] != 4 || !slices.Equal(row["uncovered"].([]string), []string{"5-7"}) {
+				t.Errorf("main coverage = %v", row)
+			}
+		default:
+			// Not in the profile, so whatever an older one set is removed
+			if row["coverage"] != nil || row["uncovered"] != nil {
+				t.Errorf("%s coverage = %v, want none", row["name"], row)
+			}
+		}
+	}
+}
+
+func TestBuildStatementsDuplicates(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		if stmt.Desc == "linking duplicates" {
+			t.Fatal("duplicates linked without the duplicates feature")
+		}
+	}
+
+	fingerprint := make([]uint32, fingerprintSize)
+	graph.Functions[0].Fingerprint, graph.Functions[1].Fingerprint = fingerprint, fingerprint
+	graph.Features = Features{"duplicates": true}
+	var rows []map[string]any
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{SoftDelete: true}) {
+		if stmt.Desc == "linking duplicates" {
+			rows = append(rows, stmt.Rows...)
+		}
+		if stmt.Desc == "tombstoning relationships" && !strings.Contains(stmt.Query, "|DUPLICATES]") {
+			t.Errorf("DUPLICATES relationships are not tombstoned: %s", stmt.Query)
+		}
+	}
+	if len(rows) != 1 || rows[0]["similarity"] != 1.0 {
+		t.Errorf("duplicate rows = %v, want one pair with similarity 1", rows)
+	}
+}
+
+func TestBuildStatementsTests(t *testing.T) {
+	graph := parseTestTree(t, Filter{Tests: true})
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		if stmt.Desc == "linking tests" {
+			t.Fatal("tests linked without the tests feature")
+		}
+	}
+
+	graph, err := Parser{Root: writeTree(t, testLinksTree), Filter: Filter{Tests: true}}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Features = Features{"tests": true}
+	var rows []map[string]any
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{SoftDelete: true, Files: []string{"store/store_test.go"}}) {
+		if stmt.Desc == "linking tests" {
+			rows = append(rows, stmt.Rows...)
+		}
+		if stmt.Desc == "tombstoning relationships" && !strings.Contains(stmt.Query, "|TESTS]") {
+			t.Errorf("TESTS relationships are not tombstoned: %s", stmt.Query)
+		}
+	}
+	if len(rows) != 3 || rows[0]["testName"] != "TestPut" || rows[0]["targetName"] != "Put" || rows[0]["depth"] != 1 {
+		t.Errorf("test rows = %v", rows)
+	}
+}
+
+func TestBuildStatementsVulnerabilities(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	graph.Vulnerabilities = []Vulnerability{}
+	rows := make(map[string][]map[string]any)
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		rows[stmt.Desc] = append(rows[stmt.Desc], stmt.Rows...)
+		if stmt.Desc == "linking vulnerable calls" && stmt.Rows == nil {
+			t.Error("vulnerable calls are linked without rows")
+		}
+	}
+	if _, ok := rows["clearing vulnerabilities"]; !ok {
+		t.Error("a clean report does not clear the old vulnerabilities")
+	}
+
+	fn := graph.Functions[0]
+	graph.Vulnerabilities = []Vulnerability{{ID: "GO-2023-1988", Module: "golang.org/x/net", Level: "symbol",
+		Paths: []CallPath{{From: fn.Key(), Calls: []string{"html.Parse"}}, {From: "Function:gone.go:gone"}}}}
+	rows = make(map[string][]map[string]any)
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{Files: []string{"other.go"}}) {
+		rows[stmt.Desc] = append(rows[stmt.Desc], stmt.Rows...)
+	}
+	if got := rows["creating vulnerabilities"]; len(got) != 1 || got[0]["module"] != "golang.org/x/net" {
+		t.Errorf("vulnerability rows = %v", got)
+	}
+	if got := rows["linking vulnerable calls"]; len(got) != 1 || got[0]["name"] != fn.Name || !slices.Equal(got[0]["path"].([]string), []string{"html.Parse"}) {
+		t.Errorf("vulnerable call rows = %v, want one from %s", got, fn.Name)
+	}
+}
+
+func TestBuildStatementsCoupling(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+
+	// Callers in other files change the counts, so an incremental write
+	// still sets every function's
+	rows := make(map[string][]map[string]any)
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{Files: []string{"main.go"}}) {
+		rows[stmt.Desc] = append(rows[stmt.Desc], stmt.Rows...)
+	}
+	functions := rows["annotating function coupling"]
+	if len(functions) != len(graph.Functions) {
+		t.Fatalf("function coupling rows = %v, want all %d functions", functions, len(graph.Functions))
+	}
+	for _, row := range functions {
+		if row["name"] == "Put" && (row["fanIn"] != 1 || row["fanOut"] != 1) {
+			t.Errorf("Put coupling = %v, want one call in and one out", row)
+		}
+	}
+	if depths := rows["annotating call depth"]; len(depths) != 1 || depths[0]["name"] != "main" || depths[0]["kind"] != "main" ||
+		depths[0]["depth"] != len(depths[0]["path"].([]string))-1 {
+		t.Errorf("call depth rows = %v, want main's", depths)
+	}
+	if breakdown := rows["annotating import breakdown"]; len(breakdown) != 2 || breakdown[0]["path"] != "." || breakdown[0]["internal"] != 1 {
+		t.Errorf("import breakdown rows = %v", breakdown)
+	}
+	packages := rows["annotating package coupling"]
+	if len(packages) != 2 || packages[0]["path"] != "." || packages[0]["efferent"] != 1 || packages[0]["instability"] != 1.0 {
+		t.Errorf("package coupling rows = %v", packages)
+	}
+
+	// Without calls nothing counts them
+	graph.Features = Features{"calls": false}
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		if stmt.Desc == "annotating function coupling" || stmt.Desc == "annotating call depth" {
+			t.Errorf("%s without calls: %v", stmt.Desc, stmt.Rows)
+		}
+	}
+}
+
+func TestBuildStatementsModules(t *testing.T) {
+	graph, err := Parser{Root: writeTree(t, monorepoTree), SyntaxOnly: true}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string][]map[string]any)
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		rows[stmt.Desc] = append(rows[stmt.Desc], stmt.Rows...)
+	}
+	if modules := rows["creating modules"]; len(modules) != 2 || modules[0]["path"] != "example.com/api" {
+		t.Errorf("module rows = %v", modules)
+	}
+	// The package outside any module is not linked
+	if members := rows["linking packages to modules"]; len(members) != len(graph.Packages)-1 {
+		t.Errorf("%d package rows linked to modules, want %d", len(members), len(graph.Packages)-1)
+	}
+
+	graph.Modules = nil
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		if stmt.Desc == "creating modules" {
+			t.Error("modules written for a graph without any")
+		}
+	}
+}
+
+func TestBuildStatementsCommit(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	tests := []struct {
+		commit string
+		want   any
+	}{
+		{"", nil},
+		{"abc123", "abc123"},
+	}
+	for _, tt := range tests {
+		stmts := BuildStatements("App", graph, RunInfo{ID: "run-1", Commit: tt.commit}, StatementOptions{})
+		for _, stmt := range stmts {
+			if stmt.Params == nil || !strings.Contains(stmt.Query, "$commit") {
+				continue
+			}
+			if got, ok := stmt.Params["commit"]; !ok || got != tt.want {
+				t.Errorf("commit %q: %s commit param = %v, want %v", tt.commit, stmt.Desc, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestStatementRows(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	rows := 0
+	for _, stmt := range BuildStatements("App", graph, RunInfo{ID: "run-1"}, StatementOptions{}) {
+		rows += len(stmt.Rows)
+	}
+	// Unresolved callees are counted and implementations are not, so the
+	// estimate is off but stays close
+	if got := StatementRows(graph, StatementOptions{}); got < rows/2 || got > 2*rows {
+		t.Errorf("StatementRows = %d, written %d", got, rows)
+	}
+	if full, changed := StatementRows(graph, StatementOptions{}), StatementRows(graph, StatementOptions{Files: []string{"main.go"}}); changed >= full || changed == 0 {
+		t.Errorf("StatementRows = %d for one file, %d for all", changed, full)
+	}
+}
+
+func TestStreamStatements(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	opts := StatementOptions{Labels: LabelMap{Prefix: "CG_"}}
+	want := BuildStatements("App", graph, RunInfo{ID: "run-1"}, opts)
+	var got []Statement
+	for stmt := range StreamStatements(context.Background(), "App", graph, RunInfo{ID: "run-1"}, opts) {
+		got = append(got, stmt)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed %d statements, differing from the %d built", len(got), len(want))
+	}
+
+	// Once the writer stops, the rest are dropped and the stream closed
+	ctx, cancel := context.WithCancel(context.Background())
+	stmts := StreamStatements(ctx, "App", graph, RunInfo{ID: "run-1"}, opts)
+	<-stmts
+	cancel()
+	n := 0
+	for range stmts {
+		n++
+	}
+	if n > statementBuffer+1 {
+		t.Errorf("%d of %d statements streamed after cancelling", n, len(want))
+	}
+}
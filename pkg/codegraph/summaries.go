@@ -0,0 +1,165 @@
+package codegraph
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SummaryStore is implemented by backends that keep what a session did in
+// a few sentences, so later sessions can pick up where it left off by
+// reading a handful of summaries rather than replaying every memory
+type SummaryStore interface {
+	// Summarize stores a summary of a session, assigning its ID and
+	// CreatedAt, and links it to the session and the nodes it is about.
+	// Without About, it is about the files the session touched.
+	Summarize(ctx context.Context, project string, summary Summary) (Summary, error)
+	// Summaries lists the summaries matching q, newest first
+	Summaries(ctx context.Context, project string, q SummaryQuery) ([]Summary, error)
+}
+
+// Summary is an end-of-session summary: what was done during Session and
+// the nodes, named by key, it was done to. Packages are the directories
+// of those nodes, by which summaries are recalled.
+type Summary struct {
+	ID        string    `json:"id"`
+	Session   string    `json:"session"`
+	Text      string    `json:"text"`
+	About     []string  `json:"about"`
+	Packages  []string  `json:"packages"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Redacted is set when redaction changed the text, see RedactSummary
+	Redacted bool `json:"redacted,omitempty"`
+	GitState
+}
+
+// SummaryQuery selects summaries: those about Package, by its path, and of
+// Session, if set, at most Limit of them if positive
+type SummaryQuery struct {
+	Package string
+	Session string
+	Limit   int
+}
+
+// ErrInvalidSummary is returned for a summary without text or a session,
+// or about no node or an invalid key
+var ErrInvalidSummary = errors.New("invalid summary")
+
+// newSummaryID returns an ID that sorts by creation time
+func newSummaryID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return "sum-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// summaryPackages returns the packages holding the nodes keyed in about,
+// in order of first use
+func summaryPackages(about []string) []string {
+	packages := []string{}
+	for _, key := range about {
+		for _, enclosing := range EnclosingKeys(key) {
+			if pkg, ok := strings.CutPrefix(enclosing, "Package:"); ok && !slices.Contains(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	return packages
+}
+
+// cypherSummarize checks the session exists and every key given names a
+// live node, then creates the Summary node and links it to the session,
+// the commit and the nodes. Files the session touched are not checked, as
+// they may not be indexed yet; they are linked when they are.
+func cypherSummarize(ctx context.Context, q Querier, labels LabelMap, project string, summary Summary) (Summary, error) {
+	if strings.TrimSpace(summary.Text) == "" || summary.Session == "" {
+		return Summary{}, fmt.Errorf("%w: no text or session", ErrInvalidSummary)
+	}
+	session, err := readSession(ctx, q, labels, project, summary.Session)
+	if err != nil {
+		return Summary{}, err
+	}
+	if len(summary.About) > 0 {
+		if err := checkKeys(ctx, q, labels, project, summary.About, ErrInvalidSummary); err != nil {
+			return Summary{}, err
+		}
+	} else {
+		for _, file := range session.Touched {
+			summary.About = append(summary.About, "File:"+file)
+		}
+		if len(summary.About) == 0 {
+			return Summary{}, fmt.Errorf("%w: session %s touched no file, give the keys it is about", ErrInvalidSummary, summary.Session)
+		}
+	}
+
+	now := time.Now().UTC()
+	summary.ID, summary.CreatedAt, summary.Packages = newSummaryID(now), now, summaryPackages(summary.About)
+	_, _, err = q.Query(ctx, fmt.Sprintf(`
+		CREATE (:%s:%s {id: $id, session: $session, text: $text, about: $about, packages: $packages, createdAt: $createdAt,
+		        redacted: $redacted, commit: $commit, branch: $branch, pr: $pr})
+	`, project, labels.Label("Summary")), map[string]any{
+		"id": summary.ID, "session": summary.Session, "text": summary.Text, "about": summary.About,
+		"packages": summary.Packages, "createdAt": now, "redacted": summary.Redacted,
+		"commit": summary.Commit, "branch": summary.Branch, "pr": summary.PR,
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	if err := recordInSession(ctx, q, labels, project, summary.Session, "Summary", summary.ID); err != nil {
+		return Summary{}, err
+	}
+	if err := recordAtCommit(ctx, q, labels, project, "Summary", summary.ID, summary.GitState); err != nil {
+		return Summary{}, err
+	}
+	links := make([]nodeLink, len(summary.About))
+	for i, key := range summary.About {
+		links[i] = nodeLink{summary.ID, key}
+	}
+	return summary, linkNodes(ctx, q, labels, project, "Summary", "ABOUT", links)
+}
+
+// cypherSummaries lists the summaries matching sq, newest first
+func cypherSummaries(ctx context.Context, q Querier, labels LabelMap, project string, sq SummaryQuery) ([]Summary, error) {
+	limit := ""
+	if sq.Limit > 0 {
+		limit = fmt.Sprintf("LIMIT %d", sq.Limit)
+	}
+	if sq.Package != "" {
+		sq.Package = path.Clean(sq.Package)
+	}
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (s:%s:%s)
+		WHERE ($package = '' OR $package IN s.packages) AND ($session = '' OR s.session = $session)
+		RETURN s.id AS id, s.session AS session, s.text AS text, s.about AS about, s.packages AS packages,
+		       s.createdAt AS createdAt, s.commit AS commit, s.branch AS branch, s.pr AS pr,
+		       coalesce(s.redacted, false) AS redacted
+		ORDER BY s.id DESC
+		%s
+	`, project, labels.Label("Summary"), limit), map[string]any{"package": sq.Package, "session": sq.Session})
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]Summary, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 10 {
+			continue
+		}
+		redacted, _ := row[9].(bool)
+		summaries = append(summaries, Summary{
+			ID:        fmt.Sprint(row[0]),
+			Session:   fmt.Sprint(row[1]),
+			Text:      fmt.Sprint(row[2]),
+			About:     stringList(row[3]),
+			Packages:  stringList(row[4]),
+			CreatedAt: timeValue(row[5]),
+			Redacted:  redacted,
+			GitState:  gitState(row[6:9]),
+		})
+	}
+	return summaries, nil
+}
@@ -0,0 +1,107 @@
+package codegraph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryPackages(t *testing.T) {
+	got := summaryPackages([]string{"File:main.go", "Function:store/store.go:*Store.Put", "Package:store", "Package:cache", "bad"})
+	if want := []string{".", "store", "cache"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("summaryPackages = %q, want %q", got, want)
+	}
+}
+
+func TestCypherSummarize(t *testing.T) {
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		switch {
+		case strings.HasPrefix(query, "MATCH (s:App:Session) WHERE"):
+			if params["id"] == "ses-1" {
+				return [][]any{sessionRow}
+			}
+			if params["id"] == "ses-2" {
+				return [][]any{{"ses-2", "2026-03-04T05:06:07Z", nil, nil, nil, []any{}, int64(0), nil}}
+			}
+		case strings.HasSuffix(query, "AS count"):
+			if params["path"] == "store" {
+				return [][]any{{int64(1)}}
+			}
+			return [][]any{{int64(0)}}
+		}
+		return nil
+	}}
+	summary, err := cypherSummarize(context.Background(), q, LabelMap{}, "App", Summary{
+		Session: "ses-1", Text: "Made main exit 2 on bad flags", GitState: GitState{Commit: "abc123"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(summary.ID, "sum-") || !reflect.DeepEqual(summary.About, []string{"File:main.go"}) ||
+		!reflect.DeepEqual(summary.Packages, []string{"."}) {
+		t.Errorf("summary = %+v", summary)
+	}
+	var created, linked, recorded bool
+	for i, query := range q.queries {
+		switch {
+		case strings.HasPrefix(query, "CREATE (:App:Summary {"):
+			created = q.params[i]["session"] == "ses-1" && q.params[i]["commit"] == "abc123"
+		case strings.HasSuffix(query, "MERGE (m)-[:ABOUT]->(n)"):
+			linked = strings.Contains(query, "MATCH (n:App:File {path: row.path})")
+		case strings.HasSuffix(query, "MERGE (s)-[:RECORDED]->(n)"):
+			recorded = q.params[i]["id"] == summary.ID
+		}
+	}
+	if !created || !linked || !recorded {
+		t.Errorf("created %v, linked %v, recorded %v in %q", created, linked, recorded, q.queries)
+	}
+
+	summary, err = cypherSummarize(context.Background(), q, LabelMap{}, "App", Summary{Session: "ses-2", Text: "x", About: []string{"Package:store"}})
+	if err != nil || !reflect.DeepEqual(summary.Packages, []string{"store"}) {
+		t.Errorf("summary = %+v, %v", summary, err)
+	}
+
+	tests := []struct {
+		summary Summary
+		want    error
+	}{
+		{Summary{Session: "ses-1"}, ErrInvalidSummary},
+		{Summary{Text: "x"}, ErrInvalidSummary},
+		{Summary{Session: "ses-9", Text: "x"}, ErrNoSession},
+		{Summary{Session: "ses-2", Text: "x"}, ErrInvalidSummary},
+		{Summary{Session: "ses-1", Text: "x", About: []string{"bad"}}, ErrInvalidSummary},
+		{Summary{Session: "ses-1", Text: "x", About: []string{"Package:gone"}}, ErrNoNode},
+	}
+	for _, tt := range tests {
+		if _, err := cypherSummarize(context.Background(), q, LabelMap{}, "App", tt.summary); !errors.Is(err, tt.want) {
+			t.Errorf("%+v: err = %v, want %v", tt.summary, err, tt.want)
+		}
+	}
+}
+
+func TestCypherSummaries(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	q := &recordingQuerier{answer: func(query string, params map[string]any) [][]any {
+		return [][]any{
+			{"sum-2", "ses-2", "Cached Put", []any{"Function:store/store.go:*Store.Put"}, []any{"store"}, created, "abc123", "main", int64(42), true},
+			{"sum-1", "ses-1", "short"},
+		}
+	}}
+	summaries, err := cypherSummaries(context.Background(), q, LabelMap{}, "App", SummaryQuery{Package: "./store/", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Summary{{
+		ID: "sum-2", Session: "ses-2", Text: "Cached Put", About: []string{"Function:store/store.go:*Store.Put"}, Packages: []string{"store"},
+		CreatedAt: created, Redacted: true, GitState: GitState{Commit: "abc123", Branch: "main", PR: 42},
+	}}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("summaries = %+v, want %+v", summaries, want)
+	}
+	if !strings.HasSuffix(q.queries[0], "LIMIT 3") || q.params[0]["package"] != "store" || q.params[0]["session"] != "" {
+		t.Errorf("query %q with %v", q.queries[0], q.params[0])
+	}
+}
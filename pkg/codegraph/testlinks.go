@@ -0,0 +1,105 @@
+package codegraph
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// TestLink is a TESTS relationship: the test function keyed Test reaches
+// the function keyed Target through Depth calls, 1 if it calls it itself
+type TestLink struct {
+	Test   string `json:"test"`
+	Target string `json:"target"`
+	Depth  int    `json:"depth"`
+}
+
+// TestReach is how many calls deep TestLinks follows a test
+const TestReach = 3
+
+// TestLinks links each function go test runs, in the _test.go files of g,
+// to the functions outside them it reaches within TestReach calls or
+// references, resolved like Graph.Calls whether or not the calls feature
+// is on. Links are ordered by test and target.
+func (g *Graph) TestLinks() []TestLink {
+	packages := make(map[string]string, len(g.Files))
+	for _, file := range g.Files {
+		packages[file.Path] = file.Package
+	}
+	full := withRefs(g)
+	full.Features = Features{"calls": true}
+	callees := make(map[string][]string)
+	for _, call := range full.Calls() {
+		callees[call.From] = append(callees[call.From], call.To)
+	}
+	files := make(map[string]string, len(g.Functions))
+	for _, fn := range g.Functions {
+		files[fn.Key()] = fn.File
+	}
+
+	var links []TestLink
+	for _, fn := range g.Functions {
+		if !strings.HasSuffix(fn.File, "_test.go") || !isEntryPoint(fn, packages[fn.File]) {
+			continue
+		}
+		reached := map[string]bool{fn.Key(): true}
+		frontier := []string{fn.Key()}
+		for depth := 1; depth <= TestReach && len(frontier) > 0; depth++ {
+			var next []string
+			for _, key := range frontier {
+				for _, callee := range callees[key] {
+					if reached[callee] {
+						continue
+					}
+					reached[callee] = true
+					next = append(next, callee)
+					if !strings.HasSuffix(files[callee], "_test.go") {
+						links = append(links, TestLink{Test: fn.Key(), Target: callee, Depth: depth})
+					}
+				}
+			}
+			frontier = next
+		}
+	}
+	slices.SortFunc(links, func(a, b TestLink) int {
+		return cmp.Or(strings.Compare(a.Test, b.Test), strings.Compare(a.Target, b.Target))
+	})
+	return links
+}
+
+// ChangedFunctions returns the functions of g outside _test.go files that
+// a zero-context diff against g's tree, as git diff -U0 writes it, adds or
+// changes lines in, in the order of g
+func ChangedFunctions(g *Graph, diff string) []FunctionNode {
+	functions := make(map[string][]FunctionNode)
+	for _, fn := range g.Functions {
+		if !strings.HasSuffix(fn.File, "_test.go") {
+			functions[fn.File] = append(functions[fn.File], fn)
+		}
+	}
+	touched := make(map[string]bool)
+	for _, change := range parseDiff(diff) {
+		for _, h := range change.Hunks {
+			for key := range hunkFunctions(functions[change.Path], nil, h) {
+				touched[key] = true
+			}
+		}
+	}
+	var changed []FunctionNode
+	for _, fn := range g.Functions {
+		if touched[fn.Key()] {
+			changed = append(changed, fn)
+		}
+	}
+	return changed
+}
+
+// UntestedChanges returns the functions ChangedFunctions finds that no
+// test of g reaches, see Graph.TestLinks
+func UntestedChanges(g *Graph, diff string) []FunctionNode {
+	tested := make(map[string]bool)
+	for _, link := range g.TestLinks() {
+		tested[link.Target] = true
+	}
+	return slices.DeleteFunc(ChangedFunctions(g, diff), func(fn FunctionNode) bool { return tested[fn.Key()] })
+}
@@ -0,0 +1,82 @@
+package codegraph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var testLinksTree = map[string]string{
+	"store/store.go": `package store
+
+type Store struct{ keys []string }
+
+func New() *Store { return &Store{} }
+
+func (s *Store) Put(key string) {
+	s.keys = append(s.keys, key)
+	s.flush()
+}
+
+func (s *Store) flush() {}
+
+func (s *Store) Len() int {
+	return len(s.keys)
+}
+`,
+	"store/store_test.go": `package store
+
+import "testing"
+
+func TestPut(t *testing.T) {
+	s := newStore(t)
+	s.Put("k")
+}
+
+func newStore(t *testing.T) *Store { return New() }
+`,
+}
+
+func TestTestLinks(t *testing.T) {
+	graph, err := Parser{Root: writeTree(t, testLinksTree), Filter: Filter{Tests: true}}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TestLink{
+		{Test: "Function:store/store_test.go:TestPut", Target: "Function:store/store.go:*Store.Put", Depth: 1},
+		{Test: "Function:store/store_test.go:TestPut", Target: "Function:store/store.go:*Store.flush", Depth: 2},
+		{Test: "Function:store/store_test.go:TestPut", Target: "Function:store/store.go:New", Depth: 2},
+	}
+	if links := graph.TestLinks(); !reflect.DeepEqual(links, want) {
+		t.Errorf("links = %+v, want %+v", links, want)
+	}
+
+	// Changing Put, a line of Len and deleting a line of the test
+	diff := `diff --git a/store/store.go b/store/store.go
+--- a/store/store.go
++++ b/store/store.go
+@@ -8 +8 @@ func (s *Store) Put(key string) {
+-	s.keys = append(s.keys, key, key)
++	s.keys = append(s.keys, key)
+@@ -15,0 +16 @@ func (s *Store) Len() int {
++	return len(s.keys)
+diff --git a/store/store_test.go b/store/store_test.go
+--- a/store/store_test.go
++++ b/store/store_test.go
+@@ -7,0 +8 @@ func TestPut(t *testing.T) {
++	s.Put("k")
+`
+	var changed, untested []string
+	for _, fn := range ChangedFunctions(graph, diff) {
+		changed = append(changed, fn.Key())
+	}
+	for _, fn := range UntestedChanges(graph, diff) {
+		untested = append(untested, fn.Key())
+	}
+	if want := []string{"Function:store/store.go:*Store.Put", "Function:store/store.go:*Store.Len"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %q, want %q", changed, want)
+	}
+	if want := []string{"Function:store/store.go:*Store.Len"}; !reflect.DeepEqual(untested, want) {
+		t.Errorf("untested = %q, want %q", untested, want)
+	}
+}
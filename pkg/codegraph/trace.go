@@ -0,0 +1,31 @@
+package codegraph
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records a span for every file parsed, batch written and query run.
+// It reports to the global OpenTelemetry provider, so the spans cost next to
+// nothing until a program installs one.
+var tracer = otel.Tracer("github.com/amarodeabreu/claude-graph-memory/pkg/codegraph")
+
+// endSpan marks span failed if err is not nil, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startQuery starts the span of a query run against a system such as neo4j
+func startQuery(ctx context.Context, system, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "query", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system.name", system),
+		attribute.String("db.query.text", TrimQuery(query))))
+}
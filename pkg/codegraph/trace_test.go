@@ -0,0 +1,106 @@
+package codegraph
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     = tracetest.NewSpanRecorder()
+)
+
+// traceSpans starts a trace recorded by the global tracer provider. The
+// returned function ends it and lists the spans below its root. Tracers
+// delegate to the first provider installed, so every test shares one.
+func traceSpans(t *testing.T) (context.Context, func() []sdktrace.ReadOnlySpan) {
+	t.Helper()
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+	ctx, root := otel.Tracer("test").Start(context.Background(), t.Name())
+	return ctx, func() []sdktrace.ReadOnlySpan {
+		root.End()
+		var spans []sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if span.SpanContext().TraceID() == root.SpanContext().TraceID() && span.SpanContext().SpanID() != root.SpanContext().SpanID() {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTraceParse(t *testing.T) {
+	ctx, spans := traceSpans(t)
+	root := writeTree(t, map[string]string{"a.go": "package a\n", "broken.go": "package"})
+	if _, err := (Parser{Root: root}).Parse(ctx); err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans() {
+		if span.Name() == "parse file" {
+			files[filepath.Base(spanAttr(span, "code.file.path").AsString())] = span
+		}
+	}
+	if len(files) != 2 {
+		t.Fatalf("%d parse file spans, want 2", len(files))
+	}
+	for name, span := range files {
+		if span.Parent().SpanID() != trace.SpanContextFromContext(ctx).SpanID() {
+			t.Errorf("%s: span is not a child of the caller's", name)
+		}
+	}
+	if files["a.go"].Status().Code != codes.Unset || files["broken.go"].Status().Code != codes.Error {
+		t.Errorf("status a.go %v, broken.go %v; want only broken.go failed", files["a.go"].Status(), files["broken.go"].Status())
+	}
+}
+
+func TestTraceBatches(t *testing.T) {
+	ctx, spans := traceSpans(t)
+	deadlock := &neo4j.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected"}
+	session := &fakeSession{fail: func(run, rows int) error { return map[bool]error{true: deadlock}[run == 2] }}
+	w := &batchWriter{session: session, opts: WriteOptions{BatchSize: 2}, stats: &WriteStats{}, size: 2, progress: noProgress{}}
+	stmt := Statement{Desc: "creating files", Query: "UNWIND $rows AS row CREATE (:File)", Rows: make([]map[string]any, 5)}
+	if err := w.write(ctx, stmt); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows, failed, retried int
+	for _, span := range spans() {
+		if span.Name() != "write batch" || spanAttr(span, "codegraph.statement").AsString() != "creating files" {
+			t.Errorf("unexpected span %s %v", span.Name(), span.Attributes())
+			continue
+		}
+		if span.Status().Code == codes.Error {
+			failed++
+			continue
+		}
+		rows += int(spanAttr(span, "codegraph.rows").AsInt64())
+		if spanAttr(span, "codegraph.retries").AsInt64() > 0 {
+			retried++
+		}
+	}
+	if rows != 5 || failed != 1 || retried != 1 {
+		t.Errorf("batches wrote %d rows with %d failed and %d retried, want 5, 1 and 1", rows, failed, retried)
+	}
+}
@@ -0,0 +1,367 @@
+package codegraph
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/tools/go/packages"
+)
+
+// resolver resolves the names the calls and references in node are
+// written by, those of names, with recvName the receiver variable of the
+// function holding it and recvType its type
+type resolver func(node ast.Node, recvName, recvType string, names []string) map[string]string
+
+// typedFile is a file go/packages parsed, and the type information of the
+// package it was checked in
+type typedFile struct {
+	syntax *ast.File
+	info   *types.Info
+}
+
+// parseTyped parses the files under root that filter includes like
+// parseCodebase, but loads their packages with go/packages and
+// type-checks them, so the names their functions call and refer to are
+// resolved, see FunctionNode.Resolved. Files no package loaded, such as
+// those of nested modules, left out by build constraints or failing to
+// parse, are parsed on their own, as is every file if the packages cannot
+// be loaded at all or are not in a module. Each module of root is loaded
+// on its own. Type errors are only warnings. With a cache, packages
+// unchanged since they were cached are read back and only the others are
+// loaded, see packageKeys.
+func parseTyped(ctx context.Context, root string, filter Filter, cache *parseCache) (*Graph, error) {
+	paths, err := sourceFiles(root, filter)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	modules, err := findModules(root, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) == 0 {
+		slog.Info("not type-checking outside a module, resolving calls by name", "root", root)
+		return parseMerged(ctx, root, paths, nil, cache), nil
+	}
+
+	// Every package is loaded unless it is cached. Packages are taken in
+	// the order they are listed, or else loaded, as the first to hold a
+	// file gives its fragment.
+	loads := make([][]string, len(modules))
+	entries := make(map[string]*typedPackage)
+	hashes := make(map[string]string)
+	var order []string
+	ordered := make(map[string]bool)
+	if cache != nil {
+		lists := make([][]*packages.Package, len(modules))
+		for i, m := range modules {
+			lists[i], err = listPackages(ctx, filepath.Join(root, m.Dir), filter)
+			if err != nil {
+				slog.Warn("could not list packages, loading them all", "module", m.Path, "err", err)
+				lists[i] = nil
+			}
+		}
+		hashes = packageKeys(abs, modules, lists, filter)
+		for i, list := range lists {
+			if list == nil {
+				loads[i] = []string{"./..."}
+				continue
+			}
+			for _, pkg := range list {
+				if !underRoot(abs, pkg.CompiledGoFiles) {
+					continue
+				}
+				ordered[pkg.ID] = true
+				order = append(order, pkg.ID)
+				var entry typedPackage
+				if hash := hashes[pkg.ID]; hash != "" && cache.load(hash, &entry) {
+					entries[pkg.ID] = &entry
+					continue
+				}
+				pattern := pkg.PkgPath
+				if pkg.ForTest != "" {
+					pattern = pkg.ForTest
+				}
+				if !slices.Contains(loads[i], pattern) {
+					loads[i] = append(loads[i], pattern)
+				}
+			}
+		}
+	} else {
+		for i := range loads {
+			loads[i] = []string{"./..."}
+		}
+	}
+
+	// The packages of a module leave out those of the modules below it
+	fset := token.NewFileSet()
+	var pkgs []*packages.Package
+	for i, m := range modules {
+		if len(loads[i]) == 0 {
+			continue
+		}
+		loaded, err := loadPackages(ctx, fset, filepath.Join(root, m.Dir), filter, loads[i]...)
+		if err != nil {
+			slog.Warn("could not load packages, resolving calls by name", "module", m.Path, "err", err)
+		}
+		pkgs = append(pkgs, loaded...)
+	}
+
+	// With tests, a package is loaded again with its _test.go files, and
+	// each file's first type information is kept. Every loading declares
+	// its own objects, so functions are matched across them by funcID.
+	var loaded []*packages.Package
+	reported := make(map[string]bool)
+	for _, pkg := range pkgs {
+		broken := pkg.TypesInfo == nil
+		for _, e := range pkg.Errors {
+			broken = broken || e.Kind == packages.ParseError
+			if !reported[e.Error()] {
+				reported[e.Error()] = true
+				slog.Warn("type-checking failed", "package", pkg.PkgPath, "err", e.Error())
+			}
+		}
+		if broken {
+			delete(entries, pkg.ID)
+			continue
+		}
+		entry := &typedPackage{Files: make(map[string]*Graph), Keys: make(map[string]string), fresh: true}
+		for _, file := range pkg.Syntax {
+			relPath, err := filepath.Rel(abs, fset.File(file.Pos()).Name())
+			if err != nil || strings.HasPrefix(relPath, "..") {
+				continue
+			}
+			for _, decl := range file.Decls {
+				d, ok := decl.(*ast.FuncDecl)
+				if !ok {
+					continue
+				}
+				obj, ok := pkg.TypesInfo.Defs[d.Name].(*types.Func)
+				if !ok {
+					continue
+				}
+				fn := FunctionNode{Name: d.Name.Name, File: relPath}
+				if d.Recv != nil && len(d.Recv.List) > 0 {
+					fn.Receiver = exprToString(d.Recv.List[0].Type)
+				}
+				entry.Keys[funcID(obj)] = fn.Key()
+			}
+		}
+		if !ordered[pkg.ID] {
+			ordered[pkg.ID] = true
+			order = append(order, pkg.ID)
+		}
+		entries[pkg.ID] = entry
+		loaded = append(loaded, pkg)
+	}
+	keys := make(map[string]string)
+	for _, entry := range entries {
+		maps.Copy(keys, entry.Keys)
+	}
+
+	// Without a cache only the file's first package parses it
+	parsed := make(map[string]bool)
+	for _, pkg := range loaded {
+		entry := entries[pkg.ID]
+		for _, file := range pkg.Syntax {
+			relPath, err := filepath.Rel(abs, fset.File(file.Pos()).Name())
+			if err != nil || strings.HasPrefix(relPath, "..") || cache == nil && parsed[relPath] {
+				continue
+			}
+			parsed[relPath] = true
+			info := pkg.TypesInfo
+			entry.Files[filepath.ToSlash(relPath)] = fileGraph(fset, relPath, file, func(node ast.Node, recvName, recvType string, names []string) map[string]string {
+				return resolveNames(node, recvName, recvType, names, info, keys)
+			})
+		}
+	}
+	files := make(map[string]*Graph)
+	cached := make(map[string]bool)
+	for _, id := range order {
+		entry, ok := entries[id]
+		if !ok {
+			continue
+		}
+		if cache != nil && entry.fresh && hashes[id] != "" {
+			cache.store(hashes[id], entry)
+		}
+		for relPath, fragment := range entry.Files {
+			if _, ok := files[relPath]; !ok {
+				files[relPath] = fragment
+				cached[relPath] = !entry.fresh
+			}
+		}
+	}
+
+	fragments := make([]*Graph, len(paths))
+	var rest []string
+	var restIndex []int
+	for i, path := range paths {
+		relPath, _ := filepath.Rel(root, path)
+		fragment, ok := files[filepath.ToSlash(relPath)]
+		if !ok {
+			rest = append(rest, path)
+			restIndex = append(restIndex, i)
+			continue
+		}
+		if cache != nil && cached[filepath.ToSlash(relPath)] {
+			cache.hits.Add(1)
+		} else if cache != nil {
+			cache.misses.Add(1)
+		}
+		fragments[i] = fragment
+	}
+	var failed []ParseFailure
+	if len(rest) > 0 {
+		failed = parseFiles(ctx, root, rest, nil, cache, func(i int, fragment *Graph) {
+			fragments[restIndex[i]] = fragment
+		})
+	}
+	graph := mergeFragments(fragments)
+	graph.Failed = failed
+	return graph, nil
+}
+
+// listPackages lists the packages below dir, with their files and
+// imports, as loadPackages would load them, but parses nothing
+func listPackages(ctx context.Context, dir string, filter Filter) ([]*packages.Package, error) {
+	return packages.Load(&packages.Config{
+		Context: ctx,
+		Dir:     dir,
+		Tests:   filter.Tests,
+		Mode:    packages.NeedName | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedForTest,
+	}, "./...")
+}
+
+// underRoot reports whether any of files is below root
+func underRoot(root string, files []string) bool {
+	return slices.ContainsFunc(files, func(file string) bool {
+		rel, err := filepath.Rel(root, file)
+		return err == nil && !strings.HasPrefix(rel, "..")
+	})
+}
+
+// loadPackages loads and type-checks the packages below dir, in one
+// module, with their tests if filter includes them. Of a file over its
+// MaxFileSize only the package clause is parsed. patterns are those of go
+// list, ./... if none.
+func loadPackages(ctx context.Context, fset *token.FileSet, dir string, filter Filter, patterns ...string) ([]*packages.Package, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	ctx, span := tracer.Start(ctx, "load packages", trace.WithAttributes(attribute.String("code.directory", dir)))
+	pkgs, err := packages.Load(&packages.Config{
+		Context: ctx,
+		Dir:     dir,
+		Fset:    fset,
+		Tests:   filter.Tests,
+		Mode:    packages.NeedName | packages.NeedCompiledGoFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+			if filter.MaxFileSize > 0 && int64(len(src)) > filter.MaxFileSize {
+				return parser.ParseFile(fset, filename, src, parser.PackageClauseOnly)
+			}
+			return parser.ParseFile(fset, filename, src, parser.ParseComments)
+		},
+	}, patterns...)
+	endSpan(span, err)
+	return pkgs, err
+}
+
+// funcID identifies a function or method by its package, receiver type and
+// name, the same for every object a type checker makes of it
+func funcID(fn *types.Func) string {
+	fn = fn.Origin()
+	var id string
+	if fn.Pkg() != nil {
+		id = fn.Pkg().Path()
+	}
+	id += "\x00"
+	if recv := fn.Signature().Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if named, ok := types.Unalias(t).(*types.Named); ok {
+			id += named.Obj().Name()
+		}
+	}
+	return id + "\x00" + fn.Name()
+}
+
+// resolveNames maps each of names, written like the callees of
+// extractCalls, to the key in keys, by funcID, of the function info says
+// it names in node, or to "" if it names a variable, a conversion, a
+// builtin or a function keys lacks. Methods of interfaces, which only
+// the IMPLEMENTS relationships can resolve, and names info does not know
+// are left out.
+func resolveNames(node ast.Node, recvName, recvType string, names []string, info *types.Info, keys map[string]string) map[string]string {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var resolved map[string]string
+	add := func(name string, id *ast.Ident) {
+		if _, ok := resolved[name]; ok || !wanted[name] {
+			return
+		}
+		var key string
+		switch obj := info.Uses[id].(type) {
+		case nil:
+			return
+		case *types.Func:
+			if recv := obj.Signature().Recv(); recv != nil && types.IsInterface(recv.Type()) {
+				return
+			}
+			key = keys[funcID(obj)]
+		}
+		if resolved == nil {
+			resolved = make(map[string]string)
+		}
+		resolved[name] = key
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			qualifier := exprToString(n.X)
+			if recvName != "" && qualifier == recvName {
+				qualifier = recvType
+			}
+			add(qualifier+"."+n.Sel.Name, n.Sel)
+			// The selected name is not a bare one
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.Ident:
+			add(n.Name, n)
+		}
+		return true
+	}
+	ast.Inspect(node, visit)
+	return resolved
+}
+
+// mergeResolved adds the names of more to resolved, keeping those it has
+func mergeResolved(resolved, more map[string]string) map[string]string {
+	for name, key := range more {
+		if resolved == nil {
+			resolved = make(map[string]string)
+		}
+		if _, ok := resolved[name]; !ok {
+			resolved[name] = key
+		}
+	}
+	return resolved
+}
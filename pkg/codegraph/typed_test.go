@@ -0,0 +1,135 @@
+package codegraph
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// typedTree has calls that resolving by name gets wrong: a method called
+// through a field, a package imported under another name, and a method of
+// the standard library named like one of the project
+var typedTree = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.22\n",
+	"store/store.go": `package store
+
+type Store struct{}
+
+func New() *Store { return &Store{} }
+
+func (s *Store) Get(key string) string { return key }
+`,
+	"cache/cache.go": `package cache
+
+type Cache struct{}
+
+func (c *Cache) Get(key string) string { return key }
+
+func (c *Cache) Close() error { return nil }
+`,
+	"main.go": `package main
+
+import (
+	"os"
+
+	st "example.com/app/store"
+)
+
+type server struct {
+	store *st.Store
+}
+
+func (s *server) lookup(key string) string {
+	return s.store.Get(key)
+}
+
+func main() {
+	s := &server{store: st.New()}
+	s.lookup("k")
+	f, _ := os.Open("x")
+	f.Close()
+}
+`,
+}
+
+func TestParseTyped(t *testing.T) {
+	root := writeTree(t, typedTree)
+	graph, err := Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, rel := range graph.Calls() {
+		calls = append(calls, rel.From+" -> "+rel.To)
+	}
+	want := []string{
+		"Function:main.go:*server.lookup -> Function:store/store.go:*Store.Get",
+		"Function:main.go:main -> Function:store/store.go:New",
+		"Function:main.go:main -> Function:main.go:*server.lookup",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	syntax, err := Parser{Root: root, SyntaxOnly: true}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range syntax.Functions {
+		if fn.Resolved != nil {
+			t.Errorf("%s resolved %v without type-checking", fn.Key(), fn.Resolved)
+		}
+	}
+	// By name, st.New is found through the import table, but f.Close is
+	// the only Close of the project and s.store.Get one of two
+	var syntaxCalls []string
+	for _, rel := range syntax.Calls() {
+		syntaxCalls = append(syntaxCalls, rel.From+" -> "+rel.To)
+	}
+	want = []string{
+		"Function:main.go:main -> Function:store/store.go:New",
+		"Function:main.go:main -> Function:main.go:*server.lookup",
+		"Function:main.go:main -> Function:cache/cache.go:*Cache.Close",
+	}
+	if !slices.Equal(syntaxCalls, want) {
+		t.Errorf("syntax-only calls = %q, want %q", syntaxCalls, want)
+	}
+}
+
+func TestParseTypedFallback(t *testing.T) {
+	// Without a go.mod no package loads, and every file is parsed alone
+	files := make(map[string]string)
+	for path, src := range typedTree {
+		if path != "go.mod" {
+			files[path] = src
+		}
+	}
+	graph, err := Parser{Root: writeTree(t, files)}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Files) != 3 || len(graph.Functions) != 6 {
+		t.Errorf("parsed %d files and %d functions, want 3 and 6", len(graph.Files), len(graph.Functions))
+	}
+	for _, fn := range graph.Functions {
+		if fn.Resolved != nil {
+			t.Errorf("%s resolved %v without type-checking", fn.Key(), fn.Resolved)
+		}
+	}
+}
+
+func TestParseFailed(t *testing.T) {
+	files := maps.Clone(typedTree)
+	files["store/broken.go"] = "package store\n\nfunc Broken( {\n"
+	root := writeTree(t, files)
+	for _, syntaxOnly := range []bool{false, true} {
+		graph, err := Parser{Root: root, SyntaxOnly: syntaxOnly}.Parse(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(graph.Failed) != 1 || graph.Failed[0].File != "store/broken.go" || len(graph.Files) != 3 {
+			t.Errorf("syntax-only %v: failed %+v with %d files parsed, want store/broken.go and 3", syntaxOnly, graph.Failed, len(graph.Files))
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package codegraph
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Vulnerability is a known vulnerability in a module the project depends
+// on, as govulncheck reports it. Level says how exposed the project is:
+// "symbol" if its code calls a vulnerable function, "package" if it only
+// imports a vulnerable package, and "module" if it only requires the
+// module.
+type Vulnerability struct {
+	ID           string     `json:"id"`
+	Aliases      []string   `json:"aliases,omitempty"`
+	Summary      string     `json:"summary,omitempty"`
+	URL          string     `json:"url,omitempty"`
+	Module       string     `json:"module"`
+	Version      string     `json:"version,omitempty"`
+	FixedVersion string     `json:"fixedVersion,omitempty"`
+	Level        string     `json:"level"`
+	Paths        []CallPath `json:"paths,omitempty"`
+}
+
+// CallPath is a chain of calls from the function keyed From to a
+// vulnerable one: the functions called in turn, named like "html.Parse"
+// or "http.Server.Serve", ending with the vulnerable function
+type CallPath struct {
+	From  string   `json:"from"`
+	Calls []string `json:"calls"`
+}
+
+// The levels of a Vulnerability, least exposed first
+var vulnLevels = []string{"module", "package", "symbol"}
+
+// govulncheckMessage is one of the JSON objects govulncheck -json writes,
+// holding what is needed of an OSV entry or a finding
+type govulncheckMessage struct {
+	OSV *struct {
+		ID               string   `json:"id"`
+		Aliases          []string `json:"aliases"`
+		Summary          string   `json:"summary"`
+		DatabaseSpecific struct {
+			URL string `json:"url"`
+		} `json:"database_specific"`
+	} `json:"osv"`
+	Finding *struct {
+		OSV          string             `json:"osv"`
+		FixedVersion string             `json:"fixed_version"`
+		Trace        []govulncheckFrame `json:"trace"`
+	} `json:"finding"`
+}
+
+// govulncheckFrame is a step of a finding's trace, which runs from the
+// vulnerable symbol to the code of the module scanned
+type govulncheckFrame struct {
+	Module   string `json:"module"`
+	Version  string `json:"version"`
+	Package  string `json:"package"`
+	Function string `json:"function"`
+	Receiver string `json:"receiver"`
+	Position *struct {
+		Filename string `json:"filename"`
+		Line     int    `json:"line"`
+	} `json:"position"`
+}
+
+// name writes the frame's function like "html.Parse", or its package if
+// it has none
+func (f govulncheckFrame) name() string {
+	name := path.Base(f.Package)
+	if f.Receiver != "" {
+		name += "." + strings.TrimPrefix(f.Receiver, "*")
+	}
+	if f.Function != "" {
+		name += "." + f.Function
+	}
+	return name
+}
+
+// RunGovulncheck runs govulncheck -json over the packages below root and
+// returns what it wrote, for AddVulnerabilities
+func RunGovulncheck(ctx context.Context, root string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "govulncheck", "-json", "./...")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return nil, fmt.Errorf("govulncheck: %w: %s", err, strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, fmt.Errorf("govulncheck: %w", err)
+	}
+	return out, nil
+}
+
+// AddVulnerabilities reads the output of govulncheck -json and records the
+// vulnerabilities it found in graph.Vulnerabilities, most exposed first,
+// each with the call paths reaching it from graph's functions. The
+// functions in a trace are matched by position, or else by the package
+// below the scanned module and name.
+func AddVulnerabilities(graph *Graph, report io.Reader) error {
+	entries := make(map[string]Vulnerability)
+	found := make(map[string]*Vulnerability)
+	var order []string
+	dec := json.NewDecoder(report)
+	for {
+		var msg govulncheckMessage
+		if err := dec.Decode(&msg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading govulncheck output: %w", err)
+		}
+		if msg.OSV != nil {
+			entries[msg.OSV.ID] = Vulnerability{ID: msg.OSV.ID, Aliases: msg.OSV.Aliases, Summary: msg.OSV.Summary,
+				URL: cmp.Or(msg.OSV.DatabaseSpecific.URL, "https://pkg.go.dev/vuln/"+msg.OSV.ID)}
+		}
+		if msg.Finding == nil || len(msg.Finding.Trace) == 0 {
+			continue
+		}
+		trace := msg.Finding.Trace
+		level := "module"
+		if trace[0].Function != "" {
+			level = "symbol"
+		} else if trace[0].Package != "" {
+			level = "package"
+		}
+		v, ok := found[msg.Finding.OSV]
+		if !ok {
+			v = &Vulnerability{ID: msg.Finding.OSV, Module: trace[0].Module, Version: trace[0].Version,
+				FixedVersion: msg.Finding.FixedVersion, Level: level}
+			found[v.ID] = v
+			order = append(order, v.ID)
+		}
+		if slices.Index(vulnLevels, level) > slices.Index(vulnLevels, v.Level) {
+			v.Level = level
+		}
+		if level == "symbol" {
+			v.Paths = appendCallPaths(v.Paths, graph, trace)
+		}
+	}
+
+	graph.Vulnerabilities = make([]Vulnerability, 0, len(order))
+	for _, id := range order {
+		v := *found[id]
+		entry := entries[id]
+		v.Aliases, v.Summary, v.URL = entry.Aliases, entry.Summary, cmp.Or(entry.URL, "https://pkg.go.dev/vuln/"+id)
+		slices.SortFunc(v.Paths, func(a, b CallPath) int { return strings.Compare(a.From, b.From) })
+		graph.Vulnerabilities = append(graph.Vulnerabilities, v)
+	}
+	slices.SortStableFunc(graph.Vulnerabilities, func(a, b Vulnerability) int {
+		return cmp.Or(slices.Index(vulnLevels, b.Level)-slices.Index(vulnLevels, a.Level), strings.Compare(a.ID, b.ID))
+	})
+	return nil
+}
+
+// appendCallPaths adds a path to paths for each function of graph in
+// trace, from it to the vulnerable function at the start of the trace,
+// keeping the shortest path from each function
+func appendCallPaths(paths []CallPath, graph *Graph, trace []govulncheckFrame) []CallPath {
+	module := trace[len(trace)-1].Module
+	for i := len(trace) - 1; i > 0; i-- {
+		fn, ok := traceFunction(graph, trace[i], module)
+		if !ok {
+			continue
+		}
+		p := CallPath{From: fn.Key()}
+		for j := i - 1; j >= 0; j-- {
+			p.Calls = append(p.Calls, trace[j].name())
+		}
+		if k := slices.IndexFunc(paths, func(c CallPath) bool { return c.From == p.From }); k < 0 {
+			paths = append(paths, p)
+		} else if len(p.Calls) < len(paths[k].Calls) {
+			paths[k] = p
+		}
+	}
+	return paths
+}
+
+// traceFunction returns the function of graph a frame of a trace through
+// module, the one scanned, is in
+func traceFunction(graph *Graph, frame govulncheckFrame, module string) (FunctionNode, bool) {
+	receiver := strings.TrimPrefix(frame.Receiver, "*")
+	for _, fn := range graph.Functions {
+		if frame.Position != nil && frame.Position.Filename != "" {
+			file := filepath.ToSlash(frame.Position.Filename)
+			if (file == fn.File || strings.HasSuffix(file, "/"+fn.File)) && fn.LineStart <= frame.Position.Line && frame.Position.Line <= fn.LineEnd {
+				return fn, true
+			}
+			continue
+		}
+		if frame.Module != module || fn.Name != frame.Function || strings.TrimPrefix(fn.Receiver, "*") != receiver {
+			continue
+		}
+		dir := cmp.Or(strings.TrimPrefix(strings.TrimPrefix(frame.Package, module), "/"), ".")
+		if filepath.ToSlash(filepath.Dir(fn.File)) == dir {
+			return fn, true
+		}
+	}
+	return FunctionNode{}, false
+}
@@ -0,0 +1,66 @@
+package codegraph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// govulncheckReport is govulncheck -json output for a module calling
+// html.Parse through two of its functions, importing a vulnerable package
+// without calling into it, and requiring a vulnerable module
+const govulncheckReport = `{"config": {"protocol_version": "v1.0.0", "scanner_name": "govulncheck"}}
+{"progress": {"message": "Scanning your code and 12 packages across 3 dependent modules for known vulnerabilities..."}}
+{"osv": {"id": "GO-2023-1571", "aliases": ["CVE-2022-41723"], "summary": "Denial of service via crafted HTTP/2 stream in net/http and golang.org/x/net", "database_specific": {"url": "https://pkg.go.dev/vuln/GO-2023-1571"}}}
+{"osv": {"id": "GO-2023-1988", "aliases": ["CVE-2023-3978"], "summary": "Improper rendering of text nodes in golang.org/x/net/html"}}
+{"osv": {"id": "GO-2022-0969", "summary": "Unbounded memory growth in net/http"}}
+{"finding": {"osv": "GO-2023-1988", "fixed_version": "v0.13.0", "trace": [{"module": "golang.org/x/net", "version": "v0.12.0"}]}}
+{"finding": {"osv": "GO-2023-1988", "fixed_version": "v0.13.0", "trace": [{"module": "golang.org/x/net", "version": "v0.12.0", "package": "golang.org/x/net/html"}]}}
+{"finding": {"osv": "GO-2023-1988", "fixed_version": "v0.13.0", "trace": [
+  {"module": "golang.org/x/net", "version": "v0.12.0", "package": "golang.org/x/net/html", "function": "Parse"},
+  {"module": "example.com/app", "package": "example.com/app/render", "function": "render", "receiver": "*Page", "position": {"filename": "/src/app/render/page.go", "line": 12}},
+  {"module": "example.com/app", "package": "example.com/app", "function": "main", "position": {"filename": "main.go", "line": 6}}
+]}}
+{"finding": {"osv": "GO-2023-1988", "fixed_version": "v0.13.0", "trace": [
+  {"module": "golang.org/x/net", "version": "v0.12.0", "package": "golang.org/x/net/html", "function": "Parse"},
+  {"module": "example.com/app", "package": "example.com/app/render", "function": "Preview"}
+]}}
+{"finding": {"osv": "GO-2023-1571", "fixed_version": "v0.7.0", "trace": [{"module": "golang.org/x/net", "version": "v0.12.0", "package": "golang.org/x/net/http2"}]}}
+`
+
+func TestAddVulnerabilities(t *testing.T) {
+	graph := &Graph{Functions: []FunctionNode{
+		{Name: "main", File: "main.go", LineStart: 5, LineEnd: 8},
+		{Name: "render", Receiver: "*Page", File: "render/page.go", LineStart: 10, LineEnd: 14},
+		{Name: "Preview", File: "render/page.go", LineStart: 16, LineEnd: 18},
+	}}
+	if err := AddVulnerabilities(graph, strings.NewReader(govulncheckReport)); err != nil {
+		t.Fatal(err)
+	}
+	want := []Vulnerability{
+		{
+			ID: "GO-2023-1988", Aliases: []string{"CVE-2023-3978"}, Summary: "Improper rendering of text nodes in golang.org/x/net/html",
+			URL: "https://pkg.go.dev/vuln/GO-2023-1988", Module: "golang.org/x/net", Version: "v0.12.0", FixedVersion: "v0.13.0", Level: "symbol",
+			Paths: []CallPath{
+				{From: "Function:main.go:main", Calls: []string{"render.Page.render", "html.Parse"}},
+				{From: "Function:render/page.go:*Page.render", Calls: []string{"html.Parse"}},
+				{From: "Function:render/page.go:Preview", Calls: []string{"html.Parse"}},
+			},
+		},
+		{
+			ID: "GO-2023-1571", Aliases: []string{"CVE-2022-41723"}, Summary: "Denial of service via crafted HTTP/2 stream in net/http and golang.org/x/net",
+			URL: "https://pkg.go.dev/vuln/GO-2023-1571", Module: "golang.org/x/net", Version: "v0.12.0", FixedVersion: "v0.7.0", Level: "package",
+		},
+	}
+	if !reflect.DeepEqual(graph.Vulnerabilities, want) {
+		t.Errorf("vulnerabilities =\n%+v\nwant\n%+v", graph.Vulnerabilities, want)
+	}
+
+	// A clean report leaves none rather than nil, so old ones are removed
+	if err := AddVulnerabilities(graph, strings.NewReader(`{"config": {}}`)); err != nil || graph.Vulnerabilities == nil || len(graph.Vulnerabilities) > 0 {
+		t.Errorf("clean report: err = %v, vulnerabilities %+v", err, graph.Vulnerabilities)
+	}
+	if err := AddVulnerabilities(graph, strings.NewReader(`{"finding": `)); err == nil {
+		t.Error("truncated output has no error")
+	}
+}
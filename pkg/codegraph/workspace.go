@@ -0,0 +1,118 @@
+package codegraph
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// UnusedAPI is the kind of finding UnusedAPIOf reports
+const UnusedAPI = "unused-api"
+
+// ModulePath returns the import path of the package at root: the path of
+// the module whose go.mod is in root or the nearest directory above it,
+// followed by root's directory below that one
+func ModulePath(root string) (string, error) {
+	file, below, err := findGoMod(root)
+	if err != nil {
+		return "", err
+	}
+	module, _, err := readGoMod(file)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(append([]string{module}, below...)...), nil
+}
+
+// findGoMod returns the go.mod in root or the nearest directory above it,
+// and the names of the directories below that one down to root
+func findGoMod(root string) (string, []string, error) {
+	dir, err := filepath.Abs(root)
+	if err != nil {
+		return "", nil, err
+	}
+	var below []string
+	for {
+		file := filepath.Join(dir, "go.mod")
+		if _, err := os.Stat(file); err == nil {
+			return file, below, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil, fmt.Errorf("no go.mod in %s or above it", root)
+		}
+		below = append([]string{filepath.Base(dir)}, below...)
+		dir = parent
+	}
+}
+
+// UnusedAPIOf finds the exported functions of library, whose packages are
+// imported below module, that DeadCode finds unused inside it and that no
+// function or package-level declaration of consumers calls or refers to,
+// so they can be unexported or removed without breaking any of them. The
+// consumers' calls are resolved through the imports of their files, like
+// Graph.Calls; methods are left out, as they may satisfy interfaces. A
+// library that none of consumers imports is not used as one, so has no
+// findings. Findings are ordered by file and line.
+func UnusedAPIOf(library *Graph, module string, consumers []*Graph) []Finding {
+	module = strings.TrimSuffix(module, "/")
+	used := make(map[string]bool)
+	importers := 0
+	for _, consumer := range consumers {
+		imports := false
+		for _, file := range consumer.Files {
+			for _, imp := range file.Imports {
+				imports = imports || imp == module || strings.HasPrefix(imp, module+"/")
+			}
+		}
+		if !imports {
+			continue
+		}
+		importers++
+
+		fileImports := make(map[string][]string, len(consumer.Files))
+		names := make(map[string][]string)
+		for _, file := range consumer.Files {
+			fileImports[file.Path] = file.Imports
+			names[file.Path] = append(names[file.Path], file.Uses...)
+		}
+		for _, fn := range consumer.Functions {
+			names[fn.File] = append(append(names[fn.File], fn.Calls...), fn.Refs...)
+		}
+		for file, list := range names {
+			for _, name := range list {
+				qualifier, name, ok := strings.Cut(name, ".")
+				if !ok || strings.Contains(name, ".") {
+					continue
+				}
+				for _, imp := range fileImports[file] {
+					if path.Base(imp) != qualifier || imp != module && !strings.HasPrefix(imp, module+"/") {
+						continue
+					}
+					dir := cmp.Or(strings.TrimPrefix(strings.TrimPrefix(imp, module), "/"), ".")
+					used[dir+"\x00"+name] = true
+				}
+			}
+		}
+	}
+	if importers == 0 {
+		return nil
+	}
+
+	var findings []Finding
+	for _, finding := range DeadCode(library) {
+		if finding.Kind != UnusedExport || used[filepath.ToSlash(filepath.Dir(finding.File))+"\x00"+finding.Name] {
+			continue
+		}
+		finding.Kind = UnusedAPI
+		finding.Message = fmt.Sprintf("%s is exported but not called or referred to in its module or the %d projects importing it", finding.Name, importers)
+		findings = append(findings, finding)
+	}
+	return findings
+}
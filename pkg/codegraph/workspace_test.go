@@ -0,0 +1,67 @@
+package codegraph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestModulePath(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":              "// The monorepo\nmodule \"example.com/mono\"\n\ngo 1.22\n",
+		"libs/store/store.go": "package store\n",
+	})
+	for dir, want := range map[string]string{root: "example.com/mono", filepath.Join(root, "libs", "store"): "example.com/mono/libs/store"} {
+		if got, err := ModulePath(dir); err != nil || got != want {
+			t.Errorf("%s: module path %q, %v, want %q", dir, got, err, want)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("go 1.22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ModulePath(root); err == nil {
+		t.Error("a go.mod without a module line has no error")
+	}
+}
+
+func TestUnusedAPIOf(t *testing.T) {
+	ctx := context.Background()
+	library, err := Parser{Root: writeTree(t, map[string]string{
+		"lib.go":         "package lib\n\nfunc Version() string { return \"1\" }\n\nfunc Deprecated() {}\n",
+		"store/store.go": "package store\n\nfunc Open() {}\n\nfunc Close() {}\n\nfunc Handler() {}\n\nfunc Internal() {}\n\nfunc Local() { Internal() }\n",
+	})}.Parse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One consumer calls Open and passes Handler, the other reads the
+	// version in a package-level declaration; one imports neither
+	var consumers []*Graph
+	for _, files := range []map[string]string{
+		{"main.go": "package main\n\nimport (\n\t\"example.com/lib/store\"\n\t\"net/http\"\n)\n\nfunc main() {\n\tstore.Open()\n\thttp.HandleFunc(\"/\", store.Handler)\n}\n"},
+		{"cli/cli.go": "package cli\n\nimport \"example.com/lib\"\n\nvar version = lib.Version()\n"},
+		{"other.go": "package other\n\nimport \"example.com/library/store\"\n\nfunc Run() { store.Close() }\n"},
+	} {
+		consumer, err := Parser{Root: writeTree(t, files)}.Parse(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		consumers = append(consumers, consumer)
+	}
+
+	var got []string
+	for _, finding := range UnusedAPIOf(library, "example.com/lib", consumers) {
+		if finding.Kind != UnusedAPI {
+			t.Errorf("finding = %+v", finding)
+		}
+		got = append(got, finding.Key)
+	}
+	want := []string{"Function:lib.go:Deprecated", "Function:store/store.go:Close", "Function:store/store.go:Local"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unused API = %v, want %v", got, want)
+	}
+	if findings := UnusedAPIOf(library, "example.com/lib", consumers[2:]); findings != nil {
+		t.Errorf("a library nothing imports has findings %+v", findings)
+	}
+}
@@ -0,0 +1,187 @@
+package codegraph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Writer stores a parsed code graph, replacing whatever the project had
+type Writer interface {
+	Write(ctx context.Context, project string, graph *Graph, run RunInfo) error
+	Close(ctx context.Context) error
+}
+
+// LabelMap renames the node labels written to Neo4j so the code graph can
+// coexist with other schemas in a shared instance. It is read from the JSON
+// file given by --label-map, e.g. {"prefix": "CG_", "rename": {"Struct": "Class"}}.
+type LabelMap struct {
+	Prefix string            `json:"prefix"`
+	Rename map[string]string `json:"rename"`
+}
+
+// labelPattern matches a node label in the queries this tool builds
+var labelPattern = regexp.MustCompile(`:(Package|File|Function|Method|Struct|Interface)\b`)
+
+// IdentPattern matches labels that need no quoting in Cypher
+var IdentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// LoadLabelMap reads a label map from a JSON file and checks its labels
+func LoadLabelMap(path string) (LabelMap, error) {
+	var m LabelMap
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+
+	seen := make(map[string]string)
+	for kind := range m.Rename {
+		if !slices.Contains(NodeLabels, kind) {
+			return m, fmt.Errorf("%s: unknown label %q, expected one of %s", path, kind, strings.Join(NodeLabels, ", "))
+		}
+	}
+	for _, kind := range NodeLabels {
+		label := m.Label(kind)
+		if !IdentPattern.MatchString(label) {
+			return m, fmt.Errorf("%s: invalid label %q for %s", path, label, kind)
+		}
+		if other, ok := seen[label]; ok {
+			return m, fmt.Errorf("%s: %s and %s both map to %q", path, other, kind, label)
+		}
+		seen[label] = kind
+	}
+	return m, nil
+}
+
+// Label returns the label stored for a node kind
+func (m LabelMap) Label(kind string) string {
+	if name, ok := m.Rename[kind]; ok {
+		kind = name
+	}
+	return m.Prefix + kind
+}
+
+// Kind maps a stored label back to its node kind, or "" if it is not one
+func (m LabelMap) Kind(label string) string {
+	for _, kind := range NodeLabels {
+		if m.Label(kind) == label {
+			return kind
+		}
+	}
+	return ""
+}
+
+// Rewrite replaces the node labels in a Cypher query with their mapped names
+func (m LabelMap) Rewrite(query string) string {
+	if m.Prefix == "" && len(m.Rename) == 0 {
+		return query
+	}
+	return labelPattern.ReplaceAllStringFunc(query, func(match string) string {
+		return ":" + m.Label(match[1:])
+	})
+}
+
+// Validator is implemented by backends that can read back the graph they
+// wrote and report violated invariants
+type Validator interface {
+	Validate(ctx context.Context, project string, graph *Graph) ([]string, error)
+}
+
+// Querier is implemented by backends that can run an arbitrary Cypher query,
+// returning its column names and rows
+type Querier interface {
+	Query(ctx context.Context, query string, params map[string]any) ([]string, [][]any, error)
+}
+
+// Lister is implemented by backends that can name the projects they hold
+type Lister interface {
+	Projects(ctx context.Context) ([]string, error)
+}
+
+// labelProjects lists the projects of a Cypher backend, which label every
+// node with its project as well as its kind
+func labelProjects(ctx context.Context, q Querier, labels LabelMap) ([]string, error) {
+	_, rows, err := q.Query(ctx, fmt.Sprintf(`
+		MATCH (p:%s)
+		UNWIND labels(p) AS label
+		WITH label WHERE label <> $package
+		RETURN DISTINCT label ORDER BY label
+	`, labels.Label("Package")), map[string]any{"package": labels.Label("Package")})
+	if err != nil {
+		return nil, err
+	}
+	projects := make([]string, 0, len(rows))
+	for _, row := range rows {
+		switch label := row[0].(type) {
+		case string:
+			projects = append(projects, label)
+		case []byte:
+			projects = append(projects, string(label))
+		}
+	}
+	return projects, nil
+}
+
+// graphCheck is what a backend found when reading back a written graph
+type graphCheck struct {
+	Counts          map[string]int // nodes per label
+	OrphanFunctions int            // functions and methods no File contains
+	OrphanFiles     int            // files that belong to no Package
+}
+
+// violations compares the check against the node counts the graph should
+// have produced
+func (c graphCheck) violations(expected map[string]int) []string {
+	var out []string
+	for _, label := range NodeLabels {
+		if c.Counts[label] != expected[label] {
+			out = append(out, fmt.Sprintf("%s nodes: parsed %d, stored %d", label, expected[label], c.Counts[label]))
+		}
+	}
+	if c.OrphanFunctions > 0 {
+		out = append(out, fmt.Sprintf("%d functions are not contained in a File", c.OrphanFunctions))
+	}
+	if c.OrphanFiles > 0 {
+		out = append(out, fmt.Sprintf("%d files do not belong to a Package", c.OrphanFiles))
+	}
+	return out
+}
+
+// countLabels counts nodes per label, once per key if distinct is set for
+// backends that upsert by key
+func countLabels(nodes []GraphNode, distinct bool) map[string]int {
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		if distinct {
+			if seen[node.Key] {
+				continue
+			}
+			seen[node.Key] = true
+		}
+		counts[node.Label]++
+	}
+	return counts
+}
+
+// WriteFiles rewrites just files when the backend supports it, and the whole
+// graph otherwise
+func WriteFiles(ctx context.Context, backend Writer, project string, graph *Graph, files []string, run RunInfo) error {
+	if w, ok := backend.(IncrementalWriter); ok {
+		return w.WriteFiles(ctx, project, graph, files, run)
+	}
+	return backend.Write(ctx, project, graph, run)
+}
+
+// IncrementalWriter is implemented by backends that can replace just some
+// files of a graph they wrote earlier
+type IncrementalWriter interface {
+	WriteFiles(ctx context.Context, project string, graph *Graph, files []string, run RunInfo) error
+}
@@ -0,0 +1,138 @@
+package codegraph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLabelMap(t *testing.T) {
+	labels := LabelMap{Prefix: "CG_", Rename: map[string]string{"Struct": "Class"}}
+	if got := labels.Label("Struct"); got != "CG_Class" {
+		t.Errorf(`Label("Struct") = %q, want "CG_Class"`, got)
+	}
+	if got := labels.Kind("CG_Class"); got != "Struct" {
+		t.Errorf(`Kind("CG_Class") = %q, want "Struct"`, got)
+	}
+	if got := labels.Kind("Struct"); got != "" {
+		t.Errorf(`Kind("Struct") = %q, want ""`, got)
+	}
+
+	tests := []struct {
+		labels LabelMap
+		query  string
+		want   string
+	}{
+		{LabelMap{}, "MATCH (s:App:Struct)", "MATCH (s:App:Struct)"},
+		{labels, "MATCH (s:App:Struct)-[:CONTAINS]-(f:App:File)", "MATCH (s:App:CG_Class)-[:CONTAINS]-(f:App:CG_File)"},
+		{labels, "MATCH (s:App:Structure) SET s:Function", "MATCH (s:App:Structure) SET s:CG_Function"},
+		{labels, "WHERE fn:Function OR fn:Method", "WHERE fn:CG_Function OR fn:CG_Method"},
+	}
+	for _, tt := range tests {
+		if got := tt.labels.Rewrite(tt.query); got != tt.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLoadLabelMap(t *testing.T) {
+	tests := []struct {
+		json string
+		ok   bool
+	}{
+		{`{"prefix": "CG_", "rename": {"Struct": "Class"}}`, true},
+		{`{"rename": {"Class": "Struct"}}`, false},
+		{`{"prefix": "CG-"}`, false},
+		{`{"rename": {"Struct": "Interface"}}`, false},
+		{`{"prefix": `, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "labels.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadLabelMap(path); (err == nil) != tt.ok {
+			t.Errorf("LoadLabelMap(%s) error = %v, want ok %v", tt.json, err, tt.ok)
+		}
+	}
+}
+
+func TestGraphCheckViolations(t *testing.T) {
+	expected := map[string]int{"Package": 2, "File": 2, "Function": 3}
+	tests := []struct {
+		check graphCheck
+		want  []string
+	}{
+		{graphCheck{Counts: map[string]int{"Package": 2, "File": 2, "Function": 3}}, nil},
+		{graphCheck{Counts: map[string]int{"Package": 2, "File": 1, "Function": 3}}, []string{"File nodes: parsed 2, stored 1"}},
+		{graphCheck{Counts: map[string]int{"Package": 2, "File": 2, "Function": 3, "Method": 1}, OrphanFunctions: 1, OrphanFiles: 2}, []string{
+			"Method nodes: parsed 0, stored 1",
+			"1 functions are not contained in a File",
+			"2 files do not belong to a Package",
+		}},
+	}
+	for _, tt := range tests {
+		if got := tt.check.violations(expected); !slices.Equal(got, tt.want) {
+			t.Errorf("violations(%+v) = %q, want %q", tt.check, got, tt.want)
+		}
+	}
+}
+
+func TestCountLabels(t *testing.T) {
+	nodes := []GraphNode{
+		{Key: "Function:main.go:init", Label: "Function"},
+		{Key: "Function:main.go:init", Label: "Function"},
+		{Key: "File:main.go", Label: "File"},
+	}
+	if got := countLabels(nodes, false); got["Function"] != 2 || got["File"] != 1 {
+		t.Errorf("countLabels = %v, want 2 functions and 1 file", got)
+	}
+	if got := countLabels(nodes, true); got["Function"] != 1 || got["File"] != 1 {
+		t.Errorf("distinct countLabels = %v, want 1 function and 1 file", got)
+	}
+}
+
+// recordingBackend records the writes made to it; incrementalBackend also
+// accepts per-file writes
+type recordingBackend struct {
+	writes chan []string
+}
+
+func (b *recordingBackend) Write(ctx context.Context, project string, graph *Graph, run RunInfo) error {
+	b.writes <- nil
+	return nil
+}
+
+func (b *recordingBackend) Close(ctx context.Context) error { return nil }
+
+type incrementalBackend struct {
+	recordingBackend
+	graph *Graph
+}
+
+func (b *incrementalBackend) WriteFiles(ctx context.Context, project string, graph *Graph, files []string, run RunInfo) error {
+	b.graph = graph
+	b.writes <- files
+	return nil
+}
+
+func TestWriteFiles(t *testing.T) {
+	graph := parseTestTree(t, Filter{})
+	full := &recordingBackend{writes: make(chan []string, 1)}
+	if err := WriteFiles(context.Background(), full, "App", graph, []string{"main.go"}, RunInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if files := <-full.writes; files != nil {
+		t.Errorf("plain backend got a partial write of %q", files)
+	}
+
+	incremental := &incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string, 1)}}
+	if err := WriteFiles(context.Background(), incremental, "App", graph, []string{"main.go"}, RunInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if files := <-incremental.writes; !slices.Equal(files, []string{"main.go"}) {
+		t.Errorf("WriteFiles files = %q, want main.go", files)
+	}
+}
@@ -1,530 +1,10773 @@
 // Code Graph Populator for NornicDB
 //
 // Parses Go source files using the Go AST parser and creates a code structure
-// graph in NornicDB for use by Claude Code.
+// graph in NornicDB for use by Claude Code. The parsing and writing live in
+// the pkg/codegraph package, for other Go programs to embed; this file is
+// its command line.
 //
 // Usage:
 //
-//	go run scripts/populate-code-graph.go [--project PROJECT_NAME] [--path PATH]
+//	go run scripts/populate-code-graph.go [COMMAND] [flags] [ARGS]
+//	go run scripts/populate-code-graph.go help [COMMAND]
+//
+// index runs when no command is named. help lists the commands, and help
+// COMMAND explains one, with examples, and lists its flags. Global flags
+// such as --project, --path, --backend and the connection settings are
+// taken by every command, before or after its arguments.
 //
 // Example:
 //
 //	go run scripts/populate-code-graph.go --project TradingEngine --path .
+//
+// The default backend writes to NornicDB/Neo4j over Bolt; --backend picks
+// sqlite, kuzu, age, falkordb or memory instead (see help index).
+//
+// Options can be committed to a .codegraph.yaml in the working directory (or
+// the file named by --config), keyed by flag name; nested sections join their
+// keys with a dash. Flags given on the command line override the file, and
+// relative paths in it are resolved against its directory:
+//
+//	project: TradingEngine
+//	path: .
+//	backend: neo4j
+//	neo4j:
+//	  uri: bolt://graph:7687
+//	  password-env: GRAPH_PASSWORD
+//	blame: true
+//
+// Credentials are never read from the file: --neo4j-user-env,
+// --neo4j-password-env and --falkor-password-env name the environment
+// variables that hold them (NEO4J_USER, NEO4J_PASSWORD and FALKORDB_PASSWORD
+// by default). Neo4j connects without authentication when neither is set.
+//
+// Progress, warnings and errors are logged to stderr through log/slog, as
+// key=value text or, with --log-format json, as JSON lines for CI and log
+// collectors; --log-level (debug, info, warn, error) sets the threshold.
+// Stdout carries only what was asked for: exports, query results, diffs and
+// dry-run output.
+//
+// --output json adds a last line to stdout when any command ends: a JSON
+// object with the command, ok or failed, the exit code, the files, symbols,
+// nodes and relationships of each project it parsed, wrote or read, and the
+// errors and warnings it logged. The exit code says why a run failed:
+//
+//	1  anything not below
+//	2  unknown command, bad flags, arguments or config
+//	3  the source could not be parsed, or a file of it failed to parse and
+//	   was left out, unless --allow-parse-errors
+//	4  the backend could not be reached or opened
+//	5  the written graph failed --validate
+//	6  breaking found changes to the exported API
+//	7  check found imports breaking a --rule
+//	8  untested found changed functions no test reaches
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"go/ast"
-	"go/parser"
-	"go/token"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	rtrace "runtime/trace"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/amarodeabreu/claude-graph-memory/pkg/codegraph"
+	"github.com/fsnotify/fsnotify"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gopkg.in/yaml.v3"
 )
 
 // Config holds the populator configuration
 type Config struct {
-	Project  string
-	Path     string
-	Neo4jURI string
-	DryRun   bool
+	ConfigFile string
+	Project    string
+	Path       string
+	Paths      []string
+	ProjectMap []string
+	Neo4jURI   string
+
+	Neo4jUserEnv     string
+	Neo4jPasswordEnv string
+	Backend          string
+	DBPath           string
+	AgeDSN           string
+	AgeGraph         string
+	LabelMap         string
+	Labels           codegraph.LabelMap
+	Redact           []string
+	RedactDefaults   bool
+	RedactRules      []codegraph.RedactRule
+
+	FalkorAddr        string
+	FalkorGraph       string
+	FalkorPasswordEnv string
+
+	MaxPoolSize          int
+	AcquisitionTimeout   time.Duration
+	MaxConnLifetime      time.Duration
+	LivenessCheckTimeout time.Duration
+	ConnectTimeout       time.Duration
+	KeepAlive            bool
+	Timeout              time.Duration
+	StatementTimeout     time.Duration
+	BatchSize            int
+	FlushInterval        time.Duration
+	AdaptiveBatch        bool
+	DryRun               bool
+	RecordRun            bool
+	SoftDelete           bool
+	Blame                bool
+	Filter               codegraph.Filter
+	Features             codegraph.Features
+	SyntaxOnly           bool
+	AllowParseErrors     bool
+	CacheDir             string
+	LogLevel             string
+	LogFormat            string
+	Progress             string
+	Output               string
+	Trace                bool
+	CPUProfile           string
+	MemProfile           string
+	ExecTrace            string
+	Churn                string
+	CoverProfile         string
+	Govulncheck          string
+	Plugins              []string
+	Embed                string
+	EmbedBatch           int
+	Since                string
+	Rev                  string
+	Watch                bool
+	Validate             bool
+	ShowStatements       bool
+	Base                 string
+	Diff                 string
+	Rules                []string
+	ExportFormat         string
+	Out                  string
+
+	Query  string
+	Symbol string
+	Depth  int
+
+	StatsTop   int
+	StaleAfter time.Duration
+
+	// Workspace is every project of the run, for analyses looking across
+	// them
+	Workspace []target
+
+	Yes       bool
+	PruneDays int
+
+	About       []string
+	Tags        []string
+	ExcludeTags []string
+	Namespace   string
+	Limit       int
+	TTL         time.Duration
+	All         bool
+	Session     string
+	WorkDir     string
+	Branch      string
+	Touch       []string
+
+	Importance float64
+	Confidence float64
+	Pin        bool
+	Unpin      bool
+	Rank       bool
+	PR         int
+
+	Agent      string
+	Model      string
+	Provenance []string
+	Trust      []string
+
+	Title        string
+	Rationale    string
+	Alternatives []string
+	Status       string
+	Affects      []string
+
+	Category string
+	Example  string
+	Packages []string
+
+	LinkFrom  string
+	LinkTo    string
+	ToProject string
+	LinkType  string
+	Module    string
+	Linked    bool
+
+	Package      string
+	Callers      int
+	SnippetLines int
+
+	HalfLife       time.Duration
+	Threshold      float64
+	DeleteMemories bool
+	Similarity     float64
+
+	Distance float64
+	Methods  int
+	God      codegraph.GodLimits
+	MaxDepth int
+
+	BenchPackages  int
+	BenchFiles     int
+	BenchFunctions int
+	BenchRuns      int
+	BenchDir       string
+
+	QueryFile string
+	Params    []string
+	Format    string
+	Name      string
+
+	Listen    string
+	Transport string
+
+	Schedule string
+	Poll     time.Duration
+
+	DotView    string
+	DotPackage string
+
+	MermaidView    string
+	MermaidPackage string
+	MermaidType    string
 }
 
-// FileNode represents a source file in the graph
-type FileNode struct {
-	Path     string
-	Package  string
-	Language string
-	Imports  []string
+// command is a subcommand of the CLI. Every command takes the global flags
+// as well as the ones flags registers.
+type command struct {
+	name    string
+	args    string // positional arguments, for usage
+	maxArgs int
+	summary string
+	// doc explains the command in full, with examples, for help COMMAND
+	doc   string
+	flags func(fs *flag.FlagSet, cfg *Config)
+	// failure is logged with the error run returns
+	failure string
+	// noTargets commands are about the database, not the projects in it
+	noTargets bool
+	// serves is set for commands that run until interrupted
+	serves bool
+	run    func(ctx context.Context, cfg Config, targets []target, args []string) error
 }
 
-// FunctionNode represents a function/method in the graph
-type FunctionNode struct {
-	Name      string
-	File      string
-	Signature string
-	Receiver  string // empty for functions, type name for methods
-	IsExport  bool
-	LineStart int
-	LineEnd   int
+// commands lists the subcommands in the order usage shows them; the first
+// runs when none is named
+var commands = []*command{
+	{
+		name:    "index",
+		summary: "Parse the projects and write them to the backend",
+		doc: `The default backend writes to NornicDB/Neo4j over Bolt. --backend sqlite
+writes the same nodes and relationships into nodes/edges tables of the
+SQLite file given by --db-path, for machines without Docker. --backend kuzu
+writes to an embedded Kùzu database directory at --db-path, which can live
+alongside the repository and be queried with Cypher; it needs cgo and a
+binary built with -tags kuzu. --backend age stores
+the graph in PostgreSQL through the Apache AGE extension (--age-dsn,
+--age-graph). --backend falkordb writes to FalkorDB over the Redis
+protocol (--falkor-addr, --falkor-graph). --backend memory
+keeps the graph in-process and answers --query against it: callers and
+callees of a function, implementers of an interface (or the interfaces a
+struct implements), and the impact of changing a symbol or file.
+
+Packages are loaded and type-checked with go/packages, so a call through
+a field, an import under another name or a promoted method links to the
+function it really calls; files no package loads, and every file of a
+tree without a go.mod, are parsed on their own and their calls resolved
+by name. --syntax-only does that for every file, which is faster and
+needs no dependencies downloaded. Trees at a --rev and files re-parsed
+by --watch are never type-checked.
+
+--cache-dir keeps what is parsed of each file in a directory, so a later
+run parses only what changed since. A file parsed on its own, with
+--syntax-only, at a --rev or of a --base revision, is keyed by a hash of
+its path and content. What the calls of a type-checked file resolve to
+depends on the rest of its package and on those it imports, so packages
+are cached whole, keyed by their files, their imports' keys and their
+module's go.mod and go.sum, and only those with a changed key are
+type-checked again. The directory may be removed at any time.
+
+Each go.mod below --path, or the nearest above it, is written as a
+Module node, each module is type-checked on its own, and packages
+BELONGS_TO the module holding them, with its path and their importPath
+as properties. Imports resolve to a package by that import path, so a
+monorepo's modules may each have their own package of the same name.
+
+Hidden directories, vendor and node_modules are always skipped. --exclude
+skips further files or directories and --include, when given, limits the
+graph to matching files. Both take globs relative to --path and may be
+repeated. ** matches any number of directories, and a pattern without a
+slash matches a name at any depth:
+
+	--include 'internal/**' --exclude testdata --exclude '*.pb.go'
+
+A leading slash anchors a pattern to --path.
+
+Source files over --max-file-size, 2M unless given, are skipped with a
+warning naming each, so a generated or vendored file of megabytes does
+not fill memory or the graph; 0 lifts the limit. --max-files caps how
+many files are indexed, warning of how many more were skipped.
+
+Symlinks are skipped, so a file linked to from elsewhere in the tree is
+indexed once, by its own path. --follow-symlinks indexes what the links
+lead to outside --path by the links' paths, each file and directory
+once however many links reach it, and never loops through a link to a
+directory above.
+
+--features switches individual extractors on, or off with a leading minus,
+for repositories where full extraction is more than is wanted: calls,
+imports and implements (the CALLS, IMPORTS and IMPLEMENTS relationships)
+run by default, tests (_test.go files, and TESTS relationships from each
+test to the functions it reaches, with the depth of the calls) does not.
+Nor does duplicates,
+which links functions whose bodies are copies of each other, up to
+renamed variables, by DUPLICATES relationships with their similarity
+from 0.8 to 1, written like churn by the Neo4j and FalkorDB backends and
+the cypher export; the duplicates query lists them. For example --features tests,-calls, or in the config
+file a list such as [tests, -calls]. Git ownership and churn are
+switched by --blame and --churn.
+
+--path may be repeated to write several trees in one run, and NAME=DIR
+writes DIR as project NAME instead of --project. In a monorepo,
+--project-map 'services/*' splits each matching directory into its own
+project named after the directory, and --project-map 'services/*={name}Svc'
+names them from a template; the root project then leaves those
+directories out. diff, export, --query and --watch need a single project.
+
+Files are parsed in parallel on GOMAXPROCS workers and merged in directory
+order, so the graph is the same whatever the scheduling. Packages and
+files are then sorted by path and symbols by file and position, in the
+graph of a --watch update and of a JSON export read back too, so exports,
+dry runs and writes list them in the same order on every run and diff
+cleanly.
+
+--rev REV indexes the tree as it was at a commit, tag or branch, reading
+files from the git object store instead of the working tree.
+
+The Neo4j backend writes in UNWIND batches of --batch-size rows, committing
+each batch unless --flush-interval groups them into longer transactions.
+--adaptive-batch halves the batch size whenever the server reports memory
+pressure, replaying the failed transaction. The Neo4j and FalkorDB
+backends and the cypher export build each statement's rows only as the
+statement is written, and with --syntax-only files are merged into the
+graph as they are parsed. Memory still grows with the repository: the
+graph is held whole, type-checking holds every package of a module at
+once, and a statement carries every node of its label.
+
+--soft-delete merges nodes on their identity instead of clearing the
+project first, and marks symbols and relationships that no longer exist
+with deleted: true and deletedAt, so memories attached to old code stay
+resolvable. Deleted nodes are ignored by diff and validation.
+
+--watch keeps the process running after the first write and updates the
+graph as files change. The Neo4j and FalkorDB backends rewrite only the
+changed files and the relationships touching them; other backends rewrite
+the whole graph.
+
+After writing, the Neo4j and SQLite backends read the graph back and check
+that node counts match what was parsed, every function is contained in a
+File and every File belongs to a Package; a violation exits non-zero.
+--validate=false skips the check.
+
+--label-map names a JSON file that prefixes or renames node labels, e.g.
+{"prefix": "CG_", "rename": {"Struct": "Class"}}, so the code graph can share
+a NornicDB instance with other schemas. It applies to the Neo4j and
+FalkorDB backends, diff and the cypher and csv exports.
+
+--since REF writes only the Go files that differ from REF in the working
+tree, for quick updates in CI. The whole tree is still parsed so calls
+across files resolve. Backends without incremental writes rewrite everything.
+
+With --embed, index and watch embed each function's doc comment and
+signature and each struct's doc comment and fields, write the vectors to
+an embedding property and, on neo4j, keep a cosine vector index over
+them named PROJECT_embedding. The provider is openai[:MODEL] (default
+text-embedding-3-small, with $OPENAI_API_KEY and $OPENAI_BASE_URL),
+ollama[:MODEL] (default nomic-embed-text, at $OLLAMA_HOST) or
+exec:COMMAND, a process run in --path answering each {"texts": [...]}
+line with an {"embeddings": [...]} line. --embed-batch sets the texts per
+request.
+
+--blame runs git blame over every file and sets lastAuthor, lastModified
+and topContributors on File, Function and Method nodes, and links them from
+Author nodes (keyed by email) with AUTHORED relationships carrying the
+number of lines written, e.g.
+
+	MATCH (a:MyProject:Author)-[r:AUTHORED]->(fn:MyProject:Function {name: "Parse"})
+	RETURN a.name, r.lines ORDER BY r.lines DESC
+
+Package nodes get the ownership of their files' lines summed: owner, the
+author of the most, and ownerShare, their share; knowledgeableAuthors,
+the authors of at least a tenth of them; and busFactor, the fewest
+authors who wrote more than half. The bus-factor query lists the
+packages only one or two people know first.
+
+Ownership is written by the Neo4j and FalkorDB backends and included in the
+cypher and json exports. Files git does not track are left unannotated.
+
+--churn SINCE sets churn (commits) and churnLines (lines added plus removed)
+on File, Function and Method nodes for the history since a git date such
+as "90 days ago" or 2025-01-01. Older line numbers are carried forward
+through later diffs, so a function is credited with the edits made to it
+before it moved. Like --blame it applies to the Neo4j and FalkorDB backends
+and the cypher and json exports.
+
+--coverprofile FILE reads a go test -coverprofile file and sets coverage
+(the percentage of statements run), statements, coveredStatements and
+uncoveredLines (ranges such as "12-15") on the Function and Method nodes
+of the files it profiles, and removes them from the others. Its files are
+named by import path and matched to the project's by suffix, so a
+profile of the whole module annotates each project of a monorepo. The
+untested-exports query lists the exported functions it never ran:
+
+	go test -coverprofile=cover.out ./...
+	go run scripts/populate-code-graph.go --coverprofile cover.out
+	go run scripts/populate-code-graph.go query untested-exports
+
+--govulncheck FILE reads the output of govulncheck -json, and
+--govulncheck run runs govulncheck in --path (it must be on PATH). Each
+vulnerability found becomes a Vulnerability node, with its OSV id,
+aliases such as the CVE, summary, fixedVersion and level: symbol if the
+code calls a vulnerable function, package if it only imports one, module
+if it only requires the module. It is linked by AFFECTS to a Dependency
+node for the module and version, and every function on a call path to
+the vulnerable function is linked to it by REACHES, whose path lists
+the calls from there on. Each write replaces the last vulnerabilities.
+The vulnerabilities query answers whether a CVE can be reached:
+
+	go run scripts/populate-code-graph.go --govulncheck run
+	go run scripts/populate-code-graph.go query vulnerabilities --name CVE-2023-3978
+
+Every write also sets the coupling counted from the CALLS and IMPORTS
+relationships: fanIn and fanOut, the functions calling and called, on
+Function and Method nodes, and afferent and efferent, the packages
+importing and imported, on Package nodes, with their instability,
+efferent / (afferent + efferent), abstractness and distance (see analyze
+stability). Like churn it is written by the Neo4j and FalkorDB backends
+and the cypher export, and an incremental write updates every node, as a
+change in one file moves the counts of others.
+The depended-upon and fragile-packages queries rank by them. Function
+and Method nodes also get complexity, the cyclomatic complexity of the
+body, which the hotspots query and analyze hotspots weigh with churn.
+Those that are entry points, as for analyze depth, get entryPoint, the
+kind of entry point, callDepth and deepestPath, the keys of the
+functions along their longest call chain, which the call-depth query
+lists. IMPORTS relationships, which only reach the project's packages,
+have kind internal, and Package nodes count the distinct packages their
+files import by kind: stdlibImports, for those whose path starts without
+a dot, internalImports and thirdPartyImports, with thirdPartyPackages
+listing them and thirdPartyRatio their share of all. The third-party
+query ranks packages by them for dependency reviews.
+
+--plugin CMD attaches custom properties, such as the owning team from
+CODEOWNERS, without changing this program. CMD runs once per pass with
+sh -c in --path and is sent a line of JSON per file holding the file and
+its nodes with their properties; it answers each with a line of the
+properties to add, by node key (see codegraph.ProcessEnricher):
+
+	{"properties": {"File:api/handler.go": {"team": "payments"}}}
+
+Properties must be strings, numbers, booleans or lists of them, and cannot
+replace the parsed ones. Several plugins run in order, each seeing what
+the earlier ones added. Every backend but Kùzu writes them.
+
+Parsing and writing report progress (done, total, elapsed and ETA): as a
+bar with a summary table at the end when stderr is a terminal, otherwise
+as a log record every 10 seconds. --progress bar, log or off overrides the
+choice.
+
+Each run ends with a metrics record: parse and write time, nodes and
+relationships per second, statements, retries and batch sizes. With
+--record-run the Neo4j backend also stores them as a Run node.
+
+--trace exports OpenTelemetry spans over OTLP/HTTP, configured by the
+standard OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS and
+OTEL_SERVICE_NAME variables: a span for the command with its parse and
+write phases, and below them one per file parsed, batch written and query
+run, to see where a slow run on a big repository spends its time. serve,
+daemon, grpc, mcp and --watch trace each re-index and query separately.
+
+	OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318 go run scripts/populate-code-graph.go --trace
+
+To report a slow run without a collector, --cpuprofile FILE writes a CPU
+profile of the run and --memprofile FILE a heap profile as it ends, both
+for go tool pprof, and --exectrace FILE a Go execution trace for go tool
+trace. The parse and write phases are labelled phase=parse and
+phase=write in the CPU profile (go tool pprof -tagfocus phase=write) and
+are regions of the execution trace.
+
+	go run scripts/populate-code-graph.go --cpuprofile cpu.out --memprofile mem.out
+	go tool pprof -top cpu.out`,
+		failure: "indexing",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			parseFlags(fs, cfg)
+			writeFlags(fs, cfg)
+			fs.BoolVar(&cfg.DryRun, "dry-run", false, "Parse code without writing to DB")
+			fs.BoolVar(&cfg.ShowStatements, "show-statements", false, "dry-run: print every Cypher statement and its parameters instead of a sample")
+			fs.StringVar(&cfg.Since, "since", "", "Only write files changed since this git ref")
+			fs.BoolVar(&cfg.Watch, "watch", false, "Keep running and update the graph as files change")
+			fs.BoolVar(&cfg.RecordRun, "record-run", false, "neo4j: store the run's metrics as a Run node")
+			fs.BoolVar(&cfg.Validate, "validate", true, "neo4j, sqlite: check the written graph and exit non-zero if it is inconsistent")
+			fs.StringVar(&cfg.Query, "query", "", "memory: query to run (callers, callees, implementers, impact)")
+			fs.StringVar(&cfg.Symbol, "symbol", "", "memory: symbol, file or node key the query is about")
+			depthFlag(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if len(targets) > 1 && (cfg.Query != "" || cfg.Watch) {
+				return fmt.Errorf("--query and --watch need a single project, not %d", len(targets))
+			}
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return populate(ctx, cfg)
+			})
+		},
+	},
+	{
+		name:    "export",
+		summary: "Parse a project and write it to a file instead of the backend",
+		doc: `The export command writes the graph to --out instead of the database, in
+the --format given:
+
+	cypher  a Cypher script of every statement, with parameters inlined
+	csv     node and relationship files for neo4j-admin import (--out is a directory)
+	parquet nodes.parquet and edges.parquet for DuckDB, Spark and other
+	        analytics tools (--out is a directory)
+	graphml GraphML for Gephi, yEd and other graph tools
+	dot     Graphviz DOT; --dot-view selects the package import graph (imports)
+	        or files and their symbols (structure), --dot-package narrows it
+	json    the parsed graph and its relationships
+	mermaid a Mermaid classDiagram of the types in --mermaid-package or named by
+	        --mermaid-type (methods, implemented interfaces, field dependencies),
+	        or with --mermaid-view flowchart the package dependencies
+
+A JSON export can be passed to diff --base to compare against a snapshot.`,
+		failure: "exporting",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			parseFlags(fs, cfg)
+			fs.StringVar(&cfg.ExportFormat, "format", "json", "Export format: cypher, csv, parquet, graphml, dot, json, mermaid")
+			fs.StringVar(&cfg.Out, "out", "-", "Destination file, or directory for csv and parquet (- for stdout)")
+			fs.IntVar(&cfg.BatchSize, "batch-size", 500, "cypher: rows per UNWIND statement")
+			fs.BoolVar(&cfg.SoftDelete, "soft-delete", false, "cypher: mark removed symbols deleted instead of deleting them")
+			fs.StringVar(&cfg.DotView, "dot-view", "imports", "dot: subgraph to render (imports, structure)")
+			fs.StringVar(&cfg.DotPackage, "dot-package", "", "dot: only include packages under this path")
+			fs.StringVar(&cfg.MermaidView, "mermaid-view", "class", "mermaid: diagram kind (class, flowchart)")
+			fs.StringVar(&cfg.MermaidPackage, "mermaid-package", "", "mermaid: package path to diagram")
+			fs.StringVar(&cfg.MermaidType, "mermaid-type", "", "mermaid: type name to diagram")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if len(targets) > 1 {
+				return fmt.Errorf("export needs a single project, not %d", len(targets))
+			}
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return populate(ctx, cfg)
+			})
+		},
+	},
+	{
+		name:    "diff",
+		summary: "Compare a project's source with what is stored, or with another tree or export",
+		doc: `The diff command parses the code and compares it against what is currently
+stored in the database (or against a second source tree given by --base),
+printing added/removed/changed symbols and relationships as JSON without
+writing anything. --base may also name a JSON export (see help export).`,
+		failure: "computing diff",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Base, "base", "", "Compare against this source tree or JSON export instead of the database")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if len(targets) > 1 {
+				return fmt.Errorf("diff needs a single project, not %d", len(targets))
+			}
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runDiff(ctx, cfg)
+			})
+		},
+	},
+	{
+		name:    "query",
+		args:    "[CYPHER | NAMED-QUERY]",
+		maxArgs: 1,
+		summary: "Run a Cypher query, or a named one, and print the rows",
+		doc: `The query command runs a Cypher query against the neo4j, falkordb or kuzu
+backend and prints the rows as an aligned table, a JSON array of objects or
+CSV. The query is the argument, the contents of --file, or stdin; --param
+passes parameters, parsed as JSON where possible:
+
+	go run scripts/populate-code-graph.go query --param name=Parse \
+	  'MATCH (c)-[:CALLS]->(f:Function {name: $name}) RETURN c.name, c.file'
+
+Common questions have named queries that need no Cypher, run against
+--project on the neo4j and falkordb backends:
+
+	callers               functions and methods calling --name
+	implementations       structs implementing interface --name, or the
+	                      interfaces struct --name implements
+	unused-exports        exported functions nothing in the project calls,
+	                      optionally under package path --name
+	untested-exports      exported functions the --coverprofile tests never
+	                      run, the most called first
+	package-dependencies  packages each package imports, optionally only
+	                      for package path --name
+	impact                symbols reaching the symbols in file --name through
+	                      calls or implementations, up to --depth hops
+	depended-upon         the functions the most others call, optionally
+	                      under package path --name
+	fragile-packages      the packages importing others that the fewest
+	                      import, by instability
+	hotspots              the functions scoring highest by churn, complexity
+	                      and fan-in, optionally under package path --name
+	duplicates            pairs of functions copied from each other, the
+	                      most similar first, for --features duplicates,
+	                      optionally under package path --name
+	vulnerabilities       the vulnerabilities --govulncheck found, or the
+	                      one with OSV id or alias --name, the most
+	                      exposed first, with the functions reaching them
+	call-depth            the entry points whose calls go deepest, with
+	                      the longest chain, optionally under package
+	                      path --name
+	third-party           the packages importing the most third-party
+	                      packages, with their share of all imports,
+	                      optionally under package path --name
+	bus-factor            the packages known to the fewest authors, for
+	                      graphs written with --blame, optionally under
+	                      package path --name
+	modules               the Go modules of a monorepo, with their
+	                      directory, Go version and packages, or the one
+	                      with module path --name
+
+e.g. go run scripts/populate-code-graph.go query callers --name Parse.`,
+		failure:   "running query",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.QueryFile, "file", "", "Read the Cypher query from this file")
+			fs.Var((*stringList)(&cfg.Params), "param", "Query parameter NAME=VALUE, VALUE parsed as JSON if it can be (repeatable)")
+			fs.StringVar(&cfg.Format, "format", "table", "Output format: table, json, csv")
+			fs.StringVar(&cfg.Name, "name", "", "Symbol, file or package a named query is about. Named queries:"+namedQueryUsage())
+			depthFlag(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return runQuery(ctx, cfg, strings.Join(args, ""))
+		},
+	},
+	{
+		name:    "stats",
+		summary: "Report node and relationship counts, the last index and staleness",
+		doc: `The stats command reads a project back from Neo4j without parsing: node
+and relationship counts, when and at which commit it was last written,
+its --top largest packages by symbol count, and warnings when the tree at
+--path has moved on (new commits, files modified since, or a last write
+older than --stale-after).`,
+		failure: "reading stats",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.IntVar(&cfg.StatsTop, "top", 10, "Number of largest packages to list")
+			fs.DurationVar(&cfg.StaleAfter, "stale-after", 7*24*time.Hour, "Warn when the project was last indexed longer ago than this, 0 to never warn")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runStats(ctx, cfg)
+			})
+		},
+	},
+	{
+		name:    "analyze",
+		args:    "deadcode|cycles|stability|hotspots|unused-api|interfaces|god|depth|errors",
+		maxArgs: 1,
+		summary: "Analyze the code for dead functions, package cycles, hard to change packages, refactoring hotspots, exports no project uses, interfaces to split, structs and functions doing too much, deep call chains or discarded errors, writing what is found to the graph",
+		doc: `The analyze command parses --path, runs an analysis of the code and
+prints what it finds, writing it to the neo4j or falkordb backend in
+place of what the analysis found last; --dry-run only prints. analyze
+deadcode finds the unexported functions nothing calls or refers to, and
+the exported ones unused inside the module, which other modules may
+still use. Unlike the unused-exports query it counts functions passed
+as values, such as handlers, and leaves out methods, which may satisfy
+interfaces. It writes them as Finding nodes linked by FLAGS
+relationships to the functions, queried like the rest of the graph:
+
+	go run scripts/populate-code-graph.go analyze deadcode
+	go run scripts/populate-code-graph.go query 'MATCH (f:Finding {kind: "unused-function"})-[:FLAGS]->(fn) RETURN fn.name, fn.file'
+
+analyze cycles finds the packages that import each other in a loop, and
+the near cycles type usage closes: a package calling back into one that
+imports it through a method, or implementing an interface of a package
+that imports it. It writes them as Cycle nodes linked by INCLUDES
+relationships to their packages, in place of the last ones, and prints
+a loop through each, so packages growing into one another show before
+the compiler refuses them:
+
+	go run scripts/populate-code-graph.go analyze cycles --dry-run
+
+analyze stability prints each package's afferent and efferent
+coupling, instability, abstractness (the share of its types that are
+interfaces) and distance from the main sequence, |abstractness +
+instability - 1|, which every write also sets on Package nodes. Packages
+others import that are concrete and stable, at least --distance (0.7)
+from the main sequence, are in the zone of pain, hard to change without
+breaking their importers; they are marked and written as Finding nodes
+flagging the Package:
+
+	go run scripts/populate-code-graph.go analyze stability --distance 0.5
+
+analyze hotspots ranks functions and files by churn × complexity × (1 +
+fan-in): the commits touching them since --churn (90 days ago), their
+cyclomatic complexity, summed over a file's functions, and the functions
+calling them, from other files for a file. The --top (10) scoring
+highest are the first candidates for refactoring, changing often, hard
+to follow and relied upon; they are printed and written as Finding
+nodes flagging them:
+
+	go run scripts/populate-code-graph.go analyze hotspots --churn "1 year ago" --top 20
+
+analyze unused-api looks across every project of the run, given by more
+than one --path or by --project-map, as indexed together into one
+database. For each project other projects import, by the module path of
+the go.mod in or above its directory, it finds the exported functions
+neither it nor any of them calls or refers to, which can be unexported
+or removed without breaking a consumer. Methods are left out, as for
+analyze deadcode, and consumers outside the run are not known of:
+
+	go run scripts/populate-code-graph.go analyze unused-api --path Lib=../lib --path Api=../api --path Worker=../worker
+
+analyze interfaces looks for interfaces to segregate. One of at least
+--methods (5) methods, most of whose implementations stub some of them
+with an empty body, zero values, a new error or a panic, is bloated: its
+implementors only need part of it, and the methods stubbed most are
+listed as those to split off. One with a single implementation in the
+project may not need to be an interface. Implementations are matched by
+method names, as for the implements feature. Both are printed and
+written as Finding nodes flagging the Interface:
+
+	go run scripts/populate-code-graph.go analyze interfaces --methods 4
+
+analyze god flags the structs and functions doing too much: structs of
+more than --max-fields (15) fields, or whose fields use more than
+--max-dependencies (10) types, and functions longer than --max-lines
+(80), more complex than --max-complexity (15) or taking more than
+--max-params (5) parameters. A limit of 0 is not checked. Each is
+printed and written as a Finding node with a severity, a warning past a
+limit and an error past twice the limit:
+
+	go run scripts/populate-code-graph.go analyze god --max-lines 60 --max-params 0
+
+analyze depth follows the calls from each entry point of the program:
+main, the HTTP handlers, taking an http.ResponseWriter and an
+*http.Request or named ServeHTTP, and the methods of gRPC servers, which
+embed a generated Unimplemented...Server. It prints how many calls deep
+the longest chain from each goes, deepest first, with the chain, and
+writes a Finding node for those at least --max-depth (10) deep, where
+layers of indirection pile up latency and stack. Calls back into a
+chain, as in recursion, are not followed:
+
+	go run scripts/populate-code-graph.go analyze depth --max-depth 8
+
+analyze errors audits error handling: it flags each call throwing away
+an error the function called returns, made as a statement of its own,
+as in store.Open(name), or assigning the error to _, as in s, _ :=
+store.Open(name) or _ = s.Close(). Deferred calls are left alone. Only
+the project's own functions are known to return errors, resolved like
+CALLS, so calls into the standard library and other modules are not
+checked. Each is printed and written as a Finding node flagging the
+function, with the line of the call:
+
+	go run scripts/populate-code-graph.go analyze errors`,
+		failure: "analyzing",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.BoolVar(&cfg.DryRun, "dry-run", false, "Print what the analysis finds without writing it to the graph")
+			fs.Float64Var(&cfg.Distance, "distance", 0.7, "Flag packages in the zone of pain at least this far from the main sequence, from 0 to 1 (stability)")
+			fs.StringVar(&cfg.Churn, "churn", "90 days ago", "Count the commits touching each file and function since this git date (hotspots)")
+			fs.IntVar(&cfg.Limit, "top", 10, "Report and write this many of the highest scoring functions and files, 0 for all (hotspots)")
+			fs.IntVar(&cfg.Methods, "methods", 5, "Flag interfaces of at least this many methods most implementations stub some of (interfaces)")
+			fs.IntVar(&cfg.God.Fields, "max-fields", 15, "Flag structs with more fields than this, 0 for no limit (god)")
+			fs.IntVar(&cfg.God.Dependencies, "max-dependencies", 10, "Flag structs whose fields use more types than this, 0 for no limit (god)")
+			fs.IntVar(&cfg.God.Lines, "max-lines", 80, "Flag functions longer than this many lines, 0 for no limit (god)")
+			fs.IntVar(&cfg.God.Complexity, "max-complexity", 15, "Flag functions of a higher cyclomatic complexity than this, 0 for no limit (god)")
+			fs.IntVar(&cfg.God.Params, "max-params", 5, "Flag functions taking more parameters than this, 0 for no limit (god)")
+			fs.IntVar(&cfg.MaxDepth, "max-depth", 10, "Flag entry points whose calls go at least this many deep (depth)")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			cfg.Workspace = targets
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				if cfg.DryRun {
+					return runAnalyze(ctx, cfg, nil, args, os.Stdout)
+				}
+				backend, err := openBackend(ctx, cfg)
+				if err != nil {
+					return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+				}
+				defer closeBackend(backend)
+				return runAnalyze(ctx, cfg, backend, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "impact",
+		args:    "FUNCTION|STRUCT|FILE",
+		maxArgs: 1,
+		summary: "List the symbols, files and tests a change to a function, struct or file can break",
+		doc: `The impact command answers what a change can break before it is made.
+It parses --path, tests included, and walks back from a function, a
+Type.Method, a struct, an interface or a file relative to the project
+root, up to --depth (3) hops, through the functions calling or referring
+to it, the functions, structs and interfaces whose signatures or fields
+use its types, and the structs implementing its interfaces. It prints
+each affected symbol with its depth and the relationship reaching it,
+the tests go test runs that reach it, and the files to look at. Unlike
+the impact query it needs no database, and follows type usage:
+
+	go run scripts/populate-code-graph.go impact --depth 2 Store.Put
+	go run scripts/populate-code-graph.go impact pkg/store/store.go`,
+		failure: "analyzing impact",
+		flags:   depthFlag,
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runImpact(ctx, cfg, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "breaking",
+		summary: "Report the changes to the exported API since a tree, export or git revision that can break importers",
+		doc: `The breaking command compares the exported API of --path, or of its tree
+at --rev, with --base: another source tree, a JSON export, or a git
+revision of --path. It reports exported functions, methods and types
+removed, signatures and struct fields changed, and methods removed from
+or added to interfaces, leaving out main, internal and test packages,
+and exits with 6 if there are any, to gate the merges of a library:
+
+	go run scripts/populate-code-graph.go breaking --base origin/main
+	go run scripts/populate-code-graph.go breaking --base v1.4.0 --rev HEAD --format json`,
+		failure: "comparing APIs",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Base, "base", "", "Source tree, JSON export or git revision of --path to compare against")
+			fs.StringVar(&cfg.Rev, "rev", "", "Compare the tree at this git revision instead of the working tree")
+			fs.StringVar(&cfg.Format, "format", "text", "Output format: text, json")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runBreaking(ctx, cfg, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "check",
+		summary: "Check the imports of a project against the layering rules of --rule",
+		doc: `The check command holds the imports of --path to layering rules, each
+given by --rule or, better, listed in the config file so CI and
+developers check the same ones. A rule is "PKG must not import PKG" or
+"only PKG may import PKG", where a package is a path relative to the
+project root or an import path, and takes in those below it. check
+prints every import breaking a rule and exits with 7 if there are any:
+
+	rule:
+	  - pkg/domain must not import pkg/http
+	  - only pkg/store may import database/sql`,
+		failure: "checking rules",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.Var((*stringList)(&cfg.Rules), "rule", `Layering rule "PKG must not import PKG" or "only PKG may import PKG" (repeatable)`)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runCheck(ctx, cfg, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "untested",
+		summary: "List the functions a diff changed that no test reaches",
+		doc: `The untested command lists the functions a diff adds or changes lines
+in that no test reaches within three calls, and exits with 8 if there
+are any, so CI can say "you changed X but nothing tests it". The diff is
+git diff -U0 of the working tree against --base (HEAD), or read from
+--diff, - for stdin:
+
+	go run scripts/populate-code-graph.go untested --base origin/main
+	git diff -U0 origin/main | go run scripts/populate-code-graph.go untested --diff -`,
+		failure: "finding untested changes",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Base, "base", "HEAD", "Git revision of --path to diff the working tree against")
+			fs.StringVar(&cfg.Diff, "diff", "", "Read a zero-context diff from this file, - for stdin, instead of running git")
+			fs.StringVar(&cfg.Format, "format", "text", "Output format: text, json")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runUntested(ctx, cfg, os.Stdin, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "snapshots",
+		summary: "List the runs whose writes a project still holds",
+		doc: `Stored data is otherwise only ever replaced by the next write. snapshots
+lists the runs a project's nodes were last written by, newest first, with
+their commit and how many of their nodes are live or marked deleted. It
+works on the neo4j, falkordb and sqlite backends.`,
+		failure: "listing snapshots",
+		flags:   func(fs *flag.FlagSet, cfg *Config) {},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return withManager(ctx, cfg, func(m codegraph.Manager) error {
+					return runSnapshots(ctx, cfg, m)
+				})
+			})
+		},
+	},
+	{
+		name:    "prune",
+		summary: "Remove symbols deleted and runs recorded more than --days ago",
+		doc: `prune removes the nodes and relationships --soft-delete marked deleted
+more than --days (30) days ago and the Run nodes --record-run stored
+before then. It works on the neo4j, falkordb and sqlite backends.`,
+		failure: "pruning",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.IntVar(&cfg.PruneDays, "days", 30, "Keep what was deleted or recorded in the last this many days")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return withManager(ctx, cfg, func(m codegraph.Manager) error {
+					return runPrune(ctx, cfg, m)
+				})
+			})
+		},
+	},
+	{
+		name:    "wipe",
+		summary: "Delete a project's graph",
+		doc: `wipe deletes a project outright; without --yes it only reports how much
+it would delete. It works on the neo4j, falkordb and sqlite backends.`,
+		failure: "wiping project",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.BoolVar(&cfg.Yes, "yes", false, "Delete the project; without it wipe only reports what it would delete")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return withManager(ctx, cfg, func(m codegraph.Manager) error {
+					return runWipe(ctx, cfg, m)
+				})
+			})
+		},
+	},
+	{
+		name:    "remember",
+		args:    "TEXT",
+		maxArgs: 1,
+		summary: "Store a memory about functions, files or packages",
+		doc: `Memories keep observations about the code between sessions: remember
+stores TEXT as a Memory node with its --tag tags and creation time, linked
+by ABOUT relationships to the Function, File or Package nodes named by
+each --about key. Later writes replace those nodes but link the memory to
+them again, by key, so it survives re-indexing. The files, packages and
+symbols its text names, such as pkg/store/store.go or Store.Put, and the
+memories, decisions and summaries it cites by ID are linked by MENTIONS
+relationships when they are in the graph; a name matching several nodes
+is left out. recall lists the memories about a key or with a tag, newest
+first, and forget deletes one by ID. They work on the neo4j and falkordb
+backends:
+
+	go run scripts/populate-code-graph.go remember --about Function:pkg/store/store.go:*Store.Put --tag perf "Put holds the lock across fsync"
+	go run scripts/populate-code-graph.go recall --about Function:pkg/store/store.go:*Store.Put
+
+A memory carries an --importance (0.5) and a --confidence (1), from 0 to
+1. recall --rank orders memories by their relevance, as gc scores it
+over a 90-day half-life, times their importance and confidence instead
+of newest first. Pinned memories, remembered with --pin or pinned later
+with pin ID, always come first; pin --unpin ID unpins one:
+
+	go run scripts/populate-code-graph.go remember --about File:main.go --importance 0.9 --pin "never log tokens"
+	go run scripts/populate-code-graph.go recall --about File:main.go --rank --limit 5
+
+Memory text, session summaries and decision titles, rationales and
+alternatives are redacted before they are stored, however they arrive,
+so the graph stays safe to sync to a shared or cloud database: API keys,
+tokens, private keys, assigned passwords and email addresses are
+replaced with [REDACTED:RULE] and the memory is tagged redacted, the
+summary or decision marked redacted. --redact NAME=REGEX
+adds a rule, such as one for internal hostnames, and
+--redact-defaults=false keeps only those given:
+
+	go run scripts/populate-code-graph.go mcp --redact 'host=[\w-]+\.corp\.example\.com'
+
+Memories and decisions are tied to the code they were recorded at: the
+commit checked out in the current directory, or in the project's when
+served, stored as a Commit node they are linked to by a RECORDED_AT
+relationship holding the branch and pull request. The pull request is
+--pr or, with $GITHUB_TOKEN set, the branch's open pull request on
+GitHub, in $GITHUB_REPOSITORY or the origin remote's repository. recall
+and decision list show them as "at COMMIT on BRANCH (#PR)".`,
+		failure:   "remembering",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.Var((*stringList)(&cfg.About), "about", "Key of a node the memory is about, e.g. Function:main.go:run (repeatable)")
+			fs.Var((*stringList)(&cfg.Tags), "tag", "Tag to recall the memory by (repeatable)")
+			fs.StringVar(&cfg.Session, "session", getEnvOrDefault("CODEGRAPH_SESSION", ""), "ID of the session the memory is made during (default $CODEGRAPH_SESSION)")
+			fs.DurationVar(&cfg.TTL, "ttl", 0, "Expire the memory after this long, e.g. 720h, 0 to keep it until gc finds it stale")
+			fs.Float64Var(&cfg.Importance, "importance", codegraph.DefaultImportance, "How much the memory matters when recall ranks memories, from 0 to 1")
+			fs.Float64Var(&cfg.Confidence, "confidence", codegraph.DefaultConfidence, "How sure the memory is, from 0 to 1")
+			fs.BoolVar(&cfg.Pin, "pin", false, "Pin the memory so it is always recalled first")
+			fs.IntVar(&cfg.PR, "pr", 0, "Number of the pull request the memory is made in (default the branch's open one on GitHub, with $GITHUB_TOKEN)")
+			namespaceFlag(fs, cfg)
+			provenanceFlags(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runRemember(ctx, cfg, m, strings.Join(args, ""))
+			})
+		},
+	},
+	{
+		name:    "recall",
+		summary: "List the memories about a node or with tags, pinned and then newest first",
+		doc: `recall lists the memories about an --about key or with a --tag, newest
+first, with those of linked projects (see help link) unless
+--linked=false. --rank orders them by relevance instead, pinned memories
+first (see help remember).
+
+Namespaces let developers share one database without reading each
+other's notes. --namespace, default $CODEGRAPH_NAMESPACE, is a
+comma-separated list such as user:alice,team:payments: remember and
+capture keep memories to the first, and recall lists the shared
+memories, remembered without a namespace, and those in any namespace
+listed, or in every one with --namespace '*'. recall --tag may be
+repeated to list memories with all the tags, and --exclude-tag leaves
+out those with a tag, such as activity:
+
+	CODEGRAPH_NAMESPACE=user:alice,team:payments go run scripts/populate-code-graph.go recall --tag perf --exclude-tag activity
+
+Each memory records its provenance: via cli, hook, mcp, http or
+consolidate, the agent that wrote it, from --agent ($CODEGRAPH_AGENT) or
+the MCP client's name, the model behind it, from --model
+($CODEGRAPH_MODEL) or the MCP tool's model argument, and the user.
+recall --provenance keeps to memories written a way, --trust weighs
+them by a trust from 0 to 1 when ranking, and forget --provenance
+deletes them all:
+
+	go run scripts/populate-code-graph.go recall --about File:main.go --trust via=hook:0.3 --trust model=claude-haiku:0.7
+	go run scripts/populate-code-graph.go forget --provenance agent=old-bot --dry-run`,
+		failure:   "recalling",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.Var((*stringList)(&cfg.About), "about", "Only memories about the node with this key")
+			fs.Var((*stringList)(&cfg.Tags), "tag", "Only memories with this tag (repeatable, all of them)")
+			fs.Var((*stringList)(&cfg.ExcludeTags), "exclude-tag", "Leave out memories with this tag, e.g. activity (repeatable)")
+			namespaceFlag(fs, cfg)
+			fs.IntVar(&cfg.Limit, "limit", 0, "List at most this many memories (0 for all)")
+			fs.BoolVar(&cfg.All, "all", false, "Also list expired and archived memories")
+			fs.BoolVar(&cfg.Rank, "rank", false, "Order memories by relevance, importance and confidence instead of newest first")
+			fs.BoolVar(&cfg.Linked, "linked", true, "Also list the memories of linked projects about the nodes linked to")
+			fs.Var((*stringList)(&cfg.Provenance), "provenance", "Only memories written with this provenance, e.g. via=hook or model=claude-haiku (repeatable, all of them)")
+			fs.Var((*stringList)(&cfg.Trust), "trust", "Weigh memories with this provenance by a trust from 0 to 1 when ranking, e.g. via=hook:0.5 (repeatable, implies --rank)")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runRecall(ctx, cfg, m, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "pin",
+		args:    "ID",
+		maxArgs: 1,
+		summary: "Pin a memory so it is always recalled first, or unpin it",
+		doc: `pin ID pins a memory, so that recall lists it first and gc never
+collects it; pin --unpin ID unpins it.`,
+		failure:   "pinning",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.BoolVar(&cfg.Unpin, "unpin", false, "Unpin the memory instead")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runPin(ctx, cfg, m, strings.Join(args, ""))
+			})
+		},
+	},
+	{
+		name:    "forget",
+		args:    "[ID]",
+		maxArgs: 1,
+		summary: "Delete a memory, or every memory written with a --provenance",
+		doc: `forget deletes a memory by ID, or with --provenance every memory written
+a way, such as by one agent or model; --dry-run lists those it would
+delete:
+
+	go run scripts/populate-code-graph.go forget --provenance agent=old-bot --dry-run`,
+		failure:   "forgetting",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.Var((*stringList)(&cfg.Provenance), "provenance", "Instead of one memory, delete every memory written with this provenance, e.g. agent=old-bot (repeatable, all of them)")
+			fs.BoolVar(&cfg.DryRun, "dry-run", false, "List the memories --provenance would delete without deleting them")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runForget(ctx, cfg, m, strings.Join(args, ""))
+			})
+		},
+	},
+	{
+		name:    "capture",
+		summary: "Remember the files and functions an agent reads or edits, from its hook's JSON on stdin",
+		doc: `capture records what an agent reads and edits without it having to
+remember anything. Run from a Claude Code PostToolUse hook, it reads the
+hook's JSON on stdin and, for the Read, Edit, MultiEdit and Write tools,
+remembers an activity memory: "read FILE" or "edit FILE: FUNCTIONS",
+tagged activity and read or edit, about the file and the functions in
+the lines read or edited, with an importance of 0.1 and expiring after
+--ttl (30 days). Files and functions not in the graph yet are left out.
+The activity is part of the --session or, as a hook cannot pass one,
+of a session started the first time the hook's session_id is seen, and
+edits mark the file touched by it. recall --tag activity lists them. In
+.claude/settings.json:
+
+	{"hooks": {"PostToolUse": [{"matcher": "Read|Edit|MultiEdit|Write", "hooks": [
+	  {"type": "command", "command": "go run scripts/populate-code-graph.go capture --project App"}]}]}}`,
+		failure:   "capturing activity",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Session, "session", getEnvOrDefault("CODEGRAPH_SESSION", ""), "ID of the session the activity is part of (default $CODEGRAPH_SESSION, else one started for the hook event's session_id)")
+			fs.DurationVar(&cfg.TTL, "ttl", 30*24*time.Hour, "Expire activity memories after this long, 0 to keep them until gc finds them stale")
+			namespaceFlag(fs, cfg)
+			provenanceFlags(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runCapture(ctx, cfg, m, os.Stdin)
+			})
+		},
+	},
+	{
+		name:    "gc",
+		summary: "Archive or delete memories whose relevance has decayed",
+		doc: `Memories fade so the graph does not keep stale context forever. A memory
+remembered with --ttl expires that long after, and each recall records
+when and how often it was read. gc scores every memory: 1 when made or
+last recalled, halving every --half-life (90 days) since, with each
+recall lengthening its half-life and expired memories scoring 0. Those
+scoring below --threshold (0.1) are archived, hidden from recall but kept
+for recall --all and session replays, or deleted with --delete. Pinned
+memories are never collected. --dry-run lists them without changing
+anything:
+
+	go run scripts/populate-code-graph.go remember --about Package:pkg/store --ttl 336h "migration half done"
+	go run scripts/populate-code-graph.go gc --half-life 720h --dry-run`,
+		failure:   "collecting memories",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.DurationVar(&cfg.HalfLife, "half-life", 90*24*time.Hour, "Time for a memory's relevance to halve since it was made or last recalled")
+			fs.Float64Var(&cfg.Threshold, "threshold", 0.1, "Collect memories whose relevance is below this, from 0 to 1")
+			fs.BoolVar(&cfg.DeleteMemories, "delete", false, "Delete the memories collected, and those archived before, instead of archiving them")
+			fs.BoolVar(&cfg.DryRun, "dry-run", false, "List the memories that would be collected without changing them")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runGC(ctx, cfg, m, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "consolidate",
+		summary: "Merge near-duplicate memories about the same code",
+		doc: `consolidate merges near-duplicate memories: live memories about a node in
+common whose texts are at least --similarity (0.85) alike, by the words
+they share or, with --embed, the cosine of their embeddings. Each group
+becomes one memory with the longest text and every tag and key of the
+group, linked to the memories merged by CONSOLIDATES relationships and
+listing their IDs in its sources. Those are archived, so they stay as
+its provenance. --dry-run lists the groups without merging them.`,
+		failure:   "consolidating memories",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			embedFlags(fs, cfg)
+			fs.Float64Var(&cfg.Similarity, "similarity", 0.85, "Merge memories about a node in common at least this alike, from 0 to 1")
+			fs.BoolVar(&cfg.DryRun, "dry-run", false, "List the memories that would be merged without changing them")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withMemories(ctx, cfg, func(m codegraph.MemoryStore) error {
+				return runConsolidate(ctx, cfg, m, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "session",
+		args:    "start|end|touch|list|show [ID]",
+		maxArgs: 2,
+		summary: "Record work sessions, and list or replay them",
+		doc: `Sessions group the work of one sitting, such as a Claude Code session.
+session start stores a Session node with its start time, working
+directory (--dir, default the current one) and branch (--branch, default
+the one checked out there) and prints its ID. Memories remembered with
+--session ID, or with the ID in $CODEGRAPH_SESSION, are linked to it by
+RECORDED relationships, and session touch --file records the files it
+changed with TOUCHED relationships, linked again after each write like
+memories. session end records when it ended, session list lists the
+sessions newest first, and session show replays one: where it ran, the
+files it touched and its memories in order, of the --namespace ones and
+the shared ones like recall. end, touch and show take the session's ID as
+an argument, --session or $CODEGRAPH_SESSION.`,
+		failure:   "recording session",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.WorkDir, "dir", "", "Working directory of a session being started (default the current one)")
+			fs.StringVar(&cfg.Branch, "branch", "", "Branch of a session being started (default the one checked out in --dir)")
+			fs.Var((*stringList)(&cfg.Touch), "file", "File the session touched, relative to the project root (repeatable)")
+			fs.IntVar(&cfg.Limit, "limit", 20, "List at most this many sessions (0 for all)")
+			fs.StringVar(&cfg.Session, "session", getEnvOrDefault("CODEGRAPH_SESSION", ""), "ID of the session to end, touch or show, if not an argument (default $CODEGRAPH_SESSION)")
+			namespaceFlag(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withSessions(ctx, cfg, func(s codegraph.SessionStore) error {
+				return runSession(ctx, cfg, s, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "decision",
+		args:    "add|list|status [ID STATUS]",
+		maxArgs: 3,
+		summary: "Record architectural decisions about the code, and list them",
+		doc: `Decisions record why the code is built the way it is. decision add
+stores a Decision node with its --title, --rationale, the --alternative
+options considered and a --status (accepted, proposed, rejected,
+deprecated or superseded; default accepted), linked by AFFECTS
+relationships to each --affects node and kept across writes like
+memories. decision list lists them newest first; with --about KEY it
+lists those affecting the node or the file and package holding it, so
+the decisions about a package are found from any of its functions.
+decision status ID STATUS changes a decision's status, such as when a
+later one supersedes it:
+
+	go run scripts/populate-code-graph.go decision add --title "Writes go through one goroutine" \
+	  --rationale "the driver is not safe for concurrent use" --alternative "a mutex per store" --affects Package:pkg/store
+	go run scripts/populate-code-graph.go decision list --about Function:pkg/store/store.go:*Store.Put`,
+		failure:   "recording decision",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Title, "title", "", "What was decided")
+			fs.StringVar(&cfg.Rationale, "rationale", "", "Why it was decided")
+			fs.Var((*stringList)(&cfg.Alternatives), "alternative", "An option considered instead (repeatable)")
+			fs.Var((*stringList)(&cfg.Affects), "affects", "Key of a node the decision affects, e.g. Package:pkg/store (repeatable)")
+			fs.StringVar(&cfg.Status, "status", "", "Status of a decision being added (default accepted), or only list decisions with it")
+			fs.Var((*stringList)(&cfg.About), "about", "Only list decisions affecting the node with this key or the file and package holding it")
+			fs.IntVar(&cfg.Limit, "limit", 0, "List at most this many decisions (0 for all)")
+			fs.StringVar(&cfg.Session, "session", getEnvOrDefault("CODEGRAPH_SESSION", ""), "ID of the session the decision is made during (default $CODEGRAPH_SESSION)")
+			fs.IntVar(&cfg.PR, "pr", 0, "Number of the pull request a decision being added is made in (default the branch's open one on GitHub, with $GITHUB_TOKEN)")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withDecisions(ctx, cfg, func(d codegraph.DecisionStore) error {
+				return runDecision(ctx, cfg, d, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "convention",
+		args:    "add RULE|list|remove ID",
+		maxArgs: 2,
+		summary: "Record the coding conventions and preferences code should follow, and list them",
+		doc: `Conventions record how code in the project is written, so an agent
+follows them without being told again. convention add RULE stores a
+Convention node in a --category (general, error-handling, logging,
+naming, testing, style, dependencies or preference; default general),
+with a --rationale and an --example, linked by APPLIES_TO relationships
+to each --package, or applying to the whole project without one. A
+package's conventions apply to the packages under it too. Conventions
+are kept to the first --namespace like memories, so a developer's own
+preferences go in user:NAME. convention list --package PATH, or --about
+KEY for the package holding a node, lists those applying there by
+category, and convention remove ID deletes one:
+
+	go run scripts/populate-code-graph.go convention add --category error-handling --package pkg/store \
+	  --rationale "callers match them with errors.Is" "wrap errors with %w and the operation that failed"
+	go run scripts/populate-code-graph.go convention list --about Function:pkg/store/store.go:*Store.Put`,
+		failure:   "recording convention",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Category, "category", "", "Category of a convention being added, one of "+strings.Join(codegraph.ConventionCategories, ", ")+" (default general), or only list conventions in it")
+			fs.StringVar(&cfg.Rationale, "rationale", "", "Why code follows the convention")
+			fs.StringVar(&cfg.Example, "example", "", "Code following the convention")
+			fs.Var((*stringList)(&cfg.Packages), "package", "Path of a package the convention applies to, with those under it, e.g. pkg/store (repeatable; default the whole project), or only list conventions applying to it")
+			fs.Var((*stringList)(&cfg.About), "about", "Only list conventions applying to the package holding the node with this key")
+			fs.IntVar(&cfg.Limit, "limit", 0, "List at most this many conventions (0 for all)")
+			namespaceFlag(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withConventions(ctx, cfg, func(c codegraph.ConventionStore) error {
+				return runConvention(ctx, cfg, c, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "summary",
+		args:    "add TEXT|list",
+		maxArgs: 2,
+		summary: "Record what a session did, and list the last sessions' summaries",
+		doc: `Summaries give the next session the gist of the last ones without
+replaying them. At the end of a session, summary add TEXT stores a
+Summary node linked to the --session by a RECORDED relationship and by
+ABOUT relationships to each --about node, or else to the files the
+session touched, and records the packages holding them. summary list
+--package PATH lists the newest summaries (--limit, 5) of sessions that
+worked on the package, and session show starts with the session's own:
+
+	go run scripts/populate-code-graph.go summary add "Put now retries on a busy store; the cache still ignores it"
+	go run scripts/populate-code-graph.go summary list --package pkg/store --limit 3`,
+		failure:   "recording summary",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.Var((*stringList)(&cfg.About), "about", "Key of a node the session worked on (repeatable; default the files it touched)")
+			fs.StringVar(&cfg.Package, "package", "", "Only list summaries of sessions that worked on this package path, e.g. pkg/store")
+			fs.IntVar(&cfg.Limit, "limit", 5, "List at most this many summaries (0 for all)")
+			fs.StringVar(&cfg.Session, "session", getEnvOrDefault("CODEGRAPH_SESSION", ""), "ID of the session being summarized (default $CODEGRAPH_SESSION)")
+			fs.IntVar(&cfg.PR, "pr", 0, "Number of the pull request the session worked in (default the branch's open one on GitHub, with $GITHUB_TOKEN)")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withSummaries(ctx, cfg, func(s codegraph.SummaryStore) error {
+				return runSessionSummary(ctx, cfg, s, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "knowledge",
+		args:    "export|import [FILE]",
+		maxArgs: 2,
+		summary: "Export the memories and decisions to JSON, or import an export into this database",
+		doc: `knowledge export writes every memory, archived ones included, and every
+decision of the project as JSON to --out, and knowledge import FILE (or
+stdin) stores them in another database or project with the IDs, times
+and commits they had, skipping those already there. They name code by
+key, which a fresh index of the same tree reproduces, so import works
+before or after indexing and links them as the nodes appear:
+
+	go run scripts/populate-code-graph.go knowledge export --project App --out app-knowledge.json
+	go run scripts/populate-code-graph.go knowledge import --project App --neo4j-uri bolt://other:7687 app-knowledge.json`,
+		failure:   "moving knowledge",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Out, "out", "-", "File to export to (- for stdout)")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			backend, err := openBackend(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+			}
+			defer closeBackend(backend)
+			return runKnowledge(ctx, cfg, backend, args, os.Stdin, os.Stdout)
+		},
+	},
+	{
+		name:    "link",
+		args:    "add|imports|list|remove [ID]",
+		maxArgs: 2,
+		summary: "Link nodes to another project's, such as a shared library's, to recall its memories about them",
+		doc: `Projects indexed into one database can be linked, so what is known about
+a shared library is recalled in the services using it. link add links
+the --from node of --project to the --to node of --to-project by a
+--type relationship (DEPENDS_ON, IMPORTS or CALLS; default DEPENDS_ON),
+and link imports --module PATH links each file of --project by IMPORTS
+to the packages of --to-project, whose Go module path is PATH, that it
+imports. Links are kept as CrossLink nodes and made again after either
+project is re-indexed. recall adds the memories of linked projects about
+the nodes linked to, or what holds or is held by them, shown as "from
+PROJECT"; --linked=false leaves them out. link list lists the links from
+or to the project, and link remove ID deletes one:
+
+	go run scripts/populate-code-graph.go link imports --project Api --to-project Lib --module example.com/lib
+	go run scripts/populate-code-graph.go recall --project Api --about File:cmd/main.go`,
+		failure:   "linking",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.LinkFrom, "from", "", "Key of the node of --project to link from, e.g. File:cmd/main.go")
+			fs.StringVar(&cfg.ToProject, "to-project", "", "Project to link to, indexed into the same database")
+			fs.StringVar(&cfg.LinkTo, "to", "", "Key of the node of --to-project to link to, e.g. Package:log")
+			fs.StringVar(&cfg.LinkType, "type", codegraph.CrossLinkTypes[0], "Type of the link: "+strings.Join(codegraph.CrossLinkTypes, ", "))
+			fs.StringVar(&cfg.Module, "module", "", "Go module path of --to-project, to link the files importing its packages")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return withLinker(ctx, cfg, func(l codegraph.CrossLinker) error {
+				return runLink(ctx, cfg, l, args, os.Stdout)
+			})
+		},
+	},
+	{
+		name:    "changes",
+		summary: "Catch up on the code changed and the memories and decisions recorded since a time or session",
+		doc: `changes catches up on a project when resuming work: it lists the keys of
+the files changed in the commits to --path since a time, or in its
+working tree, then of the functions and methods those changes touched,
+followed by the memories and decisions recorded since. The time is
+--since, as a time, a date or a duration before now, or the end of the
+--session given, by default of the last session to have ended:
+
+	go run scripts/populate-code-graph.go changes
+	go run scripts/populate-code-graph.go changes --since 24h`,
+		failure:   "reading changes",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			fs.StringVar(&cfg.Since, "since", "", "Time to catch up from, e.g. 2026-01-02T15:04:05Z or 2026-01-02, or a duration before now, e.g. 24h")
+			fs.StringVar(&cfg.Session, "session", "", "ID of the session to catch up from the end of (default the last session to have ended)")
+			namespaceFlag(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			backend, err := openBackend(ctx, cfg)
+			if err != nil {
+				return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+			}
+			defer closeBackend(backend)
+			return runChanges(ctx, cfg, backend, os.Stdout)
+		},
+	},
+	{
+		name:    "search",
+		args:    "TEXT",
+		maxArgs: 1,
+		summary: "Find the functions and structs closest in meaning to a description",
+		doc: `The search command finds code without knowing its name: it embeds a
+description with the same --embed provider and lists the functions,
+methods and structs closest to it, each with its package, signature, up
+to --callers of its callers and the first --lines lines of its source
+read from --path. Among close matches, those with more callers rank
+higher. --package keeps those in a package and below it:
+
+	go run scripts/populate-code-graph.go index --embed ollama
+	go run scripts/populate-code-graph.go search --embed ollama --package pkg/store "retry a request with backoff"`,
+		failure:   "searching",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			embedFlags(fs, cfg)
+			searchFlags(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if len(args) == 0 {
+				return withExit(exitUsage, errors.New("search needs the text to search for"))
+			}
+			return runSearch(ctx, cfg, args[0], os.Stdout)
+		},
+	},
+	{
+		name:    "similar",
+		args:    "TEXT",
+		maxArgs: 1,
+		summary: "List the functions and structs closest in meaning to a description, with their scores",
+		doc: `similar embeds a description with the --embed provider the project was
+indexed with and lists the functions, methods and structs closest to it,
+with how close each is, ranked by similarity alone; search adds their
+package, callers and source:
+
+	go run scripts/populate-code-graph.go similar --embed ollama "retry a request with backoff"`,
+		failure:   "searching embeddings",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			embedFlags(fs, cfg)
+			fs.IntVar(&cfg.Limit, "limit", 10, "Show at most this many nodes")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if len(args) == 0 {
+				return withExit(exitUsage, errors.New("similar needs the text to search for"))
+			}
+			return runSimilar(ctx, cfg, args[0], os.Stdout)
+		},
+	},
+	{
+		name:    "doctor",
+		summary: "Check the backend, the projects and the machine are ready to index",
+		doc: `The doctor command checks a setup before a first run: that the backend
+can be reached and logged into, the Neo4j version and edition, indexes on
+the keys writes match nodes on, whether APOC is installed, that each --path
+is a git repository, and the free disk and memory. Each problem is printed
+with how to fix it, and doctor exits non-zero if any check failed.`,
+		failure: "checking setup",
+		flags:   func(fs *flag.FlagSet, cfg *Config) {},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return runDoctor(ctx, cfg, targets)
+		},
+	},
+	{
+		name:    "bench",
+		summary: "Index a generated repository repeatedly and report throughput percentiles",
+		doc: `The bench command measures the parser and writer on a repository it
+generates: --packages packages of --files files, each with a struct, an
+interface, a method and --functions functions calling each other and the
+previous package. It parses and writes the repository --runs times as the
+project Bench, replacing it each time, and prints the 50th, 90th and 99th
+percentile throughput of each phase, so releases can be compared on the
+same machine and backend:
+
+	go run scripts/populate-code-graph.go bench --backend sqlite --db-path /tmp/bench.db --runs 10`,
+		failure:   "benchmarking",
+		noTargets: true,
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			writeFlags(fs, cfg)
+			fs.IntVar(&cfg.BenchPackages, "packages", 20, "Packages in the generated repository")
+			fs.IntVar(&cfg.BenchFiles, "files", 10, "Files in each package")
+			fs.IntVar(&cfg.BenchFunctions, "functions", 20, "Functions in each file")
+			fs.IntVar(&cfg.BenchRuns, "runs", 5, "Times to parse and write the repository")
+			fs.StringVar(&cfg.BenchDir, "dir", "", "Generate the repository here and keep it, instead of in a temporary directory")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return runBench(ctx, cfg, os.Stdout)
+		},
+	},
+	{
+		name:    "hook",
+		args:    "[pre-commit|post-commit]",
+		maxArgs: 1,
+		summary: "Rewrite the files a commit touches, from a git hook",
+		doc: `The hook command writes only the Go files touched by the staged changes
+(pre-commit) or by HEAD (post-commit), so it is fast enough to keep the
+graph in step with the repository from a git hook, e.g. in
+.git/hooks/post-commit:
+
+	#!/bin/sh
+	go run /path/to/scripts/populate-code-graph.go hook post-commit --project MyProject --path .`,
+		failure: "indexing commit",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			historyFlags(fs, cfg)
+			coverageFlag(fs, cfg)
+			vulnFlag(fs, cfg)
+			pluginFlag(fs, cfg)
+			writeFlags(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			stage := "post-commit"
+			if len(args) > 0 {
+				stage = args[0]
+			}
+			return forEachTarget(cfg, targets, func(cfg Config) error {
+				return runHook(ctx, cfg, stage)
+			})
+		},
+	},
+	{
+		name:    "serve",
+		serves:  true,
+		summary: "Serve search, nodes, neighbors, named queries and re-indexing over HTTP",
+		doc: `The serve command answers a JSON HTTP API on --listen (localhost:7480) from
+the neo4j or falkordb backend, for editors, bots and dashboards that do not
+speak Bolt. Every project given by --path and --project-map is served;
+?project= picks one and defaults to the first. Requests from web pages not
+on this machine are refused, and those that change anything must be sent
+with Content-Type: application/json.
+
+	GET  /symbols?q=pars&kind=Function  symbols whose name contains q
+	GET  /search?q=TEXT&package=PATH     nodes closest in meaning to TEXT, with --embed
+	GET  /similar?q=TEXT&limit=10        the same, with just their scores
+	GET  /node?key=Function:cmd/main.go:run
+	GET  /neighbors?key=...&direction=in|out|both&type=CALLS
+	GET  /queries                        the named queries
+	GET  /queries/callers?name=Parse     a named query, with name and depth
+	POST /reindex                        parse and rewrite the project
+	GET  /reindex                        the state of the last re-index
+	GET  /projects                       projects and their re-index state
+	GET  /memories?about=KEY&tag=perf    memories, pinned and then newest first, or ranked with rank=true;
+	                                     file=PATH adds those about its symbols, q=TEXT keeps those sharing its words,
+	                                     exclude-tag=TAG leaves tagged ones out, namespace=NS adds NS's to the shared ones,
+	                                     linked=false leaves out those of linked projects, via=, agent=, model= and user= keep
+	                                     to a provenance, trust=FIELD=VALUE:WEIGHT weighs one when ranking
+	POST /memories                       remember {"text", "tags", "about", "session", "importance", "confidence", "pinned", "namespace", "commit", "branch", "pr",
+	                                     "agent", "model", "user"}, via http
+	DELETE /memories/{id}                forget a memory
+	PUT|DELETE /memories/{id}/pin        pin or unpin a memory
+	GET  /sessions                       sessions, newest first
+	POST /sessions                       start a session {"dir", "branch"}
+	GET  /sessions/{id}                  a session, its summaries and its memories, in namespace= and the shared ones
+	POST /sessions/{id}/end              end a session
+	POST /sessions/{id}/touch            record {"files"} as touched
+	POST /sessions/{id}/summary          summarize a session {"text", "about", "commit", "branch", "pr"}
+	GET  /summaries?package=PATH&limit=5 the newest summaries of sessions that worked on a package
+	GET  /decisions?about=KEY&status=    decisions affecting a node or what holds it
+	POST /decisions                      record {"title", "rationale", "alternatives", "status", "affects", "session", "commit", "branch", "pr"}
+	POST /decisions/{id}/status          set a decision's {"status"}
+	GET  /conventions?package=PATH       conventions applying to a package, or the one holding about=KEY, in category=
+	POST /conventions                    record {"category", "rule", "rationale", "example", "packages", "namespace"}
+	DELETE /conventions/{id}             delete a convention
+	GET  /links                          links from or to the project's nodes
+	POST /links                          link {"type", "from", "toProject", "to"}, or {"toProject", "module"} by imports
+	DELETE /links/{id}                   delete a link
+	GET  /changes?since=TIME             code, memories and decisions changed since a time or duration ago, or session=ID's end
+	GET  /metrics                        Prometheus metrics
+
+/metrics counts each project's re-indexes by result and histograms their
+parse and write times, with the statements, batches and retries written,
+the nodes and time of the last successful run, and
+codegraph_staleness_seconds since then. Alerting on staleness over a few
+schedule intervals catches a daemon whose graph has stopped updating.`,
+		failure: "serving",
+		flags:   serverFlags,
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return runServe(ctx, cfg, targets)
+		},
+	},
+	{
+		name:    "daemon",
+		serves:  true,
+		summary: "Serve the HTTP API and re-index on a schedule or new commits",
+		doc: `The daemon command serves the same API and keeps its projects indexed
+without anyone asking: every project at startup, then all of them on a
+five-field cron --schedule, and each one whose git HEAD has moved when
+--poll checks. One project is indexed at a time; a project still being
+indexed when its turn comes again is skipped. GET /projects adds each
+project's schedule, next run and last commit seen. For example, hourly
+and within a minute of every commit:
+
+	go run scripts/populate-code-graph.go daemon --schedule @hourly --poll 1m --path ./api --path ./web`,
+		failure: "running daemon",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			serverFlags(fs, cfg)
+			fs.StringVar(&cfg.Schedule, "schedule", "", "Cron schedule to re-index every project on, e.g. \"0 */6 * * *\" or @daily")
+			fs.DurationVar(&cfg.Poll, "poll", 0, "Check each project's git HEAD this often and re-index when it moves, 0 to not poll")
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return runDaemon(ctx, cfg, targets)
+		},
+	},
+	{
+		name:    "grpc",
+		serves:  true,
+		summary: "Serve the codegraph.v1.CodeGraph gRPC service",
+		doc: `The grpc command serves the codegraph.v1.CodeGraph service described in
+scripts/codegraph.proto on --listen, for tooling that orchestrates
+indexing: StartIndex re-indexes a project in the background, one run at a
+time, StreamProgress follows it, and Query and GetSnapshotDiff match the
+query and diff commands. Like serve it has no TLS or authentication.`,
+		failure: "serving gRPC",
+		flags:   serverFlags,
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			return runGRPC(ctx, cfg, targets)
+		},
+	},
+	{
+		name:    "mcp",
+		serves:  true,
+		summary: "Serve graph tools to Model Context Protocol clients",
+		doc: `The mcp command serves the same graph to Claude Code and other Model
+Context Protocol clients, on stdio or, with --transport sse, over HTTP with
+server-sent events at http://--listen/sse, refusing requests from web
+pages not on this machine. Its tools are search_code_graph,
+get_callers, get_implementations, get_impact, which answers like the
+impact command, reindex_path, which rewrites the files under a path
+after they are edited, remember, recall and forget for memories,
+record_decision and get_decisions for decisions, record_convention and get_conventions for conventions, which
+the server's instructions ask clients to follow, summarize_session and
+get_summaries for session summaries, get_changes_since to catch up on
+what changed since a time or session, and semantic_search when served
+with --embed. remember and recall name a symbol by key or by name, as in
+Store.Put; recall also takes a file, for the memories about it and what
+it declares, and a question, keeping the memories sharing its words,
+most relevant first, and adds those of linked projects about the nodes
+linked to. forget with a correction replaces a memory rather than
+deleting it, archiving the old one as the new one's source. For example:
+
+	claude mcp add codegraph -- go run /path/to/scripts/populate-code-graph.go mcp --project MyProject --path /path/to/repo`,
+		failure: "serving MCP",
+		flags: func(fs *flag.FlagSet, cfg *Config) {
+			serverFlags(fs, cfg)
+			fs.StringVar(&cfg.Transport, "transport", "stdio", "Transport: stdio or sse")
+			namespaceFlag(fs, cfg)
+		},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if err := runMCP(ctx, cfg, targets); !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		},
+	},
+	{
+		name:    "completion",
+		args:    "bash|zsh|fish",
+		maxArgs: 1,
+		summary: "Print a shell completion script",
+		doc: `completion prints a bash, zsh or fish script completing the commands,
+their flags and enumerated values, and --project from the names stored in
+the backend the rest of the command line (or the config file) selects.
+It completes the binary it was generated by, so build one onto PATH:
+
+	go build -o ~/bin/codegraph scripts/populate-code-graph.go
+	codegraph completion bash > /etc/bash_completion.d/codegraph
+	codegraph completion zsh > "${fpath[1]}/_codegraph"
+	codegraph completion fish > ~/.config/fish/completions/codegraph.fish`,
+		failure:   "generating completion",
+		noTargets: true,
+		flags:     func(fs *flag.FlagSet, cfg *Config) {},
+		run: func(ctx context.Context, cfg Config, targets []target, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("completion needs a shell: bash, zsh or fish")
+			}
+			return writeCompletion(os.Stdout, args[0])
+		},
+	},
+}
+
+// globalFlags registers the flags every command takes: what to index, where
+// the backend is and how to log
+func globalFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.ConfigFile, "config", "", "YAML file of default options (default .codegraph.yaml if present)")
+	fs.StringVar(&cfg.Project, "project", "TradingEngine", "Project label for graph nodes")
+	fs.Var((*stringList)(&cfg.Paths), "path", "Path to Go source code, or PROJECT=PATH to index it as another project (repeatable, default .)")
+	fs.Var((*stringList)(&cfg.ProjectMap), "project-map", "Index directories matching this glob below each path as their own project, PATTERN[=TEMPLATE] with {name} for the directory name (repeatable)")
+	fs.StringVar(&cfg.Backend, "backend", "neo4j", "Storage backend: neo4j, sqlite, kuzu, age, falkordb, memory")
+	fs.Var((*stringList)(&cfg.Filter.Include), "include", "Only index files matching this glob, e.g. internal/** (repeatable)")
+	fs.Var((*stringList)(&cfg.Filter.Exclude), "exclude", "Skip files and directories matching this glob, e.g. testdata or *.pb.go (repeatable)")
+	cfg.Filter.MaxFileSize = 2 << 20
+	fs.Var((*byteSize)(&cfg.Filter.MaxFileSize), "max-file-size", "Skip source files larger than this, e.g. 512K or 4M, 0 for no limit")
+	fs.IntVar(&cfg.Filter.MaxFiles, "max-files", 0, "Index at most this many source files, skipping the rest in walk order, 0 for no limit")
+	fs.BoolVar(&cfg.Filter.FollowSymlinks, "follow-symlinks", false, "Index the files and directories symlinks lead to outside --path, instead of skipping symlinks")
+	fs.Var(&cfg.Features, "features", "Comma-separated extractors to switch on, or off with a leading -: calls, imports, implements (on by default), tests, duplicates (repeatable)")
+	fs.BoolVar(&cfg.SyntaxOnly, "syntax-only", false, "Parse each file on its own instead of type-checking packages, resolving calls by name")
+	fs.BoolVar(&cfg.AllowParseErrors, "allow-parse-errors", false, "Exit 0 when files fail to parse, leaving them out of the graph with a warning, instead of exiting 3 once the command is done")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", "", "Directory keeping what is parsed of each file and type-checked package, to skip parsing them again while unchanged")
+	fs.StringVar(&cfg.DBPath, "db-path", ".codegraph.db", "Database file for embedded backends")
+	fs.StringVar(&cfg.Neo4jURI, "neo4j-uri", getEnvOrDefault("NEO4J_URI", "bolt://localhost:7687"), "neo4j: Bolt URI (env NEO4J_URI)")
+	fs.StringVar(&cfg.Neo4jUserEnv, "neo4j-user-env", "NEO4J_USER", "neo4j: environment variable holding the user name")
+	fs.StringVar(&cfg.Neo4jPasswordEnv, "neo4j-password-env", "NEO4J_PASSWORD", "neo4j: environment variable holding the password")
+	fs.StringVar(&cfg.AgeDSN, "age-dsn", getEnvOrDefault("AGE_DSN", "postgres://localhost:5432/postgres"), "age: PostgreSQL connection string (env AGE_DSN)")
+	fs.StringVar(&cfg.AgeGraph, "age-graph", "code_graph", "age: graph name")
+	fs.StringVar(&cfg.FalkorAddr, "falkor-addr", getEnvOrDefault("FALKORDB_ADDR", "localhost:6379"), "falkordb: server address (env FALKORDB_ADDR)")
+	fs.StringVar(&cfg.FalkorPasswordEnv, "falkor-password-env", "FALKORDB_PASSWORD", "falkordb: environment variable holding the password")
+	fs.StringVar(&cfg.FalkorGraph, "falkor-graph", "code_graph", "falkordb: graph key")
+	fs.StringVar(&cfg.LabelMap, "label-map", "", "neo4j, falkordb, cypher, csv: JSON file with a label prefix and renames")
+	fs.Var((*stringList)(&cfg.Redact), "redact", "neo4j, falkordb: NAME=REGEX rule replacing matches in memory, summary and decision text with [REDACTED:NAME] before it is stored, e.g. host=[\\w-]+\\.corp\\.example\\.com (repeatable)")
+	fs.BoolVar(&cfg.RedactDefaults, "redact-defaults", true, "neo4j, falkordb: redact API keys, tokens, private keys, passwords and email addresses from memory, summary and decision text")
+	fs.IntVar(&cfg.MaxPoolSize, "max-pool-size", getEnvInt("NEO4J_MAX_POOL_SIZE", 100),
+		"neo4j: maximum connections in the pool (env NEO4J_MAX_POOL_SIZE)")
+	fs.DurationVar(&cfg.AcquisitionTimeout, "acquisition-timeout", getEnvDuration("NEO4J_ACQUISITION_TIMEOUT", time.Minute),
+		"neo4j: how long to wait for a pooled connection (env NEO4J_ACQUISITION_TIMEOUT)")
+	fs.DurationVar(&cfg.MaxConnLifetime, "max-conn-lifetime", getEnvDuration("NEO4J_MAX_CONN_LIFETIME", time.Hour),
+		"neo4j: close pooled connections older than this (env NEO4J_MAX_CONN_LIFETIME)")
+	fs.DurationVar(&cfg.LivenessCheckTimeout, "liveness-check-timeout", getEnvDuration("NEO4J_LIVENESS_CHECK_TIMEOUT", 0),
+		"neo4j: health-check connections idle longer than this, 0 to never check (env NEO4J_LIVENESS_CHECK_TIMEOUT)")
+	fs.DurationVar(&cfg.ConnectTimeout, "connect-timeout", getEnvDuration("NEO4J_CONNECT_TIMEOUT", 5*time.Second),
+		"neo4j: TCP connect timeout (env NEO4J_CONNECT_TIMEOUT)")
+	fs.BoolVar(&cfg.KeepAlive, "keepalive", getEnvBool("NEO4J_SOCKET_KEEPALIVE", true),
+		"neo4j: enable TCP keep-alive (env NEO4J_SOCKET_KEEPALIVE)")
+	fs.DurationVar(&cfg.Timeout, "timeout", 0, "Abort the run after this long, 0 for no limit")
+	fs.DurationVar(&cfg.StatementTimeout, "statement-timeout", 0, "neo4j, age, falkordb: abort any single statement after this long, 0 for no limit")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Log level: debug, info, warn, error")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "Log format on stderr: text or json")
+	fs.StringVar(&cfg.Progress, "progress", "auto", "Progress reporting: bar, log (every 10s), off, or auto for a bar on a terminal")
+	fs.BoolVar(&cfg.Trace, "trace", false, "Export OpenTelemetry traces over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT (default localhost:4318)")
+	fs.StringVar(&cfg.CPUProfile, "cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof")
+	fs.StringVar(&cfg.MemProfile, "memprofile", "", "Write a heap profile to this file when the run ends, for go tool pprof")
+	fs.StringVar(&cfg.ExecTrace, "exectrace", "", "Write a Go execution trace of the run to this file, for go tool trace")
+	fs.StringVar(&cfg.Output, "output", "text", "Result summary: text, or json for a line of counts, errors and warnings on stdout when the command ends")
+}
+
+// parseFlags registers the flags of commands that parse a tree with its git
+// history
+func parseFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Rev, "rev", "", "Index the tree at this git revision instead of the working tree")
+	historyFlags(fs, cfg)
+	coverageFlag(fs, cfg)
+	vulnFlag(fs, cfg)
+	pluginFlag(fs, cfg)
+	embedFlags(fs, cfg)
+}
+
+func coverageFlag(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.CoverProfile, "coverprofile", "", "Annotate functions with their test coverage from this go test -coverprofile file")
+}
+
+func vulnFlag(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Govulncheck, "govulncheck", "", "Link the vulnerabilities in this govulncheck -json file, or found by running govulncheck if run, to the dependencies and functions they affect")
+}
+
+func pluginFlag(fs *flag.FlagSet, cfg *Config) {
+	fs.Var((*stringList)(&cfg.Plugins), "plugin", "Command adding custom properties to each file's nodes over JSON lines, run with sh -c in --path (repeatable)")
+}
+
+func embedFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Embed, "embed", "", "Embed functions and structs with openai[:MODEL], ollama[:MODEL] or exec:COMMAND, for semantic search")
+	fs.IntVar(&cfg.EmbedBatch, "embed-batch", 64, "Texts sent to the embedding provider per request")
+}
+
+// namespaceFlag registers --namespace, the namespaces memories are
+// remembered in and recalled from
+func namespaceFlag(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Namespace, "namespace", getEnvOrDefault("CODEGRAPH_NAMESPACE", ""),
+		"Comma-separated namespaces, e.g. user:alice,team:payments: memories are remembered in the first and recalled from all of them and the shared ones, * recalling every namespace (default $CODEGRAPH_NAMESPACE)")
+}
+
+// provenanceFlags registers --agent and --model, recorded with the
+// memories a command writes
+func provenanceFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Agent, "agent", getEnvOrDefault("CODEGRAPH_AGENT", ""), "Agent or client writing the memory, recorded as its provenance (default $CODEGRAPH_AGENT)")
+	fs.StringVar(&cfg.Model, "model", getEnvOrDefault("CODEGRAPH_MODEL", ""), "Model behind the agent writing the memory, recorded as its provenance (default $CODEGRAPH_MODEL)")
+}
+
+// provenance returns the provenance of a memory written via the given
+// way, by --agent and --model for the user running the command
+func (cfg Config) provenance(via string) codegraph.Provenance {
+	return codegraph.Provenance{Via: via, Agent: cfg.Agent, Model: cfg.Model, User: currentUser()}
+}
+
+// currentUser returns the name of the user running the command, or "" if
+// unknown
+func currentUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// homeNamespace returns the first namespace of a --namespace list, the
+// one memories are remembered in
+func homeNamespace(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// recallNamespaces returns the namespaces of a --namespace list, or nil
+// for * to recall every namespace
+func recallNamespaces(value string) []string {
+	namespaces := []string{}
+	for namespace := range strings.SplitSeq(value, ",") {
+		switch namespace = strings.TrimSpace(namespace); namespace {
+		case "*":
+			return nil
+		case "":
+		default:
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+func searchFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Package, "package", "", "Only find nodes in this package path or below it")
+	fs.IntVar(&cfg.Limit, "limit", 10, "Show at most this many nodes")
+	fs.IntVar(&cfg.Callers, "callers", 3, "Callers listed with each node")
+	fs.IntVar(&cfg.SnippetLines, "lines", 8, "Source lines shown of each function, 0 for none")
+}
+
+func historyFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.BoolVar(&cfg.Blame, "blame", false, "Annotate files and functions with their owners from git blame and create Author nodes")
+	fs.StringVar(&cfg.Churn, "churn", "", "Count the commits touching each file and function since this git date, e.g. \"90 days ago\"")
+}
+
+// writeFlags registers the flags of commands that write to the backend
+func writeFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.IntVar(&cfg.BatchSize, "batch-size", 500, "neo4j, falkordb: rows written per UNWIND statement")
+	fs.DurationVar(&cfg.FlushInterval, "flush-interval", 0, "neo4j: commit batches together until this long has passed, 0 to commit every batch")
+	fs.BoolVar(&cfg.AdaptiveBatch, "adaptive-batch", false, "neo4j: halve the batch size when the server reports memory pressure")
+	fs.BoolVar(&cfg.SoftDelete, "soft-delete", false, "neo4j, falkordb: mark removed symbols deleted instead of deleting them")
+}
+
+func depthFlag(fs *flag.FlagSet, cfg *Config) {
+	fs.IntVar(&cfg.Depth, "depth", 3, "Traversal depth for impact")
+}
+
+// serverFlags registers the flags of the commands serving an API, which
+// re-index on request
+func serverFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Listen, "listen", "localhost:7480", "Address to listen on")
+	parseFlags(fs, cfg)
+	writeFlags(fs, cfg)
+	depthFlag(fs, cfg)
+}
+
+// newFlagSet returns the flag set of cmd, bound to cfg
+func newFlagSet(cmd *command, cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	globalFlags(fs, cfg)
+	cmd.flags(fs, cfg)
+	fs.Usage = func() { commandUsage(fs.Output(), cmd) }
+	return fs
+}
+
+// lookupCommand returns the command called name, or nil
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// isOption reports whether any command has the flag name, so a config file
+// may set it even when the command being run does not take it
+func isOption(name string) bool {
+	for _, cmd := range commands {
+		if newFlagSet(cmd, &Config{}).Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// usage lists the commands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [COMMAND] [flags]\n\nCommands:\n", programName())
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, cmd := range commands {
+		summary := cmd.summary
+		if i == 0 {
+			summary += " (default)"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s help COMMAND' for the flags of a command.\n", programName())
+}
+
+// commandUsage describes cmd and its flags, then the global flags
+func commandUsage(w io.Writer, cmd *command) {
+	line := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", programName(), cmd.name, cmd.args))
+	fmt.Fprintf(w, "Usage: %s\n\n%s.\n", line, cmd.summary)
+	if cmd.doc != "" {
+		fmt.Fprintf(w, "\n%s\n", cmd.doc)
+	}
+	var cfg Config
+	own := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmd.flags(own, &cfg)
+	own.SetOutput(w)
+	if hasFlags(own) {
+		fmt.Fprintf(w, "\nFlags:\n")
+		own.PrintDefaults()
+	}
+	global := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	globalFlags(global, &cfg)
+	global.SetOutput(w)
+	fmt.Fprintf(w, "\nGlobal flags:\n")
+	global.PrintDefaults()
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	n := 0
+	fs.VisitAll(func(*flag.Flag) { n++ })
+	return n > 0
+}
+
+func programName() string {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".go")
+}
+
+// forEachTarget runs fn with cfg pointed at each target in turn
+func forEachTarget(cfg Config, targets []target, fn func(Config) error) error {
+	for _, t := range targets {
+		cfg := cfg
+		cfg.Project, cfg.Path, cfg.Filter = t.Project, t.Path, t.Filter
+		if err := fn(cfg); err != nil {
+			if len(targets) > 1 {
+				return fmt.Errorf("%s: %w", cfg.Project, err)
+			}
+			return err
+		}
+	}
+	return nil
 }
 
-// StructNode represents a struct definition
-type StructNode struct {
-	Name     string
-	File     string
-	Fields   []string
-	IsExport bool
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "__complete" {
+		// The shell is waiting on stdout; logs would only get in the way
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		complete(os.Stdout, args[1:])
+		return
+	}
+	cmd := commands[0]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] == "help" {
+			if len(args) > 1 {
+				if cmd := lookupCommand(args[1]); cmd != nil {
+					commandUsage(os.Stdout, cmd)
+					return
+				}
+				fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[1])
+				usage(os.Stderr)
+				os.Exit(exitUsage)
+			}
+			usage(os.Stdout)
+			return
+		}
+		if cmd = lookupCommand(args[0]); cmd == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+			usage(os.Stderr)
+			os.Exit(exitUsage)
+		}
+		args = args[1:]
+	}
+
+	var cfg Config
+	fs := newFlagSet(cmd, &cfg)
+	fs.Parse(args)
+
+	// Arguments may come before or after the flags
+	var positional []string
+	for fs.NArg() > 0 {
+		positional = append(positional, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	if len(positional) > cmd.maxArgs {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n\n", strings.Join(positional[cmd.maxArgs:], " "))
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	summary.Command = cmd.name
+	// exit ends the run, printing its summary first under --output json
+	exit := func(code int) {
+		if cfg.Output == "json" {
+			summary.write(os.Stdout, code)
+		}
+		os.Exit(code)
+	}
+
+	configFile, required := cfg.ConfigFile, cfg.ConfigFile != ""
+	if !required {
+		configFile = defaultConfigFile
+	}
+	if err := applyConfigFile(fs, configFile, required); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+		summary.addMessage(slog.LevelError, "reading config: "+err.Error())
+		exit(exitUsage)
+	}
+	if cfg.Output != "text" && cfg.Output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown --output %q, expected text or json\n", cfg.Output)
+		os.Exit(exitUsage)
+	}
+
+	logger, err := newLogger(stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in --log-level/--log-format: %v\n", err)
+		summary.addMessage(slog.LevelError, "--log-level/--log-format: "+err.Error())
+		exit(exitUsage)
+	}
+	if cfg.Output == "json" {
+		logger = slog.New(summaryHandler{Handler: logger.Handler()})
+	}
+	slog.SetDefault(logger)
+
+	// A bar only makes sense on a terminal reading text logs
+	switch cfg.Progress {
+	case "auto":
+		progressMode = "log"
+		// An MCP client owns the terminal, if there is one
+		if isTerminal(os.Stderr) && cfg.LogFormat == "text" && cmd.name != "mcp" {
+			progressMode = "bar"
+		}
+	case "bar", "log":
+		progressMode = cfg.Progress
+	case "off":
+	default:
+		slog.Error("unknown --progress, expected auto, bar, log or off", "progress", cfg.Progress)
+		exit(exitUsage)
+	}
+
+	codegraph.StartProgress = func(name, unit string, total int) codegraph.Progress {
+		return startProgress(name, unit, total)
+	}
+
+	cfg.Filter.Tests = cfg.Features.Enabled("tests")
+	if err := cfg.Filter.Validate(); err != nil {
+		slog.Error("invalid --include/--exclude", "err", err)
+		exit(exitUsage)
+	}
+
+	if cfg.LabelMap != "" {
+		labels, err := codegraph.LoadLabelMap(cfg.LabelMap)
+		if err != nil {
+			slog.Error("reading label map", "path", cfg.LabelMap, "err", err)
+			exit(exitUsage)
+		}
+		cfg.Labels = labels
+	}
+	rules, err := redactRules(cfg.Redact, cfg.RedactDefaults)
+	if err != nil {
+		slog.Error("invalid --redact", "err", err)
+		exit(exitUsage)
+	}
+	cfg.RedactRules = rules
+
+	// Ctrl-C and SIGTERM cancel in-flight statements so the backend can be
+	// closed cleanly instead of leaving the process to be killed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	var targets []target
+	if !cmd.noTargets {
+		if targets, err = cfg.targets(); err != nil {
+			slog.Error("invalid --path/--project-map", "err", err)
+			exit(exitUsage)
+		}
+	}
+
+	if cfg.Trace {
+		shutdown, err := startTracing(ctx)
+		if err != nil {
+			slog.Error("starting tracing", "err", err)
+			exit(exitUsage)
+		}
+		// Spans are exported in batches, so the last ones go out on exit
+		next := exit
+		exit = func(code int) {
+			shutdown()
+			next(code)
+		}
+		defer shutdown()
+	}
+	stopProfiling, err := startProfiling(cfg)
+	if err != nil {
+		slog.Error("starting profiling", "err", err)
+		exit(exitUsage)
+	}
+	// Profiles are only complete once stopped, so on exit too
+	next := exit
+	exit = func(code int) {
+		stopProfiling()
+		next(code)
+	}
+	defer stopProfiling()
+	// Commands that run until interrupted trace each re-index and query on
+	// its own rather than under one span that never ends
+	span := trace.SpanFromContext(ctx)
+	if !cmd.serves && !cfg.Watch {
+		ctx, span = tracer.Start(ctx, cmd.name, trace.WithAttributes(attribute.StringSlice("codegraph.projects", projectNames(targets))))
+	}
+	err = cmd.run(ctx, cfg, targets, positional)
+	endSpan(span, err)
+	if err != nil {
+		slog.Error(cmd.failure, "err", describeCancel(ctx, err))
+		exit(exitCode(err))
+	}
+	// Servers report the files of each re-index as they go
+	if n := summary.parseFailures(); n > 0 && !cfg.AllowParseErrors && !cmd.serves && !cfg.Watch {
+		slog.Error("files failed to parse and were left out", "files", n)
+		exit(exitParse)
+	}
+	if cfg.Output == "json" {
+		summary.write(os.Stdout, 0)
+	}
+}
+
+// tracer records the commands and their parse and write phases; the spans
+// of files, batches and queries come from pkg/codegraph
+var tracer = otel.Tracer("github.com/amarodeabreu/claude-graph-memory/scripts")
+
+// startTracing installs a tracer provider exporting over OTLP/HTTP, set up
+// by the standard OTEL_EXPORTER_OTLP_* and OTEL_SERVICE_NAME variables. The
+// returned function flushes the spans not yet exported.
+func startTracing(ctx context.Context) (func(), error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "codegraph")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Warn("exporting traces", "err", err)
+		}
+	}, nil
+}
+
+// startProfiling starts the CPU profile and execution trace asked for by
+// --cpuprofile and --exectrace. The returned function stops them and
+// writes the heap profile asked for by --memprofile.
+func startProfiling(cfg Config) (func(), error) {
+	var stops []func()
+	stop := func() {
+		for _, stop := range slices.Backward(stops) {
+			stop()
+		}
+	}
+	closeFile := func(f *os.File) {
+		if err := f.Close(); err != nil {
+			slog.Warn("writing profile", "file", f.Name(), "err", err)
+		}
+	}
+	if cfg.CPUProfile != "" {
+		f, err := os.Create(cfg.CPUProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			closeFile(f)
+		})
+	}
+	if cfg.ExecTrace != "" {
+		f, err := os.Create(cfg.ExecTrace)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if err := rtrace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			rtrace.Stop()
+			closeFile(f)
+		})
+	}
+	if cfg.MemProfile != "" {
+		// Created now so a path that cannot be written fails the run early
+		f, err := os.Create(cfg.MemProfile)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			// Up to date statistics, see pprof.WriteHeapProfile
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				slog.Warn("writing profile", "file", f.Name(), "err", err)
+			}
+			closeFile(f)
+		})
+	}
+	return stop, nil
+}
+
+// profilePhase runs fn as the phase name of a run: its CPU samples
+// are labelled phase=name and it is a region of the execution trace
+func profilePhase(ctx context.Context, name string, fn func(ctx context.Context)) {
+	pprof.Do(ctx, pprof.Labels("phase", name), func(ctx context.Context) {
+		defer rtrace.StartRegion(ctx, name).End()
+		fn(ctx)
+	})
+}
+
+// endSpan marks span failed if err is not nil, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// flagValues completes the values of enumerated flags; flags naming files
+// are left to the shell
+var flagValues = map[string][]string{
+	"backend":      backends,
+	"log-level":    {"debug", "info", "warn", "error"},
+	"log-format":   {"text", "json"},
+	"progress":     {"auto", "bar", "log", "off"},
+	"query":        {"callers", "callees", "implementers", "impact"},
+	"dot-view":     {"imports", "structure"},
+	"mermaid-view": {"class", "flowchart"},
+	"transport":    {"stdio", "sse"},
+}
+
+// formatValues completes --format, which means something else per command
+var formatValues = map[string][]string{
+	"export":   {"cypher", "csv", "parquet", "graphml", "dot", "json", "mermaid"},
+	"query":    {"table", "json", "csv"},
+	"breaking": {"text", "json"},
+	"untested": {"text", "json"},
+}
+
+// argValues completes the positional arguments of commands that take one
+func argValues(cmd *command) []string {
+	switch cmd.name {
+	case "completion":
+		return []string{"bash", "zsh", "fish"}
+	case "hook":
+		return []string{"pre-commit", "post-commit"}
+	case "query":
+		return slices.Sorted(maps.Keys(namedQueries))
+	}
+	return nil
+}
+
+// complete prints the candidates for the last of words, the arguments typed
+// so far, one per line. The completion scripts call it as __complete so
+// that what they offer always matches the binary.
+func complete(w io.Writer, words []string) {
+	if len(words) == 0 {
+		return
+	}
+	prior, current := words[:len(words)-1], words[len(words)-1]
+	var candidates []string
+	switch {
+	case len(prior) == 0 && !strings.HasPrefix(current, "-"):
+		candidates = append(candidates, "help")
+		for _, cmd := range commands {
+			candidates = append(candidates, cmd.name)
+		}
+	case len(prior) == 1 && prior[0] == "help":
+		for _, cmd := range commands {
+			candidates = append(candidates, cmd.name)
+		}
+	default:
+		candidates = completeCommand(prior, current)
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Fprintln(w, candidate)
+		}
+	}
+}
+
+// completeCommand completes the flags, flag values and arguments of the
+// command prior names, or index
+func completeCommand(prior []string, current string) []string {
+	cmd := commands[0]
+	if len(prior) > 0 && !strings.HasPrefix(prior[0], "-") {
+		if cmd = lookupCommand(prior[0]); cmd == nil {
+			return nil
+		}
+		prior = prior[1:]
+	}
+	cfg := Config{}
+	fs := newFlagSet(cmd, &cfg)
+	fs.Init(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	// A flag without its value yet
+	if len(prior) > 0 && strings.HasPrefix(prior[len(prior)-1], "-") && !strings.Contains(prior[len(prior)-1], "=") {
+		name := strings.TrimLeft(prior[len(prior)-1], "-")
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) {
+			return completeValue(cmd, fs, &cfg, prior[:len(prior)-1], name)
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		var names []string
+		fs.VisitAll(func(f *flag.Flag) { names = append(names, "--"+f.Name) })
+		return names
+	}
+
+	args := 0
+	for rest := prior; len(rest) > 0; {
+		if fs.Parse(rest) != nil || fs.NArg() == 0 {
+			break
+		}
+		args++
+		rest = fs.Args()[1:]
+	}
+	if args < cmd.maxArgs {
+		return argValues(cmd)
+	}
+	return nil
+}
+
+// completeValue completes the value of flag name. Project names come from
+// the backend the rest of the command line and the config file point at.
+func completeValue(cmd *command, fs *flag.FlagSet, cfg *Config, prior []string, name string) []string {
+	switch name {
+	case "format":
+		return formatValues[cmd.name]
+	case "project":
+		for rest := prior; len(rest) > 0; rest = fs.Args()[1:] {
+			if fs.Parse(rest) != nil || fs.NArg() == 0 {
+				break
+			}
+		}
+		configFile, required := cfg.ConfigFile, cfg.ConfigFile != ""
+		if !required {
+			configFile = defaultConfigFile
+		}
+		if applyConfigFile(fs, configFile, required) != nil {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		projects, err := storedProjects(ctx, *cfg)
+		if err != nil {
+			return nil
+		}
+		return projects
+	}
+	return flagValues[name]
+}
+
+// storedProjects names the projects the configured backend holds
+func storedProjects(ctx context.Context, cfg Config) ([]string, error) {
+	if cfg.LabelMap != "" {
+		labels, err := codegraph.LoadLabelMap(cfg.LabelMap)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Labels = labels
+	}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBackend(backend)
+	lister, ok := backend.(codegraph.Lister)
+	if !ok {
+		return nil, fmt.Errorf("backend %s cannot list projects", cfg.Backend)
+	}
+	return lister.Projects(ctx)
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// completionScripts hand every completion to __complete. %[1]s is the
+// program name and %[2]s the same name usable in a shell identifier.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for %[1]s
+_%[2]s_complete() {
+	local IFS=$'\n'
+	COMPREPLY=($(%[1]s __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _%[2]s_complete %[1]s
+`,
+	"zsh": `#compdef %[1]s
+_%[2]s() {
+	local -a candidates
+	candidates=("${(@f)$(%[1]s __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n ${candidates[1]} ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _%[2]s %[1]s
+`,
+	"fish": `# fish completion for %[1]s
+function __%[2]s_complete
+	set -l words (commandline -opc)
+	set -l current (commandline -ct)
+	%[1]s __complete $words[2..-1] "$current" 2>/dev/null
+end
+complete -c %[1]s -a '(__%[2]s_complete)'
+`,
+}
+
+// writeCompletion writes the completion script for shell
+func writeCompletion(w io.Writer, shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", shell)
+	}
+	name := programName()
+	ident := regexp.MustCompile(`[^A-Za-z0-9_]`).ReplaceAllString(name, "_")
+	_, err := fmt.Fprintf(w, script, name, ident)
+	return err
+}
+
+// populate parses cfg.Path and writes or exports it as cfg.Project, adding
+// its counts to the summary
+func populate(ctx context.Context, cfg Config) error {
+	log := slog.With("project", cfg.Project)
+	attrs := []any{"path", cfg.Path, "backend", cfg.Backend}
+	if cfg.Rev != "" {
+		attrs = append(attrs, "rev", cfg.Rev)
+	}
+	switch cfg.Backend {
+	case "neo4j":
+		attrs = append(attrs, "uri", cfg.Neo4jURI)
+	case "age":
+		attrs = append(attrs, "graph", cfg.AgeGraph)
+	case "falkordb":
+		attrs = append(attrs, "addr", cfg.FalkorAddr, "graph", cfg.FalkorGraph)
+	default:
+		attrs = append(attrs, "db", cfg.DBPath)
+	}
+	log.Info("Code Graph Populator", attrs...)
+	run := codegraph.NewRunInfo()
+	run.Commit = codegraph.ResolveCommit(ctx, cfg.Path, cfg.Rev)
+
+	// Parse the codebase
+	parseStart := time.Now()
+	parseCtx, span := tracer.Start(ctx, "parse", trace.WithAttributes(attribute.String("codegraph.project", cfg.Project)))
+	var graph *codegraph.Graph
+	var err error
+	profilePhase(parseCtx, "parse", func(ctx context.Context) { graph, err = parseTarget(ctx, cfg) })
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("parsing codebase: %w", err)
+	}
+	metrics := codegraph.RunMetrics{ParseTime: time.Since(parseStart), Files: len(graph.Files)}
+	result := graphSummary(cfg.Project, graph)
+	result.Run, result.Commit = run.ID, run.Commit
+	defer func() { summary.addProject(result) }()
+
+	attrs = []any{
+		"files", len(graph.Files),
+		"packages", len(graph.Packages),
+		"functions", len(graph.Functions),
+		"structs", len(graph.Structs),
+		"interfaces", len(graph.Interfaces),
+	}
+	if cfg.Blame {
+		attrs = append(attrs, "authors", len(graph.Authors))
+	}
+	log.Info("parsed", attrs...)
+
+	if cfg.ExportFormat != "" {
+		if err := exportGraph(cfg, graph, run); err != nil {
+			return fmt.Errorf("exporting graph: %w", err)
+		}
+		if cfg.Out != "-" {
+			log.Info("exported", "format", cfg.ExportFormat, "out", cfg.Out)
+		}
+		return nil
+	}
+
+	if cfg.DryRun {
+		log.Info("dry run, not writing to database")
+		if cfg.ShowStatements {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if err := printStatements(os.Stdout, codegraph.StreamStatements(ctx, cfg.Project, graph, run, cfg.statementOptions()), max(cfg.BatchSize, 1)); err != nil {
+				return fmt.Errorf("printing statements: %w", err)
+			}
+			return nil
+		}
+		printSample(graph)
+		return nil
+	}
+
+	// --since writes only the files changed since a git ref
+	var changed []string
+	if cfg.Since != "" {
+		changed, err = changedSince(ctx, cfg.Path, cfg.Since, cfg.Filter)
+		if err != nil {
+			return fmt.Errorf("listing changes since %s: %w", cfg.Since, err)
+		}
+		log.Info("changed files", "since", cfg.Since, "files", len(changed))
+		if len(changed) == 0 {
+			return nil
+		}
+		result.WrittenFiles = len(changed)
+	}
+
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+
+	log = log.With("run", run.ID)
+	log.Info("writing graph")
+
+	// Create the graph
+	writeStart := time.Now()
+	writeCtx, span := tracer.Start(ctx, "write", trace.WithAttributes(
+		attribute.String("codegraph.project", cfg.Project),
+		attribute.String("codegraph.backend", cfg.Backend)))
+	profilePhase(writeCtx, "write", func(ctx context.Context) {
+		if cfg.Since != "" {
+			err = codegraph.WriteFiles(ctx, backend, cfg.Project, graph, changed, run)
+		} else {
+			err = backend.Write(ctx, cfg.Project, graph, run)
+		}
+	})
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("creating graph: %w", err)
+	}
+	metrics.WriteTime = time.Since(writeStart)
+	metrics.Nodes = len(graph.Nodes())
+	metrics.Relationships = len(graph.Relationships())
+	if s, ok := backend.(interface{ Stats() codegraph.WriteStats }); ok {
+		metrics.WriteStats = s.Stats()
+	}
+	if progressMode == "bar" {
+		printSummary(stderr, metrics)
+	} else {
+		logMetrics(log, metrics)
+	}
+
+	if v, ok := backend.(codegraph.Validator); ok && cfg.Validate {
+		violations, err := v.Validate(ctx, cfg.Project, graph)
+		if err != nil {
+			return fmt.Errorf("validating graph: %w", err)
+		}
+		if len(violations) > 0 {
+			for _, violation := range violations {
+				log.Error("validation failed", "violation", violation)
+			}
+			return withExit(exitValidation, fmt.Errorf("graph failed %d validation checks", len(violations)))
+		}
+		log.Info("validation passed")
+	}
+
+	if cfg.RecordRun {
+		nb, ok := backend.(*codegraph.Neo4jWriter)
+		if !ok {
+			return withExit(exitUsage, errors.New("--record-run needs --backend neo4j"))
+		}
+		if err := nb.RecordRun(ctx, cfg.Project, run, metrics); err != nil {
+			return fmt.Errorf("recording run: %w", err)
+		}
+	}
+
+	if cfg.Query != "" {
+		mem, ok := backend.(*codegraph.MemoryWriter)
+		if !ok {
+			return withExit(exitUsage, errors.New("--query needs --backend memory"))
+		}
+		if err := printQuery(mem, cfg.Query, cfg.Symbol, cfg.Depth); err != nil {
+			return fmt.Errorf("running query: %w", err)
+		}
+		return nil
+	}
+
+	if cfg.Backend == "neo4j" {
+		log.Info("code graph populated", "browser", "http://localhost:7474")
+	} else {
+		log.Info("code graph populated")
+	}
+
+	if cfg.Watch {
+		if err := watchCodebase(ctx, cfg, backend, graph); err != nil {
+			return fmt.Errorf("watching %s: %w", cfg.Path, err)
+		}
+	}
+	return nil
+}
+
+// parseTarget parses cfg.Path, at cfg.Rev if set, with the git ownership,
+// churn and plugin properties the flags ask for
+func parseTarget(ctx context.Context, cfg Config) (*codegraph.Graph, error) {
+	parser := codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}
+	graph, err := parseTree(ctx, parser, cfg.Rev)
+	if err != nil {
+		return nil, withExit(exitParse, err)
+	}
+	graph.Features = cfg.Features
+	if cfg.Blame {
+		if err := codegraph.AddOwnership(ctx, graph, cfg.Path, cfg.Rev); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Churn != "" {
+		if err := codegraph.AddChurn(ctx, graph, cfg.Path, cfg.Rev, cfg.Churn); err != nil {
+			return nil, err
+		}
+	}
+	if err := addCoverage(cfg, graph); err != nil {
+		return nil, err
+	}
+	if err := addVulnerabilities(ctx, cfg, graph); err != nil {
+		return nil, err
+	}
+	if err := enrichGraph(ctx, cfg, graph); err != nil {
+		return nil, err
+	}
+	if err := embedGraph(ctx, cfg, graph); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// parseTree parses the tree of parser, at the git revision rev if set, and
+// counts the files that failed to parse towards exiting exitParse
+func parseTree(ctx context.Context, parser codegraph.Parser, rev string) (*codegraph.Graph, error) {
+	parse := parser.Parse
+	if rev != "" {
+		parse = func(ctx context.Context) (*codegraph.Graph, error) { return parser.ParseRevision(ctx, rev) }
+	}
+	graph, err := parse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary.addParseFailures(len(graph.Failed))
+	return graph, nil
+}
+
+// addCoverage annotates the functions of graph with their test coverage
+// from --coverprofile, if given
+func addCoverage(cfg Config, graph *codegraph.Graph) error {
+	if cfg.CoverProfile == "" {
+		return nil
+	}
+	f, err := os.Open(cfg.CoverProfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := codegraph.AddCoverage(graph, f); err != nil {
+		return fmt.Errorf("reading %s: %w", cfg.CoverProfile, err)
+	}
+	return nil
+}
+
+// addVulnerabilities records the vulnerabilities of --govulncheck in graph:
+// those of the file it names, or found by running govulncheck in cfg.Path
+func addVulnerabilities(ctx context.Context, cfg Config, graph *codegraph.Graph) error {
+	var report io.Reader
+	switch cfg.Govulncheck {
+	case "":
+		return nil
+	case "run":
+		out, err := codegraph.RunGovulncheck(ctx, cfg.Path)
+		if err != nil {
+			return err
+		}
+		report = bytes.NewReader(out)
+	default:
+		f, err := os.Open(cfg.Govulncheck)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		report = f
+	}
+	if err := codegraph.AddVulnerabilities(graph, report); err != nil {
+		return fmt.Errorf("reading %s: %w", cmp.Or(cfg.Govulncheck, "govulncheck"), err)
+	}
+	slog.Info("found vulnerabilities", "project", cfg.Project, "vulnerabilities", len(graph.Vulnerabilities))
+	return nil
+}
+
+// enrichGraph runs the --plugin commands over graph, each started in
+// cfg.Path for the one pass
+func enrichGraph(ctx context.Context, cfg Config, graph *codegraph.Graph) (err error) {
+	var enrichers []codegraph.Enricher
+	for _, command := range cfg.Plugins {
+		e, err := codegraph.StartEnricher(ctx, command, cfg.Path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, e.Close()) }()
+		enrichers = append(enrichers, e)
+	}
+	return codegraph.Enrich(ctx, graph, enrichers...)
+}
+
+// embedGraph embeds graph's functions and structs with the --embed
+// provider, if one is set
+func embedGraph(ctx context.Context, cfg Config, graph *codegraph.Graph) (err error) {
+	if cfg.Embed == "" {
+		return nil
+	}
+	e, closeEmbedder, err := openEmbedder(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeEmbedder()) }()
+	return codegraph.Embed(ctx, graph, e, cfg.EmbedBatch)
+}
+
+// openEmbedder returns the embedding provider --embed names, and a function
+// stopping it. Unknown providers are usage errors.
+func openEmbedder(ctx context.Context, cfg Config) (codegraph.Embedder, func() error, error) {
+	noop := func() error { return nil }
+	provider, model, _ := strings.Cut(cfg.Embed, ":")
+	switch provider {
+	case "openai":
+		return &codegraph.OpenAIEmbedder{
+			BaseURL: os.Getenv("OPENAI_BASE_URL"),
+			APIKey:  os.Getenv("OPENAI_API_KEY"),
+			Model:   cmp.Or(model, "text-embedding-3-small"),
+		}, noop, nil
+	case "ollama":
+		host := os.Getenv("OLLAMA_HOST")
+		if host != "" && !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return &codegraph.OllamaEmbedder{BaseURL: host, Model: cmp.Or(model, "nomic-embed-text")}, noop, nil
+	case "exec":
+		if model == "" {
+			return nil, nil, withExit(exitUsage, errors.New("--embed exec: needs a command"))
+		}
+		e, err := codegraph.StartEmbedder(ctx, model, cfg.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return e, e.Close, nil
+	}
+	return nil, nil, withExit(exitUsage, fmt.Errorf("unknown embedding provider %q: use openai, ollama or exec", provider))
+}
+
+// backends are the values --backend takes
+var backends = []string{"neo4j", "sqlite", "kuzu", "age", "falkordb", "memory"}
+
+// openBackend connects to the storage selected by --backend. Its errors
+// exit with exitConnection.
+func openBackend(ctx context.Context, cfg Config) (codegraph.Writer, error) {
+	if !slices.Contains(backends, cfg.Backend) {
+		return nil, withExit(exitUsage, fmt.Errorf("unknown backend %q", cfg.Backend))
+	}
+	backend, err := dialBackend(ctx, cfg)
+	return backend, withExit(exitConnection, err)
+}
+
+// dialBackend connects to or opens the backend cfg names
+func dialBackend(ctx context.Context, cfg Config) (codegraph.Writer, error) {
+	switch cfg.Backend {
+	case "neo4j":
+		// Connect to NornicDB
+		driver, err := newNeo4jDriver(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to Neo4j: %w", err)
+		}
+
+		// Verify connection
+		if err := driver.VerifyConnectivity(ctx); err != nil {
+			driver.Close(ctx)
+			return nil, fmt.Errorf("cannot connect to Neo4j: %w", err)
+		}
+		slog.Info("connected to NornicDB", "uri", cfg.Neo4jURI)
+		return &codegraph.Neo4jWriter{Driver: driver, Statements: cfg.statementOptions(), Options: codegraph.WriteOptions{
+			BatchSize:        cfg.BatchSize,
+			FlushInterval:    cfg.FlushInterval,
+			Adaptive:         cfg.AdaptiveBatch,
+			StatementTimeout: cfg.StatementTimeout,
+		}, Redact: cfg.RedactRules}, nil
+
+	case "sqlite":
+		return codegraph.OpenSQLite(ctx, cfg.DBPath)
+
+	case "kuzu":
+		return codegraph.OpenKuzu(cfg.DBPath)
+
+	case "age":
+		b, err := codegraph.OpenAGE(ctx, cfg.AgeDSN, cfg.AgeGraph)
+		if err != nil {
+			return nil, err
+		}
+		b.StatementTimeout = cfg.StatementTimeout
+		return b, nil
+
+	case "falkordb":
+		b, err := codegraph.OpenFalkorDB(ctx, cfg.FalkorAddr, cfg.FalkorGraph, os.Getenv(cfg.FalkorPasswordEnv))
+		if err != nil {
+			return nil, err
+		}
+		b.Statements = cfg.statementOptions()
+		b.Redact = cfg.RedactRules
+		b.BatchSize = max(cfg.BatchSize, 1)
+		b.StatementTimeout = cfg.StatementTimeout
+		return b, nil
+
+	case "memory":
+		return &codegraph.MemoryWriter{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
+// statementOptions returns the options the Cypher-based writers share
+func (cfg Config) statementOptions() codegraph.StatementOptions {
+	return codegraph.StatementOptions{Labels: cfg.Labels, SoftDelete: cfg.SoftDelete}
+}
+
+// redactRules returns the rules memory, summary and decision text is
+// redacted by: the defaults, if wanted, then those of each --redact
+// NAME=REGEX
+func redactRules(values []string, defaults bool) ([]codegraph.RedactRule, error) {
+	var rules []codegraph.RedactRule
+	if defaults {
+		rules = slices.Clone(codegraph.DefaultRedactRules)
+	}
+	for _, value := range values {
+		rule, err := codegraph.ParseRedactRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// closeBackend closes the backend with a fresh deadline, since the run's
+// context may already have been cancelled
+func closeBackend(backend codegraph.Writer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := backend.Close(ctx); err != nil {
+		slog.Error("closing backend", "err", err)
+	}
+}
+
+// describeCancel explains errors caused by an interrupt or --timeout rather
+// than reporting the driver's context error
+func describeCancel(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return fmt.Errorf("interrupted: %w", err)
+	case context.DeadlineExceeded:
+		return fmt.Errorf("timed out: %w", err)
+	}
+	return err
+}
+
+// newNeo4jDriver creates a driver with the configured pool settings. It
+// authenticates only when the credential variables are set.
+func newNeo4jDriver(cfg Config) (neo4j.DriverWithContext, error) {
+	auth := neo4j.NoAuth()
+	if user, password := os.Getenv(cfg.Neo4jUserEnv), os.Getenv(cfg.Neo4jPasswordEnv); user != "" || password != "" {
+		auth = neo4j.BasicAuth(user, password, "")
+	}
+	return neo4j.NewDriverWithContext(cfg.Neo4jURI, auth, func(c *neo4j.Config) {
+		c.MaxConnectionPoolSize = cfg.MaxPoolSize
+		c.ConnectionAcquisitionTimeout = cfg.AcquisitionTimeout
+		c.MaxConnectionLifetime = cfg.MaxConnLifetime
+		if cfg.LivenessCheckTimeout > 0 {
+			c.ConnectionLivenessCheckTimeout = cfg.LivenessCheckTimeout
+		}
+		c.SocketConnectTimeout = cfg.ConnectTimeout
+		c.SocketKeepalive = cfg.KeepAlive
+	})
+}
+
+// defaultConfigFile is read from the working directory when --config is not
+// given
+const defaultConfigFile = ".codegraph.yaml"
+
+// configPathFlags are the options whose relative paths are resolved against
+// the config file's directory rather than the working directory
+var configPathFlags = []string{"path", "db-path", "label-map"}
+
+// loadConfigFile reads a YAML config file and returns its options keyed by
+// flag name. Nested sections are joined to their keys with a dash, so
+//
+//	neo4j:
+//	  uri: bolt://graph:7687
+//
+// sets --neo4j-uri. Lists give one value per item.
+func loadConfigFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	options := make(map[string][]string)
+	var flatten func(prefix string, m map[string]any)
+	flatten = func(prefix string, m map[string]any) {
+		for key, value := range m {
+			name := prefix + key
+			switch v := value.(type) {
+			case map[string]any:
+				flatten(name+"-", v)
+			case []any:
+				for _, item := range v {
+					options[name] = append(options[name], fmt.Sprint(item))
+				}
+			case nil:
+			default:
+				options[name] = []string{fmt.Sprint(v)}
+			}
+		}
+	}
+	flatten("", doc)
+
+	dir := filepath.Dir(path)
+	for _, name := range configPathFlags {
+		for i, value := range options[name] {
+			// Keep the PROJECT= of a --path
+			prefix := ""
+			if i := strings.LastIndex(value, "="); i >= 0 {
+				prefix, value = value[:i+1], value[i+1:]
+			}
+			if !filepath.IsAbs(value) {
+				options[name][i] = prefix + filepath.Join(dir, value)
+			}
+		}
+	}
+	return options, nil
+}
+
+// applyConfigFile sets every flag named in the config file that was not
+// given on the command line, so flags always win over the file. Options of
+// other commands are skipped, so one file can serve them all
+func applyConfigFile(fs *flag.FlagSet, path string, required bool) error {
+	options, err := loadConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, values := range options {
+		if !isOption(name) {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if fs.Lookup(name) == nil || set[name] {
+			continue
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", value)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", value)
+		return defaultValue
+	}
+	return d
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("ignoring invalid environment variable", "key", key, "value", value)
+		return defaultValue
+	}
+	return b
+}
+
+// runHook writes the files touched by the commit being made (pre-commit) or
+// just made (post-commit). The whole tree is parsed so calls into and out of
+// those files resolve, but backends that support it only rewrite the touched
+// files.
+func runHook(ctx context.Context, cfg Config, stage string) error {
+	files, err := touchedFiles(ctx, cfg.Path, stage, cfg.Filter)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		slog.Info("no Go files changed", "project", cfg.Project)
+		return nil
+	}
+
+	graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	if err != nil {
+		return withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+	graph.Features = cfg.Features
+	if cfg.Blame {
+		if err := codegraph.AddOwnership(ctx, graph, cfg.Path, ""); err != nil {
+			return fmt.Errorf("blaming %s: %w", cfg.Path, err)
+		}
+	}
+	if cfg.Churn != "" {
+		if err := codegraph.AddChurn(ctx, graph, cfg.Path, "", cfg.Churn); err != nil {
+			return fmt.Errorf("counting churn in %s: %w", cfg.Path, err)
+		}
+	}
+	if err := addCoverage(cfg, graph); err != nil {
+		return err
+	}
+	if err := addVulnerabilities(ctx, cfg, graph); err != nil {
+		return err
+	}
+	if err := enrichGraph(ctx, cfg, graph); err != nil {
+		return err
+	}
+
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+
+	run := codegraph.NewRunInfo()
+	run.Commit = codegraph.ResolveCommit(ctx, cfg.Path, "")
+	if err := codegraph.WriteFiles(ctx, backend, cfg.Project, graph, files, run); err != nil {
+		return err
+	}
+	result := graphSummary(cfg.Project, graph)
+	result.Run, result.Commit, result.WrittenFiles = run.ID, run.Commit, len(files)
+	summary.addProject(result)
+	slog.Info("indexed changed files", "project", cfg.Project, "files", len(files))
+	return nil
+}
+
+// touchedFiles lists the Go files the commit adds, changes or deletes,
+// relative to dir. Renames are reported as a deletion and an addition.
+func touchedFiles(ctx context.Context, dir, stage string, filter codegraph.Filter) ([]string, error) {
+	switch stage {
+	case "pre-commit":
+		return gitFiles(ctx, dir, filter, "diff", "--cached", "--name-only", "--relative", "--no-renames")
+	case "post-commit":
+		return gitFiles(ctx, dir, filter, "diff-tree", "-r", "--root", "--no-commit-id", "--name-only", "--relative", "--no-renames", "HEAD")
+	default:
+		return nil, fmt.Errorf("unknown hook %q, expected pre-commit or post-commit", stage)
+	}
+}
+
+// changedSince lists the Go files that differ between ref and the working
+// tree, including untracked ones, relative to dir
+func changedSince(ctx context.Context, dir, ref string, filter codegraph.Filter) ([]string, error) {
+	files, err := gitFiles(ctx, dir, filter, "diff", "--name-only", "--relative", "--no-renames", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitFiles(ctx, dir, filter, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	return append(files, untracked...), nil
+}
+
+// gitFiles runs a git command in dir that prints one path per line and
+// returns the Go source files among them that filter includes
+func gitFiles(ctx context.Context, dir string, filter codegraph.Filter, args ...string) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return nil, codegraph.GitError(args[0], err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); filter.Includes(line) {
+			files = append(files, filepath.FromSlash(line))
+		}
+	}
+	return files, nil
+}
+
+// watchDebounce is how long watch waits for further changes before writing
+const watchDebounce = 300 * time.Millisecond
+
+// watchCodebase keeps the backend in sync with cfg.Path until ctx is
+// cancelled. Changed files are re-parsed into graph; backends that support it
+// rewrite only those files, the others rewrite the whole graph.
+func watchCodebase(ctx context.Context, cfg Config, backend codegraph.Writer, graph *codegraph.Graph) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if _, err := watchDirs(watcher, cfg.Path, cfg.Path, cfg.Filter); err != nil {
+		return err
+	}
+	slog.Info("watching for changes, Ctrl-C to stop", "path", cfg.Path)
+
+	changed := make(map[string]bool)
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-watcher.Errors:
+			slog.Error("watching", "err", err)
+
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				// Files may have been written before the directory was watched
+				files, err := watchDirs(watcher, cfg.Path, event.Name, cfg.Filter)
+				if err != nil {
+					slog.Error("watching", "err", err)
+				}
+				for _, file := range files {
+					changed[file] = true
+				}
+			} else if relPath, err := filepath.Rel(cfg.Path, event.Name); err == nil && cfg.Filter.Includes(relPath) {
+				changed[event.Name] = true
+			} else {
+				continue
+			}
+			debounce.Reset(watchDebounce)
+
+		case <-debounce.C:
+			paths := make([]string, 0, len(changed))
+			for path := range changed {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			clear(changed)
+
+			if err := applyChanges(ctx, cfg, backend, graph, paths); err != nil {
+				slog.Error("updating graph", "err", describeCancel(ctx, err))
+			}
+		}
+	}
+}
+
+// watchDirs watches dir and every directory below it that parseCodebase
+// would visit from root, returning the source files found along the way
+func watchDirs(watcher *fsnotify.Watcher, root, dir string, filter codegraph.Filter) ([]string, error) {
+	if rel, err := filepath.Rel(root, dir); err != nil || filter.SkipDir(filepath.ToSlash(rel)) {
+		return nil, err
+	}
+	var files []string
+	err := filter.Walk(root, dir, func(path, relPath string, info fs.FileInfo) error {
+		if !info.IsDir() {
+			if filter.Includes(relPath) {
+				files = append(files, path)
+			}
+			return nil
+		}
+		return watcher.Add(path)
+	})
+	return files, err
+}
+
+// applyChanges re-parses the changed paths into graph and writes the result.
+// A file that no longer parses keeps its previous symbols until it does.
+func applyChanges(ctx context.Context, cfg Config, backend codegraph.Writer, graph *codegraph.Graph, paths []string) error {
+	start := time.Now()
+
+	var files []string
+	for _, path := range paths {
+		relPath, err := filepath.Rel(cfg.Path, path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			graph.RemoveFile(relPath)
+			files = append(files, relPath)
+			continue
+		}
+		fragment, err := codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter}.ParseFile(path, nil)
+		if err != nil {
+			slog.Warn("failed to parse", "file", path, "err", err)
+			continue
+		}
+		if err := enrichGraph(ctx, cfg, fragment); err != nil {
+			return err
+		}
+		if err := embedGraph(ctx, cfg, fragment); err != nil {
+			return err
+		}
+		graph.RemoveFile(relPath)
+		graph.AddFragment(fragment)
+		files = append(files, relPath)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	run := codegraph.NewRunInfo()
+	run.Commit = codegraph.ResolveCommit(ctx, cfg.Path, "")
+	if err := codegraph.WriteFiles(ctx, backend, cfg.Project, graph, files, run); err != nil {
+		return err
+	}
+	slog.Info("updated graph", "files", files, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// target is one project graph written by a run: a --path root, or a
+// subdirectory split off into its own project by --project-map
+type target struct {
+	Project string
+	Path    string
+	Filter  codegraph.Filter
+}
+
+// targets expands --path and --project-map into the project graphs to write.
+// A --path of the form NAME=DIR writes DIR as project NAME. Each
+// --project-map PATTERN[=TEMPLATE] turns the directories matching PATTERN
+// below every root into projects named by TEMPLATE, in which {name} is the
+// directory name ({name} by default); the root's own project leaves them out.
+func (cfg Config) targets() ([]target, error) {
+	paths := cfg.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var targets []target
+	seen := make(map[string]string)
+	add := func(t target) error {
+		if dir, ok := seen[t.Project]; ok {
+			return fmt.Errorf("%s and %s both map to project %s", dir, t.Path, t.Project)
+		}
+		seen[t.Project] = t.Path
+		targets = append(targets, t)
+		return nil
+	}
+
+	for _, spec := range paths {
+		root := target{Project: cfg.Project, Path: spec, Filter: cfg.Filter}
+		if name, dir, ok := strings.Cut(spec, "="); ok {
+			root.Project, root.Path = name, dir
+		}
+		if !codegraph.IdentPattern.MatchString(root.Project) {
+			return nil, fmt.Errorf("project %q is not a valid label", root.Project)
+		}
+
+		var subs []target
+		for _, mapping := range cfg.ProjectMap {
+			pattern, template, ok := strings.Cut(mapping, "=")
+			if !ok {
+				template = "{name}"
+			}
+			matches, err := filepath.Glob(filepath.Join(root.Path, filepath.FromSlash(pattern)))
+			if err != nil {
+				return nil, fmt.Errorf("--project-map %q: %w", mapping, err)
+			}
+			for _, dir := range matches {
+				if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+					continue
+				}
+				rel, err := filepath.Rel(root.Path, dir)
+				if err != nil {
+					return nil, err
+				}
+				// Anchor the exclusion so only this directory leaves the root
+				root.Filter.Exclude = append(slices.Clip(root.Filter.Exclude), "/"+filepath.ToSlash(rel))
+				subs = append(subs, target{
+					Project: projectLabel(strings.ReplaceAll(template, "{name}", filepath.Base(dir))),
+					Path:    dir,
+					Filter:  cfg.Filter,
+				})
+			}
+		}
+
+		if err := add(root); err != nil {
+			return nil, err
+		}
+		for _, sub := range subs {
+			if err := add(sub); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return targets, nil
+}
+
+// nonLabelPattern matches the characters a project label cannot contain
+var nonLabelPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// projectLabel turns a directory-derived name into a valid label by
+// replacing anything but letters, digits and underscores
+func projectLabel(name string) string {
+	label := nonLabelPattern.ReplaceAllString(name, "_")
+	if !codegraph.IdentPattern.MatchString(label) {
+		label = "_" + label
+	}
+	return label
+}
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// byteSize is a flag holding a number of bytes, given with an optional K,
+// M or G for KiB, MiB or GiB, e.g. 512K
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B"), "I")
+	shift := 0
+	if i := strings.IndexAny(number, "KMG"); i >= 0 && i == len(number)-1 {
+		shift = 10 * (strings.Index("KMG", number[i:]) + 1)
+		number = number[:i]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a size like 512K or 2M", value)
+	}
+	*b = byteSize(n << shift)
+	return nil
+}
+
+// progressInterval is how often progress is logged when it is not drawn as
+// a bar
+const progressInterval = 10 * time.Second
+
+// progressWidth is the width of the drawn progress bar in characters
+const progressWidth = 30
+
+// progressMode is set from --progress: "bar" redraws a bar on stderr, "log"
+// logs every progressInterval and "" reports nothing
+var progressMode string
+
+// stderr is where logs and the progress bar go. While a bar is shown, log
+// lines are written above it.
+var stderr = &statusLine{w: os.Stderr}
+
+// statusLine is a writer that keeps a status line below everything written
+// through it
+type statusLine struct {
+	mu     sync.Mutex
+	w      io.Writer
+	status string
+}
+
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == "" {
+		return s.w.Write(p)
+	}
+	io.WriteString(s.w, "\r\033[K")
+	n, err := s.w.Write(p)
+	io.WriteString(s.w, s.status)
+	return n, err
+}
+
+// setStatus replaces the status line, or clears it if status is empty
+func (s *statusLine) setStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.w, "\r\033[K"+status)
+	s.status = status
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress reports on one long step, such as parsing files or writing rows
+type progress struct {
+	name   string
+	unit   string
+	total  int
+	start  time.Time
+	done   atomic.Int64
+	stop   chan struct{}
+	exited chan struct{}
+}
+
+// activeProgress is the step being reported on, if any, for the gRPC
+// service to stream
+var activeProgress atomic.Pointer[progress]
+
+// startProgress begins reporting on a step of total units in the current
+// --progress mode. Finish must be called when the step ends.
+func startProgress(name, unit string, total int) *progress {
+	p := &progress{name: name, unit: unit, total: total, start: time.Now(), stop: make(chan struct{}), exited: make(chan struct{})}
+	activeProgress.Store(p)
+	if progressMode == "" || total == 0 {
+		close(p.exited)
+		return p
+	}
+	go p.report()
+	return p
+}
+
+// Add records n more units done; it is safe for concurrent use
+func (p *progress) Add(n int) {
+	p.done.Add(int64(n))
+}
+
+// Finish stops reporting and clears the bar
+func (p *progress) Finish() {
+	activeProgress.CompareAndSwap(p, nil)
+	close(p.stop)
+	<-p.exited
+}
+
+func (p *progress) report() {
+	defer close(p.exited)
+	interval := progressInterval
+	if progressMode == "bar" {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	drawn := false
+	for {
+		select {
+		case <-p.stop:
+			if drawn {
+				stderr.setStatus("")
+			}
+			return
+		case <-ticker.C:
+			done, elapsed := int(p.done.Load()), time.Since(p.start).Round(100*time.Millisecond)
+			var eta time.Duration
+			if done > 0 {
+				eta = (elapsed * time.Duration(p.total-done) / time.Duration(done)).Round(100 * time.Millisecond)
+			}
+			if progressMode == "log" {
+				slog.Info("progress", "step", p.name, p.unit, done, "total", p.total, "elapsed", elapsed, "eta", eta)
+				continue
+			}
+			filled := progressWidth * min(done, p.total) / p.total
+			drawn = true
+			stderr.setStatus(fmt.Sprintf("%-8s [%s%s] %d/%d %s  %s  ETA %s", p.name,
+				strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled), done, p.total, p.unit, elapsed, eta))
+		}
+	}
+}
+
+// printSummary writes the run's metrics as a table to w
+func printSummary(w io.Writer, m codegraph.RunMetrics) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tcount\ttime\tper sec\t")
+	fmt.Fprintf(tw, "files parsed\t%d\t%s\t%.0f\t\n", m.Files, m.ParseTime.Round(time.Millisecond), codegraph.PerSecond(m.Files, m.ParseTime))
+	fmt.Fprintf(tw, "nodes written\t%d\t%s\t%.0f\t\n", m.Nodes, m.WriteTime.Round(time.Millisecond), codegraph.PerSecond(m.Nodes, m.WriteTime))
+	fmt.Fprintf(tw, "relationships written\t%d\t%s\t%.0f\t\n", m.Relationships, m.WriteTime.Round(time.Millisecond), codegraph.PerSecond(m.Relationships, m.WriteTime))
+	if m.Statements > 0 {
+		fmt.Fprintf(tw, "statements\t%d\t\t\t\n", m.Statements)
+		fmt.Fprintf(tw, "retries\t%d\t\t\t\n", m.Retries)
+	}
+	if m.Batches > 0 {
+		fmt.Fprintf(tw, "batches (max %d rows)\t%d\t\t\t\n", m.MaxBatch, m.Batches)
+		if m.Shrinks > 0 {
+			fmt.Fprintf(tw, "batch size halved\t%d\t\t\t\n", m.Shrinks)
+		}
+	}
+	tw.Flush()
+}
+
+// newLogger returns a logger writing to w at the named level, as
+// key=value text or as JSON lines
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+// Exit codes tell CI wrappers why a run failed
+const (
+	exitFailure    = 1 // anything not listed below
+	exitUsage      = 2 // unknown command, bad flags, arguments or config
+	exitParse      = 3 // the source could not be parsed
+	exitConnection = 4 // the backend could not be reached or opened
+	exitValidation = 5 // the written graph failed validation
+	exitBreaking   = 6 // breaking found changes to the exported API
+	exitRules      = 7 // check found imports breaking a rule
+	exitUntested   = 8 // untested found changed functions no test reaches
+)
+
+// exitError carries the exit code an error warrants
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExit marks err as warranting code, unless it is nil
+func withExit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for err: the one it was marked with, or
+// exitConnection for errors reaching the database
+func exitCode(err error) int {
+	var exit *exitError
+	var connectivity *neo4j.ConnectivityError
+	var op *net.OpError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return exit.code
+	case errors.As(err, &connectivity), errors.As(err, &op):
+		return exitConnection
+	}
+	return exitFailure
+}
+
+// maxSummaryMessages bounds the errors and warnings a summary keeps, so a
+// long-running daemon does not accumulate them forever
+const maxSummaryMessages = 100
+
+// runSummary is printed on stdout as a single JSON line when a command
+// finishes with --output json
+type runSummary struct {
+	mu       sync.Mutex
+	Command  string           `json:"command"`
+	Status   string           `json:"status"` // ok or failed
+	ExitCode int              `json:"exitCode"`
+	Duration int64            `json:"durationMs"`
+	Projects []projectSummary `json:"projects"`
+	Errors   []string         `json:"errors"`
+	Warnings []string         `json:"warnings"`
+	start    time.Time
+	failed   int // files that failed to parse
+}
+
+// projectSummary counts what a command parsed, wrote or found for a project
+type projectSummary struct {
+	Project       string `json:"project"`
+	Run           string `json:"run,omitempty"`
+	Commit        string `json:"commit,omitempty"`
+	Files         int    `json:"files"`
+	Packages      int    `json:"packages"`
+	Functions     int    `json:"functions"`
+	Structs       int    `json:"structs"`
+	Interfaces    int    `json:"interfaces"`
+	Nodes         int    `json:"nodes"`
+	Relationships int    `json:"relationships"`
+	WrittenFiles  int    `json:"writtenFiles,omitempty"` // --since and hook write only these
+
+	ParseFailures []codegraph.ParseFailure `json:"parseFailures,omitempty"`
+}
+
+// summary collects what the running command did for --output json
+var summary = &runSummary{Projects: []projectSummary{}, Errors: []string{}, Warnings: []string{}, start: time.Now()}
+
+// graphSummary counts a parsed graph
+func graphSummary(project string, graph *codegraph.Graph) projectSummary {
+	return projectSummary{
+		Project:       project,
+		Files:         len(graph.Files),
+		Packages:      len(graph.Packages),
+		Functions:     len(graph.Functions),
+		Structs:       len(graph.Structs),
+		Interfaces:    len(graph.Interfaces),
+		Nodes:         len(graph.Nodes()),
+		Relationships: len(graph.Relationships()),
+		ParseFailures: graph.Failed,
+	}
+}
+
+func (s *runSummary) addProject(p projectSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Projects = append(s.Projects, p)
+}
+
+// addParseFailures counts files that failed to parse
+func (s *runSummary) addParseFailures(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed += n
+}
+
+// parseFailures returns how many files failed to parse
+func (s *runSummary) parseFailures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+func (s *runSummary) addMessage(level slog.Level, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case level >= slog.LevelError && len(s.Errors) < maxSummaryMessages:
+		s.Errors = append(s.Errors, msg)
+	case level >= slog.LevelWarn && level < slog.LevelError && len(s.Warnings) < maxSummaryMessages:
+		s.Warnings = append(s.Warnings, msg)
+	}
+}
+
+// write prints the summary of a run ending with code
+func (s *runSummary) write(w io.Writer, code int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Status, s.ExitCode = "ok", code
+	if code != 0 {
+		s.Status = "failed"
+	}
+	s.Duration = time.Since(s.start).Milliseconds()
+	return json.NewEncoder(w).Encode(s)
+}
+
+// summaryHandler copies warnings and errors into the summary as
+// "message key=value ..." before passing records on
+type summaryHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func (h summaryHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		var b strings.Builder
+		b.WriteString(r.Message)
+		add := func(a slog.Attr) bool {
+			fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+			return true
+		}
+		for _, a := range h.attrs {
+			add(a)
+		}
+		r.Attrs(add)
+		summary.addMessage(r.Level, b.String())
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h summaryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return summaryHandler{Handler: h.Handler.WithAttrs(attrs), attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h summaryHandler) WithGroup(name string) slog.Handler {
+	return summaryHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
+
+// logMetrics reports parse and write throughput for the run
+func logMetrics(log *slog.Logger, m codegraph.RunMetrics) {
+	attrs := []any{
+		"parse", m.ParseTime.Round(time.Millisecond),
+		"filesPerSec", math.Round(codegraph.PerSecond(m.Files, m.ParseTime)),
+		"write", m.WriteTime.Round(time.Millisecond),
+		"nodes", m.Nodes,
+		"nodesPerSec", math.Round(codegraph.PerSecond(m.Nodes, m.WriteTime)),
+		"relationships", m.Relationships,
+		"relationshipsPerSec", math.Round(codegraph.PerSecond(m.Relationships, m.WriteTime)),
+	}
+	if m.Statements > 0 {
+		attrs = append(attrs, "statements", m.Statements, "retries", m.Retries)
+	}
+	if m.Batches > 0 {
+		attrs = append(attrs, "batches", m.Batches,
+			"avgBatch", math.Round(float64(m.BatchRows)/float64(m.Batches)*10)/10, "maxBatch", m.MaxBatch)
+		if m.Shrinks > 0 {
+			attrs = append(attrs, "shrinks", m.Shrinks)
+		}
+	}
+	log.Info("metrics", attrs...)
+}
+
+// printQuery prints the results of a --query against the memory backend
+func printQuery(b *codegraph.MemoryWriter, kind, symbol string, depth int) error {
+	results, err := b.Traverse(kind, symbol, depth)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%s of %s: %d\n", kind, symbol, len(results))
+	for _, result := range results {
+		if kind == "impact" {
+			fmt.Printf("  [%d] %s\n", result.Depth, result.Key)
+		} else {
+			fmt.Printf("  %s\n", result.Key)
+		}
+	}
+	return nil
+}
+
+// exportGraph writes the graph in the format selected by export --format to
+// the --out destination
+func exportGraph(cfg Config, graph *codegraph.Graph, run codegraph.RunInfo) error {
+	// Formats that produce several files take --out as a directory
+	if cfg.ExportFormat == "csv" {
+		if cfg.Out == "-" {
+			return fmt.Errorf("csv export needs --out DIR")
+		}
+		return writeCSVExport(cfg.Out, cfg.Project, graph, run, cfg.Labels)
+	}
+	if cfg.ExportFormat == "parquet" {
+		if cfg.Out == "-" {
+			return fmt.Errorf("parquet export needs --out DIR")
+		}
+		return writeParquetExport(cfg.Out, cfg.Project, graph, run)
+	}
+
+	w := io.Writer(os.Stdout)
+	if cfg.Out != "-" {
+		f, err := os.Create(cfg.Out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch cfg.ExportFormat {
+	case "cypher":
+		return writeCypherScript(w, cfg.Project, codegraph.StreamStatements(context.Background(), cfg.Project, graph, run, cfg.statementOptions()), run, max(cfg.BatchSize, 1))
+	case "graphml":
+		return writeGraphML(w, cfg.Project, graph)
+	case "dot":
+		return writeDOT(w, cfg.Project, graph, cfg.DotView, cfg.DotPackage)
+	case "mermaid":
+		return writeMermaid(w, graph, cfg.MermaidView, cfg.MermaidPackage, cfg.MermaidType)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(codegraph.GraphExport{
+			Project:       cfg.Project,
+			RunID:         run.ID,
+			GeneratedAt:   run.StartedAt,
+			Graph:         graph,
+			Relationships: graph.Relationships(),
+		})
+	default:
+		return fmt.Errorf("unknown output format %q", cfg.ExportFormat)
+	}
+}
+
+// writeCypherScript writes the statements as a script runnable with
+// cypher-shell, inlining parameters as literals
+func writeCypherScript(w io.Writer, project string, stmts <-chan codegraph.Statement, run codegraph.RunInfo, batchSize int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// Code graph for %s\n", project)
+	fmt.Fprintf(bw, "// Run %s generated %s\n", run.ID, run.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(bw, "// Load with: cypher-shell -f <this file>\n")
+
+	phase := ""
+	for stmt := range stmts {
+		if stmt.Phase != phase {
+			phase = stmt.Phase
+			fmt.Fprintf(bw, "\n// %s\n", phase)
+		}
+		for _, batch := range stmt.Batches(batchSize) {
+			fmt.Fprintf(bw, "%s;\n", inlineParams(batch.Query, batch.Params))
+		}
+	}
+	return bw.Flush()
+}
+
+var paramPattern = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// inlineParams substitutes $name references with Cypher literals and strips
+// the indentation the query has in source
+func inlineParams(query string, params map[string]any) string {
+	query = paramPattern.ReplaceAllStringFunc(query, func(ref string) string {
+		value, ok := params[ref[1:]]
+		if !ok {
+			return ref
+		}
+		return codegraph.CypherLiteral(value)
+	})
+	return codegraph.TrimQuery(query)
+}
+
+// writeCSVExport writes the graph as neo4j-admin import files: one node
+// file per kind and a single relationships file, plus an import.sh with the
+// matching neo4j-admin invocation
+func writeCSVExport(dir, project string, graph *codegraph.Graph, run codegraph.RunInfo, labelMap codegraph.LabelMap) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	stamp := []string{run.StartedAt.Format(time.RFC3339Nano), run.StartedAt.Format(time.RFC3339Nano), run.ID}
+	stampHeader := []string{"createdAt:datetime", "updatedAt:datetime", "runId"}
+	labels := func(label string) string { return project + ";" + labelMap.Label(label) }
+	list := func(values []string) string { return strings.Join(values, ";") }
+
+	var rows [][]string
+	for _, pkg := range graph.Packages {
+		rows = append(rows, append([]string{pkg.Key(), pkg.Name, pkg.Path}, append(stamp, labels("Package"))...))
+	}
+	if err := writeCSVFile(filepath.Join(dir, "packages.csv"),
+		append([]string{":ID", "name", "path"}, append(stampHeader, ":LABEL")...), rows); err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, file := range graph.Files {
+		rows = append(rows, append([]string{file.Key(), file.Path, file.Package, file.Language, list(file.Imports)},
+			append(stamp, labels("File"))...))
+	}
+	if err := writeCSVFile(filepath.Join(dir, "files.csv"),
+		append([]string{":ID", "path", "package", "language", "imports:string[]"}, append(stampHeader, ":LABEL")...), rows); err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, fn := range graph.Functions {
+		label := "Function"
+		if fn.Receiver != "" {
+			label = "Method"
+		}
+		rows = append(rows, append([]string{
+			fn.Key(), fn.Name, fn.File, fn.Signature, fn.Receiver,
+			strconv.FormatBool(fn.IsExport), strconv.Itoa(fn.LineStart), strconv.Itoa(fn.LineEnd),
+		}, append(stamp, labels(label))...))
+	}
+	if err := writeCSVFile(filepath.Join(dir, "functions.csv"),
+		append([]string{":ID", "name", "file", "signature", "receiver", "isExport:boolean", "lineStart:int", "lineEnd:int"},
+			append(stampHeader, ":LABEL")...), rows); err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, st := range graph.Structs {
+		rows = append(rows, append([]string{st.Key(), st.Name, st.File, list(st.Fields), strconv.FormatBool(st.IsExport)},
+			append(stamp, labels("Struct"))...))
+	}
+	if err := writeCSVFile(filepath.Join(dir, "structs.csv"),
+		append([]string{":ID", "name", "file", "fields:string[]", "isExport:boolean"}, append(stampHeader, ":LABEL")...), rows); err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, iface := range graph.Interfaces {
+		rows = append(rows, append([]string{iface.Key(), iface.Name, iface.File, list(iface.Methods), strconv.FormatBool(iface.IsExport)},
+			append(stamp, labels("Interface"))...))
+	}
+	if err := writeCSVFile(filepath.Join(dir, "interfaces.csv"),
+		append([]string{":ID", "name", "file", "methods:string[]", "isExport:boolean"}, append(stampHeader, ":LABEL")...), rows); err != nil {
+		return err
+	}
+
+	rows = nil
+	for _, rel := range graph.Relationships() {
+		rows = append(rows, append([]string{rel.From, rel.To, rel.Type}, stamp...))
+	}
+	if err := writeCSVFile(filepath.Join(dir, "relationships.csv"),
+		append([]string{":START_ID", ":END_ID", ":TYPE"}, stampHeader...), rows); err != nil {
+		return err
+	}
+
+	script := `#!/bin/sh
+# Offline bulk load of the code graph. Stop the database first; the target
+# database must not exist yet.
+cd "$(dirname "$0")"
+neo4j-admin database import full "${1:-neo4j}" \
+    --nodes=packages.csv --nodes=files.csv --nodes=functions.csv \
+    --nodes=structs.csv --nodes=interfaces.csv \
+    --relationships=relationships.csv \
+    --skip-duplicate-nodes=true
+`
+	return os.WriteFile(filepath.Join(dir, "import.sh"), []byte(script), 0o755)
+}
+
+func writeCSVFile(path string, header []string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// parquetNode is one row of nodes.parquet. Columns that do not apply to a
+// node's label are left empty; items holds a File's imports, a Struct's
+// fields or an Interface's methods.
+type parquetNode struct {
+	Project   string    `parquet:"project,dict"`
+	ID        string    `parquet:"id"`
+	Label     string    `parquet:"label,dict"`
+	Name      string    `parquet:"name"`
+	Path      string    `parquet:"path"`
+	File      string    `parquet:"file,dict"`
+	Package   string    `parquet:"package,dict"`
+	Language  string    `parquet:"language,dict"`
+	Signature string    `parquet:"signature"`
+	Receiver  string    `parquet:"receiver,dict"`
+	IsExport  bool      `parquet:"is_export"`
+	LineStart int32     `parquet:"line_start"`
+	LineEnd   int32     `parquet:"line_end"`
+	Items     []string  `parquet:"items,list"`
+	RunID     string    `parquet:"run_id,dict"`
+	CreatedAt time.Time `parquet:"created_at,timestamp"`
+}
+
+// parquetEdge is one row of edges.parquet
+type parquetEdge struct {
+	Project   string    `parquet:"project,dict"`
+	Type      string    `parquet:"type,dict"`
+	Source    string    `parquet:"source"`
+	Target    string    `parquet:"target"`
+	RunID     string    `parquet:"run_id,dict"`
+	CreatedAt time.Time `parquet:"created_at,timestamp"`
+}
+
+// writeParquetExport writes nodes.parquet and edges.parquet for loading into
+// DuckDB, Spark or other analytics tools, keyed by the same node keys as the
+// other exports
+func writeParquetExport(dir, project string, graph *codegraph.Graph, run codegraph.RunInfo) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	var nodes []parquetNode
+	for _, pkg := range graph.Packages {
+		nodes = append(nodes, parquetNode{ID: pkg.Key(), Label: "Package", Name: pkg.Name, Path: pkg.Path})
+	}
+	for _, file := range graph.Files {
+		nodes = append(nodes, parquetNode{ID: file.Key(), Label: "File", Path: file.Path, File: file.Path,
+			Package: file.Package, Language: file.Language, Items: file.Imports})
+	}
+	for _, fn := range graph.Functions {
+		label := "Function"
+		if fn.Receiver != "" {
+			label = "Method"
+		}
+		nodes = append(nodes, parquetNode{ID: fn.Key(), Label: label, Name: fn.Name, File: fn.File,
+			Signature: fn.Signature, Receiver: fn.Receiver, IsExport: fn.IsExport,
+			LineStart: int32(fn.LineStart), LineEnd: int32(fn.LineEnd)})
+	}
+	for _, st := range graph.Structs {
+		nodes = append(nodes, parquetNode{ID: st.Key(), Label: "Struct", Name: st.Name, File: st.File,
+			IsExport: st.IsExport, Items: st.Fields})
+	}
+	for _, iface := range graph.Interfaces {
+		nodes = append(nodes, parquetNode{ID: iface.Key(), Label: "Interface", Name: iface.Name, File: iface.File,
+			IsExport: iface.IsExport, Items: iface.Methods})
+	}
+	for i := range nodes {
+		nodes[i].Project, nodes[i].RunID, nodes[i].CreatedAt = project, run.ID, run.StartedAt
+	}
+	if err := parquet.WriteFile(filepath.Join(dir, "nodes.parquet"), nodes); err != nil {
+		return fmt.Errorf("writing nodes: %w", err)
+	}
+
+	var edges []parquetEdge
+	for _, rel := range graph.Relationships() {
+		edges = append(edges, parquetEdge{Project: project, Type: rel.Type, Source: rel.From, Target: rel.To,
+			RunID: run.ID, CreatedAt: run.StartedAt})
+	}
+	if err := parquet.WriteFile(filepath.Join(dir, "edges.parquet"), edges); err != nil {
+		return fmt.Errorf("writing edges: %w", err)
+	}
+	return nil
+}
+
+// writeGraphML writes the graph as GraphML. Every node property becomes a
+// declared attribute; list properties are joined with ";".
+func writeGraphML(w io.Writer, project string, graph *codegraph.Graph) error {
+	nodes := graph.Nodes()
+
+	// Declare an attribute key for every property in use
+	attrTypes := map[string]string{}
+	for _, node := range nodes {
+		for name, value := range node.Props {
+			attrTypes[name] = graphMLType(value)
+		}
+	}
+	attrs := make([]string, 0, len(attrTypes))
+	for name := range attrTypes {
+		attrs = append(attrs, name)
+	}
+	sort.Strings(attrs)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(bw, `  <key id="label" for="node" attr.name="label" attr.type="string"/>`)
+	fmt.Fprintln(bw, `  <key id="kind" for="node" attr.name="kind" attr.type="string"/>`)
+	for _, name := range attrs {
+		fmt.Fprintf(bw, "  <key id=%q for=\"node\" attr.name=%q attr.type=%q/>\n", name, name, attrTypes[name])
+	}
+	fmt.Fprintln(bw, `  <key id="type" for="edge" attr.name="type" attr.type="string"/>`)
+	fmt.Fprintf(bw, "  <graph id=%s edgedefault=\"directed\">\n", xmlAttr(project))
+
+	for _, node := range nodes {
+		display := codegraph.PropString(node.Props, "name")
+		if display == "" {
+			display = codegraph.PropString(node.Props, "path")
+		}
+		fmt.Fprintf(bw, "    <node id=%s>\n", xmlAttr(node.Key))
+		fmt.Fprintf(bw, "      <data key=\"label\">%s</data>\n", xmlText(display))
+		fmt.Fprintf(bw, "      <data key=\"kind\">%s</data>\n", xmlText(node.Label))
+		for _, name := range attrs {
+			if value, ok := node.Props[name]; ok {
+				fmt.Fprintf(bw, "      <data key=%q>%s</data>\n", name, xmlText(graphMLValue(value)))
+			}
+		}
+		fmt.Fprintln(bw, "    </node>")
+	}
+
+	for i, rel := range graph.Relationships() {
+		fmt.Fprintf(bw, "    <edge id=\"e%d\" source=%s target=%s>\n", i, xmlAttr(rel.From), xmlAttr(rel.To))
+		fmt.Fprintf(bw, "      <data key=\"type\">%s</data>\n", xmlText(rel.Type))
+		fmt.Fprintln(bw, "    </edge>")
+	}
+
+	fmt.Fprintln(bw, "  </graph>")
+	fmt.Fprintln(bw, "</graphml>")
+	return bw.Flush()
+}
+
+func graphMLType(value any) string {
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case int:
+		return "int"
+	default:
+		return "string"
+	}
+}
+
+func graphMLValue(value any) string {
+	if list, ok := value.([]string); ok {
+		return strings.Join(list, ";")
+	}
+	return fmt.Sprint(value)
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmlAttr(s string) string {
+	return `"` + xmlText(s) + `"`
+}
+
+// readGraphJSON loads the graph from an export --format json.
+// Relationships are derived again from the nodes, which are sorted as a
+// parsed graph's are.
+func readGraphJSON(path string) (*codegraph.Graph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	export := codegraph.GraphExport{Graph: &codegraph.Graph{}}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	export.Graph.Sort()
+	return export.Graph, nil
+}
+
+// writeDOT renders a subgraph as Graphviz DOT. The imports view draws
+// package-to-package dependencies; the structure view draws files and the
+// symbols they contain, clustered by package. pkgFilter keeps packages whose
+// path equals or lies under it (plus, for imports, their direct neighbours).
+func writeDOT(w io.Writer, project string, graph *codegraph.Graph, view, pkgFilter string) error {
+	inScope := func(pkgPath string) bool {
+		return pkgFilter == "" || pkgPath == pkgFilter || strings.HasPrefix(pkgPath, pkgFilter+"/")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", dotID(project))
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, "  node [shape=box, fontname=\"Helvetica\"];")
+
+	switch view {
+	case "imports":
+		var edges []codegraph.Relationship
+		used := make(map[string]bool)
+		for _, rel := range graph.PackageDependencies() {
+			if !inScope(strings.TrimPrefix(rel.From, "Package:")) && !inScope(strings.TrimPrefix(rel.To, "Package:")) {
+				continue
+			}
+			edges = append(edges, rel)
+			used[rel.From], used[rel.To] = true, true
+		}
+		for _, pkg := range graph.Packages {
+			if inScope(pkg.Path) {
+				used[pkg.Key()] = true
+			}
+		}
+
+		for _, pkg := range graph.Packages {
+			if used[pkg.Key()] {
+				fmt.Fprintf(bw, "  %s [label=%s];\n", dotID(pkg.Key()), dotID(pkg.Name+"\n"+pkg.Path))
+			}
+		}
+		for _, edge := range edges {
+			fmt.Fprintf(bw, "  %s -> %s;\n", dotID(edge.From), dotID(edge.To))
+		}
+
+	case "structure":
+		byPkg := make(map[string][]codegraph.FileNode)
+		for _, file := range graph.Files {
+			pkgPath := filepath.Dir(file.Path)
+			if inScope(pkgPath) {
+				byPkg[pkgPath] = append(byPkg[pkgPath], file)
+			}
+		}
+
+		for i, pkg := range graph.Packages {
+			files := byPkg[pkg.Path]
+			if len(files) == 0 {
+				continue
+			}
+			fmt.Fprintf(bw, "  subgraph cluster_%d {\n", i)
+			fmt.Fprintf(bw, "    label=%s;\n", dotID(pkg.Path))
+			for _, file := range files {
+				fmt.Fprintf(bw, "    %s [label=%s, shape=folder];\n", dotID(file.Key()), dotID(filepath.Base(file.Path)))
+			}
+			fmt.Fprintln(bw, "  }")
+		}
+
+		inFile := func(file string) bool { return inScope(filepath.Dir(file)) }
+		for _, st := range graph.Structs {
+			if inFile(st.File) {
+				fmt.Fprintf(bw, "  %s [label=%s, shape=record];\n", dotID(st.Key()), dotID(st.Name))
+			}
+		}
+		for _, iface := range graph.Interfaces {
+			if inFile(iface.File) {
+				fmt.Fprintf(bw, "  %s [label=%s, shape=component];\n", dotID(iface.Key()), dotID(iface.Name))
+			}
+		}
+		for _, fn := range graph.Functions {
+			if inFile(fn.File) {
+				label := fn.Name
+				if fn.Receiver != "" {
+					label = fn.Receiver + "." + fn.Name
+				}
+				fmt.Fprintf(bw, "  %s [label=%s, shape=ellipse];\n", dotID(fn.Key()), dotID(label))
+			}
+		}
+		for _, rel := range graph.Relationships() {
+			if rel.Type == "CONTAINS" && inScope(filepath.Dir(strings.TrimPrefix(rel.From, "File:"))) {
+				fmt.Fprintf(bw, "  %s -> %s;\n", dotID(rel.From), dotID(rel.To))
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown dot view %q (want imports or structure)", view)
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotID quotes a string as a DOT identifier
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// writeMermaid renders a Mermaid diagram ready to paste into Markdown. The
+// class view shows the selected types with their members, the interfaces
+// they implement (or the structs implementing them) and the types their
+// fields depend on. The flowchart view shows package dependencies around
+// pkgFilter.
+func writeMermaid(w io.Writer, graph *codegraph.Graph, view, pkgFilter, typeName string) error {
+	bw := bufio.NewWriter(w)
+
+	switch view {
+	case "flowchart":
+		fmt.Fprintln(bw, "flowchart LR")
+		names := make(map[string]string)
+		for _, pkg := range graph.Packages {
+			names[pkg.Key()] = pkg.Name
+		}
+		for _, dep := range graph.PackageDependencies() {
+			from, to := strings.TrimPrefix(dep.From, "Package:"), strings.TrimPrefix(dep.To, "Package:")
+			if pkgFilter != "" && from != pkgFilter && to != pkgFilter {
+				continue
+			}
+			fmt.Fprintf(bw, "    %s[%q] --> %s[%q]\n", mermaidID(dep.From), names[dep.From], mermaidID(dep.To), names[dep.To])
+		}
+
+	case "class":
+		if pkgFilter == "" && typeName == "" {
+			return fmt.Errorf("mermaid class diagram needs --mermaid-package or --mermaid-type")
+		}
+		writeMermaidClasses(bw, graph, pkgFilter, typeName)
+
+	default:
+		return fmt.Errorf("unknown mermaid view %q (want class or flowchart)", view)
+	}
+
+	return bw.Flush()
+}
+
+func writeMermaidClasses(w io.Writer, graph *codegraph.Graph, pkgFilter, typeName string) {
+	selected := func(name, file string) bool {
+		return (typeName == "" || name == typeName) && (pkgFilter == "" || filepath.Dir(file) == pkgFilter)
+	}
+
+	pkgNames := make(map[string]string)
+	for _, file := range graph.Files {
+		pkgNames[file.Path] = file.Package
+	}
+
+	// Index every type so field types and implementations can be resolved
+	type typeRef struct {
+		key, name, file string
+	}
+	var types []typeRef
+	for _, st := range graph.Structs {
+		types = append(types, typeRef{st.Key(), st.Name, st.File})
+	}
+	for _, iface := range graph.Interfaces {
+		types = append(types, typeRef{iface.Key(), iface.Name, iface.File})
+	}
+	typeNames := make(map[string]string)
+	for _, t := range types {
+		typeNames[t.key] = t.name
+	}
+	resolve := func(fromFile, fieldType string) string {
+		qualifier, name := baseTypeName(fieldType)
+		for _, t := range types {
+			if t.name != name {
+				continue
+			}
+			if qualifier == "" && filepath.Dir(t.file) == filepath.Dir(fromFile) {
+				return t.key
+			}
+			if qualifier != "" && pkgNames[t.file] == qualifier {
+				return t.key
+			}
+		}
+		return ""
+	}
+
+	focus := make(map[string]bool)
+	related := make(map[string]string) // key -> display name
+	var edges []string
+
+	fmt.Fprintln(w, "classDiagram")
+	for _, st := range graph.Structs {
+		if !selected(st.Name, st.File) {
+			continue
+		}
+		id := mermaidID(st.Key())
+		focus[st.Key()] = true
+		fmt.Fprintf(w, "    class %s[%q]\n", id, st.Name)
+		for _, field := range st.Fields {
+			fmt.Fprintf(w, "    %s : %s\n", id, mermaidMember(field))
+
+			fieldType := field
+			if i := strings.Index(field, " "); i >= 0 {
+				fieldType = field[i+1:]
+			}
+			if target := resolve(st.File, fieldType); target != "" && target != st.Key() {
+				related[target] = typeNames[target]
+				edges = append(edges, fmt.Sprintf("%s --> %s", id, mermaidID(target)))
+			}
+		}
+		for _, fn := range graph.MethodsOf(filepath.Dir(st.File), st.Name) {
+			fmt.Fprintf(w, "    %s : %s\n", id, mermaidMember(strings.TrimPrefix(fn.Signature, "func ("+fn.Receiver+") ")))
+		}
+	}
+	for _, iface := range graph.Interfaces {
+		if !selected(iface.Name, iface.File) {
+			continue
+		}
+		id := mermaidID(iface.Key())
+		focus[iface.Key()] = true
+		fmt.Fprintf(w, "    class %s[%q]\n", id, iface.Name)
+		fmt.Fprintf(w, "    <<interface>> %s\n", id)
+		for _, method := range iface.Methods {
+			fmt.Fprintf(w, "    %s : %s\n", id, mermaidMember(method))
+		}
+	}
+
+	for _, rel := range graph.Implementations() {
+		if !focus[rel.From] && !focus[rel.To] {
+			continue
+		}
+		related[rel.From], related[rel.To] = typeNames[rel.From], typeNames[rel.To]
+		edges = append(edges, fmt.Sprintf("%s <|.. %s", mermaidID(rel.To), mermaidID(rel.From)))
+	}
+
+	relatedKeys := make([]string, 0, len(related))
+	for key := range related {
+		if !focus[key] {
+			relatedKeys = append(relatedKeys, key)
+		}
+	}
+	sort.Strings(relatedKeys)
+	for _, key := range relatedKeys {
+		fmt.Fprintf(w, "    class %s[%q]\n", mermaidID(key), related[key])
+		if strings.HasPrefix(key, "Interface:") {
+			fmt.Fprintf(w, "    <<interface>> %s\n", mermaidID(key))
+		}
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(w, "    %s\n", edge)
+	}
+}
+
+// baseTypeName reduces a type expression such as *[]pkg.Name or
+// map[string]Name to its element type's package qualifier and name
+func baseTypeName(expr string) (qualifier, name string) {
+	for {
+		switch {
+		case strings.HasPrefix(expr, "*"):
+			expr = expr[1:]
+		case strings.HasPrefix(expr, "[]"):
+			expr = expr[2:]
+		case strings.HasPrefix(expr, "map["):
+			expr = expr[strings.Index(expr, "]")+1:]
+		default:
+			if i := strings.LastIndex(expr, "."); i >= 0 {
+				return expr[:i], expr[i+1:]
+			}
+			return "", expr
+		}
+	}
+}
+
+// mermaidMember formats a field or method for a class body, marking
+// unexported members private and removing braces Mermaid cannot parse
+func mermaidMember(member string) string {
+	member = strings.ReplaceAll(member, "interface{}", "any")
+	member = strings.NewReplacer("{", "", "}", "").Replace(member)
+	if ast.IsExported(member) {
+		return "+" + member
+	}
+	return "-" + member
+}
+
+// mermaidID turns a node key into an identifier Mermaid accepts
+func mermaidID(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// graphSnapshot is a comparable view of a code graph: every symbol key maps
+// to a fingerprint of its content, and relationships are keyed by endpoints
+type graphSnapshot struct {
+	Functions     map[string]string
+	Structs       map[string]string
+	Interfaces    map[string]string
+	Relationships map[string]string
+}
+
+// SymbolDiff lists the keys added, removed and changed between two snapshots
+type SymbolDiff struct {
+	Added   []string        `json:"added"`
+	Removed []string        `json:"removed"`
+	Changed []ChangedSymbol `json:"changed"`
+}
+
+// ChangedSymbol is a symbol present in both snapshots with differing content
+type ChangedSymbol struct {
+	Key    string `json:"key"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// GraphDiff is the structured result of the diff command
+type GraphDiff struct {
+	Functions     SymbolDiff `json:"functions"`
+	Structs       SymbolDiff `json:"structs"`
+	Interfaces    SymbolDiff `json:"interfaces"`
+	Relationships SymbolDiff `json:"relationships"`
+}
+
+func runDiff(ctx context.Context, cfg Config) error {
+	diff, err := computeDiff(ctx, cfg, nil)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(diff)
+}
+
+// computeDiff compares the tree at cfg.Path with cfg.Base, or with what is
+// stored for cfg.Project if there is no base, read through driver or a new
+// driver if it is nil
+func computeDiff(ctx context.Context, cfg Config, driver neo4j.DriverWithContext) (GraphDiff, error) {
+	graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	if err != nil {
+		return GraphDiff{}, withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+	graph.Features = cfg.Features
+	after := snapshotFromGraph(graph)
+
+	var before graphSnapshot
+	if strings.HasSuffix(cfg.Base, ".json") {
+		baseGraph, err := readGraphJSON(cfg.Base)
+		if err != nil {
+			return GraphDiff{}, fmt.Errorf("reading %s: %w", cfg.Base, err)
+		}
+		before = snapshotFromGraph(baseGraph)
+	} else if cfg.Base != "" {
+		baseGraph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Base, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+		if err != nil {
+			return GraphDiff{}, withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Base, err))
+		}
+		baseGraph.Features = cfg.Features
+		before = snapshotFromGraph(baseGraph)
+	} else {
+		if driver == nil {
+			if driver, err = newNeo4jDriver(cfg); err != nil {
+				return GraphDiff{}, withExit(exitConnection, fmt.Errorf("connecting to Neo4j: %w", err))
+			}
+			defer driver.Close(ctx)
+		}
+		if before, err = loadSnapshot(ctx, driver, cfg.Project, cfg.Labels); err != nil {
+			return GraphDiff{}, err
+		}
+	}
+	return diffSnapshots(before, after), nil
+}
+
+func snapshotFromGraph(graph *codegraph.Graph) graphSnapshot {
+	snap := newSnapshot()
+	for _, fn := range graph.Functions {
+		snap.Functions[fn.Key()] = fn.Signature
+	}
+	for _, st := range graph.Structs {
+		snap.Structs[st.Key()] = strings.Join(st.Fields, "; ")
+	}
+	for _, iface := range graph.Interfaces {
+		snap.Interfaces[iface.Key()] = strings.Join(iface.Methods, "; ")
+	}
+	for _, rel := range graph.Relationships() {
+		snap.Relationships[rel.Key()] = ""
+	}
+	return snap
+}
+
+func newSnapshot() graphSnapshot {
+	return graphSnapshot{
+		Functions:     make(map[string]string),
+		Structs:       make(map[string]string),
+		Interfaces:    make(map[string]string),
+		Relationships: make(map[string]string),
+	}
+}
+
+// loadSnapshot reads the project's code nodes and relationships back from the
+// database into the same shape produced by snapshotFromGraph
+func loadSnapshot(ctx context.Context, driver neo4j.DriverWithContext, project string, labels codegraph.LabelMap) (graphSnapshot, error) {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	snap := newSnapshot()
+
+	result, err := session.Run(ctx, labels.Rewrite(fmt.Sprintf(`
+		MATCH (n:%s) WHERE (n:Function OR n:Method) AND NOT coalesce(n.deleted, false)
+		RETURN n.name AS name, n.file AS file, n.receiver AS receiver, n.signature AS signature
+	`, project)), nil)
+	if err != nil {
+		return snap, fmt.Errorf("reading functions: %w", err)
+	}
+	for result.Next(ctx) {
+		props := result.Record().AsMap()
+		fn := codegraph.FunctionNode{Name: codegraph.PropString(props, "name"), File: codegraph.PropString(props, "file"), Receiver: codegraph.PropString(props, "receiver")}
+		snap.Functions[fn.Key()] = codegraph.PropString(props, "signature")
+	}
+	if err := result.Err(); err != nil {
+		return snap, fmt.Errorf("reading functions: %w", err)
+	}
+
+	result, err = session.Run(ctx, labels.Rewrite(fmt.Sprintf(`
+		MATCH (n:%s:Struct) WHERE NOT coalesce(n.deleted, false)
+		RETURN n.name AS name, n.file AS file, n.fields AS fields
+	`, project)), nil)
+	if err != nil {
+		return snap, fmt.Errorf("reading structs: %w", err)
+	}
+	for result.Next(ctx) {
+		props := result.Record().AsMap()
+		st := codegraph.StructNode{Name: codegraph.PropString(props, "name"), File: codegraph.PropString(props, "file")}
+		snap.Structs[st.Key()] = strings.Join(codegraph.PropStrings(props, "fields"), "; ")
+	}
+	if err := result.Err(); err != nil {
+		return snap, fmt.Errorf("reading structs: %w", err)
+	}
+
+	result, err = session.Run(ctx, labels.Rewrite(fmt.Sprintf(`
+		MATCH (n:%s:Interface) WHERE NOT coalesce(n.deleted, false)
+		RETURN n.name AS name, n.file AS file, n.methods AS methods
+	`, project)), nil)
+	if err != nil {
+		return snap, fmt.Errorf("reading interfaces: %w", err)
+	}
+	for result.Next(ctx) {
+		props := result.Record().AsMap()
+		iface := codegraph.InterfaceNode{Name: codegraph.PropString(props, "name"), File: codegraph.PropString(props, "file")}
+		snap.Interfaces[iface.Key()] = strings.Join(codegraph.PropStrings(props, "methods"), "; ")
+	}
+	if err := result.Err(); err != nil {
+		return snap, fmt.Errorf("reading interfaces: %w", err)
+	}
+
+	result, err = session.Run(ctx, fmt.Sprintf(`
+		MATCH (a:%s)-[r]->(b:%s) WHERE NOT coalesce(r.deleted, false)
+		RETURN type(r) AS type, labels(a) AS fromLabels, properties(a) AS fromProps,
+		       labels(b) AS toLabels, properties(b) AS toProps
+	`, project, project), nil)
+	if err != nil {
+		return snap, fmt.Errorf("reading relationships: %w", err)
+	}
+	for result.Next(ctx) {
+		record := result.Record().AsMap()
+		from := nodeKeyFromProps(labels, record["fromLabels"], record["fromProps"])
+		to := nodeKeyFromProps(labels, record["toLabels"], record["toProps"])
+		if from == "" || to == "" {
+			// Not a code node (e.g. a memory attached to code)
+			continue
+		}
+		rel := codegraph.Relationship{Type: codegraph.PropString(record, "type"), From: from, To: to}
+		snap.Relationships[rel.Key()] = ""
+	}
+	if err := result.Err(); err != nil {
+		return snap, fmt.Errorf("reading relationships: %w", err)
+	}
+
+	return snap, nil
+}
+
+// nodeKeyFromProps rebuilds a node key from the labels and properties
+// returned by the database, or "" if the node is not a code node
+func nodeKeyFromProps(labelMap codegraph.LabelMap, labels, props any) string {
+	labelList, _ := labels.([]any)
+	propMap, _ := props.(map[string]any)
+	for _, label := range labelList {
+		name, _ := label.(string)
+		switch labelMap.Kind(name) {
+		case "Package":
+			return codegraph.PackageNode{Path: codegraph.PropString(propMap, "path")}.Key()
+		case "File":
+			return codegraph.FileNode{Path: codegraph.PropString(propMap, "path")}.Key()
+		case "Function", "Method":
+			return codegraph.FunctionNode{
+				Name:     codegraph.PropString(propMap, "name"),
+				File:     codegraph.PropString(propMap, "file"),
+				Receiver: codegraph.PropString(propMap, "receiver"),
+			}.Key()
+		case "Struct":
+			return codegraph.StructNode{Name: codegraph.PropString(propMap, "name"), File: codegraph.PropString(propMap, "file")}.Key()
+		case "Interface":
+			return codegraph.InterfaceNode{Name: codegraph.PropString(propMap, "name"), File: codegraph.PropString(propMap, "file")}.Key()
+		}
+	}
+	return ""
+}
+
+func diffSnapshots(before, after graphSnapshot) GraphDiff {
+	return GraphDiff{
+		Functions:     diffMaps(before.Functions, after.Functions),
+		Structs:       diffMaps(before.Structs, after.Structs),
+		Interfaces:    diffMaps(before.Interfaces, after.Interfaces),
+		Relationships: diffMaps(before.Relationships, after.Relationships),
+	}
+}
+
+func diffMaps(before, after map[string]string) SymbolDiff {
+	diff := SymbolDiff{
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []ChangedSymbol{},
+	}
+
+	for key, value := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case old != value:
+			diff.Changed = append(diff.Changed, ChangedSymbol{Key: key, Before: old, After: value})
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Key < diff.Changed[j].Key
+	})
+	return diff
+}
+
+// runQuery runs a Cypher query given as arg, read from --file, or read from
+// stdin when neither is given (or arg is -), against the configured backend
+// and prints the rows as a table, JSON or CSV. An arg naming one of the
+// namedQueries runs that query for --project instead.
+func runQuery(ctx context.Context, cfg Config, arg string) error {
+	params, err := parseParams(cfg.Params)
+	if err != nil {
+		return err
+	}
+	var query string
+	named, isNamed := namedQueries[arg]
+	if isNamed {
+		if named.NameRequired && cfg.Name == "" {
+			return fmt.Errorf("query %s needs --name", arg)
+		}
+		if cfg.Backend != "neo4j" && cfg.Backend != "falkordb" {
+			return fmt.Errorf("query %s needs --backend neo4j or falkordb", arg)
+		}
+		query = named.text(cfg)
+		params["name"] = cfg.Name
+	} else if query, err = readQuery(arg, cfg.QueryFile); err != nil {
+		return err
+	}
+
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	q, ok := backend.(codegraph.Querier)
+	if !ok {
+		return fmt.Errorf("backend %s cannot run Cypher, use neo4j, falkordb or kuzu", cfg.Backend)
+	}
+
+	start := time.Now()
+	columns, rows, err := q.Query(ctx, query, params)
+	if err != nil {
+		return err
+	}
+	slog.Debug("query finished", "rows", len(rows), "took", time.Since(start).Round(time.Millisecond))
+	return printRows(os.Stdout, cfg.Format, columns, rows)
+}
+
+// namedQuery is a pre-written query run by `query NAME`. Its text is
+// formatted with the project label (%[1]s) and --depth (%[2]d), and takes
+// --name as $name.
+type namedQuery struct {
+	Usage        string
+	NameRequired bool
+	Cypher       string
+}
+
+// namedQueries is the query library. They are written for the label-per-
+// project schema of the neo4j and falkordb backends.
+var namedQueries = map[string]namedQuery{
+	"callers": {
+		Usage:        "functions and methods calling --name",
+		NameRequired: true,
+		Cypher: `
+		MATCH (caller:%[1]s)-[r:CALLS]->(fn:%[1]s)
+		WHERE (fn:Function OR fn:Method) AND fn.name = $name
+		  AND NOT coalesce(r.deleted, false) AND NOT coalesce(caller.deleted, false)
+		RETURN caller.name AS caller, caller.receiver AS receiver, caller.file AS file,
+		       caller.lineStart AS line, fn.receiver AS calleeReceiver
+		ORDER BY file, line`,
+	},
+	"implementations": {
+		Usage:        "structs implementing interface --name, or interfaces struct --name implements",
+		NameRequired: true,
+		Cypher: `
+		MATCH (s:%[1]s:Struct)-[r:IMPLEMENTS]->(i:%[1]s:Interface)
+		WHERE (i.name = $name OR s.name = $name)
+		  AND NOT coalesce(r.deleted, false) AND NOT coalesce(s.deleted, false) AND NOT coalesce(i.deleted, false)
+		RETURN s.name AS struct, s.file AS structFile, i.name AS interface, i.file AS interfaceFile
+		ORDER BY interface, struct`,
+	},
+	"unused-exports": {
+		Usage: "exported functions and methods nothing in the project calls, under package path --name if given",
+		Cypher: `
+		MATCH (fn:%[1]s)
+		WHERE (fn:Function OR fn:Method) AND fn.isExport AND NOT coalesce(fn.deleted, false)
+		  AND ($name = '' OR fn.file STARTS WITH $name + '/')
+		OPTIONAL MATCH (caller:%[1]s)-[r:CALLS]->(fn) WHERE NOT coalesce(r.deleted, false)
+		WITH fn, count(caller) AS calls WHERE calls = 0
+		RETURN fn.name AS name, fn.receiver AS receiver, fn.file AS file, fn.lineStart AS line
+		ORDER BY file, line`,
+	},
+	"untested-exports": {
+		Usage: "exported functions and methods the --coverprofile tests never run, the most called first, under package path --name if given",
+		Cypher: `
+		MATCH (fn:%[1]s)
+		WHERE (fn:Function OR fn:Method) AND fn.isExport AND fn.coverage = 0 AND NOT coalesce(fn.deleted, false)
+		  AND ($name = '' OR fn.file STARTS WITH $name + '/')
+		RETURN fn.name AS name, fn.receiver AS receiver, fn.file AS file, fn.statements AS statements,
+		       coalesce(fn.fanIn, 0) AS fanIn
+		ORDER BY fanIn DESC, statements DESC, file, name`,
+	},
+	"package-dependencies": {
+		Usage: "packages each package imports, for package path --name if given",
+		Cypher: `
+		MATCH (p:%[1]s:Package)<-[b:BELONGS_TO]-(f:%[1]s:File)-[r:IMPORTS]->(dep:%[1]s:Package)
+		WHERE ($name = '' OR p.path = $name) AND dep <> p
+		  AND NOT coalesce(b.deleted, false) AND NOT coalesce(r.deleted, false)
+		RETURN p.path AS package, dep.path AS dependency, count(DISTINCT f) AS files
+		ORDER BY package, dependency`,
+	},
+	"depended-upon": {
+		Usage: "the 20 functions and methods the most others call, under package path --name if given",
+		Cypher: `
+		MATCH (fn:%[1]s)
+		WHERE (fn:Function OR fn:Method) AND coalesce(fn.fanIn, 0) > 0 AND NOT coalesce(fn.deleted, false)
+		  AND ($name = '' OR fn.file STARTS WITH $name + '/')
+		RETURN fn.name AS name, fn.receiver AS receiver, fn.file AS file, fn.fanIn AS fanIn, fn.fanOut AS fanOut
+		ORDER BY fanIn DESC, file, name
+		LIMIT 20`,
+	},
+	"hotspots": {
+		Usage: "the 20 functions and methods scoring highest by churn × complexity × (1 + fanIn), for graphs written with --churn, under package path --name if given",
+		Cypher: `
+		MATCH (fn:%[1]s)
+		WHERE (fn:Function OR fn:Method) AND coalesce(fn.churn, 0) > 0 AND NOT coalesce(fn.deleted, false)
+		  AND ($name = '' OR fn.file STARTS WITH $name + '/')
+		WITH fn, fn.churn * coalesce(fn.complexity, 1) * (1 + coalesce(fn.fanIn, 0)) AS score
+		RETURN fn.name AS name, fn.receiver AS receiver, fn.file AS file, score,
+		       fn.churn AS churn, fn.complexity AS complexity, fn.fanIn AS fanIn
+		ORDER BY score DESC, file, name
+		LIMIT 20`,
+	},
+	"duplicates": {
+		Usage: "pairs of functions whose bodies are copies of each other, the most similar first, for graphs written with --features duplicates, under package path --name if given",
+		Cypher: `
+		MATCH (a:%[1]s)-[r:DUPLICATES]->(b:%[1]s)
+		WHERE NOT coalesce(r.deleted, false)
+		  AND ($name = '' OR a.file STARTS WITH $name + '/' OR b.file STARTS WITH $name + '/')
+		RETURN a.name AS name, a.file AS file, b.name AS duplicateName, b.file AS duplicateFile, r.similarity AS similarity
+		ORDER BY similarity DESC, file, name
+		LIMIT 50`,
+	},
+	"vulnerabilities": {
+		Usage: "the vulnerabilities --govulncheck found, called ones first, with the module, fixed version and functions reaching them, or only the one with OSV id or alias --name",
+		Cypher: `
+		MATCH (v:%[1]s:Vulnerability)-[:AFFECTS]->(d:%[1]s:Dependency)
+		WHERE $name = '' OR v.id = $name OR $name IN v.aliases
+		OPTIONAL MATCH (fn:%[1]s)-[r:REACHES]->(v)
+		WITH v, d, fn, r
+		ORDER BY size(r.path)
+		RETURN v.id AS id, v.aliases AS aliases, v.level AS level, d.module AS module, d.version AS version,
+		       v.fixedVersion AS fixedVersion, collect(fn.file + ':' + fn.name) AS reachedFrom, v.summary AS summary
+		ORDER BY level DESC, id`,
+	},
+	"call-depth": {
+		Usage: "the 20 entry points whose calls go deepest, with the longest chain of calls from each, under package path --name if given",
+		Cypher: `
+		MATCH (fn:%[1]s)
+		WHERE (fn:Function OR fn:Method) AND fn.callDepth IS NOT NULL AND NOT coalesce(fn.deleted, false)
+		  AND ($name = '' OR fn.file STARTS WITH $name + '/')
+		RETURN fn.name AS name, fn.receiver AS receiver, fn.file AS file, fn.entryPoint AS entryPoint,
+		       fn.callDepth AS depth, fn.deepestPath AS path
+		ORDER BY depth DESC, file, name
+		LIMIT 20`,
+	},
+	"third-party": {
+		Usage: "the 20 packages importing the most third-party packages, with the share of their imports those are, under package path --name if given",
+		Cypher: `
+		MATCH (p:%[1]s:Package)
+		WHERE coalesce(p.thirdPartyImports, 0) > 0 AND NOT coalesce(p.deleted, false)
+		  AND ($name = '' OR p.path = $name OR p.path STARTS WITH $name + '/')
+		RETURN p.path AS package, p.thirdPartyImports AS thirdParty, p.stdlibImports AS stdlib,
+		       p.internalImports AS internal, p.thirdPartyRatio AS ratio, p.thirdPartyPackages AS packages
+		ORDER BY thirdParty DESC, ratio DESC, package
+		LIMIT 20`,
+	},
+	"bus-factor": {
+		Usage: "the 20 packages known to the fewest authors, the lowest bus factor and most dominant owner first, for graphs written with --blame, under package path --name if given",
+		Cypher: `
+		MATCH (p:%[1]s:Package)
+		WHERE p.busFactor IS NOT NULL AND NOT coalesce(p.deleted, false)
+		  AND ($name = '' OR p.path = $name OR p.path STARTS WITH $name + '/')
+		RETURN p.path AS package, p.busFactor AS busFactor, p.owner AS owner, p.ownerShare AS ownerShare,
+		       p.knowledgeableAuthors AS knowledgeable
+		ORDER BY busFactor, ownerShare DESC, package
+		LIMIT 20`,
+	},
+	"modules": {
+		Usage: "the Go modules of the project, by directory, with their Go version and how many packages each holds, or the module with path --name",
+		Cypher: `
+		MATCH (m:%[1]s:Module)
+		WHERE $name = '' OR m.path = $name
+		OPTIONAL MATCH (p:%[1]s:Package)-[:BELONGS_TO]->(m)
+		WHERE NOT coalesce(p.deleted, false)
+		RETURN m.path AS module, m.dir AS dir, m.goVersion AS goVersion, count(p) AS packages
+		ORDER BY dir`,
+	},
+	"fragile-packages": {
+		Usage: "the 20 packages most likely to break when others change: the most unstable, then those importing the most",
+		Cypher: `
+		MATCH (p:%[1]s:Package)
+		WHERE coalesce(p.efferent, 0) > 0 AND NOT coalesce(p.deleted, false)
+		RETURN p.path AS package, p.instability AS instability, p.efferent AS efferent, p.afferent AS afferent
+		ORDER BY instability DESC, efferent DESC, package
+		LIMIT 20`,
+	},
+	"impact": {
+		Usage:        "symbols calling or implementing the symbols in file --name, up to --depth hops away",
+		NameRequired: true,
+		Cypher: `
+		MATCH (f:%[1]s:File {path: $name})-[c:CONTAINS]->(s)
+		WHERE NOT coalesce(c.deleted, false)
+		MATCH path = (affected:%[1]s)-[:CALLS|IMPLEMENTS*1..%[2]d]->(s)
+		WHERE affected.file <> $name AND NOT coalesce(affected.deleted, false)
+		  AND all(r IN relationships(path) WHERE NOT coalesce(r.deleted, false))
+		RETURN affected.name AS symbol, affected.receiver AS receiver, affected.file AS file,
+		       min(length(path)) AS depth
+		ORDER BY depth, file, symbol`,
+	},
+}
+
+// text formats the query for cfg's project, label map and --depth
+func (q namedQuery) text(cfg Config) string {
+	return cfg.Labels.Rewrite(fmt.Sprintf(q.Cypher, cfg.Project, max(cfg.Depth, 1)))
+}
+
+// namedQueryUsage lists the query library for help and errors
+func namedQueryUsage() string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(namedQueries)) {
+		fmt.Fprintf(&b, "\n  %-21s %s", name, namedQueries[name].Usage)
+	}
+	return b.String()
+}
+
+// readQuery returns the query text from the argument, the file or stdin
+func readQuery(arg, file string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case arg != "" && file != "":
+		return "", errors.New("give the query as an argument or with --file, not both")
+	case file != "":
+		data, err = os.ReadFile(file)
+	case arg != "" && arg != "-":
+		return arg, nil
+	default:
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", errors.New("empty query")
+	}
+	return string(data), nil
+}
+
+// parseParams turns --param NAME=VALUE options into query parameters. A
+// value that parses as JSON (a number, boolean, list or map) is passed as
+// such, anything else as a string.
+func parseParams(list []string) (map[string]any, error) {
+	params := make(map[string]any, len(list))
+	for _, param := range list {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --param %q, expected NAME=VALUE", param)
+		}
+		dec := json.NewDecoder(strings.NewReader(value))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil || dec.More() {
+			params[name] = value
+			continue
+		}
+		params[name] = jsonNumbers(v)
+	}
+	return params, nil
+}
+
+// jsonNumbers replaces the json.Numbers in v with int64 where they are whole
+// and float64 otherwise, so LIMIT $n and the like receive integers
+func jsonNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = jsonNumbers(v[key])
+		}
+	}
+	return v
+}
+
+// printRows writes query results as an aligned table, a JSON array with an
+// object per row, or CSV with a header line
+func printRows(w io.Writer, format string, columns []string, rows [][]any) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, value := range row {
+				cells[i] = cellText(value)
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "(%d rows)\n", len(rows))
+		return err
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(rowObjects(columns, rows))
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, value := range row {
+				cells[i] = cellText(value)
+			}
+			cw.Write(cells)
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unknown format %q, expected table, json or csv", format)
+	}
+}
+
+// rowObjects turns query rows into objects keyed by column name
+func rowObjects(columns []string, rows [][]any) []map[string]any {
+	records := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		records[i] = make(map[string]any, len(columns))
+		for j, column := range columns {
+			if j < len(row) {
+				records[i][column] = plainValue(row[j])
+			}
+		}
+	}
+	return records
+}
+
+// cellText renders a value for a table or CSV cell: strings as they are,
+// null as nothing and anything else as JSON
+func cellText(value any) string {
+	switch v := plainValue(value).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// plainValue converts driver types in a query result (nodes, relationships,
+// paths and temporal values) into maps, lists and strings for printing
+func plainValue(value any) any {
+	switch v := value.(type) {
+	case neo4j.Node:
+		return map[string]any{"labels": v.Labels, "properties": plainValue(v.Props)}
+	case neo4j.Relationship:
+		return map[string]any{"type": v.Type, "properties": plainValue(v.Props)}
+	case neo4j.Path:
+		nodes := make([]any, len(v.Nodes))
+		for i, node := range v.Nodes {
+			nodes[i] = plainValue(node)
+		}
+		rels := make([]any, len(v.Relationships))
+		for i, rel := range v.Relationships {
+			rels[i] = plainValue(rel)
+		}
+		return map[string]any{"nodes": nodes, "relationships": rels}
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = plainValue(item)
+		}
+		return items
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, item := range v {
+			m[key] = plainValue(item)
+		}
+		return m
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return value
+	}
+}
+
+// server answers the HTTP API of the serve command from a Cypher backend.
+// Every project given by --path and --project-map is served, chosen with
+// ?project= and defaulting to the first.
+type server struct {
+	ctx      context.Context
+	backend  codegraph.Writer
+	querier  codegraph.Querier
+	projects []string
+	targets  map[string]Config
+
+	mu        sync.Mutex
+	indexes   map[string]*indexStatus
+	indexing  string // project holding indexMu
+	daemon    *daemonState
+	metrics   *serverMetrics
+	mcpClient string // name the MCP client gave in initialize
+
+	// indexMu lets one project be indexed at a time, so the active
+	// progress belongs to the project being indexed
+	indexMu sync.Mutex
+}
+
+// indexStatus is the state of a project's most recent re-index
+type indexStatus struct {
+	Running    bool      `json:"running"`
+	StartedAt  time.Time `json:"startedAt,omitzero"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+	RunID      string    `json:"runId,omitempty"`
+	Commit     string    `json:"commit,omitempty"`
+	Step       string    `json:"step,omitempty"`
+	Done       int       `json:"done,omitempty"`
+	Total      int       `json:"total,omitempty"`
+	Unit       string    `json:"unit,omitempty"`
+	Files      int       `json:"files,omitempty"`
+	Nodes      int       `json:"nodes,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// apiNode is a node as returned by the HTTP API
+type apiNode struct {
+	Key        string         `json:"key"`
+	Label      string         `json:"label"`
+	Properties map[string]any `json:"properties"`
+}
+
+// runServe serves the HTTP API on --listen until ctx is cancelled
+func runServe(ctx context.Context, cfg Config, targets []target) error {
+	if cfg.Backend != "neo4j" && cfg.Backend != "falkordb" {
+		return fmt.Errorf("serve needs --backend neo4j or falkordb")
+	}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+
+	return serveHTTP(ctx, newServer(ctx, cfg, targets, backend), cfg.Listen)
+}
+
+// serveHTTP serves s's routes on addr until ctx is cancelled
+func serveHTTP(ctx context.Context, s *server, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+	slog.Info("serving HTTP API", "addr", addr, "projects", s.projects)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// newServer serves the targets from backend, which must implement Querier.
+// Re-indexing stops when ctx is cancelled.
+func newServer(ctx context.Context, cfg Config, targets []target, backend codegraph.Writer) *server {
+	s := &server{
+		ctx:     ctx,
+		backend: backend,
+		querier: backend.(codegraph.Querier),
+		targets: make(map[string]Config),
+		indexes: make(map[string]*indexStatus),
+	}
+	for _, t := range targets {
+		tcfg := cfg
+		tcfg.Project, tcfg.Path, tcfg.Filter = t.Project, t.Path, t.Filter
+		s.projects = append(s.projects, t.Project)
+		s.targets[t.Project] = tcfg
+		s.indexes[t.Project] = &indexStatus{}
+	}
+	s.metrics = newServerMetrics(s.projects)
+	return s
+}
+
+// routes registers the API endpoints
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /projects", s.handleProjects)
+	mux.HandleFunc("GET /symbols", s.handleSymbols)
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /similar", s.handleSimilar)
+	mux.HandleFunc("GET /node", s.handleNode)
+	mux.HandleFunc("GET /neighbors", s.handleNeighbors)
+	mux.HandleFunc("GET /queries", s.handleQueries)
+	mux.HandleFunc("GET /queries/{name}", s.handleNamedQuery)
+	mux.HandleFunc("GET /reindex", s.handleIndexStatus)
+	mux.HandleFunc("POST /reindex", s.handleReindex)
+	mux.HandleFunc("GET /memories", s.handleRecall)
+	mux.HandleFunc("POST /memories", s.handleRemember)
+	mux.HandleFunc("DELETE /memories/{id}", s.handleForget)
+	mux.HandleFunc("PUT /memories/{id}/pin", s.handlePin)
+	mux.HandleFunc("DELETE /memories/{id}/pin", s.handlePin)
+	mux.HandleFunc("GET /sessions", s.handleSessions)
+	mux.HandleFunc("POST /sessions", s.handleStartSession)
+	mux.HandleFunc("GET /sessions/{id}", s.handleReplay)
+	mux.HandleFunc("POST /sessions/{id}/end", s.handleEndSession)
+	mux.HandleFunc("POST /sessions/{id}/touch", s.handleTouchFiles)
+	mux.HandleFunc("POST /sessions/{id}/summary", s.handleSummarize)
+	mux.HandleFunc("GET /summaries", s.handleSummaries)
+	mux.HandleFunc("GET /decisions", s.handleDecisions)
+	mux.HandleFunc("POST /decisions", s.handleDecide)
+	mux.HandleFunc("POST /decisions/{id}/status", s.handleDecisionStatus)
+	mux.HandleFunc("GET /conventions", s.handleConventions)
+	mux.HandleFunc("POST /conventions", s.handleAddConvention)
+	mux.HandleFunc("DELETE /conventions/{id}", s.handleRemoveConvention)
+	mux.HandleFunc("GET /links", s.handleLinks)
+	mux.HandleFunc("POST /links", s.handleLink)
+	mux.HandleFunc("DELETE /links/{id}", s.handleUnlink)
+	mux.HandleFunc("GET /changes", s.handleChanges)
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	return localOnly(jsonOnly(mux))
+}
+
+// jsonOnly refuses requests to mux's routes that change anything unless
+// their body is declared JSON. A web page can send a form or text/plain
+// POST anywhere without asking, but not an application/json one.
+func jsonOnly(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if _, pattern := mux.Handler(r); pattern != "" {
+				mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if mediaType != "application/json" {
+					writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+					return
+				}
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// project returns the configuration of the project a request is about
+func (s *server) project(r *http.Request) (Config, error) {
+	return s.lookup(r.URL.Query().Get("project"))
+}
+
+// lookup returns the configuration of the named project, or the first if
+// name is empty
+func (s *server) lookup(name string) (Config, error) {
+	if name == "" {
+		name = s.projects[0]
+	}
+	cfg, ok := s.targets[name]
+	if !ok {
+		return cfg, fmt.Errorf("unknown project %q", name)
+	}
+	return cfg, nil
+}
+
+func (s *server) handleProjects(w http.ResponseWriter, r *http.Request) {
+	type project struct {
+		Name     string        `json:"name"`
+		Path     string        `json:"path"`
+		Index    indexStatus   `json:"index"`
+		Schedule *daemonStatus `json:"schedule,omitempty"`
+	}
+	projects := make([]project, 0, len(s.projects))
+	for _, name := range s.projects {
+		projects = append(projects, project{Name: name, Path: s.targets[name].Path, Index: s.status(name), Schedule: s.schedule(name)})
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
+
+// handleSymbols finds functions, methods, structs and interfaces whose name
+// contains ?q=, case-insensitively, optionally only of ?kind=
+func (s *server) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("q") == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing q"))
+		return
+	}
+	kind := q.Get("kind")
+	if kind != "" && !slices.Contains(codegraph.NodeLabels, kind) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown kind %q", kind))
+		return
+	}
+	limit, err := queryInt(q, "limit", 50)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	nodes, err := s.searchSymbols(r.Context(), cfg, q.Get("q"), kind, limit)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// handleSearch finds the functions, methods and structs closest in meaning
+// to ?q=, in ?package= if set, at most ?limit= of them with ?callers= of
+// their callers and ?lines= of source each
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("q") == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing q"))
+		return
+	}
+	cfg.Package = q.Get("package")
+	for _, param := range []struct {
+		name  string
+		value *int
+		def   int
+	}{{"limit", &cfg.Limit, 10}, {"callers", &cfg.Callers, 3}, {"lines", &cfg.SnippetLines, 8}} {
+		if *param.value, err = queryInt(q, param.name, param.def); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	v, err := s.vectorSearcher(cfg)
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	results, err := searchCode(r.Context(), cfg, v, q.Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// handleSimilar finds the functions, methods and structs whose embeddings
+// are closest to that of ?q=, at most ?limit= of them
+func (s *server) handleSimilar(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("q") == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing q"))
+		return
+	}
+	if cfg.Limit, err = queryInt(q, "limit", 10); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	v, err := s.vectorSearcher(cfg)
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	nodes, err := findSimilar(r.Context(), cfg, v, q.Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// vectorSearcher returns the backend's vector search, if it has one and the
+// server was started with --embed
+func (s *server) vectorSearcher(cfg Config) (codegraph.VectorSearcher, error) {
+	v, ok := s.backend.(codegraph.VectorSearcher)
+	if !ok {
+		return nil, errors.New("the backend cannot search embeddings")
+	}
+	if cfg.Embed == "" {
+		return nil, errors.New("the server was started without --embed")
+	}
+	return v, nil
+}
+
+// searchSymbols finds the symbols of kind (any if empty) whose name contains
+// text, shortest names first
+func (s *server) searchSymbols(ctx context.Context, cfg Config, text, kind string, limit int) ([]apiNode, error) {
+	if kind != "" {
+		kind = cfg.Labels.Label(kind)
+	}
+	query := cfg.Labels.Rewrite(fmt.Sprintf(`
+		MATCH (n:%s)
+		WHERE (n:Function OR n:Method OR n:Struct OR n:Interface) AND NOT coalesce(n.deleted, false)
+		  AND toLower(n.name) CONTAINS toLower($q) AND ($kind = '' OR $kind IN labels(n))
+		RETURN labels(n) AS labels, properties(n) AS props
+		ORDER BY size(n.name), n.name LIMIT $limit
+	`, cfg.Project))
+	_, rows, err := s.querier.Query(ctx, query, map[string]any{"q": text, "kind": kind, "limit": limit})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]apiNode, 0, len(rows))
+	for _, row := range rows {
+		nodes = append(nodes, newAPINode(cfg.Labels, row[0], row[1]))
+	}
+	return nodes, nil
+}
+
+// symbolKey returns the key of the one function, method, struct or
+// interface named name, a method named with its receiver type, as in
+// Store.Put
+func (s *server) symbolKey(ctx context.Context, cfg Config, name string) (string, error) {
+	receiver, method, isMethod := strings.Cut(name, ".")
+	if !isMethod {
+		method = name
+	}
+	nodes, err := s.searchSymbols(ctx, cfg, method, "", 1000)
+	if err != nil {
+		return "", err
+	}
+	var keys []string
+	for _, node := range nodes {
+		nodeReceiver, _ := node.Properties["receiver"].(string)
+		if node.Properties["name"] == method && (!isMethod || strings.TrimPrefix(nodeReceiver, "*") == receiver) {
+			keys = append(keys, node.Key)
+		}
+	}
+	switch len(keys) {
+	case 0:
+		return "", fmt.Errorf("no function, method, struct or interface named %q", name)
+	case 1:
+		return keys[0], nil
+	default:
+		return "", fmt.Errorf("%q names %d symbols, pass one of their keys instead: %s", name, len(keys), strings.Join(keys, ", "))
+	}
+}
+
+// handleNode returns the node with ?key=
+func (s *server) handleNode(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	match, params, err := keyMatch(cfg, r.URL.Query().Get("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, rows, err := s.querier.Query(r.Context(), match+" RETURN labels(n) AS labels, properties(n) AS props LIMIT 1", params)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if len(rows) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("no such node"))
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPINode(cfg.Labels, rows[0][0], rows[0][1]))
+}
+
+// handleNeighbors returns the nodes related to ?key=, optionally only
+// through ?type= relationships in ?direction= (in, out or both)
+func (s *server) handleNeighbors(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	match, params, err := keyMatch(cfg, q.Get("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var pattern string
+	switch q.Get("direction") {
+	case "out":
+		pattern = "(n)-[r]->(m:%s)"
+	case "in":
+		pattern = "(n)<-[r]-(m:%s)"
+	case "", "both":
+		pattern = "(n)-[r]-(m:%s)"
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown direction %q, expected in, out or both", q.Get("direction")))
+		return
+	}
+	limit, err := queryInt(q, "limit", 200)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	params["type"] = q.Get("type")
+	params["limit"] = limit
+
+	query := match + fmt.Sprintf(`
+		MATCH `+pattern+`
+		WHERE ($type = '' OR type(r) = $type) AND NOT coalesce(r.deleted, false) AND NOT coalesce(m.deleted, false)
+		RETURN type(r) AS type, startNode(r) = n AS outgoing, labels(m) AS labels, properties(m) AS props
+		LIMIT $limit
+	`, cfg.Project)
+	_, rows, err := s.querier.Query(r.Context(), query, params)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	type neighbor struct {
+		Type      string  `json:"type"`
+		Direction string  `json:"direction"`
+		Node      apiNode `json:"node"`
+	}
+	neighbors := make([]neighbor, 0, len(rows))
+	for _, row := range rows {
+		n := neighbor{Direction: "in", Node: newAPINode(cfg.Labels, row[2], row[3])}
+		n.Type, _ = row[0].(string)
+		if outgoing, _ := row[1].(bool); outgoing {
+			n.Direction = "out"
+		}
+		neighbors = append(neighbors, n)
+	}
+	writeJSON(w, http.StatusOK, neighbors)
+}
+
+// memories returns the backend's memory store
+func (s *server) memories() (codegraph.MemoryStore, error) {
+	m, ok := s.backend.(codegraph.MemoryStore)
+	if !ok {
+		return nil, errors.New("the backend cannot keep memories")
+	}
+	return m, nil
+}
+
+// handleRecall lists the memories about ?about= or anything in ?file=,
+// tagged every ?tag= and no ?exclude-tag=, and sharing words with ?q=,
+// pinned and then newest first or ranked with ?rank=true, at most ?limit=
+// of them. Only shared memories are listed, and those in each ?namespace=,
+// or every namespace with ?namespace=*. Those of linked projects are added
+// unless ?linked=false.
+func (s *server) handleRecall(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit", 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	mq := codegraph.MemoryQuery{
+		About: q.Get("about"), File: q.Get("file"), Tags: q["tag"], ExcludeTags: q["exclude-tag"],
+		Namespaces: recallNamespaces(strings.Join(q["namespace"], ",")), Question: q.Get("q"), Limit: limit,
+		Provenance: codegraph.Provenance{Via: q.Get("via"), Agent: q.Get("agent"), Model: q.Get("model"), User: q.Get("user")},
+	}
+	trust, err := codegraph.ParseTrust(q["trust"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if value := q.Get("rank"); value != "" || len(trust) > 0 {
+		rank, err := strconv.ParseBool(cmp.Or(value, "true"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid rank %q", value))
+			return
+		}
+		if rank {
+			ranking := codegraph.DefaultRanking
+			ranking.Trust = trust
+			mq.Ranking = &ranking
+		}
+	}
+	linked := true
+	if value := q.Get("linked"); value != "" {
+		if linked, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid linked %q", value))
+			return
+		}
+	}
+	m, err := s.memories()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	memories, err := recallMemories(r.Context(), m, cfg.Project, mq, linked)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, memories)
+}
+
+// handleRemember stores the memory in the request body, answering with it
+// as stored
+func (s *server) handleRemember(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var memory codegraph.Memory
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&memory); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading memory: %w", err))
+		return
+	}
+	m, err := s.memories()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	if memory.GitState == (codegraph.GitState{}) {
+		memory.GitState = resolveGitState(r.Context(), cfg)
+	}
+	memory.Via = codegraph.ViaHTTP
+	memory, err = m.Remember(r.Context(), cfg.Project, memory)
+	switch {
+	case errors.Is(err, codegraph.ErrInvalidMemory):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, codegraph.ErrNoNode), errors.Is(err, codegraph.ErrNoSession):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeJSON(w, http.StatusCreated, memory)
+	}
+}
+
+// handleForget deletes the memory with the ID in the path
+func (s *server) handleForget(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	m, err := s.memories()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	found, err := m.Forget(r.Context(), cfg.Project, r.PathValue("id"))
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	case !found:
+		writeError(w, http.StatusNotFound, errors.New("no such memory"))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handlePin pins the memory with the ID in the path on PUT, and unpins it
+// on DELETE
+func (s *server) handlePin(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	m, err := s.memories()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	found, err := m.PinMemory(r.Context(), cfg.Project, r.PathValue("id"), r.Method == http.MethodPut)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	case !found:
+		writeError(w, http.StatusNotFound, errors.New("no such memory"))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sessions returns the backend's session store
+func (s *server) sessions() (codegraph.SessionStore, error) {
+	store, ok := s.backend.(codegraph.SessionStore)
+	if !ok {
+		return nil, errors.New("the backend cannot record sessions")
+	}
+	return store, nil
+}
+
+// handleSessions lists the sessions, newest first, at most ?limit= of them
+func (s *server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	limit, err := queryInt(r.URL.Query(), "limit", 20)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	store, err := s.sessions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	sessions, err := store.Sessions(r.Context(), cfg.Project, limit)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// handleStartSession starts a session in the request body's dir and branch
+func (s *server) handleStartSession(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var session codegraph.Session
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&session); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading session: %w", err))
+		return
+	}
+	store, err := s.sessions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	session, err = store.StartSession(r.Context(), cfg.Project, session)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+// handleReplay returns the session with the ID in the path and the
+// memories, and summaries and decisions if the backend keeps them, it
+// recorded, oldest first
+func (s *server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	store, err := s.sessions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	session, memories, err := store.Replay(r.Context(), cfg.Project, r.PathValue("id"), recallNamespaces(strings.Join(r.URL.Query()["namespace"], ",")))
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	replay := map[string]any{"session": session, "memories": memories}
+	if sum, err := s.summaries(); err == nil {
+		summaries, err := sessionSummaries(r.Context(), cfg.Project, sum, session.ID)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		replay["summaries"] = summaries
+	}
+	if d, err := s.decisions(); err == nil {
+		decisions, err := sessionDecisions(r.Context(), cfg.Project, d, session.ID)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		replay["decisions"] = decisions
+	}
+	writeJSON(w, http.StatusOK, replay)
+}
+
+// handleEndSession ends the session with the ID in the path
+func (s *server) handleEndSession(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	store, err := s.sessions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	session, err := store.EndSession(r.Context(), cfg.Project, r.PathValue("id"))
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleTouchFiles records the files in the request body as touched by the
+// session with the ID in the path
+func (s *server) handleTouchFiles(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var body struct {
+		Files []string `json:"files"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading files: %w", err))
+		return
+	}
+	if len(body.Files) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing files"))
+		return
+	}
+	store, err := s.sessions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	if err := store.TouchFiles(r.Context(), cfg.Project, r.PathValue("id"), body.Files); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeSessionError answers 404 for an unknown session and 502 otherwise
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, codegraph.ErrNoSession) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusBadGateway, err)
+}
+
+// summaries returns the backend's summary store
+func (s *server) summaries() (codegraph.SummaryStore, error) {
+	sum, ok := s.backend.(codegraph.SummaryStore)
+	if !ok {
+		return nil, errors.New("the backend cannot keep summaries")
+	}
+	return sum, nil
+}
+
+// handleSummarize stores the summary in the request body of the session
+// with the ID in the path, answering with it as stored
+func (s *server) handleSummarize(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var summary codegraph.Summary
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&summary); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading summary: %w", err))
+		return
+	}
+	sum, err := s.summaries()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	summary.Session = r.PathValue("id")
+	if summary.GitState == (codegraph.GitState{}) {
+		summary.GitState = resolveGitState(r.Context(), cfg)
+	}
+	summary, err = sum.Summarize(r.Context(), cfg.Project, summary)
+	if err != nil {
+		writeSummaryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, summary)
+}
+
+// writeSummaryError answers 400 for an invalid summary, 404 for an unknown
+// session or node and 502 otherwise
+func writeSummaryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, codegraph.ErrInvalidSummary):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, codegraph.ErrNoSession), errors.Is(err, codegraph.ErrNoNode):
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+// handleSummaries lists the summaries of sessions that worked on
+// ?package=, newest first, at most ?limit= of them
+func (s *server) handleSummaries(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit", 5)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sum, err := s.summaries()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	summaries, err := sum.Summaries(r.Context(), cfg.Project, codegraph.SummaryQuery{Package: q.Get("package"), Session: q.Get("session"), Limit: limit})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// linker returns the backend's cross-project linker
+func (s *server) linker() (codegraph.CrossLinker, error) {
+	l, ok := s.backend.(codegraph.CrossLinker)
+	if !ok {
+		return nil, errors.New("the backend cannot link projects")
+	}
+	return l, nil
+}
+
+// handleLinks lists the links from or to the project's nodes
+func (s *server) handleLinks(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	l, err := s.linker()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	links, err := l.CrossLinks(r.Context(), cfg.Project)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, links)
+}
+
+// handleLink stores the link in the request body from the project,
+// answering with it as stored, or with a module links the project's files
+// by their imports of the module's packages and answers with those links
+func (s *server) handleLink(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var req struct {
+		codegraph.CrossLink
+		Module string `json:"module"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading link: %w", err))
+		return
+	}
+	l, err := s.linker()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	var result any
+	if req.Module != "" {
+		result, err = l.LinkImports(r.Context(), cfg.Project, req.ToProject, req.Module)
+	} else {
+		req.FromProject = cfg.Project
+		result, err = l.LinkProjects(r.Context(), req.CrossLink)
+	}
+	switch {
+	case errors.Is(err, codegraph.ErrInvalidLink):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, codegraph.ErrNoNode):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeJSON(w, http.StatusCreated, result)
+	}
+}
+
+// handleUnlink deletes the link with the ID in the path
+func (s *server) handleUnlink(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	l, err := s.linker()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	found, err := l.Unlink(r.Context(), cfg.Project, r.PathValue("id"))
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	case !found:
+		writeError(w, http.StatusNotFound, errors.New("no such link"))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleChanges answers the code changed and the memories and decisions
+// recorded since the time or duration ago in since, or the end of the
+// session with ID session, by default of the last session to have ended
+func (s *server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	since, err := changesTime(r.Context(), cfg, s.backend, q.Get("since"), q.Get("session"), time.Now().UTC())
+	if err != nil {
+		if exitCode(err) == exitUsage {
+			writeError(w, http.StatusBadRequest, err)
+		} else {
+			writeSessionError(w, err)
+		}
+		return
+	}
+	changes, err := readChanges(r.Context(), cfg, s.backend, since)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// decisions returns the backend's decision store
+func (s *server) decisions() (codegraph.DecisionStore, error) {
+	d, ok := s.backend.(codegraph.DecisionStore)
+	if !ok {
+		return nil, errors.New("the backend cannot keep decisions")
+	}
+	return d, nil
+}
+
+// handleDecisions lists the decisions affecting ?about= or the file and
+// package holding it, with ?status=, newest first, at most ?limit= of them
+func (s *server) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit", 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	d, err := s.decisions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	dq := codegraph.DecisionQuery{Status: q.Get("status"), Limit: limit}
+	if about := q.Get("about"); about != "" {
+		dq.Affects = codegraph.EnclosingKeys(about)
+	}
+	decisions, err := d.Decisions(r.Context(), cfg.Project, dq)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, decisions)
+}
+
+// handleDecide stores the decision in the request body, answering with it
+// as stored
+func (s *server) handleDecide(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var decision codegraph.Decision
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&decision); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading decision: %w", err))
+		return
+	}
+	d, err := s.decisions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	if decision.GitState == (codegraph.GitState{}) {
+		decision.GitState = resolveGitState(r.Context(), cfg)
+	}
+	decision, err = d.Decide(r.Context(), cfg.Project, decision)
+	if err != nil {
+		writeDecisionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, decision)
+}
+
+// handleDecisionStatus sets the status in the request body on the decision
+// with the ID in the path
+func (s *server) handleDecisionStatus(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading status: %w", err))
+		return
+	}
+	if body.Status == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing status"))
+		return
+	}
+	d, err := s.decisions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	decision, err := d.SetDecisionStatus(r.Context(), cfg.Project, r.PathValue("id"), body.Status)
+	if err != nil {
+		writeDecisionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, decision)
+}
+
+// writeDecisionError answers 400 for an invalid decision, 404 for an
+// unknown decision, node or session and 502 otherwise
+func writeDecisionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, codegraph.ErrInvalidDecision):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, codegraph.ErrNoDecision), errors.Is(err, codegraph.ErrNoNode), errors.Is(err, codegraph.ErrNoSession):
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+// conventions returns the backend's convention store
+func (s *server) conventions() (codegraph.ConventionStore, error) {
+	c, ok := s.backend.(codegraph.ConventionStore)
+	if !ok {
+		return nil, errors.New("the backend cannot keep conventions")
+	}
+	return c, nil
+}
+
+// handleConventions lists the conventions applying to ?package= or the
+// package holding ?about=, in ?category=, shared or in a ?namespace=, at
+// most ?limit= of them
+func (s *server) handleConventions(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit", 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	c, err := s.conventions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	cq := codegraph.ConventionQuery{
+		Package: q.Get("package"), Category: q.Get("category"),
+		Namespaces: recallNamespaces(strings.Join(q["namespace"], ",")), Limit: limit,
+	}
+	if about := q.Get("about"); about != "" {
+		cq.Package = keyPackage(about)
+	}
+	conventions, err := c.Conventions(r.Context(), cfg.Project, cq)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conventions)
+}
+
+// handleAddConvention stores the convention in the request body, answering
+// with it as stored
+func (s *server) handleAddConvention(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	var convention codegraph.Convention
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&convention); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading convention: %w", err))
+		return
+	}
+	c, err := s.conventions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	convention, err = c.AddConvention(r.Context(), cfg.Project, convention)
+	switch {
+	case errors.Is(err, codegraph.ErrInvalidConvention):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, codegraph.ErrNoNode):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeJSON(w, http.StatusCreated, convention)
+	}
+}
+
+// handleRemoveConvention deletes the convention with the ID in the path
+func (s *server) handleRemoveConvention(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	c, err := s.conventions()
+	if err != nil {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	found, err := c.RemoveConvention(r.Context(), cfg.Project, r.PathValue("id"))
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	case !found:
+		writeError(w, http.StatusNotFound, errors.New("no such convention"))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *server) handleQueries(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Name         string `json:"name"`
+		Usage        string `json:"usage"`
+		NameRequired bool   `json:"nameRequired"`
+	}
+	queries := make([]query, 0, len(namedQueries))
+	for _, name := range slices.Sorted(maps.Keys(namedQueries)) {
+		queries = append(queries, query{Name: name, Usage: namedQueries[name].Usage, NameRequired: namedQueries[name].NameRequired})
+	}
+	writeJSON(w, http.StatusOK, queries)
+}
+
+// handleNamedQuery runs a named query with ?name= and ?depth= standing in
+// for --name and --depth, returning an object per row
+func (s *server) handleNamedQuery(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	named, ok := namedQueries[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown query %q", r.PathValue("name")))
+		return
+	}
+	q := r.URL.Query()
+	if named.NameRequired && q.Get("name") == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing name"))
+		return
+	}
+	if cfg.Depth, err = queryInt(q, "depth", cfg.Depth); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rows, err := s.runNamed(r.Context(), cfg, named, q.Get("name"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rows)
+}
+
+// runNamed runs a named query for cfg's project with name as $name
+func (s *server) runNamed(ctx context.Context, cfg Config, named namedQuery, name string) ([]map[string]any, error) {
+	columns, rows, err := s.querier.Query(ctx, named.text(cfg), map[string]any{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	return rowObjects(columns, rows), nil
+}
+
+func (s *server) handleIndexStatus(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status(cfg.Project))
+}
+
+// handleReindex starts re-indexing the project in the background and
+// returns at once; GET /reindex reports its progress
+func (s *server) handleReindex(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.project(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	run, status, ok := s.beginIndex(cfg.Project)
+	if !ok {
+		writeJSON(w, http.StatusConflict, status)
+		return
+	}
+	go s.index(s.ctx, cfg, run, nil)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// status returns the project's index status, with the step in progress
+// while it is running
+func (s *server) status(project string) indexStatus {
+	s.mu.Lock()
+	status, indexing := *s.indexes[project], s.indexing == project
+	s.mu.Unlock()
+	if p := activeProgress.Load(); p != nil && indexing {
+		status.Step, status.Done, status.Total, status.Unit = p.name, int(p.done.Load()), p.total, p.unit
+	}
+	return status
+}
+
+// beginIndex marks project as being indexed by a new run, returning its
+// status and false instead if it already is
+func (s *server) beginIndex(project string) (codegraph.RunInfo, indexStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.indexes[project]
+	if status.Running {
+		return codegraph.RunInfo{}, *status, false
+	}
+	run := codegraph.NewRunInfo()
+	*status = indexStatus{Running: true, StartedAt: run.StartedAt, RunID: run.ID}
+	return run, *status, true
+}
+
+// index parses cfg.Project and rewrites files, or everything if files is
+// nil, as the run begun by beginIndex, and records the outcome in its status
+func (s *server) index(ctx context.Context, cfg Config, run codegraph.RunInfo, files []string) (indexStatus, error) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	s.mu.Lock()
+	s.indexing = cfg.Project
+	s.mu.Unlock()
+
+	log := slog.With("project", cfg.Project, "run", run.ID)
+	log.Info("re-indexing", "files", len(files))
+	parseStart := time.Now()
+	parseCtx, span := tracer.Start(ctx, "parse", trace.WithAttributes(attribute.String("codegraph.project", cfg.Project)))
+	var graph *codegraph.Graph
+	var err error
+	profilePhase(parseCtx, "parse", func(ctx context.Context) { graph, err = parseTarget(ctx, cfg) })
+	endSpan(span, err)
+	parseTime := time.Since(parseStart)
+	var writeTime time.Duration
+	before := writeStats(s.backend)
+	if err == nil {
+		run.Commit = codegraph.ResolveCommit(ctx, cfg.Path, cfg.Rev)
+		writeStart := time.Now()
+		writeCtx, span := tracer.Start(ctx, "write", trace.WithAttributes(
+			attribute.String("codegraph.project", cfg.Project),
+			attribute.String("codegraph.backend", cfg.Backend)))
+		profilePhase(writeCtx, "write", func(ctx context.Context) {
+			if files != nil {
+				err = codegraph.WriteFiles(ctx, s.backend, cfg.Project, graph, files, run)
+			} else {
+				err = s.backend.Write(ctx, cfg.Project, graph, run)
+			}
+		})
+		endSpan(span, err)
+		writeTime = time.Since(writeStart)
+	}
+	after := writeStats(s.backend)
+	written := codegraph.WriteStats{
+		Statements: after.Statements - before.Statements,
+		Batches:    after.Batches - before.Batches,
+		Retries:    after.Retries - before.Retries,
+	}
+	nodes := 0
+	if err == nil {
+		nodes = len(graph.Nodes())
+	}
+	s.metrics.observe(cfg.Project, parseTime, writeTime, written, nodes, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexing = ""
+	status := s.indexes[cfg.Project]
+	status.Running = false
+	status.FinishedAt = time.Now().UTC()
+	if err != nil {
+		err = describeCancel(ctx, err)
+		status.Error = err.Error()
+		log.Error("re-indexing", "err", err)
+		return *status, err
+	}
+	status.Files, status.Nodes, status.Commit = len(graph.Files), len(graph.Nodes()), run.Commit
+	log.Info("re-indexed", "files", status.Files, "nodes", status.Nodes, "took", status.FinishedAt.Sub(status.StartedAt).Round(time.Millisecond))
+	return *status, nil
+}
+
+// durationBuckets spans parses and writes from 50ms to about two minutes
+var durationBuckets = prometheus.ExponentialBuckets(0.05, 2, 12)
+
+// serverMetrics are the Prometheus metrics of a serving process's
+// re-indexes, served at GET /metrics
+type serverMetrics struct {
+	registry   *prometheus.Registry
+	runs       *prometheus.CounterVec
+	parse      *prometheus.HistogramVec
+	write      *prometheus.HistogramVec
+	statements *prometheus.CounterVec
+	batches    *prometheus.CounterVec
+	retries    *prometheus.CounterVec
+	nodes      *prometheus.GaugeVec
+	lastIndex  *prometheus.GaugeVec
+
+	mu      sync.Mutex
+	indexed map[string]time.Time // last successful index of each project
+}
+
+func newServerMetrics(projects []string) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "codegraph_index_runs_total",
+			Help: "Re-indexes of each project, by result (ok or error).",
+		}, []string{"project", "result"}),
+		parse: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "codegraph_parse_duration_seconds",
+			Help:    "Time taken to parse a project for a re-index.",
+			Buckets: durationBuckets,
+		}, []string{"project"}),
+		write: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "codegraph_write_duration_seconds",
+			Help:    "Time taken to write a parsed project to the backend.",
+			Buckets: durationBuckets,
+		}, []string{"project"}),
+		statements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "codegraph_write_statements_total",
+			Help: "Statements run writing each project.",
+		}, []string{"project"}),
+		batches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "codegraph_write_batches_total",
+			Help: "UNWIND batches written for each project.",
+		}, []string{"project"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "codegraph_write_retries_total",
+			Help: "Statements retried after transient errors writing each project.",
+		}, []string{"project"}),
+		nodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "codegraph_nodes",
+			Help: "Nodes written by the last successful re-index of each project.",
+		}, []string{"project"}),
+		lastIndex: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "codegraph_last_index_timestamp_seconds",
+			Help: "Unix time the last successful re-index of each project finished.",
+		}, []string{"project"}),
+		indexed: make(map[string]time.Time),
+	}
+	m.registry.MustRegister(m.runs, m.parse, m.write, m.statements, m.batches, m.retries, m.nodes, m.lastIndex,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	started := time.Now()
+	for _, project := range projects {
+		// Export zeros, so rates and absent() work before the first run
+		m.runs.WithLabelValues(project, "ok")
+		m.runs.WithLabelValues(project, "error")
+		m.statements.WithLabelValues(project)
+		m.batches.WithLabelValues(project)
+		m.retries.WithLabelValues(project)
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "codegraph_staleness_seconds",
+			Help:        "Seconds since each project was last re-indexed successfully, or since the server started if it has not been.",
+			ConstLabels: prometheus.Labels{"project": project},
+		}, func() float64 {
+			m.mu.Lock()
+			last, ok := m.indexed[project]
+			m.mu.Unlock()
+			if !ok {
+				last = started
+			}
+			return time.Since(last).Seconds()
+		}))
+	}
+	return m
+}
+
+// observe records a re-index of project that parsed for parse and wrote for
+// write, zero if it failed before writing, running the statements in stats
+func (m *serverMetrics) observe(project string, parse, write time.Duration, stats codegraph.WriteStats, nodes int, err error) {
+	m.parse.WithLabelValues(project).Observe(parse.Seconds())
+	if write > 0 {
+		m.write.WithLabelValues(project).Observe(write.Seconds())
+	}
+	m.statements.WithLabelValues(project).Add(float64(stats.Statements))
+	m.batches.WithLabelValues(project).Add(float64(stats.Batches))
+	m.retries.WithLabelValues(project).Add(float64(stats.Retries))
+	if err != nil {
+		m.runs.WithLabelValues(project, "error").Inc()
+		return
+	}
+	m.runs.WithLabelValues(project, "ok").Inc()
+	m.nodes.WithLabelValues(project).Set(float64(nodes))
+	now := time.Now()
+	m.lastIndex.WithLabelValues(project).Set(float64(now.Unix()))
+	m.mu.Lock()
+	m.indexed[project] = now
+	m.mu.Unlock()
+}
+
+// writeStats returns the statements backend has run so far, if it counts them
+func writeStats(backend codegraph.Writer) codegraph.WriteStats {
+	if s, ok := backend.(interface{ Stats() codegraph.WriteStats }); ok {
+		return s.Stats()
+	}
+	return codegraph.WriteStats{}
+}
+
+// daemonState is the schedule the daemon command re-indexes projects on
+type daemonState struct {
+	schedule *cronSchedule
+	spec     string
+	poll     time.Duration
+
+	mu      sync.Mutex
+	nextRun time.Time
+	polled  time.Time
+	heads   map[string]string // commit last seen at each project's HEAD
+}
+
+// daemonStatus is a project's schedule as returned by GET /projects
+type daemonStatus struct {
+	Schedule   string    `json:"schedule,omitempty"`
+	NextRun    time.Time `json:"nextRun,omitzero"`
+	Poll       string    `json:"poll,omitempty"`
+	LastPolled time.Time `json:"lastPolled,omitzero"`
+	Head       string    `json:"head,omitempty"`
+}
+
+// runDaemon serves the HTTP API like serve, and keeps every project indexed:
+// all of them at startup, on the --schedule and, with --poll, whenever a
+// project's HEAD moves
+func runDaemon(ctx context.Context, cfg Config, targets []target) error {
+	if cfg.Backend != "neo4j" && cfg.Backend != "falkordb" {
+		return fmt.Errorf("daemon needs --backend neo4j or falkordb")
+	}
+	if cfg.Schedule == "" && cfg.Poll <= 0 {
+		return fmt.Errorf("daemon needs --schedule or --poll")
+	}
+	d := &daemonState{spec: cfg.Schedule, poll: cfg.Poll, heads: make(map[string]string)}
+	if cfg.Schedule != "" {
+		schedule, err := parseCron(cfg.Schedule)
+		if err != nil {
+			return fmt.Errorf("--schedule: %w", err)
+		}
+		d.schedule = schedule
+	}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+
+	s := newServer(ctx, cfg, targets, backend)
+	s.daemon = d
+	go s.keepIndexed(ctx)
+	return serveHTTP(ctx, s, cfg.Listen)
+}
+
+// keepIndexed indexes every project, then re-indexes them when the schedule
+// fires or their HEAD moves, until ctx is cancelled
+func (s *server) keepIndexed(ctx context.Context) {
+	d := s.daemon
+	for _, project := range s.projects {
+		d.mu.Lock()
+		d.heads[project] = codegraph.ResolveCommit(ctx, s.targets[project].Path, s.targets[project].Rev)
+		d.mu.Unlock()
+		s.scheduledIndex(ctx, project, "startup")
+	}
+
+	var fire <-chan time.Time
+	var timer *time.Timer
+	arm := func() {
+		if d.schedule == nil {
+			return
+		}
+		next, ok := d.schedule.next(time.Now())
+		if !ok {
+			slog.Warn("--schedule never fires", "schedule", d.spec)
+			return
+		}
+		d.mu.Lock()
+		d.nextRun = next
+		d.mu.Unlock()
+		timer = time.NewTimer(time.Until(next))
+		fire = timer.C
+	}
+	arm()
+	var poll <-chan time.Time
+	if d.poll > 0 {
+		ticker := time.NewTicker(d.poll)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-fire:
+			for _, project := range s.projects {
+				s.scheduledIndex(ctx, project, "schedule")
+			}
+			arm()
+		case <-poll:
+			for _, project := range s.projects {
+				cfg := s.targets[project]
+				head := codegraph.ResolveCommit(ctx, cfg.Path, cfg.Rev)
+				d.mu.Lock()
+				moved := head != "" && head != d.heads[project]
+				d.heads[project], d.polled = head, time.Now().UTC()
+				d.mu.Unlock()
+				if moved {
+					s.scheduledIndex(ctx, project, "commit")
+				}
+			}
+		}
+	}
+}
+
+// scheduledIndex re-indexes project unless it is already being indexed
+func (s *server) scheduledIndex(ctx context.Context, project, reason string) {
+	run, status, ok := s.beginIndex(project)
+	if !ok {
+		slog.Info("skipping re-index, already running", "project", project, "reason", reason, "run", status.RunID)
+		return
+	}
+	slog.Info("scheduled re-index", "project", project, "reason", reason)
+	s.index(ctx, s.targets[project], run, nil)
+}
+
+// schedule returns the project's daemon schedule, or nil outside the daemon
+func (s *server) schedule(project string) *daemonStatus {
+	d := s.daemon
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := &daemonStatus{Schedule: d.spec, NextRun: d.nextRun, LastPolled: d.polled, Head: d.heads[project]}
+	if d.poll > 0 {
+		status.Poll = d.poll.String()
+	}
+	return status
+}
+
+// cronSchedule is a five-field cron expression: minute, hour, day of month,
+// month and day of week, each a set of allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// With either day field *, only the other restricts the day; otherwise
+	// a day matching either one matches, as in cron
+	domAny, dowAny bool
+}
+
+// cronMacros are the shorthand schedules cron accepts
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression. Each field is *, a value, a range a-b
+// or a comma-separated list of them, any followed by /step. Day of week 7
+// is Sunday, like 0; names of months and days are not supported.
+func parseCron(spec string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q: expected 5 fields (minute hour day-of-month month day-of-week), got %d", spec, len(fields))
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%q: field %d: %w", spec, i+1, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField returns the set of values field allows between lo and hi
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+		first, last := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			last = first
+			if isRange {
+				if last, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				last = hi
+			}
+		}
+		if first < lo || last > hi || first > last {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := first; v <= last; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first minute after t that the schedule matches, and
+// false if there is none within five years, such as for February 30
+func (c *cronSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// mcpProtocolVersions are the Model Context Protocol revisions the mcp
+// command speaks, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool is a tool offered to MCP clients. Call receives the tool's
+// arguments and returns what is sent back as JSON text.
+type mcpTool struct {
+	Description string
+	Schema      map[string]any
+	Call        func(ctx context.Context, s *server, args map[string]any) (any, error)
+}
+
+// mcpProjectArg is the optional project argument every tool takes
+var mcpProjectArg = map[string]any{"type": "string", "description": "Project to query, default the first one served"}
+
+// mcpModelArg is the optional model argument of the tools writing notes,
+// recorded as their provenance
+var mcpModelArg = map[string]any{"type": "string", "description": "Model you are, e.g. claude-sonnet-4, recorded with the note so notes can be weighed by who wrote them"}
+
+// mcpProvenance returns the provenance of a note written through an MCP
+// tool: by the client named in initialize, with the model args gives
+func (s *server) mcpProvenance(args map[string]any) codegraph.Provenance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return codegraph.Provenance{Via: codegraph.ViaMCP, Agent: s.mcpClient, Model: argString(args, "model")}
+}
+
+// mcpTools are the tools the mcp command exposes, backed by the same
+// queries as the HTTP API
+var mcpTools = map[string]mcpTool{
+	"search_code_graph": {
+		Description: "Find functions, methods, structs and interfaces whose name contains the query, case-insensitively.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":   map[string]any{"type": "string", "description": "Part of a symbol name"},
+				"kind":    map[string]any{"type": "string", "enum": []string{"Function", "Method", "Struct", "Interface"}},
+				"limit":   map[string]any{"type": "integer", "minimum": 1, "default": 50},
+				"project": mcpProjectArg,
+			},
+			"required": []string{"query"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			kind := argString(args, "kind")
+			if kind != "" && !slices.Contains(codegraph.NodeLabels, kind) {
+				return nil, fmt.Errorf("unknown kind %q", kind)
+			}
+			return s.searchSymbols(ctx, cfg, argString(args, "query"), kind, argInt(args, "limit", 50))
+		},
+	},
+	"get_callers": {
+		Description: "List the functions and methods that call the named function or method, with their files and lines.",
+		Schema:      mcpNameSchema("Function or method name"),
+		Call:        mcpNamedQuery("callers"),
+	},
+	"get_implementations": {
+		Description: "List the structs implementing the named interface, or the interfaces the named struct implements.",
+		Schema:      mcpNameSchema("Interface or struct name"),
+		Call:        mcpNamedQuery("implementations"),
+	},
+	"get_impact": {
+		Description: "Before a risky edit, list what a change to a function, method, struct, interface or file can break: the symbols depending on it through calls, type usage or implementations, with their distance, the files holding them and the tests reaching it.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"name":    map[string]any{"type": "string", "description": "Function, Type.Method, struct or interface name, node key, or file path relative to the project root"},
+				"depth":   map[string]any{"type": "integer", "minimum": 1, "description": "Hops to follow (default the server's --depth)"},
+				"project": mcpProjectArg,
+			},
+			"required": []string{"name"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			if argString(args, "name") == "" {
+				return nil, errors.New("missing name")
+			}
+			cfg.Depth = argInt(args, "depth", cfg.Depth)
+			return parseImpact(ctx, cfg, argString(args, "name"))
+		},
+	},
+	"reindex_path": {
+		Description: "Re-parse the project and rewrite the graph for the Go files under a path, or the whole project if no path is given. Use after editing code.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path":    map[string]any{"type": "string", "description": "File or directory, relative to the project root or absolute"},
+				"project": mcpProjectArg,
+			},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			var files []string
+			if path := argString(args, "path"); path != "" {
+				if files, err = filesUnder(cfg, path); err != nil {
+					return nil, err
+				}
+			}
+			run, status, ok := s.beginIndex(cfg.Project)
+			if !ok {
+				return nil, fmt.Errorf("project %s is already being indexed by run %s", cfg.Project, status.RunID)
+			}
+			return s.index(ctx, cfg, run, files)
+		},
+	},
+	"remember": {
+		Description: "Store a note about functions, methods, structs, files or packages, such as how they behave or why they are written the way they are, so it can be recalled in later sessions. Name what it is about by key with about, or by name with symbol.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"text":       map[string]any{"type": "string", "description": "What to remember, as a sentence that makes sense on its own"},
+				"about":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Keys of the nodes the note is about, e.g. Function:cmd/main.go:run, Function:store.go:*Store.Put, File:cmd/main.go or Package:cmd"},
+				"symbol":     map[string]any{"type": "string", "description": "Name of a function, struct or interface the note is about, or of a method with its receiver type, e.g. run or Store.Put, when its key is not known"},
+				"tags":       map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				"session":    map[string]any{"type": "string", "description": "ID of the session the note is made during, if one was started"},
+				"importance": map[string]any{"type": "number", "minimum": 0, "maximum": 1, "default": codegraph.DefaultImportance, "description": "How much the note matters"},
+				"confidence": map[string]any{"type": "number", "minimum": 0, "maximum": 1, "default": codegraph.DefaultConfidence, "description": "How sure the note is"},
+				"pinned":     map[string]any{"type": "boolean", "description": "Always recall the note first"},
+				"namespace":  map[string]any{"type": "string", "description": "Namespace to keep the note to, e.g. user:alice or team:payments, \"\" to share it (default the first of the server's --namespace)"},
+				"model":      mcpModelArg,
+				"project":    mcpProjectArg,
+			},
+			"required": []string{"text"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			m, err := s.memories()
+			if err != nil {
+				return nil, err
+			}
+			about := argStrings(args, "about")
+			if symbol := argString(args, "symbol"); symbol != "" {
+				key, err := s.symbolKey(ctx, cfg, symbol)
+				if err != nil {
+					return nil, err
+				}
+				about = append(about, key)
+			}
+			if len(about) == 0 {
+				return nil, errors.New("give the keys the note is about, or a symbol")
+			}
+			importance, _ := args["importance"].(float64)
+			confidence, _ := args["confidence"].(float64)
+			pinned, _ := args["pinned"].(bool)
+			namespace, ok := args["namespace"].(string)
+			if !ok {
+				namespace = homeNamespace(cfg.Namespace)
+			}
+			return m.Remember(ctx, cfg.Project, codegraph.Memory{
+				Text:       argString(args, "text"),
+				Tags:       argStrings(args, "tags"),
+				About:      about,
+				Session:    argString(args, "session"),
+				Importance: importance,
+				Confidence: confidence,
+				Pinned:     pinned,
+				Namespace:  namespace,
+				GitState:   resolveGitState(ctx, cfg),
+				Provenance: s.mcpProvenance(args),
+			})
+		},
+	},
+	"recall": {
+		Description: "List the notes remembered about a function, method, struct, file or package, or with tags, pinned notes first and then newest first, or ranked by relevance, importance and confidence. A file also recalls the notes about what is declared in it, and a question keeps the notes sharing its words, most relevant first. Notes of linked projects, such as a shared library, about what the node uses are included with their project.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"about":        map[string]any{"type": "string", "description": "Key of a node, e.g. Function:cmd/main.go:run"},
+				"symbol":       map[string]any{"type": "string", "description": "Name of a function, struct or interface, or of a method with its receiver type, e.g. run or Store.Put"},
+				"file":         map[string]any{"type": "string", "description": "File path relative to the project root, e.g. cmd/main.go"},
+				"question":     map[string]any{"type": "string", "description": "What you want to know, e.g. \"why does Put hold the lock?\""},
+				"tags":         map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Only notes with all of these tags"},
+				"exclude_tags": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Leave out notes with any of these tags, e.g. activity"},
+				"namespaces":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Namespaces to recall from besides the shared notes, [\"*\"] for every one (default the server's --namespace)"},
+				"limit":        map[string]any{"type": "integer", "minimum": 1, "default": 20},
+				"rank":         map[string]any{"type": "boolean", "description": "Rank by relevance, importance and confidence instead of newest first"},
+				"linked":       map[string]any{"type": "boolean", "default": true, "description": "Also recall the notes of linked projects, such as shared libraries, about the nodes linked to"},
+				"project":      mcpProjectArg,
+			},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			m, err := s.memories()
+			if err != nil {
+				return nil, err
+			}
+			q := codegraph.MemoryQuery{
+				About:       argString(args, "about"),
+				File:        argString(args, "file"),
+				Tags:        argStrings(args, "tags"),
+				ExcludeTags: argStrings(args, "exclude_tags"),
+				Namespaces:  recallNamespaces(cfg.Namespace),
+				Question:    argString(args, "question"),
+				Limit:       argInt(args, "limit", 20),
+			}
+			if _, ok := args["namespaces"]; ok {
+				q.Namespaces = recallNamespaces(strings.Join(argStrings(args, "namespaces"), ","))
+			}
+			if symbol := argString(args, "symbol"); symbol != "" {
+				if q.About != "" {
+					return nil, errors.New("give about or symbol, not both")
+				}
+				if q.About, err = s.symbolKey(ctx, cfg, symbol); err != nil {
+					return nil, err
+				}
+			}
+			if rank, _ := args["rank"].(bool); rank {
+				q.Ranking = &codegraph.DefaultRanking
+			}
+			linked, ok := args["linked"].(bool)
+			return recallMemories(ctx, m, cfg.Project, q, linked || !ok)
+		},
+	},
+	"record_decision": {
+		Description: "Record an architectural decision: what was decided about packages, files or functions, why, and the alternatives considered, so the reason the code is built that way can be found next to it.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"title":        map[string]any{"type": "string", "description": "What was decided"},
+				"rationale":    map[string]any{"type": "string", "description": "Why it was decided"},
+				"alternatives": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Options considered instead"},
+				"status":       map[string]any{"type": "string", "enum": codegraph.DecisionStatuses, "default": codegraph.DecisionStatuses[0]},
+				"affects":      map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Keys of the nodes the decision affects, e.g. Package:pkg/store or Function:store.go:*Store.Put"},
+				"session":      map[string]any{"type": "string", "description": "ID of the session the decision is made during, if one was started"},
+				"project":      mcpProjectArg,
+			},
+			"required": []string{"title", "affects"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			d, err := s.decisions()
+			if err != nil {
+				return nil, err
+			}
+			return d.Decide(ctx, cfg.Project, codegraph.Decision{
+				Title:        argString(args, "title"),
+				Rationale:    argString(args, "rationale"),
+				Alternatives: argStrings(args, "alternatives"),
+				Status:       argString(args, "status"),
+				Affects:      argStrings(args, "affects"),
+				Session:      argString(args, "session"),
+				GitState:     resolveGitState(ctx, cfg),
+			})
+		},
+	},
+	"get_decisions": {
+		Description: "List the architectural decisions affecting a function, method, file or package, or the file and package holding it, newest first, to learn why it is built the way it is.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"about":   map[string]any{"type": "string", "description": "Key of a node, e.g. Function:cmd/main.go:run"},
+				"status":  map[string]any{"type": "string", "enum": codegraph.DecisionStatuses},
+				"limit":   map[string]any{"type": "integer", "minimum": 1, "default": 20},
+				"project": mcpProjectArg,
+			},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			d, err := s.decisions()
+			if err != nil {
+				return nil, err
+			}
+			q := codegraph.DecisionQuery{Status: argString(args, "status"), Limit: argInt(args, "limit", 20)}
+			if about := argString(args, "about"); about != "" {
+				q.Affects = codegraph.EnclosingKeys(about)
+			}
+			return d.Decisions(ctx, cfg.Project, q)
+		},
+	},
+	"record_convention": {
+		Description: "Record a coding convention of the project or a preference of the user, such as how errors are handled, which logging library to use or how to name things, for the packages it applies to or the whole project, so it is followed in later sessions. Record one when the user corrects how code is written.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"rule":      map[string]any{"type": "string", "description": "The convention, as an instruction that makes sense on its own, e.g. \"wrap errors with %w and the operation that failed\""},
+				"category":  map[string]any{"type": "string", "enum": codegraph.ConventionCategories, "default": codegraph.ConventionCategories[0]},
+				"rationale": map[string]any{"type": "string", "description": "Why code follows it"},
+				"example":   map[string]any{"type": "string", "description": "Code following it"},
+				"packages":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Paths of the packages it applies to, with those under them, e.g. pkg/store (default the whole project)"},
+				"namespace": map[string]any{"type": "string", "description": "Namespace to keep a preference to, e.g. user:alice, \"\" to share it (default the first of the server's --namespace)"},
+				"project":   mcpProjectArg,
+			},
+			"required": []string{"rule"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			c, err := s.conventions()
+			if err != nil {
+				return nil, err
+			}
+			namespace, ok := args["namespace"].(string)
+			if !ok {
+				namespace = homeNamespace(cfg.Namespace)
+			}
+			return c.AddConvention(ctx, cfg.Project, codegraph.Convention{
+				Category:  argString(args, "category"),
+				Rule:      argString(args, "rule"),
+				Rationale: argString(args, "rationale"),
+				Example:   argString(args, "example"),
+				Packages:  argStrings(args, "packages"),
+				Namespace: namespace,
+			})
+		},
+	},
+	"get_conventions": {
+		Description: "List the coding conventions and user preferences that apply to a package, file or symbol, or to the whole project, by category. Call this before writing or changing code and follow them.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"package":  map[string]any{"type": "string", "description": "Package path relative to the project root, e.g. pkg/store"},
+				"file":     map[string]any{"type": "string", "description": "File path relative to the project root, e.g. cmd/main.go, for the conventions of its package"},
+				"about":    map[string]any{"type": "string", "description": "Key of a node, e.g. Function:cmd/main.go:run, for the conventions of its package"},
+				"category": map[string]any{"type": "string", "enum": codegraph.ConventionCategories},
+				"project":  mcpProjectArg,
+			},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			c, err := s.conventions()
+			if err != nil {
+				return nil, err
+			}
+			q := codegraph.ConventionQuery{Package: argString(args, "package"), Category: argString(args, "category"), Namespaces: recallNamespaces(cfg.Namespace)}
+			if file := argString(args, "file"); file != "" {
+				q.Package = keyPackage("File:" + file)
+			}
+			if about := argString(args, "about"); about != "" {
+				q.Package = keyPackage(about)
+			}
+			return c.Conventions(ctx, cfg.Project, q)
+		},
+	},
+	"summarize_session": {
+		Description: "Record at the end of a session what it did, in a few sentences, about the files it touched or the nodes given, so the next session working on the same packages can pick up where it left off.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"session": map[string]any{"type": "string", "description": "ID of the session, as started with session start"},
+				"text":    map[string]any{"type": "string", "description": "What the session did, and what is left to do"},
+				"about":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Keys of the nodes worked on, e.g. Package:pkg/store (default the files the session touched)"},
+				"project": mcpProjectArg,
+			},
+			"required": []string{"session", "text"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			sum, err := s.summaries()
+			if err != nil {
+				return nil, err
+			}
+			return sum.Summarize(ctx, cfg.Project, codegraph.Summary{
+				Session:  argString(args, "session"),
+				Text:     argString(args, "text"),
+				About:    argStrings(args, "about"),
+				GitState: resolveGitState(ctx, cfg),
+			})
+		},
+	},
+	"get_summaries": {
+		Description: "List the summaries of the last sessions that worked on a package, newest first, to pick up where they left off without replaying everything they recorded.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"package": map[string]any{"type": "string", "description": "Package path, e.g. pkg/store (default every package)"},
+				"limit":   map[string]any{"type": "integer", "minimum": 1, "default": 5},
+				"project": mcpProjectArg,
+			},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			sum, err := s.summaries()
+			if err != nil {
+				return nil, err
+			}
+			return sum.Summaries(ctx, cfg.Project, codegraph.SummaryQuery{Package: argString(args, "package"), Limit: argInt(args, "limit", 5)})
+		},
+	},
+	"get_changes_since": {
+		Description: "Catch up on a project when resuming work: the keys of the files, functions and methods changed since a time or the end of a session, and the memories and decisions recorded since.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"since":   map[string]any{"type": "string", "description": "Time, e.g. 2026-01-02T15:04:05Z, or a duration before now, e.g. 24h"},
+				"session": map[string]any{"type": "string", "description": "ID of the session to catch up from the end of (default the last session to have ended)"},
+				"project": mcpProjectArg,
+			},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			since, err := changesTime(ctx, cfg, s.backend, argString(args, "since"), argString(args, "session"), time.Now().UTC())
+			if err != nil {
+				return nil, err
+			}
+			return readChanges(ctx, cfg, s.backend, since)
+		},
+	},
+	"semantic_search": {
+		Description: "Find the functions, methods and structs whose signatures and doc comments are closest in meaning to a description of what they do, for when their names are not known. Each result has its package, signature, callers and the start of its source. Needs the project indexed with --embed.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":   map[string]any{"type": "string", "description": "What the code does, e.g. \"retry a failed HTTP request with backoff\""},
+				"package": map[string]any{"type": "string", "description": "Only search this package path and below it, e.g. pkg/store"},
+				"limit":   map[string]any{"type": "integer", "minimum": 1, "default": 10},
+				"project": mcpProjectArg,
+			},
+			"required": []string{"query"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			v, err := s.vectorSearcher(cfg)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Package, cfg.Limit, cfg.Callers, cfg.SnippetLines = argString(args, "package"), argInt(args, "limit", 10), 3, 8
+			return searchCode(ctx, cfg, v, argString(args, "query"))
+		},
+	},
+	"similar": {
+		Description: "List the functions, methods and structs whose signatures and doc comments are closest in meaning to a description, with how close each is and nothing else, for a quick look before semantic_search. Needs the project indexed with --embed.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":   map[string]any{"type": "string", "description": "What the code does, e.g. \"retry a failed HTTP request with backoff\""},
+				"limit":   map[string]any{"type": "integer", "minimum": 1, "default": 10},
+				"project": mcpProjectArg,
+			},
+			"required": []string{"query"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			v, err := s.vectorSearcher(cfg)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Limit = argInt(args, "limit", 10)
+			return findSimilar(ctx, cfg, v, argString(args, "query"))
+		},
+	},
+	"forget": {
+		Description: "Delete a remembered note that is no longer true, by its ID, or correct it: given a correction, the note is replaced by one saying that instead, about the same nodes, and the old note is archived as its provenance.",
+		Schema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"id":         map[string]any{"type": "string", "description": "ID of the note, as returned by remember or recall"},
+				"correction": map[string]any{"type": "string", "description": "What the note should say instead, to correct it rather than delete it"},
+				"model":      mcpModelArg,
+				"project":    mcpProjectArg,
+			},
+			"required": []string{"id"},
+		},
+		Call: func(ctx context.Context, s *server, args map[string]any) (any, error) {
+			cfg, err := s.lookup(argString(args, "project"))
+			if err != nil {
+				return nil, err
+			}
+			m, err := s.memories()
+			if err != nil {
+				return nil, err
+			}
+			id := argString(args, "id")
+			if correction := argString(args, "correction"); correction != "" {
+				return codegraph.CorrectMemory(ctx, m, cfg.Project, id, correction, resolveGitState(ctx, cfg), s.mcpProvenance(args))
+			}
+			found, err := m.Forget(ctx, cfg.Project, id)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return nil, fmt.Errorf("no memory %q", id)
+			}
+			return map[string]string{"forgotten": id}, nil
+		},
+	},
+}
+
+// mcpNameSchema is the input schema of a tool taking a name
+func mcpNameSchema(description string) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name":    map[string]any{"type": "string", "description": description},
+			"project": mcpProjectArg,
+		},
+		"required": []string{"name"},
+	}
+}
+
+// mcpNamedQuery returns a tool call running the named query with the name
+// argument
+func mcpNamedQuery(name string) func(context.Context, *server, map[string]any) (any, error) {
+	return func(ctx context.Context, s *server, args map[string]any) (any, error) {
+		cfg, err := s.lookup(argString(args, "project"))
+		if err != nil {
+			return nil, err
+		}
+		if argString(args, "name") == "" {
+			return nil, errors.New("missing name")
+		}
+		return s.runNamed(ctx, cfg, namedQueries[name], argString(args, "name"))
+	}
+}
+
+func argString(args map[string]any, key string) string {
+	value, _ := args[key].(string)
+	return value
+}
+
+// argStrings reads a list of strings, skipping items of other types
+func argStrings(args map[string]any, key string) []string {
+	items, _ := args[key].([]any)
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+func argInt(args map[string]any, key string, defaultValue int) int {
+	if value, ok := args[key].(float64); ok && value >= 1 {
+		return int(value)
+	}
+	return defaultValue
+}
+
+// filesUnder lists the source files at or below path in cfg's project,
+// relative to its root. A path that no longer exists is returned as is, so
+// its nodes are removed.
+func filesUnder(cfg Config, path string) ([]string, error) {
+	root, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside project %s", path, cfg.Project)
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return []string{rel}, nil
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		return []string{rel}, nil
+	}
+
+	files := []string{}
+	err = cfg.Filter.Walk(root, path, func(_, rel string, info fs.FileInfo) error {
+		if !info.IsDir() && cfg.Filter.Includes(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// runMCP serves the Model Context Protocol on stdio, or over HTTP with
+// server-sent events on --listen, until ctx is cancelled or stdin closes
+func runMCP(ctx context.Context, cfg Config, targets []target) error {
+	if cfg.Backend != "neo4j" && cfg.Backend != "falkordb" {
+		return fmt.Errorf("mcp needs --backend neo4j or falkordb")
+	}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	s := newServer(ctx, cfg, targets, backend)
+
+	switch cfg.Transport {
+	case "stdio":
+		return serveMCPStdio(ctx, s, os.Stdin, os.Stdout)
+	case "sse":
+		return serveMCPSSE(ctx, s, cfg.Listen)
+	default:
+		return fmt.Errorf("unknown transport %q, expected stdio or sse", cfg.Transport)
+	}
+}
+
+// serveMCPStdio reads newline-delimited JSON-RPC messages from r and writes
+// the responses to w, one request at a time
+func serveMCPStdio(ctx context.Context, s *server, r io.Reader, w io.Writer) error {
+	slog.Info("serving MCP on stdio", "projects", s.projects)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if reply := s.handleRPC(ctx, scanner.Bytes()); reply != nil {
+			if err := enc.Encode(reply); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+// serveMCPSSE serves the HTTP with server-sent events transport: a client
+// opens GET /sse, is told the endpoint to POST its messages to, and receives
+// the responses as events on the stream. Requests from web pages not on
+// this machine are refused, see localOrigin.
+func serveMCPSSE(ctx context.Context, s *server, addr string) error {
+	// sseSession is an open stream: where its replies go, and closed once
+	// the client is gone
+	type sseSession struct {
+		replies chan *rpcMessage
+		done    <-chan struct{}
+	}
+	var mu sync.Mutex
+	sessions := make(map[string]sseSession)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+			return
+		}
+		id := make([]byte, 16)
+		rand.Read(id)
+		session := hex.EncodeToString(id)
+		replies := make(chan *rpcMessage, 16)
+		mu.Lock()
+		sessions[session] = sseSession{replies, r.Context().Done()}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			delete(sessions, session)
+			mu.Unlock()
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprintf(w, "event: endpoint\ndata: /message?sessionId=%s\n\n", session)
+		flusher.Flush()
+		for {
+			select {
+			case reply := <-replies:
+				data, _ := json.Marshal(reply)
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	mux.HandleFunc("POST /message", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		session, ok := sessions[r.URL.Query().Get("sessionId")]
+		mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("unknown session"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16*1024*1024))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			if reply := s.handleRPC(ctx, body); reply != nil {
+				select {
+				case session.replies <- reply:
+				case <-session.done:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           localOnly(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServe() }()
+	slog.Info("serving MCP over SSE", "url", "http://"+addr+"/sse", "projects", s.projects)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	// A client may hold a connection open without sending a request, which
+	// Shutdown waits for as long as it gives itself; it is closed instead
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return srv.Close()
+}
+
+// localOnly refuses requests from web pages not on this machine, see
+// localOrigin
+func localOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localOrigin(r) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("origin %s not allowed", r.Header.Get("Origin")))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// localOrigin reports whether r comes from a web page on this machine or
+// from no page at all. Browsers send the Origin of the page making a
+// request, which must then name a loopback host, so a site cannot reach a
+// local server by DNS rebinding its own name to 127.0.0.1.
+func localOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleRPC answers one JSON-RPC message, returning nil for notifications
+func (s *server) handleRPC(ctx context.Context, data []byte) *rpcMessage {
+	var msg rpcMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return &rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: -32700, Message: err.Error()}}
+	}
+	if msg.ID == nil {
+		return nil
+	}
+	reply := &rpcMessage{JSONRPC: "2.0", ID: msg.ID}
+	result, err := s.callRPC(ctx, msg.Method, msg.Params)
+	if err != nil {
+		reply.Error = err
+	} else {
+		reply.Result = result
+	}
+	return reply
+}
+
+// callRPC runs an MCP method
+func (s *server) callRPC(ctx context.Context, method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		var req struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			ClientInfo      struct {
+				Name string `json:"name"`
+			} `json:"clientInfo"`
+		}
+		json.Unmarshal(params, &req)
+		s.mu.Lock()
+		s.mcpClient = req.ClientInfo.Name
+		s.mu.Unlock()
+		version := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, req.ProtocolVersion) {
+			version = req.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "codegraph", "version": "1.0.0"},
+			"instructions": "Go code graph of projects " + strings.Join(s.projects, ", ") + ": symbols, calls, implementations and file impact." +
+				" Call get_conventions before writing code in a package, and follow the conventions it lists.",
+		}, nil
+
+	case "ping":
+		return map[string]any{}, nil
+
+	case "tools/list":
+		tools := make([]map[string]any, 0, len(mcpTools))
+		for _, name := range slices.Sorted(maps.Keys(mcpTools)) {
+			tools = append(tools, map[string]any{
+				"name":        name,
+				"description": mcpTools[name].Description,
+				"inputSchema": mcpTools[name].Schema,
+			})
+		}
+		return map[string]any{"tools": tools}, nil
+
+	case "tools/call":
+		var req struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &req); err != nil {
+			return nil, &rpcError{Code: -32602, Message: err.Error()}
+		}
+		tool, ok := mcpTools[req.Name]
+		if !ok {
+			return nil, &rpcError{Code: -32602, Message: fmt.Sprintf("unknown tool %q", req.Name)}
+		}
+		// Tool failures are results the model can read, not protocol errors
+		result, err := tool.Call(ctx, s, req.Arguments)
+		if err != nil {
+			return map[string]any{
+				"content": []any{map[string]any{"type": "text", "text": err.Error()}},
+				"isError": true,
+			}, nil
+		}
+		text, _ := json.MarshalIndent(result, "", "  ")
+		return map[string]any{"content": []any{map[string]any{"type": "text", "text": string(text)}}}, nil
+
+	default:
+		return nil, &rpcError{Code: -32601, Message: fmt.Sprintf("method %q not found", method)}
+	}
+}
+
+// codeGraphService is the gRPC service described by codegraph.proto. Its
+// messages are encoded by hand with protowire, so the script needs no
+// generated code.
+type codeGraphService interface {
+	startIndex(ctx context.Context, req *startIndexRequest) (*grpcIndexStatus, error)
+	streamProgress(req *streamProgressRequest, stream grpc.ServerStream) error
+	query(ctx context.Context, req *grpcQueryRequest) (*grpcQueryResponse, error)
+	snapshotDiff(ctx context.Context, req *snapshotDiffRequest) (*grpcGraphDiff, error)
+}
+
+var codeGraphServiceDesc = grpc.ServiceDesc{
+	ServiceName: "codegraph.v1.CodeGraph",
+	HandlerType: (*codeGraphService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "StartIndex", Handler: grpcUnary("StartIndex", codeGraphService.startIndex)},
+		{MethodName: "Query", Handler: grpcUnary("Query", codeGraphService.query)},
+		{MethodName: "GetSnapshotDiff", Handler: grpcUnary("GetSnapshotDiff", codeGraphService.snapshotDiff)},
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "StreamProgress",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			req := new(streamProgressRequest)
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(codeGraphService).streamProgress(req, stream)
+		},
+	}},
+	Metadata: "codegraph.proto",
+}
+
+// grpcUnary adapts a service method to a unary gRPC handler
+func grpcUnary[Req any, PReq interface {
+	*Req
+	wireDecoder
+}, Resp any](name string, method func(codeGraphService, context.Context, PReq) (Resp, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := PReq(new(Req))
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		call := func(ctx context.Context, req any) (any, error) {
+			return method(srv.(codeGraphService), ctx, req.(PReq))
+		}
+		if interceptor == nil {
+			return call(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/codegraph.v1.CodeGraph/" + name}, call)
+	}
+}
+
+// grpcServer implements codeGraphService on top of the HTTP API's server
+type grpcServer struct {
+	*server
+	cfg Config
+}
+
+// runGRPC serves the gRPC API on --listen until ctx is cancelled
+func runGRPC(ctx context.Context, cfg Config, targets []target) error {
+	if cfg.Backend != "neo4j" && cfg.Backend != "falkordb" {
+		return fmt.Errorf("grpc needs --backend neo4j or falkordb")
+	}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+
+	lis, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(wireCodec{}))
+	srv.RegisterService(&codeGraphServiceDesc, &grpcServer{server: newServer(ctx, cfg, targets, backend), cfg: cfg})
+	stop := context.AfterFunc(ctx, srv.GracefulStop)
+	defer stop()
+	slog.Info("serving gRPC API", "addr", lis.Addr().String(), "projects", projectNames(targets))
+	return srv.Serve(lis)
+}
+
+// projectNames lists the targets' projects
+func projectNames(targets []target) []string {
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.Project
+	}
+	return names
+}
+
+// grpcLookup is lookup with a gRPC status for unknown projects
+func (g *grpcServer) grpcLookup(project string) (Config, error) {
+	cfg, err := g.lookup(project)
+	if err != nil {
+		return cfg, status.Error(codes.NotFound, err.Error())
+	}
+	return cfg, nil
+}
+
+func (g *grpcServer) startIndex(ctx context.Context, req *startIndexRequest) (*grpcIndexStatus, error) {
+	cfg, err := g.grpcLookup(req.Project)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, path := range req.Paths {
+		under, err := filesUnder(cfg, path)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		files = append(files, under...)
+	}
+	if len(req.Paths) > 0 && files == nil {
+		files = []string{}
+	}
+
+	run, current, ok := g.beginIndex(cfg.Project)
+	if !ok {
+		return nil, status.Errorf(codes.AlreadyExists, "project %s is already being indexed by run %s", cfg.Project, current.RunID)
+	}
+	go g.index(g.ctx, cfg, run, files)
+	return &grpcIndexStatus{Project: cfg.Project, indexStatus: current}, nil
+}
+
+// streamProgress polls the project's status, sending it whenever it
+// changes, until a run that was in progress finishes
+func (g *grpcServer) streamProgress(req *streamProgressRequest, stream grpc.ServerStream) error {
+	cfg, err := g.grpcLookup(req.Project)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	var last indexStatus
+	for first := true; ; first = false {
+		current := g.status(cfg.Project)
+		if first || current != last {
+			if err := stream.SendMsg(&grpcIndexStatus{Project: cfg.Project, indexStatus: current}); err != nil {
+				return err
+			}
+		}
+		if !current.Running {
+			return nil
+		}
+		last = current
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (g *grpcServer) query(ctx context.Context, req *grpcQueryRequest) (*grpcQueryResponse, error) {
+	cfg, err := g.grpcLookup(req.Project)
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]any
+	query := req.Cypher
+	if req.Named != "" {
+		named, ok := namedQueries[req.Named]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown named query %q", req.Named)
+		}
+		if named.NameRequired && req.Name == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "query %s needs a name", req.Named)
+		}
+		if req.Depth > 0 {
+			cfg.Depth = int(req.Depth)
+		}
+		query, params = named.text(cfg), map[string]any{"name": req.Name}
+	} else {
+		if strings.TrimSpace(query) == "" {
+			return nil, status.Error(codes.InvalidArgument, "empty query")
+		}
+		list := make([]string, 0, len(req.Params))
+		for name, value := range req.Params {
+			list = append(list, name+"="+value)
+		}
+		if params, err = parseParams(list); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	columns, rows, err := g.querier.Query(ctx, query, params)
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	resp := &grpcQueryResponse{Columns: columns, Rows: make([][]string, len(rows))}
+	for i, row := range rows {
+		resp.Rows[i] = make([]string, len(row))
+		for j, value := range row {
+			data, err := json.Marshal(plainValue(value))
+			if err != nil {
+				data = []byte("null")
+			}
+			resp.Rows[i][j] = string(data)
+		}
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) snapshotDiff(ctx context.Context, req *snapshotDiffRequest) (*grpcGraphDiff, error) {
+	cfg, err := g.grpcLookup(req.Project)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Base = req.Base
+	var driver neo4j.DriverWithContext
+	if nb, ok := g.backend.(*codegraph.Neo4jWriter); ok {
+		driver = nb.Driver
+	} else if cfg.Base == "" {
+		return nil, status.Error(codes.FailedPrecondition, "comparing with the database needs --backend neo4j; give a base")
+	}
+	diff, err := computeDiff(ctx, cfg, driver)
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	return &grpcGraphDiff{diff}, nil
+}
+
+// wireEncoder and wireDecoder are implemented by the gRPC messages, in the
+// protobuf wire format of codegraph.proto
+type wireEncoder interface {
+	appendWire(b []byte) []byte
+}
+
+type wireDecoder interface {
+	decodeWire(b []byte) error
+}
+
+// wireCodec is the gRPC codec for the hand-encoded messages
+type wireCodec struct{}
+
+func (wireCodec) Name() string { return "proto" }
+
+func (wireCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(wireEncoder)
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T", v)
+	}
+	return m.appendWire(nil), nil
+}
+
+func (wireCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(wireDecoder)
+	if !ok {
+		return fmt.Errorf("cannot decode %T", v)
+	}
+	return m.decodeWire(data)
+}
+
+// consumeFields calls field for each field in b with its number and value:
+// v for varints, data for length-delimited fields. Other fields are skipped.
+func consumeFields(b []byte, field func(num protowire.Number, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			data, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field(num, 0, data)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// appendString appends a string field, omitting the proto3 default
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendInt appends an int64 or bool field, omitting the proto3 default
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendMessage appends an embedded message field
+func appendMessage(b []byte, num protowire.Number, m wireEncoder) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.appendWire(nil))
+}
+
+// appendTimestamp appends a google.protobuf.Timestamp field unless t is zero
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	ts = appendInt(ts, 1, t.Unix())
+	ts = appendInt(ts, 2, int64(t.Nanosecond()))
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+type startIndexRequest struct {
+	Project string
+	Paths   []string
+}
+
+func (m *startIndexRequest) decodeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.Project = string(data)
+		case 2:
+			m.Paths = append(m.Paths, string(data))
+		}
+	})
+}
+
+type streamProgressRequest struct {
+	Project string
+}
+
+func (m *streamProgressRequest) decodeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v uint64, data []byte) {
+		if num == 1 {
+			m.Project = string(data)
+		}
+	})
+}
+
+// grpcIndexStatus is an IndexStatus message
+type grpcIndexStatus struct {
+	Project string
+	indexStatus
+}
+
+func (m *grpcIndexStatus) appendWire(b []byte) []byte {
+	b = appendString(b, 1, m.Project)
+	b = appendString(b, 2, m.RunID)
+	if m.Running {
+		b = appendInt(b, 3, 1)
+	}
+	b = appendString(b, 4, m.Step)
+	b = appendInt(b, 5, int64(m.Done))
+	b = appendInt(b, 6, int64(m.Total))
+	b = appendString(b, 7, m.Unit)
+	b = appendTimestamp(b, 8, m.StartedAt)
+	b = appendTimestamp(b, 9, m.FinishedAt)
+	b = appendInt(b, 10, int64(m.Files))
+	b = appendInt(b, 11, int64(m.Nodes))
+	b = appendString(b, 12, m.Error)
+	return appendString(b, 13, m.Commit)
+}
+
+type grpcQueryRequest struct {
+	Project string
+	Cypher  string
+	Named   string
+	Name    string
+	Depth   int32
+	Params  map[string]string
+}
+
+func (m *grpcQueryRequest) decodeWire(b []byte) error {
+	var entryErr error
+	err := consumeFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.Project = string(data)
+		case 2:
+			m.Cypher = string(data)
+		case 3:
+			m.Named = string(data)
+		case 4:
+			m.Name = string(data)
+		case 5:
+			m.Depth = int32(v)
+		case 6:
+			// Map entries are messages of key = 1, value = 2
+			var key, value string
+			if err := consumeFields(data, func(num protowire.Number, v uint64, data []byte) {
+				switch num {
+				case 1:
+					key = string(data)
+				case 2:
+					value = string(data)
+				}
+			}); err != nil {
+				entryErr = err
+			}
+			if m.Params == nil {
+				m.Params = make(map[string]string)
+			}
+			m.Params[key] = value
+		}
+	})
+	return cmp.Or(err, entryErr)
+}
+
+type grpcQueryResponse struct {
+	Columns []string
+	Rows    [][]string
+}
+
+func (m *grpcQueryResponse) appendWire(b []byte) []byte {
+	for _, column := range m.Columns {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, column)
+	}
+	for _, row := range m.Rows {
+		var r []byte
+		for _, value := range row {
+			r = protowire.AppendTag(r, 1, protowire.BytesType)
+			r = protowire.AppendString(r, value)
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, r)
+	}
+	return b
+}
+
+type snapshotDiffRequest struct {
+	Project string
+	Base    string
+}
+
+func (m *snapshotDiffRequest) decodeWire(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.Project = string(data)
+		case 2:
+			m.Base = string(data)
+		}
+	})
+}
+
+// grpcGraphDiff is a GraphDiff message
+type grpcGraphDiff struct {
+	GraphDiff
+}
+
+func (m *grpcGraphDiff) appendWire(b []byte) []byte {
+	b = appendMessage(b, 1, grpcSymbolDiff(m.Functions))
+	b = appendMessage(b, 2, grpcSymbolDiff(m.Structs))
+	b = appendMessage(b, 3, grpcSymbolDiff(m.Interfaces))
+	return appendMessage(b, 4, grpcSymbolDiff(m.Relationships))
+}
+
+// grpcSymbolDiff is a SymbolDiff message
+type grpcSymbolDiff SymbolDiff
+
+func (m grpcSymbolDiff) appendWire(b []byte) []byte {
+	for _, key := range m.Added {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+	}
+	for _, key := range m.Removed {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+	}
+	for _, changed := range m.Changed {
+		var c []byte
+		c = appendString(c, 1, changed.Key)
+		c = appendString(c, 2, changed.Before)
+		c = appendString(c, 3, changed.After)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, c)
+	}
+	return b
+}
+
+// keyMatch returns a MATCH binding n to the node with key, as produced by
+// the Key methods, and its parameters
+func keyMatch(cfg Config, key string) (string, map[string]any, error) {
+	kind, params, err := codegraph.ParseKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	var conditions []string
+	for _, prop := range slices.Sorted(maps.Keys(params)) {
+		conditions = append(conditions, fmt.Sprintf("n.%s = $%s", prop, prop))
+	}
+	return fmt.Sprintf("MATCH (n:%s:%s) WHERE %s", cfg.Project, cfg.Labels.Label(kind), strings.Join(conditions, " AND ")), params, nil
+}
+
+// newAPINode builds a node from the labels and properties columns of a row
+func newAPINode(labelMap codegraph.LabelMap, labels, props any) apiNode {
+	propMap := resultMap(props)
+	node := apiNode{Key: nodeKeyFromProps(labelMap, labels, propMap), Properties: propMap}
+	labelList, _ := labels.([]any)
+	for _, label := range labelList {
+		name, _ := label.(string)
+		if kind := labelMap.Kind(name); kind != "" {
+			node.Label = kind
+		}
+	}
+	return node
+}
+
+// resultMap returns a map value from a query result: a map from Neo4j, or
+// the flat key, value list FalkorDB replies with
+func resultMap(value any) map[string]any {
+	switch v := value.(type) {
+	case map[string]any:
+		return plainValue(v).(map[string]any)
+	case []any:
+		m := make(map[string]any, len(v)/2)
+		for i := 0; i+1 < len(v); i += 2 {
+			if key, ok := v[i].(string); ok {
+				m[key] = plainValue(v[i+1])
+			}
+		}
+		return m
+	default:
+		return map[string]any{}
+	}
+}
+
+// queryInt reads a positive integer query parameter
+func queryInt(q url.Values, name string, defaultValue int) (int, error) {
+	value := q.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// ProjectStats describes what the database holds for one project, as
+// reported by the stats command
+type ProjectStats struct {
+	Project       string         `json:"project"`
+	Nodes         map[string]int `json:"nodes"`
+	Relationships map[string]int `json:"relationships"`
+	Deleted       int            `json:"deleted,omitempty"`
+	LastIndexed   time.Time      `json:"lastIndexed,omitzero"`
+	RunID         string         `json:"runId,omitempty"`
+	Commit        string         `json:"commit,omitempty"`
+	Packages      []PackageStats `json:"largestPackages"`
+	Warnings      []string       `json:"warnings"`
+}
+
+// PackageStats is the size of one stored package
+type PackageStats struct {
+	Path    string `json:"path"`
+	Files   int    `json:"files"`
+	Symbols int    `json:"symbols"`
+}
+
+// runStats reads cfg.Project back from the database and prints its counts,
+// when and at which commit it was last indexed, its largest packages and
+// anything suggesting the graph is out of date. Nothing is parsed.
+func runStats(ctx context.Context, cfg Config) error {
+	driver, err := newNeo4jDriver(cfg)
+	if err != nil {
+		return withExit(exitConnection, fmt.Errorf("connecting to Neo4j: %w", err))
+	}
+	defer driver.Close(ctx)
+
+	stats, err := loadStats(ctx, driver, cfg.Project, cfg.Labels, cfg.StatsTop)
+	if err != nil {
+		return err
+	}
+	stats.Warnings = staleness(ctx, cfg, stats)
+	printStats(os.Stdout, stats)
+	summary.addProject(statsSummary(stats))
+	for _, warning := range stats.Warnings {
+		summary.addMessage(slog.LevelWarn, warning)
+	}
+	return nil
+}
+
+// statsSummary counts the stored project for the summary
+func statsSummary(stats ProjectStats) projectSummary {
+	result := projectSummary{
+		Project:    stats.Project,
+		Run:        stats.RunID,
+		Commit:     stats.Commit,
+		Files:      stats.Nodes["File"],
+		Packages:   stats.Nodes["Package"],
+		Functions:  stats.Nodes["Function"] + stats.Nodes["Method"],
+		Structs:    stats.Nodes["Struct"],
+		Interfaces: stats.Nodes["Interface"],
+	}
+	for _, n := range stats.Nodes {
+		result.Nodes += n
+	}
+	for _, n := range stats.Relationships {
+		result.Relationships += n
+	}
+	return result
+}
+
+// loadStats counts the project's nodes and relationships and finds its most
+// recent write and its top largest packages by symbol count
+func loadStats(ctx context.Context, driver neo4j.DriverWithContext, project string, labels codegraph.LabelMap, top int) (ProjectStats, error) {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	stats := ProjectStats{
+		Project:       project,
+		Nodes:         make(map[string]int),
+		Relationships: make(map[string]int),
+		Packages:      []PackageStats{},
+	}
+
+	result, err := session.Run(ctx, fmt.Sprintf(`
+		MATCH (n:%s)
+		UNWIND labels(n) AS label
+		RETURN label, coalesce(n.deleted, false) AS deleted, count(*) AS count
+	`, project), nil)
+	if err != nil {
+		return stats, fmt.Errorf("counting nodes: %w", err)
+	}
+	for result.Next(ctx) {
+		record := result.Record().AsMap()
+		label := codegraph.PropString(record, "label")
+		count, _ := record["count"].(int64)
+		if label == project {
+			if deleted, _ := record["deleted"].(bool); deleted {
+				stats.Deleted += int(count)
+			}
+			continue
+		}
+		if deleted, _ := record["deleted"].(bool); deleted {
+			continue
+		}
+		if kind := labels.Kind(label); kind != "" {
+			label = kind
+		}
+		stats.Nodes[label] += int(count)
+	}
+	if err := result.Err(); err != nil {
+		return stats, fmt.Errorf("counting nodes: %w", err)
+	}
+
+	result, err = session.Run(ctx, fmt.Sprintf(`
+		MATCH (:%s)-[r]->(:%s) WHERE NOT coalesce(r.deleted, false)
+		RETURN type(r) AS type, count(*) AS count
+	`, project, project), nil)
+	if err != nil {
+		return stats, fmt.Errorf("counting relationships: %w", err)
+	}
+	for result.Next(ctx) {
+		record := result.Record().AsMap()
+		count, _ := record["count"].(int64)
+		stats.Relationships[codegraph.PropString(record, "type")] = int(count)
+	}
+	if err := result.Err(); err != nil {
+		return stats, fmt.Errorf("counting relationships: %w", err)
+	}
+
+	result, err = session.Run(ctx, fmt.Sprintf(`
+		MATCH (n:%s) WHERE n.updatedAt IS NOT NULL
+		RETURN n.updatedAt AS updatedAt, n.runId AS runId, n.commit AS commit
+		ORDER BY n.updatedAt DESC LIMIT 1
+	`, project), nil)
+	if err != nil {
+		return stats, fmt.Errorf("finding last write: %w", err)
+	}
+	if result.Next(ctx) {
+		record := result.Record().AsMap()
+		stats.LastIndexed, _ = record["updatedAt"].(time.Time)
+		stats.RunID = codegraph.PropString(record, "runId")
+		stats.Commit = codegraph.PropString(record, "commit")
+	}
+	if err := result.Err(); err != nil {
+		return stats, fmt.Errorf("finding last write: %w", err)
+	}
+
+	result, err = session.Run(ctx, labels.Rewrite(fmt.Sprintf(`
+		MATCH (p:%[1]s:Package)<-[:BELONGS_TO]-(f:%[1]s:File)
+		WHERE NOT coalesce(p.deleted, false) AND NOT coalesce(f.deleted, false)
+		OPTIONAL MATCH (f)-[:CONTAINS]->(s) WHERE NOT coalesce(s.deleted, false)
+		WITH p, count(DISTINCT f) AS files, count(s) AS symbols
+		RETURN p.path AS path, files, symbols
+		ORDER BY symbols DESC, path LIMIT $top
+	`, project)), map[string]any{"top": top})
+	if err != nil {
+		return stats, fmt.Errorf("sizing packages: %w", err)
+	}
+	for result.Next(ctx) {
+		record := result.Record().AsMap()
+		files, _ := record["files"].(int64)
+		symbols, _ := record["symbols"].(int64)
+		stats.Packages = append(stats.Packages, PackageStats{Path: codegraph.PropString(record, "path"), Files: int(files), Symbols: int(symbols)})
+	}
+	if err := result.Err(); err != nil {
+		return stats, fmt.Errorf("sizing packages: %w", err)
+	}
+
+	return stats, nil
+}
+
+// staleness compares the stored project with the source tree at cfg.Path:
+// commits made since the indexed one, source files modified after the last
+// write, and writes older than --stale-after
+func staleness(ctx context.Context, cfg Config, stats ProjectStats) []string {
+	warnings := []string{}
+	if stats.LastIndexed.IsZero() {
+		return append(warnings, "project has not been indexed")
+	}
+
+	if age := time.Since(stats.LastIndexed); cfg.StaleAfter > 0 && age > cfg.StaleAfter {
+		warnings = append(warnings, fmt.Sprintf("last indexed %s ago", age.Round(time.Minute)))
+	}
+
+	switch head := codegraph.ResolveCommit(ctx, cfg.Path, ""); {
+	case head == "":
+	case stats.Commit == "":
+		warnings = append(warnings, "indexed commit was not recorded")
+	case stats.Commit != head:
+		out, err := exec.CommandContext(ctx, "git", "-C", cfg.Path, "rev-list", "--count", stats.Commit+"..HEAD").Output()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("indexed commit %.12s is not an ancestor of HEAD", stats.Commit))
+		} else if n := strings.TrimSpace(string(out)); n != "0" {
+			warnings = append(warnings, fmt.Sprintf("%s commits since indexed commit %.12s", n, stats.Commit))
+		}
+	}
+
+	modified := 0
+	cfg.Filter.Walk(cfg.Path, cfg.Path, func(_, rel string, info fs.FileInfo) error {
+		if !info.IsDir() && cfg.Filter.Includes(rel) && info.ModTime().After(stats.LastIndexed) {
+			modified++
+		}
+		return nil
+	})
+	if modified > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d source files modified since last indexed", modified))
+	}
+	return warnings
+}
+
+// printStats writes the stats as tables to w
+func printStats(w io.Writer, stats ProjectStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "project\t%s\n", stats.Project)
+	if !stats.LastIndexed.IsZero() {
+		fmt.Fprintf(tw, "last indexed\t%s (%s ago)\n", stats.LastIndexed.Local().Format(time.DateTime),
+			time.Since(stats.LastIndexed).Round(time.Second))
+		fmt.Fprintf(tw, "run\t%s\n", stats.RunID)
+	}
+	if stats.Commit != "" {
+		fmt.Fprintf(tw, "commit\t%s\n", stats.Commit)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "nodes\t")
+	for _, label := range slices.Sorted(maps.Keys(stats.Nodes)) {
+		fmt.Fprintf(tw, "  %s\t%d\n", label, stats.Nodes[label])
+	}
+	if stats.Deleted > 0 {
+		fmt.Fprintf(tw, "  deleted\t%d\n", stats.Deleted)
+	}
+	fmt.Fprintln(tw, "relationships\t")
+	for _, typ := range slices.Sorted(maps.Keys(stats.Relationships)) {
+		fmt.Fprintf(tw, "  %s\t%d\n", typ, stats.Relationships[typ])
+	}
+	tw.Flush()
+
+	if len(stats.Packages) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "files\tsymbols\t  largest packages")
+		for _, pkg := range stats.Packages {
+			fmt.Fprintf(tw, "%d\t%d\t  %s\n", pkg.Files, pkg.Symbols, pkg.Path)
+		}
+		tw.Flush()
+	}
+
+	if len(stats.Warnings) > 0 {
+		fmt.Fprintln(w)
+		for _, warning := range stats.Warnings {
+			fmt.Fprintf(w, "warning: %s\n", warning)
+		}
+	}
+}
+
+// withManager opens the backend and runs fn with it, if it can manage
+// stored projects
+func withManager(ctx context.Context, cfg Config, fn func(codegraph.Manager) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	m, ok := backend.(codegraph.Manager)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot manage stored projects; use neo4j, falkordb or sqlite", cfg.Backend))
+	}
+	return fn(m)
+}
+
+// runSnapshots prints the runs whose writes the project still holds
+func runSnapshots(ctx context.Context, cfg Config, m codegraph.Manager) error {
+	snapshots, err := m.Snapshots(ctx, cfg.Project)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		slog.Warn("project holds no nodes", "project", cfg.Project)
+		return nil
+	}
+	printSnapshots(os.Stdout, cfg.Project, snapshots)
+	return nil
+}
+
+// printSnapshots lists snapshots as a table under the project's name
+func printSnapshots(w io.Writer, project string, snapshots []codegraph.Snapshot) {
+	fmt.Fprintf(w, "project %s\n", project)
+	fmt.Fprintf(w, "  %-26s  %-20s  %-12s  %7s  %7s\n", "run", "written", "commit", "nodes", "deleted")
+	for _, s := range snapshots {
+		written := "-"
+		if !s.Written.IsZero() {
+			written = s.Written.UTC().Format(time.DateTime)
+		}
+		commit := cmp.Or(s.Commit, "-")
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		fmt.Fprintf(w, "  %-26s  %-20s  %-12s  %7d  %7d\n", s.RunID, written, commit, s.Nodes, s.Deleted)
+	}
+}
+
+// runPrune removes what stopped being current more than --days ago
+func runPrune(ctx context.Context, cfg Config, m codegraph.Manager) error {
+	if cfg.PruneDays < 0 {
+		return withExit(exitUsage, fmt.Errorf("--days must not be negative, got %d", cfg.PruneDays))
+	}
+	before := time.Now().AddDate(0, 0, -cfg.PruneDays)
+	removed, err := m.Prune(ctx, cfg.Project, before)
+	if err != nil {
+		return err
+	}
+	slog.Info("pruned project", "project", cfg.Project, "before", before.UTC().Format(time.DateOnly), "removed", removed)
+	return nil
+}
+
+// runWipe deletes the project, or with no --yes reports how many nodes it
+// would delete and fails
+func runWipe(ctx context.Context, cfg Config, m codegraph.Manager) error {
+	if !cfg.Yes {
+		snapshots, err := m.Snapshots(ctx, cfg.Project)
+		if err != nil {
+			return err
+		}
+		nodes := 0
+		for _, s := range snapshots {
+			nodes += s.Nodes + s.Deleted
+		}
+		return withExit(exitUsage, fmt.Errorf("wipe would delete the %d nodes of project %s; pass --yes to delete them", nodes, cfg.Project))
+	}
+	removed, err := m.DeleteProject(ctx, cfg.Project)
+	if err != nil {
+		return err
+	}
+	slog.Info("wiped project", "project", cfg.Project, "nodes", removed)
+	return nil
+}
+
+// withMemories opens the backend and runs fn with it, if it can keep
+// memories
+func withMemories(ctx context.Context, cfg Config, fn func(codegraph.MemoryStore) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	m, ok := backend.(codegraph.MemoryStore)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep memories; use neo4j or falkordb", cfg.Backend))
+	}
+	return fn(m)
+}
+
+// runRemember stores text as a memory about the --about nodes
+func runRemember(ctx context.Context, cfg Config, m codegraph.MemoryStore, text string) error {
+	if text == "" || len(cfg.About) == 0 {
+		return withExit(exitUsage, errors.New("remember needs the memory's text and at least one --about key"))
+	}
+	if cfg.TTL < 0 {
+		return withExit(exitUsage, fmt.Errorf("--ttl must not be negative, got %s", cfg.TTL))
+	}
+	memory := codegraph.Memory{
+		Text: text, Tags: cfg.Tags, About: cfg.About, Session: cfg.Session,
+		Importance: cfg.Importance, Confidence: cfg.Confidence, Pinned: cfg.Pin,
+		Namespace: homeNamespace(cfg.Namespace), GitState: resolveGitState(ctx, cfg),
+		Provenance: cfg.provenance(codegraph.ViaCLI),
+	}
+	if cfg.TTL > 0 {
+		memory.ExpiresAt = time.Now().Add(cfg.TTL)
+	}
+	memory, err := m.Remember(ctx, cfg.Project, memory)
+	if errors.Is(err, codegraph.ErrInvalidMemory) {
+		return withExit(exitUsage, err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(memory.ID)
+	return nil
+}
+
+// resolveGitState returns the commit and branch checked out in cfg.Path,
+// the current directory if empty, and the pull request --pr or, with $GITHUB_TOKEN set, the branch's open one
+// on GitHub. A failed lookup is only logged, as the memory or decision is
+// worth keeping without it.
+func resolveGitState(ctx context.Context, cfg Config) codegraph.GitState {
+	state := codegraph.ResolveGitState(ctx, cfg.Path)
+	state.PR = cfg.PR
+	token := os.Getenv("GITHUB_TOKEN")
+	if state.PR != 0 || state.Branch == "" || token == "" {
+		return state
+	}
+	repo := cmp.Or(os.Getenv("GITHUB_REPOSITORY"), codegraph.GitHubRepo(ctx, cfg.Path))
+	if repo == "" {
+		return state
+	}
+	g := &codegraph.GitHub{BaseURL: os.Getenv("GITHUB_API_URL"), Repo: repo, Token: token}
+	pr, err := g.PullRequest(ctx, state.Branch)
+	if err != nil {
+		slog.Warn("could not look up the pull request", "repo", repo, "branch", state.Branch, "err", err)
+		return state
+	}
+	state.PR = pr
+	return state
+}
+
+// formatGitState describes the commit, branch and pull request of state,
+// or returns "" if its commit is unknown
+func formatGitState(state codegraph.GitState) string {
+	if state.Commit == "" {
+		return ""
+	}
+	text := state.Commit[:min(len(state.Commit), 12)]
+	if state.Branch != "" {
+		text += " on " + state.Branch
+	}
+	if state.PR != 0 {
+		text += fmt.Sprintf(" (#%d)", state.PR)
+	}
+	return text
+}
+
+// runRecall prints the memories about --about, tagged every --tag and no
+// --exclude-tag, written with the --provenance, shared or in the
+// --namespace namespaces, and with --linked those of linked projects
+func runRecall(ctx context.Context, cfg Config, m codegraph.MemoryStore, w io.Writer) error {
+	if len(cfg.About) > 1 || cfg.Limit < 0 {
+		return withExit(exitUsage, errors.New("recall takes at most one --about, and a --limit of 0 or more"))
+	}
+	provenance, err := codegraph.ParseProvenance(cfg.Provenance)
+	if err != nil {
+		return withExit(exitUsage, err)
+	}
+	trust, err := codegraph.ParseTrust(cfg.Trust)
+	if err != nil {
+		return withExit(exitUsage, err)
+	}
+	q := codegraph.MemoryQuery{
+		Tags: cfg.Tags, ExcludeTags: cfg.ExcludeTags, Namespaces: recallNamespaces(cfg.Namespace),
+		Limit: cfg.Limit, All: cfg.All, Provenance: provenance,
+	}
+	if cfg.Rank || len(trust) > 0 {
+		ranking := codegraph.DefaultRanking
+		ranking.Trust = trust
+		q.Ranking = &ranking
+	}
+	if len(cfg.About) > 0 {
+		q.About = cfg.About[0]
+	}
+	memories, err := recallMemories(ctx, m, cfg.Project, q, cfg.Linked)
+	if err != nil {
+		return err
+	}
+	if len(memories) == 0 {
+		slog.Warn("no memories", "project", cfg.Project, "about", q.About, "tags", q.Tags, "namespace", cfg.Namespace)
+		return nil
+	}
+	printMemories(w, memories)
+	return nil
+}
+
+// printMemories prints each memory's ID, time, tags, namespace and
+// project if recalled through a link, the keys it is about and mentions,
+// where it came from and its text, indented. Importance and confidence are
+// shown when not the defaults.
+func printMemories(w io.Writer, memories []codegraph.Memory) {
+	for i, memory := range memories {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s  %s", memory.ID, memory.CreatedAt.UTC().Format(time.DateTime))
+		if len(memory.Tags) > 0 {
+			fmt.Fprintf(w, "  [%s]", strings.Join(memory.Tags, ", "))
+		}
+		if !memory.ExpiresAt.IsZero() {
+			fmt.Fprintf(w, "  expires %s", memory.ExpiresAt.UTC().Format(time.DateTime))
+		}
+		if memory.Archived {
+			fmt.Fprint(w, "  archived")
+		}
+		if memory.Pinned {
+			fmt.Fprint(w, "  pinned")
+		}
+		if memory.Namespace != "" {
+			fmt.Fprintf(w, "  in %s", memory.Namespace)
+		}
+		if memory.Project != "" {
+			fmt.Fprintf(w, "  from %s", memory.Project)
+		}
+		if importance := cmp.Or(memory.Importance, codegraph.DefaultImportance); importance != codegraph.DefaultImportance {
+			fmt.Fprintf(w, "  importance %.2g", importance)
+		}
+		if confidence := cmp.Or(memory.Confidence, codegraph.DefaultConfidence); confidence != codegraph.DefaultConfidence {
+			fmt.Fprintf(w, "  confidence %.2g", confidence)
+		}
+		fmt.Fprintf(w, "\n  about %s\n", strings.Join(memory.About, ", "))
+		if len(memory.Mentions) > 0 {
+			fmt.Fprintf(w, "  mentions %s\n", strings.Join(memory.Mentions, ", "))
+		}
+		if len(memory.Sources) > 0 {
+			fmt.Fprintf(w, "  consolidates %s\n", strings.Join(memory.Sources, ", "))
+		}
+		if at := formatGitState(memory.GitState); at != "" {
+			fmt.Fprintf(w, "  at %s\n", at)
+		}
+		if from := formatProvenance(memory.Provenance); from != "" {
+			fmt.Fprintf(w, "  %s\n", from)
+		}
+		for line := range strings.SplitSeq(strings.TrimRight(memory.Text, "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}
+
+// formatProvenance describes who or what wrote a memory, such as "via mcp,
+// agent claude-code", or returns "" if unknown
+func formatProvenance(p codegraph.Provenance) string {
+	var parts []string
+	for _, field := range []struct{ name, value string }{{"via", p.Via}, {"agent", p.Agent}, {"model", p.Model}, {"user", p.User}} {
+		if field.value != "" {
+			parts = append(parts, field.name+" "+field.value)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runForget deletes the memory with the given ID, failing if there is
+// none, or with --provenance every memory written with it
+func runForget(ctx context.Context, cfg Config, m codegraph.MemoryStore, id string) error {
+	if len(cfg.Provenance) > 0 {
+		if id != "" {
+			return withExit(exitUsage, errors.New("forget takes either the ID of a memory or --provenance, not both"))
+		}
+		return purgeMemories(ctx, cfg, m)
+	}
+	if id == "" {
+		return withExit(exitUsage, errors.New("forget needs the ID of a memory or a --provenance"))
+	}
+	found, err := m.Forget(ctx, cfg.Project, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no memory %q in project %s", id, cfg.Project)
+	}
+	slog.Info("forgot memory", "project", cfg.Project, "id", id)
+	return nil
+}
+
+// purgeMemories deletes every memory, expired and archived ones too,
+// written with the --provenance, or with --dry-run logs each one instead
+func purgeMemories(ctx context.Context, cfg Config, m codegraph.MemoryStore) error {
+	provenance, err := codegraph.ParseProvenance(cfg.Provenance)
+	if err != nil {
+		return withExit(exitUsage, err)
+	}
+	memories, err := m.Recall(ctx, cfg.Project, codegraph.MemoryQuery{All: true, Peek: true, Provenance: provenance})
+	if err != nil {
+		return err
+	}
+	forgot := 0
+	for _, memory := range memories {
+		if cfg.DryRun {
+			slog.Info("would forget memory", "project", cfg.Project, "id", memory.ID, "text", memory.Text)
+			continue
+		}
+		found, err := m.Forget(ctx, cfg.Project, memory.ID)
+		if err != nil {
+			return fmt.Errorf("forgetting %s: %w", memory.ID, err)
+		}
+		if found {
+			forgot++
+		}
+	}
+	if !cfg.DryRun {
+		slog.Info("forgot memories", "project", cfg.Project, "provenance", cfg.Provenance, "memories", forgot)
+	}
+	return nil
+}
+
+// runPin pins the memory with the given ID, or unpins it with --unpin,
+// failing if there is none
+func runPin(ctx context.Context, cfg Config, m codegraph.MemoryStore, id string) error {
+	if id == "" {
+		return withExit(exitUsage, errors.New("pin needs the ID of a memory"))
+	}
+	found, err := m.PinMemory(ctx, cfg.Project, id, !cfg.Unpin)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no memory %q in project %s", id, cfg.Project)
+	}
+	action := "pinned memory"
+	if cfg.Unpin {
+		action = "unpinned memory"
+	}
+	slog.Info(action, "project", cfg.Project, "id", id)
+	return nil
+}
+
+// runCapture remembers the activity in the hook event read from r, about
+// the files under the first --path, $CLAUDE_PROJECT_DIR or the event's
+// working directory. Activity on files outside the project or not in the
+// graph is skipped, so the hook never fails the tool call over it. Without
+// --session the activity is part of the session started for the event's
+// session_id.
+func runCapture(ctx context.Context, cfg Config, m codegraph.MemoryStore, r io.Reader) error {
+	if cfg.TTL < 0 {
+		return withExit(exitUsage, fmt.Errorf("--ttl must not be negative, got %s", cfg.TTL))
+	}
+	var event codegraph.HookEvent
+	if err := json.NewDecoder(r).Decode(&event); err != nil {
+		return withExit(exitUsage, fmt.Errorf("reading hook event: %w", err))
+	}
+	root := cmp.Or(os.Getenv("CLAUDE_PROJECT_DIR"), event.Cwd, ".")
+	if len(cfg.Paths) > 0 {
+		root = cfg.Paths[0]
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	if path := event.ToolInput.FilePath; path != "" && !filepath.IsAbs(path) {
+		event.ToolInput.FilePath = filepath.Join(cmp.Or(event.Cwd, root), path)
+	}
+	src, _ := os.ReadFile(event.ToolInput.FilePath)
+	activity, ok := codegraph.HookActivity(event, root, src)
+	if !ok {
+		slog.Debug("no activity to capture", "tool", event.ToolName, "file", event.ToolInput.FilePath)
+		return nil
+	}
+
+	// Without --session, the agent's own session is recorded as a Session,
+	// started the first time one of its events is captured
+	session := cfg.Session
+	sessions, hasSessions := m.(codegraph.SessionStore)
+	if session == "" && event.SessionID != "" && hasSessions {
+		started, err := sessions.StartSession(ctx, cfg.Project, codegraph.Session{
+			AgentSession: event.SessionID, Dir: root, Branch: codegraph.CurrentBranch(ctx, root),
+		})
+		if err != nil {
+			return fmt.Errorf("starting session for %s: %w", event.SessionID, err)
+		}
+		session = started.ID
+	}
+
+	memory := activity.Memory()
+	memory.Session, memory.Namespace = session, homeNamespace(cfg.Namespace)
+	memory.Provenance = cfg.provenance(codegraph.ViaHook)
+	if cfg.TTL > 0 {
+		memory.ExpiresAt = time.Now().Add(cfg.TTL)
+	}
+	stored, err := m.Remember(ctx, cfg.Project, memory)
+	if errors.Is(err, codegraph.ErrNoNode) && len(activity.Functions) > 0 {
+		// Functions added since the last write are not in the graph yet
+		activity.Functions = nil
+		fileOnly := activity.Memory()
+		memory.Text, memory.About = fileOnly.Text, fileOnly.About
+		stored, err = m.Remember(ctx, cfg.Project, memory)
+	}
+	if errors.Is(err, codegraph.ErrNoNode) {
+		slog.Debug("file not in the graph", "file", activity.File)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.Debug("captured activity", "id", stored.ID, "text", stored.Text)
+
+	if hasSessions && session != "" && activity.Action == "edit" {
+		if err := sessions.TouchFiles(ctx, cfg.Project, session, []string{activity.File}); err != nil {
+			return fmt.Errorf("touching %s in session %s: %w", activity.File, session, err)
+		}
+	}
+	return nil
+}
+
+// runGC archives or deletes the memories whose relevance has decayed below
+// --threshold, listing each with its score
+func runGC(ctx context.Context, cfg Config, m codegraph.MemoryStore, w io.Writer) error {
+	if cfg.HalfLife <= 0 || cfg.Threshold < 0 || cfg.Threshold > 1 {
+		return withExit(exitUsage, errors.New("gc needs a positive --half-life and a --threshold from 0 to 1"))
+	}
+	gc := codegraph.MemoryGC{
+		Decay:     codegraph.Decay{HalfLife: cfg.HalfLife},
+		Threshold: cfg.Threshold,
+		Delete:    cfg.DeleteMemories,
+		DryRun:    cfg.DryRun,
+	}
+	collected, err := codegraph.CollectMemories(ctx, m, cfg.Project, gc, time.Now())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range collected {
+		text, _, _ := strings.Cut(s.Text, "\n")
+		fmt.Fprintf(tw, "%s\t%.3f\t%s\n", s.ID, s.Score, text)
+	}
+	tw.Flush()
+	action := "archived"
+	switch {
+	case cfg.DryRun:
+		action = "would collect"
+	case cfg.DeleteMemories:
+		action = "deleted"
+	}
+	slog.Info(action+" memories", "project", cfg.Project, "memories", len(collected))
+	return nil
+}
+
+// runConsolidate merges the near-duplicate memories, listing each merged
+// memory with the IDs of those it replaces
+func runConsolidate(ctx context.Context, cfg Config, m codegraph.MemoryStore, w io.Writer) (err error) {
+	if cfg.Similarity <= 0 || cfg.Similarity > 1 {
+		return withExit(exitUsage, errors.New("consolidate needs a --similarity above 0 and at most 1"))
+	}
+	opts := codegraph.ConsolidateOptions{Similarity: cfg.Similarity, DryRun: cfg.DryRun}
+	if cfg.Embed != "" {
+		e, closeEmbedder, err := openEmbedder(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, closeEmbedder()) }()
+		opts.Embedder = e
+	}
+	consolidations, err := codegraph.ConsolidateMemories(ctx, m, cfg.Project, opts)
+	if err != nil {
+		return err
+	}
+	for i, c := range consolidations {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		id := c.Memory.ID
+		if id == "" {
+			id = "(new)"
+		}
+		fmt.Fprintf(w, "%s <- %s\n", id, strings.Join(c.Memory.Sources, ", "))
+		for line := range strings.SplitSeq(strings.TrimRight(c.Memory.Text, "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	action := "consolidated"
+	if cfg.DryRun {
+		action = "would consolidate"
+	}
+	slog.Info(action+" memories", "project", cfg.Project, "groups", len(consolidations))
+	return nil
+}
+
+// withSessions opens the backend and runs fn with it, if it can record
+// sessions
+func withSessions(ctx context.Context, cfg Config, fn func(codegraph.SessionStore) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	s, ok := backend.(codegraph.SessionStore)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot record sessions; use neo4j or falkordb", cfg.Backend))
+	}
+	return fn(s)
+}
+
+// runSession runs the session action named by the first argument, on the
+// session with the ID in the second or --session
+func runSession(ctx context.Context, cfg Config, s codegraph.SessionStore, args []string, w io.Writer) error {
+	action, id := "", cfg.Session
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if len(args) > 1 {
+		id = args[1]
+	}
+	if id == "" && (action == "end" || action == "touch" || action == "show") {
+		return withExit(exitUsage, fmt.Errorf("session %s needs a session ID, as an argument, --session or $CODEGRAPH_SESSION", action))
+	}
+
+	switch action {
+	case "start":
+		dir, err := filepath.Abs(cmp.Or(cfg.WorkDir, "."))
+		if err != nil {
+			return err
+		}
+		session, err := s.StartSession(ctx, cfg.Project, codegraph.Session{Dir: dir, Branch: cmp.Or(cfg.Branch, codegraph.CurrentBranch(ctx, dir))})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, session.ID)
+	case "end":
+		session, err := s.EndSession(ctx, cfg.Project, id)
+		if err != nil {
+			return err
+		}
+		slog.Info("ended session", "id", session.ID, "took", session.EndedAt.Sub(session.StartedAt).Round(time.Second),
+			"memories", session.Memories, "files", len(session.Touched))
+	case "touch":
+		if len(cfg.Touch) == 0 {
+			return withExit(exitUsage, errors.New("session touch needs at least one --file"))
+		}
+		return s.TouchFiles(ctx, cfg.Project, id, cfg.Touch)
+	case "list":
+		if cfg.Limit < 0 {
+			return withExit(exitUsage, fmt.Errorf("--limit must not be negative, got %d", cfg.Limit))
+		}
+		sessions, err := s.Sessions(ctx, cfg.Project, cfg.Limit)
+		if err != nil {
+			return err
+		}
+		if len(sessions) == 0 {
+			slog.Warn("no sessions", "project", cfg.Project)
+			return nil
+		}
+		printSessions(w, sessions)
+	case "show":
+		session, memories, err := s.Replay(ctx, cfg.Project, id, recallNamespaces(cfg.Namespace))
+		if err != nil {
+			return err
+		}
+		var summaries []codegraph.Summary
+		if sum, ok := s.(codegraph.SummaryStore); ok {
+			if summaries, err = sessionSummaries(ctx, cfg.Project, sum, id); err != nil {
+				return err
+			}
+		}
+		var decisions []codegraph.Decision
+		if d, ok := s.(codegraph.DecisionStore); ok {
+			if decisions, err = sessionDecisions(ctx, cfg.Project, d, id); err != nil {
+				return err
+			}
+		}
+		printReplay(w, session, summaries, memories, decisions)
+	default:
+		return withExit(exitUsage, fmt.Errorf("unknown session action %q, expected start, end, touch, list or show", action))
+	}
+	return nil
+}
+
+// sessionTimes describes when a session started and how long it lasted
+func sessionTimes(session codegraph.Session) (started, took string) {
+	started, took = session.StartedAt.UTC().Format(time.DateTime), "open"
+	if !session.EndedAt.IsZero() {
+		took = session.EndedAt.Sub(session.StartedAt).Round(time.Second).String()
+	}
+	return started, took
+}
+
+// printSessions lists sessions as a table
+func printSessions(w io.Writer, sessions []codegraph.Session) {
+	fmt.Fprintf(w, "%-29s  %-19s  %-9s  %-20s  %8s  %5s\n", "session", "started", "took", "branch", "memories", "files")
+	for _, session := range sessions {
+		started, took := sessionTimes(session)
+		fmt.Fprintf(w, "%-29s  %-19s  %-9s  %-20s  %8d  %5d\n", session.ID, started, took, cmp.Or(session.Branch, "-"),
+			session.Memories, len(session.Touched))
+	}
+}
+
+// printReplay prints where and when a session ran, the files it touched,
+// its summaries and the memories and decisions it recorded, in order
+func printReplay(w io.Writer, session codegraph.Session, summaries []codegraph.Summary, memories []codegraph.Memory, decisions []codegraph.Decision) {
+	started, took := sessionTimes(session)
+	fmt.Fprintf(w, "session %s\n", session.ID)
+	fmt.Fprintf(w, "  started  %s (%s)\n", started, took)
+	fmt.Fprintf(w, "  dir      %s\n", cmp.Or(session.Dir, "-"))
+	fmt.Fprintf(w, "  branch   %s\n", cmp.Or(session.Branch, "-"))
+	fmt.Fprintf(w, "  touched  %s\n", cmp.Or(strings.Join(session.Touched, ", "), "-"))
+	if len(summaries) > 0 {
+		fmt.Fprintln(w)
+		printSummaries(w, summaries)
+	}
+	if len(memories) > 0 {
+		fmt.Fprintln(w)
+		printMemories(w, memories)
+	}
+	if len(decisions) > 0 {
+		fmt.Fprintln(w)
+		printDecisions(w, decisions)
+	}
+}
+
+// sessionSummaries returns the summaries of the session id, oldest first
+func sessionSummaries(ctx context.Context, project string, s codegraph.SummaryStore, id string) ([]codegraph.Summary, error) {
+	summaries, err := s.Summaries(ctx, project, codegraph.SummaryQuery{Session: id})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(summaries)
+	return summaries, nil
+}
+
+// sessionDecisions returns the decisions made during the session id,
+// oldest first
+func sessionDecisions(ctx context.Context, project string, d codegraph.DecisionStore, id string) ([]codegraph.Decision, error) {
+	decisions, err := d.Decisions(ctx, project, codegraph.DecisionQuery{Session: id})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(decisions)
+	return decisions, nil
+}
+
+// withDecisions opens the backend and runs fn with it, if it can keep
+// decisions
+func withDecisions(ctx context.Context, cfg Config, fn func(codegraph.DecisionStore) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	d, ok := backend.(codegraph.DecisionStore)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep decisions; use neo4j or falkordb", cfg.Backend))
+	}
+	return fn(d)
+}
+
+// runDecision runs the decision action named by the first argument
+func runDecision(ctx context.Context, cfg Config, d codegraph.DecisionStore, args []string, w io.Writer) error {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if action != "status" && len(args) > 1 {
+		return withExit(exitUsage, fmt.Errorf("decision %s takes no arguments", action))
+	}
+
+	switch action {
+	case "add":
+		if cfg.Title == "" || len(cfg.Affects) == 0 {
+			return withExit(exitUsage, errors.New("decision add needs a --title and at least one --affects key"))
+		}
+		decision, err := d.Decide(ctx, cfg.Project, codegraph.Decision{
+			Title:        cfg.Title,
+			Rationale:    cfg.Rationale,
+			Alternatives: cfg.Alternatives,
+			Status:       cfg.Status,
+			Affects:      cfg.Affects,
+			Session:      cfg.Session,
+			GitState:     resolveGitState(ctx, cfg),
+		})
+		if errors.Is(err, codegraph.ErrInvalidDecision) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, decision.ID)
+	case "list":
+		if len(cfg.About) > 1 || cfg.Limit < 0 {
+			return withExit(exitUsage, errors.New("decision list takes at most one --about, and a --limit of 0 or more"))
+		}
+		q := codegraph.DecisionQuery{Status: cfg.Status, Limit: cfg.Limit}
+		if len(cfg.About) > 0 {
+			q.Affects = codegraph.EnclosingKeys(cfg.About[0])
+		}
+		decisions, err := d.Decisions(ctx, cfg.Project, q)
+		if err != nil {
+			return err
+		}
+		if len(decisions) == 0 {
+			slog.Warn("no decisions", "project", cfg.Project, "about", cfg.About, "status", cfg.Status)
+			return nil
+		}
+		printDecisions(w, decisions)
+	case "status":
+		if len(args) != 3 {
+			return withExit(exitUsage, errors.New("decision status needs a decision ID and a status"))
+		}
+		decision, err := d.SetDecisionStatus(ctx, cfg.Project, args[1], args[2])
+		if errors.Is(err, codegraph.ErrInvalidDecision) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("set decision status", "project", cfg.Project, "id", decision.ID, "status", decision.Status)
+	default:
+		return withExit(exitUsage, fmt.Errorf("unknown decision action %q, expected add, list or status", action))
+	}
+	return nil
+}
+
+// printDecisions prints each decision's ID, time, status and title, the
+// keys it affects, its rationale and the alternatives considered, indented
+func printDecisions(w io.Writer, decisions []codegraph.Decision) {
+	for i, decision := range decisions {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s  %s  [%s]  %s\n", decision.ID, decision.CreatedAt.UTC().Format(time.DateTime), decision.Status, decision.Title)
+		fmt.Fprintf(w, "  affects %s\n", strings.Join(decision.Affects, ", "))
+		if at := formatGitState(decision.GitState); at != "" {
+			fmt.Fprintf(w, "  at %s\n", at)
+		}
+		if decision.Rationale != "" {
+			for line := range strings.SplitSeq(strings.TrimRight(decision.Rationale, "\n"), "\n") {
+				fmt.Fprintf(w, "  %s\n", line)
+			}
+		}
+		for _, alternative := range decision.Alternatives {
+			fmt.Fprintf(w, "  instead of %s\n", alternative)
+		}
+	}
+}
+
+// withSummaries opens the backend and runs fn with it, if it can keep
+// summaries
+func withSummaries(ctx context.Context, cfg Config, fn func(codegraph.SummaryStore) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	s, ok := backend.(codegraph.SummaryStore)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep summaries; use neo4j or falkordb", cfg.Backend))
+	}
+	return fn(s)
+}
+
+// runSessionSummary runs the summary action named by the first argument:
+// add summarizes --session with the second, list lists the newest
+// summaries
+func runSessionSummary(ctx context.Context, cfg Config, s codegraph.SummaryStore, args []string, w io.Writer) error {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "add":
+		if len(args) != 2 || strings.TrimSpace(args[1]) == "" || cfg.Session == "" {
+			return withExit(exitUsage, errors.New("summary add needs the summary as an argument and a session ID, as --session or $CODEGRAPH_SESSION"))
+		}
+		summary, err := s.Summarize(ctx, cfg.Project, codegraph.Summary{
+			Session:  cfg.Session,
+			Text:     args[1],
+			About:    cfg.About,
+			GitState: resolveGitState(ctx, cfg),
+		})
+		if errors.Is(err, codegraph.ErrInvalidSummary) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, summary.ID)
+	case "list":
+		if len(args) > 1 || cfg.Limit < 0 {
+			return withExit(exitUsage, errors.New("summary list takes no arguments, and a --limit of 0 or more"))
+		}
+		summaries, err := s.Summaries(ctx, cfg.Project, codegraph.SummaryQuery{Package: cfg.Package, Limit: cfg.Limit})
+		if err != nil {
+			return err
+		}
+		if len(summaries) == 0 {
+			slog.Warn("no summaries", "project", cfg.Project, "package", cfg.Package)
+			return nil
+		}
+		printSummaries(w, summaries)
+	default:
+		return withExit(exitUsage, fmt.Errorf("unknown summary action %q, expected add or list", action))
+	}
+	return nil
+}
+
+// printSummaries prints each summary's ID, time and session, the packages
+// it is about and its text, indented
+func printSummaries(w io.Writer, summaries []codegraph.Summary) {
+	for i, summary := range summaries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s  %s  session %s\n", summary.ID, summary.CreatedAt.UTC().Format(time.DateTime), summary.Session)
+		fmt.Fprintf(w, "  in %s\n", strings.Join(summary.Packages, ", "))
+		if at := formatGitState(summary.GitState); at != "" {
+			fmt.Fprintf(w, "  at %s\n", at)
+		}
+		for line := range strings.SplitSeq(strings.TrimRight(summary.Text, "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}
+
+// withConventions opens the backend and runs fn with it, if it can keep
+// conventions
+func withConventions(ctx context.Context, cfg Config, fn func(codegraph.ConventionStore) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	c, ok := backend.(codegraph.ConventionStore)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep conventions; use neo4j or falkordb", cfg.Backend))
+	}
+	return fn(c)
+}
+
+// runConvention runs the convention action named by the first argument:
+// add stores the second as a convention for the --package packages, list
+// lists those applying to a package, and remove deletes one by ID
+func runConvention(ctx context.Context, cfg Config, c codegraph.ConventionStore, args []string, w io.Writer) error {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "add":
+		if len(args) != 2 || strings.TrimSpace(args[1]) == "" {
+			return withExit(exitUsage, errors.New("convention add needs the rule as an argument"))
+		}
+		convention, err := c.AddConvention(ctx, cfg.Project, codegraph.Convention{
+			Category:  cfg.Category,
+			Rule:      args[1],
+			Rationale: cfg.Rationale,
+			Example:   cfg.Example,
+			Packages:  cfg.Packages,
+			Namespace: homeNamespace(cfg.Namespace),
+		})
+		if errors.Is(err, codegraph.ErrInvalidConvention) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, convention.ID)
+	case "list":
+		if len(args) > 1 || len(cfg.Packages)+len(cfg.About) > 1 || cfg.Limit < 0 {
+			return withExit(exitUsage, errors.New("convention list takes no arguments, at most one --package or --about, and a --limit of 0 or more"))
+		}
+		q := codegraph.ConventionQuery{Category: cfg.Category, Namespaces: recallNamespaces(cfg.Namespace), Limit: cfg.Limit}
+		if len(cfg.Packages) > 0 {
+			q.Package = cfg.Packages[0]
+		}
+		if len(cfg.About) > 0 {
+			q.Package = keyPackage(cfg.About[0])
+		}
+		conventions, err := c.Conventions(ctx, cfg.Project, q)
+		if err != nil {
+			return err
+		}
+		if len(conventions) == 0 {
+			slog.Warn("no conventions", "project", cfg.Project, "package", q.Package, "category", cfg.Category)
+			return nil
+		}
+		printConventions(w, conventions)
+	case "remove":
+		if len(args) != 2 {
+			return withExit(exitUsage, errors.New("convention remove needs the ID of a convention"))
+		}
+		found, err := c.RemoveConvention(ctx, cfg.Project, args[1])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no convention %q in project %s", args[1], cfg.Project)
+		}
+		slog.Info("removed convention", "project", cfg.Project, "id", args[1])
+	default:
+		return withExit(exitUsage, fmt.Errorf("unknown convention action %q, expected add, list or remove", action))
+	}
+	return nil
+}
+
+// keyPackage returns the path of the package holding the node with the
+// given key, or "" if the key is invalid
+func keyPackage(key string) string {
+	for _, enclosing := range codegraph.EnclosingKeys(key) {
+		if pkg, ok := strings.CutPrefix(enclosing, "Package:"); ok {
+			return pkg
+		}
+	}
+	return ""
+}
+
+// printConventions prints each convention's ID, category, namespace and
+// rule, the packages it applies to, its rationale and example, indented
+func printConventions(w io.Writer, conventions []codegraph.Convention) {
+	for i, convention := range conventions {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s  [%s]", convention.ID, convention.Category)
+		if convention.Namespace != "" {
+			fmt.Fprintf(w, "  in %s", convention.Namespace)
+		}
+		fmt.Fprintf(w, "  %s\n", convention.Rule)
+		if len(convention.Packages) > 0 {
+			fmt.Fprintf(w, "  applies to %s\n", strings.Join(convention.Packages, ", "))
+		} else {
+			fmt.Fprintln(w, "  applies to the whole project")
+		}
+		if convention.Rationale != "" {
+			for line := range strings.SplitSeq(strings.TrimRight(convention.Rationale, "\n"), "\n") {
+				fmt.Fprintf(w, "  %s\n", line)
+			}
+		}
+		if convention.Example != "" {
+			fmt.Fprintln(w, "  for example:")
+			for line := range strings.SplitSeq(strings.TrimRight(convention.Example, "\n"), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+	}
+}
+
+// analyses are the analyses analyze runs over a parsed tree, by name. Each
+// prints what it finds to w and writes it to backend, unless that is nil.
+var analyses = map[string]func(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error{
+	"deadcode":   analyzeDeadCode,
+	"cycles":     analyzeCycles,
+	"stability":  analyzeStability,
+	"hotspots":   analyzeHotspots,
+	"unused-api": analyzeUnusedAPI,
+	"interfaces": analyzeInterfaces,
+	"god":        analyzeGod,
+	"depth":      analyzeDepth,
+	"errors":     analyzeErrors,
+}
+
+// runAnalyze parses cfg.Path and runs the analysis named by the argument,
+// writing what it finds to backend unless that is nil
+func runAnalyze(ctx context.Context, cfg Config, backend any, args []string, w io.Writer) error {
+	names := strings.Join(slices.Sorted(maps.Keys(analyses)), ", ")
+	if len(args) != 1 {
+		return withExit(exitUsage, fmt.Errorf("analyze needs the analysis to run: %s", names))
+	}
+	analyze, ok := analyses[args[0]]
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("unknown analysis %q, expected one of %s", args[0], names))
+	}
+	graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	if err != nil {
+		return withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+	return analyze(ctx, cfg, graph, backend, w)
+}
+
+// analyzeDeadCode prints the functions nothing calls, replacing the
+// project's dead code findings with them
+func analyzeDeadCode(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	findings := codegraph.DeadCode(graph)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "deadcode", findings); err != nil {
+			return err
+		}
+	}
+	printFindings(w, findings)
+	slog.Info("analyzed dead code", "project", cfg.Project, "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeCycles prints the import and near cycles between packages,
+// replacing the project's Cycle nodes with them
+func analyzeCycles(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	cycles := codegraph.Cycles(graph)
+	if backend != nil {
+		c, ok := backend.(codegraph.CycleStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep cycles; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := c.ReplaceCycles(ctx, cfg.Project, cycles); err != nil {
+			return err
+		}
+	}
+	for _, cycle := range cycles {
+		fmt.Fprintf(w, "%s cycle  %s\n", cycle.Kind, strings.Join(cycle.Path, " -> "))
+	}
+	slog.Info("analyzed cycles", "project", cfg.Project, "cycles", len(cycles), "written", backend != nil)
+	return nil
+}
+
+// analyzeStability prints the stability metrics of every package, marking
+// those in the zone of pain, and replaces the project's stability findings
+// with those
+func analyzeStability(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	if cfg.Distance < 0 || cfg.Distance > 1 {
+		return withExit(exitUsage, errors.New("analyze stability needs a --distance from 0 to 1"))
+	}
+	stability := graph.Stability()
+	findings := codegraph.PainfulPackages(stability, cfg.Distance)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "stability", findings); err != nil {
+			return err
+		}
+	}
+	painful := make(map[string]bool)
+	for _, finding := range findings {
+		painful[finding.Name] = true
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "afferent\tefferent\tinstability\tabstractness\tdistance\t  package")
+	for _, s := range stability {
+		zone := ""
+		if painful[s.Package] {
+			zone = "  zone of pain"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t%.2f\t%.2f\t  %s%s\n", s.Afferent, s.Efferent, s.Instability, s.Abstractness, s.Distance, s.Package, zone)
+	}
+	tw.Flush()
+	slog.Info("analyzed stability", "project", cfg.Project, "packages", len(stability), "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeHotspots prints the --top functions and files scoring highest by
+// churn in the --churn window, complexity and fan-in, and replaces the
+// project's hotspot findings with them
+func analyzeHotspots(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	if cfg.Churn == "" || cfg.Limit < 0 {
+		return withExit(exitUsage, errors.New("analyze hotspots needs a --churn window and a --top of 0 or more"))
+	}
+	if err := codegraph.AddChurn(ctx, graph, cfg.Path, "", cfg.Churn); err != nil {
+		return fmt.Errorf("counting churn in %s: %w", cfg.Path, err)
+	}
+	hotspots := graph.Hotspots()
+	findings := codegraph.HotspotFindings(hotspots, cfg.Limit)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "hotspots", findings); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "score\tchurn\tcomplexity\tfan-in\t  hotspot")
+	for _, h := range hotspots[:len(findings)] {
+		at := h.File
+		if h.Line > 0 {
+			at = fmt.Sprintf("%s  %s:%d", h.Name, h.File, h.Line)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t  %s\n", h.Score, h.Churn, h.Complexity, h.FanIn, at)
+	}
+	tw.Flush()
+	slog.Info("analyzed hotspots", "project", cfg.Project, "hotspots", len(hotspots), "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeUnusedAPI prints the exported functions of the project that the
+// other projects of the run import it for but never use, nor does it, and
+// replaces the project's unused API findings with them
+func analyzeUnusedAPI(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	if len(cfg.Workspace) < 2 {
+		return withExit(exitUsage, errors.New("analyze unused-api needs the projects using the library too, given by more --path or by --project-map"))
+	}
+	module, err := codegraph.ModulePath(cfg.Path)
+	if err != nil {
+		return fmt.Errorf("finding the module path of %s: %w", cfg.Path, err)
+	}
+	var consumers []*codegraph.Graph
+	for _, t := range cfg.Workspace {
+		if t.Project == cfg.Project {
+			continue
+		}
+		consumer, err := parseTree(ctx, codegraph.Parser{Root: t.Path, Filter: t.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+		if err != nil {
+			return withExit(exitParse, fmt.Errorf("parsing %s: %w", t.Path, err))
+		}
+		consumers = append(consumers, consumer)
+	}
+	findings := codegraph.UnusedAPIOf(graph, module, consumers)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "unused-api", findings); err != nil {
+			return err
+		}
+	}
+	printFindings(w, findings)
+	slog.Info("analyzed unused API", "project", cfg.Project, "module", module, "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeInterfaces prints the interfaces bloated beyond what their
+// implementations need and those with a single implementation, replacing
+// the project's interface findings with them
+func analyzeInterfaces(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	if cfg.Methods < 1 {
+		return withExit(exitUsage, errors.New("analyze interfaces needs --methods of 1 or more"))
+	}
+	findings := codegraph.InterfaceFindings(graph, cfg.Methods)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "interfaces", findings); err != nil {
+			return err
+		}
+	}
+	printFindings(w, findings)
+	slog.Info("analyzed interfaces", "project", cfg.Project, "interfaces", len(graph.Interfaces), "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeErrors prints the calls throwing errors away, replacing the
+// project's discarded error findings with them
+func analyzeErrors(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	findings := codegraph.DiscardedErrors(graph)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "errors", findings); err != nil {
+			return err
+		}
+	}
+	printFindings(w, findings)
+	slog.Info("analyzed discarded errors", "project", cfg.Project, "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeDepth prints how deep the calls from each entry point go and
+// along which chain, and replaces the project's call depth findings with
+// those at least --max-depth deep
+func analyzeDepth(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	if cfg.MaxDepth < 1 {
+		return withExit(exitUsage, errors.New("analyze depth needs a --max-depth of 1 or more"))
+	}
+	depths := graph.CallDepths()
+	findings := codegraph.DeepCallFindings(depths, cfg.MaxDepth)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "depth", findings); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, d := range depths {
+		chain := make([]string, len(d.Path))
+		for i, key := range d.Path {
+			chain[i] = key[strings.LastIndex(key, ":")+1:]
+		}
+		fmt.Fprintf(tw, "%s:%d\t%s\t%d\t%s\n", d.File, d.Line, d.Kind, d.Depth, strings.Join(chain, " -> "))
+	}
+	tw.Flush()
+	slog.Info("analyzed call depth", "project", cfg.Project, "entryPoints", len(depths), "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// analyzeGod prints the structs and functions past the size limits,
+// replacing the project's god struct and function findings with them
+func analyzeGod(ctx context.Context, cfg Config, graph *codegraph.Graph, backend any, w io.Writer) error {
+	if min(cfg.God.Fields, cfg.God.Dependencies, cfg.God.Lines, cfg.God.Complexity, cfg.God.Params) < 0 {
+		return withExit(exitUsage, errors.New("analyze god needs limits of 0 or more"))
+	}
+	findings := codegraph.GodFindings(graph, cfg.God)
+	if backend != nil {
+		f, ok := backend.(codegraph.FindingStore)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot keep findings; use neo4j or falkordb, or --dry-run", cfg.Backend))
+		}
+		if err := f.ReplaceFindings(ctx, cfg.Project, "god", findings); err != nil {
+			return err
+		}
+	}
+	printFindings(w, findings)
+	slog.Info("analyzed god structs and functions", "project", cfg.Project, "findings", len(findings), "written", backend != nil)
+	return nil
+}
+
+// printFindings prints each finding's place, kind and message, aligned.
+// The place is only the file for findings without a line, and the kind is
+// followed by the severity of those with one.
+func printFindings(w io.Writer, findings []codegraph.Finding) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, finding := range findings {
+		place, kind := finding.File, finding.Kind
+		if finding.Line > 0 {
+			place += ":" + strconv.Itoa(finding.Line)
+		}
+		if finding.Severity != "" {
+			kind += " (" + finding.Severity + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", place, kind, finding.Message)
+	}
+	tw.Flush()
+}
+
+// runImpact prints what a change to the function, struct, interface or
+// file args names can break, up to --depth hops away
+func runImpact(ctx context.Context, cfg Config, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return withExit(exitUsage, errors.New("impact needs the function, struct, interface or file to change"))
+	}
+	impact, err := parseImpact(ctx, cfg, args[0])
+	if err != nil {
+		return err
+	}
+	printImpact(w, impact)
+	return nil
+}
+
+// parseImpact parses cfg.Path, tests included, and walks what depends on
+// target up to --depth hops
+func parseImpact(ctx context.Context, cfg Config, target string) (codegraph.Impact, error) {
+	filter := cfg.Filter
+	filter.Tests = true
+	graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	if err != nil {
+		return codegraph.Impact{}, withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+	impact, err := codegraph.ImpactOf(graph, target, cfg.Depth)
+	if err != nil {
+		return codegraph.Impact{}, withExit(exitUsage, err)
+	}
+	return impact, nil
+}
+
+// printImpact writes the changed symbols, then those affected and the
+// tests reaching them with their depth and the relationship reaching them,
+// then the files holding them
+func printImpact(w io.Writer, impact codegraph.Impact) {
+	fmt.Fprintf(w, "changed: %s\n", strings.Join(impact.Changed, ", "))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, section := range []struct {
+		name     string
+		affected []codegraph.Affected
+	}{{"symbols", impact.Symbols}, {"tests", impact.Tests}} {
+		fmt.Fprintf(tw, "%s: %d\n", section.name, len(section.affected))
+		for _, affected := range section.affected {
+			at := affected.File
+			if affected.Line > 0 {
+				at = fmt.Sprintf("%s:%d", affected.File, affected.Line)
+			}
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\n", affected.Depth, affected.Via, affected.Name, at)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "files: %d\n", len(impact.Files))
+	for _, file := range impact.Files {
+		fmt.Fprintf(w, "  %s\n", file)
+	}
+}
+
+// recallMemories recalls the project's memories matching q and, with
+// linked and a backend keeping links, those of the projects it links to
+func recallMemories(ctx context.Context, m codegraph.MemoryStore, project string, q codegraph.MemoryQuery, linked bool) ([]codegraph.Memory, error) {
+	l, ok := m.(codegraph.CrossLinker)
+	if !linked || !ok {
+		return m.Recall(ctx, project, q)
+	}
+	links, err := l.CrossLinks(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	return codegraph.RecallLinked(ctx, m, links, project, q)
+}
+
+// withLinker opens the backend and runs fn with it, if it can link
+// projects
+func withLinker(ctx context.Context, cfg Config, fn func(codegraph.CrossLinker) error) error {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	l, ok := backend.(codegraph.CrossLinker)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot link projects; use neo4j or falkordb", cfg.Backend))
+	}
+	return fn(l)
+}
+
+// runLink runs the link action named by the first argument: add links
+// --from to --to of --to-project, imports links the files importing
+// --to-project's packages, list lists the links and remove deletes the one
+// with the second as ID
+func runLink(ctx context.Context, cfg Config, l codegraph.CrossLinker, args []string, w io.Writer) error {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "add":
+		if len(args) > 1 || cfg.LinkFrom == "" || cfg.ToProject == "" || cfg.LinkTo == "" {
+			return withExit(exitUsage, errors.New("link add takes no arguments, and needs --from, --to-project and --to"))
+		}
+		link, err := l.LinkProjects(ctx, codegraph.CrossLink{
+			Type: cfg.LinkType, FromProject: cfg.Project, From: cfg.LinkFrom, ToProject: cfg.ToProject, To: cfg.LinkTo,
+		})
+		if errors.Is(err, codegraph.ErrInvalidLink) || errors.Is(err, codegraph.ErrNoNode) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, link.ID)
+	case "imports":
+		if len(args) > 1 || cfg.ToProject == "" || cfg.Module == "" {
+			return withExit(exitUsage, errors.New("link imports takes no arguments, and needs --to-project and --module"))
+		}
+		links, err := l.LinkImports(ctx, cfg.Project, cfg.ToProject, cfg.Module)
+		if errors.Is(err, codegraph.ErrInvalidLink) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			slog.Warn("no imports of the module", "project", cfg.Project, "module", cfg.Module)
+			return nil
+		}
+		printLinks(w, links)
+	case "list":
+		if len(args) > 1 {
+			return withExit(exitUsage, errors.New("link list takes no arguments"))
+		}
+		links, err := l.CrossLinks(ctx, cfg.Project)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			slog.Warn("no links", "project", cfg.Project)
+			return nil
+		}
+		printLinks(w, links)
+	case "remove":
+		if len(args) != 2 {
+			return withExit(exitUsage, errors.New("link remove needs the link's ID"))
+		}
+		found, err := l.Unlink(ctx, cfg.Project, args[1])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no link %q from or to project %s", args[1], cfg.Project)
+		}
+	default:
+		return withExit(exitUsage, fmt.Errorf("unknown link action %q, expected add, imports, list or remove", action))
+	}
+	return nil
+}
+
+// printLinks prints each link's ID, type and the nodes it links, one per
+// line
+func printLinks(w io.Writer, links []codegraph.CrossLink) {
+	for _, link := range links {
+		fmt.Fprintf(w, "%s  %s:%s -[%s]-> %s:%s\n", link.ID, link.FromProject, link.From, link.Type, link.ToProject, link.To)
+	}
+}
+
+// changesTime returns the time to catch up from: since, as a time or a
+// date, or a duration before now, else the end of the session with ID
+// session, else of the last session to have ended
+func changesTime(ctx context.Context, cfg Config, backend any, since, session string, now time.Time) (time.Time, error) {
+	if since != "" {
+		if session != "" {
+			return time.Time{}, withExit(exitUsage, errors.New("give --since or --session, not both"))
+		}
+		for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+			if t, err := time.Parse(layout, since); err == nil {
+				return t, nil
+			}
+		}
+		d, err := time.ParseDuration(since)
+		if err != nil || d < 0 {
+			return time.Time{}, withExit(exitUsage, fmt.Errorf("--since %q is not a time, such as 2026-01-02T15:04:05Z, or a duration, such as 24h", since))
+		}
+		return now.Add(-d), nil
+	}
+	s, ok := backend.(codegraph.SessionStore)
+	if !ok {
+		return time.Time{}, withExit(exitUsage, fmt.Errorf("the %s backend records no sessions; give --since", cfg.Backend))
+	}
+	if session != "" {
+		return codegraph.SessionTime(ctx, s, cfg.Project, session)
+	}
+	sessions, err := s.Sessions(ctx, cfg.Project, 20)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, session := range sessions {
+		if !session.EndedAt.IsZero() {
+			return session.EndedAt, nil
+		}
+	}
+	return time.Time{}, withExit(exitUsage, errors.New("no session has ended yet; give --since or --session"))
+}
+
+// readChanges reads what changed in the project after since: its memories
+// and decisions, and the code in cfg.Path if that is a git repository
+func readChanges(ctx context.Context, cfg Config, backend any, since time.Time) (codegraph.Changes, error) {
+	m, _ := backend.(codegraph.MemoryStore)
+	d, _ := backend.(codegraph.DecisionStore)
+	cq := codegraph.ChangeQuery{Since: since, Namespaces: recallNamespaces(cfg.Namespace)}
+	if codegraph.ResolveCommit(ctx, cfg.Path, "") != "" {
+		graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+		if err != nil {
+			return codegraph.Changes{}, fmt.Errorf("parsing %s: %w", cfg.Path, err)
+		}
+		cq.Root, cq.Graph = cfg.Path, graph
+	}
+	return codegraph.ChangesSince(ctx, m, d, cfg.Project, cq)
+}
+
+// runChanges prints what changed since --since or the end of --session, by
+// default of the last session to have ended
+func runChanges(ctx context.Context, cfg Config, backend any, w io.Writer) error {
+	since, err := changesTime(ctx, cfg, backend, cfg.Since, cfg.Session, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	changes, err := readChanges(ctx, cfg, backend, since)
+	if err != nil {
+		return err
+	}
+	printChanges(w, changes)
+	return nil
+}
+
+// printChanges writes the changed nodes, one key a line, then the new
+// memories and decisions
+func printChanges(w io.Writer, changes codegraph.Changes) {
+	fmt.Fprintf(w, "since %s: %d nodes changed, %d memories, %d decisions\n",
+		changes.Since.UTC().Format(time.DateTime), len(changes.Nodes), len(changes.Memories), len(changes.Decisions))
+	for _, key := range changes.Nodes {
+		fmt.Fprintf(w, "  %s\n", key)
+	}
+	if len(changes.Memories) > 0 {
+		fmt.Fprintln(w)
+		printMemories(w, changes.Memories)
+	}
+	if len(changes.Decisions) > 0 {
+		fmt.Fprintln(w)
+		printDecisions(w, changes.Decisions)
+	}
+}
+
+// runKnowledge exports the project's memories and decisions as JSON to
+// --out, or imports an export from the file named by the second argument
+// or r, keeping the IDs and times they had
+func runKnowledge(ctx context.Context, cfg Config, backend any, args []string, r io.Reader, w io.Writer) error {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "export":
+		if len(args) > 1 {
+			return withExit(exitUsage, errors.New("knowledge export takes no arguments; name the file with --out"))
+		}
+		m, _ := backend.(codegraph.MemoryStore)
+		d, _ := backend.(codegraph.DecisionStore)
+		if m == nil && d == nil {
+			return withExit(exitUsage, fmt.Errorf("the %s backend keeps no memories or decisions; use neo4j or falkordb", cfg.Backend))
+		}
+		k, err := codegraph.ExportKnowledge(ctx, m, d, cfg.Project)
+		if err != nil {
+			return err
+		}
+		if cfg.Out != "" && cfg.Out != "-" {
+			f, err := os.Create(cfg.Out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(k); err != nil {
+			return err
+		}
+		slog.Info("exported knowledge", "project", cfg.Project, "memories", len(k.Memories), "decisions", len(k.Decisions), "out", cfg.Out)
+	case "import":
+		imp, ok := backend.(codegraph.KnowledgeImporter)
+		if !ok {
+			return withExit(exitUsage, fmt.Errorf("the %s backend cannot import memories and decisions; use neo4j or falkordb", cfg.Backend))
+		}
+		if len(args) > 1 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return withExit(exitUsage, err)
+			}
+			defer f.Close()
+			r = f
+		}
+		var k codegraph.Knowledge
+		if err := json.NewDecoder(r).Decode(&k); err != nil {
+			return withExit(exitUsage, fmt.Errorf("reading knowledge: %w", err))
+		}
+		result, err := codegraph.ImportKnowledge(ctx, imp, cfg.Project, k)
+		if errors.Is(err, codegraph.ErrInvalidMemory) || errors.Is(err, codegraph.ErrInvalidDecision) {
+			return withExit(exitUsage, err)
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("imported knowledge", "project", cfg.Project, "from", k.Project,
+			"memories", result.Memories, "decisions", result.Decisions, "skipped", result.Skipped)
+	default:
+		return withExit(exitUsage, fmt.Errorf("unknown knowledge action %q, expected export or import", action))
+	}
+	return nil
 }
 
-// InterfaceNode represents an interface definition
-type InterfaceNode struct {
-	Name     string
-	File     string
-	Methods  []string
-	IsExport bool
+// runSearch prints the nodes of the project closest in meaning to text
+func runSearch(ctx context.Context, cfg Config, text string, w io.Writer) error {
+	if cfg.Embed == "" {
+		return withExit(exitUsage, errors.New("search needs --embed, set to the provider the project was indexed with"))
+	}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
+	}
+	defer closeBackend(backend)
+	v, ok := backend.(codegraph.VectorSearcher)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot search embeddings; use neo4j", cfg.Backend))
+	}
+	results, err := searchCode(ctx, cfg, v, text)
+	if err != nil {
+		return err
+	}
+	printSearchResults(w, results)
+	return nil
 }
 
-// PackageNode represents a Go package
-type PackageNode struct {
-	Name string
-	Path string
+// searchCode embeds text with the --embed provider and returns the at most
+// --limit nodes of the project closest to it, with the source of each read
+// from --path
+func searchCode(ctx context.Context, cfg Config, v codegraph.VectorSearcher, text string) (results []codegraph.SearchResult, err error) {
+	e, closeEmbedder, err := openEmbedder(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.Join(err, closeEmbedder()) }()
+	vectors, err := e.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedding the search: got %d vectors for 1 text", len(vectors))
+	}
+	results, err = v.Search(ctx, cfg.Project, codegraph.SearchQuery{
+		Vector:  vectors[0],
+		Package: cfg.Package,
+		Limit:   cfg.Limit,
+		Callers: cfg.Callers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	codegraph.AddSnippets(cfg.Path, results, cfg.SnippetLines)
+	return results, nil
 }
 
-// CodeGraph holds all parsed code elements
-type CodeGraph struct {
-	Files      []FileNode
-	Functions  []FunctionNode
-	Structs    []StructNode
-	Interfaces []InterfaceNode
-	Packages   []PackageNode
+// printSearchResults prints each result with where it is, its source and
+// its callers, best first
+func printSearchResults(w io.Writer, results []codegraph.SearchResult) {
+	for i, r := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%.3f  %s  %s\n", r.Score, r.Kind, r.Key)
+		where := r.File
+		if r.LineStart > 0 {
+			where += fmt.Sprintf(":%d", r.LineStart)
+		}
+		fmt.Fprintf(w, "  package %s, %s, %d callers\n", r.Package, where, r.CallerCount)
+		if r.Snippet != "" {
+			for line := range strings.SplitSeq(r.Snippet, "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		} else if r.Signature != "" {
+			fmt.Fprintf(w, "    %s\n", r.Signature)
+		}
+		if len(r.Callers) > 0 {
+			fmt.Fprintf(w, "  called by %s\n", strings.Join(r.Callers, ", "))
+		}
+	}
 }
 
-func main() {
-	cfg := Config{
-		Neo4jURI: getEnvOrDefault("NEO4J_URI", "bolt://localhost:7687"),
+// runSimilar prints the embedded nodes of the project closest to text
+func runSimilar(ctx context.Context, cfg Config, text string, w io.Writer) error {
+	if cfg.Embed == "" {
+		return withExit(exitUsage, errors.New("similar needs --embed, set to the provider the project was indexed with"))
 	}
-
-	flag.StringVar(&cfg.Project, "project", "TradingEngine", "Project label for graph nodes")
-	flag.StringVar(&cfg.Path, "path", ".", "Path to Go source code")
-	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Parse code without writing to DB")
-	flag.Parse()
-
-	fmt.Printf("Code Graph Populator\n")
-	fmt.Printf("  Project: %s\n", cfg.Project)
-	fmt.Printf("  Path: %s\n", cfg.Path)
-	fmt.Printf("  Neo4j: %s\n", cfg.Neo4jURI)
-	fmt.Println()
-
-	// Parse the codebase
-	graph, err := parseCodebase(cfg.Path)
+	backend, err := openBackend(ctx, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing codebase: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
 	}
-
-	fmt.Printf("Parsed:\n")
-	fmt.Printf("  Files: %d\n", len(graph.Files))
-	fmt.Printf("  Packages: %d\n", len(graph.Packages))
-	fmt.Printf("  Functions: %d\n", len(graph.Functions))
-	fmt.Printf("  Structs: %d\n", len(graph.Structs))
-	fmt.Printf("  Interfaces: %d\n", len(graph.Interfaces))
-	fmt.Println()
-
-	if cfg.DryRun {
-		fmt.Println("Dry run - not writing to database")
-		printSample(graph)
-		return
+	defer closeBackend(backend)
+	v, ok := backend.(codegraph.VectorSearcher)
+	if !ok {
+		return withExit(exitUsage, fmt.Errorf("the %s backend cannot search embeddings; use neo4j", cfg.Backend))
 	}
-
-	// Connect to NornicDB
-	ctx := context.Background()
-	driver, err := neo4j.NewDriverWithContext(cfg.Neo4jURI, neo4j.NoAuth())
+	nodes, err := findSimilar(ctx, cfg, v, text)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error connecting to Neo4j: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	defer driver.Close(ctx)
+	printSimilar(w, nodes)
+	return nil
+}
 
-	// Verify connection
-	if err := driver.VerifyConnectivity(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot connect to Neo4j: %v\n", err)
-		os.Exit(1)
+// findSimilar embeds text with the --embed provider and returns the at most
+// --limit nodes of the project closest to it
+func findSimilar(ctx context.Context, cfg Config, v codegraph.VectorSearcher, text string) (nodes []codegraph.SimilarNode, err error) {
+	e, closeEmbedder, err := openEmbedder(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("Connected to NornicDB!")
-
-	// Create the graph
-	if err := createGraph(ctx, driver, cfg.Project, graph); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating graph: %v\n", err)
-		os.Exit(1)
+	defer func() { err = errors.Join(err, closeEmbedder()) }()
+	vectors, err := e.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
 	}
-
-	fmt.Println("\nDone! Code graph populated successfully.")
-	fmt.Println("View in browser: http://localhost:7474")
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedding the search: got %d vectors for 1 text", len(vectors))
+	}
+	return v.SimilarNodes(ctx, cfg.Project, vectors[0], cfg.Limit)
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// printSimilar prints nodes with their scores, closest first
+func printSimilar(w io.Writer, nodes []codegraph.SimilarNode) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, node := range nodes {
+		fmt.Fprintf(tw, "%.3f\t%s\t%s\n", node.Score, node.Kind, node.Key)
 	}
-	return defaultValue
+	tw.Flush()
 }
 
-func parseCodebase(root string) (*CodeGraph, error) {
-	graph := &CodeGraph{}
-	fset := token.NewFileSet()
-	seenPackages := make(map[string]bool)
+// doctorCheck is the outcome of one doctor check, with how to fix it when
+// it did not pass
+type doctorCheck struct {
+	Name   string
+	Status string // ok, warn or fail
+	Detail string
+	Fix    string
+}
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// Headroom below these is worth a warning before a large run
+const (
+	minFreeDisk   = 1 << 30
+	minFreeMemory = 512 << 20
+)
+
+// runDoctor checks that the backend, the projects and the machine are ready
+// for indexing and prints what it found, with a fix for every problem. It
+// fails if any check does.
+func runDoctor(ctx context.Context, cfg Config, targets []target) error {
+	var checks []doctorCheck
+	switch cfg.Backend {
+	case "neo4j":
+		checks = checkNeo4j(ctx, cfg)
+	case "sqlite", "kuzu":
+		checks = checkEmbedded(ctx, cfg)
+	default:
+		checks = checkBackend(ctx, cfg)
+	}
+	checks = append(checks, checkTargets(ctx, targets)...)
+	checks = append(checks, checkHeadroom(cfg)...)
+	printChecks(os.Stdout, checks)
 
-		// Skip hidden directories and common non-source directories
-		if info.IsDir() {
-			name := info.Name()
-			if strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules" {
-				return filepath.SkipDir
+	failed, code := 0, exitFailure
+	for _, c := range checks {
+		switch c.Status {
+		case "fail":
+			failed++
+			if c.Name == "connectivity" || c.Name == "auth" {
+				code = exitConnection
 			}
-			return nil
+			summary.addMessage(slog.LevelError, c.Name+": "+c.Detail)
+		case "warn":
+			summary.addMessage(slog.LevelWarn, c.Name+": "+c.Detail)
 		}
+	}
+	if failed > 0 {
+		return withExit(code, fmt.Errorf("%d of %d checks failed", failed, len(checks)))
+	}
+	return nil
+}
 
-		// Only process .go files (not test files for now)
-		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
-			return nil
+// checkNeo4j checks that the server can be reached and logged into, which
+// server and version it is, the indexes on the keys writes match on, APOC
+// and the server's memory settings
+func checkNeo4j(ctx context.Context, cfg Config) []doctorCheck {
+	driver, err := newNeo4jDriver(cfg)
+	if err != nil {
+		return []doctorCheck{{"connectivity", "fail", err.Error(),
+			"--neo4j-uri (or NEO4J_URI) should look like bolt://localhost:7687 or neo4j://host:7687"}}
+	}
+	defer driver.Close(ctx)
+
+	userEnv, passwordEnv := cfg.Neo4jUserEnv, cfg.Neo4jPasswordEnv
+	if err := driver.VerifyConnectivity(ctx); err != nil {
+		var neoErr *neo4j.Neo4jError
+		if errors.As(err, &neoErr) && strings.Contains(neoErr.Code, "Security") {
+			return []doctorCheck{
+				{"connectivity", "ok", cfg.Neo4jURI + " is reachable", ""},
+				{"auth", "fail", neoErr.Msg,
+					fmt.Sprintf("set %s and %s to the database user and password, or name other variables with --neo4j-user-env and --neo4j-password-env", userEnv, passwordEnv)},
+			}
 		}
+		return []doctorCheck{{"connectivity", "fail", err.Error(),
+			fmt.Sprintf("start the server or point --neo4j-uri at it (now %s); for a machine without one, use --backend sqlite", cfg.Neo4jURI)}}
+	}
+	checks := []doctorCheck{{"connectivity", "ok", cfg.Neo4jURI + " is reachable", ""}}
+	if user := os.Getenv(userEnv); user != "" {
+		checks = append(checks, doctorCheck{"auth", "ok", "logged in as " + user, ""})
+	} else {
+		checks = append(checks, doctorCheck{"auth", "ok", "server accepts connections without authentication", ""})
+	}
 
-		// Parse the file
-		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	query := func(query string) ([][]any, error) {
+		session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+		defer session.Close(ctx)
+		result, err := session.Run(ctx, query, nil)
 		if err != nil {
-			fmt.Printf("  Warning: Failed to parse %s: %v\n", path, err)
-			return nil
+			return nil, err
 		}
-
-		relPath, _ := filepath.Rel(root, path)
-
-		// Extract file info
-		fileNode := FileNode{
-			Path:     relPath,
-			Package:  file.Name.Name,
-			Language: "go",
-			Imports:  extractImports(file),
+		var rows [][]any
+		for result.Next(ctx) {
+			rows = append(rows, result.Record().Values)
 		}
-		graph.Files = append(graph.Files, fileNode)
+		return rows, result.Err()
+	}
 
-		// Track packages
-		pkgPath := filepath.Dir(relPath)
-		if !seenPackages[pkgPath] {
-			seenPackages[pkgPath] = true
-			graph.Packages = append(graph.Packages, PackageNode{
-				Name: file.Name.Name,
-				Path: pkgPath,
-			})
+	// Version and dialect: NornicDB and other Bolt servers may not have
+	// dbms.components or SHOW
+	rows, err := query(`CALL dbms.components() YIELD name, versions, edition RETURN name, versions[0], edition`)
+	if err != nil || len(rows) == 0 {
+		checks = append(checks, doctorCheck{"version", "warn", "server does not report its version through dbms.components()",
+			"Neo4j-compatible servers such as NornicDB may lack some procedures; if writes fail, check the server supports UNWIND, MERGE and SHOW INDEXES"})
+	} else {
+		name, version, edition := fmt.Sprint(rows[0][0]), fmt.Sprint(rows[0][1]), fmt.Sprint(rows[0][2])
+		check := doctorCheck{"version", "ok", fmt.Sprintf("%s %s %s", name, version, edition), ""}
+		if major, _, _ := strings.Cut(version, "."); name == "Neo4j Kernel" && (major == "3" || major == "4") {
+			check.Status, check.Fix = "warn", "Neo4j 5 or later is recommended; 4.x lacks some of the SHOW commands used here"
 		}
+		checks = append(checks, check)
+	}
 
-		// Extract declarations
-		for _, decl := range file.Decls {
-			switch d := decl.(type) {
-			case *ast.FuncDecl:
-				fn := extractFunction(d, relPath, fset)
-				graph.Functions = append(graph.Functions, fn)
-
-			case *ast.GenDecl:
-				for _, spec := range d.Specs {
-					switch s := spec.(type) {
-					case *ast.TypeSpec:
-						switch t := s.Type.(type) {
-						case *ast.StructType:
-							st := extractStruct(s, t, relPath)
-							graph.Structs = append(graph.Structs, st)
-						case *ast.InterfaceType:
-							iface := extractInterface(s, t, relPath)
-							graph.Interfaces = append(graph.Interfaces, iface)
-						}
-					}
-				}
+	// Writes match every relationship's endpoints on their keys, so a
+	// missing index turns each batch into label scans
+	rows, err = query(`SHOW INDEXES YIELD labelsOrTypes, properties WHERE labelsOrTypes IS NOT NULL RETURN labelsOrTypes, properties`)
+	if err != nil {
+		checks = append(checks, doctorCheck{"indexes", "warn", "cannot list indexes: " + err.Error(), ""})
+	} else {
+		indexed := make(map[string]bool)
+		for _, row := range rows {
+			labels, _ := row[0].([]any)
+			props, _ := row[1].([]any)
+			if len(labels) > 0 && len(props) > 0 {
+				indexed[fmt.Sprint(labels[0])+"."+fmt.Sprint(props[0])] = true
+			}
+		}
+		var missing, fixes []string
+		for _, kind := range codegraph.NodeLabels {
+			label, keys := cfg.Labels.Label(kind), codegraph.NodeKeys[kind]
+			if indexed[label+"."+keys[0]] {
+				continue
+			}
+			missing = append(missing, label)
+			props := make([]string, len(keys))
+			for i, key := range keys {
+				props[i] = "n." + key
 			}
+			fixes = append(fixes, fmt.Sprintf("CREATE INDEX codegraph_%s IF NOT EXISTS FOR (n:%s) ON (%s);",
+				strings.ToLower(label), label, strings.Join(props, ", ")))
 		}
+		if len(missing) == 0 {
+			checks = append(checks, doctorCheck{"indexes", "ok", "every node kind is indexed on its key", ""})
+		} else {
+			checks = append(checks, doctorCheck{"indexes", "warn", "no index on the key of " + strings.Join(missing, ", "),
+				"large writes will be slow; run\n" + strings.Join(fixes, "\n")})
+		}
+	}
 
-		return nil
-	})
+	rows, err = query(`SHOW PROCEDURES YIELD name WHERE name STARTS WITH 'apoc.' RETURN count(*)`)
+	switch {
+	case err != nil:
+		checks = append(checks, doctorCheck{"apoc", "ok", "cannot list procedures; APOC is not required", ""})
+	case len(rows) > 0 && fmt.Sprint(rows[0][0]) != "0":
+		checks = append(checks, doctorCheck{"apoc", "ok", fmt.Sprintf("%v APOC procedures available", rows[0][0]), ""})
+	default:
+		checks = append(checks, doctorCheck{"apoc", "ok", "APOC is not installed; it is not required, but handy for ad-hoc queries", ""})
+	}
 
-	return graph, err
+	rows, err = query(`SHOW SETTINGS YIELD name, value
+		WHERE name IN ['server.memory.heap.max_size', 'db.memory.transaction.total.max', 'db.memory.transaction.max']
+		RETURN name, value ORDER BY name`)
+	if err == nil && len(rows) > 0 {
+		var settings []string
+		for _, row := range rows {
+			settings = append(settings, fmt.Sprintf("%v=%v", row[0], row[1]))
+		}
+		checks = append(checks, doctorCheck{"server memory", "ok", strings.Join(settings, " "), ""})
+	}
+	return checks
 }
 
-func extractImports(file *ast.File) []string {
-	var imports []string
-	for _, imp := range file.Imports {
-		path := strings.Trim(imp.Path.Value, `"`)
-		imports = append(imports, path)
+// checkEmbedded checks that an embedded database can be opened, or created
+// where --db-path points
+func checkEmbedded(ctx context.Context, cfg Config) []doctorCheck {
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		dir := filepath.Dir(cfg.DBPath)
+		f, err := os.CreateTemp(dir, ".codegraph-doctor-*")
+		if err != nil {
+			return []doctorCheck{{"database", "fail", err.Error(),
+				fmt.Sprintf("create %s or point --db-path at a writable directory", dir)}}
+		}
+		f.Close()
+		os.Remove(f.Name())
+		return []doctorCheck{{"database", "ok", cfg.DBPath + " does not exist yet and will be created", ""}}
 	}
-	return imports
+	return checkBackend(ctx, cfg)
 }
 
-func extractFunction(fn *ast.FuncDecl, file string, fset *token.FileSet) FunctionNode {
-	node := FunctionNode{
-		Name:      fn.Name.Name,
-		File:      file,
-		IsExport:  ast.IsExported(fn.Name.Name),
-		LineStart: fset.Position(fn.Pos()).Line,
-		LineEnd:   fset.Position(fn.End()).Line,
+// checkBackend checks that the backend can be opened
+func checkBackend(ctx context.Context, cfg Config) []doctorCheck {
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		name := "connectivity"
+		if strings.Contains(err.Error(), "authenticating") {
+			name = "auth"
+		}
+		fix := map[string]string{
+			"sqlite":   "--db-path must be a SQLite file this user can write",
+			"kuzu":     "--db-path must be a Kùzu database directory this user can write, not in use by another process",
+			"age":      "check --age-dsn (or AGE_DSN) and that the age extension is installed: CREATE EXTENSION age;",
+			"falkordb": fmt.Sprintf("start FalkorDB or point --falkor-addr at it; a password goes in %s", cfg.FalkorPasswordEnv),
+		}[cfg.Backend]
+		return []doctorCheck{{name, "fail", err.Error(), fix}}
 	}
-
-	// Build signature
-	var sig strings.Builder
-	sig.WriteString("func ")
-
-	// Check for receiver (method)
-	if fn.Recv != nil && len(fn.Recv.List) > 0 {
-		recv := fn.Recv.List[0]
-		recvType := exprToString(recv.Type)
-		node.Receiver = recvType
-		sig.WriteString("(" + recvType + ") ")
+	defer closeBackend(backend)
+	if cfg.Backend == "memory" {
+		return []doctorCheck{{"backend", "ok", "the memory backend needs no database", ""}}
 	}
-
-	sig.WriteString(fn.Name.Name)
-	sig.WriteString(formatParams(fn.Type.Params))
-
-	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
-		sig.WriteString(" ")
-		sig.WriteString(formatParams(fn.Type.Results))
+	checks := []doctorCheck{{"connectivity", "ok", cfg.Backend + " backend opened", ""}}
+	if lister, ok := backend.(codegraph.Lister); ok {
+		if projects, err := lister.Projects(ctx); err == nil {
+			checks = append(checks, doctorCheck{"projects", "ok", fmt.Sprintf("%d stored: %s", len(projects), strings.Join(projects, ", ")), ""})
+		}
 	}
-
-	node.Signature = sig.String()
-	return node
+	return checks
 }
 
-func extractStruct(spec *ast.TypeSpec, st *ast.StructType, file string) StructNode {
-	node := StructNode{
-		Name:     spec.Name.Name,
-		File:     file,
-		IsExport: ast.IsExported(spec.Name.Name),
-	}
-
-	for _, field := range st.Fields.List {
-		fieldType := exprToString(field.Type)
-		for _, name := range field.Names {
-			node.Fields = append(node.Fields, name.Name+" "+fieldType)
+// checkTargets checks each project's path is a directory, and a git
+// repository for the features that read history
+func checkTargets(ctx context.Context, targets []target) []doctorCheck {
+	var checks []doctorCheck
+	for _, t := range targets {
+		name := "project"
+		info, err := os.Stat(t.Path)
+		if err != nil || !info.IsDir() {
+			checks = append(checks, doctorCheck{name, "fail", t.Project + ": " + t.Path + " is not a directory", "fix --path or the path in the config file"})
+			continue
 		}
-		if len(field.Names) == 0 {
-			// Embedded field
-			node.Fields = append(node.Fields, fieldType)
+		if _, err := exec.CommandContext(ctx, "git", "-C", t.Path, "rev-parse", "--git-dir").Output(); err != nil {
+			checks = append(checks, doctorCheck{name, "warn", t.Project + ": " + t.Path + " is not in a git repository",
+				"--rev, --blame, --churn, --since, hook and daemon --poll need one; git init, or leave them off"})
+			continue
 		}
+		checks = append(checks, doctorCheck{name, "ok", t.Project + ": " + t.Path, ""})
 	}
-
-	return node
+	return checks
 }
 
-func extractInterface(spec *ast.TypeSpec, iface *ast.InterfaceType, file string) InterfaceNode {
-	node := InterfaceNode{
-		Name:     spec.Name.Name,
-		File:     file,
-		IsExport: ast.IsExported(spec.Name.Name),
+// checkHeadroom checks the free disk where the database or exports are
+// written, and the memory available for parsing, where the system says
+func checkHeadroom(cfg Config) []doctorCheck {
+	var checks []doctorCheck
+	dir := "."
+	if cfg.Backend == "sqlite" || cfg.Backend == "kuzu" {
+		dir = filepath.Dir(cfg.DBPath)
 	}
-
-	for _, method := range iface.Methods.List {
-		for _, name := range method.Names {
-			if fn, ok := method.Type.(*ast.FuncType); ok {
-				sig := name.Name + formatParams(fn.Params)
-				if fn.Results != nil {
-					sig += " " + formatParams(fn.Results)
-				}
-				node.Methods = append(node.Methods, sig)
-			}
+	if free, err := freeDisk(dir); err == nil {
+		check := doctorCheck{"disk", "ok", fmt.Sprintf("%s free in %s", formatBytes(free), dir), ""}
+		if free < minFreeDisk {
+			check.Status, check.Fix = "warn", "free some space before writing a large graph or export"
+		}
+		checks = append(checks, check)
+	}
+	if free, err := availableMemory(); err == nil {
+		check := doctorCheck{"memory", "ok", formatBytes(free) + " available", ""}
+		if free < minFreeMemory {
+			check.Status, check.Fix = "warn", "large repositories may not fit; index them a --path at a time or with --include"
 		}
+		checks = append(checks, check)
 	}
+	return checks
+}
 
-	return node
+// freeDisk returns the bytes available in dir's filesystem, as df reports
+func freeDisk(dir string) (int64, error) {
+	out, err := exec.Command("df", "-Pk", dir).Output()
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output %q", out)
+	}
+	kb, err := strconv.ParseInt(fields[3], 10, 64)
+	return kb << 10, err
 }
 
-func exprToString(expr ast.Expr) string {
-	switch e := expr.(type) {
-	case *ast.Ident:
-		return e.Name
-	case *ast.StarExpr:
-		return "*" + exprToString(e.X)
-	case *ast.SelectorExpr:
-		return exprToString(e.X) + "." + e.Sel.Name
-	case *ast.ArrayType:
-		return "[]" + exprToString(e.Elt)
-	case *ast.MapType:
-		return "map[" + exprToString(e.Key) + "]" + exprToString(e.Value)
-	case *ast.InterfaceType:
-		return "interface{}"
-	case *ast.FuncType:
-		return "func" + formatParams(e.Params)
-	default:
-		return "..."
+// availableMemory returns MemAvailable from /proc/meminfo, on Linux
+func availableMemory() (int64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
 	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(line, "MemAvailable:"); ok {
+			kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+			return kb << 10, err
+		}
+	}
+	return 0, errors.New("no MemAvailable in /proc/meminfo")
 }
 
-func formatParams(fields *ast.FieldList) string {
-	if fields == nil {
-		return "()"
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.0f MiB", float64(n)/(1<<20))
 	}
+	return fmt.Sprintf("%d KiB", n>>10)
+}
 
-	var parts []string
-	for _, field := range fields.List {
-		fieldType := exprToString(field.Type)
-		if len(field.Names) > 0 {
-			for _, name := range field.Names {
-				parts = append(parts, name.Name+" "+fieldType)
+// printChecks lists the checks, each problem followed by its fix
+func printChecks(w io.Writer, checks []doctorCheck) {
+	for _, c := range checks {
+		fmt.Fprintf(w, "%-4s  %-14s  %s\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+		if c.Status != "ok" && c.Fix != "" {
+			for line := range strings.SplitSeq(c.Fix, "\n") {
+				fmt.Fprintf(w, "%22s%s\n", "", line)
 			}
-		} else {
-			parts = append(parts, fieldType)
 		}
 	}
-	return "(" + strings.Join(parts, ", ") + ")"
 }
 
-func createGraph(ctx context.Context, driver neo4j.DriverWithContext, project string, graph *CodeGraph) error {
-	session := driver.NewSession(ctx, neo4j.SessionConfig{})
-	defer session.Close(ctx)
+// benchProject is the project bench writes, replacing it on every run
+const benchProject = "Bench"
 
-	fmt.Println("Creating graph nodes...")
+// benchModule is the module path of the generated repository
+const benchModule = "example.com/bench"
 
-	// Clear existing project nodes
-	fmt.Printf("  Clearing existing %s:Code nodes...\n", project)
-	_, err := session.Run(ctx, fmt.Sprintf(`
-		MATCH (n:%s) WHERE n:File OR n:Package OR n:Function OR n:Struct OR n:Interface
-		DETACH DELETE n
-	`, project), nil)
-	if err != nil {
-		return fmt.Errorf("clearing nodes: %w", err)
-	}
+// benchRun is how long one bench run spent in each phase
+type benchRun struct {
+	Parse time.Duration
+	Write time.Duration
+}
 
-	// Create Package nodes
-	fmt.Printf("  Creating %d Package nodes...\n", len(graph.Packages))
-	for _, pkg := range graph.Packages {
-		_, err := session.Run(ctx, fmt.Sprintf(`
-			CREATE (p:%s:Package {name: $name, path: $path})
-		`, project), map[string]any{
-			"name": pkg.Name,
-			"path": pkg.Path,
-		})
-		if err != nil {
-			return fmt.Errorf("creating package %s: %w", pkg.Name, err)
-		}
+// runBench generates a repository, parses and writes it cfg.BenchRuns times
+// and prints the percentiles of each phase to w
+func runBench(ctx context.Context, cfg Config, w io.Writer) error {
+	if cfg.BenchPackages < 1 || cfg.BenchFiles < 1 || cfg.BenchFunctions < 1 || cfg.BenchRuns < 1 {
+		return withExit(exitUsage, errors.New("--packages, --files, --functions and --runs must be at least 1"))
 	}
-
-	// Create File nodes with BELONGS_TO package relationship
-	fmt.Printf("  Creating %d File nodes...\n", len(graph.Files))
-	for _, file := range graph.Files {
-		pkgPath := filepath.Dir(file.Path)
-		_, err := session.Run(ctx, fmt.Sprintf(`
-			CREATE (f:%s:File {path: $path, package: $package, language: $language, imports: $imports})
-			WITH f
-			MATCH (p:%s:Package {path: $pkgPath})
-			MERGE (f)-[:BELONGS_TO]->(p)
-		`, project, project), map[string]any{
-			"path":     file.Path,
-			"package":  file.Package,
-			"language": file.Language,
-			"imports":  file.Imports,
-			"pkgPath":  pkgPath,
-		})
+	dir := cfg.BenchDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "codegraph-bench-")
 		if err != nil {
-			return fmt.Errorf("creating file %s: %w", file.Path, err)
+			return err
 		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
 	}
+	if err := generateBenchRepo(dir, cfg.BenchPackages, cfg.BenchFiles, cfg.BenchFunctions); err != nil {
+		return fmt.Errorf("generating repository: %w", err)
+	}
+	cfg.Project, cfg.Path = benchProject, dir
 
-	// Create Function nodes
-	fmt.Printf("  Creating %d Function nodes...\n", len(graph.Functions))
-	for _, fn := range graph.Functions {
-		label := "Function"
-		if fn.Receiver != "" {
-			label = "Method"
-		}
-		_, err := session.Run(ctx, fmt.Sprintf(`
-			CREATE (fn:%s:%s {
-				name: $name,
-				file: $file,
-				signature: $signature,
-				receiver: $receiver,
-				isExport: $isExport,
-				lineStart: $lineStart,
-				lineEnd: $lineEnd
-			})
-			WITH fn
-			MATCH (f:%s:File {path: $file})
-			MERGE (f)-[:CONTAINS]->(fn)
-		`, project, label, project), map[string]any{
-			"name":      fn.Name,
-			"file":      fn.File,
-			"signature": fn.Signature,
-			"receiver":  fn.Receiver,
-			"isExport":  fn.IsExport,
-			"lineStart": fn.LineStart,
-			"lineEnd":   fn.LineEnd,
-		})
-		if err != nil {
-			return fmt.Errorf("creating function %s: %w", fn.Name, err)
-		}
+	backend, err := openBackend(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("opening %s backend: %w", cfg.Backend, err)
 	}
+	defer closeBackend(backend)
 
-	// Create Struct nodes
-	fmt.Printf("  Creating %d Struct nodes...\n", len(graph.Structs))
-	for _, st := range graph.Structs {
-		_, err := session.Run(ctx, fmt.Sprintf(`
-			CREATE (s:%s:Struct {name: $name, file: $file, fields: $fields, isExport: $isExport})
-			WITH s
-			MATCH (f:%s:File {path: $file})
-			MERGE (f)-[:CONTAINS]->(s)
-		`, project, project), map[string]any{
-			"name":     st.Name,
-			"file":     st.File,
-			"fields":   st.Fields,
-			"isExport": st.IsExport,
-		})
+	parser := codegraph.Parser{Root: dir, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}
+	runs := make([]benchRun, cfg.BenchRuns)
+	var graph *codegraph.Graph
+	for i := range runs {
+		start := time.Now()
+		graph, err = parseTree(ctx, parser, "")
 		if err != nil {
-			return fmt.Errorf("creating struct %s: %w", st.Name, err)
+			return withExit(exitParse, err)
 		}
-	}
+		graph.Features = cfg.Features
+		runs[i].Parse = time.Since(start)
 
-	// Create Interface nodes
-	fmt.Printf("  Creating %d Interface nodes...\n", len(graph.Interfaces))
-	for _, iface := range graph.Interfaces {
-		_, err := session.Run(ctx, fmt.Sprintf(`
-			CREATE (i:%s:Interface {name: $name, file: $file, methods: $methods, isExport: $isExport})
-			WITH i
-			MATCH (f:%s:File {path: $file})
-			MERGE (f)-[:CONTAINS]->(i)
-		`, project, project), map[string]any{
-			"name":     iface.Name,
-			"file":     iface.File,
-			"methods":  iface.Methods,
-			"isExport": iface.IsExport,
-		})
-		if err != nil {
-			return fmt.Errorf("creating interface %s: %w", iface.Name, err)
+		start = time.Now()
+		if err := backend.Write(ctx, benchProject, graph, codegraph.NewRunInfo()); err != nil {
+			return fmt.Errorf("writing run %d: %w", i+1, err)
 		}
+		runs[i].Write = time.Since(start)
+		slog.Info("bench run", "run", i+1, "parse", runs[i].Parse, "write", runs[i].Write)
 	}
 
-	// Create IMPORTS relationships between files and packages
-	fmt.Println("  Creating IMPORTS relationships...")
-	for _, file := range graph.Files {
-		for _, imp := range file.Imports {
-			// Try to find the imported package in our codebase
-			_, err := session.Run(ctx, fmt.Sprintf(`
-				MATCH (f:%s:File {path: $filePath})
-				MATCH (p:%s:Package) WHERE $import ENDS WITH p.path
-				MERGE (f)-[:IMPORTS]->(p)
-			`, project, project), map[string]any{
-				"filePath": file.Path,
-				"import":   imp,
-			})
-			if err != nil {
-				// Non-fatal - external imports won't match
-				continue
+	result := graphSummary(benchProject, graph)
+	summary.addProject(result)
+	fmt.Fprintf(w, "%d packages, %d files, %d functions in %s\n", result.Packages, result.Files, result.Functions, dir)
+	fmt.Fprintf(w, "%d runs on the %s backend, writing %d nodes and %d relationships each\n\n",
+		len(runs), cfg.Backend, result.Nodes, result.Relationships)
+	printBench(w, runs, result)
+	return nil
+}
+
+// generateBenchRepo writes a module of packages packages under dir/pkg, each
+// of files files declaring an interface, a struct implementing it and
+// functions functions. Each function calls the next, and the last one the
+// previous package, so calls, imports and implementations all have work.
+func generateBenchRepo(dir string, packages, files, functions int) error {
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+benchModule+"\n\ngo 1.22\n"), 0o644); err != nil {
+		return err
+	}
+	for p := range packages {
+		pkg := fmt.Sprintf("p%03d", p)
+		pkgDir := filepath.Join(dir, "pkg", pkg)
+		if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+			return err
+		}
+		for f := range files {
+			var b strings.Builder
+			fmt.Fprintf(&b, "package %s\n\n", pkg)
+			if p > 0 {
+				fmt.Fprintf(&b, "import %q\n\n", fmt.Sprintf("%s/pkg/p%03d", benchModule, p-1))
+			}
+			fmt.Fprintf(&b, "type Runner%03d interface {\n\tRun(n int) int\n}\n\n", f)
+			fmt.Fprintf(&b, "type State%03d struct {\n\tID   int\n\tName string\n}\n\n", f)
+			fmt.Fprintf(&b, "func (s *State%03d) Run(n int) int {\n\treturn F%03d_000(s.ID + n)\n}\n", f, f)
+			for fn := range functions {
+				next := "n"
+				switch {
+				case fn+1 < functions:
+					next = fmt.Sprintf("F%03d_%03d(n - 1)", f, fn+1)
+				case p > 0:
+					next = fmt.Sprintf("p%03d.F%03d_000(n)", p-1, f)
+				}
+				fmt.Fprintf(&b, "\nfunc F%03d_%03d(n int) int {\n\tif n <= 0 {\n\t\treturn 0\n\t}\n\treturn %s\n}\n", f, fn, next)
+			}
+			if err := os.WriteFile(filepath.Join(pkgDir, fmt.Sprintf("f%03d.go", f)), []byte(b.String()), 0o644); err != nil {
+				return err
 			}
 		}
 	}
+	return nil
+}
 
-	// Print summary
-	result, err := session.Run(ctx, fmt.Sprintf(`
-		MATCH (n:%s)
-		RETURN labels(n) as labels, count(*) as count
-	`, project), nil)
-	if err != nil {
-		return fmt.Errorf("getting summary: %w", err)
+// printBench prints the 50th, 90th and 99th percentile time of each phase
+// with the rate it works at: files parsed, nodes written, and files indexed
+// end to end
+func printBench(w io.Writer, runs []benchRun, result projectSummary) {
+	parse := make([]time.Duration, len(runs))
+	write := make([]time.Duration, len(runs))
+	total := make([]time.Duration, len(runs))
+	for i, r := range runs {
+		parse[i], write[i], total[i] = r.Parse, r.Write, r.Parse+r.Write
+	}
+	phases := []struct {
+		name  string
+		times []time.Duration
+		n     int
+		unit  string
+	}{
+		{"parse", parse, result.Files, "files"},
+		{"write", write, result.Nodes, "nodes"},
+		{"total", total, result.Files, "files"},
+	}
+	fmt.Fprintf(w, "%-6s  %-26s  %-26s  %s\n", "phase", "p50", "p90", "p99")
+	for _, phase := range phases {
+		var cells []string
+		for _, p := range []float64{50, 90, 99} {
+			d := percentile(phase.times, p)
+			cells = append(cells, fmt.Sprintf("%v (%.0f %s/s)", d.Round(time.Microsecond), codegraph.PerSecond(phase.n, d), phase.unit))
+		}
+		fmt.Fprintf(w, "%-6s  %-26s  %-26s  %s\n", phase.name, cells[0], cells[1], cells[2])
 	}
+}
 
-	fmt.Println("\n  Graph summary:")
-	for result.Next(ctx) {
-		record := result.Record()
-		labels, _ := record.Get("labels")
-		count, _ := record.Get("count")
-		fmt.Printf("    %v: %v\n", labels, count)
+// percentile returns the nearest-rank pth percentile of times
+func percentile(times []time.Duration, p float64) time.Duration {
+	if len(times) == 0 {
+		return 0
 	}
+	sorted := slices.Clone(times)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
 
+// printStatements prints each statement the Neo4j backend would run, batch
+// by batch, with its parameters as JSON
+func printStatements(w io.Writer, stmts <-chan codegraph.Statement, batchSize int) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	for stmt := range stmts {
+		batches := stmt.Batches(batchSize)
+		for i, batch := range batches {
+			fmt.Fprintf(w, "\n// %s", stmt.Phase)
+			if batch.Rows != nil {
+				fmt.Fprintf(w, " (batch %d/%d, %d rows)", i+1, len(batches), len(batch.Rows))
+			}
+			fmt.Fprintf(w, "\n%s\n", codegraph.TrimQuery(batch.Query))
+			if len(batch.Params) > 0 {
+				fmt.Fprintf(w, "// params:\n")
+				if err := enc.Encode(batch.Params); err != nil {
+					return err
+				}
+			}
+		}
+	}
 	return nil
 }
 
-func printSample(graph *CodeGraph) {
+func printSample(graph *codegraph.Graph) {
 	fmt.Println("\nSample data:")
 
 	fmt.Println("\nPackages:")
@@ -572,3 +10815,168 @@ func printSample(graph *CodeGraph) {
 		fmt.Printf("  - %s (methods: %d)\n", iface.Name, len(iface.Methods))
 	}
 }
+
+// runBreaking prints the changes to the exported API from --base to --path,
+// or its tree at --rev, that can break importers, failing with exitBreaking
+// if there are any
+func runBreaking(ctx context.Context, cfg Config, w io.Writer) error {
+	if cfg.Base == "" {
+		return withExit(exitUsage, errors.New("breaking needs a --base tree, export or git revision to compare against"))
+	}
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return withExit(exitUsage, fmt.Errorf("unknown format %q, expected text or json", cfg.Format))
+	}
+	before, err := parseBase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	parser := codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}
+	after, err := parseTree(ctx, parser, cfg.Rev)
+	if err != nil {
+		return withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+
+	changes := codegraph.BreakingChanges(before, after)
+	if cfg.Format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if changes == nil {
+			changes = []codegraph.APIChange{}
+		}
+		if err := enc.Encode(changes); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, change := range changes {
+			fmt.Fprintf(tw, "%s.%s\t%s\t%s\n", change.Package, change.Name, change.Kind, change.Message)
+		}
+		tw.Flush()
+	}
+	if len(changes) > 0 {
+		return withExit(exitBreaking, fmt.Errorf("%d breaking changes to the exported API since %s", len(changes), cfg.Base))
+	}
+	return nil
+}
+
+// parseBase reads the graph --base names: a JSON export, a source tree, or
+// failing both the tree of --path at that git revision
+func parseBase(ctx context.Context, cfg Config) (*codegraph.Graph, error) {
+	if strings.HasSuffix(cfg.Base, ".json") {
+		graph, err := readGraphJSON(cfg.Base)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", cfg.Base, err)
+		}
+		return graph, nil
+	}
+	var graph *codegraph.Graph
+	var err error
+	if info, statErr := os.Stat(cfg.Base); statErr == nil && info.IsDir() {
+		graph, err = parseTree(ctx, codegraph.Parser{Root: cfg.Base, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	} else {
+		graph, err = parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, CacheDir: cfg.CacheDir}, cfg.Base)
+	}
+	if err != nil {
+		return nil, withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Base, err))
+	}
+	return graph, nil
+}
+
+// runCheck prints the imports of --path breaking the layering rules of
+// --rule, failing with exitRules if there are any
+func runCheck(ctx context.Context, cfg Config, w io.Writer) error {
+	if len(cfg.Rules) == 0 {
+		return withExit(exitUsage, errors.New("check needs a --rule, on the command line or in the config file"))
+	}
+	rules := make([]codegraph.ImportRule, 0, len(cfg.Rules))
+	for _, value := range cfg.Rules {
+		rule, err := codegraph.ParseImportRule(value)
+		if err != nil {
+			return withExit(exitUsage, err)
+		}
+		rules = append(rules, rule)
+	}
+	graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: cfg.Filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	if err != nil {
+		return withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+
+	violations := codegraph.CheckRules(graph, rules)
+	for _, violation := range violations {
+		fmt.Fprintln(w, violation.Message)
+	}
+	if len(violations) > 0 {
+		return withExit(exitRules, fmt.Errorf("%d imports break the layering rules", len(violations)))
+	}
+	slog.Info("checked rules", "project", cfg.Project, "rules", len(rules), "files", len(graph.Files))
+	return nil
+}
+
+// runUntested prints the functions of --path that the diff of --diff, or
+// of the working tree against --base, changed and no test reaches,
+// failing with exitUntested if there are any
+func runUntested(ctx context.Context, cfg Config, stdin io.Reader, w io.Writer) error {
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return withExit(exitUsage, fmt.Errorf("unknown format %q, expected text or json", cfg.Format))
+	}
+	var diff string
+	switch cfg.Diff {
+	case "":
+		var err error
+		if diff, err = codegraph.GitDiff(ctx, cfg.Path, cmp.Or(cfg.Base, "HEAD")); err != nil {
+			return err
+		}
+	case "-":
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("reading the diff: %w", err)
+		}
+		diff = string(data)
+	default:
+		data, err := os.ReadFile(cfg.Diff)
+		if err != nil {
+			return withExit(exitUsage, fmt.Errorf("reading the diff: %w", err))
+		}
+		diff = string(data)
+	}
+	filter := cfg.Filter
+	filter.Tests = true
+	graph, err := parseTree(ctx, codegraph.Parser{Root: cfg.Path, Filter: filter, SyntaxOnly: cfg.SyntaxOnly, CacheDir: cfg.CacheDir}, "")
+	if err != nil {
+		return withExit(exitParse, fmt.Errorf("parsing %s: %w", cfg.Path, err))
+	}
+
+	changed := codegraph.ChangedFunctions(graph, diff)
+	untested := codegraph.UntestedChanges(graph, diff)
+	if cfg.Format == "json" {
+		type function struct {
+			Key  string `json:"key"`
+			Name string `json:"name"`
+			File string `json:"file"`
+			Line int    `json:"line"`
+		}
+		out := struct {
+			Changed  int        `json:"changed"`
+			Untested []function `json:"untested"`
+		}{Changed: len(changed), Untested: []function{}}
+		for _, fn := range untested {
+			out.Untested = append(out.Untested, function{Key: fn.Key(), Name: fn.Name, File: fn.File, Line: fn.LineStart})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	} else {
+		for _, fn := range untested {
+			fmt.Fprintf(w, "%s:%d\t%s changed but no test reaches it\n", fn.File, fn.LineStart, fn.Name)
+		}
+	}
+	if len(untested) > 0 {
+		return withExit(exitUntested, fmt.Errorf("%d of %d changed functions have no tests reaching them", len(untested), len(changed)))
+	}
+	slog.Info("checked changed functions", "project", cfg.Project, "changed", len(changed))
+	return nil
+}
@@ -0,0 +1,5354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amarodeabreu/claude-graph-memory/pkg/codegraph"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/parquet-go/parquet-go"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testTree is a small codebase with calls within and across packages, a
+// method called through its receiver and an interface it implements
+var testTree = map[string]string{
+	"main.go": `package main
+
+import "example.com/app/store"
+
+func main() {
+	s := store.New()
+	s.Put("k")
+	run()
+}
+
+func run() {}
+`,
+	"store/store.go": `package store
+
+// Putter stores keys
+type Putter interface {
+	Put(key string) error
+}
+
+type Store struct {
+	keys []string
+}
+
+func New() *Store { return &Store{} }
+
+func (s *Store) Put(key string) error {
+	s.keys = append(s.keys, key)
+	return s.flush()
+}
+
+func (s *Store) flush() error { return nil }
+`,
+	"store/store_test.go": `package store
+
+func TestPut() {}
+`,
+	"vendor/dep/dep.go": `package dep
+
+func Vendored() {}
+`,
+}
+
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, src := range files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func parseTestTree(t *testing.T) *codegraph.Graph {
+	t.Helper()
+	graph, err := codegraph.Parser{Root: writeTree(t, testTree)}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+func TestDiffMaps(t *testing.T) {
+	diff := diffMaps(
+		map[string]string{"kept": "a", "changed": "old", "removed": "x"},
+		map[string]string{"kept": "a", "changed": "new", "added": "y"},
+	)
+	if want := []string{"added"}; !slices.Equal(diff.Added, want) {
+		t.Errorf("added = %q, want %q", diff.Added, want)
+	}
+	if want := []string{"removed"}; !slices.Equal(diff.Removed, want) {
+		t.Errorf("removed = %q, want %q", diff.Removed, want)
+	}
+	if want := []ChangedSymbol{{Key: "changed", Before: "old", After: "new"}}; !slices.Equal(diff.Changed, want) {
+		t.Errorf("changed = %+v, want %+v", diff.Changed, want)
+	}
+
+	empty := diffMaps(nil, nil)
+	if empty.Added == nil || empty.Removed == nil || empty.Changed == nil {
+		t.Error("empty diff has null lists")
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	before := snapshotFromGraph(parseTestTree(t))
+
+	files := make(map[string]string)
+	for path, src := range testTree {
+		files[path] = src
+	}
+	files["main.go"] = `package main
+
+func main() { helper(1) }
+
+func helper(n int) {}
+`
+	graph, err := codegraph.Parser{Root: writeTree(t, files)}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := diffSnapshots(before, snapshotFromGraph(graph))
+
+	if want := []string{"Function:main.go:helper"}; !slices.Equal(diff.Functions.Added, want) {
+		t.Errorf("added functions = %q, want %q", diff.Functions.Added, want)
+	}
+	if want := []string{"Function:main.go:run"}; !slices.Equal(diff.Functions.Removed, want) {
+		t.Errorf("removed functions = %q, want %q", diff.Functions.Removed, want)
+	}
+	if len(diff.Functions.Changed) != 0 {
+		t.Errorf("changed functions = %+v, want none", diff.Functions.Changed)
+	}
+	if len(diff.Structs.Added)+len(diff.Structs.Removed)+len(diff.Structs.Changed) != 0 {
+		t.Errorf("structs differ: %+v", diff.Structs)
+	}
+	want := []string{
+		"File:main.go -[CONTAINS]-> Function:main.go:run",
+		"File:main.go -[IMPORTS]-> Package:store",
+		"Function:main.go:main -[CALLS]-> Function:main.go:run",
+		"Function:main.go:main -[CALLS]-> Function:store/store.go:*Store.Put",
+		"Function:main.go:main -[CALLS]-> Function:store/store.go:New",
+	}
+	if !slices.Equal(diff.Relationships.Removed, want) {
+		t.Errorf("removed relationships = %q, want %q", diff.Relationships.Removed, want)
+	}
+	want = []string{
+		"File:main.go -[CONTAINS]-> Function:main.go:helper",
+		"Function:main.go:main -[CALLS]-> Function:main.go:helper",
+	}
+	if !slices.Equal(diff.Relationships.Added, want) {
+		t.Errorf("added relationships = %q, want %q", diff.Relationships.Added, want)
+	}
+}
+
+func TestNodeKeyFromProps(t *testing.T) {
+	renamed := codegraph.LabelMap{Prefix: "CG_", Rename: map[string]string{"Struct": "Class"}}
+	tests := []struct {
+		labelMap codegraph.LabelMap
+		labels   []any
+		props    map[string]any
+		want     string
+	}{
+		{codegraph.LabelMap{}, []any{"App", "Package"}, map[string]any{"path": "store"}, "Package:store"},
+		{codegraph.LabelMap{}, []any{"App", "File"}, map[string]any{"path": "main.go"}, "File:main.go"},
+		{codegraph.LabelMap{}, []any{"App", "Function"}, map[string]any{"name": "run", "file": "main.go"}, "Function:main.go:run"},
+		{codegraph.LabelMap{}, []any{"App", "Method"}, map[string]any{"name": "Put", "file": "store/store.go", "receiver": "*Store"}, "Function:store/store.go:*Store.Put"},
+		{codegraph.LabelMap{}, []any{"App", "Struct"}, map[string]any{"name": "Store", "file": "store/store.go"}, "Struct:store/store.go:Store"},
+		{codegraph.LabelMap{}, []any{"App", "Interface"}, map[string]any{"name": "Putter", "file": "store/store.go"}, "Interface:store/store.go:Putter"},
+		{codegraph.LabelMap{}, []any{"App", "Memory"}, map[string]any{"id": "m1"}, ""},
+		{renamed, []any{"App", "CG_Class"}, map[string]any{"name": "Store", "file": "store/store.go"}, "Struct:store/store.go:Store"},
+		{renamed, []any{"App", "Struct"}, map[string]any{"name": "Store", "file": "store/store.go"}, ""},
+	}
+	for _, tt := range tests {
+		if got := nodeKeyFromProps(tt.labelMap, tt.labels, tt.props); got != tt.want {
+			t.Errorf("nodeKeyFromProps(%+v, %v, %v) = %q, want %q", tt.labelMap, tt.labels, tt.props, got, tt.want)
+		}
+	}
+}
+
+func TestInlineParams(t *testing.T) {
+	query := `
+		MATCH (f:App:File {path: $path})
+		SET f.size = $size, f.other = $missing
+	`
+	got := inlineParams(query, map[string]any{"path": "main.go", "size": 3})
+	want := "MATCH (f:App:File {path: 'main.go'})\nSET f.size = 3, f.other = $missing"
+	if got != want {
+		t.Errorf("inlineParams = %q, want %q", got, want)
+	}
+}
+
+func TestWriteCypherScript(t *testing.T) {
+	run := codegraph.RunInfo{ID: "run-1", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	var buf bytes.Buffer
+	if err := writeCypherScript(&buf, "App", codegraph.StreamStatements(context.Background(), "App", parseTestTree(t), run, codegraph.StatementOptions{}), run, 1); err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+	for _, want := range []string{
+		"// Run run-1 generated 2024-01-02T03:04:05Z\n",
+		"\n// Creating 2 Package nodes\n",
+		"UNWIND [{`name`: 'store', `path`: 'store'}] AS row\n" +
+			"CREATE (p:App:Package {path: row.path, name: row.name, createdAt: datetime('2024-01-02T03:04:05Z'), updatedAt: datetime('2024-01-02T03:04:05Z'), runId: 'run-1', commit: null});\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "$") {
+		t.Errorf("script has parameters left:\n%s", script)
+	}
+}
+
+// readCSV reads every record of the CSV file at path
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestWriteCSVExport(t *testing.T) {
+	graph := parseTestTree(t)
+	dir := filepath.Join(t.TempDir(), "export")
+	run := codegraph.RunInfo{ID: "run-1", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := writeCSVExport(dir, "App", graph, run, codegraph.LabelMap{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file   string
+		header []string
+		rows   int
+	}{
+		{"packages.csv", []string{":ID", "name", "path", "createdAt:datetime", "updatedAt:datetime", "runId", ":LABEL"}, 2},
+		{"files.csv", []string{":ID", "path", "package", "language", "imports:string[]", "createdAt:datetime", "updatedAt:datetime", "runId", ":LABEL"}, 2},
+		{"functions.csv", []string{":ID", "name", "file", "signature", "receiver", "isExport:boolean", "lineStart:int", "lineEnd:int", "createdAt:datetime", "updatedAt:datetime", "runId", ":LABEL"}, 5},
+		{"structs.csv", []string{":ID", "name", "file", "fields:string[]", "isExport:boolean", "createdAt:datetime", "updatedAt:datetime", "runId", ":LABEL"}, 1},
+		{"interfaces.csv", []string{":ID", "name", "file", "methods:string[]", "isExport:boolean", "createdAt:datetime", "updatedAt:datetime", "runId", ":LABEL"}, 1},
+		{"relationships.csv", []string{":START_ID", ":END_ID", ":TYPE", "createdAt:datetime", "updatedAt:datetime", "runId"}, len(graph.Relationships())},
+	}
+	for _, tt := range tests {
+		records := readCSV(t, filepath.Join(dir, tt.file))
+		if !slices.Equal(records[0], tt.header) {
+			t.Errorf("%s header = %q, want %q", tt.file, records[0], tt.header)
+		}
+		if n := len(records) - 1; n != tt.rows {
+			t.Errorf("%s has %d rows, want %d", tt.file, n, tt.rows)
+		}
+	}
+
+	structs := readCSV(t, filepath.Join(dir, "structs.csv"))
+	want := []string{"Struct:store/store.go:Store", "Store", "store/store.go", "keys []string", "true",
+		"2024-01-02T03:04:05Z", "2024-01-02T03:04:05Z", "run-1", "App;Struct"}
+	if !slices.Equal(structs[1], want) {
+		t.Errorf("struct row = %q, want %q", structs[1], want)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "import.sh")); err != nil || info.Mode()&0o100 == 0 {
+		t.Errorf("import.sh not written executable: %v", err)
+	}
+
+	if err := exportGraph(Config{ExportFormat: "csv", Out: "-"}, graph, run); err == nil {
+		t.Error("csv export to stdout accepted")
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	graph := parseTestTree(t)
+	var buf bytes.Buffer
+	if err := writeGraphML(&buf, "A&B", graph); err != nil {
+		t.Fatal(err)
+	}
+
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	var doc struct {
+		Keys  []struct{ ID, Type string } `xml:"key"`
+		Graph struct {
+			ID    string `xml:"id,attr"`
+			Nodes []struct {
+				ID   string `xml:"id,attr"`
+				Data []data `xml:"data"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+				Data   []data `xml:"data"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid GraphML: %v\n%s", err, buf.String())
+	}
+	if doc.Graph.ID != "A&B" {
+		t.Errorf("graph id = %q, want A&B", doc.Graph.ID)
+	}
+	if len(doc.Graph.Nodes) != len(graph.Nodes()) {
+		t.Errorf("%d nodes, want %d", len(doc.Graph.Nodes), len(graph.Nodes()))
+	}
+	if len(doc.Graph.Edges) != len(graph.Relationships()) {
+		t.Errorf("%d edges, want %d", len(doc.Graph.Edges), len(graph.Relationships()))
+	}
+
+	values := make(map[string]string)
+	for _, node := range doc.Graph.Nodes {
+		if node.ID == "Struct:store/store.go:Store" {
+			for _, d := range node.Data {
+				values[d.Key] = d.Value
+			}
+		}
+	}
+	for key, want := range map[string]string{"label": "Store", "kind": "Struct", "fields": "keys []string", "isExport": "true"} {
+		if values[key] != want {
+			t.Errorf("Store %s = %q, want %q", key, values[key], want)
+		}
+	}
+}
+
+func TestGraphMLTypes(t *testing.T) {
+	tests := []struct {
+		value     any
+		typ, text string
+	}{
+		{true, "boolean", "true"},
+		{12, "int", "12"},
+		{"x", "string", "x"},
+		{[]string{"a", "b"}, "string", "a;b"},
+	}
+	for _, tt := range tests {
+		if got := graphMLType(tt.value); got != tt.typ {
+			t.Errorf("graphMLType(%#v) = %s, want %s", tt.value, got, tt.typ)
+		}
+		if got := graphMLValue(tt.value); got != tt.text {
+			t.Errorf("graphMLValue(%#v) = %s, want %s", tt.value, got, tt.text)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	graph := parseTestTree(t)
+	tests := []struct {
+		view, pkg string
+		want      []string
+		notWant   []string
+	}{
+		{"imports", "", []string{
+			`"Package:." [label="main\n."];`,
+			`"Package:." -> "Package:store";`,
+		}, nil},
+		{"structure", "store", []string{
+			`label="store";`,
+			`"File:store/store.go" [label="store.go", shape=folder];`,
+			`"Struct:store/store.go:Store" [label="Store", shape=record];`,
+			`"Interface:store/store.go:Putter" [label="Putter", shape=component];`,
+			`"Function:store/store.go:*Store.Put" [label="*Store.Put", shape=ellipse];`,
+			`"File:store/store.go" -> "Struct:store/store.go:Store";`,
+		}, []string{"main.go"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeDOT(&buf, "App", graph, tt.view, tt.pkg); err != nil {
+			t.Fatalf("%s: %v", tt.view, err)
+		}
+		dot := buf.String()
+		if !strings.HasPrefix(dot, `digraph "App" {`) || !strings.HasSuffix(dot, "}\n") {
+			t.Errorf("%s: not a digraph:\n%s", tt.view, dot)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(dot, want) {
+				t.Errorf("%s: missing %s in\n%s", tt.view, want, dot)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(dot, notWant) {
+				t.Errorf("%s: %s outside --dot-package in\n%s", tt.view, notWant, dot)
+			}
+		}
+	}
+
+	if err := writeDOT(&bytes.Buffer{}, "App", graph, "calls", ""); err == nil {
+		t.Error("unknown view accepted")
+	}
+}
+
+func TestDotID(t *testing.T) {
+	for s, want := range map[string]string{
+		"plain":      `"plain"`,
+		`say "hi"`:   `"say \"hi\""`,
+		"a\nb":       `"a\nb"`,
+		`back\slash`: `"back\\slash"`,
+	} {
+		if got := dotID(s); got != want {
+			t.Errorf("dotID(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestGraphJSONRoundTrip(t *testing.T) {
+	graph := parseTestTree(t)
+	path := filepath.Join(t.TempDir(), "graph.json")
+	run := codegraph.RunInfo{ID: "run-1", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := exportGraph(Config{Project: "App", ExportFormat: "json", Out: path}, graph, run); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["project"] != "App" || doc["runId"] != "run-1" {
+		t.Errorf("project, runId = %v, %v, want App, run-1", doc["project"], doc["runId"])
+	}
+	if rels, _ := doc["relationships"].([]any); len(rels) != len(graph.Relationships()) {
+		t.Errorf("%d relationships exported, want %d", len(rels), len(graph.Relationships()))
+	}
+
+	read, err := readGraphJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := diffSnapshots(snapshotFromGraph(graph), snapshotFromGraph(read))
+	for _, d := range []SymbolDiff{diff.Functions, diff.Structs, diff.Interfaces, diff.Relationships} {
+		if len(d.Added)+len(d.Removed)+len(d.Changed) != 0 {
+			t.Errorf("graph read back differs: %+v", d)
+		}
+	}
+}
+
+func TestBaseTypeName(t *testing.T) {
+	tests := []struct {
+		expr, qualifier, name string
+	}{
+		{"Store", "", "Store"},
+		{"*Store", "", "Store"},
+		{"[]*store.Store", "store", "Store"},
+		{"map[string][]Putter", "", "Putter"},
+	}
+	for _, tt := range tests {
+		if qualifier, name := baseTypeName(tt.expr); qualifier != tt.qualifier || name != tt.name {
+			t.Errorf("baseTypeName(%q) = %q, %q, want %q, %q", tt.expr, qualifier, name, tt.qualifier, tt.name)
+		}
+	}
+}
+
+func TestMermaidMember(t *testing.T) {
+	for member, want := range map[string]string{
+		"Put(key string) error":         "+Put(key string) error",
+		"keys []string":                 "-keys []string",
+		"Values map[string]interface{}": "+Values map[string]any",
+		"opts struct{}":                 "-opts struct",
+	} {
+		if got := mermaidMember(member); got != want {
+			t.Errorf("mermaidMember(%q) = %q, want %q", member, got, want)
+		}
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	graph := parseTestTree(t)
+	tests := []struct {
+		view, pkg, typ string
+		want           []string
+	}{
+		{"class", "store", "", []string{
+			"classDiagram\n",
+			`class Struct_store_store_go_Store["Store"]`,
+			"Struct_store_store_go_Store : -keys []string",
+			"Struct_store_store_go_Store : +Put(key string) (error)",
+			"Struct_store_store_go_Store : -flush() (error)",
+			"<<interface>> Interface_store_store_go_Putter",
+			"Interface_store_store_go_Putter <|.. Struct_store_store_go_Store",
+		}},
+		{"class", "", "Putter", []string{
+			`class Interface_store_store_go_Putter["Putter"]`,
+			`class Struct_store_store_go_Store["Store"]`,
+			"Interface_store_store_go_Putter <|.. Struct_store_store_go_Store",
+		}},
+		{"flowchart", "", "", []string{
+			"flowchart LR\n",
+			`Package__["main"] --> Package_store["store"]`,
+		}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeMermaid(&buf, graph, tt.view, tt.pkg, tt.typ); err != nil {
+			t.Fatalf("%s: %v", tt.view, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s %s%s: missing %s in\n%s", tt.view, tt.pkg, tt.typ, want, buf.String())
+			}
+		}
+	}
+
+	if err := writeMermaid(&bytes.Buffer{}, graph, "class", "", ""); err == nil {
+		t.Error("class diagram of every type accepted")
+	}
+	if err := writeMermaid(&bytes.Buffer{}, graph, "sequence", "", ""); err == nil {
+		t.Error("unknown view accepted")
+	}
+}
+
+func TestOpenBackendUnknown(t *testing.T) {
+	if _, err := openBackend(context.Background(), Config{Backend: "oracle"}); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
+
+func TestGetEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		n     int
+		d     time.Duration
+		b     bool
+	}{
+		{"", 7, time.Minute, true},
+		{"3", 3, time.Minute, true},
+		{"90s", 7, 90 * time.Second, true},
+		{"false", 7, time.Minute, false},
+		{"nonsense", 7, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("CODEGRAPH_TEST", tt.value)
+		if n := getEnvInt("CODEGRAPH_TEST", 7); n != tt.n {
+			t.Errorf("getEnvInt(%q) = %d, want %d", tt.value, n, tt.n)
+		}
+		if d := getEnvDuration("CODEGRAPH_TEST", time.Minute); d != tt.d {
+			t.Errorf("getEnvDuration(%q) = %v, want %v", tt.value, d, tt.d)
+		}
+		if b := getEnvBool("CODEGRAPH_TEST", true); b != tt.b {
+			t.Errorf("getEnvBool(%q) = %v, want %v", tt.value, b, tt.b)
+		}
+	}
+}
+
+func TestDescribeCancel(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	cause := errors.New("write failed")
+	tests := []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), "write failed"},
+		{cancelled, "interrupted: write failed"},
+		{expired, "timed out: write failed"},
+	}
+	for _, tt := range tests {
+		err := describeCancel(tt.ctx, cause)
+		if err.Error() != tt.want {
+			t.Errorf("describeCancel = %q, want %q", err, tt.want)
+		}
+		if !errors.Is(err, cause) {
+			t.Errorf("describeCancel(%q) does not wrap the error", err)
+		}
+	}
+}
+
+func TestPrintStatements(t *testing.T) {
+	stmts := make(chan codegraph.Statement, 2)
+	for _, stmt := range []codegraph.Statement{
+		{Phase: "Clearing", Query: "\n\t\tMATCH (n:App)\n\n\t\tDETACH DELETE n\n\t"},
+		{Phase: "Creating 3 Package nodes", Query: "\n\t\tUNWIND $rows AS row\n\t\tCREATE (:App:Package {path: row.path})\n\t",
+			Params: map[string]any{"runId": "run-1"},
+			Rows:   []map[string]any{{"path": "a"}, {"path": "b"}, {"path": "c"}}},
+	} {
+		stmts <- stmt
+	}
+	close(stmts)
+	var buf bytes.Buffer
+	if err := printStatements(&buf, stmts, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\n// Clearing\nMATCH (n:App)\nDETACH DELETE n\n",
+		"\n// Creating 3 Package nodes (batch 1/2, 2 rows)\nUNWIND $rows AS row\nCREATE (:App:Package {path: row.path})\n// params:\n",
+		"\n// Creating 3 Package nodes (batch 2/2, 1 rows)\n",
+		`"runId": "run-1"`,
+		`"path": "c"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in\n%s", want, buf.String())
+		}
+	}
+	if strings.Count(buf.String(), "// params:") != 2 {
+		t.Errorf("params printed for a statement without any:\n%s", buf.String())
+	}
+}
+
+// fakeFalkor serves the Redis protocol on a local port, answering every
+// GRAPH.QUERY with an empty result, and records the commands it gets
+type fakeFalkor struct {
+	addr     string
+	commands chan []string
+}
+
+func startFakeFalkor(t *testing.T) *fakeFalkor {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeFalkor{addr: l.Addr().String(), commands: make(chan []string, 1000)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			f.commands <- args
+			reply := "*3\r\n*0\r\n*0\r\n*0\r\n"
+			if args[0] != "GRAPH.QUERY" {
+				reply = "+OK\r\n"
+			}
+			if _, err := io.WriteString(conn, reply); err != nil {
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// readCommand reads one command, an array of bulk strings, sent by a Redis
+// client
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestWriteParquetExport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "export")
+	graph := parseTestTree(t)
+	run := codegraph.RunInfo{ID: "run-1", StartedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := writeParquetExport(dir, "App", graph, run); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := parquet.ReadFile[parquetNode](filepath.Join(dir, "nodes.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]parquetNode)
+	for _, node := range nodes {
+		if node.Project != "App" || node.RunID != "run-1" || !node.CreatedAt.Equal(run.StartedAt) {
+			t.Errorf("node %s: project %q, run %q, created %v", node.ID, node.Project, node.RunID, node.CreatedAt)
+		}
+		byID[node.ID] = node
+	}
+
+	tests := []struct {
+		id, label, name string
+		items           []string
+	}{
+		{"Package:store", "Package", "store", nil},
+		{"File:main.go", "File", "", []string{"example.com/app/store"}},
+		{"Function:main.go:main", "Function", "main", nil},
+		{"Function:store/store.go:*Store.Put", "Method", "Put", nil},
+		{"Struct:store/store.go:Store", "Struct", "Store", []string{"keys []string"}},
+		{"Interface:store/store.go:Putter", "Interface", "Putter", graph.Interfaces[0].Methods},
+	}
+	for _, tt := range tests {
+		node, ok := byID[tt.id]
+		if !ok {
+			t.Errorf("no node %s in %d nodes", tt.id, len(nodes))
+			continue
+		}
+		if node.Label != tt.label || node.Name != tt.name || !slices.Equal(node.Items, tt.items) {
+			t.Errorf("node %s = %s %q %q, want %s %q %q", tt.id, node.Label, node.Name, node.Items, tt.label, tt.name, tt.items)
+		}
+	}
+	if len(nodes) != 11 {
+		t.Errorf("%d nodes, want 11", len(nodes))
+	}
+
+	edges, err := parquet.ReadFile[parquetEdge](filepath.Join(dir, "edges.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edges) != len(graph.Relationships()) {
+		t.Errorf("%d edges, want %d", len(edges), len(graph.Relationships()))
+	}
+	want := parquetEdge{Project: "App", Type: "IMPORTS", Source: "File:main.go", Target: "Package:store", RunID: "run-1", CreatedAt: run.StartedAt}
+	if !slices.ContainsFunc(edges, func(e parquetEdge) bool { return e == want }) {
+		t.Errorf("edges = %+v, missing %+v", edges, want)
+	}
+}
+
+// recordingBackend records the writes made to it; incrementalBackend also
+// accepts per-file writes
+type recordingBackend struct {
+	writes chan []string
+}
+
+func (b *recordingBackend) Write(ctx context.Context, project string, graph *codegraph.Graph, run codegraph.RunInfo) error {
+	b.writes <- nil
+	return nil
+}
+
+func (b *recordingBackend) Close(ctx context.Context) error { return nil }
+
+type incrementalBackend struct {
+	recordingBackend
+	graph *codegraph.Graph
+}
+
+func (b *incrementalBackend) WriteFiles(ctx context.Context, project string, graph *codegraph.Graph, files []string, run codegraph.RunInfo) error {
+	b.graph = graph
+	b.writes <- files
+	return nil
+}
+
+func TestApplyChanges(t *testing.T) {
+	root := writeTree(t, testTree)
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Path: root, Project: "App"}
+
+	full := &recordingBackend{writes: make(chan []string, 1)}
+	if err := applyChanges(context.Background(), cfg, full, graph, []string{filepath.Join(root, "main.go")}); err != nil {
+		t.Fatal(err)
+	}
+	if files := <-full.writes; files != nil {
+		t.Errorf("plain backend got a partial write of %q", files)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "store", "store.go")); err != nil {
+		t.Fatal(err)
+	}
+	backend := &incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string, 1)}}
+	paths := []string{filepath.Join(root, "main.go"), filepath.Join(root, "store", "store.go")}
+	if err := applyChanges(context.Background(), cfg, backend, graph, paths); err != nil {
+		t.Fatal(err)
+	}
+	if files := <-backend.writes; !slices.Equal(files, []string{"main.go", "store/store.go"}) {
+		t.Errorf("WriteFiles files = %q", files)
+	}
+	if len(graph.Files) != 1 || len(graph.Functions) != 1 || len(graph.Structs) != 0 {
+		t.Errorf("graph after changes: %d files, %d functions, %d structs", len(graph.Files), len(graph.Functions), len(graph.Structs))
+	}
+}
+
+func TestParseTargetPlugins(t *testing.T) {
+	root := writeTree(t, testTree)
+	if err := os.WriteFile(filepath.Join(root, "TEAM"), []byte("payments\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Tags each file with the team named in TEAM, read from --path
+	plugin := `team=$(cat TEAM); while read -r line; do
+		path=$(printf '%s' "$line" | sed 's/^{"file":{"path":"\([^"]*\)".*/\1/')
+		printf '{"properties": {"File:%s": {"team": "%s"}}}\n' "$path" "$team"
+	done`
+	cfg := Config{Path: root, Project: "App", Plugins: []string{plugin}}
+	graph, err := parseTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range graph.Files {
+		if got := graph.Properties[file.Key()]["team"]; got != "payments" {
+			t.Errorf("%s team = %v, want payments", file.Path, got)
+		}
+	}
+
+	cfg.Plugins = append(cfg.Plugins, "exit 3")
+	if _, err := parseTarget(context.Background(), cfg); err == nil {
+		t.Error("no error from a plugin that exited")
+	}
+}
+
+func TestWatchCodebase(t *testing.T) {
+	root := writeTree(t, testTree)
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := &incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string, 10)}}
+	done := make(chan error, 1)
+	go func() { done <- watchCodebase(ctx, Config{Path: root, Project: "App"}, backend, graph) }()
+
+	// Give the watcher time to register the tree, then add a package
+	time.Sleep(200 * time.Millisecond)
+	dir := filepath.Join(root, "extra")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra.go"), []byte("package extra\n\nfunc Extra() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case files := <-backend.writes:
+		if !slices.Contains(files, "extra/extra.go") {
+			t.Errorf("WriteFiles files = %q, want extra/extra.go", files)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no write after adding a file")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("watchCodebase = %v", err)
+	}
+	if !slices.ContainsFunc(graph.Functions, func(fn codegraph.FunctionNode) bool { return fn.Name == "Extra" }) {
+		t.Error("graph does not hold the new function")
+	}
+}
+
+// gitRepo writes files into a new git repository and commits them
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := writeTree(t, files)
+	git(t, root, "init", "-q")
+	git(t, root, "add", "-A")
+	git(t, root, "commit", "-q", "-m", "initial")
+	return root
+}
+
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestTouchedFiles(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, testTree)
+
+	files, err := touchedFiles(ctx, root, "post-commit", codegraph.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main.go", filepath.Join("store", "store.go")}; !slices.Equal(files, want) {
+		t.Errorf("post-commit files = %q, want %q", files, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "README"), []byte("docs\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "mv", "store/store.go", "store/kv.go")
+	git(t, root, "add", "-A")
+
+	tests := []struct {
+		stage string
+		want  []string
+		err   bool
+	}{
+		{"pre-commit", []string{"main.go", filepath.Join("store", "kv.go"), filepath.Join("store", "store.go")}, false},
+		{"pre-push", nil, true},
+	}
+	for _, tt := range tests {
+		files, err := touchedFiles(ctx, root, tt.stage, codegraph.Filter{})
+		if (err != nil) != tt.err {
+			t.Errorf("touchedFiles(%s) error = %v, want error %v", tt.stage, err, tt.err)
+		}
+		if !slices.Equal(files, tt.want) {
+			t.Errorf("touchedFiles(%s) = %q, want %q", tt.stage, files, tt.want)
+		}
+	}
+
+	if _, err := touchedFiles(ctx, t.TempDir(), "post-commit", codegraph.Filter{}); err == nil {
+		t.Error("touchedFiles outside a repository succeeded")
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	root := gitRepo(t, testTree)
+	git(t, root, "rm", "-q", "store/store.go")
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "commit", "-q", "-am", "change main")
+
+	fake := startFakeFalkor(t)
+	cfg := Config{Path: root, Project: "App", Backend: "falkordb", FalkorAddr: fake.addr, FalkorGraph: "code", BatchSize: 100}
+	if err := runHook(context.Background(), cfg, "post-commit"); err != nil {
+		t.Fatal(err)
+	}
+	close(fake.commands)
+
+	var clear, clearPackages string
+	for args := range fake.commands {
+		switch {
+		case args[0] != "GRAPH.QUERY":
+		case strings.Contains(args[2], "f.path IN $paths") && strings.Contains(args[2], "DETACH DELETE"):
+			clear = args[2]
+		case strings.Contains(args[2], "p.path IN $packages"):
+			clearPackages = args[2]
+		}
+	}
+	if !strings.Contains(clear, "paths=['main.go', 'store/store.go']") {
+		t.Errorf("hook did not clear just the committed files:\n%s", clear)
+	}
+	// store/store.go was the only file of its package
+	if !strings.Contains(clearPackages, "packages=['store']") {
+		t.Errorf("hook did not clear the package left without files:\n%s", clearPackages)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, testTree)
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "new.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("notes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  string
+		want []string
+		err  bool
+	}{
+		{"HEAD", []string{"main.go", "new.go"}, false},
+		{"no-such-ref", nil, true},
+	}
+	for _, tt := range tests {
+		files, err := changedSince(ctx, root, tt.ref, codegraph.Filter{})
+		if (err != nil) != tt.err {
+			t.Errorf("changedSince(%s) error = %v, want error %v", tt.ref, err, tt.err)
+		}
+		if !slices.Equal(files, tt.want) {
+			t.Errorf("changedSince(%s) = %q, want %q", tt.ref, files, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".codegraph.yaml")
+	config := `project: Trading
+path: src
+db-path: /var/graph.db
+neo4j:
+  uri: bolt://graph:7687
+  password-env: GRAPH_PASSWORD
+blame: true
+batch-size: 500
+label-map:
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	options, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"project":            {"Trading"},
+		"path":               {filepath.Join(dir, "src")},
+		"db-path":            {"/var/graph.db"},
+		"neo4j-uri":          {"bolt://graph:7687"},
+		"neo4j-password-env": {"GRAPH_PASSWORD"},
+		"blame":              {"true"},
+		"batch-size":         {"500"},
+	}
+	if fmt.Sprint(options) != fmt.Sprint(want) {
+		t.Errorf("options = %v, want %v", options, want)
+	}
+
+	if err := os.WriteFile(path, []byte("project: [unclosed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigFile(path); err == nil {
+		t.Error("loaded malformed YAML")
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *string, *int, *bool) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		return fs, fs.String("project", "TradingEngine", ""), fs.Int("batch-size", 1000, ""), fs.Bool("blame", false, "")
+	}
+	dir := t.TempDir()
+	write := func(name, config string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	fs, project, batchSize, blame := newFlags()
+	fs.Parse([]string{"--project", "FromFlag"})
+	if err := applyConfigFile(fs, write("config.yaml", "project: FromFile\nbatch-size: 50\nblame: true\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	if *project != "FromFlag" || *batchSize != 50 || !*blame {
+		t.Errorf("project %q, batch size %d, blame %v; want the flag to override the file", *project, *batchSize, *blame)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		required bool
+		ok       bool
+	}{
+		{"missing default", filepath.Join(dir, "missing.yaml"), false, true},
+		{"missing --config", filepath.Join(dir, "missing.yaml"), true, false},
+		{"unknown option", write("unknown.yaml", "colour: blue\n"), true, false},
+		{"option of another command", write("other.yaml", "schedule: \"@daily\"\n"), true, true},
+		{"invalid value", write("invalid.yaml", "batch-size: lots\n"), true, false},
+	}
+	for _, tt := range tests {
+		fs, _, _, _ := newFlags()
+		if err := applyConfigFile(fs, tt.path, tt.required); (err == nil) != tt.ok {
+			t.Errorf("%s: error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestStringList(t *testing.T) {
+	var list stringList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&list, "exclude", "")
+	if err := fs.Parse([]string{"--exclude", "a", "--exclude", "b/**"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(list, stringList{"a", "b/**"}) || list.String() != "a,b/**" {
+		t.Errorf("repeated flag = %q", list)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for value, want := range map[string]int64{"0": 0, "4096": 4096, "512K": 512 << 10, "2M": 2 << 20, "2MiB": 2 << 20, "1gb": 1 << 30} {
+		var size byteSize
+		if err := size.Set(value); err != nil || int64(size) != want {
+			t.Errorf("%s = %d, %v, want %d", value, size, err, want)
+		}
+	}
+	for _, value := range []string{"", "M", "2T", "-1K", "1.5M"} {
+		var size byteSize
+		if err := size.Set(value); err == nil {
+			t.Errorf("%q parsed as %d", value, size)
+		}
+	}
+}
+
+func TestStartProfiling(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		CPUProfile: filepath.Join(dir, "cpu.out"),
+		MemProfile: filepath.Join(dir, "mem.out"),
+		ExecTrace:  filepath.Join(dir, "trace.out"),
+	}
+	stop, err := startProfiling(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profilePhase(context.Background(), "parse", func(ctx context.Context) {
+		if _, err := (codegraph.Parser{Root: ".", SyntaxOnly: true}).Parse(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	stop()
+	for _, file := range []string{cfg.CPUProfile, cfg.MemProfile, cfg.ExecTrace} {
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			t.Errorf("%s not written: %v", file, err)
+		}
+	}
+
+	// A file that cannot be created stops what was started before it
+	cfg.ExecTrace = filepath.Join(dir, "missing", "trace.out")
+	if _, err := startProfiling(cfg); err == nil {
+		t.Error("profiling into a missing directory")
+	}
+	stop, err = startProfiling(Config{CPUProfile: cfg.CPUProfile})
+	if err != nil {
+		t.Fatalf("CPU profile left running: %v", err)
+	}
+	stop()
+}
+
+func TestCommandFlags(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		check   func(cfg Config) bool
+	}{
+		{"index", []string{"--dry-run", "--project", "App"}, func(cfg Config) bool { return cfg.DryRun && cfg.Project == "App" }},
+		{"export", nil, func(cfg Config) bool { return cfg.ExportFormat == "json" && cfg.Out == "-" }},
+		{"export", []string{"--format", "dot", "--dot-view", "structure"}, func(cfg Config) bool {
+			return cfg.ExportFormat == "dot" && cfg.DotView == "structure"
+		}},
+		{"query", nil, func(cfg Config) bool { return cfg.Format == "table" && cfg.Depth == 3 }},
+		{"daemon", []string{"--schedule", "@daily", "--listen", ":0"}, func(cfg Config) bool {
+			return cfg.Schedule == "@daily" && cfg.Listen == ":0" && cfg.BatchSize == 500
+		}},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		fs := newFlagSet(lookupCommand(tt.command), &cfg)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Errorf("%s %q: %v", tt.command, tt.args, err)
+			continue
+		}
+		if !tt.check(cfg) {
+			t.Errorf("%s %q: config %+v", tt.command, tt.args, cfg)
+		}
+	}
+
+	for command, flags := range map[string][]string{
+		"index":  {"dry-run", "since", "rev", "batch-size", "project", "backend"},
+		"export": {"format", "out", "rev", "project"},
+		"query":  {"format", "name", "param", "backend"},
+		"stats":  {"top", "stale-after", "project"},
+		"mcp":    {"transport", "listen", "rev", "project"},
+	} {
+		fs := newFlagSet(lookupCommand(command), &Config{})
+		for _, name := range flags {
+			if fs.Lookup(name) == nil {
+				t.Errorf("%s has no --%s", command, name)
+			}
+		}
+	}
+	for command, name := range map[string]string{"query": "dry-run", "diff": "rev", "stats": "schedule", "export": "watch"} {
+		if newFlagSet(lookupCommand(command), &Config{}).Lookup(name) != nil {
+			t.Errorf("%s takes --%s", command, name)
+		}
+	}
+
+	if lookupCommand("colour") != nil {
+		t.Error("unknown command found")
+	}
+	if !isOption("schedule") || isOption("colour") {
+		t.Error("isOption does not cover exactly the flags of every command")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var buf bytes.Buffer
+	usage(&buf)
+	lines := strings.Split(buf.String(), "\n")
+	for i, cmd := range commands {
+		want := cmd.summary
+		if i == 0 {
+			want += " (default)"
+		}
+		if !slices.ContainsFunc(lines, func(line string) bool {
+			return strings.HasPrefix(line, "  "+cmd.name+" ") && strings.HasSuffix(line, " "+want)
+		}) {
+			t.Errorf("usage does not list %s as %q:\n%s", cmd.name, want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	commandUsage(&buf, lookupCommand("query"))
+	out := buf.String()
+	for _, want := range []string{"query [flags] [CYPHER | NAMED-QUERY]\n", "\nFlags:\n", "-param value", "named queries", "\nGlobal flags:\n", "-backend string"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("query usage does not contain %q:\n%s", want, out)
+		}
+	}
+	if own := out[:strings.Index(out, "Global flags:")]; strings.Contains(own, "-backend") {
+		t.Errorf("global flags listed as query's own:\n%s", own)
+	}
+
+	buf.Reset()
+	commandUsage(&buf, &command{name: "bare", summary: "Do nothing", flags: func(*flag.FlagSet, *Config) {}})
+	if strings.Contains(buf.String(), "\nFlags:") {
+		t.Errorf("command without flags lists a Flags section:\n%s", buf.String())
+	}
+}
+
+func TestForEachTarget(t *testing.T) {
+	targets := []target{{Project: "App", Path: "app"}, {Project: "Other", Path: "other"}}
+	var seen []string
+	err := forEachTarget(Config{Backend: "sqlite"}, targets, func(cfg Config) error {
+		seen = append(seen, cfg.Project+"="+cfg.Path+" "+cfg.Backend)
+		return nil
+	})
+	if err != nil || !slices.Equal(seen, []string{"App=app sqlite", "Other=other sqlite"}) {
+		t.Errorf("ran %q, error %v", seen, err)
+	}
+
+	fail := errors.New("boom")
+	err = forEachTarget(Config{}, targets, func(cfg Config) error { return fail })
+	if !errors.Is(err, fail) || err.Error() != "App: boom" {
+		t.Errorf("error across projects = %v, want App: boom", err)
+	}
+	err = forEachTarget(Config{}, targets[1:], func(cfg Config) error { return fail })
+	if err != fail {
+		t.Errorf("error for a single project = %v, want it unwrapped", err)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "graph.db")
+	backend, err := codegraph.OpenSQLite(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, project := range []string{"Web", "App"} {
+		if err := backend.Write(context.Background(), project, parseTestTree(t), codegraph.NewRunInfo()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend.Close(context.Background())
+	t.Chdir(dir)
+
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"st"}, []string{"stats"}},
+		{[]string{"help", "d"}, []string{"diff", "decision", "doctor", "daemon"}},
+		{[]string{"export", "--form"}, []string{"--format"}},
+		{[]string{"export", "--format", ""}, []string{"cypher", "csv", "parquet", "graphml", "dot", "json", "mermaid"}},
+		{[]string{"query", "--format", "j"}, []string{"json"}},
+		{[]string{"--backend", "f"}, []string{"falkordb"}},
+		{[]string{"index", "--dry-run", "--log-level", ""}, []string{"debug", "info", "warn", "error"}},
+		{[]string{"completion", ""}, []string{"bash", "zsh", "fish"}},
+		{[]string{"completion", "zsh", ""}, nil},
+		{[]string{"hook", "--blame", "p"}, []string{"pre-commit", "post-commit"}},
+		{[]string{"query", "--depth", "2", "call"}, []string{"call-depth", "callers"}},
+		{[]string{"colour", ""}, nil},
+		{[]string{"stats", "--backend", "sqlite", "--db-path", db, "--project", ""}, []string{"App", "Web"}},
+		{[]string{"--backend", "memory", "--project", ""}, nil},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		complete(&buf, tt.words)
+		got := strings.Fields(buf.String())
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("complete(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+
+	// The config file picks the backend --project is completed from
+	if err := os.WriteFile(filepath.Join(dir, defaultConfigFile), []byte("backend: sqlite\ndb-path: "+db+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	complete(&buf, []string{"diff", "--project", "W"})
+	if got := buf.String(); got != "Web\n" {
+		t.Errorf("--project from the config file's backend = %q, want Web", got)
+	}
+}
+
+func TestWriteCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var buf bytes.Buffer
+		if err := writeCompletion(&buf, shell); err != nil {
+			t.Fatal(err)
+		}
+		name := programName()
+		if !strings.Contains(buf.String(), name+" __complete ") || strings.Contains(buf.String(), "%!") {
+			t.Errorf("%s script does not call %s __complete:\n%s", shell, name, buf.String())
+		}
+	}
+	if err := writeCompletion(io.Discard, "tcsh"); err == nil {
+		t.Error("unknown shell accepted")
+	}
+}
+
+func TestTargets(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"services/billing/main.go":  "package main\n",
+		"services/auth-api/main.go": "package main\n",
+		"services/README.md":        "services\n",
+		"libs/log/log.go":           "package log\n",
+	})
+	services := filepath.Join(root, "services")
+	tests := []struct {
+		name string
+		cfg  Config
+		want []target
+		err  bool
+	}{
+		{"default", Config{Project: "App"}, []target{{Project: "App", Path: "."}}, false},
+		{"named paths", Config{Project: "App", Paths: []string{root, "Libs=" + filepath.Join(root, "libs")}}, []target{
+			{Project: "App", Path: root},
+			{Project: "Libs", Path: filepath.Join(root, "libs")},
+		}, false},
+		{"project map", Config{Project: "App", Paths: []string{root}, ProjectMap: []string{"services/*={name}Svc"}}, []target{
+			{Project: "App", Path: root, Filter: codegraph.Filter{Exclude: []string{"/services/auth-api", "/services/billing"}}},
+			{Project: "auth_apiSvc", Path: filepath.Join(services, "auth-api")},
+			{Project: "billingSvc", Path: filepath.Join(services, "billing")},
+		}, false},
+		{"invalid project", Config{Project: "App", Paths: []string{"my-app=" + root}}, nil, true},
+		{"duplicate project", Config{Project: "App", Paths: []string{root, root}}, nil, true},
+		{"bad pattern", Config{Project: "App", Paths: []string{root}, ProjectMap: []string{"services/["}}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.cfg.targets()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: targets = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProjectLabel(t *testing.T) {
+	for name, want := range map[string]string{
+		"billing":     "billing",
+		"auth-api":    "auth_api",
+		"2fa":         "_2fa",
+		"svc.v2 beta": "svc_v2_beta",
+	} {
+		if got := projectLabel(name); got != want {
+			t.Errorf("projectLabel(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigFilePaths(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".codegraph.yaml")
+	if err := os.WriteFile(path, []byte("path:\n  - .\n  - Libs=libs\n  - /abs\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	options, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{dir, "Libs=" + filepath.Join(dir, "libs"), "/abs"}; !slices.Equal(options["path"], want) {
+		t.Errorf("paths = %q, want %q", options["path"], want)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		level, format string
+		want          string
+		err           bool
+	}{
+		{"info", "text", `level=INFO msg=kept n=1`, false},
+		{"debug", "json", `"level":"DEBUG","msg":"debug"`, false},
+		{"warn", "text", `level=WARN msg=warned`, false},
+		{"loud", "text", "", true},
+		{"info", "xml", "", true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger, err := newLogger(&buf, tt.level, tt.format)
+		if (err != nil) != tt.err {
+			t.Errorf("newLogger(%s, %s) error = %v, want error %v", tt.level, tt.format, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		logger.Debug("debug")
+		logger.Info("kept", "n", 1)
+		logger.Warn("warned")
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("newLogger(%s, %s) wrote %q, want %q", tt.level, tt.format, buf.String(), tt.want)
+		}
+		if tt.level == "warn" && strings.Contains(buf.String(), "kept") {
+			t.Errorf("warn logger wrote info: %q", buf.String())
+		}
+	}
+}
+
+func TestLogMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	logMetrics(log, codegraph.RunMetrics{
+		ParseTime:  time.Second,
+		WriteTime:  2 * time.Second,
+		Files:      10,
+		Nodes:      100,
+		WriteStats: codegraph.WriteStats{Statements: 4, Batches: 3, BatchRows: 10, MaxBatch: 5, Shrinks: 1},
+	})
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"msg":         "metrics",
+		"filesPerSec": 10.0,
+		"nodesPerSec": 50.0,
+		"statements":  4.0,
+		"avgBatch":    3.3,
+		"maxBatch":    5.0,
+		"shrinks":     1.0,
+	} {
+		if record[key] != want {
+			t.Errorf("%s = %v, want %v", key, record[key], want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("boom"), exitFailure},
+		{withExit(exitParse, errors.New("syntax")), exitParse},
+		{fmt.Errorf("App: %w", withExit(exitValidation, errors.New("orphans"))), exitValidation},
+		{fmt.Errorf("writing: %w", &net.OpError{Op: "dial", Err: errors.New("refused")}), exitConnection},
+		{&neo4j.ConnectivityError{}, exitConnection},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+	if withExit(exitUsage, nil) != nil {
+		t.Error("withExit marked a nil error")
+	}
+}
+
+func TestPopulateExitCodes(t *testing.T) {
+	root := writeTree(t, testTree)
+	db := filepath.Join(t.TempDir(), "graph.db")
+	tests := []struct {
+		name string
+		cfg  Config
+		want int
+	}{
+		{"unknown backend", Config{Path: root, Project: "App", Backend: "colour"}, exitUsage},
+		{"unparsable path", Config{Path: filepath.Join(root, "missing"), Project: "App", Backend: "sqlite", DBPath: db}, exitParse},
+		{"unreachable backend", Config{Path: root, Project: "App", Backend: "falkordb", FalkorAddr: "127.0.0.1:1"}, exitConnection},
+		{"query without memory", Config{Path: root, Project: "App", Backend: "sqlite", DBPath: db, Query: "callers"}, exitUsage},
+		{"written", Config{Path: root, Project: "App", Backend: "sqlite", DBPath: db, Validate: true}, 0},
+	}
+	defer func(s *runSummary) { summary = s }(summary)
+	for _, tt := range tests {
+		summary = &runSummary{}
+		err := populate(context.Background(), tt.cfg)
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("%s: exit code %d (%v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+	if len(summary.Projects) != 1 || summary.Projects[0].Functions != 5 || summary.Projects[0].Run == "" {
+		t.Errorf("summary projects = %+v, want the written App", summary.Projects)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	s := &runSummary{Command: "index", Projects: []projectSummary{}, Errors: []string{}, Warnings: []string{}, start: time.Now()}
+	s.addProject(graphSummary("App", parseTestTree(t)))
+	for i := range maxSummaryMessages + 1 {
+		s.addMessage(slog.LevelError, fmt.Sprint("error ", i))
+	}
+	s.addMessage(slog.LevelWarn, "stale")
+	s.addMessage(slog.LevelInfo, "parsed")
+
+	var buf bytes.Buffer
+	if err := s.write(&buf, exitValidation); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("summary spans %d lines, want 1", n)
+	}
+	var got struct {
+		Command  string
+		Status   string
+		ExitCode int
+		Projects []projectSummary
+		Errors   []string
+		Warnings []string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := projectSummary{Project: "App", Files: 2, Packages: 2, Functions: 5, Structs: 1, Interfaces: 1, Nodes: 11, Relationships: 15}
+	if got.Command != "index" || got.Status != "failed" || got.ExitCode != exitValidation {
+		t.Errorf("summary = %s", buf.String())
+	}
+	if len(got.Projects) != 1 || !reflect.DeepEqual(got.Projects[0], want) {
+		t.Errorf("projects = %+v, want %+v", got.Projects, want)
+	}
+	if len(got.Errors) != maxSummaryMessages || !slices.Equal(got.Warnings, []string{"stale"}) {
+		t.Errorf("%d errors and warnings %q, want %d and stale", len(got.Errors), got.Warnings, maxSummaryMessages)
+	}
+
+	stats := ProjectStats{
+		Project:       "App",
+		Nodes:         map[string]int{"File": 2, "Function": 3, "Method": 2},
+		Relationships: map[string]int{"CALLS": 4, "CONTAINS": 5},
+	}
+	if got := statsSummary(stats); got.Files != 2 || got.Functions != 5 || got.Nodes != 7 || got.Relationships != 9 {
+		t.Errorf("statsSummary = %+v", got)
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	defer func(s *runSummary) { summary = s }(summary)
+	summary = &runSummary{}
+	root := writeTree(t, map[string]string{"main.go": "package main\n\nfunc main() {}\n", "broken.go": "package main\n\nfunc ("})
+	graph, err := parseTree(context.Background(), codegraph.Parser{Root: root, SyntaxOnly: true}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Files) != 1 || summary.parseFailures() != 1 {
+		t.Errorf("parsed %d files and counted %d failures, want 1 and 1", len(graph.Files), summary.parseFailures())
+	}
+	if failed := graphSummary("App", graph).ParseFailures; len(failed) != 1 || failed[0].File != "broken.go" {
+		t.Errorf("summary failures = %+v, want broken.go", failed)
+	}
+}
+
+func TestSummaryHandler(t *testing.T) {
+	defer func(s *runSummary) { summary = s }(summary)
+	summary = &runSummary{}
+	log := slog.New(summaryHandler{Handler: slog.NewTextHandler(io.Discard, nil)})
+	log.With("project", "App").Warn("parse failed", "file", "a.go")
+	log.Info("parsed", "files", 2)
+	log.Error("creating graph", "err", errors.New("boom"))
+
+	if want := []string{"parse failed project=App file=a.go"}; !slices.Equal(summary.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", summary.Warnings, want)
+	}
+	if want := []string{"creating graph err=boom"}; !slices.Equal(summary.Errors, want) {
+		t.Errorf("errors = %q, want %q", summary.Errors, want)
+	}
+}
+
+func TestTracePopulate(t *testing.T) {
+	// Tracers delegate to the first provider installed, so this is the only
+	// test that installs one
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, root := tracer.Start(context.Background(), "index")
+	cfg := Config{Path: writeTree(t, testTree), Project: "App", Backend: "sqlite", DBPath: filepath.Join(t.TempDir(), "graph.db")}
+	if err := populate(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Backend = "colour"
+	populate(ctx, cfg)
+	endSpan(root, errors.New("colour"))
+
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	if n := len(byName["parse"]); n != 2 {
+		t.Fatalf("%d parse spans, want 2", n)
+	}
+	if writes := byName["write"]; len(writes) != 1 || writes[0].Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("write spans = %v, want one below the command", writes)
+	}
+	parses := make(map[trace.SpanID]bool)
+	for _, span := range byName["parse"] {
+		parses[span.SpanContext().SpanID()] = true
+	}
+	for _, span := range byName["parse file"] {
+		if !parses[span.Parent().SpanID()] {
+			t.Errorf("parse file span %v is not below the parse phase", span.Attributes())
+		}
+	}
+	if n := len(byName["parse file"]); n != 4 {
+		t.Errorf("%d parse file spans, want 4", n)
+	}
+	if status := byName["index"][0].Status(); status.Code != otelcodes.Error || status.Description != "colour" {
+		t.Errorf("failed command status = %+v", status)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	var buf bytes.Buffer
+	s := &statusLine{w: &buf}
+	io.WriteString(s, "first\n")
+	s.setStatus("[==  ]")
+	io.WriteString(s, "second\n")
+	s.setStatus("")
+	if want := "first\n\r\033[K[==  ]\r\033[Ksecond\n[==  ]\r\033[K"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer, mode string) { stderr.w, progressMode = w, mode }(stderr.w, progressMode)
+	stderr.w = &buf
+
+	progressMode = ""
+	p := startProgress("parsing", "files", 10)
+	p.Add(10)
+	p.Finish()
+	if buf.Len() != 0 {
+		t.Errorf("progress off wrote %q", buf.String())
+	}
+
+	progressMode = "bar"
+	p = startProgress("parsing", "files", 4)
+	p.Add(2)
+	time.Sleep(250 * time.Millisecond)
+	p.Finish()
+	out := buf.String()
+	if !strings.Contains(out, "parsing  [===============               ] 2/4 files") {
+		t.Errorf("bar = %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\033[K") {
+		t.Errorf("bar not cleared: %q", out)
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	var buf bytes.Buffer
+	printSummary(&buf, codegraph.RunMetrics{
+		ParseTime:  time.Second,
+		WriteTime:  time.Second,
+		Files:      10,
+		Nodes:      20,
+		WriteStats: codegraph.WriteStats{Statements: 3, Batches: 2, MaxBatch: 5},
+	})
+	for _, want := range []string{"files parsed", "10", "nodes written", "statements", "batches (max 5 rows)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "halved") {
+		t.Errorf("summary reports shrinks that did not happen:\n%s", buf.String())
+	}
+}
+
+func TestStaleness(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{"main.go": "package main\n", "README.md": "app\n"})
+	first := codegraph.ResolveCommit(ctx, root, "")
+	os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644)
+	git(t, root, "commit", "-q", "-am", "second")
+	head := codegraph.ResolveCommit(ctx, root, "")
+
+	cfg := Config{Path: root, StaleAfter: 24 * time.Hour}
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name  string
+		stats ProjectStats
+		want  []string
+	}{
+		{"never indexed", ProjectStats{}, []string{"project has not been indexed"}},
+		{"up to date", ProjectStats{LastIndexed: future, Commit: head}, []string{}},
+		{"no commit", ProjectStats{LastIndexed: future}, []string{"indexed commit was not recorded"}},
+		{"commits since", ProjectStats{LastIndexed: future, Commit: first},
+			[]string{fmt.Sprintf("1 commits since indexed commit %.12s", first)}},
+		{"unknown commit", ProjectStats{LastIndexed: future, Commit: strings.Repeat("0", 40)},
+			[]string{"indexed commit 000000000000 is not an ancestor of HEAD"}},
+		{"modified files", ProjectStats{LastIndexed: time.Now().Add(-time.Hour), Commit: head},
+			[]string{"1 source files modified since last indexed"}},
+		{"old", ProjectStats{LastIndexed: time.Now().Add(-48 * time.Hour), Commit: head},
+			[]string{"last indexed 48h0m0s ago", "1 source files modified since last indexed"}},
+	}
+	for _, tt := range tests {
+		if got := staleness(ctx, cfg, tt.stats); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: staleness = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	var buf bytes.Buffer
+	printStats(&buf, ProjectStats{
+		Project:       "App",
+		Nodes:         map[string]int{"Function": 3, "File": 2},
+		Relationships: map[string]int{"CALLS": 4},
+		Deleted:       1,
+		LastIndexed:   time.Now().Add(-time.Hour),
+		RunID:         "run-1",
+		Commit:        "abc123",
+		Packages:      []PackageStats{{Path: "store", Files: 1, Symbols: 4}},
+		Warnings:      []string{"1 commits since indexed commit abc123"},
+	})
+	out := buf.String()
+	for _, want := range []string{
+		"project       App\n",
+		"run           run-1\n",
+		"commit        abc123\n",
+		"  File         2\n  Function     3\n  deleted      1\n",
+		"  CALLS        4\n",
+		"  files  symbols  largest packages\n      1        4  store\n",
+		"warning: 1 commits since indexed commit abc123\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	repo, plain := t.TempDir(), t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Skipf("git init: %v: %s", err, out)
+	}
+	checks := checkTargets(context.Background(), []target{
+		{Project: "Repo", Path: repo},
+		{Project: "Plain", Path: plain},
+		{Project: "Gone", Path: filepath.Join(plain, "missing")},
+	})
+	var got []string
+	for _, c := range checks {
+		got = append(got, c.Status)
+	}
+	if want := []string{"ok", "warn", "fail"}; !slices.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v: %+v", got, want, checks)
+	}
+}
+
+func TestPrintChecks(t *testing.T) {
+	var buf bytes.Buffer
+	printChecks(&buf, []doctorCheck{
+		{"connectivity", "ok", "bolt://localhost:7687 is reachable", "unused"},
+		{"indexes", "warn", "no index on the key of Function", "large writes will be slow; run\nCREATE INDEX ..."},
+	})
+	want := "OK    connectivity    bolt://localhost:7687 is reachable\n" +
+		"WARN  indexes         no index on the key of Function\n" +
+		"                      large writes will be slow; run\n" +
+		"                      CREATE INDEX ...\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printChecks =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		512 << 10: "512 KiB",
+		300 << 20: "300 MiB",
+		3 << 29:   "1.5 GiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestGenerateBenchRepo(t *testing.T) {
+	dir := t.TempDir()
+	if err := generateBenchRepo(dir, 3, 2, 4); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := codegraph.Parser{Root: dir}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := graphSummary(benchProject, graph)
+	if got.Packages != 3 || got.Files != 6 || got.Functions != 30 || got.Structs != 6 || got.Interfaces != 6 {
+		t.Errorf("generated %+v, want 3 packages, 6 files, 30 functions, 6 structs and 6 interfaces", got)
+	}
+	crossPackage := false
+	for _, rel := range graph.Calls() {
+		if strings.HasPrefix(rel.From, "Function:pkg/p001/") && strings.HasPrefix(rel.To, "Function:pkg/p000/") {
+			crossPackage = true
+		}
+	}
+	if !crossPackage {
+		t.Errorf("no call from p001 into p000: %v", graph.Calls())
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	times := []time.Duration{5, 1, 3, 2, 4}
+	for p, want := range map[float64]time.Duration{0: 1, 50: 3, 90: 5, 99: 5, 100: 5} {
+		if got := percentile(times, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := Config{Backend: "memory", BenchPackages: 2, BenchFiles: 2, BenchFunctions: 3, BenchRuns: 3, BenchDir: t.TempDir()}
+	if err := runBench(context.Background(), cfg, &buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"2 packages, 4 files, 16 functions in " + cfg.BenchDir + "\n",
+		"3 runs on the memory backend",
+		"phase   p50",
+		"\nparse   ", "files/s", "\nwrite   ", "nodes/s", "\ntotal   ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("bench output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	cfg.BenchRuns = 0
+	if err := runBench(context.Background(), cfg, &buf); exitCode(err) != exitUsage {
+		t.Errorf("--runs 0: err = %v, want a usage error", err)
+	}
+}
+
+// fakeManager is a codegraph.Manager holding one project's snapshots
+type fakeManager struct {
+	snapshots []codegraph.Snapshot
+	deleted   []string
+	before    time.Time
+}
+
+func (m *fakeManager) DeleteProject(ctx context.Context, project string) (int, error) {
+	m.deleted = append(m.deleted, project)
+	return 12, nil
+}
+
+func (m *fakeManager) Snapshots(ctx context.Context, project string) ([]codegraph.Snapshot, error) {
+	return m.snapshots, nil
+}
+
+func (m *fakeManager) Prune(ctx context.Context, project string, before time.Time) (int, error) {
+	m.before = before
+	return 3, nil
+}
+
+func TestRunWipe(t *testing.T) {
+	m := &fakeManager{snapshots: []codegraph.Snapshot{{RunID: "run-2", Nodes: 10}, {RunID: "run-1", Deleted: 2}}}
+	cfg := Config{Project: "App"}
+	err := runWipe(context.Background(), cfg, m)
+	if exitCode(err) != exitUsage || !strings.Contains(err.Error(), "the 12 nodes of project App") {
+		t.Errorf("without --yes: err = %v, want a usage error counting 12 nodes", err)
+	}
+	if len(m.deleted) > 0 {
+		t.Errorf("deleted %q without --yes", m.deleted)
+	}
+
+	cfg.Yes = true
+	if err := runWipe(context.Background(), cfg, m); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(m.deleted, []string{"App"}) {
+		t.Errorf("deleted %q, want App", m.deleted)
+	}
+}
+
+func TestRunPrune(t *testing.T) {
+	m := &fakeManager{}
+	if err := runPrune(context.Background(), Config{Project: "App", PruneDays: 30}, m); err != nil {
+		t.Fatal(err)
+	}
+	if age := time.Since(m.before); age < 30*24*time.Hour-time.Hour || age > 30*24*time.Hour+2*time.Hour {
+		t.Errorf("pruned before %v, want 30 days ago", m.before)
+	}
+	if err := runPrune(context.Background(), Config{Project: "App", PruneDays: -1}, m); exitCode(err) != exitUsage {
+		t.Errorf("--days -1: err = %v, want a usage error", err)
+	}
+}
+
+func TestRunRemember(t *testing.T) {
+	m := &fakeMemories{}
+	cfg := Config{Project: "App", About: []string{"File:main.go"}, Tags: []string{"cli"}, Importance: 0.9, Confidence: 0.7, Pin: true,
+		Namespace: "user:alice, team:storage", Agent: "release-bot", Model: "claude-haiku"}
+	t.Setenv("USER", "alice")
+	if err := runRemember(context.Background(), cfg, m, "main exits 2 on bad flags"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.memories) != 1 || m.memories[0].Text != "main exits 2 on bad flags" || !slices.Equal(m.memories[0].Tags, []string{"cli"}) ||
+		m.memories[0].Importance != 0.9 || m.memories[0].Confidence != 0.7 || !m.memories[0].Pinned || m.memories[0].Namespace != "user:alice" ||
+		m.memories[0].Provenance != (codegraph.Provenance{Via: codegraph.ViaCLI, Agent: "release-bot", Model: "claude-haiku", User: "alice"}) {
+		t.Errorf("remembered %+v", m.memories)
+	}
+	if err := runRemember(context.Background(), cfg, m, ""); exitCode(err) != exitUsage {
+		t.Errorf("no text: err = %v, want a usage error", err)
+	}
+	cfg.TTL = 48 * time.Hour
+	if err := runRemember(context.Background(), cfg, m, "release branch frozen"); err != nil {
+		t.Fatal(err)
+	}
+	if expires := m.memories[1].ExpiresAt; time.Until(expires) < 47*time.Hour || time.Until(expires) > 48*time.Hour {
+		t.Errorf("--ttl 48h: expires at %v", expires)
+	}
+	cfg.TTL = -time.Hour
+	if err := runRemember(context.Background(), cfg, m, "x"); exitCode(err) != exitUsage {
+		t.Errorf("negative --ttl: err = %v, want a usage error", err)
+	}
+	cfg.TTL = 0
+	cfg.About = []string{"File:gone.go"}
+	if err := runRemember(context.Background(), cfg, m, "gone"); !errors.Is(err, codegraph.ErrNoNode) || exitCode(err) != exitFailure {
+		t.Errorf("unknown key: err = %v, want ErrNoNode", err)
+	}
+}
+
+func TestRunRecall(t *testing.T) {
+	m := &fakeMemories{memories: []codegraph.Memory{{ID: "mem-1", Text: "x", About: []string{"File:main.go"}}}}
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", Tags: []string{"cli", "flags"}, ExcludeTags: []string{"stale"}, Namespace: "user:alice,team:storage", Limit: 3}
+	if err := runRecall(context.Background(), cfg, m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := []codegraph.MemoryQuery{{Tags: []string{"cli", "flags"}, ExcludeTags: []string{"stale"}, Namespaces: []string{"user:alice", "team:storage"}, Limit: 3}}
+	if !reflect.DeepEqual(m.queries, want) {
+		t.Errorf("recalled %+v, want %+v", m.queries, want)
+	}
+	if !strings.Contains(buf.String(), "mem-1") {
+		t.Errorf("recall printed %q", buf.String())
+	}
+	err := runRecall(context.Background(), Config{Project: "App", About: []string{"File:a.go", "File:b.go"}}, m, &buf)
+	if exitCode(err) != exitUsage {
+		t.Errorf("two --about: err = %v, want a usage error", err)
+	}
+	if err := runRecall(context.Background(), Config{Project: "App", Rank: true}, m, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if q := m.queries[len(m.queries)-1]; q.Ranking == nil || !reflect.DeepEqual(*q.Ranking, codegraph.DefaultRanking) {
+		t.Errorf("--rank recalled with %+v, want the default ranking", q)
+	}
+
+	cfg = Config{Project: "App", Provenance: []string{"via=hook"}, Trust: []string{"model=claude-haiku:0.5"}}
+	if err := runRecall(context.Background(), cfg, m, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	q := m.queries[len(m.queries)-1]
+	if q.Provenance != (codegraph.Provenance{Via: codegraph.ViaHook}) || q.Ranking == nil || q.Ranking.Trust["model=claude-haiku"] != 0.5 {
+		t.Errorf("--provenance and --trust recalled with %+v", q)
+	}
+	for _, cfg := range []Config{{Provenance: []string{"host=db1"}}, {Trust: []string{"via=hook"}}} {
+		if err := runRecall(context.Background(), cfg, m, io.Discard); exitCode(err) != exitUsage {
+			t.Errorf("%+v: err = %v, want a usage error", cfg, err)
+		}
+	}
+}
+
+func TestRunForget(t *testing.T) {
+	m := &fakeMemories{memories: []codegraph.Memory{
+		{ID: "mem-1", Text: "x", Provenance: codegraph.Provenance{Via: codegraph.ViaHook, Agent: "old-bot"}},
+		{ID: "mem-2", Text: "y", Provenance: codegraph.Provenance{Via: codegraph.ViaHook, Agent: "old-bot"}},
+		{ID: "mem-3", Text: "z"},
+	}}
+	if err := runForget(context.Background(), Config{Project: "App"}, m, "mem-3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runForget(context.Background(), Config{Project: "App"}, m, "mem-3"); err == nil || exitCode(err) != exitFailure {
+		t.Errorf("forgetting mem-3 twice: err = %v", err)
+	}
+
+	cfg := Config{Project: "App", Provenance: []string{"agent=old-bot"}, DryRun: true}
+	if err := runForget(context.Background(), cfg, m, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.memories) != 2 {
+		t.Errorf("--dry-run forgot memories, left %+v", m.memories)
+	}
+	cfg.DryRun = false
+	if err := runForget(context.Background(), cfg, m, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := codegraph.MemoryQuery{All: true, Peek: true, Provenance: codegraph.Provenance{Agent: "old-bot"}}
+	if len(m.memories) != 0 || !reflect.DeepEqual(m.queries[len(m.queries)-1], want) {
+		t.Errorf("left %+v after recalling with %+v", m.memories, m.queries)
+	}
+
+	for _, tt := range []struct {
+		cfg Config
+		id  string
+	}{{Config{}, ""}, {cfg, "mem-1"}, {Config{Provenance: []string{"via"}}, ""}} {
+		if err := runForget(context.Background(), tt.cfg, m, tt.id); exitCode(err) != exitUsage {
+			t.Errorf("%+v %q: err = %v, want a usage error", tt.cfg, tt.id, err)
+		}
+	}
+}
+
+func TestRedactRules(t *testing.T) {
+	rules, err := redactRules([]string{`host=[\w-]+\.corp\.example\.com`}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != len(codegraph.DefaultRedactRules)+1 || rules[len(rules)-1].Name != "host" {
+		t.Errorf("rules = %v", rules)
+	}
+	if rules, err := redactRules(nil, false); err != nil || len(rules) != 0 {
+		t.Errorf("no rules: %v, %v", rules, err)
+	}
+	if _, err := redactRules([]string{"host=("}, true); err == nil {
+		t.Error("accepted an invalid rule")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	tests := []struct {
+		value  string
+		home   string
+		recall []string
+	}{
+		{"", "", []string{}},
+		{"user:alice", "user:alice", []string{"user:alice"}},
+		{" user:alice , team:storage,", "user:alice", []string{"user:alice", "team:storage"}},
+		{"user:alice,*", "user:alice", nil},
+	}
+	for _, tt := range tests {
+		if got := homeNamespace(tt.value); got != tt.home {
+			t.Errorf("homeNamespace(%q) = %q, want %q", tt.value, got, tt.home)
+		}
+		if got := recallNamespaces(tt.value); !reflect.DeepEqual(got, tt.recall) {
+			t.Errorf("recallNamespaces(%q) = %#v, want %#v", tt.value, got, tt.recall)
+		}
+	}
+}
+
+func TestResolveGitState(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{"main.go": "package main\n"})
+	git(t, root, "checkout", "-q", "-b", "feature/notes")
+	head := codegraph.ResolveCommit(ctx, root, "")
+	lookups := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		if r.URL.Path == "/repos/acme/app/pulls" && r.URL.Query().Get("head") == "acme:feature/notes" {
+			w.Write([]byte(`[{"number": 7}]`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	t.Setenv("GITHUB_API_URL", srv.URL)
+	t.Setenv("GITHUB_REPOSITORY", "acme/app")
+
+	t.Setenv("GITHUB_TOKEN", "")
+	if state := resolveGitState(ctx, Config{Path: root}); state != (codegraph.GitState{Commit: head, Branch: "feature/notes"}) || lookups != 0 {
+		t.Errorf("without a token: %+v after %d lookups", state, lookups)
+	}
+	t.Setenv("GITHUB_TOKEN", "secret")
+	if state := resolveGitState(ctx, Config{Path: root}); state.PR != 7 || state.Commit != head {
+		t.Errorf("with a token: %+v", state)
+	}
+	if state := resolveGitState(ctx, Config{Path: root, PR: 12}); state.PR != 12 || lookups != 1 {
+		t.Errorf("with --pr 12: %+v after %d lookups", state, lookups)
+	}
+	// A failed lookup leaves the pull request out
+	t.Setenv("GITHUB_REPOSITORY", "acme/other")
+	if state := resolveGitState(ctx, Config{Path: root}); state.PR != 0 || state.Commit != head {
+		t.Errorf("failed lookup: %+v", state)
+	}
+}
+
+func TestRunPin(t *testing.T) {
+	m := &fakeMemories{memories: []codegraph.Memory{{ID: "mem-1", Text: "x", About: []string{"File:main.go"}}}}
+	if err := runPin(context.Background(), Config{Project: "App"}, m, "mem-1"); err != nil || !m.memories[0].Pinned {
+		t.Errorf("pin: %v, memory %+v", err, m.memories[0])
+	}
+	if err := runPin(context.Background(), Config{Project: "App", Unpin: true}, m, "mem-1"); err != nil || m.memories[0].Pinned {
+		t.Errorf("pin --unpin: %v, memory %+v", err, m.memories[0])
+	}
+	if err := runPin(context.Background(), Config{Project: "App"}, m, "mem-2"); err == nil || exitCode(err) != exitFailure {
+		t.Errorf("unknown memory: err = %v, want a failure", err)
+	}
+	if err := runPin(context.Background(), Config{Project: "App"}, m, ""); exitCode(err) != exitUsage {
+		t.Errorf("no ID: err = %v, want a usage error", err)
+	}
+}
+
+// fakeImporter is a codegraph.KnowledgeImporter keeping what it imports,
+// skipping IDs it already has
+type fakeImporter struct {
+	memories  []codegraph.Memory
+	decisions []codegraph.Decision
+}
+
+func (f *fakeImporter) ImportMemories(ctx context.Context, project string, memories []codegraph.Memory) (int, error) {
+	n := 0
+	for _, memory := range memories {
+		if memory.ID == "" {
+			return n, fmt.Errorf("%w: no ID", codegraph.ErrInvalidMemory)
+		}
+		if !slices.ContainsFunc(f.memories, func(m codegraph.Memory) bool { return m.ID == memory.ID }) {
+			f.memories = append(f.memories, memory)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeImporter) ImportDecisions(ctx context.Context, project string, decisions []codegraph.Decision) (int, error) {
+	f.decisions = append(f.decisions, decisions...)
+	return len(decisions), nil
+}
+
+func TestRunKnowledge(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	m := &fakeMemories{memories: []codegraph.Memory{
+		{ID: "mem-2", Text: "main exits 2 on bad flags", About: []string{"File:main.go"}, CreatedAt: created},
+		{ID: "mem-1", Text: "old", About: []string{"File:main.go"}, CreatedAt: created, Archived: true},
+	}}
+	out := filepath.Join(t.TempDir(), "knowledge.json")
+	if err := runKnowledge(context.Background(), Config{Project: "App", Out: out}, m, []string{"export"}, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if q := m.queries[0]; !q.All || !q.Peek {
+		t.Errorf("exported memories recalled with %+v", q)
+	}
+
+	imp := &fakeImporter{memories: []codegraph.Memory{{ID: "mem-1"}}}
+	if err := runKnowledge(context.Background(), Config{Project: "Other"}, imp, []string{"import", out}, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.memories) != 2 || !reflect.DeepEqual(imp.memories[1], m.memories[0]) || len(imp.decisions) != 0 {
+		t.Errorf("imported %+v and %+v", imp.memories, imp.decisions)
+	}
+
+	// Export to stdout, import from stdin
+	var buf bytes.Buffer
+	if err := runKnowledge(context.Background(), Config{Project: "App", Out: "-"}, m, []string{"export"}, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	imp = &fakeImporter{}
+	if err := runKnowledge(context.Background(), Config{Project: "Other"}, imp, []string{"import"}, &buf, io.Discard); err != nil || len(imp.memories) != 2 {
+		t.Errorf("import from stdin: %v, imported %+v", err, imp.memories)
+	}
+
+	for _, tt := range []struct {
+		backend any
+		args    []string
+		input   string
+	}{
+		{m, nil, ""},
+		{m, []string{"export", "file.json"}, ""},
+		{struct{}{}, []string{"export"}, ""},
+		{m, []string{"import"}, ""},
+		{imp, []string{"import"}, "{not json"},
+		{imp, []string{"import", filepath.Join(t.TempDir(), "missing.json")}, ""},
+		{imp, []string{"import"}, `{"version": 1, "memories": [{"text": "no ID", "about": ["File:main.go"]}]}`},
+	} {
+		err := runKnowledge(context.Background(), Config{Project: "App", Backend: "memory"}, tt.backend, tt.args, strings.NewReader(tt.input), io.Discard)
+		if exitCode(err) != exitUsage {
+			t.Errorf("%q with %T: err = %v, want a usage error", tt.args, tt.backend, err)
+		}
+	}
+}
+
+func TestRunCapture(t *testing.T) {
+	root := writeTree(t, map[string]string{"main.go": "package main\n\nfunc main() {\n\trun()\n}\n", "README.md": "# App\n"})
+	t.Setenv("CLAUDE_PROJECT_DIR", root)
+	m := &fakeMemories{}
+	sessions := &fakeSessions{sessions: []codegraph.Session{{ID: "ses-1"}}}
+	store := struct {
+		*fakeMemories
+		*fakeSessions
+	}{m, sessions}
+	cfg := Config{Project: "App", Session: "ses-1", TTL: time.Hour, Agent: "claude-code"}
+	capture := func(event string) error {
+		return runCapture(context.Background(), cfg, store, strings.NewReader(event))
+	}
+
+	// main is not in the fake's graph, so only the file is kept
+	edit := fmt.Sprintf(`{"hook_event_name": "PostToolUse", "tool_name": "Edit", "cwd": %q, "tool_input": {"file_path": "main.go", "new_string": "\trun()"}}`, root)
+	if err := capture(edit); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.memories) != 1 {
+		t.Fatalf("memories = %+v, want one", m.memories)
+	}
+	got := m.memories[0]
+	if got.Text != "edit main.go" || !slices.Equal(got.Tags, []string{"activity", "edit"}) || !slices.Equal(got.About, []string{"File:main.go"}) ||
+		got.Session != "ses-1" || got.Importance != codegraph.ActivityImportance || time.Until(got.ExpiresAt) > time.Hour ||
+		got.Via != codegraph.ViaHook || got.Agent != "claude-code" {
+		t.Errorf("captured %+v", got)
+	}
+	if !slices.Equal(sessions.sessions[0].Touched, []string{"main.go"}) {
+		t.Errorf("session touched %q", sessions.sessions[0].Touched)
+	}
+
+	for _, skipped := range []string{
+		`{"tool_name": "Read", "tool_input": {"file_path": "README.md"}}`,
+		`{"tool_name": "Read", "tool_input": {"file_path": "/etc/hosts"}}`,
+		`{"tool_name": "Bash", "tool_input": {"command": "ls"}}`,
+	} {
+		if err := capture(skipped); err != nil {
+			t.Errorf("%s: %v", skipped, err)
+		}
+	}
+	if len(m.memories) != 1 {
+		t.Errorf("captured %+v, want only the edit", m.memories[1:])
+	}
+	if err := capture(`{"tool_name": `); exitCode(err) != exitUsage {
+		t.Errorf("invalid JSON: err = %v, want a usage error", err)
+	}
+
+	// Without --session, the hook's session_id starts a session once
+	cfg.Session = ""
+	hooked := fmt.Sprintf(`{"session_id": "4f1c", "tool_name": "Edit", "cwd": %q, "tool_input": {"file_path": "main.go"}}`, root)
+	for range 2 {
+		if err := capture(hooked); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sessions.sessions) != 2 || sessions.sessions[1].AgentSession != "4f1c" || sessions.sessions[1].Dir != root {
+		t.Fatalf("sessions = %+v, want one started for 4f1c", sessions.sessions)
+	}
+	if got := m.memories[len(m.memories)-1]; got.Session != sessions.sessions[1].ID {
+		t.Errorf("captured %+v in session %s", got, sessions.sessions[1].ID)
+	}
+	if !slices.Equal(sessions.sessions[1].Touched, []string{"main.go", "main.go"}) {
+		t.Errorf("session touched %q", sessions.sessions[1].Touched)
+	}
+}
+
+func TestRunGC(t *testing.T) {
+	now := time.Now()
+	m := &fakeMemories{memories: []codegraph.Memory{
+		{ID: "mem-1", Text: "stale\nsecond line", CreatedAt: now.AddDate(-1, 0, 0)},
+		{ID: "mem-2", Text: "fresh", CreatedAt: now},
+		{ID: "mem-3", Text: "expired", CreatedAt: now, ExpiresAt: now.Add(-time.Hour)},
+	}}
+	cfg := Config{Project: "App", HalfLife: 30 * 24 * time.Hour, Threshold: 0.1, DryRun: true}
+	var buf bytes.Buffer
+	if err := runGC(context.Background(), cfg, m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 ||
+		lines[0] != "mem-3  0.000  expired" || !strings.HasPrefix(lines[1], "mem-1  0.000  stale") {
+		t.Errorf("gc listed\n%s", buf.String())
+	}
+	if m.memories[0].Archived || len(m.memories) != 3 {
+		t.Errorf("dry run changed %+v", m.memories)
+	}
+
+	cfg.DryRun = false
+	if err := runGC(context.Background(), cfg, m, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !m.memories[0].Archived || m.memories[1].Archived || !m.memories[2].Archived {
+		t.Errorf("archived %+v, want mem-1 and mem-3", m.memories)
+	}
+	cfg.DeleteMemories = true
+	if err := runGC(context.Background(), cfg, m, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.memories) != 1 || m.memories[0].ID != "mem-2" {
+		t.Errorf("left %+v, want mem-2", m.memories)
+	}
+
+	for _, bad := range []Config{{HalfLife: 0, Threshold: 0.1}, {HalfLife: time.Hour, Threshold: 2}} {
+		if err := runGC(context.Background(), bad, m, io.Discard); exitCode(err) != exitUsage {
+			t.Errorf("%+v: err = %v, want a usage error", bad, err)
+		}
+	}
+}
+
+func TestRunConsolidate(t *testing.T) {
+	m := &fakeMemories{memories: []codegraph.Memory{
+		{ID: "mem-1", Text: "main exits 2 on bad flags", About: []string{"File:main.go"}},
+		{ID: "mem-2", Text: "main exits 2 on bad flags\nand 1 on errors", Tags: []string{"cli"}, About: []string{"File:main.go"}},
+		{ID: "mem-3", Text: "run reads the config", About: []string{"File:main.go"}},
+	}}
+	cfg := Config{Project: "App", Similarity: 0.5, DryRun: true}
+	var buf bytes.Buffer
+	if err := runConsolidate(context.Background(), cfg, m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "(new) <- mem-1, mem-2\n  main exits 2 on bad flags\n  and 1 on errors\n"; buf.String() != want {
+		t.Errorf("dry run listed\n%s\nwant\n%s", buf.String(), want)
+	}
+	if len(m.memories) != 3 || m.memories[0].Archived {
+		t.Errorf("dry run changed %+v", m.memories)
+	}
+
+	cfg.DryRun = false
+	buf.Reset()
+	if err := runConsolidate(context.Background(), cfg, m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "mem-4 <- mem-1, mem-2\n") {
+		t.Errorf("consolidate listed\n%s", buf.String())
+	}
+	if len(m.memories) != 4 || !m.memories[0].Archived || !m.memories[1].Archived || m.memories[2].Archived ||
+		!slices.Equal(m.memories[3].Sources, []string{"mem-1", "mem-2"}) || !slices.Equal(m.memories[3].Tags, []string{"cli"}) {
+		t.Errorf("memories = %+v", m.memories)
+	}
+
+	for _, similarity := range []float64{0, 1.5} {
+		if err := runConsolidate(context.Background(), Config{Similarity: similarity}, m, io.Discard); exitCode(err) != exitUsage {
+			t.Errorf("similarity %v: err = %v, want a usage error", similarity, err)
+		}
+	}
+}
+
+func TestPrintMemories(t *testing.T) {
+	var buf bytes.Buffer
+	printMemories(&buf, []codegraph.Memory{
+		{ID: "mem-2", Text: "Put holds the lock\nacross fsync\n", Tags: []string{"perf", "locking"},
+			About: []string{"Function:store/store.go:*Store.Put"}, Mentions: []string{"Struct:store/store.go:Store"}, CreatedAt: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+			Pinned: true, Importance: 0.9, Confidence: codegraph.DefaultConfidence, GitState: codegraph.GitState{Commit: "abc123"},
+			Provenance: codegraph.Provenance{Via: codegraph.ViaMCP, Agent: "claude-code"}},
+		{ID: "mem-1", Text: "CLI entry point", About: []string{"File:main.go", "Package:."}, CreatedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			ExpiresAt: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), Archived: true, Sources: []string{"mem-0"}, Namespace: "team:cli", Project: "Lib"},
+	})
+	want := "mem-2  2026-03-04 05:06:07  [perf, locking]  pinned  importance 0.9\n" +
+		"  about Function:store/store.go:*Store.Put\n" +
+		"  mentions Struct:store/store.go:Store\n" +
+		"  at abc123\n" +
+		"  via mcp, agent claude-code\n" +
+		"  Put holds the lock\n" +
+		"  across fsync\n" +
+		"\n" +
+		"mem-1  2026-03-01 00:00:00  expires 2026-04-01 00:00:00  archived  in team:cli  from Lib\n" +
+		"  about File:main.go, Package:.\n" +
+		"  consolidates mem-0\n" +
+		"  CLI entry point\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printMemories =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRunSession(t *testing.T) {
+	f := &fakeSessions{}
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", WorkDir: dir, Branch: "main"}
+	if err := runSession(context.Background(), cfg, f, []string{"start"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ses-1\n" || len(f.sessions) != 1 || f.sessions[0].Dir != dir || f.sessions[0].Branch != "main" {
+		t.Errorf("start printed %q and stored %+v", buf.String(), f.sessions)
+	}
+
+	cfg.Touch = []string{"main.go"}
+	if err := runSession(context.Background(), cfg, f, []string{"touch", "ses-1"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Session = "ses-1"
+	if err := runSession(context.Background(), cfg, f, []string{"end"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(f.sessions[0].Touched, []string{"main.go"}) || f.sessions[0].EndedAt.IsZero() {
+		t.Errorf("session = %+v, want main.go touched and ended", f.sessions[0])
+	}
+
+	buf.Reset()
+	if err := runSession(context.Background(), cfg, f, []string{"show"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "touched  main.go") {
+		t.Errorf("show printed %q", buf.String())
+	}
+	if err := runSession(context.Background(), cfg, f, []string{"show", "ses-9"}, &buf); !errors.Is(err, codegraph.ErrNoSession) {
+		t.Errorf("show ses-9: err = %v, want ErrNoSession", err)
+	}
+
+	for _, args := range [][]string{nil, {"pause"}} {
+		if err := runSession(context.Background(), cfg, f, args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q: err = %v, want a usage error", args, err)
+		}
+	}
+	cfg.Session, cfg.Touch = "", nil
+	if err := runSession(context.Background(), cfg, f, []string{"end"}, &buf); exitCode(err) != exitUsage {
+		t.Errorf("end without an ID: err = %v, want a usage error", err)
+	}
+	if err := runSession(context.Background(), cfg, f, []string{"touch", "ses-1"}, &buf); exitCode(err) != exitUsage {
+		t.Errorf("touch without --file: err = %v, want a usage error", err)
+	}
+}
+
+func TestPrintSessions(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	printSessions(&buf, []codegraph.Session{
+		{ID: "ses-20260304T050607Z-0a1b2c3d", StartedAt: start, EndedAt: start.Add(54*time.Minute + 3*time.Second),
+			Branch: "feature/sessions", Touched: []string{"main.go"}, Memories: 2},
+		{ID: "ses-20260305T000000Z-00000000", StartedAt: start.Add(19 * time.Hour)},
+	})
+	want := "session                        started              took       branch                memories  files\n" +
+		"ses-20260304T050607Z-0a1b2c3d  2026-03-04 05:06:07  54m3s      feature/sessions             2      1\n" +
+		"ses-20260305T000000Z-00000000  2026-03-05 00:06:07  open       -                            0      0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printSessions =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrintReplay(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	printReplay(&buf, codegraph.Session{ID: "ses-1", StartedAt: start, Dir: "/src/app", Touched: []string{"main.go", "store/store.go"}},
+		[]codegraph.Summary{{ID: "sum-1", Session: "ses-1", Text: "Split the CLI\nfrom the store", Packages: []string{".", "store"}, CreatedAt: start}},
+		[]codegraph.Memory{{ID: "mem-1", Text: "CLI entry point", About: []string{"File:main.go"}, CreatedAt: start}},
+		[]codegraph.Decision{{ID: "dec-1", Title: "One binary", Status: "accepted", Affects: []string{"Package:."}, CreatedAt: start}})
+	want := "session ses-1\n" +
+		"  started  2026-03-04 05:06:07 (open)\n" +
+		"  dir      /src/app\n" +
+		"  branch   -\n" +
+		"  touched  main.go, store/store.go\n" +
+		"\n" +
+		"sum-1  2026-03-04 05:06:07  session ses-1\n" +
+		"  in ., store\n" +
+		"  Split the CLI\n" +
+		"  from the store\n" +
+		"\n" +
+		"mem-1  2026-03-04 05:06:07\n" +
+		"  about File:main.go\n" +
+		"  CLI entry point\n" +
+		"\n" +
+		"dec-1  2026-03-04 05:06:07  [accepted]  One binary\n" +
+		"  affects Package:.\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printReplay =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRunSessionSummary(t *testing.T) {
+	f := &fakeSessions{sessions: []codegraph.Session{{ID: "ses-1", Touched: []string{"main.go"}}}}
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", Session: "ses-1"}
+	if err := runSessionSummary(context.Background(), cfg, f, []string{"add", "Made main exit 2 on bad flags"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "sum-1\n" || len(f.summaries) != 1 || !slices.Equal(f.summaries[0].About, []string{"File:main.go"}) {
+		t.Errorf("add printed %q and stored %+v", buf.String(), f.summaries)
+	}
+
+	buf.Reset()
+	cfg.Package, cfg.Limit = "pkg/store", 3
+	if err := runSessionSummary(context.Background(), cfg, f, []string{"list"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := (codegraph.SummaryQuery{Package: "pkg/store", Limit: 3}); len(f.queries) != 1 || f.queries[0] != want {
+		t.Errorf("listed %+v, want %+v", f.queries, want)
+	}
+	cfg.Package = ""
+	if err := runSessionSummary(context.Background(), cfg, f, []string{"list"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "sum-1  2026-03-04 06:00:00  session ses-1\n") {
+		t.Errorf("list printed %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := runSession(context.Background(), cfg, f, []string{"show"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "  Made main exit 2 on bad flags\n") {
+		t.Errorf("session show printed %q", buf.String())
+	}
+
+	for _, tt := range []struct {
+		cfg  Config
+		args []string
+	}{
+		{cfg, []string{"add"}},
+		{Config{Project: "App"}, []string{"add", "x"}},
+		{cfg, []string{"list", "x"}},
+		{Config{Project: "App", Limit: -1}, []string{"list"}},
+		{cfg, []string{"remove"}},
+	} {
+		if err := runSessionSummary(context.Background(), tt.cfg, f, tt.args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q: err = %v, want a usage error", tt.args, err)
+		}
+	}
+	if err := runSessionSummary(context.Background(), Config{Project: "App", Session: "ses-9"}, f, []string{"add", "x"}, &buf); !errors.Is(err, codegraph.ErrNoSession) {
+		t.Errorf("unknown session: err = %v, want ErrNoSession", err)
+	}
+}
+
+func TestRunDecision(t *testing.T) {
+	d := &fakeDecisions{}
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", Title: "One binary", Rationale: "simpler installs", Alternatives: []string{"a daemon"}, Affects: []string{"File:main.go"}}
+	if err := runDecision(context.Background(), cfg, d, []string{"add"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "dec-1\n" || len(d.decisions) != 1 || d.decisions[0].Status != "accepted" || !slices.Equal(d.decisions[0].Alternatives, []string{"a daemon"}) {
+		t.Errorf("add printed %q and stored %+v", buf.String(), d.decisions)
+	}
+
+	buf.Reset()
+	if err := runDecision(context.Background(), Config{Project: "App", About: []string{"Function:main.go:run"}}, d, []string{"list"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Function:main.go:run", "File:main.go", "Package:."}; len(d.queries) != 1 || !slices.Equal(d.queries[0].Affects, want) {
+		t.Errorf("listed %+v, want affecting %q", d.queries, want)
+	}
+	if !strings.Contains(buf.String(), "dec-1") {
+		t.Errorf("list printed %q", buf.String())
+	}
+
+	if err := runDecision(context.Background(), cfg, d, []string{"status", "dec-1", "superseded"}, &buf); err != nil || d.decisions[0].Status != "superseded" {
+		t.Errorf("status: err = %v, decision %+v", err, d.decisions[0])
+	}
+	if err := runDecision(context.Background(), cfg, d, []string{"status", "dec-2", "rejected"}, &buf); !errors.Is(err, codegraph.ErrNoDecision) {
+		t.Errorf("status dec-2: err = %v, want ErrNoDecision", err)
+	}
+
+	for _, tt := range []struct {
+		cfg  Config
+		args []string
+	}{
+		{cfg, nil},
+		{cfg, []string{"undo"}},
+		{cfg, []string{"add", "extra"}},
+		{Config{Project: "App", Title: "x"}, []string{"add"}},
+		{Config{Project: "App", Title: "x", Affects: []string{"File:main.go"}, Status: "maybe"}, []string{"add"}},
+		{cfg, []string{"status", "dec-1"}},
+		{cfg, []string{"status", "dec-1", "done"}},
+	} {
+		if err := runDecision(context.Background(), tt.cfg, d, tt.args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q with %+v: err = %v, want a usage error", tt.args, tt.cfg, err)
+		}
+	}
+}
+
+func TestPrintDecisions(t *testing.T) {
+	var buf bytes.Buffer
+	printDecisions(&buf, []codegraph.Decision{
+		{ID: "dec-2", Title: "Writes go through one goroutine", Rationale: "the driver is not\nsafe for concurrent use\n",
+			Alternatives: []string{"a mutex per store", "a pool"}, Status: "accepted", Affects: []string{"Package:store", "File:main.go"},
+			CreatedAt: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC), GitState: codegraph.GitState{Commit: "0123456789abcdef", Branch: "main", PR: 42}},
+		{ID: "dec-1", Title: "Use SQLite", Status: "superseded", Affects: []string{"Package:store"}, CreatedAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	want := "dec-2  2026-03-04 05:06:07  [accepted]  Writes go through one goroutine\n" +
+		"  affects Package:store, File:main.go\n" +
+		"  at 0123456789ab on main (#42)\n" +
+		"  the driver is not\n" +
+		"  safe for concurrent use\n" +
+		"  instead of a mutex per store\n" +
+		"  instead of a pool\n" +
+		"\n" +
+		"dec-1  2026-03-01 00:00:00  [superseded]  Use SQLite\n" +
+		"  affects Package:store\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printDecisions =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRunConvention(t *testing.T) {
+	c := &fakeConventions{}
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", Category: "logging", Rationale: "one format for every service", Packages: []string{"."}, Namespace: "team:cli,user:alice"}
+	if err := runConvention(context.Background(), cfg, c, []string{"add", "log with slog"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "con-1\n" || len(c.conventions) != 1 || c.conventions[0].Rule != "log with slog" || c.conventions[0].Namespace != "team:cli" {
+		t.Errorf("add printed %q and stored %+v", buf.String(), c.conventions)
+	}
+
+	buf.Reset()
+	if err := runConvention(context.Background(), Config{Project: "App", About: []string{"Function:cmd/main.go:run"}, Namespace: "user:alice"}, c, []string{"list"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := (codegraph.ConventionQuery{Package: "cmd", Namespaces: []string{"user:alice"}}); len(c.queries) != 1 || !reflect.DeepEqual(c.queries[0], want) {
+		t.Errorf("listed %+v, want %+v", c.queries, want)
+	}
+	if !strings.Contains(buf.String(), "con-1") {
+		t.Errorf("list printed %q", buf.String())
+	}
+
+	if err := runConvention(context.Background(), cfg, c, []string{"remove", "con-1"}, &buf); err != nil || len(c.conventions) != 0 {
+		t.Errorf("remove: err = %v, conventions %+v", err, c.conventions)
+	}
+	if err := runConvention(context.Background(), cfg, c, []string{"remove", "con-1"}, &buf); err == nil || exitCode(err) != exitFailure {
+		t.Errorf("removing con-1 twice: err = %v", err)
+	}
+
+	for _, tt := range []struct {
+		cfg  Config
+		args []string
+	}{
+		{cfg, nil},
+		{cfg, []string{"undo"}},
+		{cfg, []string{"add"}},
+		{Config{Project: "App", Category: "tabs"}, []string{"add", "x"}},
+		{Config{Project: "App", Packages: []string{"a", "b"}}, []string{"list"}},
+		{cfg, []string{"list", "extra"}},
+		{cfg, []string{"remove"}},
+	} {
+		if err := runConvention(context.Background(), tt.cfg, c, tt.args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q with %+v: err = %v, want a usage error", tt.args, tt.cfg, err)
+		}
+	}
+}
+
+func TestPrintConventions(t *testing.T) {
+	var buf bytes.Buffer
+	printConventions(&buf, []codegraph.Convention{
+		{ID: "con-2", Category: "error-handling", Rule: "wrap errors with %w", Rationale: "callers match them\nwith errors.Is\n",
+			Example: "return fmt.Errorf(\"reading %s: %w\", path, err)\n", Packages: []string{"store", "cmd"}},
+		{ID: "con-1", Category: "preference", Rule: "keep functions short", Namespace: "user:alice", Packages: []string{}},
+	})
+	want := "con-2  [error-handling]  wrap errors with %w\n" +
+		"  applies to store, cmd\n" +
+		"  callers match them\n" +
+		"  with errors.Is\n" +
+		"  for example:\n" +
+		"    return fmt.Errorf(\"reading %s: %w\", path, err)\n" +
+		"\n" +
+		"con-1  [preference]  in user:alice  keep functions short\n" +
+		"  applies to the whole project\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printConventions =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRunAnalyze(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { run() }\n\nfunc run() {}\n\nfunc orphan() {}\n",
+	})
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	if err := runAnalyze(context.Background(), Config{Project: "App", Path: root}, f, []string{"deadcode"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "main.go:7  unused-function  orphan is not called or referred to\n"; buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if f.analysis != "deadcode" || len(f.findings) != 1 || f.findings[0].Key != "Function:main.go:orphan" {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+
+	// A dry run writes nothing
+	buf.Reset()
+	if err := runAnalyze(context.Background(), Config{Project: "App", Path: root}, nil, []string{"deadcode"}, &buf); err != nil || buf.Len() == 0 {
+		t.Errorf("dry run: err = %v, printed %q", err, buf.String())
+	}
+
+	for _, tt := range []struct {
+		backend any
+		args    []string
+	}{
+		{f, nil},
+		{f, []string{"lint"}},
+		{f, []string{"cycles"}},
+		{struct{}{}, []string{"deadcode"}},
+	} {
+		if err := runAnalyze(context.Background(), Config{Project: "App", Path: root}, tt.backend, tt.args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q with %T: err = %v, want a usage error", tt.args, tt.backend, err)
+		}
+	}
+}
+
+func TestAnalyzeCycles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a/a.go": "package a\n\nimport \"example.com/app/b\"\n\nfunc A() { b.B() }\n",
+		"b/b.go": "package b\n\nimport \"example.com/app/a\"\n\nfunc B() { a.A() }\n",
+	})
+	c := &fakeCycles{}
+	var buf bytes.Buffer
+	if err := runAnalyze(context.Background(), Config{Project: "App", Path: root}, c, []string{"cycles"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "import cycle  a -> b -> a\n"; buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if len(c.cycles) != 1 || !slices.Equal(c.cycles[0].Packages, []string{"a", "b"}) {
+		t.Errorf("replaced cycles with %+v", c.cycles)
+	}
+}
+
+func TestAnalyzeStability(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go":        "package main\n\nimport \"example.com/app/model\"\n\nfunc main() { _ = model.User{} }\n",
+		"model/model.go": "package model\n\ntype User struct{}\n",
+	})
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	if err := runAnalyze(context.Background(), Config{Project: "App", Path: root, Distance: 0.7}, f, []string{"stability"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "  afferent  efferent  instability  abstractness  distance  package\n" +
+		"         0         1         1.00          0.00      0.00  .\n" +
+		"         1         0         0.00          0.00      1.00  model  zone of pain\n"
+	if buf.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", buf.String(), want)
+	}
+	if f.analysis != "stability" || len(f.findings) != 1 || f.findings[0].Key != "Package:model" {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+	if err := runAnalyze(context.Background(), Config{Project: "App", Path: root, Distance: 2}, f, []string{"stability"}, &buf); exitCode(err) != exitUsage {
+		t.Errorf("--distance 2: err = %v, want a usage error", err)
+	}
+}
+
+func TestAnalyzeHotspots(t *testing.T) {
+	root := gitRepo(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {\n\tif len(run()) > 0 {\n\t}\n}\n\nfunc run() string {\n\tif true {\n\t}\n\treturn \"\"\n}\n",
+	})
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", Path: root, Churn: "1970-01-01", Limit: 2}
+	if err := runAnalyze(context.Background(), cfg, f, []string{"hotspots"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "  score  churn  complexity  fan-in  hotspot\n" +
+		"      4      1           4       0  main.go\n" +
+		"      4      1           2       1  run  main.go:8\n"
+	if buf.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", buf.String(), want)
+	}
+	if f.analysis != "hotspots" || len(f.findings) != 2 || f.findings[0].Kind != codegraph.FileHotspot || f.findings[1].Key != "Function:main.go:run" {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+	cfg.Churn = ""
+	if err := runAnalyze(context.Background(), cfg, f, []string{"hotspots"}, &buf); exitCode(err) != exitUsage {
+		t.Errorf("no --churn: err = %v, want a usage error", err)
+	}
+}
+
+func TestAnalyzeUnusedAPI(t *testing.T) {
+	lib := writeTree(t, map[string]string{
+		"go.mod":         "module example.com/lib\n",
+		"store/store.go": "package store\n\nfunc Open() {}\n\nfunc Close() {}\n",
+	})
+	app := writeTree(t, map[string]string{
+		"main.go": "package main\n\nimport \"example.com/lib/store\"\n\nfunc main() { store.Open() }\n",
+	})
+	cfg := Config{Project: "Lib", Path: lib, Workspace: []target{{Project: "Lib", Path: lib}, {Project: "App", Path: app}}}
+	graph, err := codegraph.Parser{Root: lib}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	if err := analyzeUnusedAPI(context.Background(), cfg, graph, f, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "store/store.go:5  unused-api  Close is exported but not called or referred to in its module or the 1 projects importing it\n"
+	if buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if f.analysis != "unused-api" || len(f.findings) != 1 {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+
+	cfg.Workspace = cfg.Workspace[:1]
+	if err := analyzeUnusedAPI(context.Background(), cfg, graph, nil, &buf); exitCode(err) != exitUsage {
+		t.Errorf("a single project: err = %v, want a usage error", err)
+	}
+}
+
+func TestAnalyzeInterfaces(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"store/store.go": `package store
+
+type Store interface {
+	Get(key string) string
+	Put(key, value string)
+}
+
+type Mem struct{}
+
+func (Mem) Get(key string) string { return "" }
+
+func (Mem) Put(key, value string) {}
+`,
+	})
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	if err := analyzeInterfaces(context.Background(), Config{Project: "App", Path: root, Methods: 5}, graph, f, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "store/store.go  single-implementation  Store has a single implementation, Mem, which could be used directly\n"
+	if buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if f.analysis != "interfaces" || len(f.findings) != 1 || f.findings[0].Key != "Interface:store/store.go:Store" {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+
+	if err := analyzeInterfaces(context.Background(), Config{Project: "App", Path: root}, graph, nil, &buf); exitCode(err) != exitUsage {
+		t.Errorf("no --methods: err = %v, want a usage error", err)
+	}
+}
+
+func TestAnalyzeGod(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {\n\trun(1, 2, 3)\n}\n\nfunc run(a, b, c int) {}\n",
+	})
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	cfg := Config{Project: "App", Path: root, God: codegraph.GodLimits{Lines: 4, Params: 2}}
+	if err := analyzeGod(context.Background(), cfg, graph, f, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "main.go:7  god-function (warning)  run has 3 parameters (limit 2)\n"
+	if buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if f.analysis != "god" || len(f.findings) != 1 || f.findings[0].Severity != codegraph.SeverityWarning {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+
+	cfg.God.Lines = -1
+	if err := analyzeGod(context.Background(), cfg, graph, nil, &buf); exitCode(err) != exitUsage {
+		t.Errorf("a negative limit: err = %v, want a usage error", err)
+	}
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {\n\tsave()\n}\n\nfunc save() error { return nil }\n",
+	})
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	if err := analyzeErrors(context.Background(), Config{Project: "App", Path: root}, graph, f, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "main.go:4  discarded-error  main ignores the error save returns\n"
+	if buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if f.analysis != "errors" || len(f.findings) != 1 || f.findings[0].Key != "Function:main.go:main" {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+}
+
+func TestAnalyzeDepth(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { run() }\n\nfunc run() { step() }\n\nfunc step() {}\n",
+	})
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFindings{}
+	var buf bytes.Buffer
+	if err := analyzeDepth(context.Background(), Config{Project: "App", Path: root, MaxDepth: 2}, graph, f, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "main.go:3  main  2  main -> run -> step\n"
+	if buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if f.analysis != "depth" || len(f.findings) != 1 || f.findings[0].Kind != codegraph.DeepCallChain {
+		t.Errorf("replaced %s findings with %+v", f.analysis, f.findings)
+	}
+
+	if err := analyzeDepth(context.Background(), Config{Project: "App", Path: root}, graph, nil, &buf); exitCode(err) != exitUsage {
+		t.Errorf("no --max-depth: err = %v, want a usage error", err)
+	}
+}
+
+func TestRunImpact(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go":             "package main\n\nimport \"example.com/app/store\"\n\nfunc main() { run() }\n\nfunc run() { store.Open() }\n",
+		"store/store.go":      "package store\n\ntype Store struct{}\n\nfunc Open() *Store { return &Store{} }\n",
+		"store/store_test.go": "package store\n\nimport \"testing\"\n\nfunc TestOpen(t *testing.T) { Open() }\n",
+	})
+	var buf bytes.Buffer
+	if err := runImpact(context.Background(), Config{Project: "App", Path: root, Depth: 2}, []string{"Store"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `changed: Struct:store/store.go:Store
+symbols: 2
+  1  USES_TYPE  Open  store/store.go:5
+  2  CALLS      run   main.go:7
+tests: 1
+  2  CALLS  TestOpen  store/store_test.go:5
+files: 3
+  main.go
+  store/store.go
+  store/store_test.go
+`
+	if buf.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	for _, args := range [][]string{nil, {"missing"}} {
+		if err := runImpact(context.Background(), Config{Project: "App", Path: root, Depth: 2}, args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q: err = %v, want a usage error", args, err)
+		}
+	}
+}
+
+func TestAddVulnerabilities(t *testing.T) {
+	ctx := context.Background()
+	root := writeTree(t, map[string]string{
+		"main.go": "package main\n\nimport \"golang.org/x/net/html\"\n\nfunc main() {\n\thtml.Parse(nil)\n}\n",
+		"vulns.json": `{"osv": {"id": "GO-2023-1988", "aliases": ["CVE-2023-3978"]}}
+{"finding": {"osv": "GO-2023-1988", "trace": [{"module": "golang.org/x/net", "package": "golang.org/x/net/html", "function": "Parse"},
+  {"module": "example.com/app", "package": "example.com/app", "function": "main", "position": {"filename": "main.go", "line": 6}}]}}
+`,
+	})
+	graph, err := codegraph.Parser{Root: root}.Parse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addVulnerabilities(ctx, Config{Path: root}, graph); err != nil || graph.Vulnerabilities != nil {
+		t.Errorf("without --govulncheck: err = %v, vulnerabilities %v", err, graph.Vulnerabilities)
+	}
+	if err := addVulnerabilities(ctx, Config{Path: root, Govulncheck: filepath.Join(root, "vulns.json")}, graph); err != nil {
+		t.Fatal(err)
+	}
+	if v := graph.Vulnerabilities; len(v) != 1 || v[0].Level != "symbol" || len(v[0].Paths) != 1 || v[0].Paths[0].From != "Function:main.go:main" {
+		t.Errorf("vulnerabilities = %+v", v)
+	}
+	if err := addVulnerabilities(ctx, Config{Path: root, Govulncheck: filepath.Join(root, "main.go")}, graph); err == nil {
+		t.Error("a Go file read as govulncheck output has no error")
+	}
+}
+
+func TestRunBreaking(t *testing.T) {
+	ctx := context.Background()
+	root := gitRepo(t, map[string]string{
+		"main.go":        "package main\n\nfunc Run() {}\n",
+		"store/store.go": "package store\n\ntype Store struct{}\n\nfunc (s *Store) Get(key string) string { return key }\n\nfunc Open() *Store { return nil }\n",
+	})
+	var buf bytes.Buffer
+	if err := runBreaking(ctx, Config{Path: root, Base: "HEAD", Format: "text"}, &buf); err != nil || buf.Len() > 0 {
+		t.Fatalf("unchanged: err = %v, printed %q", err, buf.String())
+	}
+
+	// main is not importable, and an added function breaks nothing
+	changed := "package store\n\ntype Store struct{}\n\nfunc (s *Store) Get(key string, n int) string { return key }\n\nfunc New() *Store { return nil }\n"
+	if err := os.WriteFile(filepath.Join(root, "store", "store.go"), []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(root, "main.go"))
+	err := runBreaking(ctx, Config{Path: root, Base: "HEAD", Format: "text"}, &buf)
+	if exitCode(err) != exitBreaking {
+		t.Errorf("err = %v, want exit code %d", err, exitBreaking)
+	}
+	want := `store.Open       removed  func Open was removed or unexported
+store.Store.Get  changed  Store.Get changed from func (Store) Get(string) string to func (Store) Get(string, int) string
+`
+	if buf.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// The working tree as a base, against the commit
+	buf.Reset()
+	err = runBreaking(ctx, Config{Path: root, Base: root, Rev: "HEAD", Format: "json"}, &buf)
+	var changes []codegraph.APIChange
+	if jsonErr := json.Unmarshal(buf.Bytes(), &changes); jsonErr != nil || exitCode(err) != exitBreaking || len(changes) != 2 || changes[0].Name != "New" {
+		t.Errorf("err = %v, printed %s", err, buf.String())
+	}
+
+	for _, cfg := range []Config{{Path: root, Format: "text"}, {Path: root, Base: "HEAD", Format: "csv"}} {
+		if err := runBreaking(ctx, cfg, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%+v: err = %v, want a usage error", cfg, err)
+		}
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	ctx := context.Background()
+	root := writeTree(t, map[string]string{
+		"pkg/domain/user.go": "package domain\n\nimport _ \"example.com/app/pkg/http\"\n",
+		"pkg/store/store.go": "package store\n\nimport _ \"database/sql\"\n",
+		"main.go":            "package main\n\nimport _ \"database/sql\"\n\nfunc main() {}\n",
+	})
+	var buf bytes.Buffer
+	cfg := Config{Path: root, Rules: []string{"pkg/domain must not import pkg/http", "only pkg/store may import database/sql"}}
+	if err := runCheck(ctx, cfg, &buf); exitCode(err) != exitRules {
+		t.Errorf("err = %v, want exit code %d", err, exitRules)
+	}
+	want := `main.go imports database/sql, breaking the rule "only pkg/store may import database/sql"
+pkg/domain/user.go imports example.com/app/pkg/http, breaking the rule "pkg/domain must not import pkg/http"
+`
+	if buf.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	cfg.Rules = []string{"pkg/store must not import pkg/domain"}
+	if err := runCheck(ctx, cfg, &buf); err != nil || buf.Len() > 0 {
+		t.Errorf("no violations: err = %v, printed %q", err, buf.String())
+	}
+	for _, rules := range [][]string{nil, {"pkg/store imports pkg/domain"}} {
+		if err := runCheck(ctx, Config{Path: root, Rules: rules}, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q: err = %v, want a usage error", rules, err)
+		}
+	}
+}
+
+func TestRunUntested(t *testing.T) {
+	ctx := context.Background()
+	root := writeTree(t, map[string]string{
+		"calc.go":      "package calc\n\nfunc Add(a, b int) int {\n\treturn a + b\n}\n\nfunc Sub(a, b int) int {\n\treturn a - b\n}\n",
+		"calc_test.go": "package calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Fail()\n\t}\n}\n",
+		"change.diff":  "diff --git a/calc.go b/calc.go\n--- a/calc.go\n+++ b/calc.go\n@@ -4 +4 @@\n-\treturn b + a\n+\treturn a + b\n@@ -8 +8 @@\n-\treturn b - a\n+\treturn a - b\n",
+	})
+	var buf bytes.Buffer
+	cfg := Config{Path: root, Diff: filepath.Join(root, "change.diff"), Format: "text"}
+	if err := runUntested(ctx, cfg, nil, &buf); exitCode(err) != exitUntested {
+		t.Errorf("err = %v, want exit code %d", err, exitUntested)
+	}
+	if want := "calc.go:7\tSub changed but no test reaches it\n"; buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	cfg.Diff, cfg.Format = "-", "json"
+	diff := "diff --git a/calc.go b/calc.go\n--- a/calc.go\n+++ b/calc.go\n@@ -4 +4 @@\n-\treturn b + a\n+\treturn a + b\n"
+	if err := runUntested(ctx, cfg, strings.NewReader(diff), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"changed\": 1,\n  \"untested\": []\n}\n"; buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+	if err := runUntested(ctx, Config{Path: root, Diff: "-", Format: "yaml"}, nil, &buf); exitCode(err) != exitUsage {
+		t.Errorf("err = %v, want a usage error", err)
+	}
+}
+
+func TestAddCoverage(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"main.go":   "package main\n\nfunc main() {\n\trun()\n}\n\nfunc run() {}\n",
+		"cover.out": "mode: set\nexample.com/app/main.go:3.13,5.2 1 1\nexample.com/app/main.go:7.12,7.14 0 0\n",
+	})
+	graph, err := codegraph.Parser{Root: root}.Parse(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addCoverage(Config{Path: root}, graph); err != nil || graph.Coverage != nil {
+		t.Errorf("without --coverprofile: err = %v, coverage %v", err, graph.Coverage)
+	}
+	cfg := Config{Path: root, CoverProfile: filepath.Join(root, "cover.out")}
+	if err := addCoverage(cfg, graph); err != nil {
+		t.Fatal(err)
+	}
+	if c := graph.Coverage["Function:main.go:main"]; c.Statements != 1 || c.Percent() != 100 || len(graph.Coverage) != 2 {
+		t.Errorf("coverage = %+v", graph.Coverage)
+	}
+	cfg.CoverProfile = filepath.Join(root, "main.go")
+	if err := addCoverage(cfg, graph); err == nil || !strings.Contains(err.Error(), "main.go") {
+		t.Errorf("reading a source file as a profile: err = %v", err)
+	}
+}
+
+func TestPrintSnapshots(t *testing.T) {
+	var buf bytes.Buffer
+	printSnapshots(&buf, "App", []codegraph.Snapshot{
+		{RunID: "20260304T050607Z-0a1b2c3d", Commit: "0123456789abcdef", Written: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC), Nodes: 10, Deleted: 2},
+		{RunID: "20260301T000000Z-00000000", Deleted: 3},
+	})
+	want := "project App\n" +
+		"  run                         written               commit          nodes  deleted\n" +
+		"  20260304T050607Z-0a1b2c3d   2026-03-04 05:06:07   0123456789ab       10        2\n" +
+		"  20260301T000000Z-00000000   -                     -                   0        3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printSnapshots =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		params []string
+		want   map[string]any
+		err    bool
+	}{
+		{nil, map[string]any{}, false},
+		{[]string{"name=Parse"}, map[string]any{"name": "Parse"}, false},
+		{[]string{"n=10", "f=1.5", "ok=true"}, map[string]any{"n": int64(10), "f": 1.5, "ok": true}, false},
+		{[]string{`names=["a","b"]`}, map[string]any{"names": []any{"a", "b"}}, false},
+		{[]string{`m={"depth":2}`}, map[string]any{"m": map[string]any{"depth": int64(2)}}, false},
+		{[]string{"s=1 2"}, map[string]any{"s": "1 2"}, false},
+		{[]string{"empty="}, map[string]any{"empty": ""}, false},
+		{[]string{"expr=a=b"}, map[string]any{"expr": "a=b"}, false},
+		{[]string{"name"}, nil, true},
+		{[]string{"=value"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseParams(tt.params)
+		if (err != nil) != tt.err {
+			t.Errorf("parseParams(%q) error = %v, want error %v", tt.params, err, tt.err)
+			continue
+		}
+		if fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) && !tt.err {
+			t.Errorf("parseParams(%q) = %#v, want %#v", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestReadQuery(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "query.cypher")
+	os.WriteFile(file, []byte("MATCH (n) RETURN n\n"), 0o644)
+	blank := filepath.Join(t.TempDir(), "blank.cypher")
+	os.WriteFile(blank, []byte(" \n"), 0o644)
+
+	tests := []struct {
+		arg, file string
+		want      string
+		err       bool
+	}{
+		{"RETURN 1", "", "RETURN 1", false},
+		{"", file, "MATCH (n) RETURN n\n", false},
+		{"RETURN 1", file, "", true},
+		{"", blank, "", true},
+		{"", filepath.Join(t.TempDir(), "missing"), "", true},
+	}
+	for _, tt := range tests {
+		got, err := readQuery(tt.arg, tt.file)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("readQuery(%q, %q) = %q, %v, want %q, error %v", tt.arg, tt.file, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPrintRows(t *testing.T) {
+	columns := []string{"name", "calls", "node"}
+	rows := [][]any{
+		{"Parse", int64(2), neo4j.Node{Labels: []string{"Function"}, Props: map[string]any{"name": "Parse"}}},
+		{"main", nil, []any{"a", int64(1)}},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "name   calls  node\n" +
+			`Parse  2      {"labels":["Function"],"properties":{"name":"Parse"}}` + "\n" +
+			`main          ["a",1]` + "\n" +
+			"(2 rows)\n"},
+		{"csv", "name,calls,node\n" +
+			`Parse,2,"{""labels"":[""Function""],""properties"":{""name"":""Parse""}}"` + "\n" +
+			`main,,"[""a"",1]"` + "\n"},
+		{"json", `[
+  {
+    "calls": 2,
+    "name": "Parse",
+    "node": {
+      "labels": [
+        "Function"
+      ],
+      "properties": {
+        "name": "Parse"
+      }
+    }
+  },
+  {
+    "calls": null,
+    "name": "main",
+    "node": [
+      "a",
+      1
+    ]
+  }
+]
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := printRows(&buf, tt.format, columns, rows); err != nil {
+			t.Errorf("printRows(%s): %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("printRows(%s) =\n%s\nwant\n%s", tt.format, buf.String(), tt.want)
+		}
+	}
+	if err := printRows(io.Discard, "yaml", columns, rows); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestPlainValue(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value any
+		want  any
+	}{
+		{nil, nil},
+		{"a", "a"},
+		{at, "2024-01-02T03:04:05Z"},
+		{neo4j.Relationship{Type: "CALLS", Props: map[string]any{"line": int64(3)}},
+			map[string]any{"type": "CALLS", "properties": map[string]any{"line": int64(3)}}},
+		{neo4j.Path{Nodes: []neo4j.Node{{Labels: []string{"File"}}}},
+			map[string]any{"nodes": []any{map[string]any{"labels": []string{"File"}, "properties": map[string]any{}}}, "relationships": []any{}}},
+		{[]any{at}, []any{"2024-01-02T03:04:05Z"}},
+		{map[string]any{"at": at}, map[string]any{"at": "2024-01-02T03:04:05Z"}},
+		{time.Second, "1s"},
+	}
+	for _, tt := range tests {
+		if got := plainValue(tt.value); fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+			t.Errorf("plainValue(%#v) = %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNamedQueries(t *testing.T) {
+	labels := codegraph.LabelMap{Rename: map[string]string{"Function": "Func"}}
+	for name, q := range namedQueries {
+		query := labels.Rewrite(fmt.Sprintf(q.Cypher, "App", 4))
+		if strings.Contains(query, "%!") {
+			t.Errorf("%s: bad format verbs in\n%s", name, query)
+		}
+		if !strings.Contains(query, ":App") {
+			t.Errorf("%s: project label missing from\n%s", name, query)
+		}
+		if strings.Contains(q.Cypher, ":Function") && !strings.Contains(query, ":Func") {
+			t.Errorf("%s: labels not rewritten in\n%s", name, query)
+		}
+		if !strings.Contains(namedQueryUsage(), "\n  "+name+" ") {
+			t.Errorf("%s missing from usage %q", name, namedQueryUsage())
+		}
+	}
+	if query := fmt.Sprintf(namedQueries["impact"].Cypher, "App", 4); !strings.Contains(query, "*1..4]") {
+		t.Errorf("impact depth not applied:\n%s", query)
+	}
+}
+
+func TestRunNamedQuery(t *testing.T) {
+	ctx := context.Background()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+
+	tests := []struct {
+		arg     string
+		backend string
+		name    string
+		err     string
+	}{
+		{"callers", "falkordb", "", "query callers needs --name"},
+		{"callers", "memory", "Parse", "query callers needs --backend neo4j or falkordb"},
+		{"unused-exports", "sqlite", "", "query unused-exports needs --backend neo4j or falkordb"},
+	}
+	for _, tt := range tests {
+		err := runQuery(ctx, Config{Backend: tt.backend, Project: "App", Name: tt.name}, tt.arg)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("runQuery(%s, %s) error = %v, want %s", tt.arg, tt.backend, err, tt.err)
+		}
+	}
+
+	fake := startFakeFalkor(t)
+	cfg := Config{Backend: "falkordb", FalkorAddr: fake.addr, FalkorGraph: "code", Project: "App", Name: "Parse", Depth: 2, Format: "table"}
+	if err := runQuery(ctx, cfg, "callers"); err != nil {
+		t.Fatal(err)
+	}
+	close(fake.commands)
+	var sent []string
+	for args := range fake.commands {
+		if args[0] == "GRAPH.QUERY" {
+			sent = append(sent, args[2])
+		}
+	}
+	if len(sent) != 1 || !strings.HasPrefix(sent[0], "CYPHER name='Parse' MATCH (caller:App)-[r:CALLS]->(fn:App)") {
+		t.Errorf("callers sent %q", sent)
+	}
+}
+
+// recordingQuerier records the queries run against it, and answers each
+// with the columns and rows of the first of its answers whose parts the
+// query contains, or with no rows. Its writes are recorded too.
+type recordingQuerier struct {
+	incrementalBackend
+	queries []string
+	params  []map[string]any
+	answers []answer
+}
+
+// answer is the result returned for the queries containing every one of parts
+type answer struct {
+	parts   []string
+	columns []string
+	rows    [][]any
+}
+
+// answering makes q return columns and rows for the queries containing every
+// one of parts, or for every query without parts
+func (q *recordingQuerier) answering(columns []string, rows [][]any, parts ...string) *recordingQuerier {
+	q.answers = append(q.answers, answer{parts: parts, columns: columns, rows: rows})
+	return q
+}
+
+func (q *recordingQuerier) Query(ctx context.Context, query string, params map[string]any) ([]string, [][]any, error) {
+	q.queries = append(q.queries, query)
+	q.params = append(q.params, params)
+	for _, a := range q.answers {
+		matched := true
+		for _, part := range a.parts {
+			matched = matched && strings.Contains(query, part)
+		}
+		if matched {
+			return a.columns, a.rows, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// newTestServer serves projects App, at root, and Other from q
+func newTestServer(ctx context.Context, q *recordingQuerier, root string) *server {
+	s := &server{
+		ctx:      ctx,
+		backend:  q,
+		querier:  q,
+		projects: []string{"App", "Other"},
+		targets:  make(map[string]Config),
+		indexes:  make(map[string]*indexStatus),
+	}
+	for _, project := range s.projects {
+		s.targets[project] = Config{Project: project, Path: root, Depth: 3}
+		s.indexes[project] = &indexStatus{}
+	}
+	s.metrics = newServerMetrics(s.projects)
+	return s
+}
+
+// jsonRequest is a request to the API with body sent as JSON
+func jsonRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestServerGuards(t *testing.T) {
+	q := &recordingQuerier{}
+	memories := &fakeMemories{}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeMemories
+	}{q, memories}
+	handler := s.routes()
+
+	tests := []struct {
+		method, target, origin, contentType string
+		status                              int
+	}{
+		{"GET", "/healthz", "", "", 200},
+		{"GET", "/healthz", "http://localhost:3000", "", 200},
+		{"GET", "/healthz", "http://127.0.0.1:8080", "", 200},
+		{"GET", "/healthz", "https://evil.example.com", "", 403},
+		{"POST", "/memories", "https://evil.example.com", "application/json", 403},
+		{"POST", "/memories", "", "text/plain", 415},
+		{"POST", "/memories", "", "", 415},
+		{"POST", "/reindex", "", "application/x-www-form-urlencoded", 415},
+		{"DELETE", "/memories/m1", "", "text/plain", 415},
+		{"POST", "/memories", "", "application/json; charset=utf-8", 201},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"text":"slow","about":["File:main.go"]}`))
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s from %q as %q status = %d, want %d", tt.method, tt.target, tt.origin, tt.contentType, rec.Code, tt.status)
+		}
+	}
+	if len(memories.memories) != 1 {
+		t.Errorf("stored %d memories, want only the JSON one", len(memories.memories))
+	}
+}
+
+func TestKeyMatch(t *testing.T) {
+	cfg := Config{Project: "App", Labels: codegraph.LabelMap{Rename: map[string]string{"Struct": "Class"}}}
+	tests := []struct {
+		key    string
+		match  string
+		params map[string]any
+	}{
+		{"Package:store", "MATCH (n:App:Package) WHERE n.path = $path", map[string]any{"path": "store"}},
+		{"File:store/store.go", "MATCH (n:App:File) WHERE n.path = $path", map[string]any{"path": "store/store.go"}},
+		{"Function:main.go:main", "MATCH (n:App:Function) WHERE n.file = $file AND n.name = $name AND n.receiver = $receiver",
+			map[string]any{"file": "main.go", "name": "main", "receiver": ""}},
+		{"Function:store/store.go:*Store.Put", "MATCH (n:App:Method) WHERE n.file = $file AND n.name = $name AND n.receiver = $receiver",
+			map[string]any{"file": "store/store.go", "name": "Put", "receiver": "*Store"}},
+		{"Struct:store/store.go:Store", "MATCH (n:App:Class) WHERE n.file = $file AND n.name = $name",
+			map[string]any{"file": "store/store.go", "name": "Store"}},
+		{"Interface:a.go:Reader", "MATCH (n:App:Interface) WHERE n.file = $file AND n.name = $name",
+			map[string]any{"file": "a.go", "name": "Reader"}},
+		{"Struct:Store", "", nil},
+		{"Run:run-1", "", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		match, params, err := keyMatch(cfg, tt.key)
+		if tt.match == "" {
+			if err == nil {
+				t.Errorf("keyMatch(%q) accepted", tt.key)
+			}
+			continue
+		}
+		if err != nil || match != tt.match || fmt.Sprint(params) != fmt.Sprint(tt.params) {
+			t.Errorf("keyMatch(%q) = %q, %v, %v, want %q, %v", tt.key, match, params, err, tt.match, tt.params)
+		}
+	}
+}
+
+func TestResultMap(t *testing.T) {
+	tests := []struct {
+		value any
+		want  map[string]any
+	}{
+		{map[string]any{"name": "Put"}, map[string]any{"name": "Put"}},
+		{[]any{"name", "Put", "line", int64(3)}, map[string]any{"name": "Put", "line": int64(3)}},
+		{[]any{"name", "Put", "dangling"}, map[string]any{"name": "Put"}},
+		{nil, map[string]any{}},
+	}
+	for _, tt := range tests {
+		if got := resultMap(tt.value); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("resultMap(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestQueryInt(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+		err   bool
+	}{
+		{"", 50, false},
+		{"limit=7", 7, false},
+		{"limit=0", 0, true},
+		{"limit=-1", 0, true},
+		{"limit=many", 0, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := queryInt(q, "limit", 50)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("queryInt(%q) = %d, %v, want %d, error %v", tt.query, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	putProps := map[string]any{"name": "Put", "file": "store/store.go", "receiver": "*Store"}
+	q := (&recordingQuerier{}).
+		answering([]string{"labels", "props"}, [][]any{{[]any{"App", "Method"}, putProps}}, "toLower(n.name) CONTAINS").
+		answering([]string{"labels", "props"}, nil, "n.name = $name", "LIMIT 1", "RETURN labels(n)").
+		answering([]string{"type", "outgoing", "labels", "props"},
+			[][]any{{"CALLS", false, []any{"App", "Function"}, map[string]any{"name": "main", "file": "main.go", "receiver": ""}}},
+			"type(r) AS type").
+		answering([]string{"labels", "props"}, [][]any{{[]any{"App", "File"}, []any{"path", "main.go"}}}, "n.path = $path").
+		answering([]string{"caller", "line"}, [][]any{{"main", int64(7)}}, "caller.name AS caller")
+	s := newTestServer(context.Background(), q, t.TempDir())
+	handler := s.routes()
+
+	tests := []struct {
+		method, target string
+		status         int
+		body           string
+	}{
+		{"GET", "/healthz", 200, `{"status":"ok"}`},
+		{"GET", "/symbols?q=put", 200,
+			`[{"key":"Function:store/store.go:*Store.Put","label":"Method","properties":{"file":"store/store.go","name":"Put","receiver":"*Store"}}]`},
+		{"GET", "/symbols", 400, `{"error":"missing q"}`},
+		{"GET", "/symbols?q=put&kind=Run", 400, `{"error":"unknown kind \"Run\""}`},
+		{"GET", "/symbols?q=put&limit=x", 400, `{"error":"invalid limit \"x\""}`},
+		{"GET", "/symbols?q=put&project=Nope", 404, `{"error":"unknown project \"Nope\""}`},
+		{"GET", "/node?key=File:main.go", 200, `{"key":"File:main.go","label":"File","properties":{"path":"main.go"}}`},
+		{"GET", "/node?key=Function:main.go:gone", 404, `{"error":"no such node"}`},
+		{"GET", "/node?key=bad", 400, `{"error":"invalid key \"bad\""}`},
+		{"GET", "/neighbors?key=Function:store/store.go:*Store.Put&direction=in", 200,
+			`[{"type":"CALLS","direction":"in","node":{"key":"Function:main.go:main","label":"Function","properties":{"file":"main.go","name":"main","receiver":""}}}]`},
+		{"GET", "/neighbors?key=File:main.go&direction=up", 400, `{"error":"unknown direction \"up\", expected in, out or both"}`},
+		{"GET", "/queries/callers?name=Put", 200, `[{"caller":"main","line":7}]`},
+		{"GET", "/queries/callers", 400, `{"error":"missing name"}`},
+		{"GET", "/queries/callers?name=Put&depth=0", 400, `{"error":"invalid depth \"0\""}`},
+		{"GET", "/queries/siblings", 404, `{"error":"unknown query \"siblings\""}`},
+		{"GET", "/reindex?project=Other", 200, `{"running":false}`},
+		{"DELETE", "/reindex", 405, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("%s %s =\n%s\nwant\n%s", tt.method, tt.target, got, tt.body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/queries", nil))
+	var queries []struct{ Name string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &queries); err != nil || len(queries) != len(namedQueries) {
+		t.Errorf("GET /queries = %s, %v", rec.Body, err)
+	}
+
+	// The neighbors query asks for the Method with the key's receiver, and
+	// the named query gets its name from the request
+	for i, query := range q.queries {
+		if strings.Contains(query, "type(r) AS type") && q.params[i]["receiver"] != "*Store" {
+			t.Errorf("neighbors params = %v", q.params[i])
+		}
+		if strings.Contains(query, "caller.name AS caller") && q.params[i]["name"] != "Put" {
+			t.Errorf("callers params = %v", q.params[i])
+		}
+	}
+}
+
+// fakeMemories is a codegraph.MemoryStore holding memories in a list, in
+// which only File:main.go exists to be remembered about
+type fakeMemories struct {
+	memories []codegraph.Memory
+	queries  []codegraph.MemoryQuery
+}
+
+func (m *fakeMemories) Remember(ctx context.Context, project string, memory codegraph.Memory) (codegraph.Memory, error) {
+	if memory.Text == "" || len(memory.About) == 0 {
+		return codegraph.Memory{}, fmt.Errorf("%w: no text or key", codegraph.ErrInvalidMemory)
+	}
+	for _, key := range memory.About {
+		if key != "File:main.go" && key != "Function:main.go:run" {
+			return codegraph.Memory{}, fmt.Errorf("%w %q", codegraph.ErrNoNode, key)
+		}
+	}
+	memory.ID = fmt.Sprintf("mem-%d", len(m.memories)+1)
+	memory.CreatedAt = time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	m.memories = append(m.memories, memory)
+	return memory, nil
+}
+
+func (m *fakeMemories) Recall(ctx context.Context, project string, q codegraph.MemoryQuery) ([]codegraph.Memory, error) {
+	m.queries = append(m.queries, q)
+	return slices.Clone(m.memories), nil
+}
+
+func (m *fakeMemories) Forget(ctx context.Context, project, id string) (bool, error) {
+	n := len(m.memories)
+	m.memories = slices.DeleteFunc(m.memories, func(memory codegraph.Memory) bool { return memory.ID == id })
+	return len(m.memories) < n, nil
+}
+
+func (m *fakeMemories) PinMemory(ctx context.Context, project, id string, pinned bool) (bool, error) {
+	for i := range m.memories {
+		if m.memories[i].ID == id {
+			m.memories[i].Pinned = pinned
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *fakeMemories) ArchiveMemories(ctx context.Context, project string, ids []string) (int, error) {
+	n := 0
+	for i := range m.memories {
+		if slices.Contains(ids, m.memories[i].ID) {
+			m.memories[i].Archived = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestServerMemories(t *testing.T) {
+	q := &recordingQuerier{}
+	memories := &fakeMemories{}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeMemories
+	}{q, memories}
+	handler := s.routes()
+
+	tests := []struct {
+		method, target, request string
+		status                  int
+		body                    string
+	}{
+		{"POST", "/memories", `{"text": "main exits 2 on bad flags", "tags": ["cli"], "about": ["File:main.go"], "via": "mcp", "agent": "curl"}`, 201,
+			`{"id":"mem-1","text":"main exits 2 on bad flags","tags":["cli"],"about":["File:main.go"],"createdAt":"2026-03-04T05:06:07Z","via":"http","agent":"curl"}`},
+		{"POST", "/memories", `{"text": "", "about": ["File:main.go"]}`, 400, `{"error":"invalid memory: no text or key"}`},
+		{"POST", "/memories", `{"text": "gone", "about": ["File:gone.go"]}`, 404, `{"error":"no such node \"File:gone.go\""}`},
+		{"POST", "/memories", `{"text": `, 400, ""},
+		{"GET", "/memories?about=File:main.go&tag=cli&limit=5", "", 200,
+			`[{"id":"mem-1","text":"main exits 2 on bad flags","tags":["cli"],"about":["File:main.go"],"createdAt":"2026-03-04T05:06:07Z","via":"http","agent":"curl"}]`},
+		{"GET", "/memories?limit=0", "", 400, `{"error":"invalid limit \"0\""}`},
+		{"GET", "/memories?rank=true", "", 200, ""},
+		{"GET", "/memories?file=main.go&q=flags", "", 200, ""},
+		{"GET", "/memories?rank=maybe", "", 400, `{"error":"invalid rank \"maybe\""}`},
+		{"GET", "/memories?via=http&agent=curl&trust=model=claude-haiku:0.5", "", 200, ""},
+		{"GET", "/memories?trust=via=http", "", 400, ""},
+		{"PUT", "/memories/mem-1/pin", "", 204, ""},
+		{"DELETE", "/memories/mem-2/pin", "", 404, `{"error":"no such memory"}`},
+		{"GET", "/memories", "", 200,
+			`[{"id":"mem-1","text":"main exits 2 on bad flags","tags":["cli"],"about":["File:main.go"],"createdAt":"2026-03-04T05:06:07Z","pinned":true,"via":"http","agent":"curl"}]`},
+		{"DELETE", "/memories/mem-2", "", 404, `{"error":"no such memory"}`},
+		{"DELETE", "/memories/mem-1", "", 204, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, jsonRequest(tt.method, tt.target, tt.request))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("%s %s =\n%s\nwant\n%s", tt.method, tt.target, got, tt.body)
+		}
+	}
+	none := []string{}
+	trusted := codegraph.DefaultRanking
+	trusted.Trust = map[string]float64{"model=claude-haiku": 0.5}
+	want := []codegraph.MemoryQuery{
+		{About: "File:main.go", Tags: []string{"cli"}, Namespaces: none, Limit: 5}, {Namespaces: none, Limit: 100, Ranking: &codegraph.DefaultRanking},
+		{File: "main.go", Question: "flags", Namespaces: none, Limit: 100},
+		{Namespaces: none, Limit: 100, Provenance: codegraph.Provenance{Via: codegraph.ViaHTTP, Agent: "curl"}, Ranking: &trusted},
+		{Namespaces: none, Limit: 100},
+	}
+	if !reflect.DeepEqual(memories.queries, want) {
+		t.Errorf("recalled %+v, want %+v", memories.queries, want)
+	}
+	if len(memories.memories) != 0 {
+		t.Errorf("memories left: %+v", memories.memories)
+	}
+
+	// A backend that cannot keep memories
+	rec := httptest.NewRecorder()
+	newTestServer(context.Background(), q, t.TempDir()).routes().ServeHTTP(rec, httptest.NewRequest("GET", "/memories", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("GET /memories without a memory store = %d", rec.Code)
+	}
+}
+
+// fakeLinks is a codegraph.CrossLinker over fakeMemories, which only has
+// File:main.go to link from
+type fakeLinks struct {
+	*fakeMemories
+	links []codegraph.CrossLink
+}
+
+func (l *fakeLinks) LinkProjects(ctx context.Context, link codegraph.CrossLink) (codegraph.CrossLink, error) {
+	if link.FromProject == link.ToProject {
+		return codegraph.CrossLink{}, fmt.Errorf("%w: %s links to itself", codegraph.ErrInvalidLink, link.FromProject)
+	}
+	if link.From != "File:main.go" {
+		return codegraph.CrossLink{}, fmt.Errorf("%w %q", codegraph.ErrNoNode, link.From)
+	}
+	if link.Type == "" {
+		link.Type = codegraph.CrossLinkTypes[0]
+	}
+	link.ID = fmt.Sprintf("lnk-%d", len(l.links)+1)
+	l.links = append(l.links, link)
+	return link, nil
+}
+
+func (l *fakeLinks) LinkImports(ctx context.Context, project, library, module string) ([]codegraph.CrossLink, error) {
+	if module == "" {
+		return nil, fmt.Errorf("%w: no module", codegraph.ErrInvalidLink)
+	}
+	link, err := l.LinkProjects(ctx, codegraph.CrossLink{Type: "IMPORTS", FromProject: project, From: "File:main.go", ToProject: library, To: "Package:log"})
+	if err != nil {
+		return nil, err
+	}
+	return []codegraph.CrossLink{link}, nil
+}
+
+func (l *fakeLinks) CrossLinks(ctx context.Context, project string) ([]codegraph.CrossLink, error) {
+	return l.links, nil
+}
+
+func (l *fakeLinks) Unlink(ctx context.Context, project, id string) (bool, error) {
+	n := len(l.links)
+	l.links = slices.DeleteFunc(l.links, func(link codegraph.CrossLink) bool { return link.ID == id })
+	return len(l.links) < n, nil
+}
+
+func TestChangesTime(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+	started := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	f := &fakeSessions{sessions: []codegraph.Session{
+		{ID: "ses-2", StartedAt: started.Add(2 * time.Hour)},
+		{ID: "ses-1", StartedAt: started, EndedAt: started.Add(time.Hour)},
+	}}
+	cfg := Config{Project: "App", Backend: "sqlite"}
+	tests := []struct {
+		since, session string
+		want           time.Time
+	}{
+		{"2026-03-04T05:06:07Z", "", started},
+		{"2026-03-04", "", time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"24h", "", now.Add(-24 * time.Hour)},
+		{"", "ses-1", started.Add(time.Hour)},
+		{"", "ses-2", started.Add(2 * time.Hour)},
+		{"", "", started.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		got, err := changesTime(ctx, cfg, f, tt.since, tt.session, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("changesTime(%q, %q) = %v, %v, want %v", tt.since, tt.session, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		backend        any
+		since, session string
+	}{
+		{f, "soon", ""},
+		{f, "-1h", ""},
+		{f, "24h", "ses-1"},
+		{&fakeMemories{}, "", "ses-1"},
+		{&fakeSessions{sessions: f.sessions[:1]}, "", ""},
+	} {
+		if _, err := changesTime(ctx, cfg, tt.backend, tt.since, tt.session, now); exitCode(err) != exitUsage {
+			t.Errorf("changesTime(%q, %q) err = %v, want a usage error", tt.since, tt.session, err)
+		}
+	}
+	if _, err := changesTime(ctx, cfg, f, "", "ses-9", now); !errors.Is(err, codegraph.ErrNoSession) {
+		t.Errorf("missing session: err = %v, want ErrNoSession", err)
+	}
+}
+
+func TestRunChanges(t *testing.T) {
+	m := &fakeMemories{memories: []codegraph.Memory{{ID: "mem-1", Text: "flush is slow", About: []string{"File:main.go"}, CreatedAt: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)}}}
+	var buf bytes.Buffer
+	// --path is no git repository, so only the memories are read
+	cfg := Config{Project: "App", Path: t.TempDir(), Since: "2026-03-04", Namespace: "team"}
+	if err := runChanges(context.Background(), cfg, m, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "since 2026-03-04 00:00:00: 0 nodes changed, 1 memories, 0 decisions\n\n" +
+		"mem-1  2026-03-04 05:06:07\n" +
+		"  about File:main.go\n" +
+		"  flush is slow\n"
+	if got := buf.String(); got != want {
+		t.Errorf("runChanges =\n%s\nwant\n%s", got, want)
+	}
+	if q := m.queries[0]; !q.Since.Equal(time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)) || !q.Peek || !slices.Equal(q.Namespaces, []string{"team"}) {
+		t.Errorf("recalled with %+v", q)
+	}
+}
+
+func TestRunLink(t *testing.T) {
+	l := &fakeLinks{fakeMemories: &fakeMemories{memories: []codegraph.Memory{
+		{ID: "mem-1", Text: "main logs through lib", About: []string{"File:main.go"}},
+		{ID: "mem-2", Text: "log writes to stderr", About: []string{"Function:log/log.go:Printf"}},
+	}}}
+	var buf bytes.Buffer
+	cfg := Config{Project: "Api", LinkFrom: "File:main.go", ToProject: "Lib", LinkTo: "Package:store", LinkType: "DEPENDS_ON"}
+	if err := runLink(context.Background(), cfg, l, []string{"add"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "lnk-1\n" || l.links[0].FromProject != "Api" || l.links[0].To != "Package:store" {
+		t.Errorf("add printed %q and stored %+v", buf.String(), l.links)
+	}
+	buf.Reset()
+	cfg.Module = "example.com/lib"
+	if err := runLink(context.Background(), cfg, l, []string{"imports"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "lnk-2  Api:File:main.go -[IMPORTS]-> Lib:Package:log\n"; buf.String() != want {
+		t.Errorf("imports printed %q, want %q", buf.String(), want)
+	}
+	if err := runLink(context.Background(), cfg, l, []string{"remove", "lnk-1"}, &buf); err != nil || len(l.links) != 1 {
+		t.Errorf("remove: %v, links %+v", err, l.links)
+	}
+	if err := runLink(context.Background(), cfg, l, []string{"remove", "lnk-1"}, &buf); err == nil || exitCode(err) != exitFailure {
+		t.Errorf("unknown link: err = %v, want a failure", err)
+	}
+
+	// Recall adds Lib's memories about what main.go imports
+	buf.Reset()
+	if err := runRecall(context.Background(), Config{Project: "Api", About: []string{"File:main.go"}, Linked: true}, l, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "mem-2  0001-01-01 00:00:00  from Lib\n") || len(l.queries) != 2 || !l.queries[1].Peek {
+		t.Errorf("recall printed %q after %+v", buf.String(), l.queries)
+	}
+	buf.Reset()
+	if err := runRecall(context.Background(), Config{Project: "Api", About: []string{"File:main.go"}}, l, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "from Lib") || len(l.queries) != 3 {
+		t.Errorf("--linked=false printed %q", buf.String())
+	}
+
+	for _, tt := range []struct {
+		cfg  Config
+		args []string
+	}{
+		{Config{Project: "Api", ToProject: "Lib", LinkTo: "Package:log"}, []string{"add"}},
+		{Config{Project: "Api", LinkFrom: "File:gone.go", ToProject: "Lib", LinkTo: "Package:log"}, []string{"add"}},
+		{Config{Project: "Api", LinkFrom: "File:main.go", ToProject: "Api", LinkTo: "Package:log"}, []string{"add"}},
+		{Config{Project: "Api", ToProject: "Lib"}, []string{"imports"}},
+		{cfg, []string{"list", "x"}},
+		{cfg, []string{"remove"}},
+		{cfg, []string{"show"}},
+	} {
+		if err := runLink(context.Background(), tt.cfg, l, tt.args, &buf); exitCode(err) != exitUsage {
+			t.Errorf("%q with %+v: err = %v, want a usage error", tt.args, tt.cfg, err)
+		}
+	}
+}
+
+func TestServerLinks(t *testing.T) {
+	q := &recordingQuerier{}
+	links := &fakeLinks{fakeMemories: &fakeMemories{memories: []codegraph.Memory{
+		{ID: "mem-1", Text: "log writes to stderr", About: []string{"Package:log"}},
+	}}}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeLinks
+	}{q, links}
+	handler := s.routes()
+
+	tests := []struct {
+		method, target, request string
+		status                  int
+		body                    string
+	}{
+		{"POST", "/links", `{"type": "CALLS", "from": "File:main.go", "toProject": "Lib", "to": "Function:log/log.go:Printf"}`, 201,
+			`{"id":"lnk-1","type":"CALLS","fromProject":"App","from":"File:main.go","toProject":"Lib","to":"Function:log/log.go:Printf","createdAt":"0001-01-01T00:00:00Z"}`},
+		{"POST", "/links", `{"from": "File:gone.go", "toProject": "Lib", "to": "Package:log"}`, 404, `{"error":"no such node \"File:gone.go\""}`},
+		{"POST", "/links", `{"from": "File:main.go", "toProject": "App", "to": "Package:log"}`, 400, `{"error":"invalid link: App links to itself"}`},
+		{"POST", "/links", `{"toProject": "Lib", "module": "example.com/lib"}`, 201,
+			`[{"id":"lnk-2","type":"IMPORTS","fromProject":"App","from":"File:main.go","toProject":"Lib","to":"Package:log","createdAt":"0001-01-01T00:00:00Z"}]`},
+		{"GET", "/memories?linked=false", "", 200,
+			`[{"id":"mem-1","text":"log writes to stderr","about":["Package:log"],"createdAt":"0001-01-01T00:00:00Z"}]`},
+		{"GET", "/memories?linked=maybe", "", 400, `{"error":"invalid linked \"maybe\""}`},
+		{"DELETE", "/links/lnk-1", "", 204, ""},
+		{"DELETE", "/links/lnk-1", "", 404, `{"error":"no such link"}`},
+		{"GET", "/links", "", 200,
+			`[{"id":"lnk-2","type":"IMPORTS","fromProject":"App","from":"File:main.go","toProject":"Lib","to":"Package:log","createdAt":"0001-01-01T00:00:00Z"}]`},
+		{"GET", "/memories", "", 200,
+			`[{"id":"mem-1","text":"log writes to stderr","about":["Package:log"],"createdAt":"0001-01-01T00:00:00Z"},` +
+				`{"id":"mem-1","text":"log writes to stderr","about":["Package:log"],"createdAt":"0001-01-01T00:00:00Z","project":"Lib"}]`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, jsonRequest(tt.method, tt.target, tt.request))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("%s %s =\n%s\nwant\n%s", tt.method, tt.target, got, tt.body)
+		}
+	}
+
+	// A backend that cannot link projects
+	rec := httptest.NewRecorder()
+	newTestServer(context.Background(), q, t.TempDir()).routes().ServeHTTP(rec, httptest.NewRequest("GET", "/links", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("GET /links without a linker = %d", rec.Code)
+	}
+}
+
+func TestServerChanges(t *testing.T) {
+	q := &recordingQuerier{}
+	started := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeSessions
+	}{q, &fakeSessions{sessions: []codegraph.Session{{ID: "ses-1", StartedAt: started, EndedAt: started.Add(time.Hour)}}}}
+	handler := s.routes()
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/changes?since=2026-03-04", 200, `{"since":"2026-03-04T00:00:00Z","nodes":[],"memories":[],"decisions":[]}`},
+		{"/changes", 200, `{"since":"2026-03-04T06:06:07Z","nodes":[],"memories":[],"decisions":[]}`},
+		{"/changes?since=soon", 400, ""},
+		{"/changes?session=ses-9", 404, `{"error":"no such session \"ses-9\""}`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("GET %s =\n%s\nwant\n%s", tt.target, got, tt.body)
+		}
+	}
+}
+
+func TestMCPMemoryTools(t *testing.T) {
+	runProps := map[string]any{"name": "run", "file": "main.go", "receiver": ""}
+	putProps := map[string]any{"name": "Put", "file": "store/store.go", "receiver": "*Store"}
+	cachePutProps := map[string]any{"name": "Put", "file": "cache/cache.go", "receiver": "*Cache"}
+	q := (&recordingQuerier{}).answering([]string{"labels", "props"}, [][]any{
+		{[]any{"App", "Function"}, runProps},
+		{[]any{"App", "Function"}, map[string]any{"name": "runner", "file": "main.go", "receiver": ""}},
+		{[]any{"App", "Method"}, putProps},
+		{[]any{"App", "Method"}, cachePutProps},
+	}, "toLower(n.name) CONTAINS")
+	memories := &fakeMemories{}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeMemories
+	}{q, memories}
+	s.mcpClient = "claude-code"
+	call := func(tool string, args map[string]any) (any, error) {
+		return mcpTools[tool].Call(context.Background(), s, args)
+	}
+
+	// A symbol is resolved to its key
+	got, err := call("remember", map[string]any{"text": "run retries forever", "symbol": "run", "about": []any{"File:main.go"}, "model": "claude-sonnet-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory := got.(codegraph.Memory); !slices.Equal(memory.About, []string{"File:main.go", "Function:main.go:run"}) ||
+		memory.Provenance != (codegraph.Provenance{Via: codegraph.ViaMCP, Agent: "claude-code", Model: "claude-sonnet-4"}) {
+		t.Errorf("remembered %+v", memory)
+	}
+	for _, tt := range []struct {
+		args map[string]any
+		err  string
+	}{
+		{map[string]any{"text": "x"}, "give the keys the note is about, or a symbol"},
+		{map[string]any{"text": "x", "symbol": "stop"}, `no function, method, struct or interface named "stop"`},
+		{map[string]any{"text": "x", "symbol": "Put"}, `"Put" names 2 symbols, pass one of their keys instead: Function:store/store.go:*Store.Put, Function:cache/cache.go:*Cache.Put`},
+	} {
+		if _, err := call("remember", tt.args); err == nil || err.Error() != tt.err {
+			t.Errorf("remember %v: err = %v, want %s", tt.args, err, tt.err)
+		}
+	}
+
+	if _, err := call("recall", map[string]any{"symbol": "Cache.Put", "file": "main.go", "question": "why retry?"}); err != nil {
+		t.Fatal(err)
+	}
+	want := codegraph.MemoryQuery{
+		About: "Function:cache/cache.go:*Cache.Put", File: "main.go", Question: "why retry?", Limit: 20,
+		Tags: []string{}, ExcludeTags: []string{}, Namespaces: []string{},
+	}
+	if len(memories.queries) != 1 || !reflect.DeepEqual(memories.queries[0], want) {
+		t.Errorf("recalled %+v, want %+v", memories.queries, want)
+	}
+	if _, err := call("recall", map[string]any{"symbol": "run", "about": "File:main.go"}); err == nil {
+		t.Error("recalled by both about and symbol")
+	}
+
+	// A correction replaces the memory, archiving it as the source
+	got, err = call("forget", map[string]any{"id": "mem-1", "correction": "run gives up after 3 retries"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if correction := got.(codegraph.Memory); correction.ID != "mem-2" || !slices.Equal(correction.Sources, []string{"mem-1"}) || correction.Via != codegraph.ViaMCP ||
+		!slices.Equal(correction.About, []string{"File:main.go", "Function:main.go:run"}) || !memories.memories[0].Archived {
+		t.Errorf("corrected into %+v, leaving %+v", correction, memories.memories)
+	}
+	if _, err := call("forget", map[string]any{"id": "mem-9", "correction": "x"}); !errors.Is(err, codegraph.ErrNoMemory) {
+		t.Errorf("correcting mem-9: err = %v", err)
+	}
+	if _, err := call("forget", map[string]any{"id": "mem-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(memories.memories) != 1 || memories.memories[0].ID != "mem-2" {
+		t.Errorf("memories left: %+v", memories.memories)
+	}
+}
+
+// fakeSessions is a codegraph.SessionStore holding sessions in a list
+type fakeSessions struct {
+	sessions  []codegraph.Session
+	memories  []codegraph.Memory
+	summaries []codegraph.Summary
+	queries   []codegraph.SummaryQuery
+}
+
+func (f *fakeSessions) StartSession(ctx context.Context, project string, session codegraph.Session) (codegraph.Session, error) {
+	if i := slices.IndexFunc(f.sessions, func(s codegraph.Session) bool {
+		return session.AgentSession != "" && s.AgentSession == session.AgentSession
+	}); i >= 0 {
+		return f.sessions[i], nil
+	}
+	session.ID = fmt.Sprintf("ses-%d", len(f.sessions)+1)
+	session.StartedAt = time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	f.sessions = append(f.sessions, session)
+	return session, nil
+}
+
+func (f *fakeSessions) session(id string) (*codegraph.Session, error) {
+	i := slices.IndexFunc(f.sessions, func(s codegraph.Session) bool { return s.ID == id })
+	if i < 0 {
+		return nil, fmt.Errorf("%w %q", codegraph.ErrNoSession, id)
+	}
+	return &f.sessions[i], nil
+}
+
+func (f *fakeSessions) EndSession(ctx context.Context, project, id string) (codegraph.Session, error) {
+	session, err := f.session(id)
+	if err != nil {
+		return codegraph.Session{}, err
+	}
+	session.EndedAt = session.StartedAt.Add(time.Hour)
+	return *session, nil
+}
+
+func (f *fakeSessions) TouchFiles(ctx context.Context, project, id string, paths []string) error {
+	session, err := f.session(id)
+	if err != nil {
+		return err
+	}
+	session.Touched = append(session.Touched, paths...)
+	return nil
+}
+
+func (f *fakeSessions) Sessions(ctx context.Context, project string, limit int) ([]codegraph.Session, error) {
+	return f.sessions, nil
+}
+
+// Replay returns every memory shared or in namespaces, of any session
+func (f *fakeSessions) Replay(ctx context.Context, project, id string, namespaces []string) (codegraph.Session, []codegraph.Memory, error) {
+	session, err := f.session(id)
+	if err != nil {
+		return codegraph.Session{}, nil, err
+	}
+	var memories []codegraph.Memory
+	for _, m := range f.memories {
+		if namespaces == nil || m.Namespace == "" || slices.Contains(namespaces, m.Namespace) {
+			memories = append(memories, m)
+		}
+	}
+	return *session, memories, nil
+}
+
+// Summarize stores a summary about the files its session touched, unless
+// it names the nodes, all in package .
+func (f *fakeSessions) Summarize(ctx context.Context, project string, summary codegraph.Summary) (codegraph.Summary, error) {
+	session, err := f.session(summary.Session)
+	if err != nil {
+		return codegraph.Summary{}, err
+	}
+	if summary.Text == "" {
+		return codegraph.Summary{}, fmt.Errorf("%w: no text or session", codegraph.ErrInvalidSummary)
+	}
+	if len(summary.About) == 0 {
+		for _, file := range session.Touched {
+			summary.About = append(summary.About, "File:"+file)
+		}
+	}
+	summary.ID = fmt.Sprintf("sum-%d", len(f.summaries)+1)
+	summary.CreatedAt = time.Date(2026, 3, 4, 6, 0, 0, 0, time.UTC)
+	summary.Packages = []string{"."}
+	f.summaries = append(f.summaries, summary)
+	return summary, nil
+}
+
+func (f *fakeSessions) Summaries(ctx context.Context, project string, q codegraph.SummaryQuery) ([]codegraph.Summary, error) {
+	f.queries = append(f.queries, q)
+	summaries := []codegraph.Summary{}
+	for _, summary := range slices.Backward(f.summaries) {
+		if (q.Session == "" || summary.Session == q.Session) && (q.Package == "" || slices.Contains(summary.Packages, q.Package)) {
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries, nil
+}
+
+func TestServerSessions(t *testing.T) {
+	q := &recordingQuerier{}
+	sessions := &fakeSessions{memories: []codegraph.Memory{
+		{ID: "mem-1", Text: "x", About: []string{"File:main.go"}, Session: "ses-1"},
+		{ID: "mem-2", Text: "y", About: []string{"File:main.go"}, Session: "ses-1", Namespace: "user:bob"},
+	}}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeSessions
+	}{q, sessions}
+	handler := s.routes()
+
+	tests := []struct {
+		method, target, request string
+		status                  int
+		body                    string
+	}{
+		{"POST", "/sessions", `{"dir": "/src/app", "branch": "main"}`, 201,
+			`{"id":"ses-1","startedAt":"2026-03-04T05:06:07Z","dir":"/src/app","branch":"main","memories":0}`},
+		{"POST", "/sessions/ses-1/touch", `{"files": ["main.go"]}`, 204, ""},
+		{"POST", "/sessions/ses-1/touch", `{"files": []}`, 400, `{"error":"missing files"}`},
+		{"POST", "/sessions/ses-2/touch", `{"files": ["main.go"]}`, 404, `{"error":"no such session \"ses-2\""}`},
+		{"POST", "/sessions/ses-1/end", "", 200,
+			`{"id":"ses-1","startedAt":"2026-03-04T05:06:07Z","endedAt":"2026-03-04T06:06:07Z","dir":"/src/app","branch":"main","touched":["main.go"],"memories":0}`},
+		{"POST", "/sessions/ses-1/summary", `{"text": "Made main exit 2 on bad flags"}`, 201,
+			`{"id":"sum-1","session":"ses-1","text":"Made main exit 2 on bad flags","about":["File:main.go"],"packages":["."],"createdAt":"2026-03-04T06:00:00Z"}`},
+		{"POST", "/sessions/ses-1/summary", `{"text": ""}`, 400, `{"error":"invalid summary: no text or session"}`},
+		{"POST", "/sessions/ses-2/summary", `{"text": "x"}`, 404, `{"error":"no such session \"ses-2\""}`},
+		{"GET", "/summaries?package=.&limit=3", "", 200,
+			`[{"id":"sum-1","session":"ses-1","text":"Made main exit 2 on bad flags","about":["File:main.go"],"packages":["."],"createdAt":"2026-03-04T06:00:00Z"}]`},
+		{"GET", "/summaries?package=store", "", 200, `[]`},
+		{"GET", "/summaries?limit=0", "", 400, `{"error":"invalid limit \"0\""}`},
+		{"GET", "/sessions/ses-1", "", 200,
+			`{"memories":[{"id":"mem-1","text":"x","about":["File:main.go"],"session":"ses-1","createdAt":"0001-01-01T00:00:00Z"}],"session":{"id":"ses-1","startedAt":"2026-03-04T05:06:07Z","endedAt":"2026-03-04T06:06:07Z","dir":"/src/app","branch":"main","touched":["main.go"],"memories":0},` +
+				`"summaries":[{"id":"sum-1","session":"ses-1","text":"Made main exit 2 on bad flags","about":["File:main.go"],"packages":["."],"createdAt":"2026-03-04T06:00:00Z"}]}`},
+		{"GET", "/sessions/ses-2", "", 404, `{"error":"no such session \"ses-2\""}`},
+		{"GET", "/sessions?limit=x", "", 400, `{"error":"invalid limit \"x\""}`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, jsonRequest(tt.method, tt.target, tt.request))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("%s %s =\n%s\nwant\n%s", tt.method, tt.target, got, tt.body)
+		}
+	}
+
+	// A namespace's memories are replayed only to those asking for it
+	for target, want := range map[string][]string{
+		"/sessions/ses-1":                    {"mem-1"},
+		"/sessions/ses-1?namespace=user:bob": {"mem-1", "mem-2"},
+		"/sessions/ses-1?namespace=user:ann": {"mem-1"},
+		"/sessions/ses-1?namespace=*":        {"mem-1", "mem-2"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		var replay struct{ Memories []codegraph.Memory }
+		if err := json.Unmarshal(rec.Body.Bytes(), &replay); err != nil {
+			t.Fatalf("GET %s = %s", target, rec.Body)
+		}
+		var got []string
+		for _, m := range replay.Memories {
+			got = append(got, m.ID)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("GET %s replayed %v, want %v", target, got, want)
+		}
+	}
+}
+
+// fakeDecisions is a codegraph.DecisionStore holding decisions in a list,
+// in which only File:main.go exists to be affected
+type fakeDecisions struct {
+	decisions []codegraph.Decision
+	queries   []codegraph.DecisionQuery
+}
+
+func (f *fakeDecisions) Decide(ctx context.Context, project string, decision codegraph.Decision) (codegraph.Decision, error) {
+	if decision.Status == "" {
+		decision.Status = "accepted"
+	}
+	if decision.Title == "" || len(decision.Affects) == 0 || !slices.Contains(codegraph.DecisionStatuses, decision.Status) {
+		return codegraph.Decision{}, fmt.Errorf("%w: no title, key or status", codegraph.ErrInvalidDecision)
+	}
+	for _, key := range decision.Affects {
+		if key != "File:main.go" {
+			return codegraph.Decision{}, fmt.Errorf("%w %q", codegraph.ErrNoNode, key)
+		}
+	}
+	decision.ID = fmt.Sprintf("dec-%d", len(f.decisions)+1)
+	decision.CreatedAt = time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	decision.UpdatedAt = decision.CreatedAt
+	f.decisions = append(f.decisions, decision)
+	return decision, nil
+}
+
+func (f *fakeDecisions) Decisions(ctx context.Context, project string, q codegraph.DecisionQuery) ([]codegraph.Decision, error) {
+	f.queries = append(f.queries, q)
+	return f.decisions, nil
+}
+
+func (f *fakeDecisions) SetDecisionStatus(ctx context.Context, project, id, status string) (codegraph.Decision, error) {
+	if !slices.Contains(codegraph.DecisionStatuses, status) {
+		return codegraph.Decision{}, fmt.Errorf("%w: unknown status %q", codegraph.ErrInvalidDecision, status)
+	}
+	i := slices.IndexFunc(f.decisions, func(d codegraph.Decision) bool { return d.ID == id })
+	if i < 0 {
+		return codegraph.Decision{}, fmt.Errorf("%w %q", codegraph.ErrNoDecision, id)
+	}
+	f.decisions[i].Status = status
+	return f.decisions[i], nil
+}
+
+func TestServerDecisions(t *testing.T) {
+	q := &recordingQuerier{}
+	decisions := &fakeDecisions{}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeDecisions
+	}{q, decisions}
+	handler := s.routes()
+
+	stored := `{"id":"dec-1","title":"One binary","rationale":"simpler installs","status":"accepted","affects":["File:main.go"],"createdAt":"2026-03-04T05:06:07Z","updatedAt":"2026-03-04T05:06:07Z"}`
+	tests := []struct {
+		method, target, request string
+		status                  int
+		body                    string
+	}{
+		{"POST", "/decisions", `{"title": "One binary", "rationale": "simpler installs", "affects": ["File:main.go"]}`, 201, stored},
+		{"POST", "/decisions", `{"title": "", "affects": ["File:main.go"]}`, 400, `{"error":"invalid decision: no title, key or status"}`},
+		{"POST", "/decisions", `{"title": "gone", "affects": ["File:gone.go"]}`, 404, `{"error":"no such node \"File:gone.go\""}`},
+		{"GET", "/decisions?about=Function:main.go:run&status=accepted", "", 200, "[" + stored + "]"},
+		{"GET", "/decisions?limit=x", "", 400, `{"error":"invalid limit \"x\""}`},
+		{"POST", "/decisions/dec-1/status", `{"status": ""}`, 400, `{"error":"missing status"}`},
+		{"POST", "/decisions/dec-1/status", `{"status": "done"}`, 400, `{"error":"invalid decision: unknown status \"done\""}`},
+		{"POST", "/decisions/dec-2/status", `{"status": "rejected"}`, 404, `{"error":"no such decision \"dec-2\""}`},
+		{"POST", "/decisions/dec-1/status", `{"status": "deprecated"}`, 200, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, jsonRequest(tt.method, tt.target, tt.request))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("%s %s =\n%s\nwant\n%s", tt.method, tt.target, got, tt.body)
+		}
+	}
+	if len(decisions.queries) != 1 || decisions.queries[0].Status != "accepted" || decisions.queries[0].Limit != 100 ||
+		!slices.Equal(decisions.queries[0].Affects, []string{"Function:main.go:run", "File:main.go", "Package:."}) {
+		t.Errorf("listed %+v, want accepted decisions affecting run, main.go or its package", decisions.queries)
+	}
+	if decisions.decisions[0].Status != "deprecated" {
+		t.Errorf("decision = %+v, want deprecated", decisions.decisions[0])
+	}
+}
+
+// fakeFindings is a codegraph.FindingStore keeping the last findings
+type fakeFindings struct {
+	analysis string
+	findings []codegraph.Finding
+}
+
+func (f *fakeFindings) ReplaceFindings(ctx context.Context, project, analysis string, findings []codegraph.Finding) error {
+	f.analysis, f.findings = analysis, findings
+	return nil
+}
+
+// fakeCycles is a codegraph.CycleStore keeping the last cycles
+type fakeCycles struct {
+	cycles []codegraph.Cycle
+}
+
+func (f *fakeCycles) ReplaceCycles(ctx context.Context, project string, cycles []codegraph.Cycle) error {
+	f.cycles = cycles
+	return nil
+}
+
+// fakeConventions is a codegraph.ConventionStore holding conventions in a
+// list, in which only the package . exists to apply to
+type fakeConventions struct {
+	conventions []codegraph.Convention
+	queries     []codegraph.ConventionQuery
+}
+
+func (f *fakeConventions) AddConvention(ctx context.Context, project string, convention codegraph.Convention) (codegraph.Convention, error) {
+	if convention.Category == "" {
+		convention.Category = codegraph.ConventionCategories[0]
+	}
+	if convention.Rule == "" || !slices.Contains(codegraph.ConventionCategories, convention.Category) {
+		return codegraph.Convention{}, fmt.Errorf("%w: no rule or category", codegraph.ErrInvalidConvention)
+	}
+	for _, pkg := range convention.Packages {
+		if pkg != "." {
+			return codegraph.Convention{}, fmt.Errorf("%w %q", codegraph.ErrNoNode, "Package:"+pkg)
+		}
+	}
+	convention.ID = fmt.Sprintf("con-%d", len(f.conventions)+1)
+	convention.CreatedAt = time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	f.conventions = append(f.conventions, convention)
+	return convention, nil
+}
+
+func (f *fakeConventions) Conventions(ctx context.Context, project string, q codegraph.ConventionQuery) ([]codegraph.Convention, error) {
+	f.queries = append(f.queries, q)
+	return slices.Clone(f.conventions), nil
+}
+
+func (f *fakeConventions) RemoveConvention(ctx context.Context, project, id string) (bool, error) {
+	n := len(f.conventions)
+	f.conventions = slices.DeleteFunc(f.conventions, func(c codegraph.Convention) bool { return c.ID == id })
+	return len(f.conventions) < n, nil
+}
+
+func TestServerConventions(t *testing.T) {
+	q := &recordingQuerier{}
+	conventions := &fakeConventions{}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeConventions
+	}{q, conventions}
+	handler := s.routes()
+
+	stored := `{"id":"con-1","category":"logging","rule":"log with slog","packages":["."],"createdAt":"2026-03-04T05:06:07Z"}`
+	tests := []struct {
+		method, target, request string
+		status                  int
+		body                    string
+	}{
+		{"POST", "/conventions", `{"category": "logging", "rule": "log with slog", "packages": ["."]}`, 201, stored},
+		{"POST", "/conventions", `{"rule": ""}`, 400, `{"error":"invalid convention: no rule or category"}`},
+		{"POST", "/conventions", `{"rule": "x", "packages": ["gone"]}`, 404, `{"error":"no such node \"Package:gone\""}`},
+		{"GET", "/conventions?about=Function:cmd/main.go:run&category=logging&namespace=user:alice", "", 200, "[" + stored + "]"},
+		{"GET", "/conventions?limit=x", "", 400, `{"error":"invalid limit \"x\""}`},
+		{"DELETE", "/conventions/con-2", "", 404, `{"error":"no such convention"}`},
+		{"DELETE", "/conventions/con-1", "", 204, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, jsonRequest(tt.method, tt.target, tt.request))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.status)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.body != "" && got != tt.body {
+			t.Errorf("%s %s =\n%s\nwant\n%s", tt.method, tt.target, got, tt.body)
+		}
+	}
+	want := []codegraph.ConventionQuery{{Package: "cmd", Category: "logging", Namespaces: []string{"user:alice"}, Limit: 100}}
+	if !reflect.DeepEqual(conventions.queries, want) {
+		t.Errorf("listed %+v, want %+v", conventions.queries, want)
+	}
+	if len(conventions.conventions) != 0 {
+		t.Errorf("conventions left: %+v", conventions.conventions)
+	}
+}
+
+func TestMCPConventionTools(t *testing.T) {
+	q := &recordingQuerier{}
+	conventions := &fakeConventions{}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	s.backend = struct {
+		*recordingQuerier
+		*fakeConventions
+	}{q, conventions}
+	call := func(tool string, args map[string]any) (any, error) {
+		return mcpTools[tool].Call(context.Background(), s, args)
+	}
+
+	got, err := call("record_convention", map[string]any{"rule": "name tests after the function", "category": "naming", "packages": []any{"."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convention := got.(codegraph.Convention); convention.ID != "con-1" || convention.Category != "naming" {
+		t.Errorf("recorded %+v", convention)
+	}
+	if _, err := call("record_convention", map[string]any{"rule": ""}); !errors.Is(err, codegraph.ErrInvalidConvention) {
+		t.Errorf("no rule: err = %v", err)
+	}
+
+	for _, args := range []map[string]any{{"file": "cmd/main.go"}, {"about": "Function:cmd/main.go:run"}, {"package": "cmd"}} {
+		if _, err := call("get_conventions", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, query := range conventions.queries {
+		if query.Package != "cmd" {
+			t.Errorf("query %d = %+v, want package cmd", i, query)
+		}
+	}
+}
+
+// fakeVectors answers every search with its results and nodes, recording
+// the queries, and the vectors and limits of the similar nodes asked for
+type fakeVectors struct {
+	results []codegraph.SearchResult
+	queries []codegraph.SearchQuery
+	nodes   []codegraph.SimilarNode
+	vectors [][]float64
+	limits  []int
+}
+
+func (f *fakeVectors) SimilarNodes(ctx context.Context, project string, vector []float64, limit int) ([]codegraph.SimilarNode, error) {
+	f.vectors = append(f.vectors, vector)
+	f.limits = append(f.limits, limit)
+	return f.nodes, nil
+}
+
+func (f *fakeVectors) Search(ctx context.Context, project string, q codegraph.SearchQuery) ([]codegraph.SearchResult, error) {
+	f.queries = append(f.queries, q)
+	return slices.Clone(f.results), nil
+}
+
+func TestServerSearch(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc run() {\n\tstart()\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	q := &recordingQuerier{}
+	vectors := &fakeVectors{results: []codegraph.SearchResult{{
+		Key: "Function:main.go:run", Kind: "Function", Name: "run", File: "main.go", Package: ".",
+		LineStart: 3, LineEnd: 5, Similarity: 0.9, Score: 0.9, Callers: []string{},
+	}}}
+	s := newTestServer(context.Background(), q, root)
+	handler := s.routes()
+
+	get := func(target string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+	if code, _ := get("/search?q=start"); code != http.StatusNotImplemented {
+		t.Errorf("without vector search: status = %d, want 501", code)
+	}
+
+	s.backend = struct {
+		*recordingQuerier
+		*fakeVectors
+	}{q, vectors}
+	if code, body := get("/search?q=start"); code != http.StatusNotImplemented || body != `{"error":"the server was started without --embed"}` {
+		t.Errorf("without --embed: %d %s", code, body)
+	}
+	cfg := s.targets["App"]
+	cfg.Embed = `exec:while read -r line; do echo '{"embeddings": [[1, 0]]}'; done`
+	s.targets["App"] = cfg
+	if code, _ := get("/search"); code != http.StatusBadRequest {
+		t.Errorf("without q: status = %d, want 400", code)
+	}
+	if code, _ := get("/search?q=start&callers=x"); code != http.StatusBadRequest {
+		t.Errorf("bad callers: status = %d, want 400", code)
+	}
+	code, body := get("/search?q=start&package=cmd&limit=3")
+	want := `[{"key":"Function:main.go:run","kind":"Function","name":"run","file":"main.go","package":".","lineStart":3,"lineEnd":5,` +
+		`"similarity":0.9,"score":0.9,"callerCount":0,"callers":[],"snippet":"func run() {\n\tstart()\n}"}]`
+	if code != http.StatusOK || body != want {
+		t.Errorf("search = %d %s, want %s", code, body, want)
+	}
+	if len(vectors.queries) != 1 {
+		t.Fatalf("queries = %+v", vectors.queries)
+	}
+	if got := vectors.queries[0]; !slices.Equal(got.Vector, []float64{1, 0}) || got.Package != "cmd" || got.Limit != 3 || got.Callers != 3 {
+		t.Errorf("searched with %+v", got)
+	}
+}
+
+func TestServerSimilar(t *testing.T) {
+	q := &recordingQuerier{}
+	vectors := &fakeVectors{nodes: []codegraph.SimilarNode{{Key: "Function:main.go:run", Kind: "Function", Name: "run", File: "main.go", Score: 0.9}}}
+	s := newTestServer(context.Background(), q, t.TempDir())
+	handler := s.routes()
+
+	get := func(target string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+	if code, _ := get("/similar?q=start"); code != http.StatusNotImplemented {
+		t.Errorf("without vector search: status = %d, want 501", code)
+	}
+
+	s.backend = struct {
+		*recordingQuerier
+		*fakeVectors
+	}{q, vectors}
+	if code, body := get("/similar?q=start"); code != http.StatusNotImplemented || body != `{"error":"the server was started without --embed"}` {
+		t.Errorf("without --embed: %d %s", code, body)
+	}
+	cfg := s.targets["App"]
+	cfg.Embed = `exec:while read -r line; do echo '{"embeddings": [[1, 0]]}'; done`
+	s.targets["App"] = cfg
+	if code, _ := get("/similar"); code != http.StatusBadRequest {
+		t.Errorf("without q: status = %d, want 400", code)
+	}
+	code, body := get("/similar?q=start&limit=3")
+	if want := `[{"key":"Function:main.go:run","kind":"Function","name":"run","file":"main.go","score":0.9}]`; code != http.StatusOK || body != want {
+		t.Errorf("similar = %d %s, want %s", code, body, want)
+	}
+	if len(vectors.vectors) != 1 || !slices.Equal(vectors.vectors[0], []float64{1, 0}) || vectors.limits[0] != 3 {
+		t.Errorf("searched for %v with limits %v", vectors.vectors, vectors.limits)
+	}
+}
+
+func TestOpenEmbedder(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OLLAMA_HOST", "gpu-box:11434")
+	tests := []struct {
+		embed string
+		want  codegraph.Embedder
+	}{
+		{"openai", &codegraph.OpenAIEmbedder{APIKey: "sk-test", Model: "text-embedding-3-small"}},
+		{"openai:text-embedding-3-large", &codegraph.OpenAIEmbedder{APIKey: "sk-test", Model: "text-embedding-3-large"}},
+		{"ollama", &codegraph.OllamaEmbedder{BaseURL: "http://gpu-box:11434", Model: "nomic-embed-text"}},
+		{"ollama:mxbai-embed-large", &codegraph.OllamaEmbedder{BaseURL: "http://gpu-box:11434", Model: "mxbai-embed-large"}},
+	}
+	for _, tt := range tests {
+		e, closeEmbedder, err := openEmbedder(context.Background(), Config{Embed: tt.embed})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.embed, err)
+		}
+		if fmt.Sprintf("%#v", e) != fmt.Sprintf("%#v", tt.want) {
+			t.Errorf("%s: embedder = %+v, want %+v", tt.embed, e, tt.want)
+		}
+		closeEmbedder()
+	}
+	for _, embed := range []string{"bert", "exec:"} {
+		if _, _, err := openEmbedder(context.Background(), Config{Embed: embed}); exitCode(err) != exitUsage {
+			t.Errorf("%s: err = %v, want a usage error", embed, err)
+		}
+	}
+}
+
+func TestPrintSearchResults(t *testing.T) {
+	var buf bytes.Buffer
+	printSearchResults(&buf, []codegraph.SearchResult{
+		{Key: "Function:store/store.go:*Store.Put", Kind: "Method", File: "store/store.go", Package: "store", LineStart: 5,
+			Score: 0.9312, CallerCount: 7, Callers: []string{"Function:main.go:main", "Function:main.go:run"},
+			Snippet: "func (s *Store) Put(key string) {\n\t..."},
+		{Key: "Struct:store/store.go:Store", Kind: "Struct", File: "store/store.go", Package: "store", Score: 0.8},
+	})
+	want := "0.931  Method  Function:store/store.go:*Store.Put\n" +
+		"  package store, store/store.go:5, 7 callers\n" +
+		"    func (s *Store) Put(key string) {\n" +
+		"    \t...\n" +
+		"  called by Function:main.go:main, Function:main.go:run\n" +
+		"\n" +
+		"0.800  Struct  Struct:store/store.go:Store\n" +
+		"  package store, store/store.go, 0 callers\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printSearchResults =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrintSimilar(t *testing.T) {
+	var buf bytes.Buffer
+	printSimilar(&buf, []codegraph.SimilarNode{
+		{Key: "Function:store/store.go:*Store.Put", Kind: "Method", Score: 0.9312},
+		{Key: "Struct:store/store.go:Store", Kind: "Struct", Score: 0.8},
+	})
+	want := "0.931  Method  Function:store/store.go:*Store.Put\n" +
+		"0.800  Struct  Struct:store/store.go:Store\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printSimilar =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestServerReindex(t *testing.T) {
+	q := &recordingQuerier{incrementalBackend: incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string)}}}
+	s := newTestServer(context.Background(), q, writeTree(t, testTree))
+	handler := s.routes()
+
+	post := func(target string) (int, indexStatus) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, jsonRequest("POST", target, ""))
+		var status indexStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, status
+	}
+
+	// The write blocks until it is received, so the first run is still going
+	// when the second is asked for
+	code, status := post("/reindex")
+	if code != http.StatusAccepted || !status.Running || status.RunID == "" {
+		t.Errorf("POST /reindex = %d %+v", code, status)
+	}
+	if code, again := post("/reindex"); code != http.StatusConflict || again.RunID != status.RunID {
+		t.Errorf("second POST /reindex = %d %+v, want conflict with run %s", code, again, status.RunID)
+	}
+	if code, _ := post("/reindex?project=Nope"); code != http.StatusNotFound {
+		t.Errorf("POST /reindex for unknown project = %d", code)
+	}
+	<-q.writes
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/projects", nil))
+		var projects []struct {
+			Name  string
+			Index indexStatus
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &projects); err != nil {
+			t.Fatal(err)
+		}
+		if len(projects) != 2 || projects[0].Name != "App" || projects[1].Index.RunID != "" {
+			t.Fatalf("GET /projects = %s", rec.Body)
+		}
+		if index := projects[0].Index; !index.Running {
+			if index.Error != "" || index.Files != 2 || index.Nodes == 0 || index.FinishedAt.IsZero() {
+				t.Errorf("finished index = %+v", index)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("re-index did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	q := &recordingQuerier{incrementalBackend: incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string, 1)}}}
+	s := newTestServer(context.Background(), q, writeTree(t, testTree))
+	if _, err := s.index(context.Background(), s.targets["App"], codegraph.NewRunInfo(), nil); err != nil {
+		t.Fatal(err)
+	}
+	broken := s.targets["App"]
+	broken.Path = filepath.Join(broken.Path, "missing")
+	if _, err := s.index(context.Background(), broken, codegraph.NewRunInfo(), nil); err == nil {
+		t.Fatal("indexed a missing path")
+	}
+	s.metrics.observe("Other", time.Second, 0, codegraph.WriteStats{Statements: 4, Batches: 3, Retries: 1}, 0, errors.New("boom"))
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", rec.Code)
+	}
+	for _, want := range []string{
+		`codegraph_index_runs_total{project="App",result="ok"} 1`,
+		`codegraph_index_runs_total{project="App",result="error"} 1`,
+		`codegraph_index_runs_total{project="Other",result="error"} 1`,
+		`codegraph_index_runs_total{project="Other",result="ok"} 0`,
+		`codegraph_parse_duration_seconds_count{project="App"} 2`,
+		`codegraph_write_duration_seconds_count{project="App"} 1`,
+		`codegraph_write_statements_total{project="Other"} 4`,
+		`codegraph_write_batches_total{project="Other"} 3`,
+		`codegraph_write_retries_total{project="Other"} 1`,
+		`codegraph_nodes{project="App"} 11`,
+		`codegraph_staleness_seconds{project="Other"} `,
+		"go_goroutines ",
+	} {
+		if !strings.Contains(rec.Body.String(), "\n"+want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+	if strings.Contains(rec.Body.String(), `codegraph_nodes{project="Other"}`) {
+		t.Error("failed re-index set the node count")
+	}
+}
+
+func TestFilesUnder(t *testing.T) {
+	root := writeTree(t, testTree)
+	cfg := Config{Project: "App", Path: root}
+	tests := []struct {
+		path string
+		want []string
+		err  bool
+	}{
+		{"store", []string{filepath.Join("store", "store.go")}, false},
+		{"main.go", []string{"main.go"}, false},
+		{filepath.Join(root, "main.go"), []string{"main.go"}, false},
+		{".", []string{"main.go", filepath.Join("store", "store.go")}, false},
+		{"vendor", []string{}, false},
+		{"gone.go", []string{"gone.go"}, false},
+		{"..", nil, true},
+		{filepath.Join("..", "other"), nil, true},
+	}
+	for _, tt := range tests {
+		got, err := filesUnder(cfg, tt.path)
+		if (err != nil) != tt.err || !slices.Equal(got, tt.want) {
+			t.Errorf("filesUnder(%q) = %q, %v, want %q, error %v", tt.path, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestServeMCPStdio(t *testing.T) {
+	putProps := map[string]any{"name": "Put", "file": "store/store.go", "receiver": "*Store"}
+	q := (&recordingQuerier{}).
+		answering([]string{"labels", "props"}, [][]any{{[]any{"App", "Method"}, putProps}}, "toLower(n.name) CONTAINS").
+		answering([]string{"caller"}, [][]any{{"main"}}, "caller.name AS caller")
+	s := newTestServer(context.Background(), q, t.TempDir())
+
+	requests := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"claude-code"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		``,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"search_code_graph","arguments":{"query":"put","limit":5}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"get_callers","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"get_callers","arguments":{"name":"Put","project":"Other"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"delete_everything"}}`,
+		`{"jsonrpc":"2.0","id":"seven","method":"resources/list"}`,
+		`{not json`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := serveMCPStdio(context.Background(), s, strings.NewReader(requests), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var replies []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var reply map[string]any
+		if err := json.Unmarshal([]byte(line), &reply); err != nil {
+			t.Fatalf("reply %q: %v", line, err)
+		}
+		replies = append(replies, reply)
+	}
+	if len(replies) != 8 {
+		t.Fatalf("%d replies, want 8:\n%s", len(replies), out.String())
+	}
+	if s.mcpClient != "claude-code" {
+		t.Errorf("client = %q, want claude-code", s.mcpClient)
+	}
+	field := func(v any, path ...any) any {
+		for _, p := range path {
+			switch p := p.(type) {
+			case string:
+				m, _ := v.(map[string]any)
+				v = m[p]
+			case int:
+				l, _ := v.([]any)
+				if p >= len(l) {
+					return nil
+				}
+				v = l[p]
+			}
+		}
+		return v
+	}
+	text := func(reply map[string]any) string {
+		s, _ := field(reply, "result", "content", 0, "text").(string)
+		return s
+	}
+
+	if v := field(replies[0], "result", "protocolVersion"); v != "2025-03-26" {
+		t.Errorf("negotiated protocol version %v", v)
+	}
+	if tools, _ := field(replies[1], "result", "tools").([]any); len(tools) != len(mcpTools) || field(tools, 0, "name") != "forget" {
+		t.Errorf("tools/list = %v", replies[1])
+	}
+	if got := text(replies[2]); !strings.Contains(got, `"key": "Function:store/store.go:*Store.Put"`) {
+		t.Errorf("search_code_graph = %s", got)
+	}
+	if field(replies[3], "result", "isError") != true || text(replies[3]) != "missing name" {
+		t.Errorf("get_callers without name = %v", replies[3])
+	}
+	if got := text(replies[4]); !strings.Contains(got, `"caller": "main"`) {
+		t.Errorf("get_callers = %s", got)
+	}
+	if code := field(replies[5], "error", "code"); code != float64(-32602) {
+		t.Errorf("unknown tool = %v", replies[5])
+	}
+	if field(replies[6], "id") != "seven" || field(replies[6], "error", "code") != float64(-32601) {
+		t.Errorf("unknown method = %v", replies[6])
+	}
+	if code := field(replies[7], "error", "code"); code != float64(-32700) {
+		t.Errorf("invalid JSON = %v", replies[7])
+	}
+	if len(q.params) < 2 || q.params[0]["limit"] != 5 || q.params[1]["name"] != "Put" {
+		t.Errorf("query params = %v", q.params)
+	}
+	if !strings.Contains(q.queries[1], "(caller:Other)") {
+		t.Errorf("get_callers ran against the wrong project:\n%s", q.queries[1])
+	}
+}
+
+func TestServeMCPStdioReindex(t *testing.T) {
+	q := &recordingQuerier{incrementalBackend: incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string, 1)}}}
+	s := newTestServer(context.Background(), q, writeTree(t, testTree))
+	var out bytes.Buffer
+	request := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"reindex_path","arguments":{}}}`
+	if err := serveMCPStdio(context.Background(), s, strings.NewReader(request), &out); err != nil {
+		t.Fatal(err)
+	}
+	if files := <-q.writes; files != nil {
+		t.Errorf("reindex_path without a path wrote files %q, want a full write", files)
+	}
+	if !strings.Contains(out.String(), `\"files\": 2`) {
+		t.Errorf("reindex_path = %s", out.String())
+	}
+}
+
+func TestServeMCPSSE(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &recordingQuerier{}
+	done := make(chan error, 1)
+	go func() { done <- serveMCPSSE(ctx, newTestServer(ctx, q, t.TempDir()), addr) }()
+	client := &http.Client{}
+	defer func() {
+		client.CloseIdleConnections()
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get("http://" + addr + "/sse"); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	event := func() (string, string) {
+		t.Helper()
+		var name, data string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return name, data
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+
+	name, endpoint := event()
+	if name != "endpoint" || !strings.HasPrefix(endpoint, "/message?sessionId=") {
+		t.Fatalf("first event %s %q", name, endpoint)
+	}
+	post, err := client.Post("http://"+addr+endpoint, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusAccepted {
+		t.Errorf("POST %s = %s", endpoint, post.Status)
+	}
+	if name, data := event(); name != "message" || data != `{"jsonrpc":"2.0","id":1,"result":{}}` {
+		t.Errorf("reply event %s %s", name, data)
+	}
+
+	post, err = client.Post("http://"+addr+"/message?sessionId=nope", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusNotFound {
+		t.Errorf("POST to unknown session = %s", post.Status)
+	}
+
+	// A page elsewhere cannot reach the server through a rebound name
+	req, err := http.NewRequest("POST", "http://"+addr+endpoint, strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://attacker.example")
+	post, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin POST = %s", post.Status)
+	}
+}
+
+func TestLocalOrigin(t *testing.T) {
+	for origin, want := range map[string]bool{
+		"":                          true,
+		"http://localhost:6274":     true,
+		"http://127.0.0.1:8080":     true,
+		"http://[::1]":              true,
+		"http://attacker.example":   false,
+		"http://localhost.evil.com": false,
+		"http://192.168.1.5":        false,
+		"::":                        false,
+	} {
+		r := httptest.NewRequest("GET", "/sse", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := localOrigin(r); got != want {
+			t.Errorf("localOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+// rawCodec sends and receives gRPC messages as already encoded bytes, so
+// tests can talk to the hand-encoded service without generated code
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v any) ([]byte, error) { return *v.(*[]byte), nil }
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*v.(*[]byte) = slices.Clone(data)
+	return nil
+}
+
+// wireFields decodes a message into its fields by number, with varints
+// formatted in decimal and embedded messages left encoded
+func wireFields(t *testing.T, b []byte) map[protowire.Number][]string {
+	t.Helper()
+	fields := make(map[protowire.Number][]string)
+	err := consumeFields(b, func(num protowire.Number, v uint64, data []byte) {
+		if data == nil {
+			fields[num] = append(fields[num], strconv.FormatUint(v, 10))
+		} else {
+			fields[num] = append(fields[num], string(data))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestWireMessages(t *testing.T) {
+	var b []byte
+	b = appendString(b, 1, "App")
+	b = appendString(b, 2, "store")
+	b = appendString(b, 2, "main.go")
+	b = appendInt(b, 9, 7) // unknown fields are skipped
+	var start startIndexRequest
+	if err := start.decodeWire(b); err != nil || start.Project != "App" || !slices.Equal(start.Paths, []string{"store", "main.go"}) {
+		t.Errorf("startIndexRequest = %+v, %v", start, err)
+	}
+
+	entry := func(key, value string) []byte {
+		var e []byte
+		e = appendString(e, 1, key)
+		return appendString(e, 2, value)
+	}
+	b = nil
+	b = appendString(b, 3, "impact")
+	b = appendString(b, 4, "main.go")
+	b = appendInt(b, 5, 2)
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendBytes(b, entry("n", "10"))
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendBytes(b, entry("name", "Put"))
+	b = protowire.AppendTag(b, 10, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 1)
+	var query grpcQueryRequest
+	if err := query.decodeWire(b); err != nil || query.Named != "impact" || query.Name != "main.go" || query.Depth != 2 ||
+		fmt.Sprint(query.Params) != "map[n:10 name:Put]" {
+		t.Errorf("grpcQueryRequest = %+v, %v", query, err)
+	}
+	if err := query.decodeWire([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("truncated message decoded")
+	}
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	status := grpcIndexStatus{Project: "App", indexStatus: indexStatus{Running: true, RunID: "run-1", Step: "writing", Done: 3, Total: 9, StartedAt: started, Commit: "abc123"}}
+	fields := wireFields(t, status.appendWire(nil))
+	want := map[protowire.Number][]string{1: {"App"}, 2: {"run-1"}, 3: {"1"}, 4: {"writing"}, 5: {"3"}, 6: {"9"}, 13: {"abc123"}}
+	for num, values := range want {
+		if !slices.Equal(fields[num], values) {
+			t.Errorf("IndexStatus field %d = %q, want %q", num, fields[num], values)
+		}
+	}
+	if ts := wireFields(t, []byte(fields[8][0])); ts[1][0] != strconv.FormatInt(started.Unix(), 10) || ts[2][0] != "6" {
+		t.Errorf("started_at = %v", ts)
+	}
+	if _, ok := fields[9]; ok {
+		t.Error("zero finished_at encoded")
+	}
+
+	resp := grpcQueryResponse{Columns: []string{"name", "line"}, Rows: [][]string{{`"main"`, "7"}}}
+	fields = wireFields(t, resp.appendWire(nil))
+	if !slices.Equal(fields[1], []string{"name", "line"}) || len(fields[2]) != 1 || !slices.Equal(wireFields(t, []byte(fields[2][0]))[1], []string{`"main"`, "7"}) {
+		t.Errorf("QueryResponse fields = %q", fields)
+	}
+
+	diff := grpcGraphDiff{GraphDiff{Functions: SymbolDiff{Added: []string{"a"}, Changed: []ChangedSymbol{{Key: "b", Before: "x", After: "y"}}}}}
+	fields = wireFields(t, diff.appendWire(nil))
+	functions := wireFields(t, []byte(fields[1][0]))
+	if !slices.Equal(functions[1], []string{"a"}) || fmt.Sprint(wireFields(t, []byte(functions[3][0]))) != "map[1:[b] 2:[x] 3:[y]]" {
+		t.Errorf("GraphDiff functions = %q", functions)
+	}
+	if len(fields[4]) != 1 || fields[4][0] != "" {
+		t.Errorf("empty relationships diff = %q", fields[4])
+	}
+}
+
+// startTestGRPC serves g on a local port and returns a client connection
+func startTestGRPC(t *testing.T, g *grpcServer) *grpc.ClientConn {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(wireCodec{}))
+	srv.RegisterService(&codeGraphServiceDesc, g)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCServer(t *testing.T) {
+	ctx := context.Background()
+	q := (&recordingQuerier{}).answering([]string{"caller", "line"}, [][]any{{"main", int64(7)}}, "caller.name AS caller")
+	q.writes = make(chan []string, 1)
+	root := writeTree(t, testTree)
+	g := &grpcServer{server: newTestServer(ctx, q, root)}
+	conn := startTestGRPC(t, g)
+
+	call := func(method string, req []byte) ([]byte, error) {
+		var resp []byte
+		err := conn.Invoke(ctx, "/codegraph.v1.CodeGraph/"+method, &req, &resp)
+		return resp, err
+	}
+	message := func(fields ...any) []byte {
+		var b []byte
+		for i := 0; i < len(fields); i += 2 {
+			switch v := fields[i+1].(type) {
+			case string:
+				b = appendString(b, protowire.Number(fields[i].(int)), v)
+			case int:
+				b = appendInt(b, protowire.Number(fields[i].(int)), int64(v))
+			}
+		}
+		return b
+	}
+
+	tests := []struct {
+		method string
+		req    []byte
+		code   codes.Code
+	}{
+		{"Query", message(1, "Nope", 2, "RETURN 1"), codes.NotFound},
+		{"Query", message(2, " "), codes.InvalidArgument},
+		{"Query", message(3, "siblings"), codes.InvalidArgument},
+		{"Query", message(3, "callers"), codes.InvalidArgument},
+		{"GetSnapshotDiff", message(1, "App"), codes.FailedPrecondition},
+		{"StartIndex", message(2, ".."), codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		if _, err := call(tt.method, tt.req); status.Code(err) != tt.code {
+			t.Errorf("%s(%q) error = %v, want %s", tt.method, tt.req, err, tt.code)
+		}
+	}
+
+	resp, err := call("Query", message(3, "callers", 4, "Put", 5, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := wireFields(t, resp)
+	if !slices.Equal(fields[1], []string{"caller", "line"}) || !slices.Equal(wireFields(t, []byte(fields[2][0]))[1], []string{`"main"`, "7"}) {
+		t.Errorf("Query callers = %q", fields)
+	}
+	if last := q.params[len(q.params)-1]; last["name"] != "Put" {
+		t.Errorf("callers params = %v", last)
+	}
+
+	base := writeTree(t, map[string]string{"main.go": "package main\n\nfunc main() {}\n\nfunc old() {}\n"})
+	resp, err = call("GetSnapshotDiff", message(2, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions := wireFields(t, []byte(wireFields(t, resp)[1][0]))
+	if !slices.Contains(functions[2], "Function:main.go:old") || !slices.Contains(functions[1], "Function:store/store.go:New") {
+		t.Errorf("GetSnapshotDiff functions = %q", functions)
+	}
+
+	resp, err = call("StartIndex", message(2, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := wireFields(t, resp); fields[1][0] != "App" || fields[3][0] != "1" {
+		t.Errorf("StartIndex = %q", fields)
+	}
+	if files := <-q.writes; !slices.Equal(files, []string{filepath.Join("store", "store.go")}) {
+		t.Errorf("StartIndex wrote %q", files)
+	}
+
+	// Once the run has finished the stream sends the final status and ends
+	for deadline := time.Now().Add(5 * time.Second); g.status("App").Running; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("index did not finish")
+		}
+	}
+	stream, err := conn.NewStream(ctx, &codeGraphServiceDesc.Streams[0], "/codegraph.v1.CodeGraph/StreamProgress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := message(1, "App")
+	if err := stream.SendMsg(&req); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	var statuses []map[protowire.Number][]string
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, wireFields(t, msg))
+	}
+	if len(statuses) != 1 || statuses[0][3] != nil || statuses[0][10][0] != "2" || statuses[0][9] == nil {
+		t.Errorf("StreamProgress sent %q", statuses)
+	}
+}
+
+func TestServerStatusProgress(t *testing.T) {
+	s := newTestServer(context.Background(), &recordingQuerier{}, t.TempDir())
+	p := startProgress("writing", "rows", 10)
+	defer p.Finish()
+	p.Add(4)
+
+	if status := s.status("App"); status.Step != "" {
+		t.Errorf("idle project has step %q", status.Step)
+	}
+	s.indexing = "App"
+	if status := s.status("App"); status.Step != "writing" || status.Done != 4 || status.Total != 10 || status.Unit != "rows" {
+		t.Errorf("status while indexing = %+v", status)
+	}
+	if status := s.status("Other"); status.Step != "" {
+		t.Errorf("other project has step %q", status.Step)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var set uint64
+		for _, v := range values {
+			set |= 1 << v
+		}
+		return set
+	}
+	tests := []struct {
+		spec string
+		want *cronSchedule
+		err  bool
+	}{
+		{"*/15 9-17 * * 1-5", &cronSchedule{minute: bits(0, 15, 30, 45), hour: bits(9, 10, 11, 12, 13, 14, 15, 16, 17),
+			dom:   bits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31),
+			month: bits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12), dow: bits(1, 2, 3, 4, 5), domAny: true}, false},
+		{"@daily", &cronSchedule{minute: bits(0), hour: bits(0),
+			dom:   bits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31),
+			month: bits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12), dow: bits(0, 1, 2, 3, 4, 5, 6, 7), domAny: true, dowAny: true}, false},
+		{"5,10 0 1 1,7 7", &cronSchedule{minute: bits(5, 10), hour: bits(0), dom: bits(1), month: bits(1, 7), dow: bits(0, 7)}, false},
+		{"30/10 * * * *", nil, false},
+		{"* * * *", nil, true},
+		{"60 * * * *", nil, true},
+		{"* 24 * * *", nil, true},
+		{"* * 0 * *", nil, true},
+		{"* * * 13 *", nil, true},
+		{"* * * * 8", nil, true},
+		{"5-1 * * * *", nil, true},
+		{"*/0 * * * *", nil, true},
+		{"a * * * *", nil, true},
+		{"* * * JAN *", nil, true},
+		{"@reboot", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCron(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("parseCron(%q) error = %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if tt.want != nil && *got != *tt.want {
+			t.Errorf("parseCron(%q) = %+v, want %+v", tt.spec, *got, *tt.want)
+		}
+	}
+	if got, _ := parseCron("30/10 * * * *"); got.minute != bits(30, 40, 50) {
+		t.Errorf("30/10 minutes = %b", got.minute)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-01 was a Monday
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		spec, from, want string
+	}{
+		{"* * * * *", "2024-01-01 10:00", "2024-01-01 10:01"},
+		{"@hourly", "2024-01-01 10:00", "2024-01-01 11:00"},
+		{"@daily", "2024-01-01 10:00", "2024-01-02 00:00"},
+		{"*/15 9-17 * * 1-5", "2024-01-05 17:50", "2024-01-08 09:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 1 * 0", "2024-01-01 13:00", "2024-01-07 12:00"},
+		{"0 0 31 * *", "2024-04-01 00:00", "2024-05-31 00:00"},
+		{"0 0 * * 7", "2024-01-01 00:00", "2024-01-07 00:00"},
+		{"0 0 30 2 *", "2024-01-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := c.next(at(tt.from))
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%q after %s = %s, want never", tt.spec, tt.from, got)
+			}
+			continue
+		}
+		if !ok || !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s, %v, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04"), ok, tt.want)
+		}
+	}
+}
+
+func TestRunDaemonFlags(t *testing.T) {
+	tests := []struct {
+		cfg Config
+		err string
+	}{
+		{Config{Backend: "memory", Schedule: "@hourly"}, "daemon needs --backend neo4j or falkordb"},
+		{Config{Backend: "falkordb"}, "daemon needs --schedule or --poll"},
+		{Config{Backend: "falkordb", Schedule: "@often"}, `--schedule: "@often": expected 5 fields (minute hour day-of-month month day-of-week), got 1`},
+	}
+	for _, tt := range tests {
+		if err := runDaemon(context.Background(), tt.cfg, nil); err == nil || err.Error() != tt.err {
+			t.Errorf("runDaemon(%+v) error = %v, want %s", tt.cfg, err, tt.err)
+		}
+	}
+}
+
+func TestKeepIndexed(t *testing.T) {
+	root := gitRepo(t, testTree)
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &recordingQuerier{incrementalBackend: incrementalBackend{recordingBackend: recordingBackend{writes: make(chan []string, 10)}}}
+	s := newTestServer(ctx, q, root)
+	s.projects = s.projects[:1]
+	s.daemon = &daemonState{poll: 20 * time.Millisecond, heads: make(map[string]string)}
+	done := make(chan struct{})
+	go func() {
+		s.keepIndexed(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case files := <-q.writes:
+			if files != nil {
+				t.Errorf("%s wrote files %q, want a full write", what, files)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no write %s", what)
+		}
+	}
+	wait("at startup")
+	first := codegraph.ResolveCommit(ctx, root, "")
+	if schedule := s.schedule("App"); schedule.Head != first || schedule.Poll != "20ms" || schedule.Schedule != "" {
+		t.Errorf("schedule after startup = %+v", schedule)
+	}
+
+	// Polling without a new commit indexes nothing
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-q.writes:
+		t.Error("re-indexed without a new commit")
+	default:
+	}
+
+	os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644)
+	git(t, root, "commit", "-q", "-am", "second")
+	wait("after a commit")
+	for deadline := time.Now().Add(5 * time.Second); s.status("App").Running || s.status("App").Commit == first; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("status after commit = %+v", s.status("App"))
+		}
+	}
+	if schedule := s.schedule("App"); schedule.Head == first || schedule.LastPolled.IsZero() {
+		t.Errorf("schedule after commit = %+v", schedule)
+	}
+}
+
+func TestServerSchedule(t *testing.T) {
+	s := newTestServer(context.Background(), &recordingQuerier{}, t.TempDir())
+	if s.schedule("App") != nil {
+		t.Error("schedule outside the daemon")
+	}
+	schedule, _ := parseCron("@hourly")
+	next := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	s.daemon = &daemonState{schedule: schedule, spec: "@hourly", nextRun: next, heads: map[string]string{"App": "abc123"}}
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("GET", "/projects", nil))
+	want := `"schedule":{"schedule":"@hourly","nextRun":"2024-01-01T11:00:00Z","head":"abc123"}`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("GET /projects = %s, want it to contain %s", rec.Body, want)
+	}
+}
@@ -34,7 +34,7 @@ echo ""
 echo "Removing scripts..."
 rm -f "$CLAUDE_DIR/scripts/neo4j-context.sh"
 rm -f "$CLAUDE_DIR/scripts/populate-doc-graph.py"
-rm -f "$CLAUDE_DIR/scripts/populate-code-graph.go"
+rm -f "$CLAUDE_DIR/scripts/populate-code-graph" "$CLAUDE_DIR/scripts/populate-code-graph.go"
 rm -f "$HOME/.local/bin/claude-graph"
 echo "  ✓ Scripts removed"
 